@@ -26,10 +26,23 @@ import (
 
 // Consenter is used to receive messages from the network
 // Every consensus plugin needs to implement this interface
+// RecvMsg must be safe for concurrent use: it is called from the handler of
+// every peer, and for the transactions this validator introduces from the
+// fair scheduler of the peer (see peer.fairness) when it is enabled
 type Consenter interface {
 	RecvMsg(msg *pb.Message, senderHandle *pb.PeerID) error
 }
 
+// Plugin is a consensus implementation registered with the controller, it is
+// selected by name with peer.validator.consensus
+type Plugin struct {
+	// New returns the Consenter of the plugin running on top of stack
+	New func(stack Stack) Consenter
+	// ValidatorsOnly restricts the CONSENSUS messages the plugin broadcasts
+	// to the validating peers, non-validators never take part in consensus
+	ValidatorsOnly bool
+}
+
 // Inquirer is used to retrieve info about the validating network
 type Inquirer interface {
 	GetNetworkInfo() (self *pb.PeerEndpoint, network []*pb.PeerEndpoint, err error)
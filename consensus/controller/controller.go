@@ -20,30 +20,69 @@ under the License.
 package controller
 
 import (
+	"fmt"
+	"strings"
+	"sync"
+
 	"github.com/op/go-logging"
 	"github.com/spf13/viper"
-	"strings"
 
 	"github.com/hyperledger/fabric/consensus"
 	"github.com/hyperledger/fabric/consensus/noops"
 	"github.com/hyperledger/fabric/consensus/obcpbft"
 )
 
+// The plugin used when peer.validator.consensus names no registered plugin
+const defaultPlugin = "noops"
+
 var logger *logging.Logger // package-level logger
 
+var plugins = struct {
+	sync.RWMutex
+	m map[string]consensus.Plugin
+}{m: make(map[string]consensus.Plugin)}
+
 func init() {
 	logger = logging.MustGetLogger("consensus/controller")
+	RegisterPlugin("noops", consensus.Plugin{New: noops.GetNoops})
+	RegisterPlugin("pbft", consensus.Plugin{New: obcpbft.GetPlugin, ValidatorsOnly: true})
+}
+
+// RegisterPlugin makes a consensus plugin available under name, which is
+// case-insensitive. Plugins outside this package register from the init
+// function of their package, linked into the peer with a blank import.
+// Registering the same name twice panics.
+func RegisterPlugin(name string, plugin consensus.Plugin) {
+	if plugin.New == nil {
+		panic(fmt.Sprintf("consensus plugin %s has no constructor", name))
+	}
+	name = strings.ToLower(name)
+	plugins.Lock()
+	defer plugins.Unlock()
+	if _, dup := plugins.m[name]; dup {
+		panic(fmt.Sprintf("consensus plugin %s registered twice", name))
+	}
+	plugins.m[name] = plugin
+}
+
+// SelectedPlugin returns the name and the plugin configured with
+// peer.validator.consensus, noops if it names no registered plugin
+func SelectedPlugin() (string, consensus.Plugin) {
+	name := strings.ToLower(viper.GetString("peer.validator.consensus"))
+	plugins.RLock()
+	defer plugins.RUnlock()
+	if plugin, ok := plugins.m[name]; ok {
+		return name, plugin
+	}
+	if name != "" {
+		logger.Warning("Unknown consensus plugin %s, using %s", name, defaultPlugin)
+	}
+	return defaultPlugin, plugins.m[defaultPlugin]
 }
 
 // NewConsenter constructs a Consenter object
 func NewConsenter(stack consensus.Stack) (consenter consensus.Consenter) {
-	plugin := strings.ToLower(viper.GetString("peer.validator.consensus"))
-	if plugin == "pbft" {
-		//logger.Info("Running with consensus plugin %s", plugin)
-		consenter = obcpbft.GetPlugin(stack)
-	} else {
-		//logger.Info("Running with default consensus plugin (noops)")
-		consenter = noops.GetNoops(stack)
-	}
-	return
+	name, plugin := SelectedPlugin()
+	logger.Debug("Running with consensus plugin %s", name)
+	return plugin.New(stack)
 }
@@ -28,6 +28,7 @@ import (
 	"github.com/hyperledger/fabric/consensus"
 	"github.com/hyperledger/fabric/consensus/controller"
 	"github.com/hyperledger/fabric/core/peer"
+	"github.com/hyperledger/fabric/core/tracing"
 
 	pb "github.com/hyperledger/fabric/protos"
 )
@@ -69,6 +70,17 @@ func NewConsensusHandler(coord peer.MessageHandlerCoordinator,
 
 // HandleMessage handles the incoming Fabric messages for the Peer
 func (handler *ConsensusHandler) HandleMessage(msg *pb.Message) error {
+	if msg.Type == pb.Message_CONSENSUS || msg.Type == pb.Message_CHAIN_TRANSACTION {
+		// The peer handler authorizes the messages passed on to it
+		senderPE, _ := handler.peerHandler.To()
+		from := &senderPE
+		if peerHandler, ok := handler.peerHandler.(*peer.Handler); ok {
+			from = peerHandler.AuthorizedEndpoint()
+		}
+		if err := peer.AuthorizeMessage(from, msg); err != nil {
+			return err
+		}
+	}
 	if msg.Type == pb.Message_CONSENSUS {
 		senderPE, _ := handler.peerHandler.To()
 		return handler.consenter.RecvMsg(msg, senderPE.ID)
@@ -77,7 +89,7 @@ func (handler *ConsensusHandler) HandleMessage(msg *pb.Message) error {
 		err := proto.Unmarshal(msg.Payload, tx)
 		if err == nil {
 			if tx.Type == pb.Transaction_CHAINCODE_QUERY {
-				return handler.doChainQuery(tx)
+				return handler.doChainQuery(msg, tx)
 			} else {
 				return handler.doChainTransaction(msg,tx)
 			}
@@ -90,7 +102,10 @@ func (handler *ConsensusHandler) HandleMessage(msg *pb.Message) error {
 	return handler.peerHandler.HandleMessage(msg)
 }
 
-func (handler *ConsensusHandler) doChainTransaction(msg *pb.Message, tx *pb.Transaction) error {
+func (handler *ConsensusHandler) doChainTransaction(msg *pb.Message, tx *pb.Transaction) (err error) {
+	span := tracing.StartSpan("receive "+msg.Type.String(), msg.TraceContext, tx.Uuid)
+	defer func() { span.Finish(err) }()
+
 	var response *pb.Response
 	// Verify transaction signature if security is enabled
 	secHelper := handler.coordinator.GetSecHelper()
@@ -98,33 +113,65 @@ func (handler *ConsensusHandler) doChainTransaction(msg *pb.Message, tx *pb.Tran
 		if logger.IsEnabledFor(logging.DEBUG) {
 			logger.Debug("Verifying transaction signature %s", tx.Uuid)
 		}
-		var err error
 		if tx,err = secHelper.TransactionPreValidation(tx); nil != err {
 			response = &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(err.Error())}
 			logger.Debug("Failed to verify transaction %v", err)
 		}
 	}
+	// Transactions pooled already were forwarded to the plugin before
+	duplicate := false
+	if nil == response {
+		added, err := handler.coordinator.PoolTransaction(tx)
+		if err != nil {
+			response = &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(err.Error())}
+		}
+		duplicate = err == nil && !added
+	}
+	// The fair scheduler forwards the transaction to the plugin on the turn
+	// of its organization, from its own goroutine: RecvMsg is then called
+	// concurrently with the handlers of the other peers (see Consenter)
+	queued := false
+	selfPE, _ := handler.coordinator.GetPeerEndpoint() // we are the validator introducting this tx into the system
+	if nil == response && !duplicate {
+		var queueErr error
+		queued, queueErr = handler.coordinator.QueueTransaction(tx, func() {
+			if err := handler.consenter.RecvMsg(msg, selfPE.ID); err != nil {
+				logger.Error(fmt.Sprintf("Error forwarding transaction %s to the consensus plugin: %s", tx.Uuid, err))
+			}
+		})
+		if queueErr != nil {
+			response = &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(queueErr.Error())}
+		}
+	}
 	// Send response back to the requester
 	// response will not be nil on error
 	if nil == response {
 		response = &pb.Response{Status: pb.Response_SUCCESS, Msg: []byte(tx.Uuid)}
 	}
 	payload, _ := proto.Marshal(response)
-	handler.SendMessage(&pb.Message{Type: pb.Message_RESPONSE, Payload: payload})
+	handler.SendMessage(&pb.Message{Type: pb.Message_RESPONSE, Payload: payload, Channel: msg.Channel})
 
 	// If we fail to marshal or verify the tx, don't send it to consensus plugin
 	if response.Status == pb.Response_FAILURE {
 		return nil
 	}
+	if duplicate {
+		logger.Debug("Transaction %s was submitted already, not sending it to the consensus plugin", tx.Uuid)
+		return nil
+	}
+	if queued {
+		return nil
+	}
 
 	// Pass the message to the plugin handler (ie PBFT)
-	selfPE, _ := handler.coordinator.GetPeerEndpoint() // we are the validator introducting this tx into the system
 	return handler.consenter.RecvMsg(msg, selfPE.ID)
 }
 
-func (handler *ConsensusHandler) doChainQuery(tx *pb.Transaction) error {
+func (handler *ConsensusHandler) doChainQuery(msg *pb.Message, tx *pb.Transaction) error {
+	span := tracing.StartSpan("receive "+msg.Type.String(), msg.TraceContext, tx.Uuid)
 	var response *pb.Response
 	var err error
+	defer func() { span.Finish(err) }()
 	// Verify transaction signature if security is enabled
 	secHelper := handler.coordinator.GetSecHelper()
 	if nil != secHelper {
@@ -141,8 +188,9 @@ func (handler *ConsensusHandler) doChainQuery(tx *pb.Transaction) error {
 	if nil == response {
 		// The secHelper is set during creat ChaincodeSupport, so we don't need this step
 		// cxt := context.WithValue(context.Background(), "security", secHelper)
-		cxt := context.Background()
-		result, err := chaincode.Execute(cxt, chaincode.GetChain(chaincode.DefaultChain), tx)
+		cxt := tracing.ContextWithSpan(context.Background(), span)
+		var result []byte
+		result, err = chaincode.Execute(cxt, chaincode.GetChain(chaincode.DefaultChain), tx)
 		if err != nil {
 			response = &pb.Response{Status: pb.Response_FAILURE,
 				Msg: []byte(fmt.Sprintf("Error:%s", err))}
@@ -151,7 +199,7 @@ func (handler *ConsensusHandler) doChainQuery(tx *pb.Transaction) error {
 		}
 	}
 	payload, _ := proto.Marshal(response)
-	handler.SendMessage(&pb.Message{Type: pb.Message_RESPONSE, Payload: payload})
+	handler.SendMessage(&pb.Message{Type: pb.Message_RESPONSE, Payload: payload, Channel: msg.Channel})
 	return nil
 }
 
@@ -22,31 +22,37 @@ package helper
 import (
 	"fmt"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/spf13/viper"
 	"golang.org/x/net/context"
 
 	"github.com/hyperledger/fabric/core/chaincode"
 	"github.com/hyperledger/fabric/consensus"
+	"github.com/hyperledger/fabric/consensus/controller"
 	crypto "github.com/hyperledger/fabric/core/crypto"
 	"github.com/hyperledger/fabric/core/ledger"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
 	"github.com/hyperledger/fabric/core/peer"
+	"github.com/hyperledger/fabric/core/util"
 	pb "github.com/hyperledger/fabric/protos"
 )
 
 // Helper contains the reference to the peer's MessageHandlerCoordinator
 type Helper struct {
-	coordinator peer.MessageHandlerCoordinator
-	secOn       bool
-	secHelper   crypto.Peer
-	curBatch    []*pb.Transaction // TODO, remove after issue 579
+	coordinator    peer.MessageHandlerCoordinator
+	secOn          bool
+	secHelper      crypto.Peer
+	validatorsOnly bool
+	curBatch       []*pb.Transaction // TODO, remove after issue 579
 }
 
 // NewHelper constructs the consensus helper object
 func NewHelper(mhc peer.MessageHandlerCoordinator) consensus.Stack {
+	_, plugin := controller.SelectedPlugin()
 	return &Helper{coordinator: mhc,
-		secOn:     viper.GetBool("security.enabled"),
-		secHelper: mhc.GetSecHelper()}
+		secOn:          viper.GetBool("security.enabled"),
+		secHelper:      mhc.GetSecHelper(),
+		validatorsOnly: plugin.ValidatorsOnly}
 }
 
 // GetNetworkInfo returns the PeerEndpoints of the current validator and the entire validating network
@@ -89,8 +95,12 @@ func (h *Helper) GetNetworkHandles() (self *pb.PeerID, network []*pb.PeerID, err
 	return
 }
 
-// Broadcast sends a message to all validating peers
+// Broadcast sends a message to all validating peers. The CONSENSUS messages
+// of a plugin registered as ValidatorsOnly are never sent to non-validators.
 func (h *Helper) Broadcast(msg *pb.Message, peerType pb.PeerEndpoint_Type) error {
+	if h.validatorsOnly && msg.Type == pb.Message_CONSENSUS && peerType == pb.PeerEndpoint_UNDEFINED {
+		peerType = pb.PeerEndpoint_VALIDATOR
+	}
 	errors := h.coordinator.Broadcast(msg, peerType)
 	if len(errors) > 0 {
 		return fmt.Errorf("Couldn't broadcast successfully")
@@ -190,9 +200,35 @@ func (h *Helper) CommitTxBatch(id interface{}, metadata []byte) (*pb.Block, erro
 		return nil, fmt.Errorf("Failed to get the block at the head of the chain: %v", err)
 	}
 
+	if viper.GetBool("peer.validator.stateDelta.broadcast") {
+		h.broadcastStateDelta(ledger, size-1, block)
+	}
+
 	return block, nil
 }
 
+// broadcastStateDelta pushes a committed block and its state delta to the
+// non-validating peers, so that they apply the delta rather than execute
+// the transactions. Peers that miss it pull the block later, errors are only
+// logged.
+func (h *Helper) broadcastStateDelta(ledger *ledger.Ledger, blockNumber uint64, block *pb.Block) {
+	delta, err := ledger.GetStateDelta(blockNumber)
+	if err != nil || delta == nil {
+		logger.Warning("No state delta to broadcast for block %d: %v", blockNumber, err)
+		return
+	}
+	payload, err := proto.Marshal(&pb.BlockStateDelta{BlockNumber: blockNumber, Block: block, StateDelta: delta.Marshal()})
+	if err != nil {
+		logger.Error(fmt.Sprintf("Error marshalling the state delta of block %d: %s", blockNumber, err))
+		return
+	}
+	msg := &pb.Message{Type: pb.Message_STATE_DELTA, Payload: payload, Timestamp: util.CreateUtcTimestamp()}
+	for _, err := range h.coordinator.Broadcast(msg, pb.PeerEndpoint_NON_VALIDATOR) {
+		// e.g. peers predating STATE_DELTA
+		logger.Debug("%s", err)
+	}
+}
+
 // RollbackTxBatch discards all the state changes that may have taken
 // place during the execution of current transaction-batch
 func (h *Helper) RollbackTxBatch(id interface{}) error {
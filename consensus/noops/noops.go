@@ -183,6 +183,10 @@ func (i *Noops) handleChannels() {
 func (i *Noops) processBlock() error {
 	i.timer.Stop()
 
+	for _, tx := range i.txQ.dropExpired(time.Now()) {
+		logger.Warning("Dropping expired transaction %s", tx.Uuid)
+	}
+
 	if i.txQ.size() < 1 {
 		if logger.IsEnabledFor(logging.DEBUG) {
 			logger.Debug("processBlock() called but transaction Q is empty")
@@ -20,6 +20,8 @@ under the License.
 package noops
 
 import (
+	"time"
+
 	pb "github.com/hyperledger/fabric/protos"
 )
 
@@ -62,6 +64,23 @@ func (o *txq) size() int {
 	return o.i
 }
 
+// dropExpired removes the transactions whose validity window has passed at
+// time now, keeping the order of the others, and returns them
+func (o *txq) dropExpired(now time.Time) []*pb.Transaction {
+	var expired []*pb.Transaction
+	kept := 0
+	for _, tx := range o.q[:o.i] {
+		if tx.IsExpired(now) {
+			expired = append(expired, tx)
+			continue
+		}
+		o.q[kept] = tx
+		kept++
+	}
+	o.i = kept
+	return expired
+}
+
 func (o *txq) reset() {
 	o.i = 0
 }
@@ -227,6 +227,7 @@ func (op *obcBatch) sendBatch() error {
 	var txs []*pb.Transaction
 	store := op.batchStore
 	op.batchStore = nil
+	now := time.Now()
 	for _, req := range store {
 		tx := &pb.Transaction{}
 		err := proto.Unmarshal(req, tx)
@@ -235,8 +236,15 @@ func (op *obcBatch) sendBatch() error {
 			logger.Error(err.Error())
 			continue
 		}
+		if tx.IsExpired(now) {
+			logger.Warning("Dropping expired transaction %s", tx.Uuid)
+			continue
+		}
 		txs = append(txs, tx)
 	}
+	if len(txs) == 0 {
+		return nil
+	}
 	tb := &pb.TransactionBlock{Transactions: txs}
 	tbPacked, err := proto.Marshal(tb)
 	if err != nil {
@@ -20,7 +20,10 @@ under the License.
 package core
 
 import (
+	"fmt"
+	"io"
 	"runtime"
+	"sort"
 	"time"
 
 	"github.com/op/go-logging"
@@ -29,6 +32,8 @@ import (
 
 	google_protobuf "google/protobuf"
 
+	"github.com/hyperledger/fabric/core/chaincode"
+	"github.com/hyperledger/fabric/core/peer"
 	pb "github.com/hyperledger/fabric/protos"
 )
 
@@ -40,8 +45,17 @@ func NewAdminServer() *ServerAdmin {
 	return s
 }
 
+// NewAdminServerWithPeer creates and returns a Admin service instance that can
+// report on the peer, e.g. its peer table in support bundles.
+func NewAdminServerWithPeer(coord peer.MessageHandlerCoordinator) *ServerAdmin {
+	s := new(ServerAdmin)
+	s.coord = coord
+	return s
+}
+
 // ServerAdmin implementation of the Admin service for the Peer
 type ServerAdmin struct {
+	coord peer.MessageHandlerCoordinator
 }
 
 func worker(id int, die chan struct{}) {
@@ -79,6 +93,204 @@ func (*ServerAdmin) StartServer(context.Context, *google_protobuf.Empty) (*pb.Se
 	return status, nil
 }
 
+// AuditLeaks reports chaincode resources outstanding for longer than the requested threshold
+func (*ServerAdmin) AuditLeaks(ctx context.Context, req *pb.LeakAuditRequest) (*pb.LeakAuditReport, error) {
+	chaincodeSupport := chaincode.GetChain(chaincode.DefaultChain)
+	if chaincodeSupport == nil {
+		return nil, fmt.Errorf("chaincode support not initialized")
+	}
+	threshold := time.Duration(req.ThresholdSeconds) * time.Second
+	report := &pb.LeakAuditReport{Resources: chaincodeSupport.AuditLeaks(threshold, req.Expire)}
+	log.Debug("returning leak report: %s", report)
+	return report, nil
+}
+
+// GetTransactionTrace returns the support bundle of a transaction: its message
+// exchange, FSM transitions, state operations and the chaincode container logs
+func (*ServerAdmin) GetTransactionTrace(ctx context.Context, req *pb.TransactionTraceRequest) (*pb.SupportBundle, error) {
+	chaincodeSupport := chaincode.GetChain(chaincode.DefaultChain)
+	if chaincodeSupport == nil {
+		return nil, fmt.Errorf("chaincode support not initialized")
+	}
+	bundle, err := chaincodeSupport.SupportBundle(ctx, req.Uuid, int(req.LogTailLines))
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("returning support bundle for transaction %s with %d entries", req.Uuid, len(bundle.Entries))
+	return bundle, nil
+}
+
+// GetFSMTransitions returns the recorded chaincode and peer handler FSM
+// transitions matching the request, oldest first
+func (*ServerAdmin) GetFSMTransitions(ctx context.Context, req *pb.FSMTransitionsRequest) (*pb.FSMTransitions, error) {
+	var all []*pb.FSMTransition
+	if chaincodeSupport := chaincode.GetChain(chaincode.DefaultChain); chaincodeSupport != nil {
+		all = append(all, chaincodeSupport.FSMTransitions(req)...)
+	}
+	all = append(all, peer.GetFSMRecorder().Transitions(req)...)
+	sort.Stable(byTimestamp(all))
+	log.Debug("returning %d FSM transitions", len(all))
+	return &pb.FSMTransitions{Transitions: all}, nil
+}
+
+// GetNetworkInventory returns the peers of the network known through
+// discovery with their latest verified metadata
+func (s *ServerAdmin) GetNetworkInventory(context.Context, *google_protobuf.Empty) (*pb.NetworkInventory, error) {
+	if s.coord == nil {
+		return nil, fmt.Errorf("peer not initialized")
+	}
+	inventory := s.coord.GetNetworkInventory()
+	log.Debug("returning %d inventory entries", len(inventory.Entries))
+	return inventory, nil
+}
+
+// GetMeshStatus returns the scores of the connected peers and the recent
+// decisions taken to keep within the connection budget
+func (s *ServerAdmin) GetMeshStatus(context.Context, *google_protobuf.Empty) (*pb.MeshStatus, error) {
+	if s.coord == nil {
+		return nil, fmt.Errorf("peer not initialized")
+	}
+	return s.coord.GetMeshStatus(), nil
+}
+
+// GetPeerStandings returns the peers that recently violated the protocol and
+// whether they are greylisted or blacklisted
+func (s *ServerAdmin) GetPeerStandings(context.Context, *google_protobuf.Empty) (*pb.PeerStandings, error) {
+	if s.coord == nil {
+		return nil, fmt.Errorf("peer not initialized")
+	}
+	return s.coord.GetPeerStandings(), nil
+}
+
+// ClearPeerStandings forgets the violations of the requested peer, or of all
+// peers, restoring them to good standing
+func (s *ServerAdmin) ClearPeerStandings(ctx context.Context, req *pb.ClearPeerStandingsRequest) (*pb.PeerStandings, error) {
+	if s.coord == nil {
+		return nil, fmt.Errorf("peer not initialized")
+	}
+	if req.PeerID != nil {
+		log.Info("Clearing the protocol violations of peer %s", req.PeerID.Name)
+	} else {
+		log.Info("Clearing the protocol violations of all peers")
+	}
+	return s.coord.ClearPeerStandings(req.PeerID), nil
+}
+
+// GetPeerBandwidth returns the bytes exchanged with each connected peer and
+// the time spent waiting on the peer.bandwidth caps
+func (s *ServerAdmin) GetPeerBandwidth(context.Context, *google_protobuf.Empty) (*pb.PeerBandwidthStatus, error) {
+	if s.coord == nil {
+		return nil, fmt.Errorf("peer not initialized")
+	}
+	return s.coord.GetPeerBandwidth(), nil
+}
+
+// GetLogLevel returns the level of a log module
+func (*ServerAdmin) GetLogLevel(ctx context.Context, req *pb.LogLevel) (*pb.LogLevel, error) {
+	level := logging.GetLevel(req.Module)
+	if chaincode.IsChaincodeLogModule(req.Module) {
+		level = chaincode.GetLogLevel(req.Module)
+	}
+	return &pb.LogLevel{Module: req.Module, Level: level.String()}, nil
+}
+
+// SetLogLevel changes the level of a log module at runtime. The lines of the
+// handler of a chaincode go to its module, chaincode.<name>.
+func (*ServerAdmin) SetLogLevel(ctx context.Context, req *pb.LogLevel) (*pb.LogLevel, error) {
+	level, err := logging.LogLevel(req.Level)
+	if err != nil {
+		return nil, err
+	}
+	if chaincode.IsChaincodeLogModule(req.Module) {
+		if err = chaincode.SetLogLevel(req.Module, level); err != nil {
+			return nil, err
+		}
+	} else {
+		logging.SetLevel(level, req.Module)
+	}
+	log.Info("Log level of module '%s' set to %s", req.Module, level)
+	return &pb.LogLevel{Module: req.Module, Level: level.String()}, nil
+}
+
+// AbortTransaction aborts a stuck transaction, failing its waiters with
+// OPERATOR_ABORTED, and optionally restarts the chaincode executing it
+func (*ServerAdmin) AbortTransaction(ctx context.Context, req *pb.AbortTransactionRequest) (*pb.AbortTransactionResponse, error) {
+	chaincodeSupport := chaincode.GetChain(chaincode.DefaultChain)
+	if chaincodeSupport == nil {
+		return nil, fmt.Errorf("chaincode support not initialized")
+	}
+	if req.Uuid == "" {
+		return nil, fmt.Errorf("transaction uuid not set")
+	}
+	reason := req.Reason
+	if reason == "" {
+		reason = "aborted by operator"
+	}
+	name, err := chaincodeSupport.AbortTransaction(ctx, req.Uuid, reason, req.RestartChaincode)
+	if err != nil {
+		return nil, err
+	}
+	log.Warning("Transaction %s of chaincode %s aborted by operator: %s", req.Uuid, name, reason)
+	return &pb.AbortTransactionResponse{ChaincodeID: name, Restarted: req.RestartChaincode}, nil
+}
+
+// GetChaincodeMetrics returns the time the handlers of the running chaincodes
+// spent in each FSM state and their transition counts
+func (*ServerAdmin) GetChaincodeMetrics(context.Context, *google_protobuf.Empty) (*pb.ChaincodeMetrics, error) {
+	chaincodeSupport := chaincode.GetChain(chaincode.DefaultChain)
+	if chaincodeSupport == nil {
+		return nil, fmt.Errorf("chaincode support not initialized")
+	}
+	metrics := &pb.ChaincodeMetrics{Handlers: chaincodeSupport.HandlerMetrics()}
+	log.Debug("returning metrics of %d chaincode handlers", len(metrics.Handlers))
+	return metrics, nil
+}
+
+// ExportState streams the committed key/values of a chaincode in chunks
+func (*ServerAdmin) ExportState(req *pb.ExportStateRequest, stream pb.Admin_ExportStateServer) error {
+	chaincodeSupport := chaincode.GetChain(chaincode.DefaultChain)
+	if chaincodeSupport == nil {
+		return fmt.Errorf("chaincode support not initialized")
+	}
+	keys, err := chaincodeSupport.ExportState(req.ChaincodeID, int(req.ChunkSize), stream.Send)
+	if err != nil {
+		return fmt.Errorf("Error exporting the state of chaincode %s after %d keys: %s", req.ChaincodeID, keys, err)
+	}
+	log.Info("Exported %d keys of chaincode %s", keys, req.ChaincodeID)
+	return nil
+}
+
+// ImportState writes the streamed chunks of key/values to the state of their
+// chaincodes. The chunks imported before an error are kept.
+func (*ServerAdmin) ImportState(stream pb.Admin_ImportStateServer) error {
+	chaincodeSupport := chaincode.GetChain(chaincode.DefaultChain)
+	if chaincodeSupport == nil {
+		return fmt.Errorf("chaincode support not initialized")
+	}
+	resp := &pb.ImportStateResponse{}
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			log.Info("Imported %d keys in %d chunks", resp.Keys, resp.Chunks)
+			return stream.SendAndClose(resp)
+		}
+		if err != nil {
+			return err
+		}
+		if err = chaincodeSupport.ImportState(chunk); err != nil {
+			return fmt.Errorf("Error importing chunk %d of chaincode %s after %d keys: %s", chunk.Sequence, chunk.ChaincodeID, resp.Keys, err)
+		}
+		resp.Chunks++
+		resp.Keys += uint64(len(chunk.KeyValues))
+	}
+}
+
+type byTimestamp []*pb.FSMTransition
+
+func (a byTimestamp) Len() int           { return len(a) }
+func (a byTimestamp) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byTimestamp) Less(i, j int) bool { return a[i].TimestampNanos < a[j].TimestampNanos }
+
 // StopServer stops the server
 func (*ServerAdmin) StopServer(context.Context, *google_protobuf.Empty) (*pb.ServerStatus, error) {
 	status := &pb.ServerStatus{Status: pb.ServerStatus_STOPPED}
@@ -0,0 +1,401 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package bridge relays chaincode invocations committed on the local network
+// to a chaincode on a second network and records the outcome back on the
+// local network, enabling basic cross-network workflows.
+package bridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/op/go-logging"
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+	google_protobuf "google/protobuf"
+
+	"github.com/hyperledger/fabric/core/peer"
+	"github.com/hyperledger/fabric/events/consumer"
+	"github.com/hyperledger/fabric/events/producer"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+var logger = logging.MustGetLogger("bridge")
+
+// Status values passed to the acknowledgment function
+const (
+	AckSuccess = "SUCCESS"
+	AckFailure = "FAILURE"
+)
+
+// pollIntervalDefault is used when bridge.pollInterval is not set
+const pollIntervalDefault = 10 * time.Second
+
+// Bridge relays the blocks committed on the local network in order, reading
+// them from the local peer. Every public invocation that executed
+// successfully and matches a configured route is submitted to the remote
+// network with the source transaction UUID prepended to its arguments, so the
+// remote chaincode can detect replays. The remote outcome is then recorded by
+// invoking the route's acknowledgment function on the local chaincode with
+// the arguments [source UUID, status, remote response].
+//
+// Block events only wake the bridge up, the blocks are relayed by a goroutine
+// of its own so that the event stream is never held up by the remote network.
+// The bridge also polls the local peer, which catches up with the blocks
+// committed while it was disconnected from the event hub. Its progress is
+// saved after every relayed transaction, a transaction is not relayed again
+// after a restart.
+type Bridge struct {
+	sync.Mutex
+	routes   []*Route
+	local    pb.DevopsClient
+	chain    pb.OpenchainClient
+	dial     func(address string) (pb.DevopsClient, error)
+	remotes  map[string]pb.DevopsClient
+	path     string
+	progress *progress
+	wake     chan struct{}
+	stop     chan struct{}
+	events   *consumer.EventsClient
+}
+
+// progress is how far the bridge got, as saved to its file
+type progress struct {
+	// Number of the next block to relay
+	Block uint64 `json:"block"`
+	// Transactions of that block already relayed
+	Relayed []string `json:"relayed,omitempty"`
+}
+
+// NewBridge returns a bridge relaying the given routes from the blocks read
+// through chain. Acknowledgments are submitted through local, and remote
+// networks are reached through clients returned by dial, which are cached per
+// address. The progress of the bridge is kept in the file at path. Without a
+// file the bridge starts with the next block committed.
+func NewBridge(routes []*Route, local pb.DevopsClient, chain pb.OpenchainClient, dial func(address string) (pb.DevopsClient, error), path string) (*Bridge, error) {
+	b := &Bridge{routes: routes, local: local, chain: chain, dial: dial, remotes: make(map[string]pb.DevopsClient),
+		path: path, wake: make(chan struct{}, 1), stop: make(chan struct{})}
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		b.progress = &progress{}
+		if err = json.Unmarshal(data, b.progress); err != nil {
+			return nil, fmt.Errorf("Error reading bridge progress from %s: %s", path, err)
+		}
+		return b, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Error reading bridge progress from %s: %s", path, err)
+	}
+	info, err := chain.GetBlockchainInfo(context.Background(), &google_protobuf.Empty{})
+	if err != nil {
+		return nil, fmt.Errorf("Error reading the height of the local blockchain: %s", err)
+	}
+	b.progress = &progress{Block: info.Height}
+	return b, b.saveProgress()
+}
+
+// Start creates a bridge from the configuration and connects it to the
+// local event hub. It does nothing unless bridge.enabled is set.
+func Start() (*Bridge, error) {
+	if !viper.GetBool("bridge.enabled") {
+		return nil, nil
+	}
+	routes, err := getRoutes()
+	if err != nil {
+		return nil, err
+	}
+	if len(routes) == 0 {
+		return nil, fmt.Errorf("bridge enabled but no routes configured")
+	}
+	conn, err := peer.NewPeerClientConnection()
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to local peer: %s", err)
+	}
+	dial := func(address string) (pb.DevopsClient, error) {
+		conn, err := peer.NewPeerClientConnectionWithAddress(address)
+		if err != nil {
+			return nil, err
+		}
+		return pb.NewDevopsClient(conn), nil
+	}
+	path := filepath.Join(viper.GetString("peer.fileSystemPath"), "bridge", "progress.json")
+	b, err := NewBridge(routes, pb.NewDevopsClient(conn), pb.NewOpenchainClient(conn), dial, path)
+	if err != nil {
+		return nil, err
+	}
+	go b.run(getPollInterval())
+
+	b.events = consumer.NewEventsClient(viper.GetString("bridge.events.address"), b)
+	if err = b.events.Start(); err != nil {
+		// polling relays the blocks until the event hub is reachable
+		logger.Warning("Error connecting bridge to event hub, polling the local peer: %s", err)
+		go b.reconnect()
+	}
+	logger.Info("Bridge started with %d route(s)", len(routes))
+	return b, nil
+}
+
+func getPollInterval() time.Duration {
+	if interval := viper.GetDuration("bridge.pollInterval"); interval > 0 {
+		return interval
+	}
+	return pollIntervalDefault
+}
+
+// Stop stops relaying blocks
+func (b *Bridge) Stop() {
+	close(b.stop)
+	if b.events != nil {
+		b.events.Stop()
+	}
+}
+
+// GetInterestedEvents implements consumer.EventAdapter
+func (b *Bridge) GetInterestedEvents() ([]*pb.Interest, error) {
+	return []*pb.Interest{{EventType: producer.BlockType, ResponseType: pb.Interest_PROTOBUF}}, nil
+}
+
+// Recv implements consumer.EventAdapter. A block event wakes up the goroutine
+// relaying the blocks, it never waits for it.
+func (b *Bridge) Recv(msg *pb.Event) (bool, error) {
+	if _, ok := msg.Event.(*pb.Event_Block); ok {
+		select {
+		case b.wake <- struct{}{}:
+		default:
+		}
+	}
+	return true, nil
+}
+
+// Disconnected implements consumer.EventAdapter. The bridge polls the local
+// peer until it is connected to the event hub again.
+func (b *Bridge) Disconnected(err error) {
+	logger.Warning("Bridge disconnected from event hub: %v", err)
+	if b.events != nil {
+		go b.reconnect()
+	}
+}
+
+// reconnect connects to the event hub again, retrying every poll interval
+// until it succeeds or the bridge stops
+func (b *Bridge) reconnect() {
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-time.After(getPollInterval()):
+		}
+		if err := b.events.Start(); err != nil {
+			logger.Debug("Error reconnecting bridge to event hub: %s", err)
+			continue
+		}
+		logger.Info("Bridge reconnected to event hub")
+		return
+	}
+}
+
+// run relays the blocks committed whenever an event reports one and every
+// interval until the bridge stops
+func (b *Bridge) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		b.catchUp()
+		select {
+		case <-b.wake:
+		case <-ticker.C:
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// catchUp relays the blocks committed since the last one relayed
+func (b *Bridge) catchUp() {
+	info, err := b.chain.GetBlockchainInfo(context.Background(), &google_protobuf.Empty{})
+	if err != nil {
+		logger.Error("Error reading the height of the local blockchain: %s", err)
+		return
+	}
+	for b.progress.Block < info.Height {
+		block, err := b.chain.GetBlockByNumber(context.Background(), &pb.BlockNumber{Number: b.progress.Block})
+		if err != nil {
+			logger.Error("Error reading block %d: %s", b.progress.Block, err)
+			return
+		}
+		if err = b.processBlock(block); err != nil {
+			logger.Error("Error relaying block %d: %s", b.progress.Block, err)
+			return
+		}
+		b.progress = &progress{Block: b.progress.Block + 1}
+		if err = b.saveProgress(); err != nil {
+			logger.Error(err.Error())
+			return
+		}
+	}
+}
+
+// processBlock relays the transactions of block that executed successfully
+// and have not been relayed yet
+func (b *Bridge) processBlock(block *pb.Block) error {
+	failed := make(map[string]bool)
+	if block.NonHashData != nil {
+		for _, result := range block.NonHashData.TransactionResults {
+			if result.ErrorCode != 0 {
+				failed[result.Uuid] = true
+			}
+		}
+	}
+	relayed := make(map[string]bool)
+	for _, uuid := range b.progress.Relayed {
+		relayed[uuid] = true
+	}
+	for _, tx := range block.Transactions {
+		if failed[tx.Uuid] || relayed[tx.Uuid] {
+			continue
+		}
+		if !b.processTransaction(tx) {
+			continue
+		}
+		b.progress.Relayed = append(b.progress.Relayed, tx.Uuid)
+		if err := b.saveProgress(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveProgress writes the progress of the bridge to its file
+func (b *Bridge) saveProgress() error {
+	data, err := json.Marshal(b.progress)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(b.path), 0755); err != nil {
+		return err
+	}
+	tmp := b.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, b.path); err != nil {
+		return fmt.Errorf("Error saving bridge progress to %s: %s", b.path, err)
+	}
+	return nil
+}
+
+// processTransaction relays tx if it matches a route and returns whether it
+// did
+func (b *Bridge) processTransaction(tx *pb.Transaction) bool {
+	if tx.Type != pb.Transaction_CHAINCODE_INVOKE || tx.ConfidentialityLevel != pb.ConfidentialityLevel_PUBLIC {
+		return false
+	}
+	cis := &pb.ChaincodeInvocationSpec{}
+	if err := proto.Unmarshal(tx.Payload, cis); err != nil {
+		logger.Debug("Skipping transaction %s with unreadable payload: %s", tx.Uuid, err)
+		return false
+	}
+	spec := cis.ChaincodeSpec
+	if spec == nil || spec.ChaincodeID == nil || spec.CtorMsg == nil {
+		return false
+	}
+	route := b.findRoute(spec.ChaincodeID.Name, spec.CtorMsg.Function)
+	if route == nil {
+		return false
+	}
+
+	status, result := AckSuccess, ""
+	resp, err := b.relay(route, tx.Uuid, spec.CtorMsg.Args)
+	if err == nil && resp.Status == pb.Response_FAILURE {
+		err = fmt.Errorf("%s", resp.Msg)
+	}
+	if err != nil {
+		logger.Error("Failed relaying transaction %s to %s: %s", tx.Uuid, route.Remote.Address, err)
+		status, result = AckFailure, err.Error()
+	} else {
+		logger.Debug("Relayed transaction %s to %s", tx.Uuid, route.Remote.Address)
+		result = string(resp.Msg)
+	}
+
+	if err = b.acknowledge(route, tx.Uuid, status, result); err != nil {
+		logger.Error("Failed acknowledging transaction %s: %s", tx.Uuid, err)
+	}
+	return true
+}
+
+func (b *Bridge) findRoute(chaincodeID, function string) *Route {
+	for _, r := range b.routes {
+		if r.ChaincodeID == chaincodeID && r.Function == function {
+			return r
+		}
+	}
+	return nil
+}
+
+func (b *Bridge) remote(address string) (pb.DevopsClient, error) {
+	b.Lock()
+	defer b.Unlock()
+	if c, ok := b.remotes[address]; ok {
+		return c, nil
+	}
+	c, err := b.dial(address)
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to remote peer %s: %s", address, err)
+	}
+	b.remotes[address] = c
+	return c, nil
+}
+
+func (b *Bridge) relay(route *Route, uuid string, args []string) (*pb.Response, error) {
+	client, err := b.remote(route.Remote.Address)
+	if err != nil {
+		return nil, err
+	}
+	spec := &pb.ChaincodeSpec{
+		Type:          pb.ChaincodeSpec_GOLANG,
+		ChaincodeID:   &pb.ChaincodeID{Name: route.Remote.ChaincodeID},
+		CtorMsg:       &pb.ChaincodeInput{Function: route.Remote.Function, Args: append([]string{uuid}, args...)},
+		SecureContext: route.Remote.SecureContext,
+	}
+	return client.Invoke(context.Background(), &pb.ChaincodeInvocationSpec{ChaincodeSpec: spec})
+}
+
+func (b *Bridge) acknowledge(route *Route, uuid, status, result string) error {
+	if route.AckFunction == "" {
+		return nil
+	}
+	spec := &pb.ChaincodeSpec{
+		Type:          pb.ChaincodeSpec_GOLANG,
+		ChaincodeID:   &pb.ChaincodeID{Name: route.ChaincodeID},
+		CtorMsg:       &pb.ChaincodeInput{Function: route.AckFunction, Args: []string{uuid, status, result}},
+		SecureContext: viper.GetString("bridge.secureContext"),
+	}
+	resp, err := b.local.Invoke(context.Background(), &pb.ChaincodeInvocationSpec{ChaincodeSpec: spec})
+	if err == nil && resp.Status == pb.Response_FAILURE {
+		err = fmt.Errorf("%s", resp.Msg)
+	}
+	return err
+}
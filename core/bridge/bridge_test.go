@@ -0,0 +1,245 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package bridge
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	google_protobuf "google/protobuf"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+type fakeDevops struct {
+	pb.DevopsClient
+	invoked []*pb.ChaincodeSpec
+	resp    *pb.Response
+	err     error
+}
+
+func (f *fakeDevops) Invoke(ctx context.Context, in *pb.ChaincodeInvocationSpec, opts ...grpc.CallOption) (*pb.Response, error) {
+	f.invoked = append(f.invoked, in.ChaincodeSpec)
+	return f.resp, f.err
+}
+
+func invokeTx(t *testing.T, uuid, chaincodeID, function string, args ...string) *pb.Transaction {
+	spec := &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_GOLANG, ChaincodeID: &pb.ChaincodeID{Name: chaincodeID},
+		CtorMsg: &pb.ChaincodeInput{Function: function, Args: args}}
+	tx, err := pb.NewChaincodeExecute(&pb.ChaincodeInvocationSpec{ChaincodeSpec: spec}, uuid, pb.Transaction_CHAINCODE_INVOKE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// fakeChain serves the blocks of a local blockchain
+type fakeChain struct {
+	pb.OpenchainClient
+	blocks []*pb.Block
+}
+
+func (c *fakeChain) GetBlockchainInfo(ctx context.Context, in *google_protobuf.Empty, opts ...grpc.CallOption) (*pb.BlockchainInfo, error) {
+	return &pb.BlockchainInfo{Height: uint64(len(c.blocks))}, nil
+}
+
+func (c *fakeChain) GetBlockByNumber(ctx context.Context, in *pb.BlockNumber, opts ...grpc.CallOption) (*pb.Block, error) {
+	if in.Number >= uint64(len(c.blocks)) {
+		return nil, fmt.Errorf("no block %d", in.Number)
+	}
+	return c.blocks[in.Number], nil
+}
+
+func newTestBridge(t *testing.T, remote *fakeDevops, chain *fakeChain, path string) (*Bridge, *fakeDevops, *int) {
+	local := &fakeDevops{resp: &pb.Response{Status: pb.Response_SUCCESS}}
+	dials := 0
+	routes := []*Route{{
+		ChaincodeID: "source",
+		Function:    "lock",
+		Remote:      Remote{Address: "remote:30303", ChaincodeID: "target", Function: "mint"},
+		AckFunction: "confirm",
+	}}
+	b, err := NewBridge(routes, local, chain, func(address string) (pb.DevopsClient, error) {
+		dials++
+		return remote, nil
+	}, path)
+	if err != nil {
+		t.Fatalf("Error creating bridge: %s", err)
+	}
+	return b, local, &dials
+}
+
+func tempProgressPath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "bridge")
+	if err != nil {
+		t.Fatalf("Error creating directory: %s", err)
+	}
+	return filepath.Join(dir, "progress.json"), func() { os.RemoveAll(dir) }
+}
+
+func TestBridgeRelaysMatchingTransactions(t *testing.T) {
+	path, cleanup := tempProgressPath(t)
+	defer cleanup()
+	remote := &fakeDevops{resp: &pb.Response{Status: pb.Response_SUCCESS, Msg: []byte("remote-uuid")}}
+	chain := &fakeChain{blocks: []*pb.Block{{Transactions: []*pb.Transaction{invokeTx(t, "tx0", "source", "lock", "old")}}}}
+	b, local, dials := newTestBridge(t, remote, chain, path)
+
+	confidential := invokeTx(t, "tx3", "source", "lock", "a")
+	confidential.ConfidentialityLevel = pb.ConfidentialityLevel_CONFIDENTIAL
+	chain.blocks = append(chain.blocks, &pb.Block{Transactions: []*pb.Transaction{
+		invokeTx(t, "tx1", "source", "lock", "a", "10"),
+		invokeTx(t, "tx2", "source", "unlock", "a"),
+		confidential,
+		invokeTx(t, "tx4", "source", "lock", "b", "5"),
+		invokeTx(t, "tx5", "source", "lock", "c", "1"),
+	}, NonHashData: &pb.NonHashData{TransactionResults: []*pb.TransactionResult{{Uuid: "tx5", ErrorCode: 1, Error: "failed"}}}})
+
+	// The event only wakes the bridge up, relaying is left to its goroutine
+	if cont, err := b.Recv(&pb.Event{Event: &pb.Event_Block{Block: chain.blocks[1]}}); !cont || err != nil {
+		t.Fatalf("Expected event stream to continue, got %t, %v", cont, err)
+	}
+	if len(remote.invoked) != 0 {
+		t.Fatal("Expected the event stream not to relay transactions")
+	}
+	b.catchUp()
+
+	// Blocks committed before the bridge started and failed transactions
+	// are not relayed
+	if len(remote.invoked) != 2 || *dials != 1 {
+		t.Fatalf("Expected 2 relayed transactions over 1 connection, got %d over %d", len(remote.invoked), *dials)
+	}
+	spec := remote.invoked[0]
+	if spec.ChaincodeID.Name != "target" || spec.CtorMsg.Function != "mint" ||
+		!reflect.DeepEqual(spec.CtorMsg.Args, []string{"tx1", "a", "10"}) {
+		t.Fatalf("Unexpected relayed invocation %v", spec)
+	}
+
+	if len(local.invoked) != 2 {
+		t.Fatalf("Expected 2 acknowledgments, got %d", len(local.invoked))
+	}
+	ack := local.invoked[1]
+	if ack.ChaincodeID.Name != "source" || ack.CtorMsg.Function != "confirm" ||
+		!reflect.DeepEqual(ack.CtorMsg.Args, []string{"tx4", AckSuccess, "remote-uuid"}) {
+		t.Fatalf("Unexpected acknowledgment %v", ack)
+	}
+
+	// Caught up, nothing is relayed twice
+	b.catchUp()
+	if len(remote.invoked) != 2 {
+		t.Fatalf("Expected no transaction to be relayed again, got %d", len(remote.invoked))
+	}
+}
+
+func TestBridgeResumesAfterRestart(t *testing.T) {
+	path, cleanup := tempProgressPath(t)
+	defer cleanup()
+	remote := &fakeDevops{resp: &pb.Response{Status: pb.Response_SUCCESS}}
+	chain := &fakeChain{}
+	b, _, _ := newTestBridge(t, remote, chain, path)
+
+	// Stopped after relaying the first transaction of the block, while
+	// disconnected more blocks were committed
+	chain.blocks = []*pb.Block{
+		{Transactions: []*pb.Transaction{invokeTx(t, "tx1", "source", "lock", "a"), invokeTx(t, "tx2", "source", "lock", "b")}},
+		{Transactions: []*pb.Transaction{invokeTx(t, "tx3", "source", "lock", "c")}},
+	}
+	b.progress = &progress{Block: 0, Relayed: []string{"tx1"}}
+	if err := b.saveProgress(); err != nil {
+		t.Fatalf("Error saving progress: %s", err)
+	}
+
+	restarted, _, _ := newTestBridge(t, remote, chain, path)
+	restarted.catchUp()
+	if len(remote.invoked) != 2 || remote.invoked[0].CtorMsg.Args[0] != "tx2" || remote.invoked[1].CtorMsg.Args[0] != "tx3" {
+		t.Fatalf("Expected tx2 and tx3 to be relayed, got %v", remote.invoked)
+	}
+}
+
+func TestBridgeAcknowledgesRemoteFailure(t *testing.T) {
+	path, cleanup := tempProgressPath(t)
+	defer cleanup()
+	remote := &fakeDevops{err: fmt.Errorf("unavailable")}
+	b, local, _ := newTestBridge(t, remote, &fakeChain{}, path)
+
+	b.processTransaction(invokeTx(t, "tx1", "source", "lock", "a"))
+
+	if len(local.invoked) != 1 {
+		t.Fatalf("Expected 1 acknowledgment, got %d", len(local.invoked))
+	}
+	if args := local.invoked[0].CtorMsg.Args; !reflect.DeepEqual(args, []string{"tx1", AckFailure, "unavailable"}) {
+		t.Fatalf("Unexpected acknowledgment args %v", args)
+	}
+}
+
+func TestBridgeSkipsUnreadablePayload(t *testing.T) {
+	path, cleanup := tempProgressPath(t)
+	defer cleanup()
+	remote := &fakeDevops{}
+	b, local, _ := newTestBridge(t, remote, &fakeChain{}, path)
+
+	tx := invokeTx(t, "tx1", "source", "lock")
+	tx.Payload = []byte{0xff}
+	if b.processTransaction(tx) {
+		t.Fatal("Expected transaction with unreadable payload not to be relayed")
+	}
+
+	if len(remote.invoked) != 0 || len(local.invoked) != 0 {
+		t.Fatal("Expected transaction with unreadable payload to be skipped")
+	}
+}
+
+func TestGetRoutes(t *testing.T) {
+	defer viper.Reset()
+	viper.SetConfigType("yaml")
+	config := []byte(`
+bridge:
+    routes:
+        - chaincodeID: source
+          function: lock
+          remote:
+              address: remote:30303
+              chaincodeID: target
+              function: mint
+          ackFunction: confirm
+`)
+	if err := viper.ReadConfig(bytes.NewBuffer(config)); err != nil {
+		t.Fatal(err)
+	}
+	routes, err := getRoutes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 1 || routes[0].Remote.Function != "mint" || routes[0].AckFunction != "confirm" {
+		t.Fatalf("Unexpected routes %+v", routes)
+	}
+
+	viper.Set("bridge.routes", []interface{}{map[interface{}]interface{}{"chaincodeID": "source", "function": "lock"}})
+	if _, err = getRoutes(); err == nil {
+		t.Fatal("Expected route without a remote to be rejected")
+	}
+}
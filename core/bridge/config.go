@@ -0,0 +1,75 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package bridge
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// Remote identifies the chaincode function on the remote network that a
+// relayed invocation is submitted to.
+type Remote struct {
+	// Address of a peer on the remote network exposing the Devops service
+	Address string
+	// ChaincodeID is the name of the chaincode on the remote network
+	ChaincodeID string
+	// Function invoked on the remote chaincode
+	Function string
+	// SecureContext is the enrollment ID used when security is enabled on
+	// the remote network
+	SecureContext string
+}
+
+// Route maps invocations of a local chaincode function onto a remote
+// chaincode function. Once the remote network accepts the transaction the
+// outcome is acknowledged on the local network by invoking AckFunction on
+// the same local chaincode, unless AckFunction is empty.
+type Route struct {
+	ChaincodeID string
+	Function    string
+	Remote      Remote
+	AckFunction string
+}
+
+func (r *Route) validate() error {
+	if r.ChaincodeID == "" || r.Function == "" {
+		return fmt.Errorf("bridge route requires a chaincodeID and function")
+	}
+	if r.Remote.Address == "" || r.Remote.ChaincodeID == "" || r.Remote.Function == "" {
+		return fmt.Errorf("bridge route %s/%s requires a remote address, chaincodeID and function", r.ChaincodeID, r.Function)
+	}
+	return nil
+}
+
+// getRoutes reads and validates the routes configured under bridge.routes
+func getRoutes() ([]*Route, error) {
+	var routes []*Route
+	if err := viper.UnmarshalKey("bridge.routes", &routes); err != nil {
+		return nil, fmt.Errorf("Error reading bridge routes: %s", err)
+	}
+	for _, r := range routes {
+		if err := r.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return routes, nil
+}
@@ -0,0 +1,104 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+
+	"github.com/hyperledger/fabric/core/fsmaudit"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// OperatorAborted starts the error returned to the waiters of a transaction
+// aborted through the Admin service
+const OperatorAborted = "OPERATOR_ABORTED"
+
+// operatorAbortEvent is the event recorded in the FSM transition audit log
+// when an operator aborts a transaction
+const operatorAbortEvent = "OPERATOR_ABORT"
+
+// abortByOperator fails the waiter of transaction uuid with OPERATOR_ABORTED,
+// releases its context, iterators and UUID entries and tells the chaincode to
+// give up on it. It returns false if the transaction is not in flight on this
+// handler.
+func (handler *Handler) abortByOperator(uuid string, reason string) bool {
+	payload := []byte(fmt.Sprintf("%s: %s", OperatorAborted, reason))
+
+	handler.Lock()
+	tctx := handler.txCtxs[uuid]
+	_, pending := handler.uuidMap[uuid]
+	if tctx == nil && !pending {
+		handler.Unlock()
+		return false
+	}
+	if tctx != nil {
+		for _, v := range tctx.rangeQueryIteratorMap {
+			v.Close()
+		}
+		//a response may already be waiting to be picked up
+		tctx.respond(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: uuid})
+		delete(handler.txCtxs, uuid)
+	}
+	handler.releaseUUIDEntry(uuid)
+	delete(handler.isTransaction, uuid)
+	handler.Unlock()
+
+	handler.logTx(uuid).Warning("Aborted by operator: %s", reason)
+	if handler.chaincodeSupport != nil {
+		state := handler.FSM.Current()
+		handler.chaincodeSupport.transitions.Record(fsmaudit.ChaincodeHandler, handler.traceChaincodeName(), uuid, operatorAbortEvent, state, state, fmt.Errorf("%s", payload))
+	}
+
+	abortMsg := handler.errorMessage(&pb.ChaincodeMessage{Uuid: uuid}, pb.ChaincodeError_ABORTED, fmt.Errorf("%s", payload), nil)
+	if err := handler.serialSend(abortMsg); err != nil {
+		handler.logTx(uuid).Error("Error sending abort: %s", err)
+	}
+	return true
+}
+
+// AbortTransaction is the last resort for a stuck transaction: it aborts
+// transaction uuid on the chaincode executing it and, if restart is set, stops
+// the chaincode container so that the next transaction launches it again. It
+// returns the name of the chaincode.
+func (chaincodeSupport *ChaincodeSupport) AbortTransaction(context context.Context, uuid string, reason string, restart bool) (string, error) {
+	chaincodeSupport.handlerMap.RLock()
+	handlers := make([]*Handler, 0, len(chaincodeSupport.handlerMap.chaincodeMap))
+	for _, handler := range chaincodeSupport.handlerMap.chaincodeMap {
+		handlers = append(handlers, handler)
+	}
+	chaincodeSupport.handlerMap.RUnlock()
+
+	for _, handler := range handlers {
+		if !handler.abortByOperator(uuid, reason) {
+			continue
+		}
+		if !restart {
+			return handler.traceChaincodeName(), nil
+		}
+		chaincodeLog.Warning("Restarting chaincode %s after aborting transaction %s", handler.traceChaincodeName(), uuid)
+		if err := chaincodeSupport.StopChaincode(context, handler.ChaincodeID); err != nil {
+			return handler.traceChaincodeName(), fmt.Errorf("Transaction %s aborted but the chaincode was not restarted: %s", uuid, err)
+		}
+		return handler.traceChaincodeName(), nil
+	}
+	return "", fmt.Errorf("Transaction %s is not in flight", uuid)
+}
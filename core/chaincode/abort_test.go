@@ -0,0 +1,80 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/hyperledger/fabric/core/fsmaudit"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestAbortTransaction(t *testing.T) {
+	chaincodeSupport := &ChaincodeSupport{
+		handlerMap:  &handlerMap{chaincodeMap: make(map[string]*Handler)},
+		transitions: fsmaudit.NewRecorder(10),
+	}
+	stream := newMockChaincodeStream()
+	handler := newTestHandler(stream)
+	handler.chaincodeSupport = chaincodeSupport
+	handler.ChaincodeID = &pb.ChaincodeID{Name: "stuck"}
+	handler.protocolVersion = pb.ChaincodeProtocolV6
+	chaincodeSupport.handlerMap.chaincodeMap["stuck"] = handler
+
+	txctx, err := handler.createTxContext("tx1", nil)
+	if err != nil {
+		t.Fatalf("Error creating tx context: %s", err)
+	}
+	handler.createUUIDEntry("tx1")
+
+	if _, err = chaincodeSupport.AbortTransaction(context.Background(), "unknown", "stuck", false); err == nil {
+		t.Fatal("Expected an error aborting a transaction that is not in flight")
+	}
+
+	name, err := chaincodeSupport.AbortTransaction(context.Background(), "tx1", "stuck", false)
+	if err != nil {
+		t.Fatalf("Error aborting transaction: %s", err)
+	}
+	if name != "stuck" {
+		t.Fatalf("Expected the transaction to be found on chaincode stuck, got %q", name)
+	}
+
+	msg := waitForNotification(t, txctx.responseNotifier)
+	if msg.Type != pb.ChaincodeMessage_ERROR || !strings.HasPrefix(string(msg.Payload), OperatorAborted) {
+		t.Fatalf("Expected %s error for the waiter, got %s %s", OperatorAborted, msg.Type, msg.Payload)
+	}
+	if handler.getTxContext("tx1") != nil || !handler.createUUIDEntry("tx1") {
+		t.Fatal("Expected the transaction context and UUID entry to be released")
+	}
+	if msg = waitForNotification(t, stream.sendCh); msg.Type != pb.ChaincodeMessage_ERROR || msg.Uuid != "tx1" {
+		t.Fatalf("Expected ERROR for tx1 sent to the chaincode, got %s for %s", msg.Type, msg.Uuid)
+	}
+	if chaincodeErr := pb.ChaincodeErrorFromPayload(handler.protocolVersion, msg.Payload); chaincodeErr.Code != pb.ChaincodeError_ABORTED {
+		t.Fatalf("Expected %s error sent to the chaincode, got %v", pb.ChaincodeError_ABORTED, chaincodeErr)
+	}
+
+	transitions := chaincodeSupport.FSMTransitions(&pb.FSMTransitionsRequest{Uuid: "tx1"})
+	if len(transitions) != 1 || transitions[0].Event != operatorAbortEvent {
+		t.Fatalf("Expected the abort to be recorded in the audit log, got %v", transitions)
+	}
+}
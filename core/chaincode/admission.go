@@ -0,0 +1,243 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// QueueFullError is returned for a transaction refused because its chaincode
+// executes as many transactions as it admits at once and no more can wait
+// for their turn
+type QueueFullError struct {
+	Chaincode                 string
+	MaxConcurrentTransactions int
+	Policy                    pb.ChaincodeConcurrency_QueueingPolicy
+}
+
+func (e *QueueFullError) Error() string {
+	return fmt.Sprintf("Queue full: chaincode %s executes %d transactions at once (policy %s)", e.Chaincode, e.MaxConcurrentTransactions, e.Policy)
+}
+
+// getDefaultConcurrency returns the admission of the transactions of
+// chaincodes whose deployment spec sets none, from chaincode.concurrency in
+// core.yaml
+func getDefaultConcurrency() *pb.ChaincodeConcurrency {
+	concurrency := &pb.ChaincodeConcurrency{
+		MaxConcurrentTransactions: int32(viper.GetInt("chaincode.concurrency.maxTransactions")),
+		Policy:                    pb.ChaincodeConcurrency_FIFO,
+		QueueSize:                 int32(viper.GetInt("chaincode.concurrency.queueSize")),
+	}
+	if policy := viper.GetString("chaincode.concurrency.policy"); policy != "" {
+		if value, ok := pb.ChaincodeConcurrency_QueueingPolicy_value[strings.ToUpper(policy)]; ok {
+			concurrency.Policy = pb.ChaincodeConcurrency_QueueingPolicy(value)
+		} else {
+			chaincodeLog.Error(fmt.Sprintf("Ignoring unknown chaincode.concurrency.policy %s", policy))
+		}
+	}
+	return concurrency
+}
+
+// concurrencyForSpec returns the admission of the transactions of a chaincode
+// deployed with spec, the settings of the spec taking precedence over the
+// defaults
+func concurrencyForSpec(spec *pb.ChaincodeSpec, defaults *pb.ChaincodeConcurrency) *pb.ChaincodeConcurrency {
+	concurrency := *defaults
+	if c := spec.GetConcurrency(); c != nil {
+		if c.MaxConcurrentTransactions != 0 {
+			concurrency.MaxConcurrentTransactions = c.MaxConcurrentTransactions
+		}
+		if c.Policy != pb.ChaincodeConcurrency_DEFAULT {
+			concurrency.Policy = c.Policy
+		}
+		if c.QueueSize != 0 {
+			concurrency.QueueSize = c.QueueSize
+		}
+	}
+	if concurrency.Policy == pb.ChaincodeConcurrency_DEFAULT {
+		concurrency.Policy = pb.ChaincodeConcurrency_FIFO
+	}
+	return &concurrency
+}
+
+// callerPriority returns the priority of the caller of tx under the priority
+// policy, from chaincode.concurrency.priorities in core.yaml. The caller is
+// the enrollment ID the certificate of tx is issued to.
+func callerPriority(tx *pb.Transaction) int {
+	if tx == nil || len(tx.Cert) == 0 {
+		return 0
+	}
+	cert, err := x509.ParseCertificate(tx.Cert)
+	if err != nil {
+		return 0
+	}
+	// viper lower cases the keys of maps
+	priorities := viper.GetStringMap("chaincode.concurrency.priorities")
+	return cast.ToInt(priorities[strings.ToLower(cert.Subject.CommonName)])
+}
+
+type admissionTicket struct {
+	priority int
+	ready    chan struct{}
+}
+
+// admissionQueue bounds the transactions a chaincode executes at once, those
+// arriving beyond the bound wait for their turn or are refused according to
+// the policy of the chaincode
+type admissionQueue struct {
+	sync.Mutex
+	chaincode   string
+	concurrency *pb.ChaincodeConcurrency
+	active      int
+	waiting     []*admissionTicket
+}
+
+func newAdmissionQueue(chaincode string, concurrency *pb.ChaincodeConcurrency) *admissionQueue {
+	return &admissionQueue{chaincode: chaincode, concurrency: concurrency}
+}
+
+// configure replaces the settings of the queue, admitting the transactions
+// waiting that a raised bound lets in
+func (q *admissionQueue) configure(concurrency *pb.ChaincodeConcurrency) {
+	q.Lock()
+	defer q.Unlock()
+	q.concurrency = concurrency
+	for len(q.waiting) > 0 && (concurrency.MaxConcurrentTransactions <= 0 || q.active < int(concurrency.MaxConcurrentTransactions)) {
+		q.active++
+		q.admitNext()
+	}
+}
+
+// acquire admits tx, waiting for its turn until deadline if the policy lets
+// it. The returned function ends the transaction and must be called once it
+// has completed.
+func (q *admissionQueue) acquire(ctxt context.Context, deadline time.Time, tx *pb.Transaction) (func(), error) {
+	q.Lock()
+	max := int(q.concurrency.MaxConcurrentTransactions)
+	if max <= 0 {
+		q.Unlock()
+		return func() {}, nil
+	}
+	if q.active < max && len(q.waiting) == 0 {
+		q.active++
+		q.Unlock()
+		return q.release, nil
+	}
+	if q.concurrency.Policy == pb.ChaincodeConcurrency_REJECT || (q.concurrency.QueueSize > 0 && len(q.waiting) >= int(q.concurrency.QueueSize)) {
+		policy := q.concurrency.Policy
+		q.Unlock()
+		return nil, &QueueFullError{Chaincode: q.chaincode, MaxConcurrentTransactions: max, Policy: policy}
+	}
+	ticket := &admissionTicket{ready: make(chan struct{})}
+	if q.concurrency.Policy == pb.ChaincodeConcurrency_PRIORITY {
+		ticket.priority = callerPriority(tx)
+	}
+	q.waiting = append(q.waiting, ticket)
+	q.Unlock()
+
+	var err error
+	select {
+	case <-ticket.ready:
+		return q.release, nil
+	case <-time.After(deadline.Sub(time.Now())):
+		err = fmt.Errorf("Timeout expired while waiting to execute on chaincode %s", q.chaincode)
+	case <-ctxt.Done():
+		err = fmt.Errorf("Transaction canceled: %s", ctxt.Err())
+	}
+
+	q.Lock()
+	defer q.Unlock()
+	for i, t := range q.waiting {
+		if t == ticket {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			return nil, err
+		}
+	}
+	// admitted while giving up
+	return q.release, nil
+}
+
+// release ends a transaction, handing its turn to the next one waiting
+func (q *admissionQueue) release() {
+	q.Lock()
+	defer q.Unlock()
+	if len(q.waiting) > 0 {
+		q.admitNext()
+	} else {
+		q.active--
+	}
+}
+
+// admitNext lets in the transaction waiting next under the policy, the lock
+// must be held
+func (q *admissionQueue) admitNext() {
+	next := 0
+	if q.concurrency.Policy == pb.ChaincodeConcurrency_PRIORITY {
+		for i, t := range q.waiting {
+			if t.priority > q.waiting[next].priority {
+				next = i
+			}
+		}
+	}
+	close(q.waiting[next].ready)
+	q.waiting = append(q.waiting[:next], q.waiting[next+1:]...)
+}
+
+// setConcurrency records the admission of the transactions of chaincode
+func (chaincodeSupport *ChaincodeSupport) setConcurrency(chaincode string, spec *pb.ChaincodeSpec) {
+	if chaincodeSupport.defaultConcurrency == nil {
+		return
+	}
+	concurrency := concurrencyForSpec(spec, chaincodeSupport.defaultConcurrency)
+	chaincodeSupport.handlerMap.Lock()
+	defer chaincodeSupport.handlerMap.Unlock()
+	if q, ok := chaincodeSupport.handlerMap.admissionMap[chaincode]; ok {
+		q.configure(concurrency)
+		return
+	}
+	chaincodeSupport.handlerMap.admissionMap[chaincode] = newAdmissionQueue(chaincode, concurrency)
+}
+
+// admit admits a transaction of chaincode before it is sent to the
+// chaincode, see admissionQueue.acquire. Transactions invoked by chaincode,
+// which have no tx, were admitted with the transaction invoking them.
+func (chaincodeSupport *ChaincodeSupport) admit(ctxt context.Context, chaincode string, deadline time.Time, tx *pb.Transaction) (func(), error) {
+	if tx == nil || chaincodeSupport.defaultConcurrency == nil {
+		return func() {}, nil
+	}
+	chaincodeSupport.handlerMap.Lock()
+	q, ok := chaincodeSupport.handlerMap.admissionMap[chaincode]
+	if !ok {
+		q = newAdmissionQueue(chaincode, chaincodeSupport.defaultConcurrency)
+		chaincodeSupport.handlerMap.admissionMap[chaincode] = q
+	}
+	chaincodeSupport.handlerMap.Unlock()
+	return q.acquire(ctxt, deadline, tx)
+}
@@ -0,0 +1,151 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+)
+
+func newCallerTransaction(t *testing.T, enrollmentID string) *pb.Transaction {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: enrollmentID}, NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+	cert, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %s", err)
+	}
+	return &pb.Transaction{Cert: cert}
+}
+
+func TestAdmissionQueueRejectsBeyondBound(t *testing.T) {
+	q := newAdmissionQueue("mycc", &pb.ChaincodeConcurrency{MaxConcurrentTransactions: 1, Policy: pb.ChaincodeConcurrency_REJECT})
+	deadline := time.Now().Add(time.Second)
+	release, err := q.acquire(context.Background(), deadline, &pb.Transaction{})
+	if err != nil {
+		t.Fatalf("Expected the first transaction to be admitted: %s", err)
+	}
+	if _, err = q.acquire(context.Background(), deadline, &pb.Transaction{}); err == nil {
+		t.Fatal("Expected the second transaction to be rejected")
+	} else if _, ok := err.(*QueueFullError); !ok {
+		t.Fatalf("Expected a QueueFullError, got %s", err)
+	}
+	release()
+	if _, err = q.acquire(context.Background(), deadline, &pb.Transaction{}); err != nil {
+		t.Fatalf("Expected a transaction to be admitted once the first completed: %s", err)
+	}
+}
+
+func TestAdmissionQueueFIFO(t *testing.T) {
+	q := newAdmissionQueue("mycc", &pb.ChaincodeConcurrency{MaxConcurrentTransactions: 1, Policy: pb.ChaincodeConcurrency_FIFO, QueueSize: 2})
+	deadline := time.Now().Add(10 * time.Second)
+	release, _ := q.acquire(context.Background(), deadline, &pb.Transaction{})
+
+	admitted := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func(i int) {
+			release, err := q.acquire(context.Background(), deadline, &pb.Transaction{})
+			if err != nil {
+				t.Errorf("Error admitting transaction %d: %s", i, err)
+				return
+			}
+			admitted <- i
+			release()
+		}(i)
+		waitForQueued(t, q, i+1)
+	}
+	if _, err := q.acquire(context.Background(), deadline, &pb.Transaction{}); err == nil {
+		t.Fatal("Expected a transaction to be rejected with the queue full")
+	}
+	release()
+	for i := 0; i < 2; i++ {
+		if next := <-admitted; next != i {
+			t.Fatalf("Expected transaction %d to be admitted, got %d", i, next)
+		}
+	}
+}
+
+func TestAdmissionQueuePriorityByCaller(t *testing.T) {
+	viper.Set("chaincode.concurrency.priorities", map[string]interface{}{"admin": 10})
+	defer viper.Set("chaincode.concurrency.priorities", nil)
+
+	q := newAdmissionQueue("mycc", &pb.ChaincodeConcurrency{MaxConcurrentTransactions: 1, Policy: pb.ChaincodeConcurrency_PRIORITY})
+	deadline := time.Now().Add(10 * time.Second)
+	release, _ := q.acquire(context.Background(), deadline, &pb.Transaction{})
+
+	admitted := make(chan string, 2)
+	for i, caller := range []string{"user", "admin"} {
+		tx := newCallerTransaction(t, caller)
+		go func(caller string) {
+			release, err := q.acquire(context.Background(), deadline, tx)
+			if err != nil {
+				t.Errorf("Error admitting transaction of %s: %s", caller, err)
+				return
+			}
+			admitted <- caller
+			release()
+		}(caller)
+		waitForQueued(t, q, i+1)
+	}
+	release()
+	if first := <-admitted; first != "admin" {
+		t.Fatalf("Expected the transaction of the caller with the highest priority first, got %s", first)
+	}
+	<-admitted
+}
+
+func TestAdmissionQueueTimeout(t *testing.T) {
+	q := newAdmissionQueue("mycc", &pb.ChaincodeConcurrency{MaxConcurrentTransactions: 1, Policy: pb.ChaincodeConcurrency_FIFO})
+	release, _ := q.acquire(context.Background(), time.Now().Add(time.Second), &pb.Transaction{})
+	defer release()
+	if _, err := q.acquire(context.Background(), time.Now().Add(50*time.Millisecond), &pb.Transaction{}); err == nil {
+		t.Fatal("Expected the transaction to time out waiting for its turn")
+	}
+	q.Lock()
+	defer q.Unlock()
+	if len(q.waiting) != 0 {
+		t.Fatal("Expected the transaction that timed out to leave the queue")
+	}
+}
+
+func waitForQueued(t *testing.T, q *admissionQueue, n int) {
+	for i := 0; i < 100; i++ {
+		q.Lock()
+		queued := len(q.waiting)
+		q.Unlock()
+		if queued == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected %d transactions to be queued", n)
+}
@@ -0,0 +1,229 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package analysis scans the source of Go chaincode at deploy time for
+// constructs that make execution nondeterministic, such as randomness, the
+// wall clock, goroutines writing state or network calls. Each analyzer's
+// findings are rejected, warned about or ignored according to a policy.
+package analysis
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/op/go-logging"
+	"github.com/spf13/viper"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+var analysisLogger = logging.MustGetLogger("analysis")
+
+// Finding is a construct reported by an analyzer
+type Finding struct {
+	Analyzer string
+	Position token.Position
+	Message  string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s (%s)", f.Position, f.Message, f.Analyzer)
+}
+
+// Analyzer inspects the parsed files of a chaincode package
+type Analyzer interface {
+	// Name identifies the analyzer in the policy
+	Name() string
+	Analyze(fset *token.FileSet, files []*ast.File) []Finding
+}
+
+var registry = struct {
+	sync.RWMutex
+	analyzers map[string]Analyzer
+}{analyzers: make(map[string]Analyzer)}
+
+// Register adds an analyzer to those run on deployed chaincode, replacing
+// any analyzer registered under the same name
+func Register(a Analyzer) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.analyzers[a.Name()] = a
+}
+
+// registered returns the registered analyzers sorted by name
+func registered() []Analyzer {
+	registry.RLock()
+	defer registry.RUnlock()
+	names := make([]string, 0, len(registry.analyzers))
+	for name := range registry.analyzers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	analyzers := make([]Analyzer, len(names))
+	for i, name := range names {
+		analyzers[i] = registry.analyzers[name]
+	}
+	return analyzers
+}
+
+// Action is what is done with the findings of an analyzer
+type Action string
+
+const (
+	// Reject fails the deployment
+	Reject Action = "reject"
+	// Warn logs the findings and lets the deployment proceed
+	Warn Action = "warn"
+	// Ignore skips the analyzer
+	Ignore Action = "ignore"
+)
+
+// Policy maps analyzer names to actions, analyzers not in the policy warn
+type Policy map[string]Action
+
+func (p Policy) action(analyzer string) Action {
+	if action, ok := p[analyzer]; ok {
+		return action
+	}
+	return Warn
+}
+
+// getPolicy reads the policy from chaincode.analysis.policy
+func getPolicy() (Policy, error) {
+	policy := make(Policy)
+	for name, value := range viper.GetStringMapString("chaincode.analysis.policy") {
+		switch action := Action(strings.ToLower(value)); action {
+		case Reject, Warn, Ignore:
+			policy[name] = action
+		default:
+			return nil, fmt.Errorf("Invalid action %q for analyzer %s, expected %s, %s or %s", value, name, Reject, Warn, Ignore)
+		}
+	}
+	return policy, nil
+}
+
+// Analyze parses the Go source files of a chaincode and runs the analyzers the
+// policy does not ignore. Findings of analyzers whose action is Reject are
+// returned as an error, the others are returned for the caller to report.
+func Analyze(fset *token.FileSet, files []*ast.File, policy Policy) ([]Finding, error) {
+	var warnings, rejected []Finding
+	for _, a := range registered() {
+		action := policy.action(a.Name())
+		if action == Ignore {
+			continue
+		}
+		findings := a.Analyze(fset, files)
+		if action == Reject {
+			rejected = append(rejected, findings...)
+		} else {
+			warnings = append(warnings, findings...)
+		}
+	}
+	if len(rejected) > 0 {
+		msgs := make([]string, len(rejected))
+		for i, f := range rejected {
+			msgs[i] = f.String()
+		}
+		return warnings, fmt.Errorf("Chaincode rejected by static analysis:\n%s", strings.Join(msgs, "\n"))
+	}
+	return warnings, nil
+}
+
+// CheckDeployment analyzes the source in the code package of a deployment
+// according to the chaincode.analysis configuration, returning an error if the
+// chaincode is rejected. Warnings are logged.
+func CheckDeployment(cds *pb.ChaincodeDeploymentSpec) error {
+	if !viper.GetBool("chaincode.analysis.enabled") {
+		return nil
+	}
+	spec := cds.ChaincodeSpec
+	if spec == nil || spec.ChaincodeID == nil || spec.Type != pb.ChaincodeSpec_GOLANG || len(cds.CodePackage) == 0 {
+		return nil
+	}
+	policy, err := getPolicy()
+	if err != nil {
+		return err
+	}
+	fset := token.NewFileSet()
+	files, err := parsePackage(fset, cds.CodePackage, chaincodeSourceDir(spec.ChaincodeID.Path))
+	if err != nil {
+		return fmt.Errorf("Error reading source of chaincode %s: %s", spec.ChaincodeID.Name, err)
+	}
+	warnings, err := Analyze(fset, files, policy)
+	for _, f := range warnings {
+		analysisLogger.Warning("Chaincode %s: %s", spec.ChaincodeID.Name, f)
+	}
+	return err
+}
+
+// chaincodeSourceDir returns the directory of the chaincode source within the
+// code package written by the golang platform
+func chaincodeSourceDir(path string) string {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "http://"), "https://")
+	return "src/" + strings.TrimSuffix(path, "/") + "/"
+}
+
+// parsePackage parses the Go files under dir in the gzipped tar code package,
+// leaving out tests and vendored dependencies
+func parsePackage(fset *token.FileSet, codePackage []byte, dir string) ([]*ast.File, error) {
+	gr, err := gzip.NewReader(bytes.NewReader(codePackage))
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	var files []*ast.File
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := hdr.Name
+		if !strings.HasPrefix(name, dir) || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") ||
+			strings.Contains(name[len(dir):], "vendor/") {
+			continue
+		}
+		src, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		file, err := parser.ParseFile(fset, name, src, 0)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no Go source found in %s", dir)
+	}
+	return files, nil
+}
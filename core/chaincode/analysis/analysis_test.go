@@ -0,0 +1,136 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package analysis
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+const nondeterministicChaincode = `package main
+
+import (
+	"math/rand"
+	t "time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+type SimpleChaincode struct{}
+
+func (s *SimpleChaincode) Invoke(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	go s.update(stub, t.Now().String())
+	go func() { stub.GetState("a") }()
+	return nil, stub.PutState("b", []byte(string(rand.Int())))
+}
+
+func (s *SimpleChaincode) update(stub *shim.ChaincodeStub, value string) {
+	save(stub, value)
+}
+
+func save(stub *shim.ChaincodeStub, value string) {
+	stub.PutState("a", []byte(value))
+}
+`
+
+func newCodePackage(t *testing.T, files map[string]string) []byte {
+	buf := bytes.NewBuffer(nil)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	for name, src := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Size: int64(len(src)), Mode: 0644}); err != nil {
+			t.Fatalf("Error writing header: %s", err)
+		}
+		tw.Write([]byte(src))
+	}
+	tw.Close()
+	gw.Close()
+	return buf.Bytes()
+}
+
+func TestAnalyzeFindings(t *testing.T) {
+	fset := token.NewFileSet()
+	pkg := newCodePackage(t, map[string]string{
+		"src/example/cc/cc.go":               nondeterministicChaincode,
+		"src/example/cc/cc_test.go":          "package main\nimport \"net\"\n",
+		"src/example/cc/vendor/lib/lib.go":   "package lib\nimport \"net\"\n",
+		"src/github.com/other/other/main.go": "package main\nimport \"net\"\n",
+	})
+	files, err := parsePackage(fset, pkg, chaincodeSourceDir("https://example/cc/"))
+	if err != nil {
+		t.Fatalf("Error parsing package: %s", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("Expected only the chaincode source to be parsed, got %d files", len(files))
+	}
+
+	warnings, err := Analyze(fset, files, Policy{})
+	if err != nil {
+		t.Fatalf("Expected warnings only, got %s", err)
+	}
+	counts := make(map[string]int)
+	for _, f := range warnings {
+		counts[f.Analyzer]++
+	}
+	expected := map[string]int{"randomness": 1, "time": 1, "goroutines": 1}
+	for name, count := range expected {
+		if counts[name] != count {
+			t.Errorf("Expected %d %s findings, got %d: %v", count, name, counts[name], warnings)
+		}
+	}
+	if len(warnings) != 3 {
+		t.Errorf("Expected 3 findings, got %v", warnings)
+	}
+
+	warnings, err = Analyze(fset, files, Policy{"time": Reject, "randomness": Ignore})
+	if err == nil || !strings.Contains(err.Error(), "time.Now") {
+		t.Fatalf("Expected chaincode to be rejected for time.Now, got %v", err)
+	}
+	if len(warnings) != 1 || warnings[0].Analyzer != "goroutines" {
+		t.Fatalf("Expected the goroutines finding as a warning, got %v", warnings)
+	}
+}
+
+func TestCheckDeploymentPolicy(t *testing.T) {
+	viper.Set("chaincode.analysis.enabled", true)
+	viper.Set("chaincode.analysis.policy", map[string]string{"randomness": "reject"})
+	defer viper.Set("chaincode.analysis.enabled", false)
+
+	cds := &pb.ChaincodeDeploymentSpec{
+		ChaincodeSpec: &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_GOLANG, ChaincodeID: &pb.ChaincodeID{Path: "example/cc", Name: "mycc"}},
+		CodePackage:   newCodePackage(t, map[string]string{"src/example/cc/cc.go": nondeterministicChaincode}),
+	}
+	if err := CheckDeployment(cds); err == nil {
+		t.Fatal("Expected chaincode using math/rand to be rejected")
+	}
+
+	viper.Set("chaincode.analysis.policy", map[string]string{"randomness": "sometimes"})
+	if err := CheckDeployment(cds); err == nil {
+		t.Fatal("Expected invalid policy action to fail")
+	}
+}
@@ -0,0 +1,176 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package analysis
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"strconv"
+)
+
+func init() {
+	Register(&importAnalyzer{name: "randomness", what: "randomness", paths: []string{"math/rand", "crypto/rand"}})
+	Register(&importAnalyzer{name: "network", what: "network access", paths: []string{"net", "net/http", "net/rpc", "net/smtp"}})
+	Register(timeAnalyzer{})
+	Register(goroutineAnalyzer{})
+}
+
+// importAnalyzer reports imports of packages whose use is nondeterministic
+type importAnalyzer struct {
+	name  string
+	what  string
+	paths []string
+}
+
+func (a *importAnalyzer) Name() string {
+	return a.name
+}
+
+func (a *importAnalyzer) Analyze(fset *token.FileSet, files []*ast.File) []Finding {
+	var findings []Finding
+	for _, file := range files {
+		for _, spec := range file.Imports {
+			path, _ := strconv.Unquote(spec.Path.Value)
+			for _, banned := range a.paths {
+				if path == banned {
+					findings = append(findings, Finding{Analyzer: a.name, Position: fset.Position(spec.Pos()), Message: fmt.Sprintf("import of %s, %s is nondeterministic", path, a.what)})
+				}
+			}
+		}
+	}
+	return findings
+}
+
+// importName returns the name under which file imports path, or "" if it does not
+func importName(file *ast.File, path string) string {
+	for _, spec := range file.Imports {
+		if p, _ := strconv.Unquote(spec.Path.Value); p != path {
+			continue
+		}
+		if spec.Name != nil {
+			return spec.Name.Name
+		}
+		return path
+	}
+	return ""
+}
+
+// timeAnalyzer reports reads of the wall clock, which differs between peers
+type timeAnalyzer struct{}
+
+func (timeAnalyzer) Name() string {
+	return "time"
+}
+
+func (timeAnalyzer) Analyze(fset *token.FileSet, files []*ast.File) []Finding {
+	var findings []Finding
+	for _, file := range files {
+		pkg := importName(file, "time")
+		if pkg == "" || pkg == "_" {
+			continue
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			sel, ok := n.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			if id, ok := sel.X.(*ast.Ident); ok && id.Name == pkg && (sel.Sel.Name == "Now" || sel.Sel.Name == "Since") {
+				findings = append(findings, Finding{Analyzer: "time", Position: fset.Position(sel.Pos()), Message: fmt.Sprintf("call of time.%s, the clock differs between peers", sel.Sel.Name)})
+			}
+			return true
+		})
+	}
+	return findings
+}
+
+// stateWriters are the ChaincodeStub methods that modify state
+var stateWriters = map[string]bool{
+	"PutState":    true,
+	"DelState":    true,
+	"CreateTable": true,
+	"DeleteTable": true,
+	"InsertRow":   true,
+	"ReplaceRow":  true,
+	"DeleteRow":   true,
+}
+
+// goroutineAnalyzer reports go statements that write state, the order of
+// their writes is not deterministic. Functions of the chaincode started as
+// goroutines are followed, as are the functions they call in turn.
+type goroutineAnalyzer struct{}
+
+func (goroutineAnalyzer) Name() string {
+	return "goroutines"
+}
+
+func (goroutineAnalyzer) Analyze(fset *token.FileSet, files []*ast.File) []Finding {
+	funcs := make(map[string]*ast.FuncDecl)
+	for _, file := range files {
+		for _, decl := range file.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Body != nil {
+				funcs[fn.Name.Name] = fn
+			}
+		}
+	}
+	var findings []Finding
+	for _, file := range files {
+		ast.Inspect(file, func(n ast.Node) bool {
+			stmt, ok := n.(*ast.GoStmt)
+			if ok && writesState(stmt.Call, funcs, make(map[string]bool)) {
+				findings = append(findings, Finding{Analyzer: "goroutines", Position: fset.Position(stmt.Pos()), Message: "goroutine writes state, the order of its writes is nondeterministic"})
+			}
+			return true
+		})
+	}
+	return findings
+}
+
+// writesState returns true if node calls a state writer, directly or through
+// the chaincode's own functions
+func writesState(node ast.Node, funcs map[string]*ast.FuncDecl, visited map[string]bool) bool {
+	found := false
+	ast.Inspect(node, func(n ast.Node) bool {
+		if found {
+			return false
+		}
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		var name string
+		switch fn := call.Fun.(type) {
+		case *ast.Ident:
+			name = fn.Name
+		case *ast.SelectorExpr:
+			name = fn.Sel.Name
+			if stateWriters[name] {
+				found = true
+				return false
+			}
+		}
+		if decl, ok := funcs[name]; ok && !visited[name] {
+			visited[name] = true
+			found = writesState(decl.Body, funcs, visited)
+		}
+		return !found
+	})
+	return found
+}
@@ -0,0 +1,169 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// respond hands msg to whoever waits for the response of the transaction,
+// unless it was answered already. Executions started with ExecuteAsync are
+// completed on a goroutine of their own, as the handler lock may be held.
+func (tctx *transactionContext) respond(msg *pb.ChaincodeMessage) bool {
+	select {
+	case tctx.responseNotifier <- msg:
+	default:
+		return false
+	}
+	if tctx.onResponse != nil {
+		go tctx.onResponse(msg)
+	}
+	return true
+}
+
+// PendingExecution is the handle of a transaction or query started with
+// ExecuteAsync. Its outcome can be polled with Poll, waited for on Done or
+// handed to the callbacks registered with OnComplete.
+type PendingExecution struct {
+	Uuid string
+
+	lock      sync.Mutex
+	done      chan struct{}
+	resp      *pb.ChaincodeMessage
+	err       error
+	callbacks []func(*pb.ChaincodeMessage, error)
+	timer     *time.Timer
+	finish    sync.Once
+	cancel    func(error)
+}
+
+// Done returns a channel closed once the execution has completed
+func (p *PendingExecution) Done() <-chan struct{} {
+	return p.done
+}
+
+// Poll returns the response of the chaincode and the error the execution
+// failed with, done is false while the execution is pending
+func (p *PendingExecution) Poll() (resp *pb.ChaincodeMessage, done bool, err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	select {
+	case <-p.done:
+		return p.resp, true, p.err
+	default:
+		return nil, false, nil
+	}
+}
+
+// OnComplete registers callback to be called with the outcome of the
+// execution, right away if it has completed already
+func (p *PendingExecution) OnComplete(callback func(resp *pb.ChaincodeMessage, err error)) {
+	p.lock.Lock()
+	select {
+	case <-p.done:
+		p.lock.Unlock()
+		callback(p.resp, p.err)
+	default:
+		p.callbacks = append(p.callbacks, callback)
+		p.lock.Unlock()
+	}
+}
+
+// Cancel gives up on the execution, which completes with an error unless it
+// has completed already
+func (p *PendingExecution) Cancel() {
+	p.cancel(fmt.Errorf("Transaction canceled"))
+}
+
+func (p *PendingExecution) complete(resp *pb.ChaincodeMessage, err error) {
+	p.lock.Lock()
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	p.resp, p.err = resp, err
+	callbacks := p.callbacks
+	p.callbacks = nil
+	close(p.done)
+	p.lock.Unlock()
+	for _, callback := range callbacks {
+		callback(resp, err)
+	}
+}
+
+// ExecuteAsync starts the execution of a transaction or query like Execute
+// but returns as soon as msg is sent to the chaincode, once admitted by the
+// concurrency policy of the chaincode. No goroutine waits for the response:
+// the execution is completed by the chaincode's response, the expiry of the
+// deadline or Cancel. The cancellation of ctxt is not watched,
+// only its deadline.
+func (chaincodeSupport *ChaincodeSupport) ExecuteAsync(ctxt context.Context, chaincode string, msg *pb.ChaincodeMessage, timeout time.Duration, tx *pb.Transaction) (*PendingExecution, error) {
+	handler, deadline, err := chaincodeSupport.prepareExecute(ctxt, chaincode, msg, timeout)
+	if err != nil {
+		return nil, err
+	}
+	release, err := chaincodeSupport.admit(ctxt, handler.ChaincodeID.Name, deadline, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := &PendingExecution{Uuid: msg.Uuid, done: make(chan struct{})}
+	finish := func(resp *pb.ChaincodeMessage, err error, abort bool) {
+		pending.finish.Do(func() {
+			if abort {
+				handler.abortTransaction(msg, err)
+			}
+			handler.finishExecuteSpan(msg.Uuid, err)
+			handler.deleteTxContext(msg.Uuid)
+			release()
+			pending.complete(resp, err)
+		})
+	}
+	pending.cancel = func(err error) { finish(nil, err, true) }
+	onResponse := func(resp *pb.ChaincodeMessage) {
+		var err error
+		if resp.Type == pb.ChaincodeMessage_ERROR || resp.Type == pb.ChaincodeMessage_QUERY_ERROR {
+			err = fmt.Errorf("%s", resp.Payload)
+		}
+		finish(resp, err, false)
+	}
+	if _, err = handler.sendExecuteMessageAsync(msg, tx, onResponse); err != nil {
+		release()
+		return nil, fmt.Errorf("Error sending %s: %s", msg.Type.String(), err)
+	}
+
+	timer := time.AfterFunc(deadline.Sub(time.Now()), func() {
+		finish(nil, fmt.Errorf("Timeout expired while executing transaction"), true)
+	})
+	pending.lock.Lock()
+	select {
+	case <-pending.done:
+		timer.Stop()
+	default:
+		pending.timer = timer
+	}
+	pending.lock.Unlock()
+	return pending, nil
+}
@@ -0,0 +1,152 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos"
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+)
+
+type blockingChaincode struct {
+	kvChaincode
+	release chan struct{}
+}
+
+func (cc *blockingChaincode) Invoke(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	if function == "block" {
+		<-cc.release
+	}
+	return []byte(function), nil
+}
+
+func TestExecuteAsync(t *testing.T) {
+	viper.Set("peer.fileSystemPath", "/var/hyperledger/test/tmpdb")
+	getPeerEndpoint := func() (*pb.PeerEndpoint, error) {
+		return &pb.PeerEndpoint{ID: &pb.PeerID{Name: "testpeer"}, Address: "0.0.0.0:40303"}, nil
+	}
+	chain := NewChaincodeSupport(DefaultChain, getPeerEndpoint, false, 10*time.Second, nil)
+
+	cc := &blockingChaincode{release: make(chan struct{})}
+	if err := RegisterSystemChaincode(&SystemChaincode{Name: "asyncsyscc", Chaincode: cc}); err != nil {
+		t.Fatalf("Error registering system chaincode: %s", err)
+	}
+	defer chain.StopChaincode(context.Background(), &pb.ChaincodeID{Name: "asyncsyscc"})
+	defer close(cc.release)
+
+	execute := func(function string, timeout time.Duration) *PendingExecution {
+		tx := newBatchTransaction(t, "asyncsyscc", pb.Transaction_CHAINCODE_INVOKE, function)
+		cID, cMsg, err := chain.LaunchChaincode(context.Background(), tx)
+		if err != nil {
+			t.Fatalf("Error launching chaincode: %s", err)
+		}
+		msg, _ := createTransactionMessage(tx.Uuid, cMsg)
+		pending, err := chain.ExecuteAsync(context.Background(), cID.Name, msg, timeout, tx)
+		if err != nil {
+			t.Fatalf("Error starting transaction: %s", err)
+		}
+		return pending
+	}
+
+	// A transaction completes through the callbacks registered while pending
+	pending := execute("echo", 10*time.Second)
+	completed := make(chan *pb.ChaincodeMessage, 1)
+	pending.OnComplete(func(resp *pb.ChaincodeMessage, err error) {
+		if err != nil {
+			t.Errorf("Error executing transaction: %s", err)
+		}
+		completed <- resp
+	})
+	select {
+	case resp := <-completed:
+		if string(resp.Payload) != "echo" {
+			t.Fatalf("Expected the chaincode's response, got %s", resp.Payload)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expected the transaction to complete")
+	}
+	if resp, done, err := pending.Poll(); !done || err != nil || string(resp.Payload) != "echo" {
+		t.Fatalf("Expected Poll to return the response, got %v, %t, %v", resp, done, err)
+	}
+
+	// A transaction the chaincode does not answer in time fails
+	pending = execute("block", 100*time.Millisecond)
+	if _, done, _ := pending.Poll(); done {
+		t.Fatal("Expected the transaction to be pending")
+	}
+	select {
+	case <-pending.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expected the transaction to time out")
+	}
+	if _, _, err := pending.Poll(); err == nil {
+		t.Fatal("Expected the transaction to fail with a timeout")
+	}
+	called := false
+	pending.OnComplete(func(resp *pb.ChaincodeMessage, err error) { called = err != nil })
+	if !called {
+		t.Fatal("Expected a callback registered after completion to be called right away")
+	}
+	pending.Cancel()
+}
+
+func TestQueryAsync(t *testing.T) {
+	viper.Set("peer.fileSystemPath", "/var/hyperledger/test/tmpdb")
+	getPeerEndpoint := func() (*pb.PeerEndpoint, error) {
+		return &pb.PeerEndpoint{ID: &pb.PeerID{Name: "testpeer"}, Address: "0.0.0.0:40303"}, nil
+	}
+	chain := NewChaincodeSupport(DefaultChain, getPeerEndpoint, false, 10*time.Second, nil)
+
+	if err := RegisterSystemChaincode(&SystemChaincode{Name: "asyncquerysyscc", Chaincode: &kvChaincode{}}); err != nil {
+		t.Fatalf("Error registering system chaincode: %s", err)
+	}
+	defer chain.StopChaincode(context.Background(), &pb.ChaincodeID{Name: "asyncquerysyscc"})
+
+	if _, err := QueryAsync(context.Background(), chain, newBatchTransaction(t, "asyncquerysyscc", pb.Transaction_CHAINCODE_INVOKE, "put", "a", "1")); err == nil {
+		t.Fatal("Expected an invoke to be refused")
+	}
+
+	pending, err := QueryAsync(context.Background(), chain, newBatchTransaction(t, "asyncquerysyscc", pb.Transaction_CHAINCODE_QUERY, "get", "a"))
+	if err != nil {
+		t.Fatalf("Error starting query: %s", err)
+	}
+	select {
+	case <-pending.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expected the query to complete")
+	}
+	if resp, done, err := pending.Poll(); !done || err != nil || resp.Type != pb.ChaincodeMessage_QUERY_COMPLETED {
+		t.Fatalf("Expected Poll to return the query response, got %v, %t, %v", resp, done, err)
+	}
+
+	// A query the chaincode fails completes with its error
+	pending, err = QueryAsync(context.Background(), chain, newBatchTransaction(t, "asyncquerysyscc", pb.Transaction_CHAINCODE_QUERY, "get"))
+	if err != nil {
+		t.Fatalf("Error starting query: %s", err)
+	}
+	<-pending.Done()
+	if _, _, err = pending.Poll(); err == nil {
+		t.Fatal("Expected the query to fail")
+	}
+}
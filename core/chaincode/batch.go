@@ -0,0 +1,231 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/hyperledger/fabric/core/ledger"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// BatchResult is the outcome of a transaction executed by ExecuteBatch
+type BatchResult struct {
+	// Response is the COMPLETED or QUERY_COMPLETED message of the chaincode,
+	// nil if the transaction failed
+	Response *pb.ChaincodeMessage
+	Err      error
+}
+
+// batchItem is a transaction of a batch ready to be sent to its chaincode
+type batchItem struct {
+	index     int
+	tx        *pb.Transaction
+	chaincode string
+	msg       *pb.ChaincodeMessage
+	// declared by the transaction, nil if it declared none
+	hints *pb.StateKeyHints
+}
+
+// ExecuteBatch executes the invoke and query transactions of a block, all of
+// which must complete within timeout. Different chaincodes execute in
+// parallel. Within a chaincode the transactions whose key hints do not
+// conflict execute alongside one another, see batchDependencies, the others
+// in block order. Results are returned in the order of xacts.
+//
+// The writes of the invokes are kept in their read-write sets, so
+// chaincode.mvcc.enabled is required. Each invoke that completed is validated
+// and committed to the ledger in its own ledger transaction, in block order,
+// and before the invokes that depend on it execute. An invoke whose read-write
+// set does not match its key hints, or whose reads were modified by the
+// invokes committed before it, is executed again once those are committed, so
+// the outcome of the invokes is the one of executing them one by one.
+func (chaincodeSupport *ChaincodeSupport) ExecuteBatch(ctxt context.Context, xacts []*pb.Transaction, timeout time.Duration) []*BatchResult {
+	deadline := time.Now().Add(timeout)
+	results := make([]*BatchResult, len(xacts))
+	ledgerObj, err := chaincodeSupport.getLedger("")
+	if err == nil && chaincodeSupport.rwsets == nil {
+		err = fmt.Errorf("chaincode.mvcc.enabled is required")
+	}
+	if err != nil {
+		for i := range xacts {
+			results[i] = &BatchResult{Err: fmt.Errorf("Failed to execute batch: %s", err)}
+		}
+		return results
+	}
+
+	// Launching is done up front so the chaincode of every transaction is known
+	var items []*batchItem
+	chaincodes := make(map[string]bool)
+	for i, t := range xacts {
+		results[i] = &BatchResult{}
+		item, err := chaincodeSupport.prepareBatchItem(ctxt, t)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		item.index = i
+		item.hints = t.KeyHints
+		chaincodes[item.chaincode] = true
+		items = append(items, item)
+	}
+
+	// An item starts once the items it depends on are done, and commits once
+	// the items before it did
+	deps := batchDependencies(items, chaincodeSupport.concurrentTransactions)
+	done := make([]chan struct{}, len(items))
+	committed := make([]chan struct{}, len(items))
+	for i := range items {
+		done[i] = make(chan struct{})
+		committed[i] = make(chan struct{})
+	}
+	concurrent := 0
+	var wg sync.WaitGroup
+	for i, item := range items {
+		if len(deps[i]) == 0 {
+			concurrent++
+		}
+		wg.Add(1)
+		go func(i int, item *batchItem) {
+			defer wg.Done()
+			defer close(done[i])
+			for _, j := range deps[i] {
+				<-done[j]
+			}
+			chaincodeSupport.executeBatchItem(ctxt, item, results[item.index], deadline)
+			if i > 0 {
+				<-committed[i-1]
+			}
+			chaincodeSupport.commitBatchItem(ctxt, ledgerObj, item, results[item.index], deadline)
+			close(committed[i])
+		}(i, item)
+	}
+	wg.Wait()
+
+	chaincodeLog.Debug("Executed a batch of %d transactions on %d chaincodes, %d of them without waiting for others", len(xacts), len(chaincodes), concurrent)
+	return results
+}
+
+// executeBatchItem executes a transaction of a batch, recording its outcome
+// in result
+func (chaincodeSupport *ChaincodeSupport) executeBatchItem(ctxt context.Context, item *batchItem, result *BatchResult, deadline time.Time) {
+	remaining := deadline.Sub(time.Now())
+	if remaining <= 0 {
+		result.Err = fmt.Errorf("Timeout expired before transaction %s was executed", item.tx.Uuid)
+		return
+	}
+	resp, err := chaincodeSupport.Execute(ctxt, item.chaincode, item.msg, remaining, item.tx)
+	if err == nil && resp == nil {
+		err = fmt.Errorf("Failed to receive a response for (%s)", item.tx.Uuid)
+	}
+	if err != nil {
+		result.Err = err
+		return
+	}
+	result.Response = resp
+}
+
+// commitBatchItem validates the read-write set of an invoke that completed
+// and applies its writes to the ledger. It is called once the invokes before
+// it are committed, an invoke that did not execute as its key hints declared
+// or read keys modified since is executed again first. Queries leave the
+// ledger untouched.
+func (chaincodeSupport *ChaincodeSupport) commitBatchItem(ctxt context.Context, ledgerObj *ledger.Ledger, item *batchItem, result *BatchResult, deadline time.Time) {
+	t := item.tx
+	if t.Type != pb.Transaction_CHAINCODE_INVOKE {
+		return
+	}
+	state := chaincodeSupport.stateAccess(chaincodeSupport.stateStoreOf(ledgerObj))
+	if batchItemCompleted(result) {
+		if err := chaincodeSupport.checkBatchItem(item, state); err != nil {
+			chaincodeLog.Debug("[%s]Executing transaction again after the ones before it: %s", shortuuid(t.Uuid), err)
+			chaincodeSupport.discardReadWriteSet(t.Uuid)
+			*result = BatchResult{}
+			chaincodeSupport.executeBatchItem(ctxt, item, result, deadline)
+		}
+	}
+	if !batchItemCompleted(result) {
+		chaincodeSupport.discardReadWriteSet(t.Uuid)
+		return
+	}
+	markTxBegin(chaincodeSupport, ledgerObj, t)
+	if err := chaincodeSupport.commitReadWriteSet(t.Uuid, state); err != nil {
+		markTxFinish(chaincodeSupport, ledgerObj, t, false)
+		result.Response, result.Err = nil, fmt.Errorf("Failed to validate transaction %s: %s", t.Uuid, err)
+		return
+	}
+	markTxFinish(chaincodeSupport, ledgerObj, t, true)
+}
+
+// batchItemCompleted returns true if the chaincode answered COMPLETED
+func batchItemCompleted(result *BatchResult) bool {
+	return result.Err == nil && result.Response.Type == pb.ChaincodeMessage_COMPLETED
+}
+
+// checkBatchItem returns an error if the read-write set of an invoke strays
+// from its key hints or its reads are no longer current in state
+func (chaincodeSupport *ChaincodeSupport) checkBatchItem(item *batchItem, state stateAccessor) error {
+	rw := chaincodeSupport.rwsets.lookup(item.tx.Uuid)
+	if rw == nil {
+		return nil
+	}
+	if err := rw.checkHints(item.chaincode, item.hints); err != nil {
+		return fmt.Errorf("the transaction %s", err)
+	}
+	return rw.validate(state)
+}
+
+// prepareBatchItem launches the chaincode of an invoke or query transaction
+// if needed and builds the message to send to it
+func (chaincodeSupport *ChaincodeSupport) prepareBatchItem(ctxt context.Context, t *pb.Transaction) (*batchItem, error) {
+	if t.Type != pb.Transaction_CHAINCODE_INVOKE && t.Type != pb.Transaction_CHAINCODE_QUERY {
+		return nil, fmt.Errorf("Transaction %s of type %s cannot be batched", t.Uuid, t.Type)
+	}
+	if secHelper := chaincodeSupport.getSecHelper(); nil != secHelper {
+		var err error
+		if t, err = secHelper.TransactionPreExecution(t); err != nil {
+			return nil, err
+		}
+	}
+
+	cID, cMsg, err := chaincodeSupport.LaunchChaincode(ctxt, t)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to launch chaincode spec(%s)", err)
+	}
+	if err = t.CheckTransient(); err != nil {
+		return nil, err
+	}
+	cMsg.Transient = t.Transient
+
+	var msg *pb.ChaincodeMessage
+	if t.Type == pb.Transaction_CHAINCODE_INVOKE {
+		msg, err = createTransactionMessage(t.Uuid, cMsg)
+	} else {
+		msg, err = createQueryMessage(t.Uuid, cMsg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create %s message(%s)", t.Type, err)
+	}
+	return &batchItem{tx: t, chaincode: cID.Name, msg: msg}, nil
+}
@@ -0,0 +1,337 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/util"
+	pb "github.com/hyperledger/fabric/protos"
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+)
+
+func newBatchTransaction(t *testing.T, name string, typ pb.Transaction_Type, function string, args ...string) *pb.Transaction {
+	spec := &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_GOLANG, ChaincodeID: &pb.ChaincodeID{Name: name}, CtorMsg: &pb.ChaincodeInput{Function: function, Args: args}}
+	tx, err := pb.NewChaincodeExecute(&pb.ChaincodeInvocationSpec{ChaincodeSpec: spec}, util.GenerateUUID(), typ)
+	if err != nil {
+		t.Fatalf("Error creating transaction: %s", err)
+	}
+	return tx
+}
+
+func TestExecuteBatch(t *testing.T) {
+	viper.Set("peer.fileSystemPath", "/var/hyperledger/test/tmpdb")
+	viper.Set("chaincode.mvcc.enabled", true)
+	defer viper.Set("chaincode.mvcc.enabled", false)
+	getPeerEndpoint := func() (*pb.PeerEndpoint, error) {
+		return &pb.PeerEndpoint{ID: &pb.PeerID{Name: "testpeer"}, Address: "0.0.0.0:40303"}, nil
+	}
+	chain := NewChaincodeSupport(DefaultChain, getPeerEndpoint, false, 10*time.Second, nil)
+
+	for _, name := range []string{"batchsyscc1", "batchsyscc2"} {
+		if err := RegisterSystemChaincode(&SystemChaincode{Name: name, Chaincode: &kvChaincode{}}); err != nil {
+			t.Fatalf("Error registering system chaincode: %s", err)
+		}
+		defer chain.StopChaincode(context.Background(), &pb.ChaincodeID{Name: name})
+	}
+
+	deploy := newBatchTransaction(t, "batchsyscc1", pb.Transaction_CHAINCODE_INVOKE, "put", "a", "1")
+	deploy.Type = pb.Transaction_CHAINCODE_DEPLOY
+	xacts := []*pb.Transaction{
+		newBatchTransaction(t, "batchsyscc1", pb.Transaction_CHAINCODE_INVOKE, "put", "a", "1"),
+		newBatchTransaction(t, "batchsyscc2", pb.Transaction_CHAINCODE_QUERY, "get", "a"),
+		deploy,
+		newBatchTransaction(t, "batchsyscc1", pb.Transaction_CHAINCODE_INVOKE, "put", "b", "2"),
+		newBatchTransaction(t, "batchsyscc2", pb.Transaction_CHAINCODE_QUERY, "get"),
+	}
+	results := chain.ExecuteBatch(context.Background(), xacts, 10*time.Second)
+
+	if len(results) != len(xacts) {
+		t.Fatalf("Expected %d results, got %d", len(xacts), len(results))
+	}
+	expected := []pb.ChaincodeMessage_Type{pb.ChaincodeMessage_COMPLETED, pb.ChaincodeMessage_QUERY_COMPLETED, -1, pb.ChaincodeMessage_COMPLETED, -1}
+	for i, result := range results {
+		if expected[i] == -1 {
+			if result.Err == nil || result.Response != nil {
+				t.Fatalf("Expected transaction %d to fail, got %+v", i, result)
+			}
+			continue
+		}
+		if result.Err != nil {
+			t.Fatalf("Error executing transaction %d: %s", i, result.Err)
+		}
+		if result.Response.Type != expected[i] || result.Response.Uuid != xacts[i].Uuid {
+			t.Fatalf("Expected %s for transaction %d, got %s for %s", expected[i], i, result.Response.Type, result.Response.Uuid)
+		}
+	}
+	// The writes of the invokes are committed
+	ledgerObj, err := chain.getLedger("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range map[string]string{"a": "1", "b": "2"} {
+		if got, err := ledgerObj.GetState("batchsyscc1", key, false); err != nil || string(got) != value {
+			t.Fatalf("Expected %s=%s in the ledger, got %q (%v)", key, value, got, err)
+		}
+	}
+}
+
+func TestExecuteBatchRequiresMVCC(t *testing.T) {
+	viper.Set("peer.fileSystemPath", "/var/hyperledger/test/tmpdb")
+	getPeerEndpoint := func() (*pb.PeerEndpoint, error) {
+		return &pb.PeerEndpoint{ID: &pb.PeerID{Name: "testpeer"}, Address: "0.0.0.0:40303"}, nil
+	}
+	chain := NewChaincodeSupport(DefaultChain, getPeerEndpoint, false, 10*time.Second, nil)
+	xacts := []*pb.Transaction{newBatchTransaction(t, "nomvccsyscc", pb.Transaction_CHAINCODE_INVOKE, "put", "a", "1")}
+	if results := chain.ExecuteBatch(context.Background(), xacts, time.Second); results[0].Err == nil {
+		t.Fatal("Expected the batch to fail without read-write sets")
+	}
+}
+
+type slowChaincode struct {
+	kvChaincode
+	delay time.Duration
+}
+
+func (cc *slowChaincode) Invoke(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	time.Sleep(cc.delay)
+	return nil, nil
+}
+
+func (cc *slowChaincode) Query(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	if function != "sleep" {
+		return cc.kvChaincode.Query(stub, function, args)
+	}
+	time.Sleep(cc.delay)
+	return nil, nil
+}
+
+func TestExecuteBatchDeadline(t *testing.T) {
+	viper.Set("peer.fileSystemPath", "/var/hyperledger/test/tmpdb")
+	viper.Set("chaincode.mvcc.enabled", true)
+	defer viper.Set("chaincode.mvcc.enabled", false)
+	getPeerEndpoint := func() (*pb.PeerEndpoint, error) {
+		return &pb.PeerEndpoint{ID: &pb.PeerID{Name: "testpeer"}, Address: "0.0.0.0:40303"}, nil
+	}
+	chain := NewChaincodeSupport(DefaultChain, getPeerEndpoint, false, 10*time.Second, nil)
+	if err := RegisterSystemChaincode(&SystemChaincode{Name: "slowbatchsyscc", Chaincode: &slowChaincode{delay: 200 * time.Millisecond}}); err != nil {
+		t.Fatalf("Error registering system chaincode: %s", err)
+	}
+	defer chain.StopChaincode(context.Background(), &pb.ChaincodeID{Name: "slowbatchsyscc"})
+	if _, _, err := chain.LaunchChaincode(context.Background(), newBatchTransaction(t, "slowbatchsyscc", pb.Transaction_CHAINCODE_QUERY, "get", "a")); err != nil {
+		t.Fatalf("Error launching chaincode: %s", err)
+	}
+
+	// The first transaction completes in time, the second runs past the
+	// deadline of the batch and the third is never sent
+	var xacts []*pb.Transaction
+	for i := 0; i < 3; i++ {
+		xacts = append(xacts, newBatchTransaction(t, "slowbatchsyscc", pb.Transaction_CHAINCODE_INVOKE, "sleep"))
+	}
+	start := time.Now()
+	results := chain.ExecuteBatch(context.Background(), xacts, 300*time.Millisecond)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected the batch to end at its deadline, took %s", elapsed)
+	}
+	if results[0].Err != nil {
+		t.Fatalf("Error executing the first transaction: %s", results[0].Err)
+	}
+	if results[1].Err == nil || results[2].Err == nil {
+		t.Fatalf("Expected the transactions past the deadline to fail, got %v and %v", results[1].Err, results[2].Err)
+	}
+}
+
+func TestExecuteBatchKeyHints(t *testing.T) {
+	viper.Set("peer.fileSystemPath", "/var/hyperledger/test/tmpdb")
+	viper.Set("chaincode.mvcc.enabled", true)
+	defer viper.Set("chaincode.mvcc.enabled", false)
+	getPeerEndpoint := func() (*pb.PeerEndpoint, error) {
+		return &pb.PeerEndpoint{ID: &pb.PeerID{Name: "testpeer"}, Address: "0.0.0.0:40303"}, nil
+	}
+	chain := NewChaincodeSupport(DefaultChain, getPeerEndpoint, false, 10*time.Second, nil)
+	if err := RegisterSystemChaincode(&SystemChaincode{Name: "hintedbatchsyscc", Chaincode: &slowChaincode{delay: 300 * time.Millisecond}}); err != nil {
+		t.Fatalf("Error registering system chaincode: %s", err)
+	}
+	defer chain.StopChaincode(context.Background(), &pb.ChaincodeID{Name: "hintedbatchsyscc"})
+	if _, _, err := chain.LaunchChaincode(context.Background(), newBatchTransaction(t, "hintedbatchsyscc", pb.Transaction_CHAINCODE_QUERY, "get", "a")); err != nil {
+		t.Fatalf("Error launching chaincode: %s", err)
+	}
+
+	// The queries read keys the invoke does not write so they execute
+	// alongside it
+	var xacts []*pb.Transaction
+	tx := newBatchTransaction(t, "hintedbatchsyscc", pb.Transaction_CHAINCODE_INVOKE, "sleep")
+	tx.KeyHints = &pb.StateKeyHints{Writes: []string{"a"}}
+	xacts = append(xacts, tx)
+	for _, key := range []string{"b", "c"} {
+		tx = newBatchTransaction(t, "hintedbatchsyscc", pb.Transaction_CHAINCODE_QUERY, "sleep")
+		tx.KeyHints = &pb.StateKeyHints{Reads: []string{key}}
+		xacts = append(xacts, tx)
+	}
+	start := time.Now()
+	results := chain.ExecuteBatch(context.Background(), xacts, 10*time.Second)
+	elapsed := time.Since(start)
+	for i, result := range results {
+		if result.Err != nil {
+			t.Fatalf("Error executing transaction %d: %s", i, result.Err)
+		}
+	}
+	if elapsed >= 900*time.Millisecond {
+		t.Fatalf("Expected the transactions to execute concurrently, took %s", elapsed)
+	}
+}
+
+func TestExecuteBatchConcurrentInvokes(t *testing.T) {
+	viper.Set("peer.fileSystemPath", "/var/hyperledger/test/tmpdb")
+	viper.Set("chaincode.mvcc.enabled", true)
+	viper.Set("chaincode.fsm", concurrentFSM)
+	defer viper.Set("chaincode.mvcc.enabled", false)
+	defer viper.Set("chaincode.fsm", legacyFSM)
+	getPeerEndpoint := func() (*pb.PeerEndpoint, error) {
+		return &pb.PeerEndpoint{ID: &pb.PeerID{Name: "testpeer"}, Address: "0.0.0.0:40303"}, nil
+	}
+	chain := NewChaincodeSupport(DefaultChain, getPeerEndpoint, false, 10*time.Second, nil)
+	if err := RegisterSystemChaincode(&SystemChaincode{Name: "concurrentbatchsyscc", Chaincode: &slowChaincode{delay: 300 * time.Millisecond}}); err != nil {
+		t.Fatalf("Error registering system chaincode: %s", err)
+	}
+	defer chain.StopChaincode(context.Background(), &pb.ChaincodeID{Name: "concurrentbatchsyscc"})
+	if _, _, err := chain.LaunchChaincode(context.Background(), newBatchTransaction(t, "concurrentbatchsyscc", pb.Transaction_CHAINCODE_QUERY, "get", "a")); err != nil {
+		t.Fatalf("Error launching chaincode: %s", err)
+	}
+
+	// Invokes writing different keys execute alongside one another
+	var xacts []*pb.Transaction
+	for _, key := range []string{"a", "b", "c"} {
+		tx := newBatchTransaction(t, "concurrentbatchsyscc", pb.Transaction_CHAINCODE_INVOKE, "sleep")
+		tx.KeyHints = &pb.StateKeyHints{Writes: []string{key}}
+		xacts = append(xacts, tx)
+	}
+	start := time.Now()
+	results := chain.ExecuteBatch(context.Background(), xacts, 10*time.Second)
+	elapsed := time.Since(start)
+	for i, result := range results {
+		if result.Err != nil {
+			t.Fatalf("Error executing transaction %d: %s", i, result.Err)
+		}
+	}
+	if elapsed >= 900*time.Millisecond {
+		t.Fatalf("Expected the invokes to execute concurrently, took %s", elapsed)
+	}
+}
+
+// counterChaincode increments the number stored at a key
+type counterChaincode struct {
+	kvChaincode
+}
+
+func (cc *counterChaincode) Invoke(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	if function != "incr" || len(args) != 1 {
+		return cc.kvChaincode.Invoke(stub, function, args)
+	}
+	value, err := stub.GetState(args[0])
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(string(value))
+	return nil, stub.PutState(args[0], []byte(strconv.Itoa(n+1)))
+}
+
+func TestExecuteBatchWrongKeyHints(t *testing.T) {
+	viper.Set("peer.fileSystemPath", "/var/hyperledger/test/tmpdb")
+	viper.Set("chaincode.mvcc.enabled", true)
+	viper.Set("chaincode.fsm", concurrentFSM)
+	defer viper.Set("chaincode.mvcc.enabled", false)
+	defer viper.Set("chaincode.fsm", legacyFSM)
+	getPeerEndpoint := func() (*pb.PeerEndpoint, error) {
+		return &pb.PeerEndpoint{ID: &pb.PeerID{Name: "testpeer"}, Address: "0.0.0.0:40303"}, nil
+	}
+	chain := NewChaincodeSupport(DefaultChain, getPeerEndpoint, false, 10*time.Second, nil)
+	if err := RegisterSystemChaincode(&SystemChaincode{Name: "counterbatchsyscc", Chaincode: &counterChaincode{}}); err != nil {
+		t.Fatalf("Error registering system chaincode: %s", err)
+	}
+	defer chain.StopChaincode(context.Background(), &pb.ChaincodeID{Name: "counterbatchsyscc"})
+
+	// Every invoke increments the same key while declaring another one, they
+	// execute concurrently and are executed again one after the other
+	var xacts []*pb.Transaction
+	for _, key := range []string{"a", "b", "c"} {
+		tx := newBatchTransaction(t, "counterbatchsyscc", pb.Transaction_CHAINCODE_INVOKE, "incr", "n")
+		tx.KeyHints = &pb.StateKeyHints{Writes: []string{key}}
+		xacts = append(xacts, tx)
+	}
+	ledgerObj, err := chain.getLedger("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := ledgerObj.GetState("counterbatchsyscc", "n", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, _ := strconv.Atoi(string(before))
+	results := chain.ExecuteBatch(context.Background(), xacts, 10*time.Second)
+	for i, result := range results {
+		if result.Err != nil {
+			t.Fatalf("Error executing transaction %d: %s", i, result.Err)
+		}
+	}
+	expected := strconv.Itoa(n + len(xacts))
+	if got, err := ledgerObj.GetState("counterbatchsyscc", "n", false); err != nil || string(got) != expected {
+		t.Fatalf("Expected n=%s in the ledger, got %q (%v)", expected, got, err)
+	}
+}
+
+func TestExecuteTransactionsBatched(t *testing.T) {
+	viper.Set("peer.fileSystemPath", "/var/hyperledger/test/tmpdb")
+	viper.Set("chaincode.mvcc.enabled", true)
+	viper.Set("chaincode.batch.enabled", true)
+	viper.Set("chaincode.batch.timeout", 10000)
+	defer viper.Set("chaincode.mvcc.enabled", false)
+	defer viper.Set("chaincode.batch.enabled", false)
+	getPeerEndpoint := func() (*pb.PeerEndpoint, error) {
+		return &pb.PeerEndpoint{ID: &pb.PeerID{Name: "testpeer"}, Address: "0.0.0.0:40303"}, nil
+	}
+	chain := NewChaincodeSupport(DefaultChain, getPeerEndpoint, false, 10*time.Second, nil)
+	if !chain.batchBlocks {
+		t.Fatal("Expected the blocks to execute as batches")
+	}
+	if err := RegisterSystemChaincode(&SystemChaincode{Name: "blockbatchsyscc", Chaincode: &kvChaincode{}}); err != nil {
+		t.Fatalf("Error registering system chaincode: %s", err)
+	}
+	defer chain.StopChaincode(context.Background(), &pb.ChaincodeID{Name: "blockbatchsyscc"})
+
+	xacts := []*pb.Transaction{
+		newBatchTransaction(t, "blockbatchsyscc", pb.Transaction_CHAINCODE_INVOKE, "put", "x", "1"),
+		newBatchTransaction(t, "blockbatchsyscc", pb.Transaction_CHAINCODE_INVOKE, "put", "y", "2"),
+	}
+	if _, errs := ExecuteTransactions(context.Background(), DefaultChain, xacts); errs[0] != nil || errs[1] != nil {
+		t.Fatalf("Error executing the block: %v", errs)
+	}
+	ledgerObj, err := chain.getLedger("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ledgerObj.GetState("blockbatchsyscc", "y", false); err != nil || string(got) != "2" {
+		t.Fatalf("Expected y=2 in the ledger, got %q (%v)", got, err)
+	}
+}
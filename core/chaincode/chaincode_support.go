@@ -23,17 +23,30 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/looplab/fsm"
 	"github.com/op/go-logging"
 	"github.com/spf13/viper"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc"
 
+	google_protobuf "google/protobuf"
+
+	"github.com/hyperledger/fabric/core/chaincode/analysis"
+	"github.com/hyperledger/fabric/core/config"
 	"github.com/hyperledger/fabric/core/container"
 	"github.com/hyperledger/fabric/core/crypto"
+	"github.com/hyperledger/fabric/core/fsmaudit"
+	"github.com/hyperledger/fabric/core/interceptor"
 	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/opevents"
+	"github.com/hyperledger/fabric/core/tracing"
 	pb "github.com/hyperledger/fabric/protos"
 )
 
@@ -65,6 +78,20 @@ type handlerMap struct {
 	sync.RWMutex
 	// Handlers for each chaincode
 	chaincodeMap map[string]*Handler
+	// Chaincodes that have been upgraded, mapped to the name of their replacement
+	upgradedMap map[string]string
+	// State namespace of upgraded chaincodes, keyed by the name of the replacement
+	namespaceMap map[string]string
+	// Resource limits of the container of each chaincode, from its deployment spec
+	limitsMap map[string]*container.ResourceLimits
+	// Digest of the code package each chaincode was deployed with, see
+	// verifyFingerprint
+	fingerprintMap map[string][]byte
+	// Admission of the transactions of each chaincode, from its deployment spec
+	admissionMap map[string]*admissionQueue
+	// Instances of each chaincode registered besides the one in chaincodeMap,
+	// see DuplicateLoadBalance
+	replicaMap map[string]*handlerReplicas
 }
 
 // GetChain returns the chaincode support for a given chain
@@ -72,6 +99,21 @@ func GetChain(name ChainName) *ChaincodeSupport {
 	return chains[name]
 }
 
+// SetLedgerProvider replaces the provider resolving the ledgers of the chains
+// state operations are routed to
+func (chaincodeSupport *ChaincodeSupport) SetLedgerProvider(provider ledger.LedgerProvider) {
+	chaincodeSupport.ledgers = provider
+}
+
+// getLedger returns the ledger of chainID, the chain of this chaincode
+// support if chainID is empty
+func (chaincodeSupport *ChaincodeSupport) getLedger(chainID string) (*ledger.Ledger, error) {
+	if chainID == "" {
+		chainID = string(chaincodeSupport.name)
+	}
+	return chaincodeSupport.ledgers.GetLedger(chainID)
+}
+
 //call this under lock
 func (chaincodeSupport *ChaincodeSupport) preLaunchSetup(chaincode string) chan bool {
 	//register placeholder Handler. This will be transferred in registerHandler
@@ -88,13 +130,42 @@ func (chaincodeSupport *ChaincodeSupport) chaincodeHasBeenLaunched(chaincode str
 	return handler, hasbeenlaunched
 }
 
+//call this under lock
+//returns the name of the chaincode that currently serves requests for chaincode
+func (chaincodeSupport *ChaincodeSupport) resolveChaincodeName(chaincode string) string {
+	for {
+		upgraded, ok := chaincodeSupport.handlerMap.upgradedMap[chaincode]
+		if !ok {
+			return chaincode
+		}
+		chaincode = upgraded
+	}
+}
+
+// getVMType returns the type of vm that runs chaincode
+func (chaincodeSupport *ChaincodeSupport) getVMType(chaincode string) string {
+	if container.IsSystemChaincode(chaincode) {
+		return container.SYSTEM
+	}
+	return container.DOCKER
+}
+
 // NewChaincodeSupport creates a new ChaincodeSupport instance
 func NewChaincodeSupport(chainname ChainName, getPeerEndpoint func() (*pb.PeerEndpoint, error), userrunsCC bool, ccstartuptimeout time.Duration, secHelper crypto.Peer) *ChaincodeSupport {
-	s := &ChaincodeSupport{name: chainname, handlerMap: &handlerMap{chaincodeMap: make(map[string]*Handler)}, secHelper: secHelper}
+	s := &ChaincodeSupport{name: chainname, stop: make(chan struct{}), handlerMap: &handlerMap{chaincodeMap: make(map[string]*Handler), upgradedMap: make(map[string]string), namespaceMap: make(map[string]string), limitsMap: make(map[string]*container.ResourceLimits), fingerprintMap: make(map[string][]byte), admissionMap: make(map[string]*admissionQueue)}, secHelper: secHelper}
 
-	//initialize global chain
+	//initialize global chain, the background work of the chain replaced stops
+	if old := chains[chainname]; old != nil {
+		old.Stop()
+	}
 	chains[chainname] = s
 
+	chainLedgers := ledger.NewChainLedgers()
+	if err := chainLedgers.Join(string(chainname)); err != nil {
+		chaincodeLog.Error(fmt.Sprintf("Error opening the ledger of chain %s: %s", chainname, err))
+	}
+	s.ledgers = chainLedgers
+
 	peerEndpoint, err := getPeerEndpoint()
 	if err != nil {
 		chaincodeLog.Error(fmt.Sprintf("Error getting PeerEndpoint, using peer.address: %s", err))
@@ -109,8 +180,162 @@ func NewChaincodeSupport(chainname ChainName, getPeerEndpoint func() (*pb.PeerEn
 	}
 
 	s.userRunsCC = userrunsCC
+	if _, err := getDuplicatePolicy(viper.GetString("chaincode.duplicateRegistration"), userrunsCC); err != nil {
+		chaincodeLog.Error(fmt.Sprintf("Ignoring chaincode.duplicateRegistration: %s", err))
+	} else {
+		s.duplicatePolicy = viper.GetString("chaincode.duplicateRegistration")
+	}
+	if policy, err := getDuplicateRequestPolicy(viper.GetString("chaincode.duplicateRequest")); err != nil {
+		chaincodeLog.Error(fmt.Sprintf("Ignoring chaincode.duplicateRequest: %s", err))
+		s.duplicateRequests = DuplicateRequestReject
+	} else {
+		s.duplicateRequests = policy
+	}
+	s.maxTenants = viper.GetInt("chaincode.maxTenants")
+
+	s.ccStartupTimeout = ccstartuptimeout
+	if s.ccStartupTimeout <= 0 {
+		s.ccStartupTimeout = time.Duration(chaincodeStartupTimeoutDefault) * time.Millisecond
+	}
+	s.startupLogLines = viper.GetInt("chaincode.startupLogLines")
+	s.startupRetries = viper.GetInt("chaincode.startupRetries")
+	s.startupBackoff = time.Duration(viper.GetInt("chaincode.startupBackoff")) * time.Millisecond
+
+	s.devStartupTimeout = devStartupTimeoutDefault
+	if t := viper.GetInt("chaincode.dev.startuptimeout"); t > 0 {
+		s.devStartupTimeout = time.Duration(t) * time.Millisecond
+	}
+
+	s.execTimeout = getExecTimeout()
+	s.stateMaxConcurrent = config.GetInt("chaincode.state.maxConcurrent")
+	s.stateRatePerSec = config.GetInt("chaincode.state.ratePerSec")
+	if limits, err := newStateKeyLimits(viper.GetInt("chaincode.state.maxKeyLength"), viper.GetInt("chaincode.state.maxValueSize"), viper.GetString("chaincode.state.keyCharacters")); err != nil {
+		chaincodeLog.Error(fmt.Sprintf("Ignoring chaincode.state limits: %s", err))
+	} else {
+		s.stateKeyLimits = limits
+	}
+
+	if interval := viper.GetInt("chaincode.leakaudit.interval"); interval > 0 {
+		threshold := time.Duration(viper.GetInt("chaincode.leakaudit.threshold")) * time.Millisecond
+		s.startLeakAuditor(time.Duration(interval)*time.Millisecond, threshold, viper.GetBool("chaincode.leakaudit.expire"))
+	}
+
+	if ttl := viper.GetInt("chaincode.iteratorTTL"); ttl > 0 {
+		s.startIteratorReaper(time.Duration(ttl) * time.Millisecond)
+	}
+
+	if maxTraced := viper.GetInt("chaincode.trace.maxTransactions"); maxTraced > 0 {
+		s.traces = newTraceStore(maxTraced)
+	}
+	s.transitions = fsmaudit.NewRecorder(viper.GetInt("chaincode.fsmAudit.size"))
+
+	if viper.GetBool("chaincode.mvcc.enabled") {
+		s.rwsets = newRWSetStore()
+	}
+	s.simulations = newRWSetStore()
+
+	if size := viper.GetInt("chaincode.stateCache.size"); size > 0 {
+		s.stateCache = newStateCache(size)
+	}
+
+	backend := viper.GetString("chaincode.stateStore.backend")
+	path := filepath.Join(viper.GetString("peer.fileSystemPath"), "chaincode", string(chainname)+"-state")
+	if store, err := newStateStore(backend, path); err != nil {
+		chaincodeLog.Error(fmt.Sprintf("Error creating the %s state store, using the ledger: %s", backend, err))
+	} else {
+		s.stateStore = store
+	}
+
+	s.upgradesPath = filepath.Join(viper.GetString("peer.fileSystemPath"), "chaincode", string(chainname)+"-upgrades.json")
+	if err := s.loadUpgrades(); err != nil {
+		chaincodeLog.Error(fmt.Sprintf("Error restoring chaincode upgrades: %s", err))
+	}
+
+	if ttl := viper.GetInt("chaincode.replayTTL"); ttl > 0 {
+		path := filepath.Join(viper.GetString("peer.fileSystemPath"), "chaincode", string(chainname)+"-replay.json")
+		s.replays = newReplayCache(time.Duration(ttl)*time.Millisecond, path)
+	}
+
+	if viper.GetBool("chaincode.journal.enabled") {
+		path := filepath.Join(viper.GetString("peer.fileSystemPath"), "chaincode", string(chainname)+"-journal.json")
+		s.journal = newWriteJournal(path)
+		// restore the state left by transactions interrupted by a crash
+		// before any new transaction executes
+		if err := s.journal.recover(func(chainID string) (journalLedger, error) { return s.getLedger(chainID) }); err != nil {
+			chaincodeLog.Error(err.Error())
+		}
+	}
+
+	if viper.GetBool("chaincode.stateWriter.enabled") {
+		flushInterval := time.Duration(viper.GetInt("chaincode.stateWriter.flushInterval")) * time.Millisecond
+		flushSize := viper.GetInt("chaincode.stateWriter.flushSize")
+		if flushSize <= 0 {
+			flushSize = 1
+		}
+		s.stateWriter = newStateWriter(flushInterval, flushSize)
+	}
+
+	s.responseChunkSize = viper.GetInt("chaincode.responseChunkSize")
+	if s.spillMaxSize = viper.GetInt("chaincode.responseSpill.maxSize"); s.spillMaxSize > 0 {
+		backend := viper.GetString("chaincode.responseSpill.backend")
+		if backend == "" || backend == LedgerStateStore {
+			// writes to the ledger would be part of the state hash
+			chaincodeLog.Error(fmt.Sprintf("Responses cannot be spilled to the %s, using the %s backend", LedgerStateStore, MemoryStateStore))
+			backend = MemoryStateStore
+		}
+		path := filepath.Join(viper.GetString("peer.fileSystemPath"), "chaincode", string(chainname)+"-spill")
+		if store, err := newStateStore(backend, path); err != nil {
+			chaincodeLog.Error(fmt.Sprintf("Error creating the %s spill store, responses are not spilled: %s", backend, err))
+		} else {
+			s.spillStore = store
+		}
+	}
+	s.stateExportChunkSize = viper.GetInt("chaincode.stateExport.chunkSize")
+	s.validator = viper.GetBool("peer.validator.enabled")
+	s.delStateRangeMaxKeys = viper.GetInt("chaincode.delStateRange.maxKeys")
+	s.defaultLimits = getDefaultResourceLimits()
+	if err := s.defaultLimits.Validate(); err != nil {
+		chaincodeLog.Error(fmt.Sprintf("Ignoring chaincode.resources: %s", err))
+		s.defaultLimits = &container.ResourceLimits{}
+	}
+	s.defaultConcurrency = getDefaultConcurrency()
+	s.flowControlWindow = viper.GetInt("chaincode.flowControl.window")
+	s.flowControlMaxQueued = viper.GetInt("chaincode.flowControl.maxQueued")
+	s.outboundBufferSize = viper.GetInt("chaincode.outboundBufferSize")
+	s.expiryTolerance = time.Duration(viper.GetInt("chaincode.expiryTolerance")) * time.Millisecond
+	s.keepaliveInterval = time.Duration(viper.GetInt("chaincode.keepalive.interval")) * time.Millisecond
+	s.keepaliveMissedLimit = viper.GetInt("chaincode.keepalive.missedLimit")
+	s.sweepInterval = time.Duration(viper.GetInt("chaincode.sweeper.interval")) * time.Millisecond
+	s.sweepGrace = time.Duration(viper.GetInt("chaincode.sweeper.grace")) * time.Millisecond
+	fsmTable, err := getFSMTable(viper.GetString("chaincode.fsm"))
+	if err == nil {
+		err = validateFSMTable(fsmTable, handlerCallbacks(&Handler{}))
+	}
+	concurrent := viper.GetString("chaincode.fsm") == concurrentFSM
+	if err == nil && concurrent && s.rwsets == nil {
+		err = fmt.Errorf("the %s FSM requires chaincode.mvcc.enabled", concurrentFSM)
+	}
+	if err != nil {
+		chaincodeLog.Error(fmt.Sprintf("Ignoring chaincode.fsm: %s", err))
+		fsmTable, concurrent = legacyFSMTable, false
+	}
+	s.fsmTable = fsmTable
+	s.concurrentTransactions = concurrent
+	if viper.GetBool("chaincode.batch.enabled") {
+		if s.rwsets == nil {
+			chaincodeLog.Error("Ignoring chaincode.batch.enabled: chaincode.mvcc.enabled is required")
+		} else {
+			s.batchBlocks = true
+			s.batchTimeout = time.Duration(viper.GetInt("chaincode.batch.timeout")) * time.Millisecond
+		}
+	}
+	s.lifecycle.Add(opevents.ChaincodeListener)
 
-	s.ccStartupTimeout = ccstartuptimeout * time.Millisecond
+	//in-process chaincode, such as system chaincode, launched for this chain
+	//registers through a stream served here
+	container.SetInProcessConnector(string(chainname), func(stream container.ChaincodeStream) error {
+		return newChaincodeSupportHandler(s, stream).processStream()
+	})
 
 	//TODO I'm not sure if this needs to be on a per chain basis... too lowel and just needs to be a global default ?
 	s.chaincodeInstallPath = chaincodeInstallPathDefault
@@ -130,11 +355,113 @@ type ChaincodeSupport struct {
 	handlerMap           *handlerMap
 	peerAddress          string
 	ccStartupTimeout     time.Duration
+	devStartupTimeout    time.Duration
+	startupLogLines      int
+	startupRetries       int
+	startupBackoff       time.Duration
 	chaincodeInstallPath string
 	userRunsCC           bool
 	secHelper            crypto.Peer
+	// settingsLock guards the settings that are reloaded at runtime
+	settingsLock         sync.RWMutex
+	execTimeout          time.Duration
+	stateMaxConcurrent   int
+	stateRatePerSec      int
+	stateKeyLimits       *stateKeyLimits
+	traces               *traceStore
+	transitions          *fsmaudit.Recorder
+	rwsets               *rwSetStore
+	simulations          *rwSetStore
+	stateCache           *stateCache
+	replays              *replayCache
+	upgradesPath         string
+	journal              *writeJournal
+	responseChunkSize    int
+	spillMaxSize         int
+	spillStore           StateStore
+	stateExportChunkSize int
+	// validator is set on a validating peer, whose ledger state only changes
+	// with blocks
+	validator            bool
+	delStateRangeMaxKeys int
+	flowControlWindow    int
+	flowControlMaxQueued int
+	outboundBufferSize   int
+	defaultLimits        *container.ResourceLimits
+	defaultConcurrency   *pb.ChaincodeConcurrency
+	expiryTolerance      time.Duration
+	keepaliveInterval    time.Duration
+	keepaliveMissedLimit int
+	sweepInterval        time.Duration
+	sweepGrace           time.Duration
+	fsmTable             fsm.Events
+	duplicatePolicy      string
+	duplicateRequests    string
+	maxTenants           int
+	ledgers              ledger.LedgerProvider
+	stateStore           StateStore
+	stateWriter          *stateWriter
+	lifecycle            opevents.Listeners
+	// closed by Stop, ends the background work of the chain
+	stop     chan struct{}
+	stopOnce sync.Once
+	// the concurrent FSM is in use, transactions of a chaincode overlap
+	concurrentTransactions bool
+	// the invokes of a block execute with ExecuteBatch, within batchTimeout
+	batchBlocks  bool
+	batchTimeout time.Duration
+	// the ledger has one transaction in progress at most, they begin and
+	// finish under txLock
+	txLock sync.Mutex
+}
+
+// Stop ends the background work of the chaincode support, such as the
+// iterator reaper. The chaincodes themselves are left running.
+func (chaincodeSupport *ChaincodeSupport) Stop() {
+	chaincodeSupport.stopOnce.Do(func() { close(chaincodeSupport.stop) })
+}
+
+// RegisterLifecycleListener calls listener whenever the handler of a chaincode
+// is registered, becomes ready, fails or is deregistered
+func (chaincodeSupport *ChaincodeSupport) RegisterLifecycleListener(listener opevents.LifecycleListener) {
+	chaincodeSupport.lifecycle.Add(listener)
+}
+
+// RegisteredChaincode describes a chaincode known to the chaincode support
+type RegisteredChaincode struct {
+	Name       string `json:"name"`
+	State      string `json:"state"`
+	UpgradedTo string `json:"upgradedTo,omitempty"`
+}
+
+// RegisteredChaincodes returns the chaincodes that are running or being
+// launched, followed by the chaincodes that have been upgraded
+func (chaincodeSupport *ChaincodeSupport) RegisteredChaincodes() []RegisteredChaincode {
+	chaincodeSupport.handlerMap.RLock()
+	defer chaincodeSupport.handlerMap.RUnlock()
+
+	var registered, upgraded []RegisteredChaincode
+	for name, handler := range chaincodeSupport.handlerMap.chaincodeMap {
+		state := "launching"
+		if handler.FSM != nil {
+			state = handler.FSM.Current()
+		}
+		registered = append(registered, RegisteredChaincode{Name: name, State: state})
+	}
+	for name, replacement := range chaincodeSupport.handlerMap.upgradedMap {
+		upgraded = append(upgraded, RegisteredChaincode{Name: name, State: "upgraded", UpgradedTo: replacement})
+	}
+	sort.Sort(registeredChaincodesByName(registered))
+	sort.Sort(registeredChaincodesByName(upgraded))
+	return append(registered, upgraded...)
 }
 
+type registeredChaincodesByName []RegisteredChaincode
+
+func (r registeredChaincodesByName) Len() int           { return len(r) }
+func (r registeredChaincodesByName) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r registeredChaincodesByName) Less(i, j int) bool { return r[i].Name < r[j].Name }
+
 // DuplicateChaincodeHandlerError returned if attempt to register same chaincodeID while a stream already exists.
 type DuplicateChaincodeHandlerError struct {
 	ChaincodeID *pb.ChaincodeID
@@ -155,33 +482,53 @@ func (chaincodeSupport *ChaincodeSupport) registerHandler(chaincodehandler *Hand
 	defer chaincodeSupport.handlerMap.Unlock()
 
 	h2, ok := chaincodeSupport.chaincodeHasBeenLaunched(key)
+	replica := false
 	if ok && h2.registered == true {
-		chaincodeLogger.Debug("duplicate registered handler(key:%s) return error", key)
-		// Duplicate, return error
-		return newDuplicateChaincodeHandlerError(chaincodehandler)
-	}
-	//a placeholder, unregistered handler will be setup by query or transaction processing that comes
-	//through via consensus. In this case we swap the handler and give it the notify channel
-	if h2 != nil {
-		chaincodehandler.readyNotify = h2.readyNotify
-		delete(chaincodeSupport.handlerMap.chaincodeMap, key)
+		switch chaincodeSupport.duplicateRegistrationPolicy() {
+		case DuplicateReplace:
+			// e.g. in dev mode the chaincode was restarted by the developer, the new
+			// instance replaces the old one whose stream may not have been torn down yet
+			chaincodeLogger.Info("chaincode %s registered again, replacing previous instance", key)
+			delete(chaincodeSupport.handlerMap.chaincodeMap, key)
+			h2 = nil
+		case DuplicateLoadBalance:
+			chaincodeLogger.Info("chaincode %s registered again, balancing load over the instances", key)
+			replica = true
+		default:
+			chaincodeLogger.Debug("duplicate registered handler(key:%s) return error", key)
+			// Duplicate, return error
+			return newDuplicateChaincodeHandlerError(chaincodehandler)
+		}
 	}
+	if replica {
+		chaincodeSupport.addReplica(key, chaincodehandler)
+	} else {
+		//a placeholder, unregistered handler will be setup by query or transaction processing that comes
+		//through via consensus. In this case we swap the handler and give it the notify channel
+		if h2 != nil {
+			chaincodehandler.readyNotify = h2.readyNotify
+			delete(chaincodeSupport.handlerMap.chaincodeMap, key)
+		}
 
-	chaincodeSupport.handlerMap.chaincodeMap[key] = chaincodehandler
+		chaincodeSupport.handlerMap.chaincodeMap[key] = chaincodehandler
+	}
 
 	chaincodehandler.registered = true
+	chaincodehandler.stateNamespace = chaincodeSupport.handlerMap.namespaceMap[key]
 
 	//now we are ready to receive messages and send back responses
 	chaincodehandler.txCtxs = make(map[string]*transactionContext)
-	chaincodehandler.uuidMap = make(map[string]bool)
+	chaincodehandler.uuidMap = make(map[string]time.Time)
 	chaincodehandler.isTransaction = make(map[string]bool)
 
 	chaincodeLogger.Debug("registered handler complete for chaincode %s", key)
+	chaincodeSupport.lifecycle.Fire(key, opevents.HandlerRegistered, nil)
 
 	return nil
 }
 
-func (chaincodeSupport *ChaincodeSupport) deregisterHandler(chaincodehandler *Handler) error {
+// deregisterHandler removes a handler whose stream ended because of reason
+func (chaincodeSupport *ChaincodeSupport) deregisterHandler(chaincodehandler *Handler, reason error) error {
 
 	// clean up rangeQueryIteratorMap
 	for _, context := range chaincodehandler.txCtxs {
@@ -194,17 +541,23 @@ func (chaincodeSupport *ChaincodeSupport) deregisterHandler(chaincodehandler *Ha
 	chaincodeLogger.Debug("Deregister handler: %s", key)
 	chaincodeSupport.handlerMap.Lock()
 	defer chaincodeSupport.handlerMap.Unlock()
-	if _, ok := chaincodeSupport.chaincodeHasBeenLaunched(key); !ok {
+	if h, ok := chaincodeSupport.chaincodeHasBeenLaunched(key); ok && h == chaincodehandler {
+		delete(chaincodeSupport.handlerMap.chaincodeMap, key)
+		chaincodeSupport.promoteReplica(key)
+	} else if !chaincodeSupport.removeReplica(key, chaincodehandler) {
 		// Handler NOT found
 		return fmt.Errorf("Error deregistering handler, could not find handler with key: %s", key)
 	}
-	delete(chaincodeSupport.handlerMap.chaincodeMap, key)
 	chaincodeLogger.Debug("Deregistered handler with key: %s", key)
+	// StopChaincode removes the handlers before it stops the container, so a
+	// handler still registered here went away without being asked to
+	chaincodeSupport.lifecycle.Fire(key, opevents.HandlerError, reason)
+	chaincodeSupport.lifecycle.Fire(key, opevents.HandlerDeregistered, nil)
 	return nil
 }
 
-// Based on state of chaincode send either init or ready to move to ready state
-func (chaincodeSupport *ChaincodeSupport) sendInitOrReady(context context.Context, uuid string, chaincode string, f *string, initArgs []string, timeout time.Duration, tx *pb.Transaction, depTx *pb.Transaction) error {
+// Based on state of chaincode send either init (or upgrade) or ready to move to ready state
+func (chaincodeSupport *ChaincodeSupport) sendInitOrReady(context context.Context, uuid string, chaincode string, initType pb.ChaincodeMessage_Type, f *string, initArgs []string, timeout time.Duration, tx *pb.Transaction, depTx *pb.Transaction) error {
 	chaincodeSupport.handlerMap.Lock()
 	//if its in the map, there must be a connected stream...nothing to do
 	var handler *Handler
@@ -216,10 +569,16 @@ func (chaincodeSupport *ChaincodeSupport) sendInitOrReady(context context.Contex
 	}
 	chaincodeSupport.handlerMap.Unlock()
 
+	return chaincodeSupport.sendInitOrReadyTo(handler, uuid, chaincode, initType, f, initArgs, timeout, tx, depTx)
+}
+
+// sendInitOrReadyTo sends init (or upgrade) or ready to handler and waits for
+// it to reach the ready state
+func (chaincodeSupport *ChaincodeSupport) sendInitOrReadyTo(handler *Handler, uuid string, chaincode string, initType pb.ChaincodeMessage_Type, f *string, initArgs []string, timeout time.Duration, tx *pb.Transaction, depTx *pb.Transaction) error {
 	var notfy chan *pb.ChaincodeMessage
 	var err error
-	if notfy, err = handler.initOrReady(uuid, f, initArgs, tx, depTx); err != nil {
-		return fmt.Errorf("Error sending %s: %s", pb.ChaincodeMessage_INIT, err)
+	if notfy, err = handler.initOrReady(uuid, initType, f, initArgs, tx, depTx); err != nil {
+		return fmt.Errorf("Error sending %s: %s", initType, err)
 	}
 	if notfy != nil {
 		select {
@@ -240,7 +599,11 @@ func (chaincodeSupport *ChaincodeSupport) sendInitOrReady(context context.Contex
 
 //get args and env given chaincodeID
 func (chaincodeSupport *ChaincodeSupport) getArgsAndEnv(cID *pb.ChaincodeID) (args []string, envs []string, err error) {
-	envs = []string{"CORE_CHAINCODE_ID_NAME=" + cID.Name}
+	envs = []string{"CORE_CHAINCODE_ID_NAME=" + cID.Name, container.ChainIDEnv + "=" + string(chaincodeSupport.name)}
+	// The chaincode authenticates its stream with the token of the peer
+	if token := viper.GetString("peer.streams.token"); token != "" {
+		envs = append(envs, "CORE_PEER_STREAMS_TOKEN="+token)
+	}
 
 	//chaincode executable will be same as the name of the chaincode
 	args = []string{chaincodeSupport.chaincodeInstallPath + cID.Name, fmt.Sprintf("-peer.address=%s", chaincodeSupport.peerAddress)}
@@ -278,39 +641,93 @@ func (chaincodeSupport *ChaincodeSupport) launchAndWaitForRegister(context conte
 
 	//creat a StartImageReq obj and send it to VMCProcess
 	vmname := container.GetVMFromName(chaincode)
+	vmtype := chaincodeSupport.getVMType(chaincode)
+	backoff := chaincodeSupport.startupBackoff
+
+	//the placeholder handler stays while the container is relaunched, a
+	//chaincode that registers late still finds notfy
+	for attempt := 1; ; attempt++ {
+		chaincodeLog.Debug("start container: %s", vmname)
+
+		sir := container.StartImageReq{ID: vmname, Args: args, Env: env, Limits: chaincodeSupport.getResourceLimits(chaincode)}
+		resp, err := container.VMCProcess(context, vmtype, sir)
+		if err != nil || (resp != nil && resp.(container.VMCResp).Err != nil) {
+			if err == nil {
+				err = resp.(container.VMCResp).Err
+			}
+			err = fmt.Errorf("Error starting container: %s", err)
+			chaincodeSupport.handlerMap.Lock()
+			delete(chaincodeSupport.handlerMap.chaincodeMap, chaincode)
+			chaincodeSupport.handlerMap.Unlock()
+			return alreadyRunning, err
+		}
 
-	chaincodeLog.Debug("start container: %s", vmname)
-
-	sir := container.StartImageReq{ID: vmname, Args: args, Env: env}
-	resp, err := container.VMCProcess(context, "Docker", sir)
-	if err != nil || (resp != nil && resp.(container.VMCResp).Err != nil) {
-		if err == nil {
-			err = resp.(container.VMCResp).Err
+		//wait for REGISTER state
+		timedOut := false
+		select {
+		case ok := <-notfy:
+			if !ok {
+				err = fmt.Errorf("registration failed for %s(tx:%s)", vmname, uuid)
+			}
+		case <-time.After(chaincodeSupport.ccStartupTimeout):
+			timedOut = true
+			err = fmt.Errorf("Timeout expired while starting chaincode %s(tx:%s), not registered within %s in %d attempt(s)", vmname, uuid, chaincodeSupport.ccStartupTimeout, attempt)
+		}
+		if timedOut && attempt <= chaincodeSupport.startupRetries {
+			//relaunch a container that hung or died before registering, it is
+			//removed when stopped so log its output first
+			chaincodeLog.Warning("%s", chaincodeSupport.addContainerLog(context, chaincode, err))
+			if _, errIgnore := container.VMCProcess(context, vmtype, container.StopImageReq{ID: vmname, Timeout: 0}); errIgnore != nil {
+				chaincodeLog.Debug("error on stop %s(%s)", errIgnore, err)
+			}
+			chaincodeLog.Info("launching %s again in %s, retry %d of %d", vmname, backoff, attempt, chaincodeSupport.startupRetries)
+			select {
+			case <-time.After(backoff):
+				backoff *= 2
+				continue
+			case <-context.Done():
+				err = fmt.Errorf("%s, not launched again: %s", err, context.Err())
+			}
+		}
+		if err != nil {
+			chaincodeLog.Debug("stopping due to error while launching %s", err)
+			//the container is removed when stopped, get its output first
+			err = chaincodeSupport.addContainerLog(context, chaincode, err)
+			errIgnore := chaincodeSupport.StopChaincode(context, cID)
+			if errIgnore != nil {
+				chaincodeLog.Debug("error on stop %s(%s)", errIgnore, err)
+			}
 		}
-		err = fmt.Errorf("Error starting container: %s", err)
-		chaincodeSupport.handlerMap.Lock()
-		delete(chaincodeSupport.handlerMap.chaincodeMap, chaincode)
-		chaincodeSupport.handlerMap.Unlock()
 		return alreadyRunning, err
 	}
+}
 
-	//wait for REGISTER state
-	select {
-	case ok := <-notfy:
-		if !ok {
-			err = fmt.Errorf("registration failed for %s(tx:%s)", vmname, uuid)
-		}
-	case <-time.After(chaincodeSupport.ccStartupTimeout):
-		err = fmt.Errorf("Timeout expired while starting chaincode %s(tx:%s)", vmname, uuid)
+// addContainerLog adds the tail of the output of the container of chaincode to
+// err so that users can see why their chaincode failed to start
+func (chaincodeSupport *ChaincodeSupport) addContainerLog(context context.Context, chaincode string, err error) error {
+	if chaincodeSupport.startupLogLines <= 0 {
+		return err
 	}
-	if err != nil {
-		chaincodeLog.Debug("stopping due to error while launching %s", err)
-		errIgnore := chaincodeSupport.StopChaincode(context, cID)
-		if errIgnore != nil {
-			chaincodeLog.Debug("error on stop %s(%s)", errIgnore, err)
-		}
+	vmname := container.GetVMFromName(chaincode)
+	resp, logErr := container.VMCProcess(context, chaincodeSupport.getVMType(chaincode), container.LogsReq{ID: vmname, Tail: chaincodeSupport.startupLogLines})
+	if logErr == nil {
+		logErr = resp.(container.VMCResp).Err
+	}
+	if logErr != nil {
+		chaincodeLog.Debug("could not get output of %s: %s", vmname, logErr)
+		return err
 	}
-	return alreadyRunning, err
+	log, _ := resp.(container.VMCResp).Resp.([]byte)
+	return containerLogError(err, chaincodeSupport.startupLogLines, log)
+}
+
+// containerLogError returns err with the container output log appended
+func containerLogError(err error, tail int, log []byte) error {
+	output := strings.TrimRight(string(log), "\n")
+	if output == "" {
+		return fmt.Errorf("%s, the container produced no output", err)
+	}
+	return fmt.Errorf("%s, last %d lines of container output:\n%s", err, tail, output)
 }
 
 func (chaincodeSupport *ChaincodeSupport) StopChaincode(context context.Context, cID *pb.ChaincodeID) error {
@@ -319,27 +736,42 @@ func (chaincodeSupport *ChaincodeSupport) StopChaincode(context context.Context,
 		return fmt.Errorf("chaincode name not set")
 	}
 
+	// The handlers are removed before the container is stopped, so their
+	// streams ending is not taken for a crash in deregisterHandler
+	chaincodeSupport.handlerMap.Lock()
+	handler, ok := chaincodeSupport.chaincodeHasBeenLaunched(chaincode)
+	var replicas []*Handler
+	if ok {
+		delete(chaincodeSupport.handlerMap.chaincodeMap, chaincode)
+		if r := chaincodeSupport.handlerMap.replicaMap[chaincode]; r != nil {
+			replicas = r.handlers
+			delete(chaincodeSupport.handlerMap.replicaMap, chaincode)
+		}
+	}
+	chaincodeSupport.handlerMap.Unlock()
+
 	vmname := container.GetVMFromName(chaincode)
 
 	//stop the chaincode
 	sir := container.StopImageReq{ID: vmname, Timeout: 0}
 
-	_, err := container.VMCProcess(context, "Docker", sir)
+	_, err := container.VMCProcess(context, chaincodeSupport.getVMType(chaincode), sir)
 	if err != nil {
 		err = fmt.Errorf("Error stopping container: %s", err)
 		//but proceed to cleanup
 	}
 
-	chaincodeSupport.handlerMap.Lock()
-	if _, ok := chaincodeSupport.chaincodeHasBeenLaunched(chaincode); !ok {
+	if !ok {
 		//nothing to do
-		chaincodeSupport.handlerMap.Unlock()
 		return nil
 	}
 
-	delete(chaincodeSupport.handlerMap.chaincodeMap, chaincode)
-
-	chaincodeSupport.handlerMap.Unlock()
+	if handler.registered {
+		chaincodeSupport.lifecycle.Fire(chaincode, opevents.HandlerDeregistered, nil)
+	}
+	for range replicas {
+		chaincodeSupport.lifecycle.Fire(chaincode, opevents.HandlerDeregistered, nil)
+	}
 
 	return err
 }
@@ -362,6 +794,10 @@ func (chaincodeSupport *ChaincodeSupport) LaunchChaincode(context context.Contex
 		cMsg = cds.ChaincodeSpec.CtorMsg
 		f = &cMsg.Function
 		initargs = cMsg.Args
+		if err = chaincodeSupport.setResourceLimits(cID.Name, cds.ChaincodeSpec); err != nil {
+			return nil, nil, err
+		}
+		chaincodeSupport.setConcurrency(cID.Name, cds.ChaincodeSpec)
 	} else if t.Type == pb.Transaction_CHAINCODE_INVOKE || t.Type == pb.Transaction_CHAINCODE_QUERY {
 		ci := &pb.ChaincodeInvocationSpec{}
 		err := proto.Unmarshal(t.Payload, ci)
@@ -374,13 +810,22 @@ func (chaincodeSupport *ChaincodeSupport) LaunchChaincode(context context.Contex
 		chaincodeSupport.handlerMap.Unlock()
 		return nil, nil, fmt.Errorf("invalid transaction type: %d", t.Type)
 	}
-	chaincode := cID.Name
 	chaincodeSupport.handlerMap.Lock()
+	//requests for an upgraded chaincode are served by its replacement
+	if chaincode := chaincodeSupport.resolveChaincodeName(cID.Name); chaincode != cID.Name {
+		cID = &pb.ChaincodeID{Path: cID.Path, Name: chaincode}
+	}
+	chaincode := cID.Name
 	var handler *Handler
 	var ok bool
 	var err error
 	//if its in the map, there must be a connected stream...nothing to do
 	if handler, ok = chaincodeSupport.chaincodeHasBeenLaunched(chaincode); ok {
+		if handler.isQuiesced() {
+			chaincodeSupport.handlerMap.Unlock()
+			chaincodeLog.Debug("chaincode (%s) is being upgraded", chaincode)
+			return cID, cMsg, fmt.Errorf("chaincode (%s) is being upgraded", chaincode)
+		}
 		if !handler.registered {
 			chaincodeSupport.handlerMap.Unlock()
 			chaincodeLog.Debug("premature execution - chaincode (%s) is being launched", chaincode)
@@ -410,8 +855,11 @@ func (chaincodeSupport *ChaincodeSupport) LaunchChaincode(context context.Contex
 	//         5) query successfully retrives committed tx and calls sendInitOrReady
 	// See issue #710
 
-	if t.Type != pb.Transaction_CHAINCODE_DEPLOY {
-		ledger, ledgerErr := ledger.GetLedger()
+	//system chaincode is compiled into the peer and need not have been deployed
+	systemCC := container.IsSystemChaincode(chaincode)
+
+	if t.Type != pb.Transaction_CHAINCODE_DEPLOY && !systemCC {
+		ledger, ledgerErr := chaincodeSupport.getLedger("")
 		if ledgerErr != nil {
 			return cID, cMsg, fmt.Errorf("Failed to get handle to ledger (%s)", ledgerErr)
 		}
@@ -432,20 +880,42 @@ func (chaincodeSupport *ChaincodeSupport) LaunchChaincode(context context.Contex
 				return cID, cMsg, fmt.Errorf("failed tx preexecution%s - %s", chaincode, err)
 			}
 		}
+		//the deployment tells which vm runs the chaincode, e.g. after a restart
+		depCds := &pb.ChaincodeDeploymentSpec{}
+		if proto.Unmarshal(depTx.Payload, depCds) == nil {
+			if err = chaincodeSupport.setResourceLimits(chaincode, depCds.ChaincodeSpec); err != nil {
+				return cID, cMsg, err
+			}
+			chaincodeSupport.setConcurrency(chaincode, depCds.ChaincodeSpec)
+			//the image of the chaincode was built from the package deployed
+			if chaincodeSupport.getVMType(chaincode) == container.DOCKER && len(depCds.CodePackage) > 0 {
+				fingerprint, fpErr := packageFingerprint(chaincode, depCds)
+				if fpErr != nil {
+					return cID, cMsg, fpErr
+				}
+				chaincodeSupport.setFingerprint(chaincode, fingerprint)
+			}
+		}
 	}
 
 	//from here on : if we launch the container and get an error, we need to stop the container
-	if !chaincodeSupport.userRunsCC && handler == nil {
+	if (!chaincodeSupport.userRunsCC || systemCC) && handler == nil {
 		_, err = chaincodeSupport.launchAndWaitForRegister(context, cID, t.Uuid)
 		if err != nil {
 			chaincodeLog.Debug("launchAndWaitForRegister failed %s", err)
 			return cID, cMsg, err
 		}
+	} else if handler == nil {
+		//dev mode, the user starts the chaincode
+		if err = chaincodeSupport.waitForDevRegister(cID, t.Uuid); err != nil {
+			chaincodeLog.Debug("waitForDevRegister failed %s", err)
+			return cID, cMsg, err
+		}
 	}
 
 	if err == nil {
 		//send init (if (f,args)) and wait for ready state
-		err = chaincodeSupport.sendInitOrReady(context, t.Uuid, chaincode, f, initargs, chaincodeSupport.ccStartupTimeout, t, depTx)
+		err = chaincodeSupport.sendInitOrReady(context, t.Uuid, chaincode, pb.ChaincodeMessage_INIT, f, initargs, chaincodeSupport.startupTimeout(), t, depTx)
 		if err != nil {
 			chaincodeLog.Debug("sending init failed(%s)", err)
 			err = fmt.Errorf("Failed to init chaincode(%s)", err)
@@ -494,6 +964,20 @@ func (chaincodeSupport *ChaincodeSupport) DeployChaincode(context context.Contex
 	}
 	chaincodeSupport.handlerMap.Unlock()
 
+	//scan the source for nondeterminism before building anything, system
+	//chaincode has no source and is trusted
+	if container.IsSystemChaincode(chaincode) {
+		chaincodeLog.Debug("deploying system chaincode %s", chaincode)
+	} else if err = analysis.CheckDeployment(cds); err != nil {
+		chaincodeLog.Error(fmt.Sprintf("deploy of %s failed static analysis: %s", chaincode, err))
+		return cds, err
+	}
+
+	if err = chaincodeSupport.setResourceLimits(chaincode, cds.ChaincodeSpec); err != nil {
+		return cds, err
+	}
+	chaincodeSupport.setConcurrency(chaincode, cds.ChaincodeSpec)
+
 	args, envs, err := chaincodeSupport.getArgsAndEnv(cID)
 	if err != nil {
 		return cds, fmt.Errorf("error getting args for chaincode %s", err)
@@ -501,11 +985,25 @@ func (chaincodeSupport *ChaincodeSupport) DeployChaincode(context context.Contex
 
 	vmname := container.GetVMFromName(chaincode)
 	var targz io.Reader = bytes.NewBuffer(cds.CodePackage)
+	//the image is stamped with the digest of the package it is built from,
+	//which the chaincode reports when it registers
+	if chaincodeSupport.getVMType(chaincode) == container.DOCKER && len(cds.CodePackage) > 0 {
+		fingerprint, err := packageFingerprint(chaincode, cds)
+		if err != nil {
+			return cds, err
+		}
+		pkg, err := stampPackage(cds.CodePackage, fingerprint)
+		if err != nil {
+			return cds, fmt.Errorf("Error stamping code package of %s: %s", chaincode, err)
+		}
+		targz = bytes.NewBuffer(pkg)
+		chaincodeSupport.setFingerprint(chaincode, fingerprint)
+	}
 	cir := &container.CreateImageReq{ID: vmname, Args: args, Reader: targz, Env: envs}
 
 	chaincodeLog.Debug("deploying chaincode %s", vmname)
 	//create image and create container
-	_, err = container.VMCProcess(context, "Docker", cir)
+	_, err = container.VMCProcess(context, chaincodeSupport.getVMType(chaincode), cir)
 	if err != nil {
 		err = fmt.Errorf("Error starting container: %s", err)
 	}
@@ -513,16 +1011,173 @@ func (chaincodeSupport *ChaincodeSupport) DeployChaincode(context context.Contex
 	return cds, err
 }
 
+// UpgradeChaincode replaces the running chaincode with the new version carried by the
+// deploy transaction t. The handler of the old version is quiesced while the new
+// version is deployed, launched and sent an UPGRADE message with the function and
+// args of the deployment spec's ctorMsg (the migrate function, if any). The new
+// version operates on the state of the old one. On success, registration is switched
+// atomically so that transactions for the old chaincode are routed to the new one,
+// and the old container is stopped. The switch is recorded on disk and restored
+// when the peer restarts. Deploy transactions whose spec names the chaincode they
+// upgrade are executed through here, see Execute.
+func (chaincodeSupport *ChaincodeSupport) UpgradeChaincode(context context.Context, chaincode string, t *pb.Transaction) (*pb.ChaincodeDeploymentSpec, error) {
+	if t.Type != pb.Transaction_CHAINCODE_DEPLOY {
+		return nil, fmt.Errorf("invalid transaction type for upgrade: %s", t.Type)
+	}
+	cds := &pb.ChaincodeDeploymentSpec{}
+	if err := proto.Unmarshal(t.Payload, cds); err != nil {
+		return nil, err
+	}
+	cID := cds.ChaincodeSpec.ChaincodeID
+	cMsg := cds.ChaincodeSpec.CtorMsg
+
+	chaincodeSupport.handlerMap.Lock()
+	chaincode = chaincodeSupport.resolveChaincodeName(chaincode)
+	oldHandler, ok := chaincodeSupport.chaincodeHasBeenLaunched(chaincode)
+	if !ok || !oldHandler.registered || !oldHandler.isRunning() {
+		chaincodeSupport.handlerMap.Unlock()
+		return cds, fmt.Errorf("cannot upgrade chaincode %s, it is not running", chaincode)
+	}
+	if oldHandler.isQuiesced() {
+		chaincodeSupport.handlerMap.Unlock()
+		return cds, fmt.Errorf("chaincode %s is already being upgraded", chaincode)
+	}
+	if chaincode == cID.Name {
+		chaincodeSupport.handlerMap.Unlock()
+		return cds, fmt.Errorf("cannot upgrade chaincode %s to itself", chaincode)
+	}
+	oldHandler.setQuiesced(true)
+	chaincodeSupport.handlerMap.namespaceMap[cID.Name] = oldHandler.getStateNamespace()
+	chaincodeSupport.handlerMap.Unlock()
+
+	var err error
+	defer func() {
+		if err != nil {
+			chaincodeSupport.handlerMap.Lock()
+			delete(chaincodeSupport.handlerMap.namespaceMap, cID.Name)
+			chaincodeSupport.handlerMap.Unlock()
+			oldHandler.setQuiesced(false)
+		}
+	}()
+
+	chaincodeLog.Debug("upgrading chaincode %s to %s", chaincode, cID.Name)
+	if err = oldHandler.waitForIdle(chaincodeSupport.ccStartupTimeout); err != nil {
+		return cds, err
+	}
+
+	if _, err = chaincodeSupport.DeployChaincode(context, t); err != nil {
+		return cds, fmt.Errorf("Failed to deploy upgraded chaincode %s: %s", cID.Name, err)
+	}
+
+	if !chaincodeSupport.userRunsCC {
+		if _, err = chaincodeSupport.launchAndWaitForRegister(context, cID, t.Uuid); err != nil {
+			return cds, err
+		}
+	}
+
+	var f *string
+	var migrateArgs []string
+	if cMsg != nil && (cMsg.Function != "" || cMsg.Args != nil) {
+		f = &cMsg.Function
+		migrateArgs = cMsg.Args
+	}
+	if err = chaincodeSupport.sendInitOrReady(context, t.Uuid, cID.Name, pb.ChaincodeMessage_UPGRADE, f, migrateArgs, chaincodeSupport.startupTimeout(), t, nil); err != nil {
+		err = fmt.Errorf("Failed to upgrade chaincode %s(%s)", chaincode, err)
+		if errIgnore := chaincodeSupport.StopChaincode(context, cID); errIgnore != nil {
+			chaincodeLog.Debug("stop failed %s(%s)", errIgnore, err)
+		}
+		return cds, err
+	}
+
+	//switch registration over to the upgraded chaincode, recorded first so
+	//that the peer still routes to it after a restart
+	chaincodeSupport.handlerMap.Lock()
+	if err = chaincodeSupport.recordUpgrade(chaincode, cID.Name); err != nil {
+		chaincodeSupport.handlerMap.Unlock()
+		err = fmt.Errorf("Failed to record upgrade of chaincode %s: %s", chaincode, err)
+		if errIgnore := chaincodeSupport.StopChaincode(context, cID); errIgnore != nil {
+			chaincodeLog.Debug("stop failed %s(%s)", errIgnore, err)
+		}
+		return cds, err
+	}
+	delete(chaincodeSupport.handlerMap.chaincodeMap, chaincode)
+	chaincodeSupport.handlerMap.upgradedMap[chaincode] = cID.Name
+	chaincodeSupport.handlerMap.Unlock()
+
+	chaincodeLog.Debug("upgraded chaincode %s to %s", chaincode, cID.Name)
+
+	//the old container is no longer reachable, stop it
+	if !chaincodeSupport.userRunsCC {
+		sir := container.StopImageReq{ID: container.GetVMFromName(chaincode), Timeout: 0}
+		if _, errIgnore := container.VMCProcess(context, chaincodeSupport.getVMType(chaincode), sir); errIgnore != nil {
+			chaincodeLog.Debug("error stopping upgraded chaincode %s: %s", chaincode, errIgnore)
+		}
+	}
+
+	return cds, nil
+}
+
 // Register the bidi stream entry point called by chaincode to register with the Peer.
+// The stream is served by the chaincode support of the chain named by its
+// REGISTER message, or by this one if the message names no chain.
 func (chaincodeSupport *ChaincodeSupport) Register(stream pb.ChaincodeSupport_RegisterServer) error {
-	return HandleChaincodeStream(chaincodeSupport, stream)
+	return interceptor.ServeStream(stream, registerMethod, func(intercepted grpc.ServerStream) error {
+		chainStream := registerServerStream{intercepted}
+		first, err := chainStream.Recv()
+		if err != nil {
+			return err
+		}
+		chain := chaincodeSupport
+		if first.ChainID != "" && ChainName(first.ChainID) != chaincodeSupport.name {
+			if chain = GetChain(ChainName(first.ChainID)); chain == nil {
+				return fmt.Errorf("Chaincode registered for unknown chain %s", first.ChainID)
+			}
+		}
+		return HandleChaincodeStream(chain, &peekedStream{chainStream, first})
+	})
+}
+
+// peekedStream gives back the message read from a stream before it is handed
+// over
+type peekedStream struct {
+	pb.ChaincodeSupport_RegisterServer
+	first *pb.ChaincodeMessage
+}
+
+func (s *peekedStream) Recv() (*pb.ChaincodeMessage, error) {
+	if first := s.first; first != nil {
+		s.first = nil
+		return first, nil
+	}
+	return s.ChaincodeSupport_RegisterServer.Recv()
+}
+
+// registerMethod is the gRPC method of the chaincode support streams
+const registerMethod = "/protos.ChaincodeSupport/Register"
+
+// registerServerStream gives an intercepted chaincode support stream back
+// its message types
+type registerServerStream struct {
+	grpc.ServerStream
+}
+
+func (s registerServerStream) Send(msg *pb.ChaincodeMessage) error {
+	return s.SendMsg(msg)
+}
+
+func (s registerServerStream) Recv() (*pb.ChaincodeMessage, error) {
+	msg := new(pb.ChaincodeMessage)
+	if err := s.RecvMsg(msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
 }
 
 // createTransactionMessage creates a transaction message.
 func createTransactionMessage(uuid string, cMsg *pb.ChaincodeInput) (*pb.ChaincodeMessage, error) {
 	payload, err := proto.Marshal(cMsg)
 	if err != nil {
-		fmt.Printf(err.Error())
+		chaincodeLog.Error(err.Error())
 		return nil, err
 	}
 	return &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_TRANSACTION, Payload: payload, Uuid: uuid}, nil
@@ -537,20 +1192,53 @@ func createQueryMessage(uuid string, cMsg *pb.ChaincodeInput) (*pb.ChaincodeMess
 	return &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_QUERY, Payload: payload, Uuid: uuid}, nil
 }
 
-// Execute executes a transaction and waits for it to complete until a timeout value.
-func (chaincodeSupport *ChaincodeSupport) Execute(ctxt context.Context, chaincode string, msg *pb.ChaincodeMessage, timeout time.Duration, tx *pb.Transaction) (*pb.ChaincodeMessage, error) {
+// prepareExecute returns the handler of the instance of chaincode msg is to be
+// executed by and the deadline by which it must complete, which is set on msg
+func (chaincodeSupport *ChaincodeSupport) prepareExecute(ctxt context.Context, chaincode string, msg *pb.ChaincodeMessage, timeout time.Duration) (*Handler, time.Time, error) {
 	chaincodeSupport.handlerMap.Lock()
+	chaincode = chaincodeSupport.resolveChaincodeName(chaincode)
 	//we expect the chaincode to be running... sanity check
 	handler, ok := chaincodeSupport.chaincodeHasBeenLaunched(chaincode)
 	if !ok {
 		chaincodeSupport.handlerMap.Unlock()
 		chaincodeLog.Debug("cannot execute-chaincode is not running: %s", chaincode)
-		return nil, fmt.Errorf("Cannot execute transaction or query for %s", chaincode)
+		return nil, time.Time{}, fmt.Errorf("Cannot execute transaction or query for %s", chaincode)
 	}
+	handler = chaincodeSupport.nextHandler(chaincode, handler)
 	chaincodeSupport.handlerMap.Unlock()
 
+	if handler.isQuiesced() {
+		chaincodeLog.Debug("cannot execute-chaincode is being upgraded: %s", chaincode)
+		return nil, time.Time{}, fmt.Errorf("Cannot execute transaction or query for %s while it is being upgraded", chaincode)
+	}
+
+	//the chaincode must complete by the deadline of the caller's context or
+	//after timeout, whichever comes first
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctxt.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	msg.Deadline = &google_protobuf.Timestamp{Seconds: deadline.Unix(), Nanos: int32(deadline.Nanosecond())}
+	msg.ChainID = string(chaincodeSupport.name)
+	if msg.TraceContext == nil {
+		msg.TraceContext = tracing.SpanFromContext(ctxt).Context()
+	}
+	return handler, deadline, nil
+}
+
+// Execute executes a transaction and waits for it to complete until a timeout value.
+func (chaincodeSupport *ChaincodeSupport) Execute(ctxt context.Context, chaincode string, msg *pb.ChaincodeMessage, timeout time.Duration, tx *pb.Transaction) (*pb.ChaincodeMessage, error) {
+	handler, deadline, err := chaincodeSupport.prepareExecute(ctxt, chaincode, msg, timeout)
+	if err != nil {
+		return nil, err
+	}
+	release, err := chaincodeSupport.admit(ctxt, handler.ChaincodeID.Name, deadline, tx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
 	var notfy chan *pb.ChaincodeMessage
-	var err error
 	if notfy, err = handler.sendExecuteMessage(msg, tx); err != nil {
 		return nil, fmt.Errorf("Error sending %s: %s", msg.Type.String(), err)
 	}
@@ -560,11 +1248,16 @@ func (chaincodeSupport *ChaincodeSupport) Execute(ctxt context.Context, chaincod
 		if ccresp.Type == pb.ChaincodeMessage_ERROR || ccresp.Type == pb.ChaincodeMessage_QUERY_ERROR {
 			err = fmt.Errorf(string(ccresp.Payload))
 		}
-	case <-time.After(timeout):
+	case <-time.After(deadline.Sub(time.Now())):
 		err = fmt.Errorf("Timeout expired while executing transaction")
+		handler.abortTransaction(msg, err)
+	case <-ctxt.Done():
+		err = fmt.Errorf("Transaction canceled: %s", ctxt.Err())
+		handler.abortTransaction(msg, err)
 	}
 
 	//our responsibility to delete transaction context if sendExecuteMessage succeeded
+	handler.finishExecuteSpan(msg.Uuid, err)
 	handler.deleteTxContext(msg.Uuid)
 
 	return ccresp, err
@@ -0,0 +1,88 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/container"
+	pb "github.com/hyperledger/fabric/protos"
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+)
+
+func TestContainerLogError(t *testing.T) {
+	startErr := fmt.Errorf("Timeout expired while starting chaincode mycc(tx:1)")
+
+	err := containerLogError(startErr, 2, []byte("panic: missing config\ngoroutine 1 [running]:\n"))
+	if !strings.HasPrefix(err.Error(), startErr.Error()) || !strings.HasSuffix(err.Error(), "output:\npanic: missing config\ngoroutine 1 [running]:") {
+		t.Fatalf("Expected the container output in the error, got %q", err)
+	}
+
+	if err = containerLogError(startErr, 2, nil); !strings.Contains(err.Error(), "no output") {
+		t.Fatalf("Expected an empty output to be reported, got %q", err)
+	}
+}
+
+func TestLaunchRetriesUnregisteredChaincode(t *testing.T) {
+	viper.Set("peer.fileSystemPath", "/var/hyperledger/test/tmpdb")
+	getPeerEndpoint := func() (*pb.PeerEndpoint, error) {
+		return &pb.PeerEndpoint{ID: &pb.PeerID{Name: "testpeer"}, Address: "0.0.0.0:40303"}, nil
+	}
+	chain := NewChaincodeSupport(DefaultChain, getPeerEndpoint, false, 200*time.Millisecond, nil)
+	chain.startupLogLines = 0
+	chain.startupBackoff = 10 * time.Millisecond
+
+	// Hangs without registering until its third launch
+	var launches int32
+	container.RegisterSystemChaincode("hangingsyscc", func(name string, stream container.ChaincodeStream) error {
+		if atomic.AddInt32(&launches, 1) < 3 {
+			stream.Recv()
+			return nil
+		}
+		return shim.StartInProc(name, stream, &kvChaincode{})
+	})
+	ctxt := context.Background()
+	cID := &pb.ChaincodeID{Name: "hangingsyscc"}
+
+	chain.startupRetries = 1
+	_, err := chain.launchAndWaitForRegister(ctxt, cID, "1")
+	if err == nil || !strings.Contains(err.Error(), "in 2 attempt(s)") {
+		t.Fatalf("Expected the launch to fail after 2 attempts, got %v", err)
+	}
+	if _, launched := chain.chaincodeHasBeenLaunched("hangingsyscc"); launched {
+		t.Fatal("Expected the failed chaincode not to be left launched")
+	}
+
+	atomic.StoreInt32(&launches, 1)
+	chain.startupRetries = 2
+	defer chain.StopChaincode(ctxt, cID)
+	if _, err = chain.launchAndWaitForRegister(ctxt, cID, "2"); err != nil {
+		t.Fatalf("Expected the chaincode to register once launched again: %s", err)
+	}
+	if n := atomic.LoadInt32(&launches); n != 3 {
+		t.Fatalf("Expected the chaincode to be launched twice more, was %d times", n-1)
+	}
+}
@@ -0,0 +1,59 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// splitResponse splits a RESPONSE whose payload is larger than chunkSize into
+// RESPONSE_CHUNK messages for the shim to reassemble. The response is returned
+// as is if it is small enough or chunkSize is not positive.
+func splitResponse(msg *pb.ChaincodeMessage, chunkSize int) ([]*pb.ChaincodeMessage, error) {
+	if chunkSize <= 0 || len(msg.Payload) <= chunkSize {
+		return []*pb.ChaincodeMessage{msg}, nil
+	}
+	var chunks []*pb.ChaincodeMessage
+	for seq, offset := uint32(0), 0; offset < len(msg.Payload); seq, offset = seq+1, offset+chunkSize {
+		end := offset + chunkSize
+		if end > len(msg.Payload) {
+			end = len(msg.Payload)
+		}
+		payload, err := proto.Marshal(&pb.ChaincodeResponseChunk{Sequence: seq, Data: msg.Payload[offset:end], Last: end == len(msg.Payload)})
+		if err != nil {
+			return nil, fmt.Errorf("Error marshalling %s %d: %s", pb.ChaincodeMessage_RESPONSE_CHUNK, seq, err)
+		}
+		chunks = append(chunks, &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE_CHUNK, Payload: payload, Uuid: msg.Uuid, Timestamp: msg.Timestamp})
+	}
+	return chunks, nil
+}
+
+// responseChunkSize returns the size above which responses to this chaincode
+// are chunked, 0 if its shim cannot reassemble them
+func (handler *Handler) responseChunkSize() int {
+	if handler.protocolVersion < pb.ChaincodeProtocolV3 || handler.chaincodeSupport == nil {
+		return 0
+	}
+	return handler.chaincodeSupport.responseChunkSize
+}
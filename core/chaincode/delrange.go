@@ -0,0 +1,155 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// delStateRangeMaxKeys returns the bound on the keys one DEL_STATE_RANGE may
+// delete, the lower of that of the peer and that of the request
+func (handler *Handler) delStateRangeMaxKeys(req *pb.DelStateRange) int {
+	maxKeys := 0
+	if handler.chaincodeSupport != nil {
+		maxKeys = handler.chaincodeSupport.delStateRangeMaxKeys
+	}
+	if req.MaxKeys > 0 && (maxKeys <= 0 || int(req.MaxKeys) < maxKeys) {
+		maxKeys = int(req.MaxKeys)
+	}
+	return maxKeys
+}
+
+// keysWithPrefix returns the keys of chaincodeID with prefix, sorted, those
+// written by the transaction of rw included and those it deleted excluded.
+// It stops once more than maxKeys are found, 0 is unbounded.
+func keysWithPrefix(store StateStore, rw *readWriteSet, chaincodeID string, prefix string, maxKeys int) ([]string, error) {
+	var written map[string]bool
+	if rw != nil {
+		written = rw.prefixWrites(chaincodeID, prefix)
+	}
+	var keys []string
+	full := func() bool { return maxKeys > 0 && len(keys) > maxKeys }
+	for key, isDelete := range written {
+		if !isDelete && !full() {
+			keys = append(keys, key)
+		}
+	}
+
+	// The scan is not in key order with every state, the buckettree returns
+	// the keys bucket by bucket, so no key ends it early
+	committed := rw != nil && rw.snapshot
+	itr, err := store.GetStateRangeScanIterator(chaincodeID, prefix, prefixEnd(prefix), committed)
+	if err != nil {
+		return nil, err
+	}
+	defer itr.Close()
+	for !full() && itr.Next() {
+		key, _ := itr.GetKeyValue()
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if _, ok := written[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// prefixEnd returns the least key greater than all the keys with prefix, ""
+// if there is none, to end range scans of the keys with prefix
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
+
+// delStateRange deletes the keys with the prefix of the DEL_STATE_RANGE msg,
+// or counts them for a dry run, and returns the payload of the RESPONSE.
+// Nothing is deleted when there are more keys than the bound.
+func (handler *Handler) delStateRange(msg *pb.ChaincodeMessage, store StateStore, chaincodeID string) ([]byte, pb.ChaincodeError_Code, map[string]string, error) {
+	req := &pb.DelStateRange{}
+	if err := proto.Unmarshal(msg.Payload, req); err != nil {
+		return nil, pb.ChaincodeError_MALFORMED, nil, err
+	}
+	details := map[string]string{"prefix": req.Prefix}
+	if req.Prefix == "" {
+		return nil, pb.ChaincodeError_INVALID_ARGUMENT, details, fmt.Errorf("%s needs a prefix", msg.Type)
+	}
+
+	maxKeys := handler.delStateRangeMaxKeys(req)
+	rw := handler.readWriteSet(msg.Uuid)
+	keys, err := keysWithPrefix(store, rw, chaincodeID, req.Prefix, maxKeys)
+	handler.traceStateOp(msg.Uuid, msg.Type, req.Prefix, err)
+	if err != nil {
+		return nil, pb.ChaincodeError_LEDGER, details, err
+	}
+	if maxKeys > 0 && len(keys) > maxKeys {
+		details["maxKeys"] = strconv.Itoa(maxKeys)
+		return nil, pb.ChaincodeError_INVALID_ARGUMENT, details, fmt.Errorf("More than %d keys with prefix %s", maxKeys, req.Prefix)
+	}
+
+	if !req.DryRun {
+		if err = handler.deleteKeys(msg, store, rw, chaincodeID, keys); err != nil {
+			return nil, pb.ChaincodeError_LEDGER, details, err
+		}
+		handler.log(msg).Debug("Deleted %d keys with prefix %s", len(keys), req.Prefix)
+	}
+	payload, err := proto.Marshal(&pb.DelStateRangeResponse{Count: int32(len(keys))})
+	if err != nil {
+		return nil, pb.ChaincodeError_UNKNOWN, details, err
+	}
+	return payload, pb.ChaincodeError_UNKNOWN, nil, nil
+}
+
+// deleteKeys deletes keys the way DEL_STATE deletes one: recorded in the
+// read-write set of the transaction if it has one, otherwise journaled then
+// deleted from the state
+func (handler *Handler) deleteKeys(msg *pb.ChaincodeMessage, store StateStore, rw *readWriteSet, chaincodeID string, keys []string) error {
+	if rw != nil {
+		for _, key := range keys {
+			rw.delState(chaincodeID, key)
+		}
+		return nil
+	}
+	state := handler.chaincodeSupport.stateAccess(store)
+	writes := make([]*journalWrite, len(keys))
+	deletes := make([]*stateWriteOp, len(keys))
+	for i, key := range keys {
+		writes[i] = &journalWrite{ChaincodeID: chaincodeID, Key: key, IsDelete: true}
+		deletes[i] = &stateWriteOp{chaincodeID: chaincodeID, key: key, isDelete: true}
+	}
+	if err := handler.chaincodeSupport.journalWrites(msg.ChainID, msg.Uuid, writes, false); err != nil {
+		return err
+	}
+	return handler.chaincodeSupport.writeState(state, deletes...)
+}
@@ -0,0 +1,160 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/core/ledger"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func delStateRangeMessage(t *testing.T, prefix string, maxKeys int32, dryRun bool) *pb.ChaincodeMessage {
+	payload, err := proto.Marshal(&pb.DelStateRange{Prefix: prefix, MaxKeys: maxKeys, DryRun: dryRun})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_DEL_STATE_RANGE, Payload: payload, Uuid: "tx1"}
+}
+
+func delStateRangeCount(t *testing.T, payload []byte) int32 {
+	resp := &pb.DelStateRangeResponse{}
+	if err := proto.Unmarshal(payload, resp); err != nil {
+		t.Fatalf("Error unmarshalling response: %s", err)
+	}
+	return resp.Count
+}
+
+func TestDelStateRange(t *testing.T) {
+	store := NewMemStateStore()
+	for _, key := range []string{"user/1", "user/2", "user/3", "users", "order/1"} {
+		store.SetState("mycc", key, []byte(key))
+	}
+	handler := &Handler{chaincodeSupport: &ChaincodeSupport{delStateRangeMaxKeys: 3}}
+
+	res, _, _, err := handler.delStateRange(delStateRangeMessage(t, "user/", 0, true), store, "mycc")
+	if err != nil || delStateRangeCount(t, res) != 3 {
+		t.Fatalf("Expected a dry run to count 3 keys, got %v", err)
+	}
+	if v, _ := store.GetState("mycc", "user/1", false); v == nil {
+		t.Fatal("Expected a dry run not to delete anything")
+	}
+
+	// the request may lower the bound of the peer, not raise it
+	for _, maxKeys := range []int32{2, 0} {
+		_, code, details, err := handler.delStateRange(delStateRangeMessage(t, "user", maxKeys, false), store, "mycc")
+		if err == nil || code != pb.ChaincodeError_INVALID_ARGUMENT || details["maxKeys"] == "" {
+			t.Fatalf("Expected more keys than the bound to be rejected, got %v", err)
+		}
+	}
+	if v, _ := store.GetState("mycc", "users", false); v == nil {
+		t.Fatal("Expected nothing deleted when the bound is exceeded")
+	}
+	if _, code, _, err := handler.delStateRange(delStateRangeMessage(t, "", 0, false), store, "mycc"); err == nil || code != pb.ChaincodeError_INVALID_ARGUMENT {
+		t.Fatal("Expected an empty prefix to be rejected")
+	}
+
+	res, _, _, err = handler.delStateRange(delStateRangeMessage(t, "user/", 0, false), store, "mycc")
+	if err != nil || delStateRangeCount(t, res) != 3 {
+		t.Fatalf("Expected 3 keys deleted, got %v", err)
+	}
+	for key, deleted := range map[string]bool{"user/1": true, "user/3": true, "users": false, "order/1": false} {
+		if v, _ := store.GetState("mycc", key, false); (v == nil) != deleted {
+			t.Fatalf("Expected %s deleted: %t", key, deleted)
+		}
+	}
+}
+
+func TestDelStateRangeReadWriteSet(t *testing.T) {
+	store := NewMemStateStore()
+	store.SetState("mycc", "k1", []byte("1"))
+	store.SetState("mycc", "k2", []byte("2"))
+	handler := &Handler{chaincodeSupport: &ChaincodeSupport{rwsets: newRWSetStore()}}
+	rw := handler.readWriteSet("tx1")
+	rw.putState("mycc", "k3", []byte("3"))
+	rw.delState("mycc", "k2")
+
+	res, _, _, err := handler.delStateRange(delStateRangeMessage(t, "k", 0, false), store, "mycc")
+	if err != nil || delStateRangeCount(t, res) != 2 {
+		t.Fatalf("Expected the keys written by the transaction counted and those it deleted not, got %v", err)
+	}
+	if v, _ := store.GetState("mycc", "k1", false); v == nil {
+		t.Fatal("Expected the deletes to wait for the transaction to commit")
+	}
+	for _, key := range []string{"k1", "k3"} {
+		if v, _ := rw.getState(store, "mycc", key, false); v != nil {
+			t.Fatalf("Expected %s deleted in the read-write set", key)
+		}
+	}
+}
+
+func TestDelStateRangeLedger(t *testing.T) {
+	viper.Set("peer.fileSystemPath", "/var/hyperledger/test/tmpdb")
+	ledgerObj, err := ledger.GetLedger()
+	if err != nil {
+		t.Fatalf("Error getting the ledger: %s", err)
+	}
+	// The buckettree scans the committed keys bucket by bucket, not in key
+	// order
+	if err = ledgerObj.BeginTxBatch("delrange"); err != nil {
+		t.Fatal(err)
+	}
+	ledgerObj.TxBegin("delrange-tx")
+	for i := 0; i < 20; i++ {
+		for _, prefix := range []string{"a/", "user/", "users/", "z/"} {
+			key := fmt.Sprintf("%s%02d", prefix, i)
+			if err = ledgerObj.SetState("delrangecc", key, []byte(key)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	ledgerObj.TxFinished("delrange-tx", true)
+	if err = ledgerObj.CommitTxBatch("delrange", nil, nil, nil); err != nil {
+		t.Fatalf("Error committing the keys: %s", err)
+	}
+
+	keys, err := keysWithPrefix(ledgerObj, nil, "delrangecc", "user/", 0)
+	if err != nil {
+		t.Fatalf("Error scanning the keys: %s", err)
+	}
+	if len(keys) != 20 {
+		t.Fatalf("Expected the 20 keys with the prefix, got %v", keys)
+	}
+	for i, key := range keys {
+		if key != fmt.Sprintf("user/%02d", i) {
+			t.Fatalf("Expected the keys with the prefix sorted, got %v", keys)
+		}
+	}
+	if keys, err = keysWithPrefix(ledgerObj, nil, "delrangecc", "user", 25); err != nil || len(keys) != 26 {
+		t.Fatalf("Expected the scan to stop past the bound, got %d keys: %v", len(keys), err)
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	for prefix, end := range map[string]string{"user/": "user0", "a\xff": "b", "\xff\xff": ""} {
+		if got := prefixEnd(prefix); got != end {
+			t.Fatalf("Expected the end of %q to be %q, got %q", prefix, end, got)
+		}
+	}
+}
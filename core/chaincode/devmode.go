@@ -0,0 +1,81 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// In dev mode (chaincode.mode set to DevModeUserRunsChaincode) no container is
+// launched. The developer starts the chaincode binary locally, possibly under a
+// debugger, and it registers with the peer like a launched chaincode would.
+
+// devStartupTimeoutDefault is used when chaincode.dev.startuptimeout is not set
+const devStartupTimeoutDefault = 5 * time.Minute
+
+// startupTimeout returns how long to wait for a chaincode to register and
+// become ready, relaxed in dev mode where it is started by hand
+func (chaincodeSupport *ChaincodeSupport) startupTimeout() time.Duration {
+	if chaincodeSupport.userRunsCC {
+		return chaincodeSupport.devStartupTimeout
+	}
+	return chaincodeSupport.ccStartupTimeout
+}
+
+// waitForDevRegister waits, in dev mode, for the developer to start chaincode
+// cID and for it to register, in place of launching its container
+func (chaincodeSupport *ChaincodeSupport) waitForDevRegister(cID *pb.ChaincodeID, uuid string) error {
+	chaincode := cID.Name
+	chaincodeSupport.handlerMap.Lock()
+	if handler, ok := chaincodeSupport.chaincodeHasBeenLaunched(chaincode); ok {
+		chaincodeSupport.handlerMap.Unlock()
+		if handler.registered {
+			return nil
+		}
+		return fmt.Errorf("premature execution - chaincode (%s) is being launched", chaincode)
+	}
+	notfy := chaincodeSupport.preLaunchSetup(chaincode)
+	chaincodeSupport.handlerMap.Unlock()
+
+	timeout := chaincodeSupport.startupTimeout()
+	chaincodeLog.Info("Waiting up to %s for chaincode %s to be started and register(tx:%s)", timeout, chaincode, uuid)
+
+	var err error
+	select {
+	case ok := <-notfy:
+		if !ok {
+			err = fmt.Errorf("registration failed for %s(tx:%s)", chaincode, uuid)
+		}
+	case <-time.After(timeout):
+		err = fmt.Errorf("Timeout expired while waiting for chaincode %s to register(tx:%s)", chaincode, uuid)
+	}
+	if err != nil {
+		//remove the placeholder unless a chaincode registered meanwhile
+		chaincodeSupport.handlerMap.Lock()
+		if handler, ok := chaincodeSupport.chaincodeHasBeenLaunched(chaincode); ok && !handler.registered {
+			delete(chaincodeSupport.handlerMap.chaincodeMap, chaincode)
+		}
+		chaincodeSupport.handlerMap.Unlock()
+	}
+	return err
+}
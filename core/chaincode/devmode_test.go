@@ -0,0 +1,92 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"testing"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func newDevModeTestSupport(userRunsCC bool) *ChaincodeSupport {
+	return &ChaincodeSupport{
+		handlerMap:        &handlerMap{chaincodeMap: make(map[string]*Handler), upgradedMap: make(map[string]string), namespaceMap: make(map[string]string)},
+		userRunsCC:        userRunsCC,
+		devStartupTimeout: 50 * time.Millisecond,
+	}
+}
+
+func TestDevModeReplacesRegisteredChaincode(t *testing.T) {
+	for _, userRunsCC := range []bool{false, true} {
+		chaincodeSupport := newDevModeTestSupport(userRunsCC)
+		first := &Handler{ChaincodeID: &pb.ChaincodeID{Name: "mycc"}}
+		second := &Handler{ChaincodeID: &pb.ChaincodeID{Name: "mycc"}}
+		if err := chaincodeSupport.registerHandler(first); err != nil {
+			t.Fatalf("Error registering chaincode: %s", err)
+		}
+		err := chaincodeSupport.registerHandler(second)
+		if !userRunsCC {
+			if err == nil {
+				t.Fatal("Expected duplicate registration to be rejected outside of dev mode")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Expected restarted chaincode to register in dev mode, got %s", err)
+		}
+		if h, _ := chaincodeSupport.chaincodeHasBeenLaunched("mycc"); h != second {
+			t.Fatal("Expected the restarted chaincode to replace the previous instance")
+		}
+	}
+}
+
+func TestDevModeWaitsForRegister(t *testing.T) {
+	chaincodeSupport := newDevModeTestSupport(true)
+	cID := &pb.ChaincodeID{Name: "mycc"}
+
+	done := make(chan error, 1)
+	go func() { done <- chaincodeSupport.waitForDevRegister(cID, "tx1") }()
+	for {
+		chaincodeSupport.handlerMap.Lock()
+		_, ok := chaincodeSupport.chaincodeHasBeenLaunched("mycc")
+		chaincodeSupport.handlerMap.Unlock()
+		if ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	handler := &Handler{ChaincodeID: cID}
+	if err := chaincodeSupport.registerHandler(handler); err != nil {
+		t.Fatalf("Error registering chaincode: %s", err)
+	}
+	handler.notifyDuringStartup(true)
+	if err := <-done; err != nil {
+		t.Fatalf("Expected wait to end with registration, got %s", err)
+	}
+
+	// Nobody starts this one, the placeholder is removed on timeout
+	if err := chaincodeSupport.waitForDevRegister(&pb.ChaincodeID{Name: "other"}, "tx2"); err == nil {
+		t.Fatal("Expected timeout waiting for a chaincode that is never started")
+	}
+	if _, ok := chaincodeSupport.chaincodeHasBeenLaunched("other"); ok {
+		t.Fatal("Expected placeholder to be removed after timeout")
+	}
+}
@@ -0,0 +1,215 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/hyperledger/fabric/core/util"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// Policies applied when a chaincode registers while another instance of it
+// is registered, selected by chaincode.duplicateRegistration in core.yaml
+const (
+	// the new instance is refused with REGISTER_FAILED
+	DuplicateReject = "reject"
+	// the new instance serves the chaincode from now on
+	DuplicateReplace = "replace"
+	// transactions and queries are spread over all the instances
+	DuplicateLoadBalance = "loadbalance"
+)
+
+// getDuplicatePolicy validates policy, defaulting to replace in development
+// mode, where the developer restarts the chaincode, and to reject otherwise
+func getDuplicatePolicy(policy string, userRunsCC bool) (string, error) {
+	switch policy {
+	case DuplicateReject, DuplicateReplace, DuplicateLoadBalance:
+		return policy, nil
+	case "":
+		if userRunsCC {
+			return DuplicateReplace, nil
+		}
+		return DuplicateReject, nil
+	}
+	return "", fmt.Errorf("Unknown duplicate registration policy %s", policy)
+}
+
+// Policies applied to a request of a chaincode whose uuid has another request
+// pending, selected by chaincode.duplicateRequest in core.yaml
+const (
+	// the request is answered with an ERROR coded DUPLICATE_REQUEST, shims
+	// predating ChaincodeProtocolV9 get no answer
+	DuplicateRequestReject = "reject"
+	// the request is served once the pending one is answered
+	DuplicateRequestQueue = "queue"
+)
+
+// getDuplicateRequestPolicy validates policy, defaulting to reject
+func getDuplicateRequestPolicy(policy string) (string, error) {
+	switch policy {
+	case DuplicateRequestReject, DuplicateRequestQueue:
+		return policy, nil
+	case "":
+		return DuplicateRequestReject, nil
+	}
+	return "", fmt.Errorf("Unknown duplicate request policy %s", policy)
+}
+
+//returns the policy applied when a chaincode registers twice
+func (chaincodeSupport *ChaincodeSupport) duplicateRegistrationPolicy() string {
+	policy, err := getDuplicatePolicy(chaincodeSupport.duplicatePolicy, chaincodeSupport.userRunsCC)
+	if err != nil {
+		return DuplicateReject
+	}
+	return policy
+}
+
+// handlerReplicas are the instances of a chaincode registered in addition to
+// the one in chaincodeMap under the loadbalance policy
+type handlerReplicas struct {
+	handlers []*Handler
+	// index of the instance the next transaction goes to, chaincodeMap's
+	// being the last
+	next int
+}
+
+//call this under lock
+func (chaincodeSupport *ChaincodeSupport) addReplica(chaincode string, handler *Handler) {
+	if chaincodeSupport.handlerMap.replicaMap == nil {
+		chaincodeSupport.handlerMap.replicaMap = make(map[string]*handlerReplicas)
+	}
+	replicas := chaincodeSupport.handlerMap.replicaMap[chaincode]
+	if replicas == nil {
+		replicas = &handlerReplicas{}
+		chaincodeSupport.handlerMap.replicaMap[chaincode] = replicas
+	}
+	replicas.handlers = append(replicas.handlers, handler)
+}
+
+//call this under lock
+//returns whether handler was a replica of chaincode
+func (chaincodeSupport *ChaincodeSupport) removeReplica(chaincode string, handler *Handler) bool {
+	replicas := chaincodeSupport.handlerMap.replicaMap[chaincode]
+	if replicas == nil {
+		return false
+	}
+	for i, h := range replicas.handlers {
+		if h == handler {
+			replicas.handlers = append(replicas.handlers[:i], replicas.handlers[i+1:]...)
+			if len(replicas.handlers) == 0 {
+				delete(chaincodeSupport.handlerMap.replicaMap, chaincode)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+//call this under lock
+//promotes a running replica of chaincode to replace its deregistered handler
+func (chaincodeSupport *ChaincodeSupport) promoteReplica(chaincode string) {
+	replicas := chaincodeSupport.handlerMap.replicaMap[chaincode]
+	if replicas == nil {
+		return
+	}
+	for _, h := range replicas.handlers {
+		if h.isRunning() {
+			chaincodeSupport.removeReplica(chaincode, h)
+			chaincodeSupport.handlerMap.chaincodeMap[chaincode] = h
+			chaincodeLogger.Info("chaincode %s now served by a former replica", chaincode)
+			return
+		}
+	}
+}
+
+//call this under lock
+//returns the handler the next transaction or query of chaincode goes to, in
+//turn each running instance registered for it
+func (chaincodeSupport *ChaincodeSupport) nextHandler(chaincode string, handler *Handler) *Handler {
+	replicas := chaincodeSupport.handlerMap.replicaMap[chaincode]
+	if replicas == nil {
+		return handler
+	}
+	for range replicas.handlers {
+		if replicas.next >= len(replicas.handlers) {
+			replicas.next = 0
+			return handler
+		}
+		h := replicas.handlers[replicas.next]
+		replicas.next++
+		if h.isRunning() {
+			return h
+		}
+	}
+	replicas.next = 0
+	return handler
+}
+
+// readyReplica moves handler to the ready state, as LaunchChaincode does for
+// the first instance of a chaincode, if it registered as a replica under the
+// loadbalance policy
+func (chaincodeSupport *ChaincodeSupport) readyReplica(handler *Handler) {
+	chaincode := handler.ChaincodeID.Name
+	chaincodeSupport.handlerMap.Lock()
+	replicas := chaincodeSupport.handlerMap.replicaMap[chaincode]
+	isReplica := false
+	if replicas != nil {
+		for _, h := range replicas.handlers {
+			isReplica = isReplica || h == handler
+		}
+	}
+	var depTx *pb.Transaction
+	if primary, ok := chaincodeSupport.chaincodeHasBeenLaunched(chaincode); ok && primary.deployTXSecContext != nil {
+		depTx = proto.Clone(primary.deployTXSecContext).(*pb.Transaction)
+	}
+	chaincodeSupport.handlerMap.Unlock()
+	if !isReplica {
+		return
+	}
+	if depTx == nil {
+		chaincodeLog.Error(fmt.Sprintf("Replica of chaincode %s cannot be readied before the first instance", chaincode))
+		return
+	}
+
+	go func() {
+		err := chaincodeSupport.sendInitOrReadyTo(handler, util.GenerateUUID(), chaincode, pb.ChaincodeMessage_INIT, nil, nil, chaincodeSupport.startupTimeout(), depTx, depTx)
+		if err != nil {
+			chaincodeLog.Error(fmt.Sprintf("Replica of chaincode %s did not become ready: %s", chaincode, err))
+			return
+		}
+		chaincodeLogger.Info("Replica of chaincode %s ready", chaincode)
+	}()
+}
+
+// sendRegisterFailed tells the shim why its REGISTER was refused before the
+// stream is closed
+func (handler *Handler) sendRegisterFailed(reason pb.ChaincodeRegisterFailure_Reason, err error) {
+	payload, marshalErr := proto.Marshal(&pb.ChaincodeRegisterFailure{Reason: reason, Message: err.Error()})
+	if marshalErr != nil {
+		handler.log(nil).Error("Error marshalling %s payload: %s", pb.ChaincodeMessage_REGISTER_FAILED, marshalErr)
+		return
+	}
+	if sendErr := handler.serialSend(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_REGISTER_FAILED, Payload: payload}); sendErr != nil {
+		handler.log(nil).Debug("Error sending %s: %s", pb.ChaincodeMessage_REGISTER_FAILED, sendErr)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/looplab/fsm"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func newRunningHandler(name string) *Handler {
+	return &Handler{ChaincodeID: &pb.ChaincodeID{Name: name}, FSM: fsm.NewFSM(readystate, nil, nil)}
+}
+
+func TestGetDuplicatePolicy(t *testing.T) {
+	if policy, _ := getDuplicatePolicy("", false); policy != DuplicateReject {
+		t.Fatalf("Expected duplicates to be rejected by default, got %s", policy)
+	}
+	if policy, _ := getDuplicatePolicy("", true); policy != DuplicateReplace {
+		t.Fatalf("Expected duplicates to replace in dev mode, got %s", policy)
+	}
+	if policy, _ := getDuplicatePolicy(DuplicateLoadBalance, true); policy != DuplicateLoadBalance {
+		t.Fatalf("Expected the configured policy, got %s", policy)
+	}
+	if _, err := getDuplicatePolicy("bogus", false); err == nil {
+		t.Fatal("Expected an unknown policy to be refused")
+	}
+}
+
+func TestGetDuplicateRequestPolicy(t *testing.T) {
+	if policy, _ := getDuplicateRequestPolicy(""); policy != DuplicateRequestReject {
+		t.Fatalf("Expected duplicate requests to be rejected by default, got %s", policy)
+	}
+	if policy, _ := getDuplicateRequestPolicy(DuplicateRequestQueue); policy != DuplicateRequestQueue {
+		t.Fatalf("Expected the configured policy, got %s", policy)
+	}
+	if _, err := getDuplicateRequestPolicy("drop"); err == nil {
+		t.Fatal("Expected an unknown policy to be refused")
+	}
+}
+
+func TestDuplicateRegistrationLoadBalance(t *testing.T) {
+	chaincodeSupport := newDevModeTestSupport(false)
+	chaincodeSupport.duplicatePolicy = DuplicateLoadBalance
+	first := newRunningHandler("mycc")
+	second := newRunningHandler("mycc")
+	starting := &Handler{ChaincodeID: &pb.ChaincodeID{Name: "mycc"}, FSM: fsm.NewFSM(establishedstate, nil, nil)}
+	for _, h := range []*Handler{first, second, starting} {
+		if err := chaincodeSupport.registerHandler(h); err != nil {
+			t.Fatalf("Error registering chaincode: %s", err)
+		}
+	}
+	if h, _ := chaincodeSupport.chaincodeHasBeenLaunched("mycc"); h != first {
+		t.Fatal("Expected the first instance to stay registered")
+	}
+
+	// instances not ready yet are skipped
+	var picked []*Handler
+	for i := 0; i < 4; i++ {
+		picked = append(picked, chaincodeSupport.nextHandler("mycc", first))
+	}
+	if picked[0] != second || picked[1] != first || picked[2] != second || picked[3] != first {
+		t.Fatal("Expected transactions to alternate between the running instances")
+	}
+
+	// a running replica takes over from a deregistered first instance
+	if err := chaincodeSupport.deregisterHandler(first, nil); err != nil {
+		t.Fatalf("Error deregistering chaincode: %s", err)
+	}
+	if h, _ := chaincodeSupport.chaincodeHasBeenLaunched("mycc"); h != second {
+		t.Fatal("Expected the running replica to be promoted")
+	}
+	if err := chaincodeSupport.deregisterHandler(starting, nil); err != nil {
+		t.Fatalf("Error deregistering replica: %s", err)
+	}
+	if _, ok := chaincodeSupport.handlerMap.replicaMap["mycc"]; ok {
+		t.Fatal("Expected no replica to be left")
+	}
+	if err := chaincodeSupport.deregisterHandler(starting, nil); err == nil {
+		t.Fatal("Expected a deregistered replica to be unknown")
+	}
+}
+
+func TestDuplicateRegisterFailed(t *testing.T) {
+	chaincodeSupport := newDevModeTestSupport(false)
+	if err := chaincodeSupport.registerHandler(newRunningHandler("mycc")); err != nil {
+		t.Fatalf("Error registering chaincode: %s", err)
+	}
+
+	stream := newMockChaincodeStream()
+	handler := newChaincodeSupportHandler(chaincodeSupport, stream)
+	defer handler.stopWriter()
+	payload, _ := proto.Marshal(&pb.ChaincodeRegistration{Name: "mycc"})
+	if err := handler.HandleMessage(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_REGISTER, Payload: payload}); err == nil {
+		t.Fatal("Expected the duplicate registration to be refused")
+	}
+
+	sent := <-stream.sendCh
+	failure := &pb.ChaincodeRegisterFailure{}
+	if err := proto.Unmarshal(sent.Payload, failure); err != nil {
+		t.Fatalf("Error unmarshalling %s: %s", sent.Type, err)
+	}
+	if sent.Type != pb.ChaincodeMessage_REGISTER_FAILED || failure.Reason != pb.ChaincodeRegisterFailure_DUPLICATE || failure.Message == "" {
+		t.Fatalf("Expected REGISTER_FAILED for a duplicate, got %s %s", sent.Type, failure)
+	}
+}
@@ -0,0 +1,47 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/events/producer"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// registerEventSchemas registers the schemas of the events chaincode declared
+// at REGISTER with the event hub. Shims declare them from protocol version 12,
+// those of older shims are ignored.
+func (handler *Handler) registerEventSchemas(chaincode string, schemas []*pb.ChaincodeEventSchema) error {
+	if len(schemas) == 0 {
+		return nil
+	}
+	if handler.protocolVersion < pb.ChaincodeProtocolV12 {
+		chaincodeLogger.Warning("Ignoring the event schemas of chaincode %s, protocol version %d does not register them", chaincode, handler.protocolVersion)
+		return nil
+	}
+	for _, schema := range schemas {
+		if err := producer.RegisterChaincodeEventSchema(chaincode, schema.EventType, producer.NewJSONSchema(schema.RequiredFields...)); err != nil {
+			return fmt.Errorf("Error registering the schema of event type %s: %s", schema.EventType, err)
+		}
+	}
+	chaincodeLogger.Debug("Registered %d event schemas of chaincode %s", len(schemas), chaincode)
+	return nil
+}
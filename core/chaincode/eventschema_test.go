@@ -0,0 +1,77 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/events/producer"
+	pb "github.com/hyperledger/fabric/protos"
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+)
+
+// eventChaincode is a kvChaincode declaring the schema of its events
+type eventChaincode struct {
+	kvChaincode
+}
+
+func (cc *eventChaincode) EventSchemas() []*pb.ChaincodeEventSchema {
+	return []*pb.ChaincodeEventSchema{{EventType: "kv.put", RequiredFields: []string{"key", "value"}}}
+}
+
+func TestChaincodeRegistersEventSchemas(t *testing.T) {
+	viper.Set("peer.fileSystemPath", "/var/hyperledger/test/tmpdb")
+	getPeerEndpoint := func() (*pb.PeerEndpoint, error) {
+		return &pb.PeerEndpoint{ID: &pb.PeerID{Name: "testpeer"}, Address: "0.0.0.0:40303"}, nil
+	}
+	NewChaincodeSupport(DefaultChain, getPeerEndpoint, false, 10*time.Second, nil)
+
+	if err := RegisterSystemChaincode(&SystemChaincode{Name: "eventsyscc", Chaincode: &eventChaincode{}}); err != nil {
+		t.Fatalf("Error registering system chaincode: %s", err)
+	}
+	cID := &pb.ChaincodeID{Name: "eventsyscc"}
+	ctxt := context.Background()
+	spec := &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_GOLANG, ChaincodeID: cID, CtorMsg: &pb.ChaincodeInput{Function: "put", Args: []string{"a", "1"}}}
+	if _, _, err := invoke(ctxt, spec, pb.Transaction_CHAINCODE_INVOKE); err != nil {
+		t.Fatalf("Error invoking chaincode: %s", err)
+	}
+
+	// the schema the chaincode declared at REGISTER validates its events
+	if err := producer.Send(producer.CreateGenericEvent("kv.put", []byte(`{"key":"a"}`))); err == nil {
+		t.Fatal("Expected an event missing a required field to be refused")
+	}
+	if err := producer.Send(producer.CreateGenericEvent("kv.put", []byte(`{"key":"a","value":"1"}`))); err != nil {
+		t.Fatalf("Expected a valid event to be sent, got %s", err)
+	}
+
+	// the chaincode registers its schemas again when it is restarted
+	GetChain(DefaultChain).StopChaincode(ctxt, cID)
+	if _, _, err := invoke(ctxt, spec, pb.Transaction_CHAINCODE_INVOKE); err != nil {
+		t.Fatalf("Error invoking the restarted chaincode: %s", err)
+	}
+	GetChain(DefaultChain).StopChaincode(ctxt, cID)
+
+	// the event types of a chaincode are its own
+	if err := producer.RegisterChaincodeEventSchema("othercc", "kv.put", producer.NewJSONSchema()); err == nil {
+		t.Fatal("Expected another chaincode to be refused the event type")
+	}
+}
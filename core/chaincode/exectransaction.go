@@ -27,6 +27,7 @@ import (
 	"github.com/golang/protobuf/proto"
 	"golang.org/x/net/context"
 
+	"github.com/hyperledger/fabric/core/container"
 	"github.com/hyperledger/fabric/core/ledger"
 	pb "github.com/hyperledger/fabric/protos"
 )
@@ -36,7 +37,7 @@ func Execute(ctxt context.Context, chain *ChaincodeSupport, t *pb.Transaction) (
 	var err error
 
 	// get a handle to ledger to mark the begin/finish of a tx
-	ledger, ledgerErr := ledger.GetLedger()
+	ledger, ledgerErr := chain.getLedger("")
 	if ledgerErr != nil {
 		return nil, fmt.Errorf("Failed to get handle to ledger (%s)", ledgerErr)
 	}
@@ -50,26 +51,60 @@ func Execute(ctxt context.Context, chain *ChaincodeSupport, t *pb.Transaction) (
 		}
 	}
 
-	if t.Type == pb.Transaction_CHAINCODE_DEPLOY {
+	// Chaincodes compiled into the peer are not deployed, nor replaced by a deploy
+	if name, upgraded := deployedChaincode(t); container.IsSystemChaincode(name) || container.IsSystemChaincode(upgraded) {
+		return nil, fmt.Errorf("Failed to deploy chaincode %s: system chaincodes cannot be deployed or upgraded by a deploy", name)
+	}
+
+	// Drop whatever is left of the read-write set when the transaction did not commit it
+	defer chain.discardReadWriteSet(t.Uuid)
+	// The ledger applied or discarded the journaled writes once Execute returns
+	defer chain.finishJournal(t.Uuid)
+	// A result not committed by then is not replayed
+	defer chain.commitResult(t.Uuid, false)
+
+	if upgraded := upgradedByDeployment(t); upgraded != "" {
+		//the new version is deployed and launched, and migrates the state
+		//of the old one within the transaction
+		markTxBegin(chain, ledger, t)
+		if _, err = chain.UpgradeChaincode(ctxt, upgraded, t); err != nil {
+			markTxFinish(chain, ledger, t, false)
+			return nil, fmt.Errorf("Failed to upgrade chaincode %s(%s)", upgraded, err)
+		}
+		if err = chain.commitReadWriteSet(t.Uuid, chain.stateAccess(chain.stateStoreOf(ledger))); err != nil {
+			markTxFinish(chain, ledger, t, false)
+			return nil, fmt.Errorf("Failed to validate transaction %s: %s", t.Uuid, err)
+		}
+		markTxFinish(chain, ledger, t, true)
+	} else if t.Type == pb.Transaction_CHAINCODE_DEPLOY {
 		_, err := chain.DeployChaincode(ctxt, t)
 		if err != nil {
 			return nil, fmt.Errorf("Failed to deploy chaincode spec(%s)", err)
 		}
 
 		//launch and wait for ready
-		markTxBegin(ledger, t)
+		markTxBegin(chain, ledger, t)
 		_, _, err = chain.LaunchChaincode(ctxt, t)
 		if err != nil {
-			markTxFinish(ledger, t, false)
+			markTxFinish(chain, ledger, t, false)
 			return nil, fmt.Errorf("%s", err)
 		}
-		markTxFinish(ledger, t, true)
+		if err = chain.commitReadWriteSet(t.Uuid, chain.stateAccess(chain.stateStoreOf(ledger))); err != nil {
+			markTxFinish(chain, ledger, t, false)
+			return nil, fmt.Errorf("Failed to validate transaction %s: %s", t.Uuid, err)
+		}
+		markTxFinish(chain, ledger, t, true)
 	} else if t.Type == pb.Transaction_CHAINCODE_INVOKE || t.Type == pb.Transaction_CHAINCODE_QUERY {
 		//will launch if necessary (and wait for ready)
 		cID, cMsg, err := chain.LaunchChaincode(ctxt, t)
 		if err != nil {
 			return nil, fmt.Errorf("Failed to launch chaincode spec(%s)", err)
 		}
+		if err = t.CheckTransient(); err != nil {
+			return nil, err
+		}
+		// Delivered to the chaincode only, the payload never carries it
+		cMsg.Transient = t.Transient
 
 		//this should work because it worked above...
 		chaincode := cID.Name
@@ -79,7 +114,7 @@ func Execute(ctxt context.Context, chain *ChaincodeSupport, t *pb.Transaction) (
 		}
 
 		// TODO: Need to comment next line and uncomment call to getTimeout, when transaction blocks are being created
-		timeout := time.Duration(30000) * time.Millisecond
+		timeout := chain.getExecTimeout()
 		//timeout, err := getTimeout(cID)
 
 		if err != nil {
@@ -99,27 +134,45 @@ func Execute(ctxt context.Context, chain *ChaincodeSupport, t *pb.Transaction) (
 			}
 		}
 
-		markTxBegin(ledger, t)
+		// The writes kept in a read-write set reach the ledger once validated,
+		// the ledger transaction then only spans the commit and the
+		// transactions of the chaincode execute concurrently
+		buffered := chain.rwsets != nil
+		if !buffered {
+			markTxBegin(chain, ledger, t)
+		}
 		resp, err := chain.Execute(ctxt, chaincode, ccMsg, timeout, t)
-		if err != nil {
+		if buffered {
+			markTxBegin(chain, ledger, t)
+		}
+		if qfErr, ok := err.(*QueueFullError); ok {
+			// Rollback transaction, callers may retry later
+			markTxFinish(chain, ledger, t, false)
+			return nil, qfErr
+		} else if err != nil {
 			// Rollback transaction
-			markTxFinish(ledger, t, false)
+			markTxFinish(chain, ledger, t, false)
 			return nil, fmt.Errorf("Failed to execute transaction or query(%s)", err)
 		} else if resp == nil {
 			// Rollback transaction
-			markTxFinish(ledger, t, false)
+			markTxFinish(chain, ledger, t, false)
 			return nil, fmt.Errorf("Failed to receive a response for (%s)", t.Uuid)
 		} else {
 			if resp.Type == pb.ChaincodeMessage_COMPLETED || resp.Type == pb.ChaincodeMessage_QUERY_COMPLETED {
+				// Validate the reads and apply the writes of the simulated transaction
+				if err = chain.commitReadWriteSet(t.Uuid, chain.stateAccess(chain.stateStoreOf(ledger))); err != nil {
+					markTxFinish(chain, ledger, t, false)
+					return nil, fmt.Errorf("Failed to validate transaction %s: %s", t.Uuid, err)
+				}
 				// Success
-				markTxFinish(ledger, t, true)
+				markTxFinish(chain, ledger, t, true)
 				return resp.Payload, nil
 			} else if resp.Type == pb.ChaincodeMessage_ERROR || resp.Type == pb.ChaincodeMessage_QUERY_ERROR {
 				// Rollback transaction
-				markTxFinish(ledger, t, false)
+				markTxFinish(chain, ledger, t, false)
 				return nil, fmt.Errorf("Transaction or query returned with failure: %s", string(resp.Payload))
 			}
-			markTxFinish(ledger, t, false)
+			markTxFinish(chain, ledger, t, false)
 			return resp.Payload, fmt.Errorf("receive a response for (%s) but in invalid state(%d)", t.Uuid, resp.Type)
 		}
 
@@ -129,6 +182,94 @@ func Execute(ctxt context.Context, chain *ChaincodeSupport, t *pb.Transaction) (
 	return nil, err
 }
 
+// QueryAsync starts query t like Execute but returns once the query is sent to
+// the chaincode, see ChaincodeSupport.ExecuteAsync. The result is polled or
+// waited for on the PendingExecution returned.
+func QueryAsync(ctxt context.Context, chain *ChaincodeSupport, t *pb.Transaction) (*PendingExecution, error) {
+	if t.Type != pb.Transaction_CHAINCODE_QUERY {
+		return nil, fmt.Errorf("Only queries can be executed asynchronously, got %s", t.Type)
+	}
+
+	if secHelper := chain.getSecHelper(); nil != secHelper {
+		var err error
+		t, err = secHelper.TransactionPreExecution(t)
+		if nil != err {
+			return nil, err
+		}
+	}
+
+	cID, cMsg, err := chain.LaunchChaincode(ctxt, t)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to launch chaincode spec(%s)", err)
+	}
+	if err = t.CheckTransient(); err != nil {
+		return nil, err
+	}
+	cMsg.Transient = t.Transient
+
+	ccMsg, err := createQueryMessage(t.Uuid, cMsg)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to query message(%s)", err)
+	}
+	return chain.ExecuteAsync(ctxt, cID.Name, ccMsg, chain.getExecTimeout(), t)
+}
+
+// Simulate executes an invoke transaction against the committed state without
+// committing anything. Reads and writes are captured in a read-write set that
+// is returned with the response instead of being applied. A chaincode that
+// fails yields a FAILURE response together with what it read and wrote
+// before failing.
+func Simulate(ctxt context.Context, chain *ChaincodeSupport, t *pb.Transaction) (*pb.SimulationResult, error) {
+	if t.Type != pb.Transaction_CHAINCODE_INVOKE {
+		return nil, fmt.Errorf("Only invoke transactions can be simulated, got %s", t.Type)
+	}
+
+	if secHelper := chain.getSecHelper(); nil != secHelper {
+		var err error
+		t, err = secHelper.TransactionPreExecution(t)
+		if nil != err {
+			return nil, err
+		}
+	}
+
+	cID, cMsg, err := chain.LaunchChaincode(ctxt, t)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to launch chaincode spec(%s)", err)
+	}
+	if err = t.CheckTransient(); err != nil {
+		return nil, err
+	}
+	cMsg.Transient = t.Transient
+
+	ccMsg, err := createTransactionMessage(t.Uuid, cMsg)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to transaction message(%s)", err)
+	}
+
+	// Registered before the transaction reaches the chaincode so every state
+	// operation, including those of chaincodes it invokes, is captured
+	rw := newReadWriteSet()
+	rw.snapshot = true
+	chain.simulations.add(t.Uuid, rw)
+	defer chain.simulations.remove(t.Uuid)
+	// Nothing is committed, the transaction is executed again when submitted
+	defer chain.commitResult(t.Uuid, false)
+
+	result := &pb.SimulationResult{Uuid: t.Uuid}
+	resp, err := chain.Execute(ctxt, cID.Name, ccMsg, chain.getExecTimeout(), t)
+	if err != nil {
+		result.Response = &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(err.Error())}
+	} else if resp == nil {
+		result.Response = &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(fmt.Sprintf("Failed to receive a response for (%s)", t.Uuid))}
+	} else if resp.Type == pb.ChaincodeMessage_COMPLETED {
+		result.Response = &pb.Response{Status: pb.Response_SUCCESS, Msg: resp.Payload}
+	} else {
+		result.Response = &pb.Response{Status: pb.Response_FAILURE, Msg: resp.Payload}
+	}
+	result.Reads, result.Writes = rw.toProto()
+	return result, nil
+}
+
 //ExecuteTransactions - will execute transactions on the array one by one
 //will return an array of errors one for each transaction. If the execution
 //succeeded, array element will be nil. returns state hash
@@ -139,10 +280,37 @@ func ExecuteTransactions(ctxt context.Context, cname ChainName, xacts []*pb.Tran
 		panic(fmt.Sprintf("[ExecuteTransactions]Chain %s not found\n", cname))
 	}
 	errs := make([]error, len(xacts)+1)
-	for i, t := range xacts {
-		_, errs[i] = Execute(ctxt, chain, t)
+	// Transactions expired for longer than the tolerance at the time of the
+	// batch are not executed. Every validator executes the same batch, so its
+	// time is taken from the batch rather than from the local clock
+	expiredBefore := batchTimestamp(xacts).Add(-chain.expiryTolerance)
+	for i := 0; i < len(xacts); {
+		t := xacts[i]
+		if t.IsExpired(expiredBefore) {
+			chaincodeLogger.Warning("Not executing expired transaction %s", t.Uuid)
+			errs[i] = fmt.Errorf("Transaction %s expired", t.Uuid)
+			i++
+			continue
+		}
+		if !chain.batchBlocks || t.Type != pb.Transaction_CHAINCODE_INVOKE {
+			_, errs[i] = Execute(ctxt, chain, t)
+			i++
+			continue
+		}
+		// The invokes that follow one another execute as a batch
+		end := i + 1
+		for end < len(xacts) && xacts[end].Type == pb.Transaction_CHAINCODE_INVOKE && !xacts[end].IsExpired(expiredBefore) {
+			end++
+		}
+		for j, result := range chain.ExecuteBatch(ctxt, xacts[i:end], chain.batchTimeout) {
+			if result.Err == nil && result.Response.Type != pb.ChaincodeMessage_COMPLETED {
+				result.Err = fmt.Errorf("Transaction or query returned with failure: %s", string(result.Response.Payload))
+			}
+			errs[i+j] = result.Err
+		}
+		i = end
 	}
-	ledger, hasherr := ledger.GetLedger()
+	ledger, hasherr := chain.getLedger("")
 	var statehash []byte
 	if hasherr == nil {
 		statehash, hasherr = ledger.GetTempStateHash()
@@ -151,6 +319,22 @@ func ExecuteTransactions(ctxt context.Context, cname ChainName, xacts []*pb.Tran
 	return statehash, errs
 }
 
+// batchTimestamp returns the latest timestamp among the transactions of the
+// batch, or the zero time when none carries one
+func batchTimestamp(xacts []*pb.Transaction) time.Time {
+	var latest time.Time
+	for _, t := range xacts {
+		if t == nil || t.Timestamp == nil {
+			continue
+		}
+		ts := time.Unix(t.Timestamp.Seconds, int64(t.Timestamp.Nanos))
+		if ts.After(latest) {
+			latest = ts
+		}
+	}
+	return latest
+}
+
 // GetSecureContext returns the security context from the context object or error
 // Security context is nil if security is off from core.yaml file
 // func GetSecureContext(ctxt context.Context) (crypto.Peer, error) {
@@ -165,6 +349,29 @@ func ExecuteTransactions(ctxt context.Context, cname ChainName, xacts []*pb.Tran
 // 	return nil, err
 // }
 
+// upgradedByDeployment returns the chaincode deploy transaction t upgrades, ""
+// if t is not an upgrade
+func upgradedByDeployment(t *pb.Transaction) string {
+	_, upgraded := deployedChaincode(t)
+	return upgraded
+}
+
+// deployedChaincode returns the name of the chaincode deployed by t and of
+// the chaincode it upgrades, empty if t deploys nothing
+func deployedChaincode(t *pb.Transaction) (name, upgraded string) {
+	if t.Type != pb.Transaction_CHAINCODE_DEPLOY {
+		return "", ""
+	}
+	cds := &pb.ChaincodeDeploymentSpec{}
+	if err := proto.Unmarshal(t.Payload, cds); err != nil {
+		return "", ""
+	}
+	if cID := cds.GetChaincodeSpec().GetChaincodeID(); cID != nil {
+		name = cID.Name
+	}
+	return name, cds.Upgrades
+}
+
 var errFailedToGetChainCodeSpecForTransaction = errors.New("Failed to get ChainCodeSpec from Transaction")
 
 func getTimeout(cID *pb.ChaincodeID) (time.Duration, error) {
@@ -187,16 +394,21 @@ func getTimeout(cID *pb.ChaincodeID) (time.Duration, error) {
 	return -1, errFailedToGetChainCodeSpecForTransaction
 }
 
-func markTxBegin(ledger *ledger.Ledger, t *pb.Transaction) {
+// markTxBegin begins the ledger transaction of t, once the one in progress
+// on chain, if any, is finished
+func markTxBegin(chain *ChaincodeSupport, ledger *ledger.Ledger, t *pb.Transaction) {
 	if t.Type == pb.Transaction_CHAINCODE_QUERY {
 		return
 	}
+	chain.txLock.Lock()
 	ledger.TxBegin(t.Uuid)
 }
 
-func markTxFinish(ledger *ledger.Ledger, t *pb.Transaction, successful bool) {
+func markTxFinish(chain *ChaincodeSupport, ledger *ledger.Ledger, t *pb.Transaction, successful bool) {
 	if t.Type == pb.Transaction_CHAINCODE_QUERY {
 		return
 	}
 	ledger.TxFinished(t.Uuid, successful)
+	chain.commitResult(t.Uuid, successful)
+	chain.txLock.Unlock()
 }
@@ -0,0 +1,52 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"testing"
+	"time"
+
+	google_protobuf "google/protobuf"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestBatchTimestamp(t *testing.T) {
+	if ts := batchTimestamp(nil); !ts.IsZero() {
+		t.Fatalf("Expected zero time for an empty batch, got %v", ts)
+	}
+	xacts := []*pb.Transaction{
+		{Uuid: "a", Timestamp: &google_protobuf.Timestamp{Seconds: 100}},
+		{Uuid: "b"},
+		{Uuid: "c", Timestamp: &google_protobuf.Timestamp{Seconds: 300}},
+		{Uuid: "d", Timestamp: &google_protobuf.Timestamp{Seconds: 200}},
+	}
+	if ts := batchTimestamp(xacts); !ts.Equal(time.Unix(300, 0)) {
+		t.Fatalf("Expected the latest transaction timestamp, got %v", ts)
+	}
+	// Expiry is judged against the batch, not the local clock
+	expired := &pb.Transaction{Uuid: "e", Expiry: &google_protobuf.Timestamp{Seconds: 250}}
+	if !expired.IsExpired(batchTimestamp(xacts)) {
+		t.Fatal("Expected transaction expiring before the batch time to be expired")
+	}
+	if expired.IsExpired(time.Unix(200, 0)) {
+		t.Fatal("Expected transaction expiring after the batch time not to be expired")
+	}
+}
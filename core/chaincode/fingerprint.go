@@ -0,0 +1,122 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/core/util"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// FingerprintEnv is the environment variable of the chaincode images holding
+// the hex digest of the code package they were built from, reported by the
+// shim in REGISTER
+const FingerprintEnv = "CORE_CHAINCODE_FINGERPRINT"
+
+// packageFingerprint returns the digest of the code package of chaincode
+// deployed with cds, which must match the digest the deployer put in the
+// deployment spec if any
+func packageFingerprint(chaincode string, cds *pb.ChaincodeDeploymentSpec) ([]byte, error) {
+	fingerprint := util.ComputeCryptoHash(cds.CodePackage)
+	if len(cds.CodePackageHash) > 0 && !bytes.Equal(fingerprint, cds.CodePackageHash) {
+		return nil, fmt.Errorf("code package of chaincode %s does not match the hash of its deployment spec", chaincode)
+	}
+	return fingerprint, nil
+}
+
+// stampPackage returns the code package pkg, a gzipped tar, with its
+// Dockerfile setting FingerprintEnv to fingerprint in the image built
+func stampPackage(pkg []byte, fingerprint []byte) ([]byte, error) {
+	gr, err := gzip.NewReader(bytes.NewReader(pkg))
+	if err != nil {
+		return nil, fmt.Errorf("Error reading code package: %s", err)
+	}
+	tr := tar.NewReader(gr)
+	out := bytes.NewBuffer(nil)
+	gw := gzip.NewWriter(out)
+	tw := tar.NewWriter(gw)
+	stamped := false
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error reading code package: %s", err)
+		}
+		contents, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading code package: %s", err)
+		}
+		if header.Name == "Dockerfile" {
+			contents = append(contents, fmt.Sprintf("\nENV %s %s\n", FingerprintEnv, hex.EncodeToString(fingerprint))...)
+			header.Size = int64(len(contents))
+			stamped = true
+		}
+		if err = tw.WriteHeader(header); err != nil {
+			return nil, fmt.Errorf("Error writing code package: %s", err)
+		}
+		if _, err = tw.Write(contents); err != nil {
+			return nil, fmt.Errorf("Error writing code package: %s", err)
+		}
+	}
+	if !stamped {
+		return nil, fmt.Errorf("No Dockerfile in code package")
+	}
+	tw.Close()
+	gw.Close()
+	return out.Bytes(), nil
+}
+
+// setFingerprint records the fingerprint chaincode must register with
+func (chaincodeSupport *ChaincodeSupport) setFingerprint(chaincode string, fingerprint []byte) {
+	chaincodeSupport.handlerMap.Lock()
+	defer chaincodeSupport.handlerMap.Unlock()
+	if chaincodeSupport.handlerMap.fingerprintMap == nil {
+		chaincodeSupport.handlerMap.fingerprintMap = make(map[string][]byte)
+	}
+	chaincodeSupport.handlerMap.fingerprintMap[chaincode] = fingerprint
+}
+
+// verifyFingerprint checks the fingerprint reported in the REGISTER of
+// chaincode against the code package it was deployed with. Chaincodes whose
+// package is not known, such as those run by the user in development mode,
+// are not checked.
+func (chaincodeSupport *ChaincodeSupport) verifyFingerprint(chaincode string, fingerprint []byte) error {
+	if !viper.GetBool("chaincode.verifyFingerprint") {
+		return nil
+	}
+	chaincodeSupport.handlerMap.RLock()
+	expected, ok := chaincodeSupport.handlerMap.fingerprintMap[chaincode]
+	chaincodeSupport.handlerMap.RUnlock()
+	if ok && !bytes.Equal(expected, fingerprint) {
+		return fmt.Errorf("chaincode %s registered with fingerprint %x, deployed code package is %x", chaincode, fingerprint, expected)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/core/util"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// newTestPackage returns a gzipped tar holding files
+func newTestPackage(t *testing.T, files map[string]string) []byte {
+	buf := bytes.NewBuffer(nil)
+	gw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gw)
+	for name, contents := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Size: int64(len(contents)), Mode: 0644}); err != nil {
+			t.Fatalf("Error writing package: %s", err)
+		}
+		tw.Write([]byte(contents))
+	}
+	tw.Close()
+	gw.Close()
+	return buf.Bytes()
+}
+
+// readTestPackage returns the files of the gzipped tar pkg
+func readTestPackage(t *testing.T, pkg []byte) map[string]string {
+	gr, err := gzip.NewReader(bytes.NewReader(pkg))
+	if err != nil {
+		t.Fatalf("Error reading package: %s", err)
+	}
+	files := make(map[string]string)
+	tr := tar.NewReader(gr)
+	for header, err := tr.Next(); err == nil; header, err = tr.Next() {
+		contents, _ := ioutil.ReadAll(tr)
+		files[header.Name] = string(contents)
+	}
+	return files
+}
+
+func TestPackageFingerprint(t *testing.T) {
+	pkg := newTestPackage(t, map[string]string{"Dockerfile": "from scratch"})
+	cds := &pb.ChaincodeDeploymentSpec{CodePackage: pkg}
+	fingerprint, err := packageFingerprint("mycc", cds)
+	if err != nil || !bytes.Equal(fingerprint, util.ComputeCryptoHash(pkg)) {
+		t.Fatalf("Expected the digest of the package without a hash in the spec, got %x, %v", fingerprint, err)
+	}
+	cds.CodePackageHash = fingerprint
+	if _, err = packageFingerprint("mycc", cds); err != nil {
+		t.Fatalf("Error checking a matching package hash: %s", err)
+	}
+	cds.CodePackage = newTestPackage(t, map[string]string{"Dockerfile": "from evil"})
+	if _, err = packageFingerprint("mycc", cds); err == nil {
+		t.Fatal("Expected a package not matching the hash of the spec to be refused")
+	}
+}
+
+func TestStampPackage(t *testing.T) {
+	pkg := newTestPackage(t, map[string]string{"Dockerfile": "from scratch", "src/main.go": "package main"})
+	fingerprint := util.ComputeCryptoHash(pkg)
+	stamped, err := stampPackage(pkg, fingerprint)
+	if err != nil {
+		t.Fatalf("Error stamping package: %s", err)
+	}
+	files := readTestPackage(t, stamped)
+	if files["src/main.go"] != "package main" {
+		t.Fatalf("Expected the sources to be kept, got %v", files)
+	}
+	if !strings.HasPrefix(files["Dockerfile"], "from scratch") || !strings.Contains(files["Dockerfile"], "ENV "+FingerprintEnv+" "+hex.EncodeToString(fingerprint)) {
+		t.Fatalf("Expected the Dockerfile to set the fingerprint, got %q", files["Dockerfile"])
+	}
+	if _, err = stampPackage(newTestPackage(t, map[string]string{"src/main.go": "package main"}), fingerprint); err == nil {
+		t.Fatal("Expected a package without Dockerfile to be refused")
+	}
+}
+
+func TestRegisterFingerprintMismatch(t *testing.T) {
+	viper.Set("chaincode.verifyFingerprint", true)
+	chaincodeSupport := newDevModeTestSupport(false)
+	chaincodeSupport.setFingerprint("mycc", []byte("deployed"))
+
+	stream := newMockChaincodeStream()
+	handler := newChaincodeSupportHandler(chaincodeSupport, stream)
+	defer handler.stopWriter()
+	payload, _ := proto.Marshal(&pb.ChaincodeRegistration{Name: "mycc", Fingerprint: []byte("other")})
+	if err := handler.HandleMessage(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_REGISTER, Payload: payload}); err == nil {
+		t.Fatal("Expected the registration of another code package to be refused")
+	}
+
+	sent := <-stream.sendCh
+	failure := &pb.ChaincodeRegisterFailure{}
+	if err := proto.Unmarshal(sent.Payload, failure); err != nil {
+		t.Fatalf("Error unmarshalling %s: %s", sent.Type, err)
+	}
+	if sent.Type != pb.ChaincodeMessage_REGISTER_FAILED || failure.Reason != pb.ChaincodeRegisterFailure_FINGERPRINT {
+		t.Fatalf("Expected REGISTER_FAILED for the fingerprint, got %s %s", sent.Type, failure)
+	}
+	if err := chaincodeSupport.verifyFingerprint("mycc", []byte("deployed")); err != nil {
+		t.Fatalf("Error verifying the deployed fingerprint: %s", err)
+	}
+	if err := chaincodeSupport.verifyFingerprint("devcc", nil); err != nil {
+		t.Fatalf("Expected a chaincode without deployed package not to be checked: %s", err)
+	}
+}
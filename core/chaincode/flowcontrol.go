@@ -0,0 +1,109 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// sendWindow bounds the messages sent to a chaincode and not yet consumed by
+// its shim to the window negotiated at REGISTER. Messages beyond the window
+// are queued, up to maxQueued of them, until the shim returns credits with
+// CREDIT, so that a slow chaincode does not block Send on the stream shared
+// by all its transactions. It is protected by the handler lock.
+type sendWindow struct {
+	size      uint32
+	credits   uint32
+	maxQueued int
+	queue     []*pb.ChaincodeMessage
+}
+
+func newSendWindow(size uint32, maxQueued int) *sendWindow {
+	return &sendWindow{size: size, credits: size, maxQueued: maxQueued}
+}
+
+// send sends msgs as long as credits are available and queues the rest. Either
+// all of msgs are accepted or, if the queue cannot hold them, none is.
+func (w *sendWindow) send(msgs []*pb.ChaincodeMessage, send func(*pb.ChaincodeMessage) error) error {
+	// messages are only queued once the credits are exhausted
+	if len(w.queue)+len(msgs) > w.maxQueued+int(w.credits) {
+		return fmt.Errorf("%d messages queued for the chaincode waiting for %s, cannot queue %d more", len(w.queue), pb.ChaincodeMessage_CREDIT, len(msgs))
+	}
+	w.queue = append(w.queue, msgs...)
+	return w.drain(send)
+}
+
+// grant adds the credits returned by the shim and sends the queued messages
+// they allow. The shim cannot hold more than the window, extra credits are
+// ignored.
+func (w *sendWindow) grant(credits uint32, send func(*pb.ChaincodeMessage) error) error {
+	if w.credits += credits; w.credits > w.size {
+		w.credits = w.size
+	}
+	return w.drain(send)
+}
+
+func (w *sendWindow) drain(send func(*pb.ChaincodeMessage) error) error {
+	for len(w.queue) > 0 && w.credits > 0 {
+		msg := w.queue[0]
+		w.queue[0] = nil
+		w.queue = w.queue[1:]
+		w.credits--
+		if err := send(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// negotiateWindow returns the window the peer honors for a shim that asked for
+// requested, 0 if messages to the chaincode are not flow controlled
+func (handler *Handler) negotiateWindow(requested uint32) uint32 {
+	if handler.protocolVersion < pb.ChaincodeProtocolV4 || handler.chaincodeSupport == nil {
+		return 0
+	}
+	window := handler.chaincodeSupport.flowControlWindow
+	if window <= 0 {
+		return 0
+	}
+	if requested < uint32(window) {
+		return requested
+	}
+	return uint32(window)
+}
+
+// handleCredit returns the credits in a CREDIT message to the send window
+func (handler *Handler) handleCredit(msg *pb.ChaincodeMessage) error {
+	credit := &pb.ChaincodeCredit{}
+	if err := proto.Unmarshal(msg.Payload, credit); err != nil {
+		return fmt.Errorf("Error unmarshalling %s: %s", pb.ChaincodeMessage_CREDIT, err)
+	}
+	handler.Lock()
+	defer handler.Unlock()
+	if handler.window == nil {
+		handler.log(msg).Warning("Received %s from chaincode %s without flow control", pb.ChaincodeMessage_CREDIT, handler.ChaincodeID)
+		return nil
+	}
+	return handler.window.grant(credit.Credits, handler.send)
+}
@@ -0,0 +1,158 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"strings"
+
+	pb "github.com/hyperledger/fabric/protos"
+	"github.com/looplab/fsm"
+)
+
+const (
+	legacyFSM     = "legacy"
+	concurrentFSM = "concurrent"
+)
+
+// fsmTables are the state machines of the chaincode handler, selected by
+// chaincode.fsm in core.yaml. Event names are ChaincodeMessage types
+var fsmTables = map[string]fsm.Events{
+	legacyFSM:     legacyFSMTable,
+	concurrentFSM: concurrentFSMTable,
+}
+
+// legacyFSMTable serializes transactions, a TRANSACTION is only accepted
+// once the chaincode has COMPLETED the previous one
+var legacyFSMTable = fsm.Events{
+	//Send REGISTERED, then, if deploy { trigger INIT(via INIT) } else { trigger READY(via COMPLETED) }
+	{Name: pb.ChaincodeMessage_REGISTER.String(), Src: []string{createdstate}, Dst: establishedstate},
+	{Name: pb.ChaincodeMessage_INIT.String(), Src: []string{establishedstate}, Dst: initstate},
+	{Name: pb.ChaincodeMessage_UPGRADE.String(), Src: []string{establishedstate}, Dst: initstate},
+	{Name: pb.ChaincodeMessage_READY.String(), Src: []string{establishedstate}, Dst: readystate},
+	{Name: pb.ChaincodeMessage_TRANSACTION.String(), Src: []string{readystate}, Dst: transactionstate},
+	{Name: pb.ChaincodeMessage_PUT_STATE.String(), Src: []string{transactionstate}, Dst: busyxactstate},
+	{Name: pb.ChaincodeMessage_DEL_STATE.String(), Src: []string{transactionstate}, Dst: busyxactstate},
+	{Name: pb.ChaincodeMessage_DEL_STATE_RANGE.String(), Src: []string{transactionstate}, Dst: busyxactstate},
+	{Name: pb.ChaincodeMessage_INVOKE_CHAINCODE.String(), Src: []string{transactionstate}, Dst: busyxactstate},
+	{Name: pb.ChaincodeMessage_PUT_STATE.String(), Src: []string{initstate}, Dst: busyinitstate},
+	{Name: pb.ChaincodeMessage_DEL_STATE.String(), Src: []string{initstate}, Dst: busyinitstate},
+	{Name: pb.ChaincodeMessage_DEL_STATE_RANGE.String(), Src: []string{initstate}, Dst: busyinitstate},
+	{Name: pb.ChaincodeMessage_INVOKE_CHAINCODE.String(), Src: []string{initstate}, Dst: busyinitstate},
+	{Name: pb.ChaincodeMessage_COMPLETED.String(), Src: []string{initstate, readystate, transactionstate}, Dst: readystate},
+	{Name: pb.ChaincodeMessage_GET_STATE.String(), Src: []string{readystate}, Dst: readystate},
+	{Name: pb.ChaincodeMessage_GET_STATE.String(), Src: []string{initstate}, Dst: initstate},
+	{Name: pb.ChaincodeMessage_GET_STATE.String(), Src: []string{busyinitstate}, Dst: busyinitstate},
+	{Name: pb.ChaincodeMessage_GET_STATE.String(), Src: []string{transactionstate}, Dst: transactionstate},
+	{Name: pb.ChaincodeMessage_GET_STATE.String(), Src: []string{busyxactstate}, Dst: busyxactstate},
+	{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE.String(), Src: []string{readystate}, Dst: readystate},
+	{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE.String(), Src: []string{initstate}, Dst: initstate},
+	{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE.String(), Src: []string{busyinitstate}, Dst: busyinitstate},
+	{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE.String(), Src: []string{transactionstate}, Dst: transactionstate},
+	{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE.String(), Src: []string{busyxactstate}, Dst: busyxactstate},
+	{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE_NEXT.String(), Src: []string{readystate}, Dst: readystate},
+	{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE_NEXT.String(), Src: []string{initstate}, Dst: initstate},
+	{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE_NEXT.String(), Src: []string{busyinitstate}, Dst: busyinitstate},
+	{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE_NEXT.String(), Src: []string{transactionstate}, Dst: transactionstate},
+	{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE_NEXT.String(), Src: []string{busyxactstate}, Dst: busyxactstate},
+	{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE_CLOSE.String(), Src: []string{readystate}, Dst: readystate},
+	{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE_CLOSE.String(), Src: []string{initstate}, Dst: initstate},
+	{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE_CLOSE.String(), Src: []string{busyinitstate}, Dst: busyinitstate},
+	{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE_CLOSE.String(), Src: []string{transactionstate}, Dst: transactionstate},
+	{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE_CLOSE.String(), Src: []string{busyxactstate}, Dst: busyxactstate},
+	{Name: pb.ChaincodeMessage_GET_HISTORY_FOR_KEY.String(), Src: []string{readystate}, Dst: readystate},
+	{Name: pb.ChaincodeMessage_GET_HISTORY_FOR_KEY.String(), Src: []string{initstate}, Dst: initstate},
+	{Name: pb.ChaincodeMessage_GET_HISTORY_FOR_KEY.String(), Src: []string{busyinitstate}, Dst: busyinitstate},
+	{Name: pb.ChaincodeMessage_GET_HISTORY_FOR_KEY.String(), Src: []string{transactionstate}, Dst: transactionstate},
+	{Name: pb.ChaincodeMessage_GET_HISTORY_FOR_KEY.String(), Src: []string{busyxactstate}, Dst: busyxactstate},
+	{Name: pb.ChaincodeMessage_ERROR.String(), Src: []string{initstate}, Dst: endstate},
+	{Name: pb.ChaincodeMessage_ERROR.String(), Src: []string{transactionstate}, Dst: readystate},
+	{Name: pb.ChaincodeMessage_ERROR.String(), Src: []string{busyinitstate}, Dst: initstate},
+	{Name: pb.ChaincodeMessage_ERROR.String(), Src: []string{busyxactstate}, Dst: transactionstate},
+	{Name: pb.ChaincodeMessage_RESPONSE.String(), Src: []string{busyinitstate}, Dst: initstate},
+	{Name: pb.ChaincodeMessage_RESPONSE.String(), Src: []string{busyxactstate}, Dst: transactionstate},
+	// fired by the peer itself when the chaincode missed its heartbeats, the
+	// KEEPALIVE answers of the chaincode are handled outside of the FSM
+	{Name: pb.ChaincodeMessage_KEEPALIVE.String(), Src: []string{establishedstate, initstate, readystate, transactionstate, busyinitstate, busyxactstate}, Dst: endstate},
+}
+
+// concurrentFSMTable is experimental. It accepts a TRANSACTION while others
+// are still in flight, also while one of them waits on a state change, and
+// the handler only goes back to ready once the last one COMPLETED (see
+// beforeCompletedEvent). It requires chaincode.mvcc.enabled: the writes of
+// each transaction are kept in its read-write set, and applied to the ledger
+// one transaction at a time.
+var concurrentFSMTable = append(fsm.Events{
+	{Name: pb.ChaincodeMessage_TRANSACTION.String(), Src: []string{transactionstate}, Dst: transactionstate},
+	{Name: pb.ChaincodeMessage_TRANSACTION.String(), Src: []string{busyxactstate}, Dst: busyxactstate},
+	{Name: pb.ChaincodeMessage_COMPLETED.String(), Src: []string{busyxactstate}, Dst: busyxactstate},
+}, legacyFSMTable...)
+
+// getFSMTable returns the state machine named by chaincode.fsm in core.yaml,
+// the legacy one if none is named
+func getFSMTable(name string) (fsm.Events, error) {
+	if name == "" {
+		name = legacyFSM
+	}
+	table, ok := fsmTables[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown chaincode FSM %s", name)
+	}
+	return table, nil
+}
+
+// validateFSMTable checks that every event of table is a ChaincodeMessage
+// type and that the events and states the callbacks are registered for are
+// part of the table, a callback for anything else would silently never run
+func validateFSMTable(table fsm.Events, callbacks fsm.Callbacks) error {
+	events := make(map[string]bool)
+	states := map[string]bool{createdstate: true}
+	for _, e := range table {
+		if _, ok := pb.ChaincodeMessage_Type_value[e.Name]; !ok {
+			return fmt.Errorf("Event %s is not a ChaincodeMessage type", e.Name)
+		}
+		events[e.Name] = true
+		for _, src := range e.Src {
+			states[src] = true
+		}
+		states[e.Dst] = true
+	}
+
+	for key := range callbacks {
+		switch {
+		case key == "enter_state" || key == "leave_state" || key == "before_event" || key == "after_event":
+		case strings.HasPrefix(key, "before_") || strings.HasPrefix(key, "after_"):
+			name := key[strings.Index(key, "_")+1:]
+			if _, ok := pb.ChaincodeMessage_Type_value[name]; !ok {
+				return fmt.Errorf("Callback %s is not for a ChaincodeMessage type", key)
+			}
+			if !events[name] {
+				return fmt.Errorf("Callback %s is for an event missing from the FSM", key)
+			}
+		case strings.HasPrefix(key, "enter_") || strings.HasPrefix(key, "leave_"):
+			if !states[key[strings.Index(key, "_")+1:]] {
+				return fmt.Errorf("Callback %s is for a state missing from the FSM", key)
+			}
+		default:
+			return fmt.Errorf("Unknown FSM callback %s", key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"testing"
+
+	pb "github.com/hyperledger/fabric/protos"
+	"github.com/looplab/fsm"
+)
+
+func TestFSMTablesValidate(t *testing.T) {
+	callbacks := handlerCallbacks(&Handler{})
+	for name, table := range fsmTables {
+		if err := validateFSMTable(table, callbacks); err != nil {
+			t.Errorf("FSM %s: %s", name, err)
+		}
+	}
+}
+
+func TestGetFSMTable(t *testing.T) {
+	if table, err := getFSMTable(""); err != nil || len(table) != len(legacyFSMTable) {
+		t.Fatalf("Expected the legacy FSM by default, got %d events, %v", len(table), err)
+	}
+	if _, err := getFSMTable("bogus"); err == nil {
+		t.Fatal("Expected an unknown FSM to be refused")
+	}
+}
+
+func TestValidateFSMTableRejects(t *testing.T) {
+	noop := func(e *fsm.Event) {}
+	table := fsm.Events{
+		{Name: pb.ChaincodeMessage_REGISTER.String(), Src: []string{createdstate}, Dst: establishedstate},
+	}
+	for _, tc := range []struct {
+		table     fsm.Events
+		callbacks fsm.Callbacks
+	}{
+		{fsm.Events{{Name: "BOGUS", Src: []string{createdstate}, Dst: establishedstate}}, nil},
+		{table, fsm.Callbacks{"before_BOGUS": noop}},
+		{table, fsm.Callbacks{"after_" + pb.ChaincodeMessage_PUT_STATE.String(): noop}},
+		{table, fsm.Callbacks{"enter_" + busyxactstate: noop}},
+		{table, fsm.Callbacks{"bogus": noop}},
+	} {
+		if err := validateFSMTable(tc.table, tc.callbacks); err == nil {
+			t.Errorf("Expected %v with callbacks %v to be refused", tc.table, tc.callbacks)
+		}
+	}
+
+	callbacks := fsm.Callbacks{
+		"before_" + pb.ChaincodeMessage_REGISTER.String(): noop,
+		"enter_" + establishedstate:                       noop,
+		"enter_state":                                     noop,
+	}
+	if err := validateFSMTable(table, callbacks); err != nil {
+		t.Fatalf("Expected the table to be valid, got %s", err)
+	}
+}
+
+func TestConcurrentFSMAcceptsOverlappingTransactions(t *testing.T) {
+	machine := fsm.NewFSM(readystate, concurrentFSMTable, nil)
+	for _, step := range []struct {
+		event pb.ChaincodeMessage_Type
+		state string
+	}{
+		{pb.ChaincodeMessage_TRANSACTION, transactionstate},
+		{pb.ChaincodeMessage_TRANSACTION, transactionstate},
+		{pb.ChaincodeMessage_PUT_STATE, busyxactstate},
+		// another transaction arrives, or completes, while the put is served
+		{pb.ChaincodeMessage_TRANSACTION, busyxactstate},
+		{pb.ChaincodeMessage_COMPLETED, busyxactstate},
+		{pb.ChaincodeMessage_RESPONSE, transactionstate},
+		{pb.ChaincodeMessage_COMPLETED, readystate},
+	} {
+		if err := filterError(machine.Event(step.event.String())); err != nil {
+			t.Fatalf("Expected %s to be accepted in state %s: %s", step.event, machine.Current(), err)
+		}
+		if machine.Current() != step.state {
+			t.Fatalf("Expected state %s after %s, got %s", step.state, step.event, machine.Current())
+		}
+	}
+	if !machine.Cannot(pb.ChaincodeMessage_PUT_STATE.String()) {
+		t.Fatal("Expected state changes to be refused once no transaction is in flight")
+	}
+
+	legacy := fsm.NewFSM(transactionstate, legacyFSMTable, nil)
+	if !legacy.Cannot(pb.ChaincodeMessage_TRANSACTION.String()) {
+		t.Fatal("Expected the legacy FSM to serialize transactions")
+	}
+}
+
+func TestOtherTransactionsInFlight(t *testing.T) {
+	handler := &Handler{isTransaction: map[string]bool{"tx1": true, "query": false}}
+	if handler.otherTransactionsInFlight("tx1") {
+		t.Fatal("Expected queries not to count as transactions in flight")
+	}
+	handler.markIsTransaction("tx2", true)
+	if !handler.otherTransactionsInFlight("tx1") {
+		t.Fatal("Expected tx2 to be in flight")
+	}
+}
@@ -22,6 +22,7 @@ package chaincode
 import (
 	"fmt"
 	"io"
+	"runtime/debug"
 	"sync"
 	"time"
 
@@ -30,6 +31,8 @@ import (
 	"github.com/op/go-logging"
 	"github.com/hyperledger/fabric/core/crypto"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	"github.com/hyperledger/fabric/core/opevents"
+	"github.com/hyperledger/fabric/core/tracing"
 	"github.com/hyperledger/fabric/core/util"
 	pb "github.com/hyperledger/fabric/protos"
 	"golang.org/x/net/context"
@@ -43,8 +46,8 @@ const (
 	initstate        = "init"        //in:ESTABLISHED, rcv:-, send: INIT
 	readystate       = "ready"       //in:ESTABLISHED,TRANSACTION, rcv:COMPLETED
 	transactionstate = "transaction" //in:READY, rcv: xact from consensus, send: TRANSACTION
-	busyinitstate    = "busyinit"    //in:INIT, rcv: PUT_STATE, DEL_STATE, INVOKE_CHAINCODE
-	busyxactstate    = "busyxact"    //in:TRANSACION, rcv: PUT_STATE, DEL_STATE, INVOKE_CHAINCODE
+	busyinitstate    = "busyinit"    //in:INIT, rcv: PUT_STATE, DEL_STATE, DEL_STATE_RANGE, INVOKE_CHAINCODE
+	busyxactstate    = "busyxact"    //in:TRANSACION, rcv: PUT_STATE, DEL_STATE, DEL_STATE_RANGE, INVOKE_CHAINCODE
 	endstate         = "end"         //in:INIT,ESTABLISHED, rcv: error, terminate container
 
 )
@@ -69,6 +72,30 @@ type transactionContext struct {
 
 	// tracks open iterators used for range queries
 	rangeQueryIteratorMap map[string]statemgmt.RangeScanIterator
+
+	// creation times, used to detect leaked contexts and iterators
+	created                   time.Time
+	rangeQueryIteratorCreated map[string]time.Time
+
+	// last RANGE_QUERY_STATE_NEXT of each iterator, idle iterators are closed
+	// after chaincode.iteratorTTL
+	rangeQueryIteratorUsed map[string]time.Time
+
+	// number of chaincodes invoked or queried so far, see deriveNestedUUID
+	nestedInvocations uint64
+
+	// the deadline of the execution, zero if not known
+	deadline time.Time
+
+	// span of the execution, finished by finishExecuteSpan
+	span *tracing.Span
+
+	// set for executions started with ExecuteAsync, called with the response
+	// instead of it being picked up from responseNotifier
+	onResponse func(*pb.ChaincodeMessage)
+
+	// the result is kept for replay protection, set for transactions
+	replayable bool
 }
 
 type nextStateInfo struct {
@@ -76,6 +103,13 @@ type nextStateInfo struct {
 	sendToCC bool
 }
 
+// receivedMessage is the result of a Recv on the chat stream, handed from the
+// receiving goroutine to the one handling the messages
+type receivedMessage struct {
+	msg *pb.ChaincodeMessage
+	err error
+}
+
 // Handler responsbile for managment of Peer's side of chaincode stream
 type Handler struct {
 	sync.RWMutex
@@ -93,13 +127,48 @@ type Handler struct {
 	// added prior to execute and remove when done execute
 	txCtxs map[string]*transactionContext
 
-	uuidMap map[string]bool
+	// Map of uuid to the time its state request started
+	uuidMap map[string]time.Time
+	// signaled when an entry of uuidMap is removed, for queued duplicates
+	uuidReleased *sync.Cond
 
 	// Track which UUIDs are queries; Although the shim maintains this, it cannot be trusted.
 	isTransaction map[string]bool
 
 	// used to do Send after making sure the state transition is complete
 	nextState chan *nextStateInfo
+
+	// set while the chaincode is being upgraded, no new transactions are accepted
+	quiesced bool
+
+	// ledger namespace for state of this chaincode; empty unless this chaincode
+	// is an upgrade of another one, in which case it inherits its state
+	stateNamespace string
+
+	// bounds concurrency and rate of state requests from the chaincode
+	stateLimiter *stateRequestLimiter
+
+	// chaincode protocol version negotiated at REGISTER
+	protocolVersion int32
+
+	// flow control of the messages sent to the chaincode, nil if the window
+	// negotiated at REGISTER is 0
+	window *sendWindow
+
+	// messages waiting for the writer, the only goroutine sending on ChatStream
+	outbound       chan *outboundMessage
+	writerStop     chan struct{}
+	writerStopOnce sync.Once
+	writerDone     chan struct{}
+
+	// time spent in each FSM state
+	residency *stateResidency
+
+	// notifiers and UUIDs released by sweepStale
+	staleSwept uint64
+
+	// responses kept in the spill store until the shim fetched them, by handle
+	spilled map[string]*spilledResult
 }
 
 func shortuuid(uuid string) string {
@@ -109,13 +178,73 @@ func shortuuid(uuid string) string {
 	return uuid[0:8]
 }
 
+// serialSend sends msg, spilled or split in chunks if it is a large response,
+// and waits until the writer sent it. Messages held back by flow control are sent once
+// the chaincode returns credits, serialSend does not wait for them.
 func (handler *Handler) serialSend(msg *pb.ChaincodeMessage) error {
+	msgs := []*pb.ChaincodeMessage{msg}
+	if msg.Type == pb.ChaincodeMessage_RESPONSE {
+		var err error
+		if msg, err = handler.spillResponse(msg); err != nil {
+			handler.log(msg).Error("%s", err)
+			return err
+		}
+		if msgs, err = splitResponse(msg, handler.responseChunkSize()); err != nil {
+			handler.log(msg).Error("%s", err)
+			return err
+		}
+	}
+	done := make(chan error, len(msgs))
+	handed := 0
+	handOver := func(m *pb.ChaincodeMessage) error {
+		handed++
+		handler.sendAsync(m, func(err error) { done <- err })
+		return nil
+	}
+
+	// Chunks of a response are handed to the writer back to back, the lock is
+	// held until the last one is
 	handler.Lock()
-	defer handler.Unlock()
-	if err := handler.ChatStream.Send(msg); err != nil {
-		chaincodeLog.Error(fmt.Sprintf("Error sending %s: %s", msg.Type.String(), err))
+	handler.traceMessage(pb.TraceEntry_SENT, msg)
+	var err error
+	if handler.window != nil {
+		err = handler.window.send(msgs, handOver)
+	} else {
+		for _, m := range msgs {
+			handOver(m)
+		}
+	}
+	handler.Unlock()
+	if err != nil {
+		handler.log(msg).Error("Error sending %s: %s", msg.Type.String(), err)
 		return fmt.Errorf("Error sending %s: %s", msg.Type.String(), err)
 	}
+
+	for ; handed > 0; handed-- {
+		select {
+		case err = <-done:
+			if err != nil {
+				return err
+			}
+		case <-handler.writerDone:
+			// the writer reported on all it got before exiting
+			select {
+			case err = <-done:
+				if err != nil {
+					return err
+				}
+			default:
+				return errWriterStopped(msg)
+			}
+		}
+	}
+	return nil
+}
+
+// send hands msg to the writer without waiting for it to be sent, errors are
+// logged by the writer
+func (handler *Handler) send(msg *pb.ChaincodeMessage) error {
+	handler.sendAsync(msg, nil)
 	return nil
 }
 
@@ -125,11 +254,17 @@ func (handler *Handler) createTxContext(uuid string, tx *pb.Transaction) (*trans
 	}
 	handler.Lock()
 	defer handler.Unlock()
+	//checked under the lock waitForIdle counts contexts under, so that no
+	//context is created once an upgrade found the handler idle
+	if handler.quiesced {
+		return nil, fmt.Errorf("cannot create notifier for Uuid:%s, chaincode is being upgraded", uuid)
+	}
 	if handler.txCtxs[uuid] != nil {
 		return nil, fmt.Errorf("Uuid:%s exists", uuid)
 	}
 	txctx := &transactionContext{transactionSecContext: tx, responseNotifier: make(chan *pb.ChaincodeMessage, 1),
-		rangeQueryIteratorMap: make(map[string]statemgmt.RangeScanIterator)}
+		rangeQueryIteratorMap: make(map[string]statemgmt.RangeScanIterator), created: time.Now(),
+		rangeQueryIteratorCreated: make(map[string]time.Time), rangeQueryIteratorUsed: make(map[string]time.Time)}
 	handler.txCtxs[uuid] = txctx
 	return txctx, nil
 }
@@ -148,23 +283,133 @@ func (handler *Handler) deleteTxContext(uuid string) {
 	}
 }
 
+// abortTransaction tells the chaincode to give up on a transaction or query
+// that did not complete by its deadline and releases the UUID. The FSM is left
+// alone, the COMPLETED or ERROR the chaincode eventually sends for the UUID
+// moves it back to ready.
+func (handler *Handler) abortTransaction(msg *pb.ChaincodeMessage, reason error) {
+	handler.log(msg).Warning("Aborting %s: %s", msg.Type, reason)
+	handler.Lock()
+	if tctx := handler.txCtxs[msg.Uuid]; tctx != nil {
+		for _, v := range tctx.rangeQueryIteratorMap {
+			v.Close()
+		}
+		delete(handler.txCtxs, msg.Uuid)
+	}
+	handler.releaseUUIDEntry(msg.Uuid)
+	delete(handler.isTransaction, msg.Uuid)
+	handler.Unlock()
+
+	abortMsg := handler.errorMessage(msg, pb.ChaincodeError_TIMEOUT, reason, nil)
+	abortMsg.Deadline = msg.Deadline
+	if err := handler.serialSend(abortMsg); err != nil {
+		handler.log(msg).Error("Error sending abort: %s", err)
+	}
+}
+
+// chainID returns the chain of the chaincode support this handler belongs to
+func (handler *Handler) chainID() string {
+	if handler.chaincodeSupport == nil {
+		return ledger.DefaultChainID
+	}
+	return string(handler.chaincodeSupport.name)
+}
+
+// getLedger returns the ledger of the chain of the handler. The chain named by
+// msg is only the chaincode's claim, a message for another chain is refused.
+func (handler *Handler) getLedger(msg *pb.ChaincodeMessage) (*ledger.Ledger, error) {
+	chainID := ledger.DefaultChainID
+	if handler.chaincodeSupport != nil {
+		chainID = string(handler.chaincodeSupport.name)
+	}
+	if msg.ChainID != "" && msg.ChainID != chainID {
+		return nil, fmt.Errorf("Message %s for chain %s refused by the handler of chain %s", msg.Uuid, msg.ChainID, chainID)
+	}
+	if handler.chaincodeSupport == nil {
+		return ledger.NewChainLedgers().GetLedger(chainID)
+	}
+	return handler.chaincodeSupport.getLedger(chainID)
+}
+
+// getStateStore returns the store of the state of the chain msg belongs to
+func (handler *Handler) getStateStore(msg *pb.ChaincodeMessage) (StateStore, error) {
+	if handler.chaincodeSupport != nil && handler.chaincodeSupport.stateStore != nil {
+		return handler.chaincodeSupport.stateStore, nil
+	}
+	ledgerObj, err := handler.getLedger(msg)
+	if err != nil {
+		return nil, err
+	}
+	return ledgerObj, nil
+}
+
+func (handler *Handler) getStateNamespace() string {
+	if handler.stateNamespace != "" {
+		return handler.stateNamespace
+	}
+	return handler.ChaincodeID.Name
+}
+
+func (handler *Handler) setQuiesced(quiesced bool) {
+	handler.Lock()
+	defer handler.Unlock()
+	handler.quiesced = quiesced
+}
+
+func (handler *Handler) isQuiesced() bool {
+	handler.RLock()
+	defer handler.RUnlock()
+	return handler.quiesced
+}
+
+// waitForIdle waits until all in-flight transactions and queries are complete
+func (handler *Handler) waitForIdle(timeout time.Duration) error {
+	expire := time.After(timeout)
+	for {
+		handler.RLock()
+		pending := len(handler.txCtxs)
+		handler.RUnlock()
+		if pending == 0 {
+			return nil
+		}
+		select {
+		case <-expire:
+			return fmt.Errorf("Timeout expired waiting for %d pending transactions on %s", pending, handler.ChaincodeID.Name)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// putRangeQueryIterator tracks rangeScanIterator and returns it wrapped, to be
+// used in its place so the reaper cannot close it while it is advanced
 func (handler *Handler) putRangeQueryIterator(txContext *transactionContext, uuid string,
-	rangeScanIterator statemgmt.RangeScanIterator) {
+	rangeScanIterator statemgmt.RangeScanIterator) statemgmt.RangeScanIterator {
 	handler.Lock()
 	defer handler.Unlock()
-	txContext.rangeQueryIteratorMap[uuid] = rangeScanIterator
+	now := time.Now()
+	iter := &lockedIterator{iter: rangeScanIterator}
+	txContext.rangeQueryIteratorMap[uuid] = iter
+	txContext.rangeQueryIteratorCreated[uuid] = now
+	txContext.rangeQueryIteratorUsed[uuid] = now
+	return iter
 }
 
 func (handler *Handler) getRangeQueryIterator(txContext *transactionContext, uuid string) statemgmt.RangeScanIterator {
 	handler.Lock()
 	defer handler.Unlock()
-	return txContext.rangeQueryIteratorMap[uuid]
+	iter := txContext.rangeQueryIteratorMap[uuid]
+	if iter != nil {
+		txContext.rangeQueryIteratorUsed[uuid] = time.Now()
+	}
+	return iter
 }
 
 func (handler *Handler) deleteRangeQueryIterator(txContext *transactionContext, uuid string) {
 	handler.Lock()
 	defer handler.Unlock()
 	delete(txContext.rangeQueryIteratorMap, uuid)
+	delete(txContext.rangeQueryIteratorCreated, uuid)
+	delete(txContext.rangeQueryIteratorUsed, uuid)
 }
 
 func (handler *Handler) encryptOrDecrypt(encrypt bool, uuid string, payload []byte) ([]byte, error) {
@@ -201,16 +446,16 @@ func (handler *Handler) encryptOrDecrypt(encrypt bool, uuid string, payload []by
 	if enc == nil {
 		return nil, fmt.Errorf("secure context returns nil encryptor for tx %s", uuid)
 	}
-	if chaincodeLogger.IsEnabledFor(logging.DEBUG) {
-		chaincodeLogger.Debug("[%s]Payload before encrypt/decrypt: %v", shortuuid(uuid), payload)
+	if handler.logger().IsEnabledFor(logging.DEBUG) {
+		handler.logTx(uuid).Debug("Payload before encrypt/decrypt: %v", payload)
 	}
 	if encrypt {
 		payload, err = enc.Encrypt(payload)
 	} else {
 		payload, err = enc.Decrypt(payload)
 	}
-	if chaincodeLogger.IsEnabledFor(logging.DEBUG) {
-		chaincodeLogger.Debug("[%s]Payload after encrypt/decrypt: %v", shortuuid(uuid), payload)
+	if handler.logger().IsEnabledFor(logging.DEBUG) {
+		handler.logTx(uuid).Debug("Payload after encrypt/decrypt: %v", payload)
 	}
 
 	return payload, err
@@ -233,9 +478,30 @@ func (handler *Handler) getSecurityBinding(tx *pb.Transaction) ([]byte, error) {
 	return secHelper.GetTransactionBinding(tx)
 }
 
-func (handler *Handler) deregister() error {
+// endOnPanic ends the stream after a panic handling msg: the chaincode is
+// sent an ERROR and the handler is left in the end state, where it accepts
+// no further message
+func (handler *Handler) endOnPanic(msg *pb.ChaincodeMessage, value interface{}) error {
+	err := fmt.Errorf("Panic handling chaincode support stream: %v", value)
+	handler.log(msg).Error("%s\n%s", err, debug.Stack())
+	if msg == nil {
+		msg = &pb.ChaincodeMessage{}
+	}
+	// The FSM may have panicked mid transition, it is replaced rather than
+	// asked for an event
+	handler.FSM = fsm.NewFSM(endstate, fsm.Events{}, fsm.Callbacks{})
+	// The handler lock may still be held, the ERROR bypasses serialSend
+	sent := make(chan error, 1)
+	handler.sendAsync(handler.errorMessage(msg, pb.ChaincodeError_UNKNOWN, err, nil), func(err error) { sent <- err })
+	if sendErr := <-sent; sendErr != nil {
+		handler.log(msg).Debug("Error sending %s after panic: %s", pb.ChaincodeMessage_ERROR, sendErr)
+	}
+	return err
+}
+
+func (handler *Handler) deregister(reason error) error {
 	if handler.registered {
-		handler.chaincodeSupport.deregisterHandler(handler)
+		handler.chaincodeSupport.deregisterHandler(handler, reason)
 	}
 	return nil
 }
@@ -244,12 +510,64 @@ func (handler *Handler) triggerNextState(msg *pb.ChaincodeMessage, send bool) {
 	handler.nextState <- &nextStateInfo{msg, send}
 }
 
-func (handler *Handler) processStream() error {
-	defer handler.deregister()
-	msgAvail := make(chan *pb.ChaincodeMessage)
+// notifyAllOnClose sends an ERROR message to every transaction still waiting on
+// a response so that callers do not hang once the stream has gone away
+func (handler *Handler) notifyAllOnClose(reason error) {
+	handler.Lock()
+	defer handler.Unlock()
+	for uuid, tctx := range handler.txCtxs {
+		payload := []byte(fmt.Sprintf("stream closed: %s", reason))
+		errMsg := &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: uuid}
+		if tctx.respond(errMsg) {
+			handler.logTx(uuid).Debug("notified of stream close")
+		} else {
+			//a response is already waiting to be picked up
+			handler.logTx(uuid).Debug("response pending, not notifying stream close")
+		}
+	}
+}
+
+func (handler *Handler) processStream() (err error) {
+	// the stream must not be used once processStream returns
+	defer handler.stopWriter()
+	defer func() {
+		if err == nil {
+			err = fmt.Errorf("chaincode support stream ended")
+		}
+		//a chaincode killed for lack of memory is reported as such rather than as EOF
+		if handler.chaincodeSupport != nil && handler.ChaincodeID != nil {
+			if exitErr := handler.chaincodeSupport.containerExitError(handler.ChaincodeID.Name); exitErr != nil {
+				handler.log(nil).Error("%s", exitErr)
+				err = exitErr
+			}
+		}
+		handler.notifyAllOnClose(err)
+		handler.releaseAllSpilled()
+		handler.deregister(err)
+	}()
+	msgAvail := make(chan *receivedMessage)
 	var nsInfo *nextStateInfo
 	var in *pb.ChaincodeMessage
-	var err error
+	// A panic handling a message ends the stream rather than the peer
+	defer func() {
+		if r := recover(); r != nil {
+			err = handler.endOnPanic(in, r)
+		}
+	}()
+
+	//an idle chaincode is probed with KEEPALIVE
+	var keepalive <-chan time.Time
+	ticker, probe := handler.keepaliveTicker()
+	if ticker != nil {
+		defer ticker.Stop()
+		keepalive = ticker.C
+	}
+	//notifiers and UUIDs of transactions past their deadline are released
+	var sweep <-chan time.Time
+	if sweeper := handler.sweepTicker(); sweeper != nil {
+		defer sweeper.Stop()
+		sweep = sweeper.C
+	}
 
 	//recv is used to spin Recv routine after previous received msg
 	//has been processed
@@ -261,28 +579,32 @@ func (handler *Handler) processStream() error {
 		if recv {
 			recv = false
 			go func() {
-				var in2 *pb.ChaincodeMessage
-				in2, err = handler.ChatStream.Recv()
-				msgAvail <- in2
+				in2, err2 := handler.ChatStream.Recv()
+				msgAvail <- &receivedMessage{in2, err2}
 			}()
 		}
 		select {
-		case in = <-msgAvail:
+		case received := <-msgAvail:
+			in, err = received.msg, received.err
 			// Defer the deregistering of the this handler.
 			if err == io.EOF {
-				chaincodeLogger.Debug("Received EOF, ending chaincode support stream, %s", err)
+				handler.log(nil).Debug("Received EOF, ending chaincode support stream, %s", err)
 				return err
 			} else if err != nil {
-				chaincodeLog.Error(fmt.Sprintf("Error handling chaincode support stream: %s", err))
+				handler.log(nil).Error("Error handling chaincode support stream: %s", err)
 				return err
 			} else if in == nil {
 				err = fmt.Errorf("Received nil message, ending chaincode support stream")
-				chaincodeLogger.Debug("Received nil message, ending chaincode support stream")
+				handler.log(nil).Debug("Received nil message, ending chaincode support stream")
 				return err
 			}
-			chaincodeLogger.Debug("[%s]Received message %s from shim", shortuuid(in.Uuid), in.Type.String())
+			handler.log(in).Debug("Received message %s from shim", in.Type.String())
+			handler.traceMessage(pb.TraceEntry_RECEIVED, in)
+			if probe != nil {
+				probe.received(time.Now())
+			}
 			if in.Type.String() == pb.ChaincodeMessage_ERROR.String() {
-				chaincodeLogger.Debug("Got error: %s", string(in.Payload))
+				handler.log(nil).Debug("Got error: %s", string(in.Payload))
 			}
 
 			// we can spin off another Recv again
@@ -291,20 +613,29 @@ func (handler *Handler) processStream() error {
 			in = nsInfo.msg
 			if in == nil {
 				err = fmt.Errorf("Next state nil message, ending chaincode support stream")
-				chaincodeLogger.Debug("Next state nil message, ending chaincode support stream")
+				handler.log(nil).Debug("Next state nil message, ending chaincode support stream")
 				return err
 			}
-			chaincodeLogger.Debug("[%s]Move state message %s", shortuuid(in.Uuid), in.Type.String())
+			handler.log(in).Debug("Move state message %s", in.Type.String())
+		case <-keepalive:
+			if err = handler.probe(probe); err != nil {
+				return err
+			}
+			continue
+		case now := <-sweep:
+			handler.sweepStale(now, handler.chaincodeSupport.sweepGrace)
+			handler.sweepSpilled(now, handler.chaincodeSupport.sweepGrace)
+			continue
 		}
 		err = handler.HandleMessage(in)
 		if err != nil {
-			chaincodeLog.Error(fmt.Sprintf("[%s]Error handling message, ending stream: %s", shortuuid(in.Uuid), err))
+			handler.log(in).Error("Error handling message, ending stream: %s", err)
 			return fmt.Errorf("Error handling message, ending stream: %s", err)
 		}
 		if nsInfo != nil && nsInfo.sendToCC {
-			chaincodeLogger.Debug("[%s]sending state message %s", shortuuid(in.Uuid), in.Type.String())
+			handler.log(in).Debug("sending state message %s", in.Type.String())
 			if err = handler.serialSend(in); err != nil {
-				chaincodeLogger.Debug("[%s]serial sending received error %s", shortuuid(in.Uuid), err)
+				handler.log(in).Debug("serial sending received error %s", err)
 				return fmt.Errorf("[%s]serial sending received error %s", shortuuid(in.Uuid), err)
 			}
 		}
@@ -315,6 +646,9 @@ func (handler *Handler) processStream() error {
 func HandleChaincodeStream(chaincodeSupport *ChaincodeSupport, stream pb.ChaincodeSupport_RegisterServer) error {
 	deadline, ok := stream.Context().Deadline()
 	chaincodeLogger.Debug("Current context deadline = %s, ok = %v", deadline, ok)
+	if chaincodeSupport.maxTenants > 0 {
+		return serveChaincodeStream(chaincodeSupport, stream)
+	}
 	handler := newChaincodeSupportHandler(chaincodeSupport, stream)
 	return handler.processStream()
 }
@@ -324,94 +658,114 @@ func newChaincodeSupportHandler(chaincodeSupport *ChaincodeSupport, peerChatStre
 		ChatStream: peerChatStream,
 	}
 	v.chaincodeSupport = chaincodeSupport
+	outboundBufferSize := 0
+	if chaincodeSupport != nil {
+		v.stateLimiter = newStateRequestLimiter(chaincodeSupport.stateLimits())
+		outboundBufferSize = chaincodeSupport.outboundBufferSize
+	}
+	v.startWriter(outboundBufferSize)
 	//we want this to block
 	v.nextState = make(chan *nextStateInfo)
+	v.residency = newStateResidency(createdstate)
 
-	v.FSM = fsm.NewFSM(
-		createdstate,
-		fsm.Events{
-			//Send REGISTERED, then, if deploy { trigger INIT(via INIT) } else { trigger READY(via COMPLETED) }
-			{Name: pb.ChaincodeMessage_REGISTER.String(), Src: []string{createdstate}, Dst: establishedstate},
-			{Name: pb.ChaincodeMessage_INIT.String(), Src: []string{establishedstate}, Dst: initstate},
-			{Name: pb.ChaincodeMessage_READY.String(), Src: []string{establishedstate}, Dst: readystate},
-			{Name: pb.ChaincodeMessage_TRANSACTION.String(), Src: []string{readystate}, Dst: transactionstate},
-			{Name: pb.ChaincodeMessage_PUT_STATE.String(), Src: []string{transactionstate}, Dst: busyxactstate},
-			{Name: pb.ChaincodeMessage_DEL_STATE.String(), Src: []string{transactionstate}, Dst: busyxactstate},
-			{Name: pb.ChaincodeMessage_INVOKE_CHAINCODE.String(), Src: []string{transactionstate}, Dst: busyxactstate},
-			{Name: pb.ChaincodeMessage_PUT_STATE.String(), Src: []string{initstate}, Dst: busyinitstate},
-			{Name: pb.ChaincodeMessage_DEL_STATE.String(), Src: []string{initstate}, Dst: busyinitstate},
-			{Name: pb.ChaincodeMessage_INVOKE_CHAINCODE.String(), Src: []string{initstate}, Dst: busyinitstate},
-			{Name: pb.ChaincodeMessage_COMPLETED.String(), Src: []string{initstate, readystate, transactionstate}, Dst: readystate},
-			{Name: pb.ChaincodeMessage_GET_STATE.String(), Src: []string{readystate}, Dst: readystate},
-			{Name: pb.ChaincodeMessage_GET_STATE.String(), Src: []string{initstate}, Dst: initstate},
-			{Name: pb.ChaincodeMessage_GET_STATE.String(), Src: []string{busyinitstate}, Dst: busyinitstate},
-			{Name: pb.ChaincodeMessage_GET_STATE.String(), Src: []string{transactionstate}, Dst: transactionstate},
-			{Name: pb.ChaincodeMessage_GET_STATE.String(), Src: []string{busyxactstate}, Dst: busyxactstate},
-			{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE.String(), Src: []string{readystate}, Dst: readystate},
-			{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE.String(), Src: []string{initstate}, Dst: initstate},
-			{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE.String(), Src: []string{busyinitstate}, Dst: busyinitstate},
-			{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE.String(), Src: []string{transactionstate}, Dst: transactionstate},
-			{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE.String(), Src: []string{busyxactstate}, Dst: busyxactstate},
-			{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE_NEXT.String(), Src: []string{readystate}, Dst: readystate},
-			{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE_NEXT.String(), Src: []string{initstate}, Dst: initstate},
-			{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE_NEXT.String(), Src: []string{busyinitstate}, Dst: busyinitstate},
-			{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE_NEXT.String(), Src: []string{transactionstate}, Dst: transactionstate},
-			{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE_NEXT.String(), Src: []string{busyxactstate}, Dst: busyxactstate},
-			{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE_CLOSE.String(), Src: []string{readystate}, Dst: readystate},
-			{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE_CLOSE.String(), Src: []string{initstate}, Dst: initstate},
-			{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE_CLOSE.String(), Src: []string{busyinitstate}, Dst: busyinitstate},
-			{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE_CLOSE.String(), Src: []string{transactionstate}, Dst: transactionstate},
-			{Name: pb.ChaincodeMessage_RANGE_QUERY_STATE_CLOSE.String(), Src: []string{busyxactstate}, Dst: busyxactstate},
-			{Name: pb.ChaincodeMessage_ERROR.String(), Src: []string{initstate}, Dst: endstate},
-			{Name: pb.ChaincodeMessage_ERROR.String(), Src: []string{transactionstate}, Dst: readystate},
-			{Name: pb.ChaincodeMessage_ERROR.String(), Src: []string{busyinitstate}, Dst: initstate},
-			{Name: pb.ChaincodeMessage_ERROR.String(), Src: []string{busyxactstate}, Dst: transactionstate},
-			{Name: pb.ChaincodeMessage_RESPONSE.String(), Src: []string{busyinitstate}, Dst: initstate},
-			{Name: pb.ChaincodeMessage_RESPONSE.String(), Src: []string{busyxactstate}, Dst: transactionstate},
-		},
-		fsm.Callbacks{
-			"before_" + pb.ChaincodeMessage_REGISTER.String():               func(e *fsm.Event) { v.beforeRegisterEvent(e, v.FSM.Current()) },
-			"before_" + pb.ChaincodeMessage_COMPLETED.String():              func(e *fsm.Event) { v.beforeCompletedEvent(e, v.FSM.Current()) },
-			"before_" + pb.ChaincodeMessage_INIT.String():                   func(e *fsm.Event) { v.beforeInitState(e, v.FSM.Current()) },
-			"after_" + pb.ChaincodeMessage_GET_STATE.String():               func(e *fsm.Event) { v.afterGetState(e, v.FSM.Current()) },
-			"after_" + pb.ChaincodeMessage_RANGE_QUERY_STATE.String():       func(e *fsm.Event) { v.afterRangeQueryState(e, v.FSM.Current()) },
-			"after_" + pb.ChaincodeMessage_RANGE_QUERY_STATE_NEXT.String():  func(e *fsm.Event) { v.afterRangeQueryStateNext(e, v.FSM.Current()) },
-			"after_" + pb.ChaincodeMessage_RANGE_QUERY_STATE_CLOSE.String(): func(e *fsm.Event) { v.afterRangeQueryStateClose(e, v.FSM.Current()) },
-			"after_" + pb.ChaincodeMessage_PUT_STATE.String():               func(e *fsm.Event) { v.afterPutState(e, v.FSM.Current()) },
-			"after_" + pb.ChaincodeMessage_DEL_STATE.String():               func(e *fsm.Event) { v.afterDelState(e, v.FSM.Current()) },
-			"after_" + pb.ChaincodeMessage_INVOKE_CHAINCODE.String():        func(e *fsm.Event) { v.afterInvokeChaincode(e, v.FSM.Current()) },
-			"enter_" + establishedstate:                                     func(e *fsm.Event) { v.enterEstablishedState(e, v.FSM.Current()) },
-			"enter_" + initstate:                                            func(e *fsm.Event) { v.enterInitState(e, v.FSM.Current()) },
-			"enter_" + readystate:                                           func(e *fsm.Event) { v.enterReadyState(e, v.FSM.Current()) },
-			"enter_" + busyinitstate:                                        func(e *fsm.Event) { v.enterBusyState(e, v.FSM.Current()) },
-			"enter_" + busyxactstate:                                        func(e *fsm.Event) { v.enterBusyState(e, v.FSM.Current()) },
-			"enter_" + endstate:                                             func(e *fsm.Event) { v.enterEndState(e, v.FSM.Current()) },
-		},
-	)
+	table := legacyFSMTable
+	if chaincodeSupport != nil && chaincodeSupport.fsmTable != nil {
+		table = chaincodeSupport.fsmTable
+	}
+	v.FSM = fsm.NewFSM(createdstate, table, handlerCallbacks(v))
 
 	return v
 }
 
+// handlerCallbacks returns the FSM callbacks of handler v, for whichever
+// table of fsmTables drives it
+func handlerCallbacks(v *Handler) fsm.Callbacks {
+	return fsm.Callbacks{
+		"before_" + pb.ChaincodeMessage_REGISTER.String():               func(e *fsm.Event) { v.beforeRegisterEvent(e, v.FSM.Current()) },
+		"before_" + pb.ChaincodeMessage_COMPLETED.String():              func(e *fsm.Event) { v.beforeCompletedEvent(e, v.FSM.Current()) },
+		"before_" + pb.ChaincodeMessage_ERROR.String():                  func(e *fsm.Event) { v.beforeErrorEvent(e, v.FSM.Current()) },
+		"before_" + pb.ChaincodeMessage_INIT.String():                   func(e *fsm.Event) { v.beforeInitState(e, v.FSM.Current()) },
+		"before_" + pb.ChaincodeMessage_UPGRADE.String():                func(e *fsm.Event) { v.beforeInitState(e, v.FSM.Current()) },
+		"after_" + pb.ChaincodeMessage_GET_STATE.String():               func(e *fsm.Event) { v.afterGetState(e, v.FSM.Current()) },
+		"after_" + pb.ChaincodeMessage_RANGE_QUERY_STATE.String():       func(e *fsm.Event) { v.afterRangeQueryState(e, v.FSM.Current()) },
+		"after_" + pb.ChaincodeMessage_RANGE_QUERY_STATE_NEXT.String():  func(e *fsm.Event) { v.afterRangeQueryStateNext(e, v.FSM.Current()) },
+		"after_" + pb.ChaincodeMessage_RANGE_QUERY_STATE_CLOSE.String(): func(e *fsm.Event) { v.afterRangeQueryStateClose(e, v.FSM.Current()) },
+		"after_" + pb.ChaincodeMessage_GET_HISTORY_FOR_KEY.String():     func(e *fsm.Event) { v.afterGetHistoryForKey(e, v.FSM.Current()) },
+		"after_" + pb.ChaincodeMessage_PUT_STATE.String():               func(e *fsm.Event) { v.afterPutState(e, v.FSM.Current()) },
+		"after_" + pb.ChaincodeMessage_DEL_STATE.String():               func(e *fsm.Event) { v.afterDelState(e, v.FSM.Current()) },
+		"after_" + pb.ChaincodeMessage_INVOKE_CHAINCODE.String():        func(e *fsm.Event) { v.afterInvokeChaincode(e, v.FSM.Current()) },
+		"enter_" + establishedstate:                                     func(e *fsm.Event) { v.enterEstablishedState(e, v.FSM.Current()) },
+		"enter_" + initstate:                                            func(e *fsm.Event) { v.enterInitState(e, v.FSM.Current()) },
+		"enter_" + readystate:                                           func(e *fsm.Event) { v.enterReadyState(e, v.FSM.Current()) },
+		"enter_" + busyinitstate:                                        func(e *fsm.Event) { v.enterBusyState(e, v.FSM.Current()) },
+		"enter_" + busyxactstate:                                        func(e *fsm.Event) { v.enterBusyState(e, v.FSM.Current()) },
+		"enter_" + endstate:                                             func(e *fsm.Event) { v.enterEndState(e, v.FSM.Current()) },
+		"enter_state":                                                   func(e *fsm.Event) { v.traceTransition(e); v.residency.enter(e.Dst) },
+	}
+}
+
 func (handler *Handler) createUUIDEntry(uuid string) bool {
 	if handler.uuidMap == nil {
 		return false
 	}
 	handler.Lock()
 	defer handler.Unlock()
-	if handler.uuidMap[uuid] {
+	if _, ok := handler.uuidMap[uuid]; ok {
 		return false
 	}
-	handler.uuidMap[uuid] = true
-	return handler.uuidMap[uuid]
+	handler.uuidMap[uuid] = time.Now()
+	return true
+}
+
+// acquireUUIDEntry creates the entry of the request msg. If another request of
+// the same uuid is pending, msg waits for it under the queue policy of
+// chaincode.duplicateRequest. Otherwise acquireUUIDEntry returns false with
+// the ERROR coded DUPLICATE_REQUEST to answer msg with, nil for shims
+// predating ChaincodeProtocolV9 whose duplicate requests are dropped.
+func (handler *Handler) acquireUUIDEntry(msg *pb.ChaincodeMessage) (bool, *pb.ChaincodeMessage) {
+	policy := DuplicateRequestReject
+	if handler.chaincodeSupport != nil {
+		policy = handler.chaincodeSupport.duplicateRequests
+	}
+	handler.Lock()
+	defer handler.Unlock()
+	for handler.uuidMap != nil {
+		if _, pending := handler.uuidMap[msg.Uuid]; !pending {
+			handler.uuidMap[msg.Uuid] = time.Now()
+			return true, nil
+		}
+		if policy != DuplicateRequestQueue {
+			break
+		}
+		handler.log(msg).Debug("Another request pending for this Uuid, %s queued behind it", msg.Type)
+		if handler.uuidReleased == nil {
+			handler.uuidReleased = sync.NewCond(handler)
+		}
+		handler.uuidReleased.Wait()
+	}
+	handler.log(msg).Debug("Another request pending for this Uuid. Cannot process %s.", msg.Type)
+	if handler.protocolVersion < pb.ChaincodeProtocolV9 {
+		return false, nil
+	}
+	err := fmt.Errorf("Another request of uuid %s is pending", msg.Uuid)
+	return false, handler.errorMessage(msg, pb.ChaincodeError_DUPLICATE_REQUEST, err, map[string]string{"type": msg.Type.String()})
 }
 
 func (handler *Handler) deleteUUIDEntry(uuid string) {
 	handler.Lock()
 	defer handler.Unlock()
 	if handler.uuidMap != nil {
-		delete(handler.uuidMap, uuid)
+		handler.releaseUUIDEntry(uuid)
 	} else {
-		chaincodeLogger.Warning("UUID %s not found!", uuid)
+		handler.logTx(uuid).Warning("UUID %s not found!", uuid)
+	}
+}
+
+//call this under lock
+func (handler *Handler) releaseUUIDEntry(uuid string) {
+	delete(handler.uuidMap, uuid)
+	if handler.uuidReleased != nil {
+		handler.uuidReleased.Broadcast()
 	}
 }
 
@@ -435,6 +789,19 @@ func (handler *Handler) getIsTransaction(uuid string) bool {
 	return handler.isTransaction[uuid]
 }
 
+// otherTransactionsInFlight reports whether transactions other than uuid are
+// being executed, queries aside
+func (handler *Handler) otherTransactionsInFlight(uuid string) bool {
+	handler.Lock()
+	defer handler.Unlock()
+	for other, isTrans := range handler.isTransaction {
+		if isTrans && other != uuid {
+			return true
+		}
+	}
+	return false
+}
+
 func (handler *Handler) deleteIsTransaction(uuid string) {
 	handler.Lock()
 	defer handler.Unlock()
@@ -446,43 +813,111 @@ func (handler *Handler) deleteIsTransaction(uuid string) {
 func (handler *Handler) notifyDuringStartup(val bool) {
 	//if USER_RUNS_CC readyNotify will be nil
 	if handler.readyNotify != nil {
-		chaincodeLogger.Debug("Notifying during startup")
+		handler.log(nil).Debug("Notifying during startup")
 		handler.readyNotify <- val
 	} else {
-		chaincodeLogger.Debug("nothing to notify (dev mode ?)")
+		handler.log(nil).Debug("nothing to notify (dev mode ?)")
 	}
 }
 
+// negotiateProtocolVersion selects the highest protocol version supported by
+// both the peer and a shim supporting versions min to max. Shims that predate
+// negotiation send neither and speak version 1.
+func negotiateProtocolVersion(min int32, max int32) (int32, error) {
+	if min == 0 && max == 0 {
+		return pb.ChaincodeProtocolV1, nil
+	}
+	version := max
+	if version > pb.MaxChaincodeProtocol {
+		version = pb.MaxChaincodeProtocol
+	}
+	if version < min || version < pb.MinChaincodeProtocol {
+		return 0, fmt.Errorf("no common protocol version, chaincode supports %d to %d, peer supports %d to %d", min, max, pb.MinChaincodeProtocol, pb.MaxChaincodeProtocol)
+	}
+	return version, nil
+}
+
 // beforeRegisterEvent is invoked when chaincode tries to register.
 func (handler *Handler) beforeRegisterEvent(e *fsm.Event, state string) {
-	chaincodeLogger.Debug("Received %s in state %s", e.Event, state)
+	handler.log(nil).Debug("Received %s in state %s", e.Event, state)
 	msg, ok := e.Args[0].(*pb.ChaincodeMessage)
 	if !ok {
 		e.Cancel(fmt.Errorf("Received unexpected message type"))
 		return
 	}
-	chaincodeID := &pb.ChaincodeID{}
-	err := proto.Unmarshal(msg.Payload, chaincodeID)
+	registration := &pb.ChaincodeRegistration{}
+	err := proto.Unmarshal(msg.Payload, registration)
 	if err != nil {
-		e.Cancel(fmt.Errorf("Error in received %s, could NOT unmarshal registration info: %s", pb.ChaincodeMessage_REGISTER, err))
+		err = fmt.Errorf("Error in received %s, could NOT unmarshal registration info: %s", pb.ChaincodeMessage_REGISTER, err)
+		handler.sendRegisterFailed(pb.ChaincodeRegisterFailure_INVALID_REGISTRATION, err)
+		e.Cancel(err)
 		return
 	}
+	chaincodeID := &pb.ChaincodeID{Path: registration.Path, Name: registration.Name}
 
 	// Now register with the chaincodeSupport
 	handler.ChaincodeID = chaincodeID
 	err = handler.chaincodeSupport.registerHandler(handler)
 	if err != nil {
+		reason := pb.ChaincodeRegisterFailure_UNKNOWN
+		if _, ok := err.(*DuplicateChaincodeHandlerError); ok {
+			reason = pb.ChaincodeRegisterFailure_DUPLICATE
+		}
+		handler.sendRegisterFailed(reason, err)
+		e.Cancel(err)
+		handler.notifyDuringStartup(false)
+		return
+	}
+
+	// Stopping the chaincode after a failed startup deregisters the handler
+	if err = handler.chaincodeSupport.verifyFingerprint(chaincodeID.Name, registration.Fingerprint); err != nil {
+		err = fmt.Errorf("Error in received %s: %s", pb.ChaincodeMessage_REGISTER, err)
+		handler.sendRegisterFailed(pb.ChaincodeRegisterFailure_FINGERPRINT, err)
+		e.Cancel(err)
+		handler.notifyDuringStartup(false)
+		return
+	}
+	handler.protocolVersion, err = negotiateProtocolVersion(registration.MinProtocolVersion, registration.MaxProtocolVersion)
+	if err != nil {
+		err = fmt.Errorf("Error in received %s for chaincodeID = %s: %s", pb.ChaincodeMessage_REGISTER, chaincodeID, err)
+		handler.sendRegisterFailed(pb.ChaincodeRegisterFailure_PROTOCOL_VERSION, err)
+		e.Cancel(err)
+		handler.notifyDuringStartup(false)
+		return
+	}
+
+	if err = handler.registerEventSchemas(chaincodeID.Name, registration.EventSchemas); err != nil {
+		err = fmt.Errorf("Error in received %s for chaincodeID = %s: %s", pb.ChaincodeMessage_REGISTER, chaincodeID, err)
+		handler.sendRegisterFailed(pb.ChaincodeRegisterFailure_INVALID_REGISTRATION, err)
 		e.Cancel(err)
 		handler.notifyDuringStartup(false)
 		return
 	}
 
-	chaincodeLogger.Debug("Got %s for chaincodeID = %s, sending back %s", e.Event, chaincodeID, pb.ChaincodeMessage_REGISTERED)
-	if err := handler.serialSend(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_REGISTERED}); err != nil {
+	window := handler.negotiateWindow(registration.Window)
+	handler.log(msg).Debug("Got %s for chaincodeID = %s with protocol version %d and window %d, sending back %s", e.Event, chaincodeID, handler.protocolVersion, window, pb.ChaincodeMessage_REGISTERED)
+	registered := &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_REGISTERED}
+	if registration.MaxProtocolVersion > 0 {
+		// Only shims that negotiate expect the selected version
+		if registered.Payload, err = proto.Marshal(&pb.ChaincodeProtocol{Version: handler.protocolVersion, Window: window}); err != nil {
+			e.Cancel(fmt.Errorf("Error marshalling %s payload: %s", pb.ChaincodeMessage_REGISTERED, err))
+			handler.notifyDuringStartup(false)
+			return
+		}
+	}
+	if err := handler.serialSend(registered); err != nil {
 		e.Cancel(fmt.Errorf("Error sending %s: %s", pb.ChaincodeMessage_REGISTERED, err))
 		handler.notifyDuringStartup(false)
 		return
 	}
+	// The shim counts the messages it consumes after REGISTERED
+	if window > 0 {
+		handler.Lock()
+		handler.window = newSendWindow(window, handler.chaincodeSupport.flowControlMaxQueued)
+		handler.Unlock()
+	}
+	// Nobody launches an additional instance, the peer readies it itself
+	handler.chaincodeSupport.readyReplica(handler)
 }
 
 func (handler *Handler) notify(msg *pb.ChaincodeMessage) {
@@ -490,10 +925,13 @@ func (handler *Handler) notify(msg *pb.ChaincodeMessage) {
 	defer handler.Unlock()
 	tctx := handler.txCtxs[msg.Uuid]
 	if tctx == nil {
-		chaincodeLogger.Debug("notifier Uuid:%s does not exist", msg.Uuid)
+		handler.log(msg).Debug("notifier Uuid:%s does not exist", msg.Uuid)
 	} else {
-		chaincodeLogger.Debug("notifying Uuid:%s", msg.Uuid)
-		tctx.responseNotifier <- msg
+		handler.log(msg).Debug("notifying Uuid:%s", msg.Uuid)
+		if tctx.replayable {
+			handler.holdResult(msg)
+		}
+		tctx.respond(msg)
 
 		// clean up rangeQueryIteratorMap
 		for _, v := range tctx.rangeQueryIteratorMap {
@@ -509,14 +947,45 @@ func (handler *Handler) beforeCompletedEvent(e *fsm.Event, state string) {
 		e.Cancel(fmt.Errorf("Received unexpected message type"))
 		return
 	}
+	if handler.completeConcurrently(e, msg) {
+		return
+	}
 	// Notify on channel once into READY state
-	chaincodeLogger.Debug("[%s]beforeCompleted - not in ready state will notify when in readystate", shortuuid(msg.Uuid))
+	handler.log(msg).Debug("beforeCompleted - not in ready state will notify when in readystate")
 	return
 }
 
+// beforeErrorEvent is invoked when the chaincode reports the failure of a
+// transaction, or when a state change of a transaction failed
+func (handler *Handler) beforeErrorEvent(e *fsm.Event, state string) {
+	msg, ok := e.Args[0].(*pb.ChaincodeMessage)
+	if !ok {
+		e.Cancel(fmt.Errorf("Received unexpected message type"))
+		return
+	}
+	// In busyxact the ERROR answers the state change, the transaction goes on
+	if state == transactionstate {
+		handler.completeConcurrently(e, msg)
+	}
+}
+
+// completeConcurrently notifies the end of the transaction of msg without
+// leaving the current state when other transactions are still in flight,
+// which only happens under the concurrent FSM. It reports whether it did.
+func (handler *Handler) completeConcurrently(e *fsm.Event, msg *pb.ChaincodeMessage) bool {
+	if handler.chaincodeSupport == nil || !handler.chaincodeSupport.concurrentTransactions || !handler.otherTransactionsInFlight(msg.Uuid) {
+		return false
+	}
+	handler.log(msg).Debug("Other transactions in flight, staying in state %s", e.Src)
+	handler.deleteIsTransaction(msg.Uuid)
+	handler.notify(msg)
+	e.Cancel()
+	return true
+}
+
 // beforeInitState is invoked before an init message is sent to the chaincode.
 func (handler *Handler) beforeInitState(e *fsm.Event, state string) {
-	chaincodeLogger.Debug("Before state %s.. notifying waiter that we are up", state)
+	handler.log(nil).Debug("Before state %s.. notifying waiter that we are up", state)
 	handler.notifyDuringStartup(true)
 }
 
@@ -527,66 +996,118 @@ func (handler *Handler) afterGetState(e *fsm.Event, state string) {
 		e.Cancel(fmt.Errorf("Received unexpected message type"))
 		return
 	}
-	chaincodeLogger.Debug("[%s]Received %s, invoking get state from ledger", shortuuid(msg.Uuid), pb.ChaincodeMessage_GET_STATE)
+	handler.log(msg).Debug("Received %s, invoking get state from ledger", pb.ChaincodeMessage_GET_STATE)
 
 	// Query ledger for state
 	handler.handleGetState(msg)
 }
 
+// sendRetryLater tells the chaincode that its state request was rejected because
+// this handler is saturated and the request should be tried again later
+func (handler *Handler) sendRetryLater(msg *pb.ChaincodeMessage) {
+	handler.log(msg).Debug("Too many state requests, sending %s for %s", RetryLater, msg.Type)
+	err := fmt.Errorf("%s: too many pending state requests", RetryLater)
+	handler.serialSend(handler.errorMessage(msg, pb.ChaincodeError_RETRY_LATER, err, nil))
+}
+
+// errorMessage returns the ERROR answering msg with err. Shims speaking
+// ChaincodeProtocolV6 get a ChaincodeError of the given code and details,
+// older ones the text of err.
+func (handler *Handler) errorMessage(msg *pb.ChaincodeMessage, code pb.ChaincodeError_Code, err error, details map[string]string) *pb.ChaincodeMessage {
+	payload := pb.ChaincodeErrorPayload(handler.protocolVersion, pb.NewChaincodeError(code, err, details))
+	return &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+}
+
+// admitStateRequest applies the state request limits, returning false if the
+// request was rejected. Shims speaking protocol version 2 or later resend
+// requests rejected with RETRY_LATER, older shims cannot so their requests
+// wait until they are admitted instead.
+func (handler *Handler) admitStateRequest(msg *pb.ChaincodeMessage) bool {
+	if handler.protocolVersion >= pb.ChaincodeProtocolV2 {
+		if !handler.stateLimiter.acquire() {
+			handler.sendRetryLater(msg)
+			return false
+		}
+		return true
+	}
+	handler.stateLimiter.wait()
+	return true
+}
+
 // Handles query to ledger to get state
 func (handler *Handler) handleGetState(msg *pb.ChaincodeMessage) {
 	// The defer followed by triggering a go routine dance is needed to ensure that the previous state transition
 	// is completed before the next one is triggered. The previous state transition is deemed complete only when
 	// the afterGetState function is exited. Interesting bug fix!!
+	if !handler.admitStateRequest(msg) {
+		return
+	}
 	go func() {
+		defer handler.stateLimiter.release()
+
 		// Check if this is the unique state request from this chaincode uuid
-		uniqueReq := handler.createUUIDEntry(msg.Uuid)
-		if !uniqueReq {
-			// Drop this request
-			chaincodeLogger.Debug("Another state request pending for this Uuid. Cannot process.")
+		if ok, reply := handler.acquireUUIDEntry(msg); !ok {
+			if reply != nil {
+				handler.serialSend(reply)
+			}
 			return
 		}
 
 		var serialSendMsg *pb.ChaincodeMessage
+		span := handler.startStateSpan(msg)
 
 		defer func() {
+			span.Finish(handler.replyError(serialSendMsg))
 			handler.deleteUUIDEntry(msg.Uuid)
-			chaincodeLogger.Debug("[%s]handleGetState serial send %s", shortuuid(serialSendMsg.Uuid), serialSendMsg.Type)
+			handler.log(serialSendMsg).Debug("handleGetState serial send %s", serialSendMsg.Type)
 			handler.serialSend(serialSendMsg)
 		}()
 
 		key := string(msg.Payload)
-		ledgerObj, ledgerErr := ledger.GetLedger()
+		if serialSendMsg = handler.stateLimitMessage(msg, key, nil); serialSendMsg != nil {
+			return
+		}
+		store, ledgerErr := handler.getStateStore(msg)
 		if ledgerErr != nil {
 			// Send error msg back to chaincode. GetState will not trigger event
-			payload := []byte(ledgerErr.Error())
-			chaincodeLogger.Error(fmt.Sprintf("Failed to get chaincode state(%s). Sending %s", ledgerErr, pb.ChaincodeMessage_ERROR))
+			handler.log(msg).Error("Failed to get chaincode state(%s). Sending %s", ledgerErr, pb.ChaincodeMessage_ERROR)
 			// Remove uuid from current set
-			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			serialSendMsg = handler.errorMessage(msg, pb.ChaincodeError_LEDGER, ledgerErr, nil)
 			return
 		}
 
 		// Invoke ledger to get state
-		chaincodeID := handler.ChaincodeID.Name
+		chaincodeID := handler.getStateNamespace()
 
 		readCommittedState := !handler.getIsTransaction(msg.Uuid)
-		res, err := ledgerObj.GetState(chaincodeID, key, readCommittedState)
+		var rw *readWriteSet
+		if !readCommittedState {
+			rw = handler.readWriteSet(msg.Uuid)
+		}
+		state := handler.chaincodeSupport.stateAccess(store)
+		var res []byte
+		var err error
+		if rw != nil {
+			// Transactions see their own writes and record the version of what they read
+			res, err = rw.getState(state, chaincodeID, key, readCommittedState)
+		} else {
+			res, err = state.GetState(chaincodeID, key, readCommittedState)
+		}
+		handler.traceStateOp(msg.Uuid, msg.Type, key, err)
 		if err != nil {
 			// Send error msg back to chaincode. GetState will not trigger event
-			payload := []byte(err.Error())
-			chaincodeLogger.Error(fmt.Sprintf("[%s]Failed to get chaincode state(%s). Sending %s", shortuuid(msg.Uuid), err, pb.ChaincodeMessage_ERROR))
-			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			handler.log(msg).Error("Failed to get chaincode state(%s). Sending %s", err, pb.ChaincodeMessage_ERROR)
+			serialSendMsg = handler.errorMessage(msg, pb.ChaincodeError_LEDGER, err, map[string]string{"key": key})
 		} else {
 			// Decrypt the data if the confidential is enabled
 			if res, err = handler.decrypt(msg.Uuid, res); err == nil {
 				// Send response msg back to chaincode. GetState will not trigger event
-				chaincodeLogger.Debug("[%s]Got state. Sending %s", shortuuid(msg.Uuid), pb.ChaincodeMessage_RESPONSE)
+				handler.log(msg).Debug("Got state. Sending %s", pb.ChaincodeMessage_RESPONSE)
 				serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: res, Uuid: msg.Uuid}
 			} else {
 				// Send err msg back to chaincode.
-				chaincodeLogger.Error(fmt.Sprintf("[%s]Got error (%s) while decrypting. Sending %s", shortuuid(msg.Uuid), err, pb.ChaincodeMessage_ERROR))
-				errBytes := []byte(err.Error())
-				serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: errBytes, Uuid: msg.Uuid}
+				handler.log(msg).Error("Got error (%s) while decrypting. Sending %s", err, pb.ChaincodeMessage_ERROR)
+				serialSendMsg = handler.errorMessage(msg, pb.ChaincodeError_LEDGER, err, map[string]string{"key": key})
 			}
 
 		}
@@ -596,6 +1117,50 @@ func (handler *Handler) handleGetState(msg *pb.ChaincodeMessage) {
 
 const maxRangeQueryStateLimit = 100
 
+// rangeQueryResponse answers msg with the next key-values of iter, at most
+// maxRangeQueryStateLimit of them, hasNext telling whether iter is at one.
+// The iterator is closed and forgotten once exhausted or on error
+func (handler *Handler) rangeQueryResponse(msg *pb.ChaincodeMessage, txContext *transactionContext, iterID string,
+	iter statemgmt.RangeScanIterator, hasNext bool) *pb.ChaincodeMessage {
+	var keysAndValues []*pb.RangeQueryStateKeyValue
+	var i = uint32(0)
+	for ; hasNext && i < maxRangeQueryStateLimit; i++ {
+		key, value := iter.GetKeyValue()
+		// Decrypt the data if the confidential is enabled
+		decryptedValue, err := handler.decryptIteratorValue(msg.Uuid, iter, value)
+		if err != nil {
+			iter.Close()
+			handler.deleteRangeQueryIterator(txContext, iterID)
+
+			handler.log(msg).Debug("Failed decrypt value. Sending %s", pb.ChaincodeMessage_ERROR)
+			return handler.errorMessage(msg, pb.ChaincodeError_LEDGER, err, map[string]string{"key": key})
+		}
+		keyAndValue := pb.RangeQueryStateKeyValue{Key: key, Value: decryptedValue}
+		keysAndValues = append(keysAndValues, &keyAndValue)
+
+		hasNext = iter.Next()
+	}
+
+	if !hasNext {
+		iter.Close()
+		handler.deleteRangeQueryIterator(txContext, iterID)
+	}
+
+	payload := &pb.RangeQueryStateResponse{KeysAndValues: keysAndValues, HasMore: hasNext, ID: iterID}
+	payloadBytes, err := proto.Marshal(payload)
+	if err != nil {
+		iter.Close()
+		handler.deleteRangeQueryIterator(txContext, iterID)
+
+		// Send error msg back to chaincode. GetState will not trigger event
+		handler.log(msg).Debug("Failed marshall resopnse. Sending %s", pb.ChaincodeMessage_ERROR)
+		return handler.errorMessage(msg, pb.ChaincodeError_UNKNOWN, err, nil)
+	}
+
+	handler.log(msg).Debug("Got keys and values. Sending %s", pb.ChaincodeMessage_RESPONSE)
+	return &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: payloadBytes, Uuid: msg.Uuid}
+}
+
 // afterRangeQueryState handles a RANGE_QUERY_STATE request from the chaincode.
 func (handler *Handler) afterRangeQueryState(e *fsm.Event, state string) {
 	msg, ok := e.Args[0].(*pb.ChaincodeMessage)
@@ -603,11 +1168,11 @@ func (handler *Handler) afterRangeQueryState(e *fsm.Event, state string) {
 		e.Cancel(fmt.Errorf("Received unexpected message type"))
 		return
 	}
-	chaincodeLogger.Debug("Received %s, invoking get state from ledger", pb.ChaincodeMessage_RANGE_QUERY_STATE)
+	handler.log(msg).Debug("Received %s, invoking get state from ledger", pb.ChaincodeMessage_RANGE_QUERY_STATE)
 
 	// Query ledger for state
 	handler.handleRangeQueryState(msg)
-	chaincodeLogger.Debug("Exiting GET_STATE")
+	handler.log(msg).Debug("Exiting GET_STATE")
 }
 
 // Handles query to ledger to rage query state
@@ -615,12 +1180,17 @@ func (handler *Handler) handleRangeQueryState(msg *pb.ChaincodeMessage) {
 	// The defer followed by triggering a go routine dance is needed to ensure that the previous state transition
 	// is completed before the next one is triggered. The previous state transition is deemed complete only when
 	// the afterRangeQueryState function is exited. Interesting bug fix!!
+	if !handler.admitStateRequest(msg) {
+		return
+	}
 	go func() {
+		defer handler.stateLimiter.release()
+
 		// Check if this is the unique state request from this chaincode uuid
-		uniqueReq := handler.createUUIDEntry(msg.Uuid)
-		if !uniqueReq {
-			// Drop this request
-			chaincodeLogger.Debug("Another state request pending for this Uuid. Cannot process.")
+		if ok, reply := handler.acquireUUIDEntry(msg); !ok {
+			if reply != nil {
+				handler.serialSend(reply)
+			}
 			return
 		}
 
@@ -628,91 +1198,46 @@ func (handler *Handler) handleRangeQueryState(msg *pb.ChaincodeMessage) {
 
 		defer func() {
 			handler.deleteUUIDEntry(msg.Uuid)
-			chaincodeLogger.Debug("[%s]handleRangeQueryState serial send %s", shortuuid(serialSendMsg.Uuid), serialSendMsg.Type)
+			handler.log(serialSendMsg).Debug("handleRangeQueryState serial send %s", serialSendMsg.Type)
 			handler.serialSend(serialSendMsg)
 		}()
 
 		rangeQueryState := &pb.RangeQueryState{}
 		unmarshalErr := proto.Unmarshal(msg.Payload, rangeQueryState)
 		if unmarshalErr != nil {
-			payload := []byte(unmarshalErr.Error())
-			chaincodeLogger.Debug("Failed to unmarshall range query request. Sending %s", pb.ChaincodeMessage_ERROR)
-			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			handler.log(msg).Debug("Failed to unmarshall range query request. Sending %s", pb.ChaincodeMessage_ERROR)
+			serialSendMsg = handler.errorMessage(msg, pb.ChaincodeError_MALFORMED, unmarshalErr, nil)
 			return
 		}
 
 		hasNext := true
 
-		ledger, ledgerErr := ledger.GetLedger()
+		store, ledgerErr := handler.getStateStore(msg)
 		if ledgerErr != nil {
 			// Send error msg back to chaincode. GetState will not trigger event
-			payload := []byte(ledgerErr.Error())
-			chaincodeLogger.Debug("Failed to get ledger. Sending %s", pb.ChaincodeMessage_ERROR)
-			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			handler.log(msg).Debug("Failed to get ledger. Sending %s", pb.ChaincodeMessage_ERROR)
+			serialSendMsg = handler.errorMessage(msg, pb.ChaincodeError_LEDGER, ledgerErr, nil)
 			return
 		}
 
-		chaincodeID := handler.ChaincodeID.Name
+		chaincodeID := handler.getStateNamespace()
 
 		readCommittedState := !handler.getIsTransaction(msg.Uuid)
-		rangeIter, err := ledger.GetStateRangeScanIterator(chaincodeID, rangeQueryState.StartKey, rangeQueryState.EndKey, readCommittedState)
+		rangeIter, err := store.GetStateRangeScanIterator(chaincodeID, rangeQueryState.StartKey, rangeQueryState.EndKey, readCommittedState)
+		handler.traceStateOp(msg.Uuid, msg.Type, rangeQueryState.StartKey+"-"+rangeQueryState.EndKey, err)
 		if err != nil {
 			// Send error msg back to chaincode. GetState will not trigger event
-			payload := []byte(err.Error())
-			chaincodeLogger.Debug("Failed to get ledger scan iterator. Sending %s", pb.ChaincodeMessage_ERROR)
-			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			handler.log(msg).Debug("Failed to get ledger scan iterator. Sending %s", pb.ChaincodeMessage_ERROR)
+			serialSendMsg = handler.errorMessage(msg, pb.ChaincodeError_LEDGER, err, map[string]string{"startKey": rangeQueryState.StartKey, "endKey": rangeQueryState.EndKey})
 			return
 		}
 
 		iterID := util.GenerateUUID()
 		txContext := handler.getTxContext(msg.Uuid)
-		handler.putRangeQueryIterator(txContext, iterID, rangeIter)
+		rangeIter = handler.putRangeQueryIterator(txContext, iterID, rangeIter)
 
 		hasNext = rangeIter.Next()
-
-		var keysAndValues []*pb.RangeQueryStateKeyValue
-		var i = uint32(0)
-		for ; hasNext && i < maxRangeQueryStateLimit; i++ {
-			key, value := rangeIter.GetKeyValue()
-			// Decrypt the data if the confidential is enabled
-			decryptedValue, err := handler.decrypt(msg.Uuid, value)
-			if err != nil {
-				payload := []byte(unmarshalErr.Error())
-				chaincodeLogger.Debug("Failed decrypt value. Sending %s", pb.ChaincodeMessage_ERROR)
-				serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
-
-				rangeIter.Close()
-				handler.deleteRangeQueryIterator(txContext, iterID)
-
-				return
-			}
-			keyAndValue := pb.RangeQueryStateKeyValue{Key: key, Value: decryptedValue}
-			keysAndValues = append(keysAndValues, &keyAndValue)
-
-			hasNext = rangeIter.Next()
-		}
-
-		if !hasNext {
-			rangeIter.Close()
-			handler.deleteRangeQueryIterator(txContext, iterID)
-		}
-
-		payload := &pb.RangeQueryStateResponse{KeysAndValues: keysAndValues, HasMore: hasNext, ID: iterID}
-		payloadBytes, err := proto.Marshal(payload)
-		if err != nil {
-			rangeIter.Close()
-			handler.deleteRangeQueryIterator(txContext, iterID)
-
-			// Send error msg back to chaincode. GetState will not trigger event
-			payload := []byte(err.Error())
-			chaincodeLogger.Debug("Failed marshall resopnse. Sending %s", pb.ChaincodeMessage_ERROR)
-			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
-			return
-		}
-
-		chaincodeLogger.Debug("Got keys and values. Sending %s", pb.ChaincodeMessage_RESPONSE)
-		serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: payloadBytes, Uuid: msg.Uuid}
-
+		serialSendMsg = handler.rangeQueryResponse(msg, txContext, iterID, rangeIter, hasNext)
 	}()
 }
 
@@ -723,11 +1248,11 @@ func (handler *Handler) afterRangeQueryStateNext(e *fsm.Event, state string) {
 		e.Cancel(fmt.Errorf("Received unexpected message type"))
 		return
 	}
-	chaincodeLogger.Debug("Received %s, invoking get state from ledger", pb.ChaincodeMessage_RANGE_QUERY_STATE)
+	handler.log(msg).Debug("Received %s, invoking get state from ledger", pb.ChaincodeMessage_RANGE_QUERY_STATE)
 
 	// Query ledger for state
 	handler.handleRangeQueryStateNext(msg)
-	chaincodeLogger.Debug("Exiting RANGE_QUERY_STATE_NEXT")
+	handler.log(msg).Debug("Exiting RANGE_QUERY_STATE_NEXT")
 }
 
 // Handles query to ledger to rage query state nexy
@@ -735,12 +1260,17 @@ func (handler *Handler) handleRangeQueryStateNext(msg *pb.ChaincodeMessage) {
 	// The defer followed by triggering a go routine dance is needed to ensure that the previous state transition
 	// is completed before the next one is triggered. The previous state transition is deemed complete only when
 	// the afterRangeQueryState function is exited. Interesting bug fix!!
+	if !handler.admitStateRequest(msg) {
+		return
+	}
 	go func() {
+		defer handler.stateLimiter.release()
+
 		// Check if this is the unique state request from this chaincode uuid
-		uniqueReq := handler.createUUIDEntry(msg.Uuid)
-		if !uniqueReq {
-			// Drop this request
-			chaincodeLogger.Debug("Another state request pending for this Uuid. Cannot process.")
+		if ok, reply := handler.acquireUUIDEntry(msg); !ok {
+			if reply != nil {
+				handler.serialSend(reply)
+			}
 			return
 		}
 
@@ -748,16 +1278,15 @@ func (handler *Handler) handleRangeQueryStateNext(msg *pb.ChaincodeMessage) {
 
 		defer func() {
 			handler.deleteUUIDEntry(msg.Uuid)
-			chaincodeLogger.Debug("[%s]handleRangeQueryState serial send %s", shortuuid(serialSendMsg.Uuid), serialSendMsg.Type)
+			handler.log(serialSendMsg).Debug("handleRangeQueryState serial send %s", serialSendMsg.Type)
 			handler.serialSend(serialSendMsg)
 		}()
 
 		rangeQueryStateNext := &pb.RangeQueryStateNext{}
 		unmarshalErr := proto.Unmarshal(msg.Payload, rangeQueryStateNext)
 		if unmarshalErr != nil {
-			payload := []byte(unmarshalErr.Error())
-			chaincodeLogger.Debug("Failed to unmarshall state range next query request. Sending %s", pb.ChaincodeMessage_ERROR)
-			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			handler.log(msg).Debug("Failed to unmarshall state range next query request. Sending %s", pb.ChaincodeMessage_ERROR)
+			serialSendMsg = handler.errorMessage(msg, pb.ChaincodeError_MALFORMED, unmarshalErr, nil)
 			return
 		}
 
@@ -765,56 +1294,12 @@ func (handler *Handler) handleRangeQueryStateNext(msg *pb.ChaincodeMessage) {
 		rangeIter := handler.getRangeQueryIterator(txContext, rangeQueryStateNext.ID)
 
 		if rangeIter == nil {
-			payload := []byte("Range query iterator not found")
-			chaincodeLogger.Debug("Range query iterator not found. Sending %s", pb.ChaincodeMessage_ERROR)
-			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			handler.log(msg).Debug("Range query iterator not found. Sending %s", pb.ChaincodeMessage_ERROR)
+			serialSendMsg = handler.errorMessage(msg, pb.ChaincodeError_LEDGER, fmt.Errorf("Range query iterator not found"), map[string]string{"iterator": rangeQueryStateNext.ID})
 			return
 		}
 
-		var keysAndValues []*pb.RangeQueryStateKeyValue
-		var i = uint32(0)
-		hasNext := true
-		for ; hasNext && i < maxRangeQueryStateLimit; i++ {
-			key, value := rangeIter.GetKeyValue()
-			// Decrypt the data if the confidential is enabled
-			decryptedValue, err := handler.decrypt(msg.Uuid, value)
-			if err != nil {
-				payload := []byte(unmarshalErr.Error())
-				chaincodeLogger.Debug("Failed decrypt value. Sending %s", pb.ChaincodeMessage_ERROR)
-				serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
-
-				rangeIter.Close()
-				handler.deleteRangeQueryIterator(txContext, rangeQueryStateNext.ID)
-
-				return
-			}
-			keyAndValue := pb.RangeQueryStateKeyValue{Key: key, Value: decryptedValue}
-			keysAndValues = append(keysAndValues, &keyAndValue)
-
-			hasNext = rangeIter.Next()
-		}
-
-		if !hasNext {
-			rangeIter.Close()
-			handler.deleteRangeQueryIterator(txContext, rangeQueryStateNext.ID)
-		}
-
-		payload := &pb.RangeQueryStateResponse{KeysAndValues: keysAndValues, HasMore: hasNext, ID: rangeQueryStateNext.ID}
-		payloadBytes, err := proto.Marshal(payload)
-		if err != nil {
-			rangeIter.Close()
-			handler.deleteRangeQueryIterator(txContext, rangeQueryStateNext.ID)
-
-			// Send error msg back to chaincode. GetState will not trigger event
-			payload := []byte(err.Error())
-			chaincodeLogger.Debug("Failed marshall resopnse. Sending %s", pb.ChaincodeMessage_ERROR)
-			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
-			return
-		}
-
-		chaincodeLogger.Debug("Got keys and values. Sending %s", pb.ChaincodeMessage_RESPONSE)
-		serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: payloadBytes, Uuid: msg.Uuid}
-
+		serialSendMsg = handler.rangeQueryResponse(msg, txContext, rangeQueryStateNext.ID, rangeIter, true)
 	}()
 }
 
@@ -825,11 +1310,11 @@ func (handler *Handler) afterRangeQueryStateClose(e *fsm.Event, state string) {
 		e.Cancel(fmt.Errorf("Received unexpected message type"))
 		return
 	}
-	chaincodeLogger.Debug("Received %s, invoking get state from ledger", pb.ChaincodeMessage_RANGE_QUERY_STATE)
+	handler.log(msg).Debug("Received %s, invoking get state from ledger", pb.ChaincodeMessage_RANGE_QUERY_STATE)
 
 	// Query ledger for state
 	handler.handleRangeQueryStateClose(msg)
-	chaincodeLogger.Debug("Exiting RANGE_QUERY_STATE_CLOSE")
+	handler.log(msg).Debug("Exiting RANGE_QUERY_STATE_CLOSE")
 }
 
 // Handles the closing of a state iterator
@@ -839,10 +1324,10 @@ func (handler *Handler) handleRangeQueryStateClose(msg *pb.ChaincodeMessage) {
 	// the afterRangeQueryState function is exited. Interesting bug fix!!
 	go func() {
 		// Check if this is the unique state request from this chaincode uuid
-		uniqueReq := handler.createUUIDEntry(msg.Uuid)
-		if !uniqueReq {
-			// Drop this request
-			chaincodeLogger.Debug("Another state request pending for this Uuid. Cannot process.")
+		if ok, reply := handler.acquireUUIDEntry(msg); !ok {
+			if reply != nil {
+				handler.serialSend(reply)
+			}
 			return
 		}
 
@@ -850,16 +1335,15 @@ func (handler *Handler) handleRangeQueryStateClose(msg *pb.ChaincodeMessage) {
 
 		defer func() {
 			handler.deleteUUIDEntry(msg.Uuid)
-			chaincodeLogger.Debug("[%s]handleRangeQueryState serial send %s", shortuuid(serialSendMsg.Uuid), serialSendMsg.Type)
+			handler.log(serialSendMsg).Debug("handleRangeQueryState serial send %s", serialSendMsg.Type)
 			handler.serialSend(serialSendMsg)
 		}()
 
 		rangeQueryStateClose := &pb.RangeQueryStateClose{}
 		unmarshalErr := proto.Unmarshal(msg.Payload, rangeQueryStateClose)
 		if unmarshalErr != nil {
-			payload := []byte(unmarshalErr.Error())
-			chaincodeLogger.Debug("Failed to unmarshall state range query close request. Sending %s", pb.ChaincodeMessage_ERROR)
-			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			handler.log(msg).Debug("Failed to unmarshall state range query close request. Sending %s", pb.ChaincodeMessage_ERROR)
+			serialSendMsg = handler.errorMessage(msg, pb.ChaincodeError_MALFORMED, unmarshalErr, nil)
 			return
 		}
 
@@ -875,13 +1359,12 @@ func (handler *Handler) handleRangeQueryStateClose(msg *pb.ChaincodeMessage) {
 		if err != nil {
 
 			// Send error msg back to chaincode. GetState will not trigger event
-			payload := []byte(err.Error())
-			chaincodeLogger.Debug("Failed marshall resopnse. Sending %s", pb.ChaincodeMessage_ERROR)
-			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			handler.log(msg).Debug("Failed marshall resopnse. Sending %s", pb.ChaincodeMessage_ERROR)
+			serialSendMsg = handler.errorMessage(msg, pb.ChaincodeError_UNKNOWN, err, nil)
 			return
 		}
 
-		chaincodeLogger.Debug("Closed. Sending %s", pb.ChaincodeMessage_RESPONSE)
+		handler.log(msg).Debug("Closed. Sending %s", pb.ChaincodeMessage_RESPONSE)
 		serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: payloadBytes, Uuid: msg.Uuid}
 
 	}()
@@ -894,7 +1377,7 @@ func (handler *Handler) afterPutState(e *fsm.Event, state string) {
 		e.Cancel(fmt.Errorf("Received unexpected message type"))
 		return
 	}
-	chaincodeLogger.Debug("Received %s in state %s, invoking put state to ledger", pb.ChaincodeMessage_PUT_STATE, state)
+	handler.log(nil).Debug("Received %s in state %s, invoking put state to ledger", pb.ChaincodeMessage_PUT_STATE, state)
 
 	// Put state into ledger handled within enterBusyState
 }
@@ -906,7 +1389,7 @@ func (handler *Handler) afterDelState(e *fsm.Event, state string) {
 		e.Cancel(fmt.Errorf("Received unexpected message type"))
 		return
 	}
-	chaincodeLogger.Debug("Received %s, invoking delete state from ledger", pb.ChaincodeMessage_DEL_STATE)
+	handler.log(nil).Debug("Received %s, invoking delete state from ledger", pb.ChaincodeMessage_DEL_STATE)
 
 	// Delete state from ledger handled within enterBusyState
 }
@@ -918,7 +1401,7 @@ func (handler *Handler) afterInvokeChaincode(e *fsm.Event, state string) {
 		e.Cancel(fmt.Errorf("Received unexpected message type"))
 		return
 	}
-	chaincodeLogger.Debug("Received %s in state %s, invoking another chaincode", pb.ChaincodeMessage_INVOKE_CHAINCODE, state)
+	handler.log(nil).Debug("Received %s in state %s, invoking another chaincode", pb.ChaincodeMessage_INVOKE_CHAINCODE, state)
 
 	// Invoke another chaincode handled within enterBusyState
 }
@@ -929,111 +1412,148 @@ func (handler *Handler) enterBusyState(e *fsm.Event, state string) {
 		msg, _ := e.Args[0].(*pb.ChaincodeMessage)
 		// First check if this UUID is a transaction; error otherwise
 		if !handler.getIsTransaction(msg.Uuid) {
-			payload := []byte(fmt.Sprintf("Cannot handle %s in query context", msg.Type.String()))
-			chaincodeLogger.Debug("[%s]Cannot handle %s in query context. Sending %s", shortuuid(msg.Uuid), msg.Type.String(), pb.ChaincodeMessage_ERROR)
-			errMsg := &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			err := fmt.Errorf("Cannot handle %s in query context", msg.Type.String())
+			handler.log(msg).Debug("Cannot handle %s in query context. Sending %s", msg.Type.String(), pb.ChaincodeMessage_ERROR)
+			errMsg := handler.errorMessage(msg, pb.ChaincodeError_ACCESS_DENIED, err, map[string]string{"type": msg.Type.String()})
 			handler.triggerNextState(errMsg, true)
 			return
 		}
 
-		chaincodeLogger.Debug("[%s]state is %s", shortuuid(msg.Uuid), state)
+		handler.log(msg).Debug("state is %s", state)
 		// Check if this is the unique request from this chaincode uuid
-		uniqueReq := handler.createUUIDEntry(msg.Uuid)
-		if !uniqueReq {
-			// Drop this request
-			chaincodeLogger.Debug("Another request pending for this Uuid. Cannot process.")
+		if ok, reply := handler.acquireUUIDEntry(msg); !ok {
+			if reply != nil {
+				handler.triggerNextState(reply, true)
+			}
 			return
 		}
 
 		var triggerNextStateMsg *pb.ChaincodeMessage
+		span := handler.startStateSpan(msg)
 
 		defer func() {
+			span.Finish(handler.replyError(triggerNextStateMsg))
 			handler.deleteUUIDEntry(msg.Uuid)
-			chaincodeLogger.Debug("[%s]enterBusyState trigger event %s", shortuuid(triggerNextStateMsg.Uuid), triggerNextStateMsg.Type)
+			handler.log(triggerNextStateMsg).Debug("enterBusyState trigger event %s", triggerNextStateMsg.Type)
 			handler.triggerNextState(triggerNextStateMsg, true)
 		}()
 
-		ledgerObj, ledgerErr := ledger.GetLedger()
+		store, ledgerErr := handler.getStateStore(msg)
 		if ledgerErr != nil {
 			// Send error msg back to chaincode and trigger event
-			payload := []byte(ledgerErr.Error())
-			chaincodeLogger.Debug("[%s]Failed to handle %s. Sending %s", shortuuid(msg.Uuid), msg.Type.String(), pb.ChaincodeMessage_ERROR)
-			triggerNextStateMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			handler.log(msg).Debug("Failed to handle %s. Sending %s", msg.Type.String(), pb.ChaincodeMessage_ERROR)
+			triggerNextStateMsg = handler.errorMessage(msg, pb.ChaincodeError_LEDGER, ledgerErr, nil)
 			return
 		}
 
-		chaincodeID := handler.ChaincodeID.Name
+		chaincodeID := handler.getStateNamespace()
 		var err error
 		var res []byte
+		errCode := pb.ChaincodeError_LEDGER
+		var errDetails map[string]string
 
 		if msg.Type.String() == pb.ChaincodeMessage_PUT_STATE.String() {
 			putStateInfo := &pb.PutStateInfo{}
 			unmarshalErr := proto.Unmarshal(msg.Payload, putStateInfo)
 			if unmarshalErr != nil {
-				payload := []byte(unmarshalErr.Error())
-				chaincodeLogger.Debug("[%s]Unable to decipher payload. Sending %s", shortuuid(msg.Uuid), pb.ChaincodeMessage_ERROR)
-				triggerNextStateMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+				handler.log(msg).Debug("Unable to decipher payload. Sending %s", pb.ChaincodeMessage_ERROR)
+				triggerNextStateMsg = handler.errorMessage(msg, pb.ChaincodeError_MALFORMED, unmarshalErr, nil)
+				return
+			}
+			if triggerNextStateMsg = handler.stateLimitMessage(msg, putStateInfo.Key, putStateInfo.Value); triggerNextStateMsg != nil {
 				return
 			}
 
 			var pVal []byte
 			// Encrypt the data if the confidential is enabled
 			if pVal, err = handler.encrypt(msg.Uuid, putStateInfo.Value); err == nil {
-				// Invoke ledger to put state
-				err = ledgerObj.SetState(chaincodeID, putStateInfo.Key, pVal)
+				if rw := handler.readWriteSet(msg.Uuid); rw != nil {
+					// Record the write, it is applied once the transaction is validated
+					rw.putState(chaincodeID, putStateInfo.Key, pVal)
+				} else {
+					// Journal, then invoke ledger to put state
+					ledgerState := handler.chaincodeSupport.stateAccess(store)
+					write := &journalWrite{ChaincodeID: chaincodeID, Key: putStateInfo.Key, Value: pVal}
+					if err = handler.chaincodeSupport.journalWrites(msg.ChainID, msg.Uuid, []*journalWrite{write}, false); err == nil {
+						err = handler.chaincodeSupport.writeState(ledgerState, &stateWriteOp{chaincodeID: chaincodeID, key: putStateInfo.Key, value: pVal})
+					}
+				}
 			}
+			handler.traceStateOp(msg.Uuid, msg.Type, putStateInfo.Key, err)
+			errDetails = map[string]string{"key": putStateInfo.Key}
 		} else if msg.Type.String() == pb.ChaincodeMessage_DEL_STATE.String() {
 			// Invoke ledger to delete state
 			key := string(msg.Payload)
-			err = ledgerObj.DeleteState(chaincodeID, key)
+			if rw := handler.readWriteSet(msg.Uuid); rw != nil {
+				rw.delState(chaincodeID, key)
+			} else {
+				ledgerState := handler.chaincodeSupport.stateAccess(store)
+				write := &journalWrite{ChaincodeID: chaincodeID, Key: key, IsDelete: true}
+				if err = handler.chaincodeSupport.journalWrites(msg.ChainID, msg.Uuid, []*journalWrite{write}, false); err == nil {
+					err = handler.chaincodeSupport.writeState(ledgerState, &stateWriteOp{chaincodeID: chaincodeID, key: key, isDelete: true})
+				}
+			}
+			handler.traceStateOp(msg.Uuid, msg.Type, key, err)
+			errDetails = map[string]string{"key": key}
+		} else if msg.Type == pb.ChaincodeMessage_DEL_STATE_RANGE {
+			res, errCode, errDetails, err = handler.delStateRange(msg, store, chaincodeID)
 		} else if msg.Type.String() == pb.ChaincodeMessage_INVOKE_CHAINCODE.String() {
 			chaincodeSpec := &pb.ChaincodeSpec{}
 			unmarshalErr := proto.Unmarshal(msg.Payload, chaincodeSpec)
 			if unmarshalErr != nil {
-				payload := []byte(unmarshalErr.Error())
-				chaincodeLogger.Debug("[%s]Unable to decipher payload. Sending %s", shortuuid(msg.Uuid), pb.ChaincodeMessage_ERROR)
-				triggerNextStateMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+				handler.log(msg).Debug("Unable to decipher payload. Sending %s", pb.ChaincodeMessage_ERROR)
+				triggerNextStateMsg = handler.errorMessage(msg, pb.ChaincodeError_MALFORMED, unmarshalErr, nil)
 				return
 			}
 
 			// Get the chaincodeID to invoke
 			newChaincodeID := chaincodeSpec.ChaincodeID.Name
+			errCode, errDetails = pb.ChaincodeError_CHAINCODE, map[string]string{"chaincode": newChaincodeID}
+
+			nestedUUID, uuidErr := handler.nextNestedUUID(msg.Uuid)
+			if uuidErr != nil {
+				handler.log(msg).Debug("Unable to derive uuid of invoked chaincode. Sending %s", pb.ChaincodeMessage_ERROR)
+				triggerNextStateMsg = handler.errorMessage(msg, pb.ChaincodeError_CHAINCODE, uuidErr, errDetails)
+				return
+			}
 
 			// Create the transaction object
 			chaincodeInvocationSpec := &pb.ChaincodeInvocationSpec{ChaincodeSpec: chaincodeSpec}
-			transaction, _ := pb.NewChaincodeExecute(chaincodeInvocationSpec, msg.Uuid, pb.Transaction_CHAINCODE_INVOKE)
+			transaction, _ := pb.NewChaincodeExecute(chaincodeInvocationSpec, nestedUUID, pb.Transaction_CHAINCODE_INVOKE)
 
 			// Launch the new chaincode if not already running
 			_, chaincodeInput, launchErr := handler.chaincodeSupport.LaunchChaincode(context.Background(), transaction)
 			if launchErr != nil {
-				payload := []byte(launchErr.Error())
-				chaincodeLogger.Debug("[%s]Failed to launch invoked chaincode. Sending %s", shortuuid(msg.Uuid), pb.ChaincodeMessage_ERROR)
-				triggerNextStateMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+				handler.log(msg).Debug("Failed to launch invoked chaincode. Sending %s", pb.ChaincodeMessage_ERROR)
+				triggerNextStateMsg = handler.errorMessage(msg, pb.ChaincodeError_CHAINCODE, launchErr, errDetails)
 				return
 			}
 
-			// TODO: Need to handle timeout correctly
-			timeout := time.Duration(30000) * time.Millisecond
+			timeout := handler.chaincodeSupport.getExecTimeout()
 
 			ccMsg, _ := createTransactionMessage(transaction.Uuid, chaincodeInput)
+			ccMsg.ParentUuid = msg.Uuid
 
 			// Execute the chaincode
 			//TODOOOOOOOOOOOOOOOOOOOOOOOOO - pass transaction to Execute
-			response, execErr := handler.chaincodeSupport.Execute(context.Background(), newChaincodeID, ccMsg, timeout, nil)
+			unshare := handler.shareReadWriteSet(msg.Uuid, nestedUUID)
+			unjournal := handler.shareJournal(msg.Uuid, nestedUUID)
+			response, execErr := handler.chaincodeSupport.Execute(tracing.ContextWithSpan(context.Background(), span), newChaincodeID, ccMsg, timeout, nil)
+			unjournal()
+			unshare()
 			err = execErr
 			res = response.Payload
 		}
 
 		if err != nil {
 			// Send error msg back to chaincode and trigger event
-			payload := []byte(err.Error())
-			chaincodeLogger.Debug("[%s]Failed to handle %s. Sending %s", shortuuid(msg.Uuid), msg.Type.String(), pb.ChaincodeMessage_ERROR)
-			triggerNextStateMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			handler.log(msg).Debug("Failed to handle %s. Sending %s", msg.Type.String(), pb.ChaincodeMessage_ERROR)
+			triggerNextStateMsg = handler.errorMessage(msg, errCode, err, errDetails)
 			return
 		}
 
 		// Send response msg back to chaincode.
-		chaincodeLogger.Debug("[%s]Completed %s. Sending %s", shortuuid(msg.Uuid), msg.Type.String(), pb.ChaincodeMessage_RESPONSE)
+		handler.log(msg).Debug("Completed %s. Sending %s", msg.Type.String(), pb.ChaincodeMessage_RESPONSE)
 		triggerNextStateMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: res, Uuid: msg.Uuid}
 	}()
 }
@@ -1048,13 +1568,13 @@ func (handler *Handler) enterInitState(e *fsm.Event, state string) {
 		e.Cancel(fmt.Errorf("Received unexpected message type"))
 		return
 	}
-	chaincodeLogger.Debug("[%s]Entered state %s", shortuuid(ccMsg.Uuid), state)
+	handler.log(ccMsg).Debug("Entered state %s", state)
 	//very first time entering init state from established, send message to chaincode
-	if ccMsg.Type == pb.ChaincodeMessage_INIT {
+	if ccMsg.Type == pb.ChaincodeMessage_INIT || ccMsg.Type == pb.ChaincodeMessage_UPGRADE {
 		// Mark isTransaction to allow put/del state and invoke other chaincodes
 		handler.markIsTransaction(ccMsg.Uuid, true)
 		if err := handler.serialSend(ccMsg); err != nil {
-			errMsg := &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: []byte(fmt.Sprintf("Error sending %s: %s", pb.ChaincodeMessage_INIT, err)), Uuid: ccMsg.Uuid}
+			errMsg := &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: []byte(fmt.Sprintf("Error sending %s: %s", ccMsg.Type, err)), Uuid: ccMsg.Uuid}
 			handler.notify(errMsg)
 		}
 	}
@@ -1068,12 +1588,15 @@ func (handler *Handler) enterReadyState(e *fsm.Event, state string) {
 		e.Cancel(fmt.Errorf("Received unexpected message type"))
 		return
 	}
-	chaincodeLogger.Debug("[%s]Entered state %s", shortuuid(msg.Uuid), state)
+	handler.log(msg).Debug("Entered state %s", state)
+	if (e.Src == establishedstate || e.Src == initstate) && handler.chaincodeSupport != nil {
+		handler.chaincodeSupport.lifecycle.Fire(handler.ChaincodeID.Name, opevents.HandlerReady, nil)
+	}
 	handler.notify(msg)
 }
 
 func (handler *Handler) enterEndState(e *fsm.Event, state string) {
-	defer handler.deregister()
+	defer handler.deregister(fmt.Errorf("Entered end state"))
 	// Now notify
 	msg, ok := e.Args[0].(*pb.ChaincodeMessage)
 	handler.deleteIsTransaction(msg.Uuid)
@@ -1081,7 +1604,7 @@ func (handler *Handler) enterEndState(e *fsm.Event, state string) {
 		e.Cancel(fmt.Errorf("Received unexpected message type"))
 		return
 	}
-	chaincodeLogger.Debug("[%s]Entered state %s", shortuuid(msg.Uuid), state)
+	handler.log(msg).Debug("Entered state %s", state)
 	handler.notify(msg)
 	e.Cancel(fmt.Errorf("Entered end state"))
 }
@@ -1089,14 +1612,14 @@ func (handler *Handler) enterEndState(e *fsm.Event, state string) {
 func (handler *Handler) cloneTx(tx *pb.Transaction) (*pb.Transaction, error) {
 	raw, err := proto.Marshal(tx)
 	if err != nil {
-		chaincodeLogger.Error(fmt.Sprintf("Failed marshalling transaction [%s].", err.Error()))
+		handler.log(nil).Error("Failed marshalling transaction [%s].", err.Error())
 		return nil, err
 	}
 
 	clone := &pb.Transaction{}
 	err = proto.Unmarshal(raw, clone)
 	if err != nil {
-		chaincodeLogger.Error(fmt.Sprintf("Failed unmarshalling transaction [%s].", err.Error()))
+		handler.log(nil).Error("Failed unmarshalling transaction [%s].", err.Error())
 		return nil, err
 	}
 
@@ -1139,19 +1662,19 @@ func (handler *Handler) initializeSecContext(tx, depTx *pb.Transaction) error {
 }
 
 func (handler *Handler) setChaincodeSecurityContext(tx *pb.Transaction, msg *pb.ChaincodeMessage) error {
-	chaincodeLogger.Debug("setting chaincode security context...")
+	handler.log(msg).Debug("setting chaincode security context...")
 	if msg.SecurityContext == nil {
 		msg.SecurityContext = &pb.ChaincodeSecurityContext{}
 	}
 	if tx != nil {
-		chaincodeLogger.Debug("setting chaincode security context. Transaction different from nil")
-		chaincodeLogger.Debug("setting chaincode security context. Metadata [% x]", tx.Metadata)
+		handler.log(msg).Debug("setting chaincode security context. Transaction different from nil")
+		handler.log(msg).Debug("setting chaincode security context. Metadata [% x]", tx.Metadata)
 
 		msg.SecurityContext.CallerCert = tx.Cert
 		msg.SecurityContext.CallerSign = tx.Signature
 		binding, err := handler.getSecurityBinding(tx)
 		if err != nil {
-			chaincodeLogger.Debug("Failed getting binding [%s]", err)
+			handler.log(msg).Debug("Failed getting binding [%s]", err)
 			return err
 		}
 		msg.SecurityContext.Binding = binding
@@ -1160,13 +1683,13 @@ func (handler *Handler) setChaincodeSecurityContext(tx *pb.Transaction, msg *pb.
 		if tx.Type == pb.Transaction_CHAINCODE_INVOKE || tx.Type == pb.Transaction_CHAINCODE_QUERY {
 			cis := &pb.ChaincodeInvocationSpec{}
 			if err := proto.Unmarshal(tx.Payload, cis); err != nil {
-				chaincodeLogger.Debug("Failed getting payload [%s]", err)
+				handler.log(msg).Debug("Failed getting payload [%s]", err)
 				return err
 			}
 
 			ctorMsgRaw, err := proto.Marshal(cis.ChaincodeSpec.GetCtorMsg())
 			if err != nil {
-				chaincodeLogger.Debug("Failed getting ctorMsgRaw [%s]", err)
+				handler.log(msg).Debug("Failed getting ctorMsgRaw [%s]", err)
 				return err
 			}
 
@@ -1177,9 +1700,9 @@ func (handler *Handler) setChaincodeSecurityContext(tx *pb.Transaction, msg *pb.
 	return nil
 }
 
-//if initArgs is set (should be for "deploy" only) move to Init
-//else move to ready
-func (handler *Handler) initOrReady(uuid string, f *string, initArgs []string, tx *pb.Transaction, depTx *pb.Transaction) (chan *pb.ChaincodeMessage, error) {
+//if initArgs is set (should be for "deploy" or "upgrade" only) move to Init
+//using initType (INIT or UPGRADE), else move to ready
+func (handler *Handler) initOrReady(uuid string, initType pb.ChaincodeMessage_Type, f *string, initArgs []string, tx *pb.Transaction, depTx *pb.Transaction) (chan *pb.ChaincodeMessage, error) {
 	var ccMsg *pb.ChaincodeMessage
 	var send bool
 
@@ -1191,7 +1714,7 @@ func (handler *Handler) initOrReady(uuid string, f *string, initArgs []string, t
 	notfy := txctx.responseNotifier
 
 	if f != nil || initArgs != nil {
-		chaincodeLogger.Debug("sending INIT")
+		handler.log(nil).Debug("sending %s", initType)
 		var f2 string
 		if f != nil {
 			f2 = *f
@@ -1200,13 +1723,13 @@ func (handler *Handler) initOrReady(uuid string, f *string, initArgs []string, t
 		var payload []byte
 		if payload, funcErr = proto.Marshal(funcArgsMsg); funcErr != nil {
 			handler.deleteTxContext(uuid)
-			return nil, fmt.Errorf("Failed to marshall %s : %s\n", ccMsg.Type.String(), funcErr)
+			return nil, fmt.Errorf("Failed to marshall %s : %s\n", initType, funcErr)
 		}
-		ccMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_INIT, Payload: payload, Uuid: uuid}
+		ccMsg = &pb.ChaincodeMessage{Type: initType, Payload: payload, Uuid: uuid, ChainID: handler.chainID()}
 		send = false
 	} else {
-		chaincodeLogger.Debug("sending READY")
-		ccMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_READY, Uuid: uuid}
+		handler.log(nil).Debug("sending READY")
+		ccMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_READY, Uuid: uuid, ChainID: handler.chainID()}
 		send = true
 	}
 
@@ -1229,16 +1752,18 @@ func (handler *Handler) initOrReady(uuid string, f *string, initArgs []string, t
 func (handler *Handler) handleQueryChaincode(msg *pb.ChaincodeMessage) {
 	go func() {
 		// Check if this is the unique request from this chaincode uuid
-		uniqueReq := handler.createUUIDEntry(msg.Uuid)
-		if !uniqueReq {
-			// Drop this request
-			chaincodeLogger.Debug("[%s]Another request pending for this Uuid. Cannot process.", shortuuid(msg.Uuid))
+		if ok, reply := handler.acquireUUIDEntry(msg); !ok {
+			if reply != nil {
+				handler.serialSend(reply)
+			}
 			return
 		}
 
 		var serialSendMsg *pb.ChaincodeMessage
+		span := handler.startStateSpan(msg)
 
 		defer func() {
+			span.Finish(handler.replyError(serialSendMsg))
 			handler.deleteUUIDEntry(msg.Uuid)
 			handler.serialSend(serialSendMsg)
 		}()
@@ -1246,99 +1771,126 @@ func (handler *Handler) handleQueryChaincode(msg *pb.ChaincodeMessage) {
 		chaincodeSpec := &pb.ChaincodeSpec{}
 		unmarshalErr := proto.Unmarshal(msg.Payload, chaincodeSpec)
 		if unmarshalErr != nil {
-			payload := []byte(unmarshalErr.Error())
-			chaincodeLogger.Debug("[%s]Unable to decipher payload. Sending %s", shortuuid(msg.Uuid), pb.ChaincodeMessage_ERROR)
-			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			handler.log(msg).Debug("Unable to decipher payload. Sending %s", pb.ChaincodeMessage_ERROR)
+			serialSendMsg = handler.errorMessage(msg, pb.ChaincodeError_MALFORMED, unmarshalErr, nil)
 			return
 		}
 
 		// Get the chaincodeID to invoke
 		newChaincodeID := chaincodeSpec.ChaincodeID.Name
+		errDetails := map[string]string{"chaincode": newChaincodeID}
+
+		nestedUUID, uuidErr := handler.nextNestedUUID(msg.Uuid)
+		if uuidErr != nil {
+			handler.log(msg).Debug("Unable to derive uuid of queried chaincode. Sending %s", pb.ChaincodeMessage_ERROR)
+			serialSendMsg = handler.errorMessage(msg, pb.ChaincodeError_CHAINCODE, uuidErr, errDetails)
+			return
+		}
 
 		// Create the transaction object
 		chaincodeInvocationSpec := &pb.ChaincodeInvocationSpec{ChaincodeSpec: chaincodeSpec}
-		transaction, _ := pb.NewChaincodeExecute(chaincodeInvocationSpec, msg.Uuid, pb.Transaction_CHAINCODE_QUERY)
+		transaction, _ := pb.NewChaincodeExecute(chaincodeInvocationSpec, nestedUUID, pb.Transaction_CHAINCODE_QUERY)
 
 		// Launch the new chaincode if not already running
 		_, chaincodeInput, launchErr := handler.chaincodeSupport.LaunchChaincode(context.Background(), transaction)
 		if launchErr != nil {
-			payload := []byte(launchErr.Error())
-			chaincodeLogger.Debug("[%s]Failed to launch invoked chaincode. Sending %s", shortuuid(msg.Uuid), pb.ChaincodeMessage_ERROR)
-			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			handler.log(msg).Debug("Failed to launch invoked chaincode. Sending %s", pb.ChaincodeMessage_ERROR)
+			serialSendMsg = handler.errorMessage(msg, pb.ChaincodeError_CHAINCODE, launchErr, errDetails)
 			return
 		}
 
-		// TODO: Need to handle timeout correctly
-		timeout := time.Duration(30000) * time.Millisecond
+		timeout := handler.chaincodeSupport.getExecTimeout()
 
 		ccMsg, _ := createQueryMessage(transaction.Uuid, chaincodeInput)
+		ccMsg.ParentUuid = msg.Uuid
 
 		// Query the chaincode
 		//TODOOOOOOOOOOOOOOOOOOOOOOOOO - pass transaction to Execute
-		response, execErr := handler.chaincodeSupport.Execute(context.Background(), newChaincodeID, ccMsg, timeout, nil)
+		unshare := handler.shareReadWriteSet(msg.Uuid, nestedUUID)
+		response, execErr := handler.chaincodeSupport.Execute(tracing.ContextWithSpan(context.Background(), span), newChaincodeID, ccMsg, timeout, nil)
+		unshare()
 
 		if execErr != nil {
 			// Send error msg back to chaincode and trigger event
-			payload := []byte(execErr.Error())
-			chaincodeLogger.Debug("[%s]Failed to handle %s. Sending %s", shortuuid(msg.Uuid), msg.Type.String(), pb.ChaincodeMessage_ERROR)
-			serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+			handler.log(msg).Debug("Failed to handle %s. Sending %s", msg.Type.String(), pb.ChaincodeMessage_ERROR)
+			serialSendMsg = handler.errorMessage(msg, pb.ChaincodeError_CHAINCODE, execErr, errDetails)
 			return
 		}
 
 		// Send response msg back to chaincode.
-		chaincodeLogger.Debug("[%s]Completed %s. Sending %s", shortuuid(msg.Uuid), msg.Type.String(), pb.ChaincodeMessage_RESPONSE)
+		handler.log(msg).Debug("Completed %s. Sending %s", msg.Type.String(), pb.ChaincodeMessage_RESPONSE)
 		serialSendMsg = &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: response.Payload, Uuid: msg.Uuid}
 	}()
 }
 
 // HandleMessage implementation of MessageHandler interface.  Peer's handling of Chaincode messages.
-func (handler *Handler) HandleMessage(msg *pb.ChaincodeMessage) error {
-	chaincodeLogger.Debug("[%s]Handling ChaincodeMessage of type: %s in state %s", shortuuid(msg.Uuid), msg.Type, handler.FSM.Current())
+func (handler *Handler) HandleMessage(msg *pb.ChaincodeMessage) (err error) {
+	handler.log(msg).Debug("Handling ChaincodeMessage")
+
+	if msg.Type != pb.ChaincodeMessage_CREDIT && msg.Type != pb.ChaincodeMessage_KEEPALIVE {
+		span := tracing.StartSpan("handle "+msg.Type.String(), msg.TraceContext, msg.Uuid)
+		span.SetTag("chaincode", handler.traceChaincodeName())
+		defer func() { span.Finish(err) }()
+	}
 
 	//QUERY_COMPLETED message can happen ONLY for Transaction_QUERY (stateless)
 	if msg.Type == pb.ChaincodeMessage_QUERY_COMPLETED {
-		chaincodeLogger.Debug("[%s]HandleMessage- QUERY_COMPLETED. Notify", msg.Uuid)
+		handler.log(msg).Debug("HandleMessage- QUERY_COMPLETED. Notify")
 		handler.deleteIsTransaction(msg.Uuid)
-		var err error
 		if msg.Payload, err = handler.encrypt(msg.Uuid, msg.Payload); nil != err {
-			chaincodeLogger.Debug("[%s]Failed to encrypt query result %s", msg.Uuid, string(msg.Payload))
+			handler.log(msg).Debug("Failed to encrypt query result %s", string(msg.Payload))
 			msg.Payload = []byte(fmt.Sprintf("Failed to encrypt query result %s", err.Error()))
 			msg.Type = pb.ChaincodeMessage_QUERY_ERROR
 		}
 		handler.notify(msg)
 		return nil
 	} else if msg.Type == pb.ChaincodeMessage_QUERY_ERROR {
-		chaincodeLogger.Debug("[%s]HandleMessage- QUERY_ERROR (%s). Notify", msg.Uuid, string(msg.Payload))
+		handler.log(msg).Debug("HandleMessage- QUERY_ERROR (%s). Notify", string(msg.Payload))
 		handler.deleteIsTransaction(msg.Uuid)
 		handler.notify(msg)
 		return nil
+	} else if msg.Type == pb.ChaincodeMessage_CREDIT {
+		return handler.handleCredit(msg)
+	} else if msg.Type == pb.ChaincodeMessage_KEEPALIVE {
+		// The answer to a probe, receiving it is all that matters
+		return nil
 	} else if msg.Type == pb.ChaincodeMessage_INVOKE_QUERY {
 		// Received request to query another chaincode from shim
-		chaincodeLogger.Debug("[%s]HandleMessage- Received request to query another chaincode", msg.Uuid)
+		handler.log(msg).Debug("HandleMessage- Received request to query another chaincode")
 		handler.handleQueryChaincode(msg)
 		return nil
+	} else if msg.Type == pb.ChaincodeMessage_FETCH_RESULT {
+		// Reads of spilled responses are served whatever the state
+		go handler.handleFetchResult(msg)
+		return nil
 	}
+	src := handler.FSM.Current()
 	if handler.FSM.Cannot(msg.Type.String()) {
 		// Check if this is a request from validator in query context
-		if msg.Type.String() == pb.ChaincodeMessage_PUT_STATE.String() || msg.Type.String() == pb.ChaincodeMessage_DEL_STATE.String() || msg.Type.String() == pb.ChaincodeMessage_INVOKE_CHAINCODE.String() {
+		if msg.Type.String() == pb.ChaincodeMessage_PUT_STATE.String() || msg.Type.String() == pb.ChaincodeMessage_DEL_STATE.String() || msg.Type == pb.ChaincodeMessage_DEL_STATE_RANGE || msg.Type.String() == pb.ChaincodeMessage_INVOKE_CHAINCODE.String() {
 			// Check if this UUID is a transaction
 			if !handler.getIsTransaction(msg.Uuid) {
-				payload := []byte(fmt.Sprintf("[%s]Cannot handle %s in query context", msg.Uuid, msg.Type.String()))
-				chaincodeLogger.Debug("[%s]Cannot handle %s in query context. Sending %s", msg.Uuid, msg.Type.String(), pb.ChaincodeMessage_ERROR)
-				errMsg := &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+				denied := fmt.Errorf("[%s]Cannot handle %s in query context", msg.Uuid, msg.Type.String())
+				handler.log(msg).Debug("Cannot handle %s in query context. Sending %s", msg.Type.String(), pb.ChaincodeMessage_ERROR)
+				errMsg := handler.errorMessage(msg, pb.ChaincodeError_ACCESS_DENIED, denied, map[string]string{"type": msg.Type.String()})
 				handler.serialSend(errMsg)
-				return fmt.Errorf("Cannot handle %s in query context", msg.Type.String())
+				err := fmt.Errorf("Cannot handle %s in query context", msg.Type.String())
+				handler.recordTransition(msg, src, err)
+				return err
 			}
 		}
 
 		// Other errors
-		return fmt.Errorf("[%s]Chaincode handler validator FSM cannot handle message (%s) with payload size (%d) while in state: %s", msg.Uuid, msg.Type.String(), len(msg.Payload), handler.FSM.Current())
+		err := fmt.Errorf("[%s]Chaincode handler validator FSM cannot handle message (%s) with payload size (%d) while in state: %s", msg.Uuid, msg.Type.String(), len(msg.Payload), handler.FSM.Current())
+		handler.recordTransition(msg, src, err)
+		return err
 	}
 	eventErr := handler.FSM.Event(msg.Type.String(), msg)
 	filteredErr := filterError(eventErr)
 	if filteredErr != nil {
-		chaincodeLogger.Debug("[%s]Failed to trigger FSM event %s: %s", msg.Uuid, msg.Type.String(), filteredErr)
+		handler.log(msg).Debug("Failed to trigger FSM event %s: %s", msg.Type.String(), filteredErr)
 	}
+	handler.recordTransition(msg, src, filteredErr)
 
 	return filteredErr
 }
@@ -1366,13 +1918,44 @@ func filterError(errFromFSMEvent error) error {
 }
 
 func (handler *Handler) sendExecuteMessage(msg *pb.ChaincodeMessage, tx *pb.Transaction) (chan *pb.ChaincodeMessage, error) {
+	return handler.sendExecuteMessageAsync(msg, tx, nil)
+}
+
+// sendExecuteMessageAsync sends msg like sendExecuteMessage and returns
+// without waiting for the response. If onResponse is set it is called with the
+// response, on a goroutine of its own, instead of the response being sent on
+// the channel returned.
+func (handler *Handler) sendExecuteMessageAsync(msg *pb.ChaincodeMessage, tx *pb.Transaction, onResponse func(*pb.ChaincodeMessage)) (chan *pb.ChaincodeMessage, error) {
+	// A transaction sent again is answered with the result of its execution
+	if msg.Type == pb.ChaincodeMessage_TRANSACTION {
+		if resp := handler.replayedResult(msg.Uuid); resp != nil {
+			handler.log(msg).Info("Transaction already executed, replaying its %s", resp.Type)
+			notfy := make(chan *pb.ChaincodeMessage, 1)
+			if onResponse != nil {
+				go onResponse(resp)
+			} else {
+				notfy <- resp
+			}
+			return notfy, nil
+		}
+	}
+
 	txctx, err := handler.createTxContext(msg.Uuid, tx)
 	if err != nil {
 		return nil, err
 	}
+	handler.Lock()
+	// the result of a transaction is kept once the transaction commits,
+	// chaincode invoked by chaincode commits with the transaction invoking it
+	txctx.replayable = msg.Type == pb.ChaincodeMessage_TRANSACTION && tx != nil
+	txctx.onResponse = onResponse
+	if msg.Deadline != nil {
+		txctx.deadline = time.Unix(msg.Deadline.Seconds, int64(msg.Deadline.Nanos))
+	}
+	handler.Unlock()
 
 	// Mark UUID as either transaction or query
-	chaincodeLogger.Debug("[%s]Inside sendExecuteMessage. Message %s", shortuuid(msg.Uuid), msg.Type.String())
+	handler.log(msg).Debug("Inside sendExecuteMessage. Message %s", msg.Type.String())
 	if msg.Type.String() == pb.ChaincodeMessage_QUERY.String() {
 		handler.markIsTransaction(msg.Uuid, false)
 	} else {
@@ -1384,14 +1967,25 @@ func (handler *Handler) sendExecuteMessage(msg *pb.ChaincodeMessage, tx *pb.Tran
 		return nil, err
 	}
 
+	// The chaincode continues the trace from the span of the execution
+	span := tracing.StartSpan("execute "+msg.Type.String(), msg.TraceContext, msg.Uuid)
+	span.SetTag("chaincode", handler.traceChaincodeName())
+	if span != nil {
+		handler.Lock()
+		txctx.span = span
+		handler.Unlock()
+		msg.TraceContext = span.Context()
+	}
+
 	// Trigger FSM event if it is a transaction
 	if msg.Type.String() == pb.ChaincodeMessage_TRANSACTION.String() {
-		chaincodeLogger.Debug("[%s]sendExecuteMsg trigger event %s", shortuuid(msg.Uuid), msg.Type)
+		handler.log(msg).Debug("sendExecuteMsg trigger event %s", msg.Type)
 		handler.triggerNextState(msg, true)
 	} else {
 		// Send the message to shim
-		chaincodeLogger.Debug("[%s]sending query", shortuuid(msg.Uuid))
+		handler.log(msg).Debug("sending query")
 		if err = handler.serialSend(msg); err != nil {
+			span.Finish(err)
 			handler.deleteTxContext(msg.Uuid)
 			return nil, fmt.Errorf("[%s]SendMessage error sending (%s)", shortuuid(msg.Uuid), err)
 		}
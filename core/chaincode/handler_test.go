@@ -0,0 +1,506 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/looplab/fsm"
+
+	google_protobuf "google/protobuf"
+
+	"github.com/hyperledger/fabric/core/ledger"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+type recvResult struct {
+	msg *pb.ChaincodeMessage
+	err error
+}
+
+// mockChaincodeStream lets a test drive the peer side of a chaincode stream
+type mockChaincodeStream struct {
+	recvCh chan recvResult
+	sendCh chan *pb.ChaincodeMessage
+}
+
+func newMockChaincodeStream() *mockChaincodeStream {
+	return &mockChaincodeStream{recvCh: make(chan recvResult), sendCh: make(chan *pb.ChaincodeMessage, 10)}
+}
+
+func (s *mockChaincodeStream) Send(msg *pb.ChaincodeMessage) error {
+	s.sendCh <- msg
+	return nil
+}
+
+func (s *mockChaincodeStream) Recv() (*pb.ChaincodeMessage, error) {
+	r := <-s.recvCh
+	return r.msg, r.err
+}
+
+func newTestHandler(stream PeerChaincodeStream) *Handler {
+	handler := newChaincodeSupportHandler(nil, stream)
+	handler.txCtxs = make(map[string]*transactionContext)
+	handler.uuidMap = make(map[string]time.Time)
+	handler.isTransaction = make(map[string]bool)
+	return handler
+}
+
+func runProcessStream(handler *Handler) chan error {
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.processStream()
+	}()
+	return done
+}
+
+func waitForNotification(t *testing.T, notfy chan *pb.ChaincodeMessage) *pb.ChaincodeMessage {
+	select {
+	case msg := <-notfy:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for notification")
+	}
+	return nil
+}
+
+func TestProcessStreamEOFNotifiesPending(t *testing.T) {
+	stream := newMockChaincodeStream()
+	handler := newTestHandler(stream)
+
+	var notifiers []chan *pb.ChaincodeMessage
+	for _, uuid := range []string{"uuid-1", "uuid-2"} {
+		txctx, err := handler.createTxContext(uuid, nil)
+		if err != nil {
+			t.Fatalf("Error creating tx context: %s", err)
+		}
+		notifiers = append(notifiers, txctx.responseNotifier)
+	}
+
+	done := runProcessStream(handler)
+
+	// chaincode goes away in the middle of the transactions
+	stream.recvCh <- recvResult{nil, io.EOF}
+
+	for i, notfy := range notifiers {
+		msg := waitForNotification(t, notfy)
+		if msg.Type != pb.ChaincodeMessage_ERROR {
+			t.Fatalf("Expected ERROR for pending transaction %d, got %s", i, msg.Type)
+		}
+		if !strings.Contains(string(msg.Payload), "stream closed") {
+			t.Fatalf("Expected stream closed reason, got %s", string(msg.Payload))
+		}
+	}
+
+	if err := <-done; err != io.EOF {
+		t.Fatalf("Expected EOF from processStream, got %v", err)
+	}
+}
+
+func TestProcessStreamRecvErrorNotifiesPending(t *testing.T) {
+	stream := newMockChaincodeStream()
+	handler := newTestHandler(stream)
+
+	txctx, err := handler.createTxContext("uuid-1", nil)
+	if err != nil {
+		t.Fatalf("Error creating tx context: %s", err)
+	}
+
+	done := runProcessStream(handler)
+	stream.recvCh <- recvResult{nil, fmt.Errorf("connection reset")}
+
+	msg := waitForNotification(t, txctx.responseNotifier)
+	if msg.Type != pb.ChaincodeMessage_ERROR {
+		t.Fatalf("Expected ERROR, got %s", msg.Type)
+	}
+	if !strings.Contains(string(msg.Payload), "connection reset") {
+		t.Fatalf("Expected the stream error in the payload, got %s", string(msg.Payload))
+	}
+	<-done
+}
+
+func TestProcessStreamCloseKeepsPendingResponse(t *testing.T) {
+	stream := newMockChaincodeStream()
+	handler := newTestHandler(stream)
+
+	txctx, err := handler.createTxContext("uuid-1", nil)
+	if err != nil {
+		t.Fatalf("Error creating tx context: %s", err)
+	}
+	// response arrived but has not yet been picked up by the waiter
+	handler.notify(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_COMPLETED, Uuid: "uuid-1"})
+
+	done := runProcessStream(handler)
+	stream.recvCh <- recvResult{nil, io.EOF}
+	<-done
+
+	msg := waitForNotification(t, txctx.responseNotifier)
+	if msg.Type != pb.ChaincodeMessage_COMPLETED {
+		t.Fatalf("Expected pending COMPLETED to be preserved, got %s", msg.Type)
+	}
+}
+
+func TestProcessStreamPanicEndsStream(t *testing.T) {
+	stream := newMockChaincodeStream()
+	handler := newTestHandler(stream)
+	txctx, err := handler.createTxContext("uuid-1", nil)
+	if err != nil {
+		t.Fatalf("Error creating tx context: %s", err)
+	}
+	handler.FSM = fsm.NewFSM(createdstate,
+		fsm.Events{{Name: pb.ChaincodeMessage_REGISTER.String(), Src: []string{createdstate}, Dst: establishedstate}},
+		fsm.Callbacks{"before_" + pb.ChaincodeMessage_REGISTER.String(): func(e *fsm.Event) { panic("boom") }})
+
+	done := runProcessStream(handler)
+	stream.recvCh <- recvResult{&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_REGISTER, Uuid: "uuid-2"}, nil}
+
+	if err := <-done; err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("Expected the panic to end the stream, got %v", err)
+	}
+	select {
+	case msg := <-stream.sendCh:
+		if msg.Type != pb.ChaincodeMessage_ERROR || msg.Uuid != "uuid-2" {
+			t.Fatalf("Expected ERROR for the message that panicked, got %s for %s", msg.Type, msg.Uuid)
+		}
+	default:
+		t.Fatal("Expected the chaincode to be sent an ERROR")
+	}
+	if !handler.FSM.Is(endstate) {
+		t.Fatalf("Expected the handler in the end state, got %s", handler.FSM.Current())
+	}
+	if msg := waitForNotification(t, txctx.responseNotifier); msg.Type != pb.ChaincodeMessage_ERROR {
+		t.Fatalf("Expected ERROR for the pending transaction, got %s", msg.Type)
+	}
+}
+
+func TestNegotiateProtocolVersion(t *testing.T) {
+	tests := []struct {
+		min, max int32
+		expected int32
+		fails    bool
+	}{
+		{0, 0, pb.ChaincodeProtocolV1, false},
+		{1, 1, pb.ChaincodeProtocolV1, false},
+		{1, 2, pb.ChaincodeProtocolV2, false},
+		{1, pb.MaxChaincodeProtocol + 5, pb.MaxChaincodeProtocol, false},
+		{pb.MaxChaincodeProtocol + 1, pb.MaxChaincodeProtocol + 5, 0, true},
+	}
+	for _, test := range tests {
+		version, err := negotiateProtocolVersion(test.min, test.max)
+		if test.fails {
+			if err == nil {
+				t.Errorf("Expected negotiation of %d-%d to fail, got %d", test.min, test.max, version)
+			}
+			continue
+		}
+		if err != nil || version != test.expected {
+			t.Errorf("Expected version %d for %d-%d, got %d (%v)", test.expected, test.min, test.max, version, err)
+		}
+	}
+}
+
+func TestRegistrationReadsChaincodeID(t *testing.T) {
+	// Shims that predate negotiation send a plain ChaincodeID
+	payload, _ := proto.Marshal(&pb.ChaincodeID{Path: "path", Name: "name"})
+	registration := &pb.ChaincodeRegistration{}
+	if err := proto.Unmarshal(payload, registration); err != nil {
+		t.Fatalf("Error unmarshalling ChaincodeID as registration: %s", err)
+	}
+	if registration.Path != "path" || registration.Name != "name" || registration.MaxProtocolVersion != 0 {
+		t.Fatalf("Unexpected registration %s", registration)
+	}
+}
+
+func TestSplitResponse(t *testing.T) {
+	payload := []byte(strings.Repeat("0123456789", 25))
+	msg := &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: payload, Uuid: "uuid"}
+
+	if msgs, _ := splitResponse(msg, len(payload)); len(msgs) != 1 || msgs[0] != msg {
+		t.Fatal("Expected a response of chunk size to be sent as is")
+	}
+
+	msgs, err := splitResponse(msg, 100)
+	if err != nil {
+		t.Fatalf("Error splitting response: %s", err)
+	}
+	if len(msgs) != 3 {
+		t.Fatalf("Expected 3 chunks, got %d", len(msgs))
+	}
+	var reassembled []byte
+	for i, m := range msgs {
+		chunk := &pb.ChaincodeResponseChunk{}
+		if err := proto.Unmarshal(m.Payload, chunk); err != nil {
+			t.Fatalf("Error unmarshalling chunk: %s", err)
+		}
+		if m.Type != pb.ChaincodeMessage_RESPONSE_CHUNK || m.Uuid != msg.Uuid || chunk.Sequence != uint32(i) || chunk.Last != (i == len(msgs)-1) {
+			t.Fatalf("Unexpected chunk %d: %s %s", i, m.Type, chunk)
+		}
+		reassembled = append(reassembled, chunk.Data...)
+	}
+	if string(reassembled) != string(payload) {
+		t.Fatal("Reassembled payload does not match the original")
+	}
+}
+
+func TestSendWindowQueuesBeyondCredits(t *testing.T) {
+	var sent []string
+	send := func(msg *pb.ChaincodeMessage) error {
+		sent = append(sent, msg.Uuid)
+		return nil
+	}
+	msg := func(uuid string) *pb.ChaincodeMessage {
+		return &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Uuid: uuid}
+	}
+	w := newSendWindow(2, 2)
+
+	if err := w.send([]*pb.ChaincodeMessage{msg("a"), msg("b"), msg("c")}, send); err != nil {
+		t.Fatalf("Error sending: %s", err)
+	}
+	if len(sent) != 2 || len(w.queue) != 1 {
+		t.Fatalf("Expected 2 messages sent and 1 queued, got %v sent and %d queued", sent, len(w.queue))
+	}
+	if err := w.send([]*pb.ChaincodeMessage{msg("d"), msg("e")}, send); err == nil {
+		t.Fatal("Expected an error when the queue cannot hold all the messages")
+	}
+	if len(w.queue) != 1 {
+		t.Fatalf("Expected a rejected send to queue nothing, %d queued", len(w.queue))
+	}
+	if err := w.send([]*pb.ChaincodeMessage{msg("d")}, send); err != nil {
+		t.Fatalf("Error sending: %s", err)
+	}
+
+	if err := w.grant(10, send); err != nil {
+		t.Fatalf("Error granting credits: %s", err)
+	}
+	if strings.Join(sent, "") != "abcd" || len(w.queue) != 0 {
+		t.Fatalf("Expected the queued messages to be sent in order, got %v", sent)
+	}
+	if w.credits != 0 {
+		t.Fatalf("Expected credits to be capped at the window, got %d left", w.credits)
+	}
+}
+
+func TestHandlerCreditReleasesQueuedMessages(t *testing.T) {
+	stream := newMockChaincodeStream()
+	handler := newTestHandler(stream)
+	handler.window = newSendWindow(1, 10)
+
+	for _, uuid := range []string{"tx1", "tx2"} {
+		if err := handler.serialSend(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Uuid: uuid}); err != nil {
+			t.Fatalf("Error sending: %s", err)
+		}
+	}
+	if sent := <-stream.sendCh; sent.Uuid != "tx1" {
+		t.Fatalf("Expected the response to tx1 to be sent, got %v", sent)
+	}
+	select {
+	case sent := <-stream.sendCh:
+		t.Fatalf("Expected the response to tx2 to wait for credits, got %v", sent)
+	default:
+	}
+
+	payload, _ := proto.Marshal(&pb.ChaincodeCredit{Credits: 1})
+	if err := handler.HandleMessage(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_CREDIT, Payload: payload}); err != nil {
+		t.Fatalf("Error handling %s: %s", pb.ChaincodeMessage_CREDIT, err)
+	}
+	select {
+	case sent := <-stream.sendCh:
+		if sent.Uuid != "tx2" {
+			t.Fatalf("Expected the response to tx2 to be sent, got %v", sent)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the credit to release the response to tx2")
+	}
+}
+
+// exclusiveStream fails Send if it is called concurrently
+type exclusiveStream struct {
+	mockChaincodeStream
+	sending int32
+}
+
+func (s *exclusiveStream) Send(msg *pb.ChaincodeMessage) error {
+	if !atomic.CompareAndSwapInt32(&s.sending, 0, 1) {
+		return fmt.Errorf("concurrent Send")
+	}
+	defer atomic.StoreInt32(&s.sending, 0)
+	time.Sleep(time.Millisecond)
+	return s.mockChaincodeStream.Send(msg)
+}
+
+func TestSerialSendFromConcurrentGoroutines(t *testing.T) {
+	stream := &exclusiveStream{mockChaincodeStream: mockChaincodeStream{sendCh: make(chan *pb.ChaincodeMessage, 20)}}
+	handler := newTestHandler(stream)
+	defer handler.stopWriter()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- handler.serialSend(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Uuid: fmt.Sprintf("tx%d", i)})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Error sending: %s", err)
+		}
+	}
+	if len(stream.sendCh) != 20 {
+		t.Fatalf("Expected 20 messages sent, got %d", len(stream.sendCh))
+	}
+}
+
+func TestSerialSendAfterWriterStopped(t *testing.T) {
+	stream := newMockChaincodeStream()
+	handler := newTestHandler(stream)
+	handler.stopWriter()
+
+	if err := handler.serialSend(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Uuid: "tx"}); err == nil {
+		t.Fatal("Expected sending after the writer stopped to fail")
+	}
+	if len(stream.sendCh) != 0 {
+		t.Fatal("Expected nothing to be sent after the writer stopped")
+	}
+}
+
+func TestAbortTransactionReleasesUUID(t *testing.T) {
+	stream := newMockChaincodeStream()
+	handler := newTestHandler(stream)
+
+	if _, err := handler.createTxContext("slow-tx", nil); err != nil {
+		t.Fatalf("Error creating tx context: %s", err)
+	}
+	handler.markIsTransaction("slow-tx", true)
+	handler.createUUIDEntry("slow-tx")
+
+	deadline := &google_protobuf.Timestamp{Seconds: time.Now().Unix()}
+	msg := &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_TRANSACTION, Uuid: "slow-tx", Deadline: deadline}
+	handler.abortTransaction(msg, fmt.Errorf("Timeout expired while executing transaction"))
+
+	select {
+	case sent := <-stream.sendCh:
+		if sent.Type != pb.ChaincodeMessage_ERROR || sent.Uuid != "slow-tx" || sent.Deadline == nil {
+			t.Fatalf("Expected an abort ERROR carrying the deadline, got %v", sent)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for the abort")
+	}
+	if handler.getTxContext("slow-tx") != nil {
+		t.Fatalf("Expected the tx context to be released")
+	}
+	if handler.getIsTransaction("slow-tx") {
+		t.Fatalf("Expected the transaction mark to be released")
+	}
+	if !handler.createUUIDEntry("slow-tx") {
+		t.Fatalf("Expected the UUID entry to be released")
+	}
+}
+
+func TestDuplicateRequestRejected(t *testing.T) {
+	handler := newTestHandler(newMockChaincodeStream())
+	handler.createUUIDEntry("tx1")
+	msg := &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_GET_STATE, Uuid: "tx1"}
+
+	handler.protocolVersion = pb.ChaincodeProtocolV8
+	if ok, reply := handler.acquireUUIDEntry(msg); ok || reply != nil {
+		t.Fatalf("Expected the duplicate of an older shim to be dropped, got %v, %v", ok, reply)
+	}
+
+	handler.protocolVersion = pb.ChaincodeProtocolV9
+	ok, reply := handler.acquireUUIDEntry(msg)
+	if ok || reply == nil || reply.Type != pb.ChaincodeMessage_ERROR || reply.Uuid != "tx1" {
+		t.Fatalf("Expected the duplicate to be answered with an ERROR, got %v, %v", ok, reply)
+	}
+	if chaincodeErr := pb.ChaincodeErrorFromPayload(pb.ChaincodeProtocolV9, reply.Payload); chaincodeErr.Code != pb.ChaincodeError_DUPLICATE_REQUEST || !chaincodeErr.Retryable {
+		t.Fatalf("Expected a retryable DUPLICATE_REQUEST, got %v", chaincodeErr)
+	}
+
+	handler.deleteUUIDEntry("tx1")
+	if ok, _ = handler.acquireUUIDEntry(msg); !ok {
+		t.Fatal("Expected the request to be served once the pending one is answered")
+	}
+}
+
+func TestDuplicateRequestQueued(t *testing.T) {
+	handler := newTestHandler(newMockChaincodeStream())
+	handler.chaincodeSupport = &ChaincodeSupport{duplicateRequests: DuplicateRequestQueue}
+	handler.createUUIDEntry("tx1")
+
+	acquired := make(chan bool, 1)
+	go func() {
+		ok, _ := handler.acquireUUIDEntry(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_PUT_STATE, Uuid: "tx1"})
+		acquired <- ok
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("Expected the duplicate to wait for the pending request")
+	case <-time.After(50 * time.Millisecond):
+	}
+	handler.deleteUUIDEntry("tx1")
+	select {
+	case ok := <-acquired:
+		if !ok {
+			t.Fatal("Expected the queued request to be served")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the queued request")
+	}
+	if handler.createUUIDEntry("tx1") {
+		t.Fatal("Expected the queued request to hold the UUID entry")
+	}
+}
+
+func TestHandlerRoutesStateToChainLedger(t *testing.T) {
+	handler := newTestHandler(newMockChaincodeStream())
+	handler.chaincodeSupport = &ChaincodeSupport{name: "chain1"}
+
+	provider := ledger.NewChainLedgers()
+	chain1, chain2 := &ledger.Ledger{}, &ledger.Ledger{}
+	provider.Register("chain1", chain1)
+	provider.Register("chain2", chain2)
+	handler.chaincodeSupport.SetLedgerProvider(provider)
+
+	if l, err := handler.getLedger(&pb.ChaincodeMessage{Uuid: "tx"}); err != nil || l != chain1 {
+		t.Fatalf("Expected a message without chain to use the handler's chain, got %v, %v", l, err)
+	}
+	if l, err := handler.getLedger(&pb.ChaincodeMessage{Uuid: "tx", ChainID: "chain1"}); err != nil || l != chain1 {
+		t.Fatalf("Expected the ledger of chain1, got %v, %v", l, err)
+	}
+	if l, err := handler.getLedger(&pb.ChaincodeMessage{Uuid: "tx", ChainID: "chain2"}); err == nil || l != nil {
+		t.Fatalf("Expected a message for another chain to be refused, got %v, %v", l, err)
+	}
+
+	handler.chaincodeSupport = &ChaincodeSupport{name: "chain3"}
+	handler.chaincodeSupport.SetLedgerProvider(provider)
+	if _, err := handler.getLedger(&pb.ChaincodeMessage{Uuid: "tx"}); err == nil {
+		t.Fatalf("Expected error for an unknown chain")
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/hyperledger/fabric/core/fsmtest"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// newScriptedHandler returns a runner driving a new handler of chain over a
+// fake stream, along with the function releasing it
+func newScriptedHandler(chain *ChaincodeSupport) (*fsmtest.Runner, func()) {
+	stream := fsmtest.NewChaincodeStream()
+	handler := newChaincodeSupportHandler(chain, stream)
+	runner := &fsmtest.Runner{
+		Deliver: func(msg proto.Message) error { return handler.HandleMessage(msg.(*pb.ChaincodeMessage)) },
+		State:   handler.FSM.Current,
+		Sent:    stream.Sent(),
+	}
+	release := func() {
+		handler.stopWriter()
+		stream.Close()
+		if handler.ChaincodeID != nil {
+			chain.deregisterHandler(handler, fmt.Errorf("script ended"))
+		}
+	}
+	return runner, release
+}
+
+func registerScript(t *testing.T, name string) fsmtest.Script {
+	payload, err := proto.Marshal(&pb.ChaincodeRegistration{Name: name, MinProtocolVersion: pb.MinChaincodeProtocol, MaxProtocolVersion: pb.MaxChaincodeProtocol})
+	if err != nil {
+		t.Fatalf("Error marshalling registration: %s", err)
+	}
+	return fsmtest.Script{
+		fsmtest.Deliver(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_REGISTER, Payload: payload}),
+		fsmtest.Expect(fsmtest.ChaincodeMessageOf(pb.ChaincodeMessage_REGISTERED, "")),
+		fsmtest.ExpectState(establishedstate),
+	}
+}
+
+func TestScriptedRegistration(t *testing.T) {
+	runner, release := newScriptedHandler(newTenantTestSupport(0))
+	defer release()
+
+	script := append(registerScript(t, "scripted"),
+		// a chaincode registers once per stream
+		fsmtest.Refuse(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_REGISTER}),
+		fsmtest.ExpectState(establishedstate))
+	if err := runner.Run(script); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Writes outside of a transaction are denied whatever the order they arrive in
+func TestScriptedQueryContextWritesDenied(t *testing.T) {
+	chain := newTenantTestSupport(0)
+	denied := func(t pb.ChaincodeMessage_Type, uuid string) fsmtest.Script {
+		return fsmtest.Script{
+			fsmtest.Refuse(&pb.ChaincodeMessage{Type: t, Uuid: uuid}),
+			fsmtest.Expect(func(msg proto.Message) error {
+				if err := fsmtest.ChaincodeMessageOf(pb.ChaincodeMessage_ERROR, uuid)(msg); err != nil {
+					return err
+				}
+				if code := pb.ChaincodeErrorFromPayload(pb.MaxChaincodeProtocol, msg.(*pb.ChaincodeMessage).Payload).Code; code != pb.ChaincodeError_ACCESS_DENIED {
+					return fmt.Errorf("expected ACCESS_DENIED, got %s", code)
+				}
+				return nil
+			}),
+		}
+	}
+
+	rounds := 0
+	newRunner := func() (*fsmtest.Runner, func(), error) {
+		rounds++
+		runner, release := newScriptedHandler(chain)
+		return runner, release, runner.Run(registerScript(t, fmt.Sprintf("scripted-%d", rounds)))
+	}
+	err := fsmtest.Fuzz(1, 20, newRunner,
+		denied(pb.ChaincodeMessage_PUT_STATE, "q1"),
+		denied(pb.ChaincodeMessage_DEL_STATE, "q2"),
+		denied(pb.ChaincodeMessage_INVOKE_CHAINCODE, "q3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	"github.com/hyperledger/fabric/core/util"
+	pb "github.com/hyperledger/fabric/protos"
+	"github.com/looplab/fsm"
+)
+
+// afterGetHistoryForKey handles a GET_HISTORY_FOR_KEY request from the chaincode.
+func (handler *Handler) afterGetHistoryForKey(e *fsm.Event, state string) {
+	msg, ok := e.Args[0].(*pb.ChaincodeMessage)
+	if !ok {
+		e.Cancel(fmt.Errorf("Received unexpected message type"))
+		return
+	}
+	handler.log(msg).Debug("Received %s, invoking get history from ledger", pb.ChaincodeMessage_GET_HISTORY_FOR_KEY)
+
+	// Query ledger for the history of the key
+	handler.handleGetHistoryForKey(msg)
+	handler.log(msg).Debug("Exiting GET_HISTORY_FOR_KEY")
+}
+
+// Handles query to ledger for the history of a key. The values are paged
+// like those of a range query, the chaincode getting the next ones with
+// RANGE_QUERY_STATE_NEXT, each of them being a marshaled KeyModification
+func (handler *Handler) handleGetHistoryForKey(msg *pb.ChaincodeMessage) {
+	// The defer followed by triggering a go routine dance is needed to ensure that the previous state transition
+	// is completed before the next one is triggered. The previous state transition is deemed complete only when
+	// the afterGetHistoryForKey function is exited.
+	if !handler.admitStateRequest(msg) {
+		return
+	}
+	go func() {
+		defer handler.stateLimiter.release()
+
+		// Check if this is the unique state request from this chaincode uuid
+		if ok, reply := handler.acquireUUIDEntry(msg); !ok {
+			if reply != nil {
+				handler.serialSend(reply)
+			}
+			return
+		}
+
+		var serialSendMsg *pb.ChaincodeMessage
+
+		defer func() {
+			handler.deleteUUIDEntry(msg.Uuid)
+			handler.log(serialSendMsg).Debug("handleGetHistoryForKey serial send %s", serialSendMsg.Type)
+			handler.serialSend(serialSendMsg)
+		}()
+
+		key := string(msg.Payload)
+		ledgerObj, err := handler.getLedger(msg)
+		if err == nil {
+			var historyIter *ledger.HistoryIterator
+			historyIter, err = ledgerObj.GetHistoryForKey(handler.getStateNamespace(), key)
+			if err == nil {
+				iterID := util.GenerateUUID()
+				txContext := handler.getTxContext(msg.Uuid)
+				iter := handler.putRangeQueryIterator(txContext, iterID, historyIter)
+				serialSendMsg = handler.rangeQueryResponse(msg, txContext, iterID, iter, iter.Next())
+			}
+		}
+		handler.traceStateOp(msg.Uuid, msg.Type, key, err)
+		if err != nil {
+			handler.log(msg).Error("Failed to get history of key [%s](%s). Sending %s", key, err, pb.ChaincodeMessage_ERROR)
+			serialSendMsg = handler.errorMessage(msg, pb.ChaincodeError_LEDGER, err, map[string]string{"key": key})
+		}
+	}()
+}
+
+// decryptIteratorValue decrypts a value read from iter. The values of a
+// history iterator are KeyModifications of which only the value is encrypted
+func (handler *Handler) decryptIteratorValue(uuid string, iter statemgmt.RangeScanIterator, value []byte) ([]byte, error) {
+	if _, ok := unlockedIterator(iter).(*ledger.HistoryIterator); !ok {
+		return handler.decrypt(uuid, value)
+	}
+	modification := &pb.KeyModification{}
+	if err := proto.Unmarshal(value, modification); err != nil {
+		return nil, err
+	}
+	if modification.IsDelete {
+		return value, nil
+	}
+	decryptedValue, err := handler.decrypt(uuid, modification.Value)
+	if err != nil {
+		return nil, err
+	}
+	modification.Value = decryptedValue
+	return proto.Marshal(modification)
+}
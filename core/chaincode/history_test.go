@@ -0,0 +1,119 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos"
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+)
+
+// historyChaincode sets and deletes keys and reads their history
+type historyChaincode struct {
+}
+
+func (cc *historyChaincode) Init(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	return nil, nil
+}
+
+func (cc *historyChaincode) Invoke(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	switch function {
+	case "put":
+		return nil, stub.PutState(args[0], []byte(args[1]))
+	case "del":
+		return nil, stub.DelState(args[0])
+	}
+	return nil, fmt.Errorf("unknown function %s", function)
+}
+
+// Query returns the number of values taken by the key, followed by the last
+// ones, "deleted" standing for a deletion
+func (cc *historyChaincode) Query(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	iter, err := stub.GetHistoryForKey(args[0])
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	var values []string
+	for iter.HasNext() {
+		modification, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		if modification.TxID == "" || modification.Timestamp == nil {
+			return nil, fmt.Errorf("history of %s without transaction", args[0])
+		}
+		if modification.IsDelete {
+			values = append(values, "deleted")
+		} else {
+			values = append(values, string(modification.Value))
+		}
+	}
+	count := len(values)
+	if count > 3 {
+		values = values[count-3:]
+	}
+	return []byte(strconv.Itoa(count) + ":" + strings.Join(values, ",")), nil
+}
+
+func TestGetHistoryForKey(t *testing.T) {
+	viper.Set("peer.fileSystemPath", "/var/hyperledger/test/tmpdb")
+	getPeerEndpoint := func() (*pb.PeerEndpoint, error) {
+		return &pb.PeerEndpoint{ID: &pb.PeerID{Name: "testpeer"}, Address: "0.0.0.0:40303"}, nil
+	}
+	NewChaincodeSupport(DefaultChain, getPeerEndpoint, false, 10*time.Second, nil)
+
+	if err := RegisterSystemChaincode(&SystemChaincode{Name: "historysyscc", Chaincode: &historyChaincode{}}); err != nil {
+		t.Fatalf("Error registering system chaincode: %s", err)
+	}
+	cID := &pb.ChaincodeID{Name: "historysyscc"}
+	ctxt := context.Background()
+	defer GetChain(DefaultChain).StopChaincode(ctxt, cID)
+
+	// more values than fit in a single page of the history
+	numValues := maxRangeQueryStateLimit + 5
+	for i := 0; i < numValues; i++ {
+		spec := &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_GOLANG, ChaincodeID: cID, CtorMsg: &pb.ChaincodeInput{Function: "put", Args: []string{"a", strconv.Itoa(i)}}}
+		if _, _, err := invoke(ctxt, spec, pb.Transaction_CHAINCODE_INVOKE); err != nil {
+			t.Fatalf("Error invoking chaincode: %s", err)
+		}
+	}
+	spec := &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_GOLANG, ChaincodeID: cID, CtorMsg: &pb.ChaincodeInput{Function: "del", Args: []string{"a"}}}
+	if _, _, err := invoke(ctxt, spec, pb.Transaction_CHAINCODE_INVOKE); err != nil {
+		t.Fatalf("Error invoking chaincode: %s", err)
+	}
+
+	spec = &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_GOLANG, ChaincodeID: cID, CtorMsg: &pb.ChaincodeInput{Function: "history", Args: []string{"a"}}}
+	_, value, err := invoke(ctxt, spec, pb.Transaction_CHAINCODE_QUERY)
+	if err != nil {
+		t.Fatalf("Error querying chaincode: %s", err)
+	}
+	expected := fmt.Sprintf("%d:%d,%d,deleted", numValues+1, numValues-2, numValues-1)
+	if string(value) != expected {
+		t.Fatalf("Expected history %s, got %s", expected, value)
+	}
+}
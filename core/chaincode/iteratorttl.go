@@ -0,0 +1,133 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+)
+
+// lockedIterator serializes the use of a range query iterator, which the
+// reaper may close while the handler advances it. A closed iterator is
+// exhausted, closing it again does nothing.
+type lockedIterator struct {
+	sync.Mutex
+	iter   statemgmt.RangeScanIterator
+	closed bool
+}
+
+func (l *lockedIterator) Next() bool {
+	l.Lock()
+	defer l.Unlock()
+	return !l.closed && l.iter.Next()
+}
+
+func (l *lockedIterator) GetKeyValue() (string, []byte) {
+	l.Lock()
+	defer l.Unlock()
+	if l.closed {
+		return "", nil
+	}
+	return l.iter.GetKeyValue()
+}
+
+func (l *lockedIterator) Close() {
+	l.Lock()
+	defer l.Unlock()
+	if !l.closed {
+		l.closed = true
+		l.iter.Close()
+	}
+}
+
+// unlockedIterator returns the iterator wrapped by iter, if any
+func unlockedIterator(iter statemgmt.RangeScanIterator) statemgmt.RangeScanIterator {
+	if l, ok := iter.(*lockedIterator); ok {
+		return l.iter
+	}
+	return iter
+}
+
+// expireIdleIterators closes the range query iterators of this handler that
+// have not been advanced for ttl, releasing their ledger resources. A later
+// RANGE_QUERY_STATE_NEXT for an expired iterator is answered with an ERROR.
+// It returns the number of iterators closed.
+func (handler *Handler) expireIdleIterators(ttl time.Duration) int {
+	handler.Lock()
+	defer handler.Unlock()
+
+	expired := 0
+	now := time.Now()
+	for uuid, tctx := range handler.txCtxs {
+		for iterID, used := range tctx.rangeQueryIteratorUsed {
+			if now.Sub(used) < ttl {
+				continue
+			}
+			handler.logTx(uuid).Debug("Closing range query iterator %s idle for %s", iterID, now.Sub(used))
+			if iter := tctx.rangeQueryIteratorMap[iterID]; iter != nil {
+				iter.Close()
+			}
+			delete(tctx.rangeQueryIteratorMap, iterID)
+			delete(tctx.rangeQueryIteratorCreated, iterID)
+			delete(tctx.rangeQueryIteratorUsed, iterID)
+			expired++
+		}
+	}
+	return expired
+}
+
+// ExpireIdleIterators closes the range query iterators of all chaincodes that
+// have not been advanced for ttl and returns the number closed
+func (chaincodeSupport *ChaincodeSupport) ExpireIdleIterators(ttl time.Duration) int {
+	chaincodeSupport.handlerMap.RLock()
+	handlers := make([]*Handler, 0, len(chaincodeSupport.handlerMap.chaincodeMap))
+	for _, handler := range chaincodeSupport.handlerMap.chaincodeMap {
+		handlers = append(handlers, handler)
+	}
+	chaincodeSupport.handlerMap.RUnlock()
+
+	expired := 0
+	for _, handler := range handlers {
+		expired += handler.expireIdleIterators(ttl)
+	}
+	return expired
+}
+
+// startIteratorReaper periodically closes range query iterators idle for ttl,
+// until the chaincode support is stopped
+func (chaincodeSupport *ChaincodeSupport) startIteratorReaper(ttl time.Duration) {
+	chaincodeLog.Info("Starting range query iterator reaper, ttl %s", ttl)
+	ticker := time.NewTicker(ttl / 2)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if expired := chaincodeSupport.ExpireIdleIterators(ttl); expired > 0 {
+					chaincodeLog.Info("Closed %d idle range query iterators", expired)
+				}
+			case <-chaincodeSupport.stop:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,137 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"testing"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+type closeRecorder struct {
+	closed bool
+}
+
+func (i *closeRecorder) Next() bool                    { return false }
+func (i *closeRecorder) GetKeyValue() (string, []byte) { return "", nil }
+func (i *closeRecorder) Close()                        { i.closed = true }
+
+func TestExpireIdleIterators(t *testing.T) {
+	handler := newTestHandler(newMockChaincodeStream())
+	handler.ChaincodeID = &pb.ChaincodeID{Name: "iterators"}
+
+	txctx, err := handler.createTxContext("tx", nil)
+	if err != nil {
+		t.Fatalf("Error creating tx context: %s", err)
+	}
+	idle, busy := &closeRecorder{}, &closeRecorder{}
+	handler.putRangeQueryIterator(txctx, "idle", idle)
+	handler.putRangeQueryIterator(txctx, "busy", busy)
+	txctx.rangeQueryIteratorUsed["idle"] = time.Now().Add(-time.Hour)
+	txctx.rangeQueryIteratorUsed["busy"] = time.Now().Add(-time.Hour)
+
+	// Advancing the iterator keeps it alive
+	if handler.getRangeQueryIterator(txctx, "busy") == nil {
+		t.Fatal("Expected iterator to be found")
+	}
+
+	if n := handler.expireIdleIterators(time.Minute); n != 1 {
+		t.Fatalf("Expected 1 idle iterator to be closed, closed %d", n)
+	}
+	if !idle.closed || busy.closed {
+		t.Fatalf("Expected only the idle iterator to be closed, idle closed %t, busy closed %t", idle.closed, busy.closed)
+	}
+	if handler.getRangeQueryIterator(txctx, "idle") != nil {
+		t.Fatal("Expected expired iterator to be released")
+	}
+	if handler.getRangeQueryIterator(txctx, "busy") == nil {
+		t.Fatal("Expected iterator in use to be kept")
+	}
+}
+
+// countingIterator yields n keys, and panics if used once closed
+type countingIterator struct {
+	n      int
+	closed bool
+}
+
+func (i *countingIterator) Next() bool {
+	if i.closed {
+		panic("Next on a closed iterator")
+	}
+	i.n--
+	return i.n >= 0
+}
+
+func (i *countingIterator) GetKeyValue() (string, []byte) {
+	if i.closed {
+		panic("GetKeyValue on a closed iterator")
+	}
+	return "key", nil
+}
+
+func (i *countingIterator) Close() { i.closed = true }
+
+func TestLockedIteratorClosedConcurrently(t *testing.T) {
+	iter := &lockedIterator{iter: &countingIterator{n: 1 << 20}}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for iter.Next() {
+			iter.GetKeyValue()
+		}
+	}()
+	iter.Close()
+	<-done
+	iter.Close()
+	if iter.Next() {
+		t.Fatal("Expected a closed iterator to be exhausted")
+	}
+}
+
+func TestIteratorReaperStops(t *testing.T) {
+	chaincodeSupport := &ChaincodeSupport{stop: make(chan struct{}), handlerMap: &handlerMap{chaincodeMap: make(map[string]*Handler)}}
+	handler := newTestHandler(newMockChaincodeStream())
+	handler.ChaincodeID = &pb.ChaincodeID{Name: "reaped"}
+	chaincodeSupport.handlerMap.chaincodeMap["reaped"] = handler
+	txctx, err := handler.createTxContext("tx", nil)
+	if err != nil {
+		t.Fatalf("Error creating tx context: %s", err)
+	}
+
+	chaincodeSupport.startIteratorReaper(10 * time.Millisecond)
+	chaincodeSupport.Stop()
+	chaincodeSupport.Stop()
+	// let a tick that raced with Stop go by
+	time.Sleep(50 * time.Millisecond)
+
+	idle := &closeRecorder{}
+	handler.putRangeQueryIterator(txctx, "idle", idle)
+	handler.Lock()
+	txctx.rangeQueryIteratorUsed["idle"] = time.Now().Add(-time.Hour)
+	handler.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	handler.Lock()
+	defer handler.Unlock()
+	if idle.closed {
+		t.Fatal("Expected the reaper to be stopped")
+	}
+}
@@ -0,0 +1,352 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/hyperledger/fabric/core/ledger"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// journalCompactSize is the number of records the journal file may hold
+// before it is rewritten with the entries in flight only
+const journalCompactSize = 1024
+
+// journalWrite is a write to the ledger state recorded before it is applied
+type journalWrite struct {
+	ChaincodeID string `json:"chaincodeID"`
+	Key         string `json:"key"`
+	Value       []byte `json:"value,omitempty"`
+	IsDelete    bool   `json:"isDelete,omitempty"`
+}
+
+// journalEntry holds the writes of a transaction in flight, decided once the
+// transaction was validated
+type journalEntry struct {
+	ChainID string          `json:"chainID,omitempty"`
+	Decided bool            `json:"decided,omitempty"`
+	Writes  []*journalWrite `json:"writes"`
+}
+
+// journalRecord is a line of the journal file: writes added to the entry of a
+// transaction, or the end of the transaction
+type journalRecord struct {
+	UUID     string          `json:"uuid"`
+	ChainID  string          `json:"chainID,omitempty"`
+	Decided  bool            `json:"decided,omitempty"`
+	Writes   []*journalWrite `json:"writes,omitempty"`
+	Finished bool            `json:"finished,omitempty"`
+}
+
+// journalLedger is the part of the ledger the journal is reconciled with. It
+// is satisfied by *ledger.Ledger.
+type journalLedger interface {
+	GetBlockchainSize() uint64
+	GetTransactionByUUID(txUUID string) (*pb.Transaction, error)
+}
+
+// writeJournal records the writes of the transactions in flight by UUID
+// before they are applied to the ledger state. The writes of a transaction
+// only reach the persistent state with the block holding it, the ledger
+// commits a block atomically and drops the writes of a block it did not
+// commit. The journal does not change the state: when the peer starts, the
+// transactions left in it are reconciled with the committed blocks, so that
+// the transactions whose writes were lost with an interrupted block are
+// reported. Records are appended to a file, which is truncated whenever no
+// transaction is in flight.
+type writeJournal struct {
+	sync.Mutex
+	path    string
+	file    *os.File
+	records int
+	entries map[string]*journalEntry
+	// nested invocations write on behalf of the transaction invoking them
+	aliases map[string]string
+}
+
+// newWriteJournal returns a journal appended to the file at path, loaded from
+// it if there is one
+func newWriteJournal(path string) *writeJournal {
+	j := &writeJournal{path: path, entries: make(map[string]*journalEntry), aliases: make(map[string]string)}
+	f, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			chaincodeLogger.Warning("Error reading write journal from %s: %s", path, err)
+		}
+		return j
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<30)
+	for scanner.Scan() {
+		r := &journalRecord{}
+		if err = json.Unmarshal(scanner.Bytes(), r); err != nil {
+			// the last record may have been cut by a crash
+			chaincodeLogger.Warning("Ignoring the rest of write journal %s: %s", path, err)
+			break
+		}
+		j.apply(r)
+	}
+	if err = scanner.Err(); err != nil {
+		chaincodeLogger.Warning("Error reading write journal from %s: %s", path, err)
+	}
+	chaincodeLogger.Debug("Loaded %d journaled transactions from %s", len(j.entries), path)
+	return j
+}
+
+// apply adds record r to the entries, the lock must be held
+func (j *writeJournal) apply(r *journalRecord) {
+	j.records++
+	if r.Finished {
+		delete(j.entries, r.UUID)
+		return
+	}
+	e := j.entries[r.UUID]
+	if e == nil {
+		e = &journalEntry{ChainID: r.ChainID}
+		j.entries[r.UUID] = e
+	}
+	e.Writes = append(e.Writes, r.Writes...)
+	e.Decided = e.Decided || r.Decided
+}
+
+// root returns the transaction uuid writes on behalf of, the lock must be held
+func (j *writeJournal) root(uuid string) string {
+	for {
+		parent, ok := j.aliases[uuid]
+		if !ok {
+			return uuid
+		}
+		uuid = parent
+	}
+}
+
+// alias makes the writes of the nested invocation nestedUUID part of
+// transaction uuid. The returned func undoes it once the nested invocation
+// completed.
+func (j *writeJournal) alias(uuid string, nestedUUID string) func() {
+	j.Lock()
+	defer j.Unlock()
+	j.aliases[nestedUUID] = uuid
+	return func() {
+		j.Lock()
+		defer j.Unlock()
+		delete(j.aliases, nestedUUID)
+	}
+}
+
+// record adds writes to the entry of transaction uuid, marking it decided if
+// decided is true. The writes must not be applied if an error is returned.
+func (j *writeJournal) record(chainID string, uuid string, writes []*journalWrite, decided bool) error {
+	j.Lock()
+	defer j.Unlock()
+	r := &journalRecord{UUID: j.root(uuid), ChainID: chainID, Decided: decided, Writes: writes}
+	if err := j.append(r); err != nil {
+		return err
+	}
+	j.apply(r)
+	return nil
+}
+
+// finish drops the entry of transaction uuid once its writes were handed to
+// the ledger or discarded
+func (j *writeJournal) finish(uuid string) {
+	j.Lock()
+	defer j.Unlock()
+	if _, ok := j.entries[uuid]; !ok {
+		return
+	}
+	r := &journalRecord{UUID: uuid, Finished: true}
+	err := j.append(r)
+	j.apply(r)
+	if err == nil && (len(j.entries) == 0 || j.records > journalCompactSize) {
+		err = j.compact()
+	}
+	if err != nil {
+		chaincodeLogger.Warning("[%s]Write journal not saved: %s", shortuuid(uuid), err)
+	}
+}
+
+// recover reconciles the transactions left in the journal with the blocks
+// committed to the ledger of their chain. The writes of a transaction in a
+// committed block are in the state, those of any other transaction were
+// dropped with the block that did not commit, and the transaction has to be
+// submitted again unless consensus executes it again. The state is left as
+// the last committed block made it. The entries of a chain are dropped once
+// they were reconciled, those that could not be are kept for the next attempt.
+func (j *writeJournal) recover(getLedger func(chainID string) (journalLedger, error)) error {
+	j.Lock()
+	defer j.Unlock()
+	if len(j.entries) == 0 {
+		return nil
+	}
+	chains := make(map[string][]string)
+	for uuid, e := range j.entries {
+		chains[e.ChainID] = append(chains[e.ChainID], uuid)
+	}
+	var errs []string
+	for chainID, uuids := range chains {
+		sort.Strings(uuids)
+		ledgerObj, err := getLedger(chainID)
+		if err == nil {
+			err = j.reconcileChain(ledgerObj, uuids)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("chain %q: %s", chainID, err))
+			continue
+		}
+		for _, uuid := range uuids {
+			delete(j.entries, uuid)
+		}
+	}
+	if err := j.compact(); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("Error recovering journaled writes: %v", errs)
+	}
+	return nil
+}
+
+// reconcileChain reports what became of the journaled transactions uuids of
+// a chain, the lock must be held
+func (j *writeJournal) reconcileChain(ledgerObj journalLedger, uuids []string) error {
+	height := ledgerObj.GetBlockchainSize()
+	for _, uuid := range uuids {
+		e := j.entries[uuid]
+		_, err := ledgerObj.GetTransactionByUUID(uuid)
+		switch {
+		case err == nil:
+			chaincodeLogger.Info("[%s]Journaled transaction committed before block %d, its %d writes are in the state", shortuuid(uuid), height, len(e.Writes))
+		case err == ledger.ErrResourceNotFound:
+			chaincodeLogger.Warning("[%s]Journaled transaction not committed (validated: %t), its %d writes were dropped with its block, the state is that of block %d", shortuuid(uuid), e.Decided, len(e.Writes), height)
+		default:
+			return fmt.Errorf("Error looking up transaction %s: %s", shortuuid(uuid), err)
+		}
+	}
+	return nil
+}
+
+// append writes record r at the end of the journal file, the lock must be
+// held
+func (j *writeJournal) append(r *journalRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if j.file == nil {
+		if err = os.MkdirAll(filepath.Dir(j.path), 0755); err != nil {
+			return err
+		}
+		if j.file, err = os.OpenFile(j.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+			return err
+		}
+	}
+	_, err = j.file.Write(append(data, '\n'))
+	return err
+}
+
+// compact rewrites the journal file with the entries in flight, the lock must
+// be held
+func (j *writeJournal) compact() error {
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+	j.records = 0
+	if len(j.entries) == 0 {
+		if err := os.Remove(j.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for uuid, e := range j.entries {
+		data, err := json.Marshal(&journalRecord{UUID: uuid, ChainID: e.ChainID, Decided: e.Decided, Writes: e.Writes})
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(append(data, '\n'))
+		j.records++
+	}
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, j.path); err != nil {
+		return fmt.Errorf("Error replacing %s: %s", j.path, err)
+	}
+	return nil
+}
+
+// journalWrites records writes of transaction uuid on chain chainID in the
+// journal. It does nothing when the journal is disabled.
+func (chaincodeSupport *ChaincodeSupport) journalWrites(chainID string, uuid string, writes []*journalWrite, decided bool) error {
+	if chaincodeSupport == nil || chaincodeSupport.journal == nil || len(writes) == 0 {
+		return nil
+	}
+	if err := chaincodeSupport.journal.record(chainID, uuid, writes, decided); err != nil {
+		return fmt.Errorf("Error journaling writes of %s: %s", shortuuid(uuid), err)
+	}
+	return nil
+}
+
+// finishJournal drops the journaled writes of transaction uuid once they were
+// handed to the ledger or discarded
+func (chaincodeSupport *ChaincodeSupport) finishJournal(uuid string) {
+	if chaincodeSupport.journal != nil {
+		chaincodeSupport.journal.finish(uuid)
+	}
+}
+
+// shareJournal makes the writes of the nested invocation nestedUUID part of
+// transaction uuid in the journal. The returned func undoes it once the
+// nested invocation completed.
+func (handler *Handler) shareJournal(uuid string, nestedUUID string) func() {
+	if handler.chaincodeSupport == nil || handler.chaincodeSupport.journal == nil {
+		return func() {}
+	}
+	return handler.chaincodeSupport.journal.alias(uuid, nestedUUID)
+}
+
+// journalWritesFromProto returns the journal writes of a write set
+func journalWritesFromProto(writes []*pb.StateWrite) []*journalWrite {
+	journaled := make([]*journalWrite, 0, len(writes))
+	for _, w := range writes {
+		journaled = append(journaled, &journalWrite{ChaincodeID: w.ChaincodeID, Key: w.Key, Value: w.Value, IsDelete: w.IsDelete})
+	}
+	return journaled
+}
@@ -0,0 +1,151 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/ledger"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// blocksLedger is a journalLedger holding the transactions of committed blocks
+type blocksLedger struct {
+	committed map[string]bool
+	err       error
+}
+
+func (l *blocksLedger) GetBlockchainSize() uint64 {
+	return 2
+}
+
+func (l *blocksLedger) GetTransactionByUUID(txUUID string) (*pb.Transaction, error) {
+	if l.err != nil {
+		return nil, l.err
+	}
+	if !l.committed[txUUID] {
+		return nil, ledger.ErrResourceNotFound
+	}
+	return &pb.Transaction{Uuid: txUUID}, nil
+}
+
+func TestWriteJournalRecovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatalf("Error creating directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "chaincode", "journal.json")
+
+	cs := &ChaincodeSupport{journal: newWriteJournal(path)}
+
+	// tx1 was executing, with a nested invocation
+	if err = cs.journalWrites("", "tx1", []*journalWrite{{ChaincodeID: "cc", Key: "a", Value: []byte("90")}}, false); err != nil {
+		t.Fatalf("Error journaling: %s", err)
+	}
+	unalias := cs.journal.alias("tx1", "nested")
+	if err = cs.journalWrites("", "nested", []*journalWrite{{ChaincodeID: "cc", Key: "new", Value: []byte("x")}}, false); err != nil {
+		t.Fatalf("Error journaling: %s", err)
+	}
+	unalias()
+
+	// tx2 was validated, tx3 completed, tx4 made it into a committed block
+	if err = cs.journalWrites("", "tx2", []*journalWrite{{ChaincodeID: "cc", Key: "b", Value: []byte("7")}}, true); err != nil {
+		t.Fatalf("Error journaling: %s", err)
+	}
+	if err = cs.journalWrites("", "tx3", []*journalWrite{{ChaincodeID: "cc", Key: "c", Value: []byte("3")}}, false); err != nil {
+		t.Fatalf("Error journaling: %s", err)
+	}
+	cs.finishJournal("tx3")
+	if err = cs.journalWrites("", "tx4", []*journalWrite{{ChaincodeID: "cc", Key: "d", IsDelete: true}}, true); err != nil {
+		t.Fatalf("Error journaling: %s", err)
+	}
+
+	// the journal is appended to, one record per change
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Error reading journal: %s", err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 6 {
+		t.Fatalf("Expected 6 records in the journal, got %d", lines)
+	}
+
+	restarted := newWriteJournal(path)
+	if len(restarted.entries) != 3 || len(restarted.entries["tx1"].Writes) != 2 || !restarted.entries["tx2"].Decided {
+		t.Fatalf("Expected tx1, tx2 and tx4 to survive a restart, got %v", restarted.entries)
+	}
+
+	// a journal that cannot be reconciled is kept for the next attempt
+	blocks := &blocksLedger{committed: map[string]bool{"tx4": true}, err: fmt.Errorf("ledger unavailable")}
+	if err = restarted.recover(func(chainID string) (journalLedger, error) { return blocks, nil }); err == nil {
+		t.Fatal("Expected the recovery to fail while the ledger is unavailable")
+	}
+	if entries := newWriteJournal(path).entries; len(entries) != 3 {
+		t.Fatalf("Expected the journal to be kept when the recovery failed, got %v", entries)
+	}
+
+	blocks.err = nil
+	if err = restarted.recover(func(chainID string) (journalLedger, error) { return blocks, nil }); err != nil {
+		t.Fatalf("Error recovering: %s", err)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Expected the journal file to be removed after recovery, got %v", err)
+	}
+	if entries := newWriteJournal(path).entries; len(entries) != 0 {
+		t.Fatalf("Expected the journal to be empty after recovery, got %v", entries)
+	}
+}
+
+func TestWriteJournalTruncatedRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatalf("Error creating directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal.json")
+
+	j := newWriteJournal(path)
+	if err = j.record("", "tx1", []*journalWrite{{ChaincodeID: "cc", Key: "a", Value: []byte("1")}}, false); err != nil {
+		t.Fatalf("Error journaling: %s", err)
+	}
+	// a crash cut the last record short
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("Error opening journal: %s", err)
+	}
+	f.WriteString(`{"uuid":"tx2","writes":[{"chaincodeID":"cc"`)
+	f.Close()
+
+	restarted := newWriteJournal(path)
+	if len(restarted.entries) != 1 || restarted.entries["tx1"] == nil {
+		t.Fatalf("Expected the complete records to be loaded, got %v", restarted.entries)
+	}
+
+	// finishing the last transaction in flight empties the file
+	j.finish("tx1")
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("Expected the journal file to be removed once no transaction is in flight, got %v", err)
+	}
+}
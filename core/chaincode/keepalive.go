@@ -0,0 +1,114 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/net/context"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// keepaliveProbe tells when to send KEEPALIVE to a chaincode that has been
+// idle for an interval and when the chaincode, having missed missedLimit of
+// them in a row, is to be considered hung. It is only used by the goroutine
+// processing the stream of the chaincode.
+type keepaliveProbe struct {
+	interval     time.Duration
+	missedLimit  int
+	lastReceived time.Time
+	missed       int
+}
+
+func newKeepaliveProbe(interval time.Duration, missedLimit int) *keepaliveProbe {
+	return &keepaliveProbe{interval: interval, missedLimit: missedLimit, lastReceived: time.Now()}
+}
+
+// received records that the chaincode is alive
+func (p *keepaliveProbe) received(now time.Time) {
+	p.lastReceived = now
+	p.missed = 0
+}
+
+// tick returns whether a KEEPALIVE is due and whether the chaincode is hung
+func (p *keepaliveProbe) tick(now time.Time) (send bool, hung bool) {
+	if now.Sub(p.lastReceived) < p.interval {
+		return false, false
+	}
+	if p.missed >= p.missedLimit {
+		return false, true
+	}
+	p.missed++
+	return true, false
+}
+
+// keepaliveTicker returns the ticks on which the stream of the chaincode is
+// probed, nil if heartbeats are disabled by chaincode.keepalive.interval
+func (handler *Handler) keepaliveTicker() (*time.Ticker, *keepaliveProbe) {
+	if handler.chaincodeSupport == nil || handler.chaincodeSupport.keepaliveInterval <= 0 {
+		return nil, nil
+	}
+	interval := handler.chaincodeSupport.keepaliveInterval
+	return time.NewTicker(interval), newKeepaliveProbe(interval, handler.chaincodeSupport.keepaliveMissedLimit)
+}
+
+// probe sends KEEPALIVE to an idle chaincode and returns an error ending the
+// stream once the chaincode is hung. Shims that predate ChaincodeProtocolV7
+// do not answer KEEPALIVE and are not probed.
+func (handler *Handler) probe(p *keepaliveProbe) error {
+	if handler.protocolVersion < pb.ChaincodeProtocolV7 {
+		return nil
+	}
+	send, hung := p.tick(time.Now())
+	if hung {
+		return handler.endHungChaincode(p.missed)
+	}
+	if send {
+		handler.log(nil).Debug("Sending %s to chaincode %s, idle for %s", pb.ChaincodeMessage_KEEPALIVE, handler.traceChaincodeName(), time.Since(p.lastReceived))
+		// outside of the flow control window, which a hung chaincode never
+		// replenishes
+		return handler.send(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_KEEPALIVE})
+	}
+	return nil
+}
+
+// endHungChaincode moves the handler of a chaincode that missed missed
+// heartbeats to the end state, which deregisters it, and stops its container
+// so that the next transaction launches the chaincode again
+func (handler *Handler) endHungChaincode(missed int) error {
+	err := fmt.Errorf("chaincode %s missed %d heartbeats", handler.traceChaincodeName(), missed)
+	handler.log(nil).Error("Ending stream: %s", err)
+	msg := &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_KEEPALIVE}
+	src := handler.FSM.Current()
+	if handler.FSM.Can(msg.Type.String()) {
+		handler.recordTransition(msg, src, filterError(handler.FSM.Event(msg.Type.String(), msg)))
+	}
+	if handler.ChaincodeID != nil && !handler.chaincodeSupport.userRunsCC {
+		// the stream ends first, StopChaincode does not wait for it
+		go func(cID *pb.ChaincodeID) {
+			if stopErr := handler.chaincodeSupport.StopChaincode(context.Background(), cID); stopErr != nil {
+				handler.log(msg).Error("Error stopping hung chaincode %s: %s", cID.Name, stopErr)
+			}
+		}(handler.ChaincodeID)
+	}
+	return err
+}
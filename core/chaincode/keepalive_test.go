@@ -0,0 +1,97 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+	"github.com/looplab/fsm"
+)
+
+func TestKeepaliveProbe(t *testing.T) {
+	now := time.Now()
+	p := &keepaliveProbe{interval: time.Second, missedLimit: 2, lastReceived: now}
+	if send, hung := p.tick(now.Add(time.Millisecond)); send || hung {
+		t.Fatal("Expected a chaincode heard from recently not to be probed")
+	}
+	for i := 0; i < 2; i++ {
+		if send, hung := p.tick(now.Add(2 * time.Second)); !send || hung {
+			t.Fatalf("Expected KEEPALIVE %d to be sent", i)
+		}
+	}
+	if _, hung := p.tick(now.Add(3 * time.Second)); !hung {
+		t.Fatal("Expected the chaincode to be hung after missing 2 heartbeats")
+	}
+	p.received(now.Add(3 * time.Second))
+	if _, hung := p.tick(now.Add(5 * time.Second)); hung {
+		t.Fatal("Expected an answer to reset the missed heartbeats")
+	}
+}
+
+func TestHungChaincodeIsDeregistered(t *testing.T) {
+	chaincodeSupport := newDevModeTestSupport(true)
+	chaincodeSupport.keepaliveInterval = 20 * time.Millisecond
+	chaincodeSupport.keepaliveMissedLimit = 2
+	stream := newMockChaincodeStream()
+	handler := newChaincodeSupportHandler(chaincodeSupport, stream)
+	handler.txCtxs = make(map[string]*transactionContext)
+	handler.isTransaction = make(map[string]bool)
+	handler.ChaincodeID = &pb.ChaincodeID{Name: "hungcc"}
+	handler.protocolVersion = pb.ChaincodeProtocolV7
+	handler.FSM = fsm.NewFSM(readystate, legacyFSMTable, handlerCallbacks(handler))
+	if err := chaincodeSupport.registerHandler(handler); err != nil {
+		t.Fatalf("Error registering chaincode: %s", err)
+	}
+	txctx, _ := handler.createTxContext("1", nil)
+	done := runProcessStream(handler)
+
+	// An answer keeps the chaincode alive
+	if msg := <-stream.sendCh; msg.Type != pb.ChaincodeMessage_KEEPALIVE {
+		t.Fatalf("Expected %s, got %s", pb.ChaincodeMessage_KEEPALIVE, msg.Type)
+	}
+	stream.recvCh <- recvResult{msg: &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_KEEPALIVE}}
+
+	// Unanswered ones end it
+	for i := 0; i < 2; i++ {
+		if msg := <-stream.sendCh; msg.Type != pb.ChaincodeMessage_KEEPALIVE {
+			t.Fatalf("Expected %s, got %s", pb.ChaincodeMessage_KEEPALIVE, msg.Type)
+		}
+	}
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "missed 2 heartbeats") {
+			t.Fatalf("Expected the stream to end for missed heartbeats, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the stream of the hung chaincode to end")
+	}
+	if state := handler.FSM.Current(); state != endstate {
+		t.Fatalf("Expected the handler to be in %s, got %s", endstate, state)
+	}
+	if _, ok := chaincodeSupport.chaincodeHasBeenLaunched("hungcc"); ok {
+		t.Fatal("Expected the hung chaincode to be deregistered")
+	}
+	if resp := <-txctx.responseNotifier; resp.Type != pb.ChaincodeMessage_ERROR {
+		t.Fatalf("Expected the pending transaction to fail, got %s", resp.Type)
+	}
+}
@@ -0,0 +1,108 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// auditLeaks reports the UUID entries, notifiers and iterators of this handler
+// that are older than threshold. If expire is set they are also removed; waiters
+// on expired notifiers receive an ERROR.
+func (handler *Handler) auditLeaks(threshold time.Duration, expire bool) []*pb.LeakedResource {
+	handler.Lock()
+	defer handler.Unlock()
+
+	var leaks []*pb.LeakedResource
+	now := time.Now()
+	name := ""
+	if handler.ChaincodeID != nil {
+		name = handler.ChaincodeID.Name
+	}
+
+	for uuid, created := range handler.uuidMap {
+		if age := now.Sub(created); age > threshold {
+			leaks = append(leaks, &pb.LeakedResource{Kind: pb.LeakedResource_UUID, ChaincodeID: name, Uuid: uuid, AgeSeconds: int64(age.Seconds()), Expired: expire})
+			if expire {
+				handler.releaseUUIDEntry(uuid)
+			}
+		}
+	}
+
+	for uuid, tctx := range handler.txCtxs {
+		for iterID, created := range tctx.rangeQueryIteratorCreated {
+			if age := now.Sub(created); age > threshold {
+				leaks = append(leaks, &pb.LeakedResource{Kind: pb.LeakedResource_ITERATOR, ChaincodeID: name, Uuid: uuid, IteratorID: iterID, AgeSeconds: int64(age.Seconds()), Expired: expire})
+				if expire {
+					if iter := tctx.rangeQueryIteratorMap[iterID]; iter != nil {
+						iter.Close()
+					}
+					delete(tctx.rangeQueryIteratorMap, iterID)
+					delete(tctx.rangeQueryIteratorCreated, iterID)
+					delete(tctx.rangeQueryIteratorUsed, iterID)
+				}
+			}
+		}
+
+		if age := now.Sub(tctx.created); age > threshold {
+			leaks = append(leaks, &pb.LeakedResource{Kind: pb.LeakedResource_NOTIFIER, ChaincodeID: name, Uuid: uuid, AgeSeconds: int64(age.Seconds()), Expired: expire})
+			if expire {
+				payload := []byte(fmt.Sprintf("transaction expired after %s", age))
+				tctx.respond(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: uuid})
+				delete(handler.txCtxs, uuid)
+			}
+		}
+	}
+
+	return leaks
+}
+
+// AuditLeaks reports UUID entries, notifiers and iterators of all chaincodes that
+// are older than threshold and are therefore likely to have leaked. If expire is
+// set the reported resources are released.
+func (chaincodeSupport *ChaincodeSupport) AuditLeaks(threshold time.Duration, expire bool) []*pb.LeakedResource {
+	chaincodeSupport.handlerMap.RLock()
+	handlers := make([]*Handler, 0, len(chaincodeSupport.handlerMap.chaincodeMap))
+	for _, handler := range chaincodeSupport.handlerMap.chaincodeMap {
+		handlers = append(handlers, handler)
+	}
+	chaincodeSupport.handlerMap.RUnlock()
+
+	var leaks []*pb.LeakedResource
+	for _, handler := range handlers {
+		leaks = append(leaks, handler.auditLeaks(threshold, expire)...)
+	}
+	return leaks
+}
+
+// startLeakAuditor periodically logs (and optionally expires) leaked resources
+func (chaincodeSupport *ChaincodeSupport) startLeakAuditor(interval time.Duration, threshold time.Duration, expire bool) {
+	chaincodeLog.Info("Starting chaincode leak auditor, interval %s, threshold %s, expire %t", interval, threshold, expire)
+	go func() {
+		for range time.Tick(interval) {
+			for _, leak := range chaincodeSupport.AuditLeaks(threshold, expire) {
+				chaincodeLog.Warning("Possible leak: %s", leak)
+			}
+		}
+	}()
+}
@@ -0,0 +1,67 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"testing"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestAuditLeaks(t *testing.T) {
+	handler := newTestHandler(newMockChaincodeStream())
+	handler.ChaincodeID = &pb.ChaincodeID{Name: "leaky"}
+
+	txctx, err := handler.createTxContext("old-tx", nil)
+	if err != nil {
+		t.Fatalf("Error creating tx context: %s", err)
+	}
+	txctx.created = time.Now().Add(-time.Hour)
+	handler.createUUIDEntry("old-tx")
+	handler.uuidMap["old-tx"] = time.Now().Add(-time.Hour)
+
+	if _, err = handler.createTxContext("new-tx", nil); err != nil {
+		t.Fatalf("Error creating tx context: %s", err)
+	}
+
+	leaks := handler.auditLeaks(time.Minute, false)
+	if len(leaks) != 2 {
+		t.Fatalf("Expected 2 leaks, got %d: %v", len(leaks), leaks)
+	}
+	if handler.getTxContext("old-tx") == nil {
+		t.Fatalf("Expected leaked context to be kept when not expiring")
+	}
+
+	leaks = handler.auditLeaks(time.Minute, true)
+	if len(leaks) != 2 {
+		t.Fatalf("Expected 2 leaks, got %d: %v", len(leaks), leaks)
+	}
+	if handler.getTxContext("old-tx") != nil || handler.getTxContext("new-tx") == nil {
+		t.Fatalf("Expected only the leaked context to be expired")
+	}
+	if !handler.createUUIDEntry("old-tx") {
+		t.Fatalf("Expected leaked UUID entry to be expired")
+	}
+	msg := waitForNotification(t, txctx.responseNotifier)
+	if msg.Type != pb.ChaincodeMessage_ERROR {
+		t.Fatalf("Expected ERROR for expired notifier, got %s", msg.Type)
+	}
+}
@@ -0,0 +1,73 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/opevents"
+	pb "github.com/hyperledger/fabric/protos"
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+)
+
+func TestLifecycleListeners(t *testing.T) {
+	viper.Set("peer.fileSystemPath", "/var/hyperledger/test/tmpdb")
+	getPeerEndpoint := func() (*pb.PeerEndpoint, error) {
+		return &pb.PeerEndpoint{ID: &pb.PeerID{Name: "testpeer"}, Address: "0.0.0.0:40303"}, nil
+	}
+	NewChaincodeSupport(DefaultChain, getPeerEndpoint, false, 10*time.Second, nil)
+	// System chaincodes stay registered, each run of the test registers its own
+	name := fmt.Sprintf("lcsyscc%d", time.Now().UnixNano())
+
+	var lock sync.Mutex
+	var events []string
+	GetChain(DefaultChain).RegisterLifecycleListener(func(chaincode string, event opevents.HandlerEvent, err error) {
+		if chaincode != name {
+			return
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		events = append(events, event.String())
+	})
+
+	if err := RegisterSystemChaincode(&SystemChaincode{Name: name, Chaincode: &kvChaincode{}}); err != nil {
+		t.Fatalf("Error registering system chaincode: %s", err)
+	}
+	ctxt := context.Background()
+	cID := &pb.ChaincodeID{Name: name}
+	spec := &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_GOLANG, ChaincodeID: cID, CtorMsg: &pb.ChaincodeInput{Function: "put", Args: []string{"a", "1"}}}
+	if _, _, err := invoke(ctxt, spec, pb.Transaction_CHAINCODE_INVOKE); err != nil {
+		t.Fatalf("Error invoking chaincode: %s", err)
+	}
+	if err := GetChain(DefaultChain).StopChaincode(ctxt, cID); err != nil {
+		t.Fatalf("Error stopping chaincode: %s", err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if got := strings.Join(events, ","); got != "registered,ready,deregistered" {
+		t.Fatalf("Expected the chaincode to be registered, ready and deregistered, got %s", got)
+	}
+}
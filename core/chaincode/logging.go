@@ -0,0 +1,157 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/op/go-logging"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// LogModule is the log module of the chaincode package. The lines a handler
+// logs go to the module of its chaincode, LogModule.<name>.
+const LogModule = "chaincode"
+
+// chaincodeLogModules holds the loggers of the chaincode modules. A module
+// follows the level of LogModule until its own level is set, the levels are
+// kept by the leveled backend of config.SetLogBackend.
+var chaincodeLogModules = struct {
+	sync.Mutex
+	loggers map[string]*logging.Logger
+}{loggers: make(map[string]*logging.Logger)}
+
+// ChaincodeLogModule returns the log module of the handler of chaincode name
+func ChaincodeLogModule(name string) string {
+	return LogModule + "." + name
+}
+
+// chaincodeModuleLogger returns the logger of module
+func chaincodeModuleLogger(module string) *logging.Logger {
+	chaincodeLogModules.Lock()
+	defer chaincodeLogModules.Unlock()
+	if logger := chaincodeLogModules.loggers[module]; logger != nil {
+		return logger
+	}
+	logger := logging.MustGetLogger(module)
+	// lines are logged through handlerLogger, the caller is one frame up
+	logger.ExtraCalldepth = 1
+	chaincodeLogModules.loggers[module] = logger
+	return logger
+}
+
+// IsChaincodeLogModule returns true for LogModule and the modules of the
+// chaincodes
+func IsChaincodeLogModule(module string) bool {
+	return module == LogModule || strings.HasPrefix(module, LogModule+".")
+}
+
+// GetLogLevel returns the level of LogModule or of the module of a chaincode
+func GetLogLevel(module string) logging.Level {
+	return logging.GetLevel(module)
+}
+
+// SetLogLevel changes the level of LogModule or of the module of a chaincode
+// at runtime. The chaincode modules whose level was not set follow LogModule.
+func SetLogLevel(module string, level logging.Level) error {
+	if !IsChaincodeLogModule(module) {
+		return fmt.Errorf("%s is not a chaincode log module", module)
+	}
+	logging.SetLevel(level, module)
+	return nil
+}
+
+// handlerLogger logs the lines of a handler to the module of its chaincode,
+// with the uuid and event of the message being handled and the state of the
+// handler as fields
+type handlerLogger struct {
+	handler *Handler
+	uuid    string
+	event   string
+}
+
+// log returns the logger of the lines about msg, which is nil for lines about
+// no message in particular
+func (handler *Handler) log(msg *pb.ChaincodeMessage) *handlerLogger {
+	if msg == nil {
+		return &handlerLogger{handler: handler}
+	}
+	return &handlerLogger{handler: handler, uuid: msg.Uuid, event: msg.Type.String()}
+}
+
+// logTx returns the logger of the lines about transaction uuid
+func (handler *Handler) logTx(uuid string) *handlerLogger {
+	return &handlerLogger{handler: handler, uuid: uuid}
+}
+
+// logger returns the logger of the module of the chaincode, LogModule until
+// the chaincode registered
+func (handler *Handler) logger() *logging.Logger {
+	if handler.ChaincodeID == nil || handler.ChaincodeID.Name == "" {
+		return chaincodeModuleLogger(LogModule)
+	}
+	return chaincodeModuleLogger(ChaincodeLogModule(handler.ChaincodeID.Name))
+}
+
+// fields formats the fields prefixed to the lines, escaped for use in a format
+func (l *handlerLogger) fields() string {
+	var fields []string
+	if l.uuid != "" {
+		fields = append(fields, "uuid="+shortuuid(l.uuid))
+	}
+	if l.handler.FSM != nil {
+		fields = append(fields, "state="+l.handler.FSM.Current())
+	}
+	if l.event != "" {
+		fields = append(fields, "event="+l.event)
+	}
+	return "[" + strings.Replace(strings.Join(fields, " "), "%", "%%", -1) + "] "
+}
+
+func (l *handlerLogger) IsEnabledFor(level logging.Level) bool {
+	return l.handler.logger().IsEnabledFor(level)
+}
+
+func (l *handlerLogger) Debug(format string, args ...interface{}) {
+	if logger := l.handler.logger(); logger.IsEnabledFor(logging.DEBUG) {
+		logger.Debug(l.fields()+format, args...)
+	}
+}
+
+func (l *handlerLogger) Info(format string, args ...interface{}) {
+	if logger := l.handler.logger(); logger.IsEnabledFor(logging.INFO) {
+		logger.Info(l.fields()+format, args...)
+	}
+}
+
+func (l *handlerLogger) Warning(format string, args ...interface{}) {
+	if logger := l.handler.logger(); logger.IsEnabledFor(logging.WARNING) {
+		logger.Warning(l.fields()+format, args...)
+	}
+}
+
+func (l *handlerLogger) Error(format string, args ...interface{}) {
+	if logger := l.handler.logger(); logger.IsEnabledFor(logging.ERROR) {
+		logger.Error(l.fields()+format, args...)
+	}
+}
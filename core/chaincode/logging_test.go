@@ -0,0 +1,86 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/op/go-logging"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestChaincodeLogModulesFollowChaincodeLevel(t *testing.T) {
+	saved := logging.GetLevel(LogModule)
+	defer SetLogLevel(LogModule, saved)
+
+	if err := SetLogLevel(LogModule, logging.WARNING); err != nil {
+		t.Fatalf("Error setting the level of %s: %s", LogModule, err)
+	}
+	following := ChaincodeLogModule("logtest-following")
+	pinned := ChaincodeLogModule("logtest-pinned")
+	chaincodeModuleLogger(following)
+	if err := SetLogLevel(pinned, logging.DEBUG); err != nil {
+		t.Fatalf("Error setting the level of %s: %s", pinned, err)
+	}
+	chaincodeModuleLogger(pinned)
+	if level := GetLogLevel(following); level != logging.WARNING {
+		t.Fatalf("Expected %s to start at WARNING, got %s", following, level)
+	}
+
+	if err := SetLogLevel(LogModule, logging.INFO); err != nil {
+		t.Fatalf("Error setting the level of %s: %s", LogModule, err)
+	}
+	if level := GetLogLevel(following); level != logging.INFO {
+		t.Fatalf("Expected %s to follow %s to INFO, got %s", following, LogModule, level)
+	}
+	if level := GetLogLevel(pinned); level != logging.DEBUG {
+		t.Fatalf("Expected %s to keep its level DEBUG, got %s", pinned, level)
+	}
+	if level := GetLogLevel(ChaincodeLogModule("logtest-unknown")); level != logging.INFO {
+		t.Fatalf("Expected a module not used yet to report the level of %s, got %s", LogModule, level)
+	}
+
+	if err := SetLogLevel("peer", logging.DEBUG); err == nil {
+		t.Fatal("Expected setting the level of a module of another package to fail")
+	}
+}
+
+func TestHandlerLogFields(t *testing.T) {
+	stream := newMockChaincodeStream()
+	handler := newTestHandler(stream)
+	defer handler.stopWriter()
+	handler.ChaincodeID = &pb.ChaincodeID{Name: "logtest"}
+
+	if module := handler.logger().Module; module != "chaincode.logtest" {
+		t.Fatalf("Expected the lines to go to chaincode.logtest, got %s", module)
+	}
+	fields := handler.log(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_GET_STATE, Uuid: "0123456789%"}).fields()
+	if fields != "[uuid=01234567 state="+createdstate+" event=GET_STATE] " {
+		t.Fatalf("Unexpected fields %q", fields)
+	}
+	if fields = handler.logTx("tx%1").fields(); !strings.HasPrefix(fields, "[uuid=tx%%1 ") {
+		t.Fatalf("Expected %% to be escaped in %q", fields)
+	}
+	if fields = handler.log(nil).fields(); fields != "[state="+createdstate+"] " {
+		t.Fatalf("Unexpected fields %q", fields)
+	}
+}
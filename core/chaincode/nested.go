@@ -0,0 +1,84 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strconv"
+)
+
+// A transaction or query that invokes or queries another chaincode gets a
+// new uuid for the nested invocation, so that a chaincode can call itself
+// and every peer executing the transaction arrives at the same uuids. The
+// n-th nested invocation of uuid, counting from 1 in the order the chaincode
+// makes them, is
+//
+//	SHA-256(uuid + ":" + decimal(n))
+//
+// truncated to 16 bytes and formatted as an RFC 4122 UUID of version 8. The
+// nested invocations of a nested invocation are derived from its own uuid.
+func deriveNestedUUID(uuid string, n uint64) string {
+	sum := sha256.Sum256([]byte(uuid + ":" + strconv.FormatUint(n, 10)))
+	id := sum[:16]
+
+	// variant bits; see section 4.1.1
+	id[8] = id[8]&^0xc0 | 0x80
+
+	// version 8 (custom)
+	id[6] = id[6]&^0xf0 | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:])
+}
+
+// nextNestedUUID counts a nested invocation made by transaction or query uuid
+// and returns the uuid for it
+func (handler *Handler) nextNestedUUID(uuid string) (string, error) {
+	handler.Lock()
+	defer handler.Unlock()
+	txContext := handler.txCtxs[uuid]
+	if txContext == nil {
+		return "", fmt.Errorf("Uuid:%s has no transaction context", uuid)
+	}
+	txContext.nestedInvocations++
+	return deriveNestedUUID(uuid, txContext.nestedInvocations), nil
+}
+
+// shareReadWriteSet makes the read-write set of uuid, if it has one, also the
+// read-write set of its nested invocation nestedUUID, so that the state the
+// nested chaincode reads and writes is part of the invoking transaction. The
+// returned func undoes it once the nested invocation completed.
+func (handler *Handler) shareReadWriteSet(uuid string, nestedUUID string) func() {
+	chaincodeSupport := handler.chaincodeSupport
+	if chaincodeSupport == nil {
+		return func() {}
+	}
+	if sims := chaincodeSupport.simulations; sims != nil {
+		if rw := sims.lookup(uuid); rw != nil {
+			sims.add(nestedUUID, rw)
+			return func() { sims.remove(nestedUUID) }
+		}
+	}
+	if chaincodeSupport.rwsets == nil {
+		return func() {}
+	}
+	chaincodeSupport.rwsets.add(nestedUUID, chaincodeSupport.rwsets.get(uuid))
+	return func() { chaincodeSupport.rwsets.remove(nestedUUID) }
+}
@@ -0,0 +1,145 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos"
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+)
+
+func TestDeriveNestedUUID(t *testing.T) {
+	first := deriveNestedUUID("uuid", 1)
+	if first != deriveNestedUUID("uuid", 1) {
+		t.Fatal("Expected the derivation to be deterministic")
+	}
+	if first == deriveNestedUUID("uuid", 2) || first == deriveNestedUUID("other", 1) {
+		t.Fatal("Expected different invocations to get different uuids")
+	}
+	if len(first) != 36 || first[14] != '8' {
+		t.Fatalf("Expected a version 8 UUID, got %s", first)
+	}
+}
+
+// uuidRecorder remembers the uuids of the invocations of uuidChaincode
+type uuidRecorder struct {
+	sync.Mutex
+	uuids   []string
+	parents []string
+}
+
+// uuidChaincode records the uuid it is invoked with and writes its argument
+type uuidChaincode struct {
+	recorder *uuidRecorder
+}
+
+func (cc *uuidChaincode) Init(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	return nil, nil
+}
+
+func (cc *uuidChaincode) Invoke(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	cc.recorder.Lock()
+	cc.recorder.uuids = append(cc.recorder.uuids, stub.UUID)
+	cc.recorder.parents = append(cc.recorder.parents, stub.ParentUUID)
+	cc.recorder.Unlock()
+	return nil, stub.PutState(args[0], []byte(stub.UUID))
+}
+
+func (cc *uuidChaincode) Query(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	return stub.GetState(args[0])
+}
+
+// fanOutChaincode invokes the chaincode named by its first argument once for
+// every other argument
+type fanOutChaincode struct {
+}
+
+func (cc *fanOutChaincode) Init(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	return nil, nil
+}
+
+func (cc *fanOutChaincode) Invoke(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	for _, key := range args[1:] {
+		if _, err := stub.InvokeChaincode(args[0], "put", []string{key}); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+func (cc *fanOutChaincode) Query(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	return nil, nil
+}
+
+func TestNestedInvocationUUIDs(t *testing.T) {
+	viper.Set("peer.fileSystemPath", "/var/hyperledger/test/tmpdb")
+	getPeerEndpoint := func() (*pb.PeerEndpoint, error) {
+		return &pb.PeerEndpoint{ID: &pb.PeerID{Name: "testpeer"}, Address: "0.0.0.0:40303"}, nil
+	}
+	NewChaincodeSupport(DefaultChain, getPeerEndpoint, false, 10*time.Second, nil)
+
+	recorder := &uuidRecorder{}
+	for _, syscc := range []*SystemChaincode{
+		{Name: "nfanoutsyscc", Chaincode: &fanOutChaincode{}},
+		{Name: "nuuidsyscc", Chaincode: &uuidChaincode{recorder: recorder}},
+	} {
+		if err := RegisterSystemChaincode(syscc); err != nil {
+			t.Fatalf("Error registering system chaincode: %s", err)
+		}
+	}
+	ctxt := context.Background()
+	fanOutID := &pb.ChaincodeID{Name: "nfanoutsyscc"}
+	uuidID := &pb.ChaincodeID{Name: "nuuidsyscc"}
+	defer GetChain(DefaultChain).StopChaincode(ctxt, fanOutID)
+	defer GetChain(DefaultChain).StopChaincode(ctxt, uuidID)
+
+	spec := &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_GOLANG, ChaincodeID: fanOutID, CtorMsg: &pb.ChaincodeInput{Function: "invoke", Args: []string{"nuuidsyscc", "a", "b"}}}
+	uuid, _, err := invoke(ctxt, spec, pb.Transaction_CHAINCODE_INVOKE)
+	if err != nil {
+		t.Fatalf("Error invoking chaincode: %s", err)
+	}
+
+	expected := []string{deriveNestedUUID(uuid, 1), deriveNestedUUID(uuid, 2)}
+	if len(recorder.uuids) != 2 || recorder.uuids[0] != expected[0] || recorder.uuids[1] != expected[1] {
+		t.Fatalf("Expected nested uuids %v, got %v", expected, recorder.uuids)
+	}
+	for _, parent := range recorder.parents {
+		if parent != uuid {
+			t.Fatalf("Expected parent uuid %s, got %s", uuid, parent)
+		}
+	}
+
+	// the writes of the nested invocations are part of the transaction
+	for i, key := range []string{"a", "b"} {
+		spec = &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_GOLANG, ChaincodeID: uuidID, CtorMsg: &pb.ChaincodeInput{Function: "get", Args: []string{key}}}
+		_, value, err := invoke(ctxt, spec, pb.Transaction_CHAINCODE_QUERY)
+		if err != nil {
+			t.Fatalf("Error querying chaincode: %s", err)
+		}
+		if string(value) != expected[i] {
+			t.Fatalf("Expected %s written by the nested invocation, got %q", expected[i], value)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos"
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+)
+
+// proxyChaincode queries the chaincode named by its first argument
+type proxyChaincode struct {
+}
+
+func (cc *proxyChaincode) Init(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	return nil, nil
+}
+
+// Invoke copies the value of a key of another chaincode into its own state
+func (cc *proxyChaincode) Invoke(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	value, err := stub.QueryChaincode(args[0], "get", []string{args[1]})
+	if err != nil {
+		return nil, err
+	}
+	return nil, stub.PutState(args[1], value)
+}
+
+func (cc *proxyChaincode) Query(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	if function == "local" {
+		return stub.GetState(args[0])
+	}
+	return stub.QueryChaincode(args[0], function, args[1:])
+}
+
+// writingQueryChaincode attempts to write to the state when queried
+type writingQueryChaincode struct {
+}
+
+func (cc *writingQueryChaincode) Init(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	return nil, nil
+}
+
+func (cc *writingQueryChaincode) Invoke(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	return nil, nil
+}
+
+func (cc *writingQueryChaincode) Query(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	return nil, stub.PutState("key", []byte("value"))
+}
+
+func TestQueryChaincodeIsReadOnly(t *testing.T) {
+	viper.Set("peer.fileSystemPath", "/var/hyperledger/test/tmpdb")
+	getPeerEndpoint := func() (*pb.PeerEndpoint, error) {
+		return &pb.PeerEndpoint{ID: &pb.PeerID{Name: "testpeer"}, Address: "0.0.0.0:40303"}, nil
+	}
+	NewChaincodeSupport(DefaultChain, getPeerEndpoint, false, 10*time.Second, nil)
+
+	for _, syscc := range []*SystemChaincode{
+		{Name: "qproxysyscc", Chaincode: &proxyChaincode{}},
+		{Name: "qkvsyscc", Chaincode: &kvChaincode{}},
+		{Name: "qwritersyscc", Chaincode: &writingQueryChaincode{}},
+	} {
+		if err := RegisterSystemChaincode(syscc); err != nil {
+			t.Fatalf("Error registering system chaincode: %s", err)
+		}
+	}
+	ctxt := context.Background()
+	proxyID := &pb.ChaincodeID{Name: "qproxysyscc"}
+	kvID := &pb.ChaincodeID{Name: "qkvsyscc"}
+	defer GetChain(DefaultChain).StopChaincode(ctxt, proxyID)
+	defer GetChain(DefaultChain).StopChaincode(ctxt, kvID)
+	defer GetChain(DefaultChain).StopChaincode(ctxt, &pb.ChaincodeID{Name: "qwritersyscc"})
+
+	spec := &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_GOLANG, ChaincodeID: kvID, CtorMsg: &pb.ChaincodeInput{Function: "put", Args: []string{"a", "100"}}}
+	if _, _, err := invoke(ctxt, spec, pb.Transaction_CHAINCODE_INVOKE); err != nil {
+		t.Fatalf("Error invoking chaincode: %s", err)
+	}
+
+	// a query querying another chaincode
+	spec = &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_GOLANG, ChaincodeID: proxyID, CtorMsg: &pb.ChaincodeInput{Function: "get", Args: []string{"qkvsyscc", "a"}}}
+	_, value, err := invoke(ctxt, spec, pb.Transaction_CHAINCODE_QUERY)
+	if err != nil {
+		t.Fatalf("Error querying chaincode: %s", err)
+	}
+	if string(value) != "100" {
+		t.Fatalf("Expected 100, got %q", value)
+	}
+
+	// a transaction querying another chaincode, the caller remains free to write
+	spec = &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_GOLANG, ChaincodeID: proxyID, CtorMsg: &pb.ChaincodeInput{Function: "copy", Args: []string{"qkvsyscc", "a"}}}
+	if _, _, err := invoke(ctxt, spec, pb.Transaction_CHAINCODE_INVOKE); err != nil {
+		t.Fatalf("Error invoking chaincode: %s", err)
+	}
+	spec = &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_GOLANG, ChaincodeID: proxyID, CtorMsg: &pb.ChaincodeInput{Function: "local", Args: []string{"a"}}}
+	if _, value, err = invoke(ctxt, spec, pb.Transaction_CHAINCODE_QUERY); err != nil || string(value) != "100" {
+		t.Fatalf("Expected 100 copied by the transaction, got %q (%v)", value, err)
+	}
+
+	// the queried chaincode cannot write, even when called from a transaction
+	spec = &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_GOLANG, ChaincodeID: proxyID, CtorMsg: &pb.ChaincodeInput{Function: "copy", Args: []string{"qwritersyscc", "a"}}}
+	_, _, err = invoke(ctxt, spec, pb.Transaction_CHAINCODE_INVOKE)
+	if err == nil || !strings.Contains(err.Error(), "query context") {
+		t.Fatalf("Expected the queried chaincode to be denied writes, got %v", err)
+	}
+}
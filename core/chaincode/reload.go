@@ -0,0 +1,91 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric/core/config"
+)
+
+// execTimeoutDefault is used when chaincode.exec.timeout is not set
+const execTimeoutDefault = 30 * time.Second
+
+func init() {
+	config.OnChange("chaincode.exec.timeout", reloadExecTimeout)
+	config.OnChange("chaincode.state.maxConcurrent", reloadStateLimits)
+	config.OnChange("chaincode.state.ratePerSec", reloadStateLimits)
+}
+
+// getExecTimeout returns the configured time a transaction or query may take
+// to execute in a chaincode
+func getExecTimeout() time.Duration {
+	if t := config.GetInt("chaincode.exec.timeout"); t > 0 {
+		return time.Duration(t) * time.Millisecond
+	}
+	return execTimeoutDefault
+}
+
+// getExecTimeout returns the time a transaction or query may take to execute
+func (chaincodeSupport *ChaincodeSupport) getExecTimeout() time.Duration {
+	if chaincodeSupport == nil {
+		return execTimeoutDefault
+	}
+	chaincodeSupport.settingsLock.RLock()
+	defer chaincodeSupport.settingsLock.RUnlock()
+	return chaincodeSupport.execTimeout
+}
+
+// stateLimits returns the limits of the state requests of each chaincode
+func (chaincodeSupport *ChaincodeSupport) stateLimits() (maxConcurrent int, ratePerSec int) {
+	chaincodeSupport.settingsLock.RLock()
+	defer chaincodeSupport.settingsLock.RUnlock()
+	return chaincodeSupport.stateMaxConcurrent, chaincodeSupport.stateRatePerSec
+}
+
+// setStateLimits replaces the limits of the state requests of each chaincode,
+// including the chaincodes already registered
+func (chaincodeSupport *ChaincodeSupport) setStateLimits(maxConcurrent int, ratePerSec int) {
+	chaincodeSupport.settingsLock.Lock()
+	chaincodeSupport.stateMaxConcurrent = maxConcurrent
+	chaincodeSupport.stateRatePerSec = ratePerSec
+	chaincodeSupport.settingsLock.Unlock()
+
+	chaincodeSupport.handlerMap.RLock()
+	defer chaincodeSupport.handlerMap.RUnlock()
+	for _, handler := range chaincodeSupport.handlerMap.chaincodeMap {
+		handler.stateLimiter.setLimits(maxConcurrent, ratePerSec)
+	}
+}
+
+func reloadExecTimeout() {
+	timeout := getExecTimeout()
+	for _, chaincodeSupport := range chains {
+		chaincodeSupport.settingsLock.Lock()
+		chaincodeSupport.execTimeout = timeout
+		chaincodeSupport.settingsLock.Unlock()
+	}
+}
+
+func reloadStateLimits() {
+	for _, chaincodeSupport := range chains {
+		chaincodeSupport.setStateLimits(config.GetInt("chaincode.state.maxConcurrent"), config.GetInt("chaincode.state.ratePerSec"))
+	}
+}
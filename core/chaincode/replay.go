@@ -0,0 +1,246 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// replayCompactSize is the number of records the replay file may hold beyond
+// twice the results kept before it is rewritten with the results kept only
+const replayCompactSize = 1024
+
+// replayRecord is a line of the replay file, the result of a transaction
+// committed by a chaincode
+type replayRecord struct {
+	Chaincode string    `json:"chaincode"`
+	UUID      string    `json:"uuid"`
+	Type      int32     `json:"type"`
+	Payload   []byte    `json:"payload,omitempty"`
+	Expires   time.Time `json:"expires"`
+}
+
+// replayCache keeps the results of the transactions committed recently by
+// chaincode and UUID, so that a transaction sent again is answered with its
+// result instead of being executed twice. The result a chaincode answers is
+// held until its transaction commits, a transaction whose writes did not
+// reach the ledger is executed again. Committed results are appended to a
+// file so the protection survives a restart of the peer, the file is
+// rewritten with the results kept only once it holds mostly expired ones.
+type replayCache struct {
+	sync.Mutex
+	ttl     time.Duration
+	path    string
+	file    *os.File
+	records int
+	entries map[string]*replayRecord
+	// results of the transactions not committed yet, by UUID
+	pending map[string]*replayRecord
+}
+
+// newReplayCache returns a cache keeping results for ttl, loaded from the file
+// at path if there is one
+func newReplayCache(ttl time.Duration, path string) *replayCache {
+	c := &replayCache{ttl: ttl, path: path, entries: make(map[string]*replayRecord), pending: make(map[string]*replayRecord)}
+	f, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			chaincodeLogger.Warning("Error reading transaction results from %s: %s", path, err)
+		}
+		return c
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<30)
+	for scanner.Scan() {
+		r := &replayRecord{}
+		if err = json.Unmarshal(scanner.Bytes(), r); err != nil {
+			// the last record may have been cut by a crash
+			chaincodeLogger.Warning("Ignoring the rest of transaction results in %s: %s", path, err)
+			break
+		}
+		c.records++
+		c.entries[replayKey(r.Chaincode, r.UUID)] = r
+	}
+	if err = scanner.Err(); err != nil {
+		chaincodeLogger.Warning("Error reading transaction results from %s: %s", path, err)
+	}
+	c.expire(time.Now())
+	chaincodeLogger.Debug("Loaded %d transaction results from %s", len(c.entries), path)
+	return c
+}
+
+func replayKey(chaincode string, uuid string) string {
+	return chaincode + "\x00" + uuid
+}
+
+// lookup returns the result of transaction uuid of chaincode, nil if it was
+// not committed within the last ttl
+func (c *replayCache) lookup(chaincode string, uuid string) *pb.ChaincodeMessage {
+	c.Lock()
+	defer c.Unlock()
+	r := c.entries[replayKey(chaincode, uuid)]
+	if r == nil || time.Now().After(r.Expires) {
+		return nil
+	}
+	return &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_Type(r.Type), Payload: r.Payload, Uuid: uuid}
+}
+
+// hold keeps resp as the result of transaction uuid of chaincode until the
+// transaction commits or is discarded
+func (c *replayCache) hold(chaincode string, uuid string, resp *pb.ChaincodeMessage) {
+	c.Lock()
+	defer c.Unlock()
+	c.pending[uuid] = &replayRecord{Chaincode: chaincode, UUID: uuid, Type: int32(resp.Type), Payload: resp.Payload, Expires: time.Now().Add(c.ttl)}
+}
+
+// commit records the result held for transaction uuid, if any, once the
+// transaction committed. A transaction that did not commit has its result
+// dropped.
+func (c *replayCache) commit(uuid string, committed bool) {
+	c.Lock()
+	defer c.Unlock()
+	r := c.pending[uuid]
+	if r == nil {
+		return
+	}
+	delete(c.pending, uuid)
+	if !committed {
+		return
+	}
+	now := time.Now()
+	r.Expires = now.Add(c.ttl)
+	c.entries[replayKey(r.Chaincode, r.UUID)] = r
+	err := c.append(r)
+	if err == nil && c.records > 2*len(c.entries)+replayCompactSize {
+		c.expire(now)
+		err = c.compact()
+	}
+	if err != nil {
+		chaincodeLogger.Warning("[%s]Transaction result not saved: %s", shortuuid(uuid), err)
+	}
+}
+
+// expire drops the results kept for longer than ttl, including those of the
+// transactions that never finished, the lock must be held
+func (c *replayCache) expire(now time.Time) {
+	for k, r := range c.entries {
+		if now.After(r.Expires) {
+			delete(c.entries, k)
+		}
+	}
+	for k, r := range c.pending {
+		if now.After(r.Expires) {
+			delete(c.pending, k)
+		}
+	}
+}
+
+// append writes record r at the end of the replay file, the lock must be held
+func (c *replayCache) append(r *replayRecord) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if c.file == nil {
+		if err = os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+			return err
+		}
+		if c.file, err = os.OpenFile(c.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+			return err
+		}
+	}
+	c.records++
+	_, err = c.file.Write(append(data, '\n'))
+	return err
+}
+
+// compact rewrites the replay file with the results kept, the lock must be
+// held
+func (c *replayCache) compact() error {
+	if c.file != nil {
+		c.file.Close()
+		c.file = nil
+	}
+	c.records = 0
+	tmp := c.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, r := range c.entries {
+		data, err := json.Marshal(r)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(append(data, '\n'))
+		c.records++
+	}
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, c.path); err != nil {
+		return fmt.Errorf("Error replacing %s: %s", c.path, err)
+	}
+	return nil
+}
+
+// replayedResult returns the result of the execution of transaction uuid by
+// the chaincode if it was committed recently, nil otherwise
+func (handler *Handler) replayedResult(uuid string) *pb.ChaincodeMessage {
+	if handler.chaincodeSupport == nil || handler.chaincodeSupport.replays == nil || handler.ChaincodeID == nil {
+		return nil
+	}
+	return handler.chaincodeSupport.replays.lookup(handler.ChaincodeID.Name, uuid)
+}
+
+// holdResult keeps the COMPLETED the chaincode answered to transaction
+// msg.Uuid until the transaction commits, see commitResult
+func (handler *Handler) holdResult(msg *pb.ChaincodeMessage) {
+	if handler.chaincodeSupport == nil || handler.chaincodeSupport.replays == nil || handler.ChaincodeID == nil {
+		return
+	}
+	if msg.Type != pb.ChaincodeMessage_COMPLETED {
+		return
+	}
+	handler.chaincodeSupport.replays.hold(handler.ChaincodeID.Name, msg.Uuid, msg)
+}
+
+// commitResult records the result held for transaction uuid, so that it is not
+// executed again, if committed is true and drops it otherwise
+func (chaincodeSupport *ChaincodeSupport) commitResult(uuid string, committed bool) {
+	if chaincodeSupport.replays != nil {
+		chaincodeSupport.replays.commit(uuid, committed)
+	}
+}
@@ -0,0 +1,154 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos"
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+)
+
+func TestReplayCacheExpiresAndPersists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatalf("Error creating directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "chaincode", "replay.json")
+
+	c := newReplayCache(time.Minute, path)
+	c.hold("mycc", "1", &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_COMPLETED, Payload: []byte("done")})
+	if c.lookup("mycc", "1") != nil {
+		t.Fatal("Expected the result not to be replayed before the transaction commits")
+	}
+	c.commit("1", true)
+	if c.lookup("othercc", "1") != nil {
+		t.Fatal("Expected results to be kept per chaincode")
+	}
+	c.hold("mycc", "2", &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_COMPLETED, Payload: []byte("lost")})
+	c.commit("2", false)
+	c.commit("2", true)
+	if c.lookup("mycc", "2") != nil {
+		t.Fatal("Expected the result of a transaction that did not commit to be dropped")
+	}
+
+	restarted := newReplayCache(time.Minute, path)
+	resp := restarted.lookup("mycc", "1")
+	if resp == nil || resp.Type != pb.ChaincodeMessage_COMPLETED || string(resp.Payload) != "done" || resp.Uuid != "1" {
+		t.Fatalf("Expected the result to survive a restart, got %v", resp)
+	}
+
+	restarted.entries[replayKey("mycc", "1")].Expires = time.Now().Add(-time.Second)
+	if restarted.lookup("mycc", "1") != nil {
+		t.Fatal("Expected an expired result to be forgotten")
+	}
+}
+
+func TestReplayCacheCompacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatalf("Error creating directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "replay.json")
+
+	c := newReplayCache(time.Minute, path)
+	for i := 0; i <= replayCompactSize+2; i++ {
+		c.hold("mycc", "1", &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_COMPLETED, Payload: []byte(strconv.Itoa(i))})
+		c.commit("1", true)
+	}
+	if c.records > 2*len(c.entries)+replayCompactSize {
+		t.Fatalf("Expected the file to be compacted, holds %d records", c.records)
+	}
+
+	restarted := newReplayCache(time.Minute, path)
+	resp := restarted.lookup("mycc", "1")
+	if resp == nil || string(resp.Payload) != strconv.Itoa(replayCompactSize+2) {
+		t.Fatalf("Expected the last result to survive the compaction, got %v", resp)
+	}
+}
+
+type countChaincode struct {
+	kvChaincode
+	count int32
+}
+
+func (cc *countChaincode) Invoke(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	return []byte(strconv.Itoa(int(atomic.AddInt32(&cc.count, 1)))), nil
+}
+
+func TestRetransmittedTransactionIsNotExecutedTwice(t *testing.T) {
+	viper.Set("peer.fileSystemPath", "/var/hyperledger/test/tmpdb")
+	getPeerEndpoint := func() (*pb.PeerEndpoint, error) {
+		return &pb.PeerEndpoint{ID: &pb.PeerID{Name: "testpeer"}, Address: "0.0.0.0:40303"}, nil
+	}
+	chain := NewChaincodeSupport(DefaultChain, getPeerEndpoint, false, 10*time.Second, nil)
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatalf("Error creating directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	chain.replays = newReplayCache(time.Minute, filepath.Join(dir, "replay.json"))
+
+	cc := &countChaincode{}
+	if err = RegisterSystemChaincode(&SystemChaincode{Name: "replaysyscc", Chaincode: cc}); err != nil {
+		t.Fatalf("Error registering system chaincode: %s", err)
+	}
+	defer chain.StopChaincode(context.Background(), &pb.ChaincodeID{Name: "replaysyscc"})
+
+	tx := newBatchTransaction(t, "replaysyscc", pb.Transaction_CHAINCODE_INVOKE, "count")
+	cID, cMsg, err := chain.LaunchChaincode(context.Background(), tx)
+	if err != nil {
+		t.Fatalf("Error launching chaincode: %s", err)
+	}
+	execute := func() string {
+		msg, _ := createTransactionMessage(tx.Uuid, cMsg)
+		resp, err := chain.Execute(context.Background(), cID.Name, msg, 10*time.Second, tx)
+		if err != nil {
+			t.Fatalf("Error executing transaction: %s", err)
+		}
+		return string(resp.Payload)
+	}
+
+	// the transaction did not commit, sent again it is executed again
+	execute()
+	chain.commitResult(tx.Uuid, false)
+	if result := execute(); result != "2" {
+		t.Fatalf("Expected a transaction that did not commit to execute again, got %s", result)
+	}
+	chain.commitResult(tx.Uuid, true)
+	for i := 0; i < 2; i++ {
+		if result := execute(); result != "2" {
+			t.Fatalf("Expected the result of the committed execution, got %s", result)
+		}
+	}
+	if count := atomic.LoadInt32(&cc.count); count != 2 {
+		t.Fatalf("Expected the chaincode to execute the transaction twice, executed %d times", count)
+	}
+}
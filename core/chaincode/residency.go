@@ -0,0 +1,105 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// stateResidency accumulates the time a handler spends in each state of its
+// FSM, telling apart chaincodes slow in their business logic (transaction)
+// from chaincodes slow in their state operations (busyxact)
+type stateResidency struct {
+	sync.Mutex
+	current     string
+	since       time.Time
+	totals      map[string]time.Duration
+	entries     map[string]uint64
+	transitions uint64
+}
+
+func newStateResidency(initial string) *stateResidency {
+	return &stateResidency{current: initial, since: time.Now(), totals: make(map[string]time.Duration), entries: map[string]uint64{initial: 1}}
+}
+
+// enter ends the stay in the current state and starts one in state
+func (r *stateResidency) enter(state string) {
+	r.Lock()
+	defer r.Unlock()
+	now := time.Now()
+	r.totals[r.current] += now.Sub(r.since)
+	r.current = state
+	r.since = now
+	r.entries[state]++
+	r.transitions++
+}
+
+// metrics returns the residency of every state entered so far, the stay in
+// the current state included
+func (r *stateResidency) metrics(chaincodeID string) *pb.ChaincodeHandlerMetrics {
+	r.Lock()
+	defer r.Unlock()
+	m := &pb.ChaincodeHandlerMetrics{ChaincodeID: chaincodeID, CurrentState: r.current, Transitions: r.transitions}
+	for state, entries := range r.entries {
+		total := r.totals[state]
+		if state == r.current {
+			total += time.Since(r.since)
+		}
+		m.States = append(m.States, &pb.ChaincodeStateResidency{State: state, TotalNanos: total.Nanoseconds(), Entries: entries})
+	}
+	sort.Sort(residencyByState(m.States))
+	return m
+}
+
+type residencyByState []*pb.ChaincodeStateResidency
+
+func (a residencyByState) Len() int           { return len(a) }
+func (a residencyByState) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a residencyByState) Less(i, j int) bool { return a[i].State < a[j].State }
+
+// HandlerMetrics returns the FSM state residency of the handlers of the
+// running chaincodes, ordered by chaincode name
+func (chaincodeSupport *ChaincodeSupport) HandlerMetrics() []*pb.ChaincodeHandlerMetrics {
+	chaincodeSupport.handlerMap.RLock()
+	defer chaincodeSupport.handlerMap.RUnlock()
+
+	var metrics []*pb.ChaincodeHandlerMetrics
+	for name, handler := range chaincodeSupport.handlerMap.chaincodeMap {
+		if handler.residency != nil {
+			m := handler.residency.metrics(name)
+			handler.RLock()
+			m.StaleSwept = handler.staleSwept
+			handler.RUnlock()
+			metrics = append(metrics, m)
+		}
+	}
+	sort.Sort(metricsByChaincode(metrics))
+	return metrics
+}
+
+type metricsByChaincode []*pb.ChaincodeHandlerMetrics
+
+func (a metricsByChaincode) Len() int           { return len(a) }
+func (a metricsByChaincode) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a metricsByChaincode) Less(i, j int) bool { return a[i].ChaincodeID < a[j].ChaincodeID }
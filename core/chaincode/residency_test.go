@@ -0,0 +1,77 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"testing"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestStateResidency(t *testing.T) {
+	r := newStateResidency(readystate)
+	r.enter(transactionstate)
+	r.enter(busyxactstate)
+	time.Sleep(20 * time.Millisecond)
+	r.enter(transactionstate)
+	r.enter(readystate)
+
+	m := r.metrics("cc")
+	if m.CurrentState != readystate || m.Transitions != 4 {
+		t.Fatalf("Expected 4 transitions ending in %s, got %d ending in %s", readystate, m.Transitions, m.CurrentState)
+	}
+	residency := make(map[string]*pb.ChaincodeStateResidency)
+	for _, s := range m.States {
+		residency[s.State] = s
+	}
+	if len(residency) != 3 || residency[readystate].Entries != 2 || residency[transactionstate].Entries != 2 || residency[busyxactstate].Entries != 1 {
+		t.Fatalf("Unexpected state entries %v", m.States)
+	}
+	if busy := time.Duration(residency[busyxactstate].TotalNanos); busy < 20*time.Millisecond {
+		t.Fatalf("Expected at least 20ms in %s, got %s", busyxactstate, busy)
+	}
+	if m.States[0].State != busyxactstate {
+		t.Fatalf("Expected states ordered by name, got %v", m.States)
+	}
+
+	// the current stay counts
+	time.Sleep(20 * time.Millisecond)
+	if ready := time.Duration(r.metrics("cc").States[1].TotalNanos); ready < 20*time.Millisecond {
+		t.Fatalf("Expected the current stay in %s to be included, got %s", readystate, ready)
+	}
+}
+
+func TestHandlerMetrics(t *testing.T) {
+	chaincodeSupport := &ChaincodeSupport{handlerMap: &handlerMap{chaincodeMap: make(map[string]*Handler)}}
+	for _, name := range []string{"cc2", "cc1"} {
+		handler := newTestHandler(newMockChaincodeStream())
+		handler.residency.enter(establishedstate)
+		chaincodeSupport.handlerMap.chaincodeMap[name] = handler
+	}
+
+	metrics := chaincodeSupport.HandlerMetrics()
+	if len(metrics) != 2 || metrics[0].ChaincodeID != "cc1" || metrics[1].ChaincodeID != "cc2" {
+		t.Fatalf("Expected the metrics of cc1 and cc2, got %v", metrics)
+	}
+	if metrics[0].CurrentState != establishedstate || metrics[0].Transitions != 1 {
+		t.Fatalf("Unexpected metrics %v", metrics[0])
+	}
+}
@@ -0,0 +1,162 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+
+	"github.com/hyperledger/fabric/core/container"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// When a chaincode stream ends while its container still runs, the state of
+// the container is polled this many times, at this interval, for it to stop
+const (
+	containerExitPolls        = 10
+	containerExitPollInterval = 100 * time.Millisecond
+)
+
+// getDefaultResourceLimits returns the limits of chaincode containers whose
+// deployment spec sets none, from chaincode.resources in core.yaml
+func getDefaultResourceLimits() *container.ResourceLimits {
+	return &container.ResourceLimits{
+		CPUShares:   int64(viper.GetInt("chaincode.resources.cpuShares")),
+		Memory:      int64(viper.GetInt("chaincode.resources.memoryLimit")),
+		PidsLimit:   int64(viper.GetInt("chaincode.resources.pidsLimit")),
+		NetworkMode: viper.GetString("chaincode.resources.networkMode"),
+	}
+}
+
+// resourceLimitsForSpec returns the limits of the container of a chaincode
+// deployed with spec. The configured limits are a ceiling the spec may only
+// tighten, a spec asking for more is refused.
+func resourceLimitsForSpec(spec *pb.ChaincodeSpec, defaults *container.ResourceLimits) (*container.ResourceLimits, error) {
+	limits := *defaults
+	if resources := spec.GetResources(); resources != nil {
+		if resources.CpuShares != 0 {
+			limits.CPUShares = resources.CpuShares
+		}
+		if resources.MemoryLimit != 0 {
+			limits.Memory = resources.MemoryLimit
+		}
+		if resources.PidsLimit != 0 {
+			limits.PidsLimit = resources.PidsLimit
+		}
+		if resources.NetworkMode != "" {
+			limits.NetworkMode = resources.NetworkMode
+		}
+	}
+	if err := limits.Validate(); err != nil {
+		return nil, err
+	}
+	if err := limits.Within(defaults); err != nil {
+		return nil, fmt.Errorf("Resource limits of chaincode exceed those of the peer: %s", err)
+	}
+	return &limits, nil
+}
+
+// setResourceLimits records the limits of the container of chaincode
+func (chaincodeSupport *ChaincodeSupport) setResourceLimits(chaincode string, spec *pb.ChaincodeSpec) error {
+	limits, err := resourceLimitsForSpec(spec, chaincodeSupport.defaultLimits)
+	if err != nil {
+		return err
+	}
+	chaincodeSupport.handlerMap.Lock()
+	defer chaincodeSupport.handlerMap.Unlock()
+	chaincodeSupport.handlerMap.limitsMap[chaincode] = limits
+	return nil
+}
+
+// getResourceLimits returns the limits of the container of chaincode
+func (chaincodeSupport *ChaincodeSupport) getResourceLimits(chaincode string) *container.ResourceLimits {
+	chaincodeSupport.handlerMap.Lock()
+	defer chaincodeSupport.handlerMap.Unlock()
+	if limits, ok := chaincodeSupport.handlerMap.limitsMap[chaincode]; ok {
+		return limits
+	}
+	return chaincodeSupport.defaultLimits
+}
+
+// containerExitError returns an error explaining why the container of
+// chaincode stopped if it was killed for exceeding its memory limit, nil
+// otherwise or if its state cannot be found out. The container is inspected
+// once so as not to hold up the teardown of the stream; one still running is
+// watched in the background, to log an OOM kill.
+func (chaincodeSupport *ChaincodeSupport) containerExitError(chaincode string) error {
+	if chaincodeSupport.userRunsCC || chaincodeSupport.getVMType(chaincode) != container.DOCKER {
+		return nil
+	}
+	state := inspectContainer(chaincode)
+	if state == nil {
+		return nil
+	}
+	if state.Running {
+		go chaincodeSupport.watchContainerExit(chaincode)
+		return nil
+	}
+	return chaincodeSupport.oomError(chaincode, state)
+}
+
+// watchContainerExit polls the state of the container of chaincode until it
+// stops running, and logs if it was killed for exceeding its memory limit
+func (chaincodeSupport *ChaincodeSupport) watchContainerExit(chaincode string) {
+	for i := 0; i < containerExitPolls; i++ {
+		time.Sleep(containerExitPollInterval)
+		state := inspectContainer(chaincode)
+		if state == nil {
+			return
+		}
+		if !state.Running {
+			if err := chaincodeSupport.oomError(chaincode, state); err != nil {
+				chaincodeLog.Error("%s", err)
+			}
+			return
+		}
+	}
+}
+
+// oomError returns an error if the container in state was killed for
+// exceeding its memory limit
+func (chaincodeSupport *ChaincodeSupport) oomError(chaincode string, state *container.ContainerState) error {
+	if !state.OOMKilled {
+		return nil
+	}
+	return fmt.Errorf("chaincode %s was killed for exceeding its memory limit of %d bytes", chaincode, chaincodeSupport.getResourceLimits(chaincode).Memory)
+}
+
+// inspectContainer returns the state of the container of chaincode, nil if it
+// cannot be found out
+func inspectContainer(chaincode string) *container.ContainerState {
+	vmname := container.GetVMFromName(chaincode)
+	resp, err := container.VMCProcess(context.Background(), container.DOCKER, container.InspectReq{ID: vmname})
+	if err == nil {
+		err = resp.(container.VMCResp).Err
+	}
+	if err != nil {
+		chaincodeLog.Debug("could not inspect %s: %s", vmname, err)
+		return nil
+	}
+	state, _ := resp.(container.VMCResp).Resp.(*container.ContainerState)
+	return state
+}
@@ -0,0 +1,63 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/core/container"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestResourceLimitsForSpec(t *testing.T) {
+	defaults := &container.ResourceLimits{CPUShares: 1024, Memory: 1 << 30, PidsLimit: 100, NetworkMode: "host"}
+
+	limits, err := resourceLimitsForSpec(&pb.ChaincodeSpec{}, defaults)
+	if err != nil || *limits != *defaults {
+		t.Fatalf("Expected the defaults for a spec without resources, got %+v (%v)", limits, err)
+	}
+
+	spec := &pb.ChaincodeSpec{Resources: &pb.ChaincodeResources{MemoryLimit: 1 << 26, PidsLimit: 50, NetworkMode: "none"}}
+	limits, err = resourceLimitsForSpec(spec, defaults)
+	if err != nil {
+		t.Fatalf("Error getting limits: %s", err)
+	}
+	expected := container.ResourceLimits{CPUShares: 1024, Memory: 1 << 26, PidsLimit: 50, NetworkMode: "none"}
+	if *limits != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, *limits)
+	}
+	if defaults.Memory != 1<<30 {
+		t.Fatal("Expected the defaults to be left alone")
+	}
+
+	spec.Resources.MemoryLimit = 1 << 31
+	if _, err = resourceLimitsForSpec(spec, defaults); err == nil {
+		t.Fatal("Expected an error for a spec raising the memory limit")
+	}
+	spec.Resources.MemoryLimit = 1 << 26
+	if _, err = resourceLimitsForSpec(&pb.ChaincodeSpec{Resources: &pb.ChaincodeResources{NetworkMode: "host"}}, &container.ResourceLimits{NetworkMode: "none"}); err == nil {
+		t.Fatal("Expected an error for a spec loosening the network isolation")
+	}
+
+	spec.Resources.NetworkMode = "container:peer"
+	if _, err = resourceLimitsForSpec(spec, defaults); err == nil {
+		t.Fatal("Expected an error for an invalid network mode")
+	}
+}
@@ -0,0 +1,285 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hyperledger/fabric/core/util"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// stateAccessor is the part of the ledger used to simulate and commit
+// transactions. It is satisfied by *ledger.Ledger.
+type stateAccessor interface {
+	GetState(chaincodeID string, key string, committed bool) ([]byte, error)
+	SetState(chaincodeID string, key string, value []byte) error
+	DeleteState(chaincodeID string, key string) error
+}
+
+// stateVersion returns the version of a state value. The ledger does not keep
+// versions, so the hash of the value is used, nil for a missing key.
+func stateVersion(value []byte) []byte {
+	if value == nil {
+		return nil
+	}
+	return util.ComputeCryptoHash(value)
+}
+
+func stateKey(chaincodeID string, key string) string {
+	return chaincodeID + "\x00" + key
+}
+
+type stateRead struct {
+	chaincodeID string
+	key         string
+	version     []byte
+}
+
+type stateWrite struct {
+	chaincodeID string
+	key         string
+	value       []byte
+	isDelete    bool
+}
+
+// readWriteSet is the simulated result of a transaction: the version of every
+// key it read from the ledger and the values it wants to write. Chaincodes
+// invoked from within the transaction share its read-write set. A snapshot
+// read-write set reads committed state only, as used by simulations.
+type readWriteSet struct {
+	sync.Mutex
+	snapshot bool
+	reads    map[string]*stateRead
+	writes   map[string]*stateWrite
+	order    []string
+}
+
+func newReadWriteSet() *readWriteSet {
+	return &readWriteSet{reads: make(map[string]*stateRead), writes: make(map[string]*stateWrite)}
+}
+
+// getState returns the value written by the transaction if there is one,
+// otherwise reads it from the ledger and records the version that was read
+func (rw *readWriteSet) getState(ledgerObj stateAccessor, chaincodeID string, key string, committed bool) ([]byte, error) {
+	rw.Lock()
+	defer rw.Unlock()
+	k := stateKey(chaincodeID, key)
+	if w, ok := rw.writes[k]; ok {
+		if w.isDelete {
+			return nil, nil
+		}
+		return w.value, nil
+	}
+	value, err := ledgerObj.GetState(chaincodeID, key, committed || rw.snapshot)
+	if err != nil {
+		return nil, err
+	}
+	// Only the first read counts, that is the version the transaction depends on
+	if _, ok := rw.reads[k]; !ok {
+		rw.reads[k] = &stateRead{chaincodeID: chaincodeID, key: key, version: stateVersion(value)}
+	}
+	return value, nil
+}
+
+func (rw *readWriteSet) putState(chaincodeID string, key string, value []byte) {
+	rw.recordWrite(&stateWrite{chaincodeID: chaincodeID, key: key, value: value})
+}
+
+func (rw *readWriteSet) delState(chaincodeID string, key string) {
+	rw.recordWrite(&stateWrite{chaincodeID: chaincodeID, key: key, isDelete: true})
+}
+
+func (rw *readWriteSet) recordWrite(w *stateWrite) {
+	rw.Lock()
+	defer rw.Unlock()
+	k := stateKey(w.chaincodeID, w.key)
+	if _, ok := rw.writes[k]; !ok {
+		rw.order = append(rw.order, k)
+	}
+	rw.writes[k] = w
+}
+
+// validate checks that none of the keys read by the transaction have changed
+// in the ledger since they were read
+func (rw *readWriteSet) validate(ledgerObj stateAccessor) error {
+	rw.Lock()
+	defer rw.Unlock()
+	for _, r := range rw.reads {
+		value, err := ledgerObj.GetState(r.chaincodeID, r.key, false)
+		if err != nil {
+			return fmt.Errorf("Error reading %s/%s for validation: %s", r.chaincodeID, r.key, err)
+		}
+		if !bytes.Equal(stateVersion(value), r.version) {
+			return fmt.Errorf("MVCC conflict, %s/%s was modified after it was read", r.chaincodeID, r.key)
+		}
+	}
+	return nil
+}
+
+// apply writes the write set to the ledger in the order the keys were first written
+func (rw *readWriteSet) apply(ledgerObj stateAccessor) error {
+	rw.Lock()
+	defer rw.Unlock()
+	for _, k := range rw.order {
+		w := rw.writes[k]
+		var err error
+		if w.isDelete {
+			err = ledgerObj.DeleteState(w.chaincodeID, w.key)
+		} else {
+			err = ledgerObj.SetState(w.chaincodeID, w.key, w.value)
+		}
+		if err != nil {
+			return fmt.Errorf("Error applying write of %s/%s: %s", w.chaincodeID, w.key, err)
+		}
+	}
+	return nil
+}
+
+// toProto returns the reads sorted by key and the writes in the order the
+// keys were first written
+func (rw *readWriteSet) toProto() ([]*pb.StateRead, []*pb.StateWrite) {
+	rw.Lock()
+	defer rw.Unlock()
+	keys := make([]string, 0, len(rw.reads))
+	for k := range rw.reads {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	reads := make([]*pb.StateRead, 0, len(keys))
+	for _, k := range keys {
+		r := rw.reads[k]
+		reads = append(reads, &pb.StateRead{ChaincodeID: r.chaincodeID, Key: r.key, Version: r.version})
+	}
+	writes := make([]*pb.StateWrite, 0, len(rw.order))
+	for _, k := range rw.order {
+		w := rw.writes[k]
+		writes = append(writes, &pb.StateWrite{ChaincodeID: w.chaincodeID, Key: w.key, Value: w.value, IsDelete: w.isDelete})
+	}
+	return reads, writes
+}
+
+// rwSetStore holds the read-write sets of the transactions being simulated
+type rwSetStore struct {
+	sync.Mutex
+	sets map[string]*readWriteSet
+}
+
+func newRWSetStore() *rwSetStore {
+	return &rwSetStore{sets: make(map[string]*readWriteSet)}
+}
+
+// get returns the read-write set of uuid, creating it on first use
+func (s *rwSetStore) get(uuid string) *readWriteSet {
+	s.Lock()
+	defer s.Unlock()
+	rw, ok := s.sets[uuid]
+	if !ok {
+		rw = newReadWriteSet()
+		s.sets[uuid] = rw
+	}
+	return rw
+}
+
+// lookup returns the read-write set of uuid, nil if there is none
+func (s *rwSetStore) lookup(uuid string) *readWriteSet {
+	s.Lock()
+	defer s.Unlock()
+	return s.sets[uuid]
+}
+
+// add registers rw as the read-write set of uuid
+func (s *rwSetStore) add(uuid string, rw *readWriteSet) {
+	s.Lock()
+	defer s.Unlock()
+	s.sets[uuid] = rw
+}
+
+// remove returns and forgets the read-write set of uuid, nil if there is none
+func (s *rwSetStore) remove(uuid string) *readWriteSet {
+	s.Lock()
+	defer s.Unlock()
+	rw := s.sets[uuid]
+	delete(s.sets, uuid)
+	return rw
+}
+
+// commitReadWriteSet validates the read set of the transaction against the
+// current state and, if no key it read has changed, journals and applies its
+// write set. It does nothing when read-write set capture is disabled.
+func (chaincodeSupport *ChaincodeSupport) commitReadWriteSet(uuid string, ledgerObj stateAccessor) error {
+	if chaincodeSupport.rwsets == nil {
+		return nil
+	}
+	rw := chaincodeSupport.rwsets.remove(uuid)
+	if rw == nil {
+		return nil
+	}
+	if err := rw.validate(ledgerObj); err != nil {
+		return err
+	}
+	_, writes := rw.toProto()
+	if err := chaincodeSupport.journalWrites("", uuid, journalWritesFromProto(writes), true); err != nil {
+		return err
+	}
+	return rw.apply(ledgerObj)
+}
+
+// discardReadWriteSet drops the read-write set of a transaction that failed
+func (chaincodeSupport *ChaincodeSupport) discardReadWriteSet(uuid string) {
+	if chaincodeSupport.rwsets != nil {
+		chaincodeSupport.rwsets.remove(uuid)
+	}
+}
+
+// readWriteSet returns the read-write set of uuid, nil if capture is disabled
+// and uuid is not being simulated
+func (handler *Handler) readWriteSet(uuid string) *readWriteSet {
+	if handler.chaincodeSupport == nil {
+		return nil
+	}
+	if sims := handler.chaincodeSupport.simulations; sims != nil {
+		if rw := sims.lookup(uuid); rw != nil {
+			return rw
+		}
+	}
+	if handler.chaincodeSupport.rwsets == nil {
+		return nil
+	}
+	return handler.chaincodeSupport.rwsets.get(uuid)
+}
+
+// prefixWrites returns the keys of chaincodeID with prefix the transaction
+// wrote, each mapped to whether it was deleted
+func (rw *readWriteSet) prefixWrites(chaincodeID string, prefix string) map[string]bool {
+	rw.Lock()
+	defer rw.Unlock()
+	written := make(map[string]bool)
+	for _, w := range rw.writes {
+		if w.chaincodeID == chaincodeID && strings.HasPrefix(w.key, prefix) {
+			written[w.key] = w.isDelete
+		}
+	}
+	return written
+}
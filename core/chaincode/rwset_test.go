@@ -0,0 +1,150 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"testing"
+)
+
+type mapState map[string][]byte
+
+func (m mapState) GetState(chaincodeID string, key string, committed bool) ([]byte, error) {
+	return m[stateKey(chaincodeID, key)], nil
+}
+
+func (m mapState) SetState(chaincodeID string, key string, value []byte) error {
+	m[stateKey(chaincodeID, key)] = value
+	return nil
+}
+
+func (m mapState) DeleteState(chaincodeID string, key string) error {
+	delete(m, stateKey(chaincodeID, key))
+	return nil
+}
+
+func TestReadWriteSetCommit(t *testing.T) {
+	state := mapState{}
+	state.SetState("cc1", "a", []byte("100"))
+	state.SetState("cc2", "b", []byte("200"))
+
+	cs := &ChaincodeSupport{rwsets: newRWSetStore()}
+	rw := cs.rwsets.get("tx1")
+	if v, _ := rw.getState(state, "cc1", "a", false); string(v) != "100" {
+		t.Fatalf("Expected 100, got %s", v)
+	}
+	rw.putState("cc1", "a", []byte("90"))
+	rw.delState("cc2", "b")
+
+	// The transaction reads its own writes, the ledger is untouched
+	if v, _ := rw.getState(state, "cc1", "a", false); string(v) != "90" {
+		t.Fatalf("Expected pending write 90, got %s", v)
+	}
+	if v, _ := rw.getState(state, "cc2", "b", false); v != nil {
+		t.Fatalf("Expected deleted key, got %s", v)
+	}
+	if v, _ := state.GetState("cc1", "a", false); string(v) != "100" {
+		t.Fatalf("Write applied before commit, got %s", v)
+	}
+
+	if err := cs.commitReadWriteSet("tx1", state); err != nil {
+		t.Fatalf("Unexpected error committing: %s", err)
+	}
+	if v, _ := state.GetState("cc1", "a", false); string(v) != "90" {
+		t.Fatalf("Expected committed 90, got %s", v)
+	}
+	if _, ok := state[stateKey("cc2", "b")]; ok {
+		t.Fatal("Expected cc2/b to be deleted")
+	}
+}
+
+func TestReadWriteSetConflict(t *testing.T) {
+	state := mapState{}
+	state.SetState("cc1", "a", []byte("100"))
+
+	cs := &ChaincodeSupport{rwsets: newRWSetStore()}
+	rw1 := cs.rwsets.get("tx1")
+	rw2 := cs.rwsets.get("tx2")
+	rw1.getState(state, "cc1", "a", false)
+	rw1.putState("cc1", "a", []byte("90"))
+	rw2.getState(state, "cc1", "a", false)
+	rw2.putState("cc1", "a", []byte("80"))
+
+	if err := cs.commitReadWriteSet("tx1", state); err != nil {
+		t.Fatalf("Unexpected error committing tx1: %s", err)
+	}
+	if err := cs.commitReadWriteSet("tx2", state); err == nil {
+		t.Fatal("Expected tx2 to fail validation after tx1 modified cc1/a")
+	}
+	if v, _ := state.GetState("cc1", "a", false); string(v) != "90" {
+		t.Fatalf("Expected 90 from tx1, got %s", v)
+	}
+}
+
+// pendingState returns pending values for uncommitted reads
+type pendingState struct {
+	mapState
+	pending mapState
+}
+
+func (p pendingState) GetState(chaincodeID string, key string, committed bool) ([]byte, error) {
+	if v, ok := p.pending[stateKey(chaincodeID, key)]; ok && !committed {
+		return v, nil
+	}
+	return p.mapState.GetState(chaincodeID, key, committed)
+}
+
+func TestSimulationReadWriteSet(t *testing.T) {
+	state := pendingState{mapState: mapState{}, pending: mapState{}}
+	state.SetState("cc1", "b", []byte("100"))
+	state.SetState("cc1", "a", []byte("1"))
+	state.pending.SetState("cc1", "b", []byte("50"))
+
+	cs := &ChaincodeSupport{simulations: newRWSetStore()}
+	rw := newReadWriteSet()
+	rw.snapshot = true
+	cs.simulations.add("sim", rw)
+
+	handler := &Handler{chaincodeSupport: cs}
+	if handler.readWriteSet("sim") != rw {
+		t.Fatal("Expected the simulation read-write set")
+	}
+	if handler.readWriteSet("tx1") != nil {
+		t.Fatal("Expected no read-write set for a transaction when mvcc is disabled")
+	}
+
+	// Simulations read committed state even from a transaction context
+	if v, _ := rw.getState(state, "cc1", "b", false); string(v) != "100" {
+		t.Fatalf("Expected committed 100, got %s", v)
+	}
+	rw.getState(state, "cc1", "a", false)
+	rw.putState("cc1", "z", []byte("2"))
+	rw.delState("cc1", "a")
+
+	reads, writes := rw.toProto()
+	if len(reads) != 2 || reads[0].Key != "a" || reads[1].Key != "b" {
+		t.Fatalf("Expected reads of a and b sorted by key, got %v", reads)
+	}
+	if len(writes) != 2 || writes[0].Key != "z" || string(writes[0].Value) != "2" || !writes[1].IsDelete {
+		t.Fatalf("Expected writes in order, got %v", writes)
+	}
+	if v, _ := state.GetState("cc1", "a", true); string(v) != "1" {
+		t.Fatalf("Simulation modified the ledger, got %s", v)
+	}
+}
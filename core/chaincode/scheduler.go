@@ -0,0 +1,115 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// batchDependencies returns, for each item of a batch in block order, the
+// earlier items of its chaincode it must wait for. Items wait for one another
+// when their key hints conflict, and items without hints conflict with every
+// invoke of their chaincode. Queries bypass the handler FSM and write
+// nothing, so queries never wait for one another. Unless concurrent is set a
+// handler executes one invoke at a time, and an invoke then waits for the
+// invokes before it whatever their hints.
+func batchDependencies(items []*batchItem, concurrent bool) [][]int {
+	deps := make([][]int, len(items))
+	byChaincode := make(map[string][]int)
+	for i, item := range items {
+		for _, j := range byChaincode[item.chaincode] {
+			if batchItemsConflict(items[j], item, concurrent) {
+				deps[i] = append(deps[i], j)
+			}
+		}
+		byChaincode[item.chaincode] = append(byChaincode[item.chaincode], i)
+	}
+	return deps
+}
+
+// batchItemsConflict returns true if two items of a chaincode cannot execute
+// at the same time
+func batchItemsConflict(a, b *batchItem, concurrent bool) bool {
+	aInvoke := a.msg.Type == pb.ChaincodeMessage_TRANSACTION
+	bInvoke := b.msg.Type == pb.ChaincodeMessage_TRANSACTION
+	if aInvoke && bInvoke && !concurrent {
+		return true
+	}
+	if !aInvoke && !bInvoke {
+		return false
+	}
+	return keyHintsConflict(a.hints, b.hints)
+}
+
+// keyHintsConflict returns true unless both transactions declared hints and
+// neither writes a key the other reads or writes
+func keyHintsConflict(a, b *pb.StateKeyHints) bool {
+	if a == nil || b == nil {
+		return true
+	}
+	return writesAny(a, b.Reads) || writesAny(a, b.Writes) || writesAny(b, a.Reads)
+}
+
+// writesAny returns true if hints write one of keys
+func writesAny(hints *pb.StateKeyHints, keys []string) bool {
+	for _, w := range hints.Writes {
+		if containsKey(keys, w) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsKey(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// checkHints returns an error if the transaction touched the state of another
+// chaincode than chaincodeID, or read or wrote a key hints do not declare. A
+// key declared as written may also be read. Without hints only the chaincode
+// is checked.
+func (rw *readWriteSet) checkHints(chaincodeID string, hints *pb.StateKeyHints) error {
+	rw.Lock()
+	defer rw.Unlock()
+	for _, r := range rw.reads {
+		if r.chaincodeID != chaincodeID {
+			return fmt.Errorf("read %s/%s of another chaincode", r.chaincodeID, r.key)
+		}
+		if hints != nil && !containsKey(hints.Reads, r.key) && !containsKey(hints.Writes, r.key) {
+			return fmt.Errorf("read %s which its key hints do not declare", r.key)
+		}
+	}
+	for _, w := range rw.writes {
+		if w.chaincodeID != chaincodeID {
+			return fmt.Errorf("wrote %s/%s of another chaincode", w.chaincodeID, w.key)
+		}
+		if hints != nil && !containsKey(hints.Writes, w.key) {
+			return fmt.Errorf("wrote %s which its key hints do not declare", w.key)
+		}
+	}
+	return nil
+}
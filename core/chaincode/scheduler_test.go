@@ -0,0 +1,108 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"reflect"
+	"testing"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestBatchDependencies(t *testing.T) {
+	hints := func(reads []string, writes []string) *pb.StateKeyHints {
+		return &pb.StateKeyHints{Reads: reads, Writes: writes}
+	}
+	invoke := &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_TRANSACTION}
+	query := &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_QUERY}
+	items := []*batchItem{
+		{chaincode: "cc1", msg: invoke, hints: hints(nil, []string{"a"})},
+		{chaincode: "cc1", msg: query, hints: hints([]string{"b"}, nil)},
+		// reads what the first one writes
+		{chaincode: "cc1", msg: query, hints: hints([]string{"a"}, nil)},
+		// the same keys in another chaincode do not conflict
+		{chaincode: "cc2", msg: invoke, hints: hints(nil, []string{"a"})},
+		// without hints, waits for every earlier item of its chaincode
+		{chaincode: "cc1", msg: invoke},
+		// reads another key, but after an invoke without hints
+		{chaincode: "cc1", msg: query, hints: hints([]string{"z"}, nil)},
+		{chaincode: "cc2", msg: query, hints: hints([]string{"b"}, nil)},
+		// waits for the invokes before it, not for the queries
+		{chaincode: "cc1", msg: invoke, hints: hints(nil, []string{"y"})},
+	}
+	expected := [][]int{nil, nil, {0}, nil, {0, 1, 2}, {4}, nil, {0, 4}}
+	if deps := batchDependencies(items, false); !reflect.DeepEqual(deps, expected) {
+		t.Fatalf("Expected dependencies %v, got %v", expected, deps)
+	}
+	// Handlers executing invokes concurrently only order the invokes by hints
+	expected = [][]int{nil, nil, {0}, nil, {0, 1, 2}, {4}, nil, {4}}
+	if deps := batchDependencies(items, true); !reflect.DeepEqual(deps, expected) {
+		t.Fatalf("Expected concurrent dependencies %v, got %v", expected, deps)
+	}
+}
+
+func TestKeyHintsConflict(t *testing.T) {
+	reader := &pb.StateKeyHints{Reads: []string{"k"}}
+	writer := &pb.StateKeyHints{Writes: []string{"k"}}
+	other := &pb.StateKeyHints{Reads: []string{"x"}, Writes: []string{"y"}}
+	for _, c := range []struct {
+		a, b     *pb.StateKeyHints
+		conflict bool
+	}{
+		{reader, reader, false},
+		{reader, writer, true},
+		{writer, reader, true},
+		{writer, writer, true},
+		{writer, other, false},
+		{nil, other, true},
+		{other, nil, true},
+	} {
+		if got := keyHintsConflict(c.a, c.b); got != c.conflict {
+			t.Fatalf("Expected conflict of %v and %v to be %v", c.a, c.b, c.conflict)
+		}
+	}
+}
+
+func TestCheckHints(t *testing.T) {
+	rw := newReadWriteSet()
+	rw.reads[stateKey("cc", "r")] = &stateRead{chaincodeID: "cc", key: "r"}
+	rw.reads[stateKey("cc", "w")] = &stateRead{chaincodeID: "cc", key: "w"}
+	rw.putState("cc", "w", []byte("1"))
+	for _, c := range []struct {
+		hints *pb.StateKeyHints
+		ok    bool
+	}{
+		{&pb.StateKeyHints{Reads: []string{"r"}, Writes: []string{"w"}}, true},
+		{&pb.StateKeyHints{Reads: []string{"r", "w"}, Writes: []string{"w", "x"}}, true},
+		{&pb.StateKeyHints{Writes: []string{"w"}}, false},
+		{&pb.StateKeyHints{Reads: []string{"r", "w"}}, false},
+		{nil, true},
+	} {
+		if err := rw.checkHints("cc", c.hints); (err == nil) != c.ok {
+			t.Fatalf("Expected hints %v to match: %v, got %v", c.hints, c.ok, err)
+		}
+	}
+
+	// The state of other chaincodes is never covered by the hints
+	rw.putState("other", "w", []byte("1"))
+	if err := rw.checkHints("cc", nil); err == nil {
+		t.Fatal("Expected a write to another chaincode to be reported")
+	}
+}
@@ -17,10 +17,13 @@ specific language governing permissions and limitations
 under the License.
 */
 
+// Package shim provides APIs for the chaincode to access its state
+// variables, transaction context and call other chaincodes.
 package shim
 
 import (
 	"bytes"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
@@ -35,19 +38,20 @@ import (
 	"github.com/golang/protobuf/proto"
 	"github.com/op/go-logging"
 	"github.com/hyperledger/fabric/core/chaincode/shim/crypto/ecdsa"
+	"github.com/hyperledger/fabric/core/interceptor"
 	pb "github.com/hyperledger/fabric/protos"
 	"github.com/spf13/viper"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/metadata"
+
+	google_protobuf "google/protobuf"
 )
 
 // Logger for the shim package.
 var chaincodeLogger = logging.MustGetLogger("chaincode")
 
-// Handler to shim that handles all control logic.
-var handler *Handler
-
 // Chaincode is the standard chaincode callback interface that the chaincode developer needs to implement.
 type Chaincode interface {
  	// Init method will be called during deployment
@@ -58,10 +62,24 @@ type Chaincode interface {
  	Query(stub *ChaincodeStub, function string, args []string) ([]byte, error)
  }
  
+// EventSchemaDeclarer is implemented by chaincodes declaring the schemas of
+// the events they emit. The schemas are sent at registration, the peer
+// registers them with its event hub, which then validates the payload of the
+// events of these types and serves it as JSON to the consumers asking so.
+type EventSchemaDeclarer interface {
+	EventSchemas() []*pb.ChaincodeEventSchema
+}
+
 // ChaincodeStub for shim side handling.
 type ChaincodeStub struct {
-	UUID            string
+	UUID string
+	// ParentUUID is the UUID of the transaction or query that invoked or
+	// queried this chaincode, empty unless it was called by another chaincode
+	ParentUUID      string
+	handler         *Handler
 	securityContext *pb.ChaincodeSecurityContext
+	transient       map[string][]byte
+	deadline        time.Time
 }
 
 // Peer address derived from command line or env var
@@ -69,6 +87,14 @@ var peerAddress string
 
 // Start entry point for chaincodes bootstrap.
 func Start(cc Chaincode) error {
+	return StartTenants(cc, nil)
+}
+
+// StartTenants bootstraps chaincode cc like Start, along with tenants, other
+// chaincodes served by the same process over the stream of cc. Each tenant
+// registers under its name in tenants once the peer accepted cc, if the peer
+// serves tenants.
+func StartTenants(cc Chaincode, tenants map[string]Chaincode) error {
 	viper.SetEnvPrefix("CORE")
 	viper.AutomaticEnv()
 	replacer := strings.NewReplacer(".", "_")
@@ -91,7 +117,7 @@ func Start(cc Chaincode) error {
 
 	chaincodeSupportClient := pb.NewChaincodeSupportClient(clientConn)
 
-	err = chatWithPeer(chaincodeSupportClient, cc)
+	err = chatWithPeer(chaincodeSupportClient, cc, tenants)
 
 	return err
 }
@@ -109,17 +135,29 @@ func getPeerAddress() string {
 	return peerAddress
 }
 
+// tlsKey returns the configuration key of the TLS settings of the chaincode
+// endpoint of the peer: those of peer.endpoints.chaincode when it is served
+// on its own listener with its own TLS settings, else those of peer.tls
+func tlsKey() string {
+	key := "peer.endpoints.chaincode.tls"
+	if viper.GetString("peer.endpoints.chaincode.listenAddress") != "" && viper.IsSet(key+".enabled") {
+		return key
+	}
+	return "peer.tls"
+}
+
 func newPeerClientConnection() (*grpc.ClientConn, error) {
 	var opts []grpc.DialOption
-	if viper.GetBool("peer.tls.enabled") {
+	tls := tlsKey()
+	if viper.GetBool(tls + ".enabled") {
 		var sn string
-		if viper.GetString("peer.tls.serverhostoverride") != "" {
-			sn = viper.GetString("peer.tls.serverhostoverride")
+		if viper.GetString(tls+".serverhostoverride") != "" {
+			sn = viper.GetString(tls + ".serverhostoverride")
 		}
 		var creds credentials.TransportAuthenticator
-		if viper.GetString("peer.tls.cert.file") != "" {
+		if viper.GetString(tls+".cert.file") != "" {
 			var err error
-			creds, err = credentials.NewClientTLSFromFile(viper.GetString("peer.tls.cert.file"), sn)
+			creds, err = credentials.NewClientTLSFromFile(viper.GetString(tls+".cert.file"), sn)
 			if err != nil {
 				grpclog.Fatalf("Failed to create TLS credentials %v", err)
 			}
@@ -138,36 +176,66 @@ func newPeerClientConnection() (*grpc.ClientConn, error) {
 	return conn, err
 }
 
-func chatWithPeer(chaincodeSupportClient pb.ChaincodeSupportClient, cc Chaincode) error {
+func chatWithPeer(chaincodeSupportClient pb.ChaincodeSupportClient, cc Chaincode, tenants map[string]Chaincode) error {
 
-	// Establish stream with validating peer
-	stream, err := chaincodeSupportClient.Register(context.Background())
+	// Establish stream with validating peer, authenticated with the token
+	// the peer launched the chaincode with
+	ctx := context.Background()
+	if token := viper.GetString("peer.streams.token"); token != "" {
+		ctx = metadata.NewContext(ctx, metadata.Pairs(interceptor.TokenKey, token))
+	}
+	stream, err := chaincodeSupportClient.Register(ctx)
 	if err != nil {
 		return fmt.Errorf("Error chatting with leader at address=%s:  %s", getPeerAddress(), err)
 	}
+	defer stream.CloseSend()
+
+	chaincodeLogger.Debug("Chaincode ID: %s", viper.GetString("chaincode.id.name"))
+	if len(tenants) > 0 {
+		return chatTenants(viper.GetString("chaincode.id.name"), getPeerAddress(), stream, cc, tenants)
+	}
+	return chat(viper.GetString("chaincode.id.name"), getPeerAddress(), stream, cc, nil)
+}
 
-	// Create the chaincode stub which will be passed to the chaincode
-	//stub := &ChaincodeStub{}
+// StartInProc runs chaincode cc compiled into the peer over an in-memory
+// stream, registering it as name. It returns when the stream ends.
+func StartInProc(name string, stream PeerChaincodeStream, cc Chaincode) error {
+	chaincodeLogger.Debug("Starting in-process chaincode %s", name)
+	return chat(name, "in-process", stream, cc, nil)
+}
 
+// chat registers cc as name on stream, along with the names of the tenants
+// sharing the stream, and handles the messages of the peer until the stream
+// ends
+func chat(name string, to string, stream PeerChaincodeStream, cc Chaincode, tenants []string) error {
 	// Create the shim handler responsible for all control logic
-	handler = newChaincodeHandler(getPeerAddress(), stream, cc)
-
-	defer stream.CloseSend()
-	// Send the ChaincodeID during register.
-	chaincodeID := &pb.ChaincodeID{Name: viper.GetString("chaincode.id.name")}
-	chaincodeLogger.Debug("Chaincode ID: %s", viper.GetString("chaincode.id.name"))
+	handler := newChaincodeHandler(to, stream, cc)
+
+	// Send the ChaincodeID, the supported protocol versions and the window during register.
+	registration := &pb.ChaincodeRegistration{Name: name, MinProtocolVersion: pb.MinChaincodeProtocol, MaxProtocolVersion: pb.MaxChaincodeProtocol, Window: receiveWindow, Tenants: tenants}
+	if declarer, ok := cc.(EventSchemaDeclarer); ok {
+		registration.EventSchemas = declarer.EventSchemas()
+	}
+	// The peer that built the image of the chaincode stamped it with the digest of its code package
+	var err error
+	if fingerprint := viper.GetString("chaincode.fingerprint"); fingerprint != "" {
+		if registration.Fingerprint, err = hex.DecodeString(fingerprint); err != nil {
+			return fmt.Errorf("Error decoding chaincode fingerprint %s: %s", fingerprint, err)
+		}
+	}
 
-	payload, err := proto.Marshal(chaincodeID)
+	payload, err := proto.Marshal(registration)
 	if err != nil {
 		return fmt.Errorf("Error marshalling chaincodeID during chaincode registration: %s", err)
 	}
 	// Register on the stream
 	chaincodeLogger.Debug("Registering.. sending %s", pb.ChaincodeMessage_REGISTER)
-	handler.serialSend(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_REGISTER, Payload: payload})
+	// The chain the peer launched the chaincode for, if any, routes the stream
+	handler.serialSend(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_REGISTER, Payload: payload, ChainID: viper.GetString("chaincode.chainid")})
 	waitc := make(chan struct{})
 	go func() {
 		defer close(waitc)
-		msgAvail := make(chan *pb.ChaincodeMessage)
+		msgAvail := make(chan *receivedMessage)
 		var nsInfo *nextStateInfo
 		var in *pb.ChaincodeMessage
 		recv := true
@@ -178,13 +246,13 @@ func chatWithPeer(chaincodeSupportClient pb.ChaincodeSupportClient, cc Chaincode
 			if recv {
 				recv = false
 				go func() {
-					var in2 *pb.ChaincodeMessage
-					in2, err = stream.Recv()
-					msgAvail <- in2
+					in2, err2 := stream.Recv()
+					msgAvail <- &receivedMessage{in2, err2}
 				}()
 			}
 			select {
-			case in = <-msgAvail:
+			case received := <-msgAvail:
+				in, err = received.msg, received.err
 				if err == io.EOF {
 					chaincodeLogger.Debug("Received EOF, ending chaincode stream, %s", err)
 					return
@@ -212,6 +280,12 @@ func chatWithPeer(chaincodeSupportClient pb.ChaincodeSupportClient, cc Chaincode
 				err = fmt.Errorf("Error handling message: %s", err)
 				return
 			}
+			// Messages received from the peer count against the window
+			if nsInfo == nil {
+				if err = handler.consume(in); err != nil {
+					return
+				}
+			}
 			if nsInfo != nil && nsInfo.sendToCC {
 				chaincodeLogger.Debug("[%s]send state message %s", shortuuid(in.Uuid), in.Type.String())
 				if err = handler.serialSend(in); err != nil {
@@ -226,39 +300,73 @@ func chatWithPeer(chaincodeSupportClient pb.ChaincodeSupportClient, cc Chaincode
 }
 
 // -- init stub ---
-func (stub *ChaincodeStub) init(uuid string, secContext *pb.ChaincodeSecurityContext) {
+func (stub *ChaincodeStub) init(handler *Handler, uuid string, secContext *pb.ChaincodeSecurityContext) {
+	stub.handler = handler
 	stub.UUID = uuid
 	stub.securityContext = secContext
 }
 
+func (stub *ChaincodeStub) setDeadline(deadline *google_protobuf.Timestamp) {
+	if deadline != nil {
+		stub.deadline = time.Unix(deadline.Seconds, int64(deadline.Nanos))
+	}
+}
+
+// GetDeadline returns the time by which the peer expects the current
+// transaction or query to complete, ok is false if the peer set none. Past the
+// deadline the peer aborts the invocation and discards its result, long
+// running chaincode should check it and give up early.
+func (stub *ChaincodeStub) GetDeadline() (deadline time.Time, ok bool) {
+	return stub.deadline, !stub.deadline.IsZero()
+}
+
+// GetTransient returns the transient data passed along with the current
+// invocation. Transient data is never written to the ledger, so chaincode
+// must not store it in the state either.
+func (stub *ChaincodeStub) GetTransient() map[string][]byte {
+	return stub.transient
+}
+
 // --------- Security functions ----------
 //CHAINCODE SEC INTERFACE FUNCS TOBE IMPLEMENTED BY ANGELO
 
 // ------------- Call Chaincode functions ---------------
 // InvokeChaincode function can be invoked by a chaincode to execute another chaincode.
 func (stub *ChaincodeStub) InvokeChaincode(chaincodeName string, function string, args []string) ([]byte, error) {
-	return handler.handleInvokeChaincode(chaincodeName, function, args, stub.UUID)
+	return stub.handler.handleInvokeChaincode(chaincodeName, function, args, stub.UUID)
 }
 
 // QueryChaincode function can be invoked by a chaincode to query another chaincode.
+// Unlike InvokeChaincode it can be called from a query as well as a transaction,
+// and the queried chaincode reads the committed state without being able to
+// change it.
 func (stub *ChaincodeStub) QueryChaincode(chaincodeName string, function string, args []string) ([]byte, error) {
-	return handler.handleQueryChaincode(chaincodeName, function, args, stub.UUID)
+	return stub.handler.handleQueryChaincode(chaincodeName, function, args, stub.UUID)
 }
 
 // --------- State functions ----------
 // GetState function can be invoked by a chaincode to get a state from the ledger.
 func (stub *ChaincodeStub) GetState(key string) ([]byte, error) {
-	return handler.handleGetState(key, stub.UUID)
+	return stub.handler.handleGetState(key, stub.UUID)
 }
 
 // PutState function can be invoked by a chaincode to put state into the ledger.
 func (stub *ChaincodeStub) PutState(key string, value []byte) error {
-	return handler.handlePutState(key, value, stub.UUID)
+	return stub.handler.handlePutState(key, value, stub.UUID)
 }
 
 // DelState function can be invoked by a chaincode to delete state from the ledger.
 func (stub *ChaincodeStub) DelState(key string) error {
-	return handler.handleDelState(key, stub.UUID)
+	return stub.handler.handleDelState(key, stub.UUID)
+}
+
+// DelStateRange function can be invoked by a chaincode to delete all the keys
+// with prefix in one request. Nothing is deleted if more than maxKeys keys
+// have the prefix, the bound of the peer applies if maxKeys is 0 or above it.
+// On a dry run the keys are only counted. It returns the number of keys
+// deleted, or that would be.
+func (stub *ChaincodeStub) DelStateRange(prefix string, maxKeys int, dryRun bool) (int, error) {
+	return stub.handler.handleDelStateRange(prefix, maxKeys, dryRun, stub.UUID)
 }
 
 // StateRangeQueryIterator allows a chaincode to iterate over a range of
@@ -276,11 +384,11 @@ type StateRangeQueryIterator struct {
 // between the startKey and endKey, inclusive. The order in which keys are
 // returned by the iterator is random.
 func (stub *ChaincodeStub) RangeQueryState(startKey, endKey string) (*StateRangeQueryIterator, error) {
-	response, err := handler.handleRangeQueryState(startKey, endKey, stub.UUID)
+	response, err := stub.handler.handleRangeQueryState(startKey, endKey, stub.UUID)
 	if err != nil {
 		return nil, err
 	}
-	return &StateRangeQueryIterator{handler, stub.UUID, response, 0}, nil
+	return &StateRangeQueryIterator{stub.handler, stub.UUID, response, 0}, nil
 }
 
 // HasNext returns true if the range query iterator contains additional keys
@@ -323,6 +431,49 @@ func (iter *StateRangeQueryIterator) Close() error {
 	return err
 }
 
+// HistoryQueryIterator allows a chaincode to iterate over the values taken
+// by a key.
+type HistoryQueryIterator struct {
+	rangeIter *StateRangeQueryIterator
+}
+
+// GetHistoryForKey function can be invoked by a chaincode to read the values
+// taken by a key in the committed blocks, from the oldest, along with the
+// transactions that set or deleted it. The changes made by the transactions
+// of the block being built are not returned.
+func (stub *ChaincodeStub) GetHistoryForKey(key string) (*HistoryQueryIterator, error) {
+	response, err := stub.handler.handleGetHistoryForKey(key, stub.UUID)
+	if err != nil {
+		return nil, err
+	}
+	return &HistoryQueryIterator{&StateRangeQueryIterator{stub.handler, stub.UUID, response, 0}}, nil
+}
+
+// HasNext returns true if the history query iterator contains additional
+// values.
+func (iter *HistoryQueryIterator) HasNext() bool {
+	return iter.rangeIter.HasNext()
+}
+
+// Next returns the next value in the history query iterator.
+func (iter *HistoryQueryIterator) Next() (*pb.KeyModification, error) {
+	_, value, err := iter.rangeIter.Next()
+	if err != nil {
+		return nil, err
+	}
+	modification := &pb.KeyModification{}
+	if err := proto.Unmarshal(value, modification); err != nil {
+		return nil, err
+	}
+	return modification, nil
+}
+
+// Close closes the history query iterator. This should be called when done
+// reading from the iterator to free up resources.
+func (iter *HistoryQueryIterator) Close() error {
+	return iter.rangeIter.Close()
+}
+
 // TABLE FUNCTIONALITY
 // TODO More comments here with documentation
 
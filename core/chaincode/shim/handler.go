@@ -22,7 +22,9 @@ package shim
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/looplab/fsm"
@@ -40,6 +42,13 @@ type nextStateInfo struct {
 	sendToCC bool
 }
 
+// receivedMessage is the result of a Recv on the chat stream, handed from the
+// receiving goroutine to the one handling the messages
+type receivedMessage struct {
+	msg *pb.ChaincodeMessage
+	err error
+}
+
 func (handler *Handler) triggerNextState(msg *pb.ChaincodeMessage, send bool) {
 	handler.nextState <- &nextStateInfo{msg, send}
 }
@@ -57,8 +66,36 @@ type Handler struct {
 	// Track which UUIDs are transactions and which are queries, to decide whether get/put state and invoke chaincode are allowed.
 	isTransaction map[string]bool
 	nextState     chan *nextStateInfo
+	// Chaincode protocol version selected by the peer at registration
+	protocolVersion int32
+	// Responses being reassembled from RESPONSE_CHUNK messages, by Uuid
+	chunks map[string]*chunkedResponse
+	// Window granted by the peer at registration, 0 without flow control, and
+	// the messages consumed since credits were last returned to the peer
+	window   uint32
+	consumed uint32
+	// Trace context of the TRANSACTION, QUERY or INIT being executed, by Uuid,
+	// copied onto the requests made on their behalf
+	traceContexts map[string]*pb.TraceContext
+}
+
+// chunkedResponse accumulates the chunks of a response in sequence
+type chunkedResponse struct {
+	next    uint32
+	payload []byte
 }
 
+// State requests rejected with RETRY_LATER are resent up to retryLaterAttempts
+// times, doubling the delay from retryLaterBackoff between attempts
+const (
+	retryLaterAttempts = 8
+	retryLaterBackoff  = 10 * time.Millisecond
+)
+
+// Number of messages the shim asks the peer to send at most before it returns
+// credits for those it consumed
+const receiveWindow uint32 = 64
+
 func shortuuid(uuid string) string {
 	if len(uuid) < 8 {
 		return uuid
@@ -69,6 +106,9 @@ func shortuuid(uuid string) string {
 func (handler *Handler) serialSend(msg *pb.ChaincodeMessage) error {
 	handler.Lock()
 	defer handler.Unlock()
+	if msg.TraceContext == nil {
+		msg.TraceContext = handler.traceContexts[msg.Uuid]
+	}
 	if err := handler.ChatStream.Send(msg); err != nil {
 		chaincodeLogger.Error(fmt.Sprintf("[%s]Error sending %s: %s", shortuuid(msg.Uuid), msg.Type.String(), err))
 		return fmt.Errorf("Error sending %s: %s", msg.Type.String(), err)
@@ -107,11 +147,68 @@ func (handler *Handler) sendChannel(msg *pb.ChaincodeMessage) error {
 	return nil
 }
 
+// receiveChannel waits for the response on c. A response the peer spilled is
+// fetched and returned whole.
 func (handler *Handler) receiveChannel(c chan pb.ChaincodeMessage) (pb.ChaincodeMessage, bool) {
 	msg, val := <-c
+	if val && msg.Type == pb.ChaincodeMessage_RESULT_SPILLED {
+		resp, err := handler.fetchSpilled(&msg, c)
+		if err != nil {
+			chaincodeLogger.Error(fmt.Sprintf("[%s]Error fetching spilled response: %s", shortuuid(msg.Uuid), err))
+			payload := pb.ChaincodeErrorPayload(handler.protocolVersion, pb.NewChaincodeError(pb.ChaincodeError_MALFORMED, err, nil))
+			return pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid, Timestamp: msg.Timestamp}, true
+		}
+		return resp, true
+	}
 	return msg, val
 }
 
+// fetchSpilled reads the payload of a RESULT_SPILLED from the peer with
+// FETCH_RESULT, piece by piece, and returns it as a RESPONSE. An ERROR the
+// peer answers with is returned as is.
+func (handler *Handler) fetchSpilled(spilled *pb.ChaincodeMessage, c chan pb.ChaincodeMessage) (pb.ChaincodeMessage, error) {
+	ref := &pb.ChaincodeResultRef{}
+	if err := proto.Unmarshal(spilled.Payload, ref); err != nil {
+		return pb.ChaincodeMessage{}, fmt.Errorf("Error unmarshalling %s: %s", spilled.Type, err)
+	}
+	payload := make([]byte, 0, ref.Size)
+	for uint64(len(payload)) < ref.Size {
+		fetch, err := proto.Marshal(&pb.FetchResult{Handle: ref.Handle, Offset: uint64(len(payload))})
+		if err != nil {
+			return pb.ChaincodeMessage{}, fmt.Errorf("Error marshalling %s: %s", pb.ChaincodeMessage_FETCH_RESULT, err)
+		}
+		if err = handler.serialSend(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_FETCH_RESULT, Payload: fetch, Uuid: spilled.Uuid}); err != nil {
+			return pb.ChaincodeMessage{}, fmt.Errorf("Error sending %s: %s", pb.ChaincodeMessage_FETCH_RESULT, err)
+		}
+		piece, ok := <-c
+		if !ok {
+			return pb.ChaincodeMessage{}, fmt.Errorf("Response channel closed fetching %s", ref.Handle)
+		}
+		if piece.Type != pb.ChaincodeMessage_RESPONSE {
+			return piece, nil
+		}
+		if len(piece.Payload) == 0 || uint64(len(payload)+len(piece.Payload)) > ref.Size {
+			return pb.ChaincodeMessage{}, fmt.Errorf("Invalid piece of %d bytes of %s at offset %d", len(piece.Payload), ref.Handle, len(payload))
+		}
+		payload = append(payload, piece.Payload...)
+	}
+	chaincodeLogger.Debug("[%s]Fetched spilled response %s of %d bytes", shortuuid(spilled.Uuid), ref.Handle, ref.Size)
+	return pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: payload, Uuid: spilled.Uuid, Timestamp: spilled.Timestamp}, nil
+}
+
+// abortChannel hands an abort to the state request pending for its UUID, if
+// the chaincode is currently waiting on one
+func (handler *Handler) abortChannel(msg *pb.ChaincodeMessage) {
+	handler.Lock()
+	defer handler.Unlock()
+	if c := handler.responseChannel[msg.Uuid]; c != nil {
+		select {
+		case c <- *msg:
+		default:
+		}
+	}
+}
+
 func (handler *Handler) deleteChannel(uuid string) {
 	handler.Lock()
 	defer handler.Unlock()
@@ -139,6 +236,26 @@ func (handler *Handler) deleteIsTransaction(uuid string) {
 	handler.Unlock()
 }
 
+// setTraceContext remembers the trace context of the execution of uuid until
+// deleteTraceContext, a nil context is not remembered
+func (handler *Handler) setTraceContext(uuid string, tc *pb.TraceContext) {
+	if tc == nil {
+		return
+	}
+	handler.Lock()
+	defer handler.Unlock()
+	if handler.traceContexts == nil {
+		handler.traceContexts = make(map[string]*pb.TraceContext)
+	}
+	handler.traceContexts[uuid] = tc
+}
+
+func (handler *Handler) deleteTraceContext(uuid string) {
+	handler.Lock()
+	delete(handler.traceContexts, uuid)
+	handler.Unlock()
+}
+
 // NewChaincodeHandler returns a new instance of the shim side handler.
 func newChaincodeHandler(to string, peerChatStream PeerChaincodeStream, chaincode Chaincode) *Handler {
 	v := &Handler{
@@ -149,6 +266,7 @@ func newChaincodeHandler(to string, peerChatStream PeerChaincodeStream, chaincod
 	v.responseChannel = make(map[string]chan pb.ChaincodeMessage)
 	v.isTransaction = make(map[string]bool)
 	v.nextState = make(chan *nextStateInfo)
+	v.chunks = make(map[string]*chunkedResponse)
 
 	// Create the shim side FSM
 	v.FSM = fsm.NewFSM(
@@ -156,6 +274,7 @@ func newChaincodeHandler(to string, peerChatStream PeerChaincodeStream, chaincod
 		fsm.Events{
 			{Name: pb.ChaincodeMessage_REGISTERED.String(), Src: []string{"created"}, Dst: "established"},
 			{Name: pb.ChaincodeMessage_INIT.String(), Src: []string{"established"}, Dst: "init"},
+			{Name: pb.ChaincodeMessage_UPGRADE.String(), Src: []string{"established"}, Dst: "init"},
 			{Name: pb.ChaincodeMessage_READY.String(), Src: []string{"established"}, Dst: "ready"},
 			{Name: pb.ChaincodeMessage_ERROR.String(), Src: []string{"init"}, Dst: "established"},
 			{Name: pb.ChaincodeMessage_RESPONSE.String(), Src: []string{"init"}, Dst: "init"},
@@ -167,6 +286,7 @@ func newChaincodeHandler(to string, peerChatStream PeerChaincodeStream, chaincod
 			{Name: pb.ChaincodeMessage_QUERY.String(), Src: []string{"transaction"}, Dst: "transaction"},
 			{Name: pb.ChaincodeMessage_QUERY.String(), Src: []string{"ready"}, Dst: "ready"},
 			{Name: pb.ChaincodeMessage_RESPONSE.String(), Src: []string{"ready"}, Dst: "ready"},
+			{Name: pb.ChaincodeMessage_ERROR.String(), Src: []string{"ready"}, Dst: "ready"},
 		},
 		fsm.Callbacks{
 			"before_" + pb.ChaincodeMessage_REGISTERED.String(): func(e *fsm.Event) { v.beforeRegistered(e) },
@@ -185,11 +305,88 @@ func newChaincodeHandler(to string, peerChatStream PeerChaincodeStream, chaincod
 
 // beforeRegistered is called to handle the REGISTERED message.
 func (handler *Handler) beforeRegistered(e *fsm.Event) {
-	if _, ok := e.Args[0].(*pb.ChaincodeMessage); !ok {
+	msg, ok := e.Args[0].(*pb.ChaincodeMessage)
+	if !ok {
 		e.Cancel(fmt.Errorf("Received unexpected message type"))
 		return
 	}
-	chaincodeLogger.Debug("Received %s, ready for invocations", pb.ChaincodeMessage_REGISTERED)
+	// Peers that predate protocol negotiation send no payload and speak version 1
+	handler.protocolVersion = pb.ChaincodeProtocolV1
+	if len(msg.Payload) > 0 {
+		protocol := &pb.ChaincodeProtocol{}
+		if err := proto.Unmarshal(msg.Payload, protocol); err != nil {
+			e.Cancel(fmt.Errorf("Error unmarshalling %s payload: %s", pb.ChaincodeMessage_REGISTERED, err))
+			return
+		}
+		handler.protocolVersion = protocol.Version
+		handler.window = protocol.Window
+	}
+	chaincodeLogger.Debug("Received %s with protocol version %d and window %d, ready for invocations", pb.ChaincodeMessage_REGISTERED, handler.protocolVersion, handler.window)
+	if declarer, ok := handler.cc.(EventSchemaDeclarer); ok && handler.protocolVersion < pb.ChaincodeProtocolV12 && len(declarer.EventSchemas()) > 0 {
+		chaincodeLogger.Warning("The peer speaks chaincode protocol version %d and did not register the event schemas of the chaincode", handler.protocolVersion)
+	}
+}
+
+// consume accounts for a message received from the peer once it has been
+// handled, returning credits to the peer when half of the window is consumed
+func (handler *Handler) consume(msg *pb.ChaincodeMessage) error {
+	// KEEPALIVE is sent by the peer outside of the window
+	if handler.window == 0 || msg.Type == pb.ChaincodeMessage_REGISTERED || msg.Type == pb.ChaincodeMessage_KEEPALIVE {
+		return nil
+	}
+	handler.consumed++
+	if handler.consumed < (handler.window+1)/2 {
+		return nil
+	}
+	payload, err := proto.Marshal(&pb.ChaincodeCredit{Credits: handler.consumed})
+	if err != nil {
+		return fmt.Errorf("Error marshalling %s: %s", pb.ChaincodeMessage_CREDIT, err)
+	}
+	handler.consumed = 0
+	return handler.serialSend(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_CREDIT, Payload: payload})
+}
+
+// isRetryLater returns true if msg rejects a request that should be sent again
+func (handler *Handler) isRetryLater(msg *pb.ChaincodeMessage) bool {
+	if handler.protocolVersion < pb.ChaincodeProtocolV2 || msg.Type != pb.ChaincodeMessage_ERROR {
+		return false
+	}
+	if handler.protocolVersion >= pb.ChaincodeProtocolV6 {
+		return pb.ChaincodeErrorFromPayload(handler.protocolVersion, msg.Payload).Code == pb.ChaincodeError_RETRY_LATER
+	}
+	return strings.HasPrefix(string(msg.Payload), pb.ChaincodeRetryLater)
+}
+
+// responseError returns the error carried by an ERROR response. From protocol
+// V6 on it is a *pb.ChaincodeError the chaincode can inspect for its code.
+func (handler *Handler) responseError(msg *pb.ChaincodeMessage) error {
+	return pb.ChaincodeErrorFromPayload(handler.protocolVersion, msg.Payload)
+}
+
+// sendReceive sends a state request and waits for its response on respChan.
+// Requests the peer is too busy to process are sent again after a backoff.
+func (handler *Handler) sendReceive(msg *pb.ChaincodeMessage, respChan chan pb.ChaincodeMessage) (pb.ChaincodeMessage, error) {
+	backoff := retryLaterBackoff
+	for attempt := 1; ; attempt++ {
+		chaincodeLogger.Debug("[%s]Sending %s", shortuuid(msg.Uuid), msg.Type)
+		if err := handler.serialSend(msg); err != nil {
+			chaincodeLogger.Error(fmt.Sprintf("[%s]error sending %s: %s", shortuuid(msg.Uuid), msg.Type, err))
+			return pb.ChaincodeMessage{}, errors.New("could not send msg")
+		}
+
+		// Wait on responseChannel for response
+		responseMsg, ok := handler.receiveChannel(respChan)
+		if !ok {
+			chaincodeLogger.Error(fmt.Sprintf("[%s]Received unexpected message type", shortuuid(msg.Uuid)))
+			return pb.ChaincodeMessage{}, errors.New("Received unexpected message type")
+		}
+		if attempt == retryLaterAttempts || !handler.isRetryLater(&responseMsg) {
+			return responseMsg, nil
+		}
+		chaincodeLogger.Debug("[%s]Peer busy, sending %s again in %s", shortuuid(msg.Uuid), msg.Type, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
 }
 
 // handleInit handles request to initialize chaincode.
@@ -219,15 +416,18 @@ func (handler *Handler) handleInit(msg *pb.ChaincodeMessage) {
 
 		// Mark as a transaction (allow put/del state)
 		handler.markIsTransaction(msg.Uuid, true)
+		handler.setTraceContext(msg.Uuid, msg.TraceContext)
 
 		// Call chaincode's Run
 		// Create the ChaincodeStub which the chaincode can use to callback
 		stub := new(ChaincodeStub)
-		stub.init(msg.Uuid, msg.SecurityContext)
+		stub.init(handler, msg.Uuid, msg.SecurityContext)
+		stub.transient = input.Transient
 		res, err := handler.cc.Init(stub, input.Function, input.Args)
 
 		// delete isTransaction entry
 		handler.deleteIsTransaction(msg.Uuid)
+		handler.deleteTraceContext(msg.Uuid)
 
 		if err != nil {
 			payload := []byte(err.Error())
@@ -252,8 +452,8 @@ func (handler *Handler) enterInitState(e *fsm.Event) {
 		return
 	}
 	chaincodeLogger.Debug("[%s]Received %s, initializing chaincode", shortuuid(msg.Uuid), msg.Type.String())
-	if msg.Type.String() == pb.ChaincodeMessage_INIT.String() {
-		// Call the chaincode's Run function to initialize
+	if msg.Type.String() == pb.ChaincodeMessage_INIT.String() || msg.Type.String() == pb.ChaincodeMessage_UPGRADE.String() {
+		// Call the chaincode's Run function to initialize (or migrate on upgrade)
 		handler.handleInit(msg)
 	}
 }
@@ -286,15 +486,20 @@ func (handler *Handler) handleTransaction(msg *pb.ChaincodeMessage) {
 
 		// Mark as a transaction (allow put/del state)
 		handler.markIsTransaction(msg.Uuid, true)
+		handler.setTraceContext(msg.Uuid, msg.TraceContext)
 
 		// Call chaincode's Run
 		// Create the ChaincodeStub which the chaincode can use to callback
 		stub := new(ChaincodeStub)
-		stub.init(msg.Uuid, msg.SecurityContext)
+		stub.init(handler, msg.Uuid, msg.SecurityContext)
+		stub.transient = input.Transient
+		stub.ParentUUID = msg.ParentUuid
+		stub.setDeadline(msg.Deadline)
 		res, err := handler.cc.Invoke(stub, input.Function, input.Args)
 
 		// delete isTransaction entry
 		handler.deleteIsTransaction(msg.Uuid)
+		handler.deleteTraceContext(msg.Uuid)
 
 		if err != nil {
 			payload := []byte(err.Error())
@@ -333,15 +538,20 @@ func (handler *Handler) handleQuery(msg *pb.ChaincodeMessage) {
 
 		// Mark as a query (do not allow put/del state)
 		handler.markIsTransaction(msg.Uuid, false)
+		handler.setTraceContext(msg.Uuid, msg.TraceContext)
 
 		// Call chaincode's Query
 		// Create the ChaincodeStub which the chaincode can use to callback
 		stub := new(ChaincodeStub)
-		stub.init(msg.Uuid, msg.SecurityContext)
+		stub.init(handler, msg.Uuid, msg.SecurityContext)
+		stub.transient = input.Transient
+		stub.ParentUUID = msg.ParentUuid
+		stub.setDeadline(msg.Deadline)
 		res, err := handler.cc.Query(stub, input.Function, input.Args)
 
 		// delete isTransaction entry
 		handler.deleteIsTransaction(msg.Uuid)
+		handler.deleteTraceContext(msg.Uuid)
 
 		if err != nil {
 			payload := []byte(err.Error())
@@ -446,17 +656,9 @@ func (handler *Handler) handleGetState(key string, uuid string) ([]byte, error)
 	// Send GET_STATE message to validator chaincode support
 	payload := []byte(key)
 	msg := &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_GET_STATE, Payload: payload, Uuid: uuid}
-	chaincodeLogger.Debug("[%s]Sending %s", shortuuid(msg.Uuid), pb.ChaincodeMessage_GET_STATE)
-	if err := handler.serialSend(msg); err != nil {
-		chaincodeLogger.Error(fmt.Sprintf("[%s]error sending GET_STATE %s", shortuuid(uuid), err))
-		return nil, errors.New("could not send msg")
-	}
-
-	// Wait on responseChannel for response
-	responseMsg, ok := handler.receiveChannel(respChan)
-	if !ok {
-		chaincodeLogger.Error(fmt.Sprintf("[%s]Received unexpected message type", shortuuid(responseMsg.Uuid)))
-		return nil, errors.New("Received unexpected message type")
+	responseMsg, err := handler.sendReceive(msg, respChan)
+	if err != nil {
+		return nil, err
 	}
 
 	if responseMsg.Type.String() == pb.ChaincodeMessage_RESPONSE.String() {
@@ -467,7 +669,7 @@ func (handler *Handler) handleGetState(key string, uuid string) ([]byte, error)
 	if responseMsg.Type.String() == pb.ChaincodeMessage_ERROR.String() {
 		// Error response
 		chaincodeLogger.Error(fmt.Sprintf("[%s]GetState received error %s", shortuuid(responseMsg.Uuid), pb.ChaincodeMessage_ERROR))
-		return nil, errors.New(string(responseMsg.Payload[:]))
+		return nil, handler.responseError(&responseMsg)
 	}
 
 	// Incorrect chaincode message received
@@ -521,8 +723,8 @@ func (handler *Handler) handlePutState(key string, value []byte, uuid string) er
 
 	if responseMsg.Type.String() == pb.ChaincodeMessage_ERROR.String() {
 		// Error response
-		chaincodeLogger.Error(fmt.Sprintf("[%s]Received %s. Payload: %s", shortuuid(responseMsg.Uuid), pb.ChaincodeMessage_ERROR, responseMsg.Payload))
-		return errors.New(string(responseMsg.Payload[:]))
+		chaincodeLogger.Error(fmt.Sprintf("[%s]Received %s. Payload: %s", shortuuid(responseMsg.Uuid), pb.ChaincodeMessage_ERROR, handler.responseError(&responseMsg)))
+		return handler.responseError(&responseMsg)
 	}
 
 	// Incorrect chaincode message received
@@ -569,8 +771,8 @@ func (handler *Handler) handleDelState(key string, uuid string) error {
 	}
 	if responseMsg.Type.String() == pb.ChaincodeMessage_ERROR.String() {
 		// Error response
-		chaincodeLogger.Error(fmt.Sprintf("[%s]Received %s. Payload: %s", msg.Uuid, pb.ChaincodeMessage_ERROR, responseMsg.Payload))
-		return errors.New(string(responseMsg.Payload[:]))
+		chaincodeLogger.Error(fmt.Sprintf("[%s]Received %s. Payload: %s", msg.Uuid, pb.ChaincodeMessage_ERROR, handler.responseError(&responseMsg)))
+		return handler.responseError(&responseMsg)
 	}
 
 	// Incorrect chaincode message received
@@ -595,17 +797,9 @@ func (handler *Handler) handleRangeQueryState(startKey, endKey string, uuid stri
 		return nil, errors.New("Failed to process range query state request")
 	}
 	msg := &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RANGE_QUERY_STATE, Payload: payloadBytes, Uuid: uuid}
-	chaincodeLogger.Debug("[%s]Sending %s", shortuuid(msg.Uuid), pb.ChaincodeMessage_RANGE_QUERY_STATE)
-	if err = handler.serialSend(msg); err != nil {
-		chaincodeLogger.Error(fmt.Sprintf("[%s]error sending %s", shortuuid(msg.Uuid), pb.ChaincodeMessage_RANGE_QUERY_STATE))
-		return nil, errors.New("could not send msg")
-	}
-
-	// Wait on responseChannel for response
-	responseMsg, ok := handler.receiveChannel(respChan)
-	if !ok {
-		chaincodeLogger.Error(fmt.Sprintf("[%s]Received unexpected message type", uuid))
-		return nil, errors.New("Received unexpected message type")
+	responseMsg, err := handler.sendReceive(msg, respChan)
+	if err != nil {
+		return nil, err
 	}
 
 	if responseMsg.Type.String() == pb.ChaincodeMessage_RESPONSE.String() {
@@ -624,7 +818,7 @@ func (handler *Handler) handleRangeQueryState(startKey, endKey string, uuid stri
 	if responseMsg.Type.String() == pb.ChaincodeMessage_ERROR.String() {
 		// Error response
 		chaincodeLogger.Error(fmt.Sprintf("[%s]Received %s", shortuuid(responseMsg.Uuid), pb.ChaincodeMessage_ERROR))
-		return nil, errors.New(string(responseMsg.Payload[:]))
+		return nil, handler.responseError(&responseMsg)
 	}
 
 	// Incorrect chaincode message received
@@ -649,17 +843,9 @@ func (handler *Handler) handleRangeQueryStateNext(id, uuid string) (*pb.RangeQue
 		return nil, errors.New("Failed to process range query state next request")
 	}
 	msg := &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RANGE_QUERY_STATE_NEXT, Payload: payloadBytes, Uuid: uuid}
-	chaincodeLogger.Debug("[%s]Sending %s", shortuuid(msg.Uuid), pb.ChaincodeMessage_RANGE_QUERY_STATE_NEXT)
-	if err = handler.serialSend(msg); err != nil {
-		chaincodeLogger.Error(fmt.Sprintf("[%s]error sending %s", shortuuid(msg.Uuid), pb.ChaincodeMessage_RANGE_QUERY_STATE_NEXT))
-		return nil, errors.New("could not send msg")
-	}
-
-	// Wait on responseChannel for response
-	responseMsg, ok := handler.receiveChannel(respChan)
-	if !ok {
-		chaincodeLogger.Error(fmt.Sprintf("[%s]Received unexpected message type", uuid))
-		return nil, errors.New("Received unexpected message type")
+	responseMsg, err := handler.sendReceive(msg, respChan)
+	if err != nil {
+		return nil, err
 	}
 
 	if responseMsg.Type.String() == pb.ChaincodeMessage_RESPONSE.String() {
@@ -678,7 +864,7 @@ func (handler *Handler) handleRangeQueryStateNext(id, uuid string) (*pb.RangeQue
 	if responseMsg.Type.String() == pb.ChaincodeMessage_ERROR.String() {
 		// Error response
 		chaincodeLogger.Error(fmt.Sprintf("[%s]Received %s", shortuuid(responseMsg.Uuid), pb.ChaincodeMessage_ERROR))
-		return nil, errors.New(string(responseMsg.Payload[:]))
+		return nil, handler.responseError(&responseMsg)
 	}
 
 	// Incorrect chaincode message received
@@ -703,17 +889,9 @@ func (handler *Handler) handleRangeQueryStateClose(id, uuid string) (*pb.RangeQu
 		return nil, errors.New("Failed to process range query state close request")
 	}
 	msg := &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RANGE_QUERY_STATE_CLOSE, Payload: payloadBytes, Uuid: uuid}
-	chaincodeLogger.Debug("[%s]Sending %s", shortuuid(msg.Uuid), pb.ChaincodeMessage_RANGE_QUERY_STATE_CLOSE)
-	if err = handler.serialSend(msg); err != nil {
-		chaincodeLogger.Error(fmt.Sprintf("[%s]error sending %s", shortuuid(msg.Uuid), pb.ChaincodeMessage_RANGE_QUERY_STATE_CLOSE))
-		return nil, errors.New("could not send msg")
-	}
-
-	// Wait on responseChannel for response
-	responseMsg, ok := handler.receiveChannel(respChan)
-	if !ok {
-		chaincodeLogger.Error(fmt.Sprintf("[%s]Received unexpected message type", uuid))
-		return nil, errors.New("Received unexpected message type")
+	responseMsg, err := handler.sendReceive(msg, respChan)
+	if err != nil {
+		return nil, err
 	}
 
 	if responseMsg.Type.String() == pb.ChaincodeMessage_RESPONSE.String() {
@@ -732,7 +910,106 @@ func (handler *Handler) handleRangeQueryStateClose(id, uuid string) (*pb.RangeQu
 	if responseMsg.Type.String() == pb.ChaincodeMessage_ERROR.String() {
 		// Error response
 		chaincodeLogger.Error(fmt.Sprintf("[%s]Received %s", shortuuid(responseMsg.Uuid), pb.ChaincodeMessage_ERROR))
-		return nil, errors.New(string(responseMsg.Payload[:]))
+		return nil, handler.responseError(&responseMsg)
+	}
+
+	// Incorrect chaincode message received
+	chaincodeLogger.Error(fmt.Sprintf("Incorrect chaincode message %s recieved. Expecting %s or %s", responseMsg.Type, pb.ChaincodeMessage_RESPONSE, pb.ChaincodeMessage_ERROR))
+	return nil, errors.New("Incorrect chaincode message received")
+}
+
+// handleDelStateRange communicates with the validator to delete the keys with
+// prefix, or count them on a dry run. It returns the number of keys.
+func (handler *Handler) handleDelStateRange(prefix string, maxKeys int, dryRun bool, uuid string) (int, error) {
+	if handler.protocolVersion < pb.ChaincodeProtocolV8 {
+		return 0, fmt.Errorf("The peer speaks chaincode protocol version %d and cannot serve %s", handler.protocolVersion, pb.ChaincodeMessage_DEL_STATE_RANGE)
+	}
+	// Check if this is a transaction
+	if !handler.isTransaction[uuid] {
+		return 0, errors.New("Cannot del state in query context")
+	}
+
+	// Create the channel on which to communicate the response from validating peer
+	respChan, uniqueReqErr := handler.createChannel(uuid)
+	if uniqueReqErr != nil {
+		chaincodeLogger.Debug("[%s]Another state request pending for this Uuid. Cannot process.", shortuuid(uuid))
+		return 0, uniqueReqErr
+	}
+
+	defer handler.deleteChannel(uuid)
+
+	// Send DEL_STATE_RANGE message to validator chaincode support
+	payload, err := proto.Marshal(&pb.DelStateRange{Prefix: prefix, MaxKeys: int32(maxKeys), DryRun: dryRun})
+	if err != nil {
+		return 0, errors.New("Failed to process del state range request")
+	}
+	msg := &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_DEL_STATE_RANGE, Payload: payload, Uuid: uuid}
+	responseMsg, err := handler.sendReceive(msg, respChan)
+	if err != nil {
+		return 0, err
+	}
+
+	if responseMsg.Type.String() == pb.ChaincodeMessage_RESPONSE.String() {
+		// Success response
+		delResponse := &pb.DelStateRangeResponse{}
+		if unmarshalErr := proto.Unmarshal(responseMsg.Payload, delResponse); unmarshalErr != nil {
+			chaincodeLogger.Error(fmt.Sprintf("[%s]unmarshall error", shortuuid(responseMsg.Uuid)))
+			return 0, errors.New("Error unmarshalling DelStateRangeResponse.")
+		}
+		chaincodeLogger.Debug("[%s]Received %s. %d keys with prefix %s (dry run: %t)", shortuuid(responseMsg.Uuid), pb.ChaincodeMessage_RESPONSE, delResponse.Count, prefix, dryRun)
+		return int(delResponse.Count), nil
+	}
+	if responseMsg.Type.String() == pb.ChaincodeMessage_ERROR.String() {
+		// Error response
+		chaincodeLogger.Error(fmt.Sprintf("[%s]Received %s. Payload: %s", shortuuid(responseMsg.Uuid), pb.ChaincodeMessage_ERROR, handler.responseError(&responseMsg)))
+		return 0, handler.responseError(&responseMsg)
+	}
+
+	// Incorrect chaincode message received
+	chaincodeLogger.Error(fmt.Sprintf("Incorrect chaincode message %s recieved. Expecting %s or %s", responseMsg.Type, pb.ChaincodeMessage_RESPONSE, pb.ChaincodeMessage_ERROR))
+	return 0, errors.New("Incorrect chaincode message received")
+}
+
+// handleGetHistoryForKey communicates with the validator to fetch the first
+// values taken by key, the next ones being fetched with handleRangeQueryStateNext.
+func (handler *Handler) handleGetHistoryForKey(key string, uuid string) (*pb.RangeQueryStateResponse, error) {
+	if handler.protocolVersion < pb.ChaincodeProtocolV5 {
+		return nil, fmt.Errorf("The peer speaks chaincode protocol version %d and cannot serve %s", handler.protocolVersion, pb.ChaincodeMessage_GET_HISTORY_FOR_KEY)
+	}
+
+	// Create the channel on which to communicate the response from validating peer
+	respChan, uniqueReqErr := handler.createChannel(uuid)
+	if uniqueReqErr != nil {
+		chaincodeLogger.Debug("[%s]Another state request pending for this Uuid. Cannot process.", shortuuid(uuid))
+		return nil, uniqueReqErr
+	}
+
+	defer handler.deleteChannel(uuid)
+
+	// Send GET_HISTORY_FOR_KEY message to validator chaincode support
+	msg := &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_GET_HISTORY_FOR_KEY, Payload: []byte(key), Uuid: uuid}
+	responseMsg, err := handler.sendReceive(msg, respChan)
+	if err != nil {
+		return nil, err
+	}
+
+	if responseMsg.Type.String() == pb.ChaincodeMessage_RESPONSE.String() {
+		// Success response
+		chaincodeLogger.Debug("[%s]Received %s. Successfully got history", shortuuid(responseMsg.Uuid), pb.ChaincodeMessage_RESPONSE)
+
+		historyResponse := &pb.RangeQueryStateResponse{}
+		unmarshalErr := proto.Unmarshal(responseMsg.Payload, historyResponse)
+		if unmarshalErr != nil {
+			chaincodeLogger.Error(fmt.Sprintf("[%s]unmarshall error", shortuuid(responseMsg.Uuid)))
+			return nil, errors.New("Error unmarshalling RangeQueryStateResponse.")
+		}
+
+		return historyResponse, nil
+	}
+	if responseMsg.Type.String() == pb.ChaincodeMessage_ERROR.String() {
+		// Error response
+		chaincodeLogger.Error(fmt.Sprintf("[%s]Received %s", shortuuid(responseMsg.Uuid), pb.ChaincodeMessage_ERROR))
+		return nil, handler.responseError(&responseMsg)
 	}
 
 	// Incorrect chaincode message received
@@ -787,7 +1064,7 @@ func (handler *Handler) handleInvokeChaincode(chaincodeName string, function str
 	if responseMsg.Type.String() == pb.ChaincodeMessage_ERROR.String() {
 		// Error response
 		chaincodeLogger.Error(fmt.Sprintf("[%s]Received %s.", shortuuid(responseMsg.Uuid), pb.ChaincodeMessage_ERROR))
-		return nil, errors.New(string(responseMsg.Payload[:]))
+		return nil, handler.responseError(&responseMsg)
 	}
 
 	// Incorrect chaincode message received
@@ -837,7 +1114,7 @@ func (handler *Handler) handleQueryChaincode(chaincodeName string, function stri
 	if responseMsg.Type.String() == pb.ChaincodeMessage_ERROR.String() {
 		// Error response
 		chaincodeLogger.Error(fmt.Sprintf("[%s]Received %s.", shortuuid(responseMsg.Uuid), pb.ChaincodeMessage_ERROR))
-		return nil, errors.New(string(responseMsg.Payload[:]))
+		return nil, handler.responseError(&responseMsg)
 	}
 
 	// Incorrect chaincode message received
@@ -845,9 +1122,75 @@ func (handler *Handler) handleQueryChaincode(chaincodeName string, function stri
 	return nil, errors.New("Incorrect chaincode message received")
 }
 
+// reassembleResponse adds a RESPONSE_CHUNK to the response being reassembled
+// for its Uuid. Once the last chunk is in, the complete RESPONSE is returned
+// along with true. A malformed or out of order chunk abandons the response and
+// an ERROR is returned instead, so the waiting request fails.
+func (handler *Handler) reassembleResponse(msg *pb.ChaincodeMessage) (*pb.ChaincodeMessage, bool) {
+	handler.Lock()
+	defer handler.Unlock()
+	chunk := &pb.ChaincodeResponseChunk{}
+	err := proto.Unmarshal(msg.Payload, chunk)
+	resp := handler.chunks[msg.Uuid]
+	if resp == nil {
+		resp = &chunkedResponse{}
+	}
+	if err == nil && chunk.Sequence != resp.next {
+		err = fmt.Errorf("expected chunk %d, got %d", resp.next, chunk.Sequence)
+	}
+	if err != nil {
+		delete(handler.chunks, msg.Uuid)
+		chaincodeLogger.Error(fmt.Sprintf("[%s]Invalid %s: %s", shortuuid(msg.Uuid), msg.Type, err))
+		payload := pb.ChaincodeErrorPayload(handler.protocolVersion, pb.NewChaincodeError(pb.ChaincodeError_MALFORMED, fmt.Errorf("Invalid %s: %s", msg.Type, err), nil))
+		return &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid, Timestamp: msg.Timestamp}, true
+	}
+	resp.payload = append(resp.payload, chunk.Data...)
+	resp.next++
+	if !chunk.Last {
+		handler.chunks[msg.Uuid] = resp
+		return nil, false
+	}
+	delete(handler.chunks, msg.Uuid)
+	chaincodeLogger.Debug("[%s]Reassembled %s of %d bytes from %d chunks", shortuuid(msg.Uuid), pb.ChaincodeMessage_RESPONSE, len(resp.payload), resp.next)
+	return &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: resp.payload, Uuid: msg.Uuid, Timestamp: msg.Timestamp}, true
+}
+
 // handleMessage message handles loop for shim side of chaincode/validator stream.
 func (handler *Handler) handleMessage(msg *pb.ChaincodeMessage) error {
+	if msg.Type == pb.ChaincodeMessage_RESPONSE_CHUNK {
+		var complete bool
+		if msg, complete = handler.reassembleResponse(msg); !complete {
+			return nil
+		}
+	}
 	chaincodeLogger.Debug("[%s]Handling ChaincodeMessage of type: %s(state:%s)", shortuuid(msg.Uuid), msg.Type, handler.FSM.Current())
+	if msg.Type == pb.ChaincodeMessage_ERROR && msg.Deadline != nil {
+		// The peer aborted a transaction or query that ran past its deadline,
+		// the chaincode still completes it but the result is discarded
+		chaincodeLogger.Warning("[%s]Aborted by the peer: %s", shortuuid(msg.Uuid), handler.responseError(msg))
+		handler.abortChannel(msg)
+		return nil
+	}
+	if msg.Type == pb.ChaincodeMessage_RESULT_SPILLED {
+		// Handed to the pending request, which fetches the response
+		if err := handler.sendChannel(msg); err != nil {
+			chaincodeLogger.Error(fmt.Sprintf("[%s]error sending %s: %s", shortuuid(msg.Uuid), msg.Type, err))
+		}
+		return nil
+	}
+	if msg.Type == pb.ChaincodeMessage_KEEPALIVE {
+		// The peer checks that the chaincode is alive, whatever its state
+		return handler.serialSend(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_KEEPALIVE, Uuid: msg.Uuid})
+	}
+	if msg.Type == pb.ChaincodeMessage_REGISTER_FAILED {
+		// The peer closes the stream after telling why it refused the chaincode
+		failure := &pb.ChaincodeRegisterFailure{}
+		if err := proto.Unmarshal(msg.Payload, failure); err != nil {
+			return fmt.Errorf("Error in received %s: %s", msg.Type, err)
+		}
+		chaincodeLogger.Error(fmt.Sprintf("Registration refused by the peer (%s): %s", failure.Reason, failure.Message))
+		return fmt.Errorf("Registration refused by the peer (%s): %s", failure.Reason, failure.Message)
+	}
 	if handler.FSM.Cannot(msg.Type.String()) {
 		errStr := fmt.Sprintf("[%s]Chaincode handler FSM cannot handle message (%s) with payload size (%d) while in state: %s", msg.Uuid, msg.Type.String(), len(msg.Payload), handler.FSM.Current())
 		err := errors.New(errStr)
@@ -0,0 +1,307 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package shim
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// MockPeerChaincodeStream is an in-memory PeerChaincodeStream for unit tests.
+// The chaincode side uses Send and Recv while the test plays the peer with
+// Deliver and Receive.
+type MockPeerChaincodeStream struct {
+	toChaincode   chan *pb.ChaincodeMessage
+	fromChaincode chan *pb.ChaincodeMessage
+	done          chan struct{}
+	closeOnce     sync.Once
+}
+
+// NewMockPeerChaincodeStream returns an open MockPeerChaincodeStream.
+func NewMockPeerChaincodeStream() *MockPeerChaincodeStream {
+	return &MockPeerChaincodeStream{
+		toChaincode:   make(chan *pb.ChaincodeMessage, 10),
+		fromChaincode: make(chan *pb.ChaincodeMessage, 10),
+		done:          make(chan struct{}),
+	}
+}
+
+// Send queues a message of the chaincode for the peer.
+func (stream *MockPeerChaincodeStream) Send(msg *pb.ChaincodeMessage) error {
+	select {
+	case stream.fromChaincode <- msg:
+		return nil
+	case <-stream.done:
+		return errors.New("Mock stream closed")
+	}
+}
+
+// Recv returns the next message delivered to the chaincode, or io.EOF once
+// the stream is closed.
+func (stream *MockPeerChaincodeStream) Recv() (*pb.ChaincodeMessage, error) {
+	select {
+	case msg := <-stream.toChaincode:
+		return msg, nil
+	case <-stream.done:
+		return nil, io.EOF
+	}
+}
+
+// Deliver queues a message of the peer for the chaincode.
+func (stream *MockPeerChaincodeStream) Deliver(msg *pb.ChaincodeMessage) error {
+	select {
+	case stream.toChaincode <- msg:
+		return nil
+	case <-stream.done:
+		return errors.New("Mock stream closed")
+	}
+}
+
+// Receive returns the next message sent by the chaincode, or io.EOF once the
+// stream is closed.
+func (stream *MockPeerChaincodeStream) Receive() (*pb.ChaincodeMessage, error) {
+	select {
+	case msg := <-stream.fromChaincode:
+		return msg, nil
+	case <-stream.done:
+		return nil, io.EOF
+	}
+}
+
+// Close ends the stream on both sides.
+func (stream *MockPeerChaincodeStream) Close() {
+	stream.closeOnce.Do(func() { close(stream.done) })
+}
+
+// MockStub runs a chaincode against an in-memory state so it can be unit
+// tested without a peer, a ledger or Docker. It plays the peer on a
+// MockPeerChaincodeStream, so the chaincode goes through the same shim code
+// as when deployed. Invocations of other chaincodes and key histories are
+// not supported and fail.
+type MockStub struct {
+	// Name is the name the chaincode is registered with
+	Name string
+	// State holds the keys and values written by the chaincode, it must not
+	// be modified while the chaincode runs
+	State map[string][]byte
+
+	stream     *MockPeerChaincodeStream
+	mutex      sync.Mutex
+	pending    map[string]chan *pb.ChaincodeMessage
+	ready      bool
+	registered chan struct{}
+	done       chan struct{}
+	err        error
+}
+
+// NewMockStub starts cc as chaincode name with an empty state.
+func NewMockStub(name string, cc Chaincode) *MockStub {
+	stub := &MockStub{
+		Name:       name,
+		State:      make(map[string][]byte),
+		stream:     NewMockPeerChaincodeStream(),
+		pending:    make(map[string]chan *pb.ChaincodeMessage),
+		registered: make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go func() {
+		stub.err = StartInProc(name, stub.stream, cc)
+		stub.stream.Close()
+		close(stub.done)
+	}()
+	go stub.serve()
+	return stub
+}
+
+// MockInit deploys the chaincode, calling its Init in transaction uuid.
+func (stub *MockStub) MockInit(uuid string, function string, args []string) ([]byte, error) {
+	<-stub.registered
+	stub.mutex.Lock()
+	ready := stub.ready
+	stub.mutex.Unlock()
+	if ready {
+		return nil, fmt.Errorf("Chaincode %s is already initialized", stub.Name)
+	}
+	res, err := stub.execute(pb.ChaincodeMessage_INIT, uuid, function, args)
+	if err == nil {
+		stub.mutex.Lock()
+		stub.ready = true
+		stub.mutex.Unlock()
+	}
+	return res, err
+}
+
+// MockInvoke calls Invoke of the chaincode in transaction uuid.
+func (stub *MockStub) MockInvoke(uuid string, function string, args []string) ([]byte, error) {
+	if err := stub.makeReady(); err != nil {
+		return nil, err
+	}
+	return stub.execute(pb.ChaincodeMessage_TRANSACTION, uuid, function, args)
+}
+
+// MockQuery calls Query of the chaincode as query uuid.
+func (stub *MockStub) MockQuery(uuid string, function string, args []string) ([]byte, error) {
+	if err := stub.makeReady(); err != nil {
+		return nil, err
+	}
+	return stub.execute(pb.ChaincodeMessage_QUERY, uuid, function, args)
+}
+
+// Close ends the stream with the chaincode and waits for the chaincode to
+// stop, returning the error it stopped with.
+func (stub *MockStub) Close() error {
+	stub.stream.Close()
+	<-stub.done
+	return stub.err
+}
+
+// makeReady moves a chaincode that was never initialized to ready, as the
+// peer does for chaincodes already deployed
+func (stub *MockStub) makeReady() error {
+	<-stub.registered
+	stub.mutex.Lock()
+	defer stub.mutex.Unlock()
+	if stub.ready {
+		return nil
+	}
+	if err := stub.stream.Deliver(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_READY}); err != nil {
+		return err
+	}
+	stub.ready = true
+	return nil
+}
+
+// execute delivers a message of msgType to the chaincode and waits for the
+// result of its execution
+func (stub *MockStub) execute(msgType pb.ChaincodeMessage_Type, uuid string, function string, args []string) ([]byte, error) {
+	payload, err := proto.Marshal(&pb.ChaincodeInput{Function: function, Args: args})
+	if err != nil {
+		return nil, fmt.Errorf("Error marshalling %s input: %s", msgType, err)
+	}
+	c := make(chan *pb.ChaincodeMessage, 1)
+	stub.mutex.Lock()
+	stub.pending[uuid] = c
+	stub.mutex.Unlock()
+	defer func() {
+		stub.mutex.Lock()
+		delete(stub.pending, uuid)
+		stub.mutex.Unlock()
+	}()
+
+	if err = stub.stream.Deliver(&pb.ChaincodeMessage{Type: msgType, Payload: payload, Uuid: uuid}); err != nil {
+		return nil, err
+	}
+	select {
+	case resp := <-c:
+		if resp.Type == pb.ChaincodeMessage_COMPLETED || resp.Type == pb.ChaincodeMessage_QUERY_COMPLETED {
+			return resp.Payload, nil
+		}
+		return nil, errors.New(string(resp.Payload))
+	case <-stub.done:
+		return nil, fmt.Errorf("Chaincode %s stopped: %v", stub.Name, stub.err)
+	}
+}
+
+// serve answers the messages of the chaincode until the stream is closed
+func (stub *MockStub) serve() {
+	for {
+		msg, err := stub.stream.Receive()
+		if err != nil {
+			return
+		}
+		if msg.Type == pb.ChaincodeMessage_REGISTER {
+			payload, _ := proto.Marshal(&pb.ChaincodeProtocol{Version: pb.MaxChaincodeProtocol})
+			stub.stream.Deliver(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_REGISTERED, Payload: payload})
+			close(stub.registered)
+			continue
+		}
+		if resp := stub.handle(msg); resp != nil {
+			stub.stream.Deliver(resp)
+		}
+	}
+}
+
+// handle serves a state request of the chaincode from the in-memory state,
+// or completes the pending execution msg answers
+func (stub *MockStub) handle(msg *pb.ChaincodeMessage) *pb.ChaincodeMessage {
+	stub.mutex.Lock()
+	defer stub.mutex.Unlock()
+	var payload []byte
+	switch msg.Type {
+	case pb.ChaincodeMessage_COMPLETED, pb.ChaincodeMessage_ERROR, pb.ChaincodeMessage_QUERY_COMPLETED, pb.ChaincodeMessage_QUERY_ERROR:
+		if c := stub.pending[msg.Uuid]; c != nil {
+			c <- msg
+		}
+		return nil
+	case pb.ChaincodeMessage_CREDIT:
+		return nil
+	case pb.ChaincodeMessage_GET_STATE:
+		payload = stub.State[string(msg.Payload)]
+	case pb.ChaincodeMessage_PUT_STATE:
+		putStateInfo := &pb.PutStateInfo{}
+		if err := proto.Unmarshal(msg.Payload, putStateInfo); err != nil {
+			return mockError(msg, pb.ChaincodeError_MALFORMED, fmt.Sprintf("Error unmarshalling %s: %s", msg.Type, err))
+		}
+		stub.State[putStateInfo.Key] = putStateInfo.Value
+	case pb.ChaincodeMessage_DEL_STATE:
+		delete(stub.State, string(msg.Payload))
+	case pb.ChaincodeMessage_RANGE_QUERY_STATE:
+		rangeQueryState := &pb.RangeQueryState{}
+		if err := proto.Unmarshal(msg.Payload, rangeQueryState); err != nil {
+			return mockError(msg, pb.ChaincodeError_MALFORMED, fmt.Sprintf("Error unmarshalling %s: %s", msg.Type, err))
+		}
+		payload, _ = proto.Marshal(stub.rangeQuery(rangeQueryState.StartKey, rangeQueryState.EndKey, msg.Uuid))
+	case pb.ChaincodeMessage_RANGE_QUERY_STATE_NEXT, pb.ChaincodeMessage_RANGE_QUERY_STATE_CLOSE:
+		// Range queries are answered at once, there is never more to fetch
+		payload, _ = proto.Marshal(&pb.RangeQueryStateResponse{ID: msg.Uuid})
+	default:
+		return mockError(msg, pb.ChaincodeError_UNKNOWN, fmt.Sprintf("%s is not supported by the mock stub", msg.Type))
+	}
+	return &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: payload, Uuid: msg.Uuid}
+}
+
+// rangeQuery returns the keys between startKey and endKey inclusive, in
+// lexical order
+func (stub *MockStub) rangeQuery(startKey, endKey string, uuid string) *pb.RangeQueryStateResponse {
+	var keys []string
+	for key := range stub.State {
+		if key >= startKey && key <= endKey {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	response := &pb.RangeQueryStateResponse{ID: uuid}
+	for _, key := range keys {
+		response.KeysAndValues = append(response.KeysAndValues, &pb.RangeQueryStateKeyValue{Key: key, Value: stub.State[key]})
+	}
+	return response
+}
+
+func mockError(msg *pb.ChaincodeMessage, code pb.ChaincodeError_Code, errStr string) *pb.ChaincodeMessage {
+	chaincodeLogger.Debug("[%s]Mock stub refusing %s: %s", shortuuid(msg.Uuid), msg.Type, errStr)
+	payload := pb.ChaincodeErrorPayload(pb.MaxChaincodeProtocol, pb.NewChaincodeError(code, errors.New(errStr), nil))
+	return &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: msg.Uuid}
+}
@@ -0,0 +1,209 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package shim
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// tenantMux demultiplexes the messages of the peer on a stream shared by a
+// chaincode and its tenants, routing them by chaincodeName to the handler of
+// the chaincode they belong to
+type tenantMux struct {
+	sync.Mutex
+	stream PeerChaincodeStream
+	to     string
+	// sendLock serializes the sends of the handlers on the stream
+	sendLock   sync.Mutex
+	chaincodes map[string]Chaincode
+	tenants    map[string]*tenantStream
+	started    bool
+}
+
+// tenantStream is the stream of one of the chaincodes sharing a stream, fed
+// by the demultiplexer of the stream
+type tenantStream struct {
+	sync.Mutex
+	mux *tenantMux
+	// name is empty for the chaincode that opened the stream
+	name   string
+	queue  []*pb.ChaincodeMessage
+	err    error
+	signal chan struct{}
+}
+
+// chatTenants registers cc as name on stream along with tenants, and handles
+// the messages of the peer for all of them until the stream ends. The
+// tenants register once the peer accepted cc with a protocol version serving
+// them.
+func chatTenants(name string, to string, stream PeerChaincodeStream, cc Chaincode, tenants map[string]Chaincode) error {
+	mux := &tenantMux{stream: stream, to: to, chaincodes: tenants, tenants: make(map[string]*tenantStream)}
+	var names []string
+	for tenant := range tenants {
+		names = append(names, tenant)
+	}
+	sort.Strings(names)
+	primary := newTenantStream(mux, "")
+	go mux.demultiplex(primary)
+
+	err := chat(name, to, primary, cc, names)
+
+	// no tenant starts once the chaincode that opened the stream ended
+	mux.Lock()
+	mux.started = true
+	for _, ts := range mux.tenants {
+		ts.end(io.EOF)
+	}
+	mux.Unlock()
+	return err
+}
+
+// demultiplex routes the messages received on the stream to the chaincode
+// they belong to, until the stream fails
+func (mux *tenantMux) demultiplex(primary *tenantStream) {
+	for {
+		msg, err := mux.stream.Recv()
+		if err != nil {
+			mux.Lock()
+			for _, ts := range mux.tenants {
+				ts.end(err)
+			}
+			mux.Unlock()
+			primary.end(err)
+			return
+		}
+		if msg == nil || msg.ChaincodeName == "" {
+			if msg != nil && msg.Type == pb.ChaincodeMessage_REGISTERED {
+				mux.startTenants(msg)
+			}
+			primary.deliver(msg)
+			continue
+		}
+		mux.Lock()
+		ts := mux.tenants[msg.ChaincodeName]
+		mux.Unlock()
+		if ts == nil {
+			chaincodeLogger.Warning(fmt.Sprintf("[%s]Dropping %s for chaincode %s, not a tenant of the stream", shortuuid(msg.Uuid), msg.Type, msg.ChaincodeName))
+			continue
+		}
+		ts.deliver(msg)
+	}
+}
+
+// startTenants registers the tenants once the peer accepted the chaincode
+// that opened the stream with REGISTERED msg
+func (mux *tenantMux) startTenants(msg *pb.ChaincodeMessage) {
+	mux.Lock()
+	defer mux.Unlock()
+	if mux.started {
+		return
+	}
+	mux.started = true
+	version := pb.ChaincodeProtocolV1
+	if len(msg.Payload) > 0 {
+		protocol := &pb.ChaincodeProtocol{}
+		if proto.Unmarshal(msg.Payload, protocol) == nil {
+			version = protocol.Version
+		}
+	}
+	if version < pb.ChaincodeProtocolV10 {
+		chaincodeLogger.Error(fmt.Sprintf("The peer speaks chaincode protocol version %d and cannot serve the tenants of the chaincode", version))
+		return
+	}
+	for name, cc := range mux.chaincodes {
+		ts := newTenantStream(mux, name)
+		mux.tenants[name] = ts
+		go func(cc Chaincode) {
+			err := chat(ts.name, mux.to, ts, cc, nil)
+			chaincodeLogger.Debug("Tenant %s ended: %s", ts.name, err)
+		}(cc)
+	}
+}
+
+func newTenantStream(mux *tenantMux, name string) *tenantStream {
+	return &tenantStream{mux: mux, name: name, signal: make(chan struct{}, 1)}
+}
+
+// deliver queues msg for the handler of the stream. Queueing rather than
+// waiting for the handler keeps a busy chaincode from holding up the others.
+func (ts *tenantStream) deliver(msg *pb.ChaincodeMessage) {
+	ts.Lock()
+	if ts.err == nil {
+		ts.queue = append(ts.queue, msg)
+	}
+	ts.Unlock()
+	ts.wake()
+}
+
+// end ends the stream with err once the messages queued are received
+func (ts *tenantStream) end(err error) {
+	ts.Lock()
+	if ts.err == nil {
+		ts.err = err
+	}
+	ts.Unlock()
+	ts.wake()
+}
+
+func (ts *tenantStream) wake() {
+	select {
+	case ts.signal <- struct{}{}:
+	default:
+	}
+}
+
+// Recv returns the next message of the peer for the chaincode of the stream
+func (ts *tenantStream) Recv() (*pb.ChaincodeMessage, error) {
+	for {
+		ts.Lock()
+		if len(ts.queue) > 0 {
+			msg := ts.queue[0]
+			ts.queue[0] = nil
+			ts.queue = ts.queue[1:]
+			ts.Unlock()
+			return msg, nil
+		}
+		err := ts.err
+		ts.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		<-ts.signal
+	}
+}
+
+// Send sends msg on the shared stream, stamped with the name of the tenant
+func (ts *tenantStream) Send(msg *pb.ChaincodeMessage) error {
+	if ts.name != "" {
+		stamped := *msg
+		stamped.ChaincodeName = ts.name
+		msg = &stamped
+	}
+	ts.mux.sendLock.Lock()
+	defer ts.mux.sendLock.Unlock()
+	return ts.mux.stream.Send(msg)
+}
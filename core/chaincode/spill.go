@@ -0,0 +1,176 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// spillSeq numbers the spilled responses of the peer, making their handles
+// unique
+var spillSeq uint64
+
+// spilledResult is a RESPONSE payload kept in the spill store until the shim
+// has read it with FETCH_RESULT
+type spilledResult struct {
+	uuid    string
+	size    int
+	created time.Time
+}
+
+// spillLimit returns the size above which responses to this chaincode are
+// spilled, 0 if its shim cannot fetch them or spilling is disabled
+func (handler *Handler) spillLimit() int {
+	if handler.protocolVersion < pb.ChaincodeProtocolV11 || handler.chaincodeSupport == nil || handler.chaincodeSupport.spillStore == nil {
+		return 0
+	}
+	return handler.chaincodeSupport.spillMaxSize
+}
+
+// spillNamespace is the namespace of the spill store the responses of this
+// chaincode are kept in
+func (handler *Handler) spillNamespace() string {
+	if handler.ChaincodeID == nil {
+		return ""
+	}
+	return handler.ChaincodeID.Name
+}
+
+// spillResponse stores the payload of a RESPONSE larger than spillLimit and
+// returns the RESULT_SPILLED to send in its place. msg is returned as is if it
+// is small enough.
+func (handler *Handler) spillResponse(msg *pb.ChaincodeMessage) (*pb.ChaincodeMessage, error) {
+	limit := handler.spillLimit()
+	if limit <= 0 || len(msg.Payload) <= limit {
+		return msg, nil
+	}
+	handle := fmt.Sprintf("%s-%d", msg.Uuid, atomic.AddUint64(&spillSeq, 1))
+	if err := handler.chaincodeSupport.spillStore.SetState(handler.spillNamespace(), handle, msg.Payload); err != nil {
+		return nil, fmt.Errorf("[%s]Error spilling %s of %d bytes: %s", shortuuid(msg.Uuid), msg.Type, len(msg.Payload), err)
+	}
+	payload, err := proto.Marshal(&pb.ChaincodeResultRef{Handle: handle, Size: uint64(len(msg.Payload))})
+	if err != nil {
+		handler.chaincodeSupport.spillStore.DeleteState(handler.spillNamespace(), handle)
+		return nil, fmt.Errorf("[%s]Error marshalling %s: %s", shortuuid(msg.Uuid), pb.ChaincodeMessage_RESULT_SPILLED, err)
+	}
+
+	handler.Lock()
+	if handler.spilled == nil {
+		handler.spilled = make(map[string]*spilledResult)
+	}
+	handler.spilled[handle] = &spilledResult{uuid: msg.Uuid, size: len(msg.Payload), created: time.Now()}
+	handler.Unlock()
+
+	handler.log(msg).Debug("Spilled %s of %d bytes as %s", msg.Type, len(msg.Payload), handle)
+	return &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESULT_SPILLED, Payload: payload, Uuid: msg.Uuid, Timestamp: msg.Timestamp}, nil
+}
+
+// handleFetchResult answers a FETCH_RESULT with the piece of the spilled
+// response from the offset it asks for. The response is released once its
+// last piece was read.
+func (handler *Handler) handleFetchResult(msg *pb.ChaincodeMessage) {
+	fetch := &pb.FetchResult{}
+	if err := proto.Unmarshal(msg.Payload, fetch); err != nil {
+		handler.serialSend(handler.errorMessage(msg, pb.ChaincodeError_MALFORMED, fmt.Errorf("Error unmarshalling %s: %s", msg.Type, err), nil))
+		return
+	}
+
+	handler.RLock()
+	spilled := handler.spilled[fetch.Handle]
+	handler.RUnlock()
+	if spilled == nil || spilled.uuid != msg.Uuid {
+		err := fmt.Errorf("Unknown spilled result %s", fetch.Handle)
+		handler.serialSend(handler.errorMessage(msg, pb.ChaincodeError_INVALID_ARGUMENT, err, map[string]string{"handle": fetch.Handle}))
+		return
+	}
+	if fetch.Offset >= uint64(spilled.size) {
+		err := fmt.Errorf("Offset %d past the end of spilled result %s of %d bytes", fetch.Offset, fetch.Handle, spilled.size)
+		handler.serialSend(handler.errorMessage(msg, pb.ChaincodeError_INVALID_ARGUMENT, err, map[string]string{"handle": fetch.Handle}))
+		return
+	}
+
+	blob, err := handler.chaincodeSupport.spillStore.GetState(handler.spillNamespace(), fetch.Handle, false)
+	if err == nil && len(blob) != spilled.size {
+		err = fmt.Errorf("expected %d bytes, found %d", spilled.size, len(blob))
+	}
+	if err != nil {
+		handler.releaseSpilled(fetch.Handle)
+		err = fmt.Errorf("Error reading spilled result %s: %s", fetch.Handle, err)
+		handler.log(msg).Error("%s", err)
+		handler.serialSend(handler.errorMessage(msg, pb.ChaincodeError_LEDGER, err, map[string]string{"handle": fetch.Handle}))
+		return
+	}
+
+	end := fetch.Offset + uint64(handler.chaincodeSupport.spillMaxSize)
+	if end >= uint64(spilled.size) {
+		end = uint64(spilled.size)
+		handler.releaseSpilled(fetch.Handle)
+	}
+	handler.serialSend(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: blob[fetch.Offset:end], Uuid: msg.Uuid})
+}
+
+// releaseSpilled deletes the spilled response kept under handle
+func (handler *Handler) releaseSpilled(handle string) {
+	handler.Lock()
+	delete(handler.spilled, handle)
+	handler.Unlock()
+	if err := handler.chaincodeSupport.spillStore.DeleteState(handler.spillNamespace(), handle); err != nil {
+		handler.log(nil).Warning("Error deleting spilled result %s: %s", handle, err)
+	}
+}
+
+// sweepSpilled releases the spilled responses not read by the deadline of the
+// transaction they answered, plus grace. It returns their handles.
+func (handler *Handler) sweepSpilled(now time.Time, grace time.Duration) []string {
+	execTimeout := handler.chaincodeSupport.getExecTimeout()
+	var stale []string
+	handler.RLock()
+	for handle, spilled := range handler.spilled {
+		if !now.Before(spilled.created.Add(execTimeout + grace)) {
+			stale = append(stale, handle)
+		}
+	}
+	handler.RUnlock()
+	for _, handle := range stale {
+		handler.log(nil).Warning("Released spilled result %s of chaincode %s, not fetched in time", handle, handler.traceChaincodeName())
+		handler.releaseSpilled(handle)
+	}
+	return stale
+}
+
+// releaseAllSpilled releases the spilled responses not read when the stream
+// ends
+func (handler *Handler) releaseAllSpilled() {
+	handler.RLock()
+	handles := make([]string, 0, len(handler.spilled))
+	for handle := range handler.spilled {
+		handles = append(handles, handle)
+	}
+	handler.RUnlock()
+	for _, handle := range handles {
+		handler.releaseSpilled(handle)
+	}
+}
@@ -0,0 +1,157 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func newSpillTestHandler(stream PeerChaincodeStream, version int32) *Handler {
+	handler := newTestHandler(stream)
+	handler.chaincodeSupport = &ChaincodeSupport{spillStore: NewMemStateStore(), spillMaxSize: 4, execTimeout: execTimeoutDefault}
+	handler.ChaincodeID = &pb.ChaincodeID{Name: "spillcc"}
+	handler.protocolVersion = version
+	return handler
+}
+
+func receiveSent(t *testing.T, stream *mockChaincodeStream) *pb.ChaincodeMessage {
+	select {
+	case msg := <-stream.sendCh:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a message to the chaincode")
+	}
+	return nil
+}
+
+func fetchResult(t *testing.T, handler *Handler, uuid string, handle string, offset uint64) {
+	payload, err := proto.Marshal(&pb.FetchResult{Handle: handle, Offset: offset})
+	if err != nil {
+		t.Fatalf("Error marshalling %s: %s", pb.ChaincodeMessage_FETCH_RESULT, err)
+	}
+	if err = handler.HandleMessage(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_FETCH_RESULT, Payload: payload, Uuid: uuid}); err != nil {
+		t.Fatalf("Error handling %s: %s", pb.ChaincodeMessage_FETCH_RESULT, err)
+	}
+}
+
+func TestSpillResponseFetchedInPieces(t *testing.T) {
+	stream := newMockChaincodeStream()
+	handler := newSpillTestHandler(stream, pb.ChaincodeProtocolV11)
+	defer handler.stopWriter()
+
+	value := []byte("0123456789")
+	if err := handler.serialSend(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: value, Uuid: "tx1"}); err != nil {
+		t.Fatalf("Error sending response: %s", err)
+	}
+	msg := receiveSent(t, stream)
+	if msg.Type != pb.ChaincodeMessage_RESULT_SPILLED {
+		t.Fatalf("Expected %s, got %s", pb.ChaincodeMessage_RESULT_SPILLED, msg.Type)
+	}
+	ref := &pb.ChaincodeResultRef{}
+	if err := proto.Unmarshal(msg.Payload, ref); err != nil {
+		t.Fatalf("Error unmarshalling %s: %s", msg.Type, err)
+	}
+	if ref.Size != uint64(len(value)) {
+		t.Fatalf("Expected a size of %d, got %d", len(value), ref.Size)
+	}
+
+	// the handle cannot be read by another transaction
+	fetchResult(t, handler, "tx2", ref.Handle, 0)
+	if msg = receiveSent(t, stream); msg.Type != pb.ChaincodeMessage_ERROR {
+		t.Fatalf("Expected an ERROR fetching from another transaction, got %s", msg.Type)
+	}
+
+	var fetched []byte
+	for uint64(len(fetched)) < ref.Size {
+		fetchResult(t, handler, "tx1", ref.Handle, uint64(len(fetched)))
+		msg = receiveSent(t, stream)
+		if msg.Type != pb.ChaincodeMessage_RESPONSE || len(msg.Payload) > 4 {
+			t.Fatalf("Expected a RESPONSE of at most 4 bytes, got %s of %d bytes", msg.Type, len(msg.Payload))
+		}
+		fetched = append(fetched, msg.Payload...)
+	}
+	if !bytes.Equal(fetched, value) {
+		t.Fatalf("Expected %s, fetched %s", value, fetched)
+	}
+
+	// the result is released once read to its end
+	if len(handler.spilled) != 0 {
+		t.Fatalf("Expected the spilled result to be released, got %v", handler.spilled)
+	}
+	if blob, _ := handler.chaincodeSupport.spillStore.GetState("spillcc", ref.Handle, false); blob != nil {
+		t.Fatal("Expected the spilled result to be deleted from the store")
+	}
+	fetchResult(t, handler, "tx1", ref.Handle, 0)
+	if msg = receiveSent(t, stream); msg.Type != pb.ChaincodeMessage_ERROR {
+		t.Fatalf("Expected an ERROR fetching a released result, got %s", msg.Type)
+	}
+}
+
+func TestSpillResponseNotUsedWithOldShims(t *testing.T) {
+	stream := newMockChaincodeStream()
+	handler := newSpillTestHandler(stream, pb.ChaincodeProtocolV10)
+	defer handler.stopWriter()
+
+	if err := handler.serialSend(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: []byte("0123456789"), Uuid: "tx1"}); err != nil {
+		t.Fatalf("Error sending response: %s", err)
+	}
+	if msg := receiveSent(t, stream); msg.Type != pb.ChaincodeMessage_RESPONSE || len(msg.Payload) != 10 {
+		t.Fatalf("Expected the RESPONSE to be sent whole, got %s of %d bytes", msg.Type, len(msg.Payload))
+	}
+}
+
+func TestSweepSpilledReleasesUnfetchedResults(t *testing.T) {
+	stream := newMockChaincodeStream()
+	handler := newSpillTestHandler(stream, pb.ChaincodeProtocolV11)
+	defer handler.stopWriter()
+
+	for _, uuid := range []string{"stale", "fresh"} {
+		if err := handler.serialSend(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_RESPONSE, Payload: []byte("0123456789"), Uuid: uuid}); err != nil {
+			t.Fatalf("Error sending response: %s", err)
+		}
+		receiveSent(t, stream)
+	}
+	var staleHandle string
+	for handle, spilled := range handler.spilled {
+		if spilled.uuid == "stale" {
+			spilled.created = time.Now().Add(-2 * execTimeoutDefault)
+			staleHandle = handle
+		}
+	}
+
+	swept := handler.sweepSpilled(time.Now(), time.Second)
+	if len(swept) != 1 || swept[0] != staleHandle {
+		t.Fatalf("Expected %s to be released, got %v", staleHandle, swept)
+	}
+	if len(handler.spilled) != 1 {
+		t.Fatalf("Expected the fresh result to be kept, got %v", handler.spilled)
+	}
+
+	handler.releaseAllSpilled()
+	if len(handler.spilled) != 0 {
+		t.Fatalf("Expected all results to be released, got %v", handler.spilled)
+	}
+}
@@ -0,0 +1,229 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"container/list"
+	"sync"
+)
+
+// generationalState is ledger state that reports when and where it changed
+// other than through SetState and DeleteState. It is satisfied by *ledger.Ledger.
+type generationalState interface {
+	stateAccessor
+	GetStateGeneration() uint64
+	GetStateChangesSince(generation uint64) (keys map[string][]string, ok bool)
+}
+
+// StateCacheStats reports the effectiveness of the chaincode state cache
+type StateCacheStats struct {
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	Entries int    `json:"entries"`
+}
+
+// stateCache is an LRU cache of ledger state values shared by the ledgers of
+// all chains. The values of a ledger are valid at the state generation of its
+// view. When the generation of the ledger changes, on block commit or
+// rollback, only the keys that changed are evicted.
+type stateCache struct {
+	sync.Mutex
+	maxEntries int
+	lru        *list.List
+	index      map[stateCacheKey]*list.Element
+	views      map[generationalState]*stateCacheView
+	hits       uint64
+	misses     uint64
+}
+
+// stateCacheView is the part of the cache holding the values of one ledger
+type stateCacheView struct {
+	id         uint64
+	generation uint64
+	// incremented on every invalidation so that a value read from the ledger
+	// concurrently with a write to it is not cached
+	writes uint64
+}
+
+// stateCacheKey separates the values of the ledgers, and committed reads
+// from reads that include the changes of the current transaction batch
+type stateCacheKey struct {
+	view        uint64
+	committed   bool
+	chaincodeID string
+	key         string
+}
+
+type stateCacheEntry struct {
+	key   stateCacheKey
+	value []byte
+}
+
+func newStateCache(maxEntries int) *stateCache {
+	return &stateCache{maxEntries: maxEntries, lru: list.New(), index: make(map[stateCacheKey]*list.Element), views: make(map[generationalState]*stateCacheView)}
+}
+
+// view returns the view of ledger, first evicting the keys that changed since
+// the generation of the view if generation is newer. Call this under lock.
+func (c *stateCache) view(ledger generationalState, generation uint64) *stateCacheView {
+	v, ok := c.views[ledger]
+	if !ok {
+		v = &stateCacheView{id: uint64(len(c.views)), generation: generation}
+		c.views[ledger] = v
+		return v
+	}
+	if generation <= v.generation {
+		// a reader that got the generation before the view moved on
+		return v
+	}
+	if changes, ok := ledger.GetStateChangesSince(v.generation); ok {
+		for chaincodeID, keys := range changes {
+			for _, key := range keys {
+				c.remove(v, chaincodeID, key)
+			}
+		}
+	} else {
+		// the changes are not known, evict all the values of the ledger
+		for elem := c.lru.Front(); elem != nil; {
+			next := elem.Next()
+			if entry := elem.Value.(*stateCacheEntry); entry.key.view == v.id {
+				c.lru.Remove(elem)
+				delete(c.index, entry.key)
+			}
+			elem = next
+		}
+	}
+	v.generation = generation
+	v.writes++
+	return v
+}
+
+// remove evicts the committed and uncommitted values of a key. Call this under lock.
+func (c *stateCache) remove(v *stateCacheView, chaincodeID string, key string) {
+	for _, committed := range []bool{true, false} {
+		k := stateCacheKey{view: v.id, committed: committed, chaincodeID: chaincodeID, key: key}
+		if elem, ok := c.index[k]; ok {
+			c.lru.Remove(elem)
+			delete(c.index, k)
+		}
+	}
+}
+
+// get returns the cached value of a key of ledger. On a miss it returns the
+// write count to pass to add once the value has been read from the ledger.
+func (c *stateCache) get(ledger generationalState, generation uint64, chaincodeID string, key string, committed bool) ([]byte, bool, uint64) {
+	c.Lock()
+	defer c.Unlock()
+	v := c.view(ledger, generation)
+	if elem, ok := c.index[stateCacheKey{view: v.id, committed: committed, chaincodeID: chaincodeID, key: key}]; ok {
+		c.lru.MoveToFront(elem)
+		c.hits++
+		return elem.Value.(*stateCacheEntry).value, true, v.writes
+	}
+	c.misses++
+	return nil, false, v.writes
+}
+
+func (c *stateCache) add(ledger generationalState, generation uint64, writes uint64, chaincodeID string, key string, committed bool, value []byte) {
+	c.Lock()
+	defer c.Unlock()
+	v, ok := c.views[ledger]
+	if !ok || generation != v.generation || writes != v.writes {
+		// The value may already be outdated
+		return
+	}
+	k := stateCacheKey{view: v.id, committed: committed, chaincodeID: chaincodeID, key: key}
+	if elem, ok := c.index[k]; ok {
+		elem.Value.(*stateCacheEntry).value = value
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.index[k] = c.lru.PushFront(&stateCacheEntry{key: k, value: value})
+	if c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.index, oldest.Value.(*stateCacheEntry).key)
+	}
+}
+
+func (c *stateCache) invalidate(ledger generationalState, chaincodeID string, key string) {
+	c.Lock()
+	defer c.Unlock()
+	if v, ok := c.views[ledger]; ok {
+		v.writes++
+		c.remove(v, chaincodeID, key)
+	}
+}
+
+func (c *stateCache) stats() StateCacheStats {
+	c.Lock()
+	defer c.Unlock()
+	return StateCacheStats{Hits: c.hits, Misses: c.misses, Entries: c.lru.Len()}
+}
+
+// cachedState reads the ledger state through the state cache and invalidates
+// the cached value of every key written
+type cachedState struct {
+	generationalState
+	cache *stateCache
+}
+
+func (cs *cachedState) GetState(chaincodeID string, key string, committed bool) ([]byte, error) {
+	generation := cs.GetStateGeneration()
+	value, ok, writes := cs.cache.get(cs.generationalState, generation, chaincodeID, key, committed)
+	if ok {
+		return value, nil
+	}
+	value, err := cs.generationalState.GetState(chaincodeID, key, committed)
+	if err != nil {
+		return nil, err
+	}
+	cs.cache.add(cs.generationalState, generation, writes, chaincodeID, key, committed, value)
+	return value, nil
+}
+
+func (cs *cachedState) SetState(chaincodeID string, key string, value []byte) error {
+	cs.cache.invalidate(cs.generationalState, chaincodeID, key)
+	return cs.generationalState.SetState(chaincodeID, key, value)
+}
+
+func (cs *cachedState) DeleteState(chaincodeID string, key string) error {
+	cs.cache.invalidate(cs.generationalState, chaincodeID, key)
+	return cs.generationalState.DeleteState(chaincodeID, key)
+}
+
+// stateAccess returns the view of the state used by chaincodes, cached when
+// the state cache is enabled and the state is that of the ledger
+func (chaincodeSupport *ChaincodeSupport) stateAccess(state stateAccessor) stateAccessor {
+	ledgerObj, ok := state.(generationalState)
+	if chaincodeSupport == nil || chaincodeSupport.stateCache == nil || !ok {
+		return state
+	}
+	return &cachedState{generationalState: ledgerObj, cache: chaincodeSupport.stateCache}
+}
+
+// StateCacheStats returns the hit and miss counts of the state cache, zero if
+// it is disabled
+func (chaincodeSupport *ChaincodeSupport) StateCacheStats() StateCacheStats {
+	if chaincodeSupport.stateCache == nil {
+		return StateCacheStats{}
+	}
+	return chaincodeSupport.stateCache.stats()
+}
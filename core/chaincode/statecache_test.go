@@ -0,0 +1,158 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"testing"
+)
+
+// countingState counts the reads reaching the underlying state and records
+// the keys committed at each generation
+type countingState struct {
+	mapState
+	reads      int
+	generation uint64
+	changes    map[uint64]map[string][]string
+}
+
+func (s *countingState) GetState(chaincodeID string, key string, committed bool) ([]byte, error) {
+	s.reads++
+	return s.mapState.GetState(chaincodeID, key, committed)
+}
+
+func (s *countingState) GetStateGeneration() uint64 {
+	return s.generation
+}
+
+func (s *countingState) GetStateChangesSince(generation uint64) (map[string][]string, bool) {
+	keys := make(map[string][]string)
+	for g := generation + 1; g <= s.generation; g++ {
+		changes, ok := s.changes[g]
+		if !ok {
+			return nil, false
+		}
+		for chaincodeID, changed := range changes {
+			keys[chaincodeID] = append(keys[chaincodeID], changed...)
+		}
+	}
+	return keys, true
+}
+
+// commit writes key of chaincode cc as a block commit would
+func (s *countingState) commit(key string, value string) {
+	s.mapState.SetState("cc", key, []byte(value))
+	s.generation++
+	if s.changes == nil {
+		s.changes = make(map[uint64]map[string][]string)
+	}
+	s.changes[s.generation] = map[string][]string{"cc": {key}}
+}
+
+func TestStateCacheReadThrough(t *testing.T) {
+	ledgerState := &countingState{mapState: mapState{}}
+	ledgerState.SetState("cc", "a", []byte("1"))
+	cs := &ChaincodeSupport{stateCache: newStateCache(10)}
+	state := cs.stateAccess(ledgerState)
+
+	for i := 0; i < 3; i++ {
+		if v, _ := state.GetState("cc", "a", false); string(v) != "1" {
+			t.Fatalf("Expected 1, got %s", v)
+		}
+	}
+	if ledgerState.reads != 1 {
+		t.Fatalf("Expected 1 ledger read, got %d", ledgerState.reads)
+	}
+
+	// A write invalidates the key
+	state.SetState("cc", "a", []byte("2"))
+	if v, _ := state.GetState("cc", "a", false); string(v) != "2" {
+		t.Fatalf("Expected 2 after write, got %s", v)
+	}
+
+	// A block commit evicts the keys it wrote
+	ledgerState.commit("a", "3")
+	if v, _ := state.GetState("cc", "a", false); string(v) != "3" {
+		t.Fatalf("Expected 3 after commit, got %s", v)
+	}
+
+	if stats := cs.StateCacheStats(); stats.Hits != 2 || stats.Misses != 3 || stats.Entries != 1 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestStateCacheKeepsKeysNotCommitted(t *testing.T) {
+	ledgerState := &countingState{mapState: mapState{}}
+	ledgerState.SetState("cc", "hot", []byte("1"))
+	ledgerState.SetState("cc", "cold", []byte("1"))
+	cs := &ChaincodeSupport{stateCache: newStateCache(10)}
+	state := cs.stateAccess(ledgerState)
+
+	state.GetState("cc", "hot", true)
+	state.GetState("cc", "cold", true)
+	ledgerState.commit("cold", "2")
+	ledgerState.commit("other", "2")
+
+	if v, _ := state.GetState("cc", "hot", true); string(v) != "1" {
+		t.Fatalf("Expected 1, got %s", v)
+	}
+	if v, _ := state.GetState("cc", "cold", true); string(v) != "2" {
+		t.Fatalf("Expected 2 after commit, got %s", v)
+	}
+	if ledgerState.reads != 3 {
+		t.Fatalf("Expected only the committed key to be read again, got %d reads", ledgerState.reads)
+	}
+
+	// Changes that are not known evict everything
+	ledgerState.generation++
+	state.GetState("cc", "hot", true)
+	if ledgerState.reads != 4 {
+		t.Fatalf("Expected unknown changes to empty the cache, got %d reads", ledgerState.reads)
+	}
+}
+
+func TestStateCacheSeparatesLedgers(t *testing.T) {
+	chain1, chain2 := &countingState{mapState: mapState{}}, &countingState{mapState: mapState{}}
+	chain1.SetState("cc", "a", []byte("1"))
+	chain2.SetState("cc", "a", []byte("2"))
+	cs := &ChaincodeSupport{stateCache: newStateCache(10)}
+
+	if v, _ := cs.stateAccess(chain1).GetState("cc", "a", true); string(v) != "1" {
+		t.Fatalf("Expected 1, got %s", v)
+	}
+	if v, _ := cs.stateAccess(chain2).GetState("cc", "a", true); string(v) != "2" {
+		t.Fatalf("Expected the value of the other chain, got %s", v)
+	}
+}
+
+func TestStateCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	ledgerState := &countingState{mapState: mapState{}}
+	cache := newStateCache(2)
+	cache.get(ledgerState, 0, "cc", "a", false)
+	cache.add(ledgerState, 0, 0, "cc", "a", false, []byte("a"))
+	cache.add(ledgerState, 0, 0, "cc", "b", false, []byte("b"))
+	cache.get(ledgerState, 0, "cc", "a", false)
+	cache.add(ledgerState, 0, 0, "cc", "c", false, []byte("c"))
+	if _, ok, _ := cache.get(ledgerState, 0, "cc", "b", false); ok {
+		t.Fatal("Expected b to be evicted")
+	}
+	if _, ok, _ := cache.get(ledgerState, 0, "cc", "a", false); !ok {
+		t.Fatal("Expected a to be cached")
+	}
+}
@@ -0,0 +1,173 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// stateImportID identifies the state delta of an imported chunk
+const stateImportID = "stateImport"
+
+// deltaLedger is the part of the ledger chunks are imported into. It is
+// satisfied by *ledger.Ledger.
+type deltaLedger interface {
+	GetState(chaincodeID string, key string, committed bool) ([]byte, error)
+	ApplyStateDelta(id interface{}, delta *statemgmt.StateDelta) error
+	CommitStateDelta(id interface{}) error
+	RollbackStateDelta(id interface{}) error
+}
+
+// exportStore returns the store the state of chaincodes is exported from and
+// imported into
+func (chaincodeSupport *ChaincodeSupport) exportStore() (StateStore, error) {
+	if chaincodeSupport.stateStore != nil {
+		return chaincodeSupport.stateStore, nil
+	}
+	ledgerObj, err := chaincodeSupport.getLedger("")
+	if err != nil {
+		return nil, err
+	}
+	return ledgerObj, nil
+}
+
+// stateNamespaceOf returns the namespace of the state of chaincode, that of
+// the chaincode it upgraded if any
+func (chaincodeSupport *ChaincodeSupport) stateNamespaceOf(chaincode string) string {
+	chaincodeSupport.handlerMap.RLock()
+	defer chaincodeSupport.handlerMap.RUnlock()
+	if namespace := chaincodeSupport.handlerMap.namespaceMap[chaincode]; namespace != "" {
+		return namespace
+	}
+	return chaincode
+}
+
+// ExportState passes the committed key/values of chaincode to send, in chunks
+// of at most chunkSize key/values, chaincode.stateExport.chunkSize if 0. The
+// key/values come in the order the state iterates them, which is not the key
+// order with the buckettree state of the ledger. A chaincode without state is
+// exported as one empty chunk. It returns the number of key/values exported.
+func (chaincodeSupport *ChaincodeSupport) ExportState(chaincode string, chunkSize int, send func(*pb.StateChunk) error) (uint64, error) {
+	if chaincode == "" {
+		return 0, fmt.Errorf("chaincode not set")
+	}
+	if chunkSize <= 0 {
+		chunkSize = chaincodeSupport.stateExportChunkSize
+	}
+	if chunkSize <= 0 {
+		return 0, fmt.Errorf("Invalid chunk size %d", chunkSize)
+	}
+	store, err := chaincodeSupport.exportStore()
+	if err != nil {
+		return 0, err
+	}
+	itr, err := store.GetStateRangeScanIterator(chaincodeSupport.stateNamespaceOf(chaincode), "", "", true)
+	if err != nil {
+		return 0, err
+	}
+	defer itr.Close()
+
+	var keys uint64
+	chunk := &pb.StateChunk{ChaincodeID: chaincode}
+	for itr.Next() {
+		key, value := itr.GetKeyValue()
+		chunk.KeyValues = append(chunk.KeyValues, &pb.StateKeyValue{Key: key, Value: value})
+		keys++
+		if len(chunk.KeyValues) < chunkSize {
+			continue
+		}
+		if err = send(chunk); err != nil {
+			return keys, err
+		}
+		chunk = &pb.StateChunk{ChaincodeID: chaincode, Sequence: chunk.Sequence + 1}
+	}
+	if len(chunk.KeyValues) > 0 || chunk.Sequence == 0 {
+		err = send(chunk)
+	}
+	return keys, err
+}
+
+// ImportState writes the key/values of chunk to the state of its chaincode,
+// atomically when the state is that of the ledger. Keys the chunk does not
+// hold are left as they are. The ledger state is changed outside of any
+// block, so a validating peer refuses the import: its state would no longer
+// be the one the other validators agree on. On other peers the imported
+// state is local, the next state transfer may replace it.
+func (chaincodeSupport *ChaincodeSupport) ImportState(chunk *pb.StateChunk) error {
+	if chunk.ChaincodeID == "" {
+		return fmt.Errorf("chaincode not set")
+	}
+	if chaincodeSupport.stateStore == nil && chaincodeSupport.validator {
+		return fmt.Errorf("The state of a validating peer is only changed by blocks, import the state of chaincode %s on a non validating peer", chunk.ChaincodeID)
+	}
+	namespace := chaincodeSupport.stateNamespaceOf(chunk.ChaincodeID)
+	if chaincodeSupport.stateStore != nil {
+		for _, kv := range chunk.KeyValues {
+			if err := chaincodeSupport.stateStore.SetState(namespace, kv.Key, importedValue(kv)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	ledgerObj, err := chaincodeSupport.getLedger("")
+	if err != nil {
+		return err
+	}
+	return importStateDelta(ledgerObj, namespace, chunk.KeyValues)
+}
+
+// importedValue returns the value of kv, an empty value once unmarshalled is
+// nil
+func importedValue(kv *pb.StateKeyValue) []byte {
+	if kv.Value == nil {
+		return []byte{}
+	}
+	return kv.Value
+}
+
+// importStateDelta applies and commits the changes kvs makes to the
+// namespace as one state delta
+func importStateDelta(ledgerObj deltaLedger, namespace string, kvs []*pb.StateKeyValue) error {
+	delta := statemgmt.NewStateDelta()
+	for _, kv := range kvs {
+		current, err := ledgerObj.GetState(namespace, kv.Key, true)
+		if err != nil {
+			return fmt.Errorf("Error reading %s/%s: %s", namespace, kv.Key, err)
+		}
+		if value := importedValue(kv); current == nil || !bytes.Equal(current, value) {
+			delta.Set(namespace, kv.Key, value, current)
+		}
+	}
+	if delta.IsEmpty() {
+		return nil
+	}
+	if err := ledgerObj.ApplyStateDelta(stateImportID, delta); err != nil {
+		return err
+	}
+	if err := ledgerObj.CommitStateDelta(stateImportID); err != nil {
+		ledgerObj.RollbackStateDelta(stateImportID)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// deltaState is a mapState that applies state deltas when they are committed
+type deltaState struct {
+	mapState
+	delta *statemgmt.StateDelta
+}
+
+func (s *deltaState) ApplyStateDelta(id interface{}, delta *statemgmt.StateDelta) error {
+	s.delta = delta
+	return nil
+}
+
+func (s *deltaState) CommitStateDelta(id interface{}) error {
+	for _, chaincodeID := range s.delta.GetUpdatedChaincodeIds(false) {
+		for key, v := range s.delta.GetUpdates(chaincodeID) {
+			if v.IsDelete() {
+				s.DeleteState(chaincodeID, key)
+			} else {
+				s.SetState(chaincodeID, key, v.GetValue())
+			}
+		}
+	}
+	s.delta = nil
+	return nil
+}
+
+func (s *deltaState) RollbackStateDelta(id interface{}) error {
+	s.delta = nil
+	return nil
+}
+
+func newStateExportSupport(store StateStore) *ChaincodeSupport {
+	return &ChaincodeSupport{
+		handlerMap:           &handlerMap{chaincodeMap: make(map[string]*Handler), namespaceMap: make(map[string]string)},
+		stateStore:           store,
+		stateExportChunkSize: 2,
+	}
+}
+
+func TestExportImportState(t *testing.T) {
+	source := newStateExportSupport(NewMemStateStore())
+	for i := 0; i < 5; i++ {
+		source.stateStore.SetState("mycc", fmt.Sprintf("k%d", i), []byte(fmt.Sprintf("v%d", i)))
+	}
+	source.stateStore.SetState("other", "k0", []byte("other"))
+	// mycc2 upgraded mycc and kept its state
+	source.handlerMap.namespaceMap["mycc2"] = "mycc"
+
+	var chunks []*pb.StateChunk
+	keys, err := source.ExportState("mycc2", 0, func(chunk *pb.StateChunk) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if err != nil || keys != 5 {
+		t.Fatalf("Expected 5 keys exported, got %d (%v)", keys, err)
+	}
+	if len(chunks) != 3 || len(chunks[2].KeyValues) != 1 || chunks[2].Sequence != 2 {
+		t.Fatalf("Expected 3 chunks of at most 2 keys, got %v", chunks)
+	}
+	// the memory store iterates its keys in order
+	if kv := chunks[0].KeyValues[0]; kv.Key != "k0" || string(kv.Value) != "v0" {
+		t.Fatalf("Expected chunks ordered by key, got %v", chunks[0])
+	}
+
+	target := newStateExportSupport(NewMemStateStore())
+	target.stateStore.SetState("mycc2", "k0", []byte("stale"))
+	for _, chunk := range chunks {
+		if err = target.ImportState(chunk); err != nil {
+			t.Fatalf("Error importing chunk %d: %s", chunk.Sequence, err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if v, _ := target.stateStore.GetState("mycc2", fmt.Sprintf("k%d", i), true); string(v) != fmt.Sprintf("v%d", i) {
+			t.Fatalf("Expected v%d imported, got %s", i, v)
+		}
+	}
+
+	// A chaincode without state is one empty chunk
+	chunks = nil
+	if keys, err = source.ExportState("none", 10, func(chunk *pb.StateChunk) error {
+		chunks = append(chunks, chunk)
+		return nil
+	}); err != nil || keys != 0 || len(chunks) != 1 || len(chunks[0].KeyValues) != 0 {
+		t.Fatalf("Expected one empty chunk, got %v (%v)", chunks, err)
+	}
+}
+
+func TestImportStateDelta(t *testing.T) {
+	ledgerState := &deltaState{mapState: mapState{}}
+	ledgerState.SetState("mycc", "a", []byte("1"))
+	kvs := []*pb.StateKeyValue{{Key: "a", Value: []byte("2")}, {Key: "b", Value: []byte("3")}, {Key: "empty"}}
+	if err := importStateDelta(ledgerState, "mycc", kvs); err != nil {
+		t.Fatalf("Error importing state delta: %s", err)
+	}
+	if v, _ := ledgerState.GetState("mycc", "a", true); string(v) != "2" {
+		t.Fatalf("Expected a to be overwritten, got %s", v)
+	}
+	if v, _ := ledgerState.GetState("mycc", "b", true); string(v) != "3" {
+		t.Fatalf("Expected b to be imported, got %s", v)
+	}
+	if v, _ := ledgerState.GetState("mycc", "empty", true); v == nil || len(v) != 0 {
+		t.Fatalf("Expected an empty value to be imported, got %v", v)
+	}
+}
+
+func TestImportStateRefusedOnValidator(t *testing.T) {
+	validator := newStateExportSupport(nil)
+	validator.validator = true
+	chunk := &pb.StateChunk{ChaincodeID: "mycc", KeyValues: []*pb.StateKeyValue{{Key: "a", Value: []byte("1")}}}
+	if err := validator.ImportState(chunk); err == nil {
+		t.Fatal("Expected a validating peer to refuse to import state into its ledger")
+	}
+}
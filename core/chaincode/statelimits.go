@@ -0,0 +1,112 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// stateKeyLimits bounds the keys chaincodes read and write and the values
+// they write. A zero maxKeyLength or maxValueSize and a nil keyPattern
+// disable the corresponding check.
+type stateKeyLimits struct {
+	maxKeyLength int
+	maxValueSize int
+	keyPattern   *regexp.Regexp
+}
+
+// newStateKeyLimits returns the limits allowing keys made of keyCharacters,
+// the contents of a regular expression character class, any key if empty
+func newStateKeyLimits(maxKeyLength int, maxValueSize int, keyCharacters string) (*stateKeyLimits, error) {
+	l := &stateKeyLimits{maxKeyLength: maxKeyLength, maxValueSize: maxValueSize}
+	if keyCharacters != "" {
+		pattern, err := regexp.Compile("^[" + keyCharacters + "]*$")
+		if err != nil {
+			return nil, fmt.Errorf("Invalid key characters %q: %s", keyCharacters, err)
+		}
+		l.keyPattern = pattern
+	}
+	return l, nil
+}
+
+// stateLimitError is returned for a key or value breaking the limits, the
+// details tell the chaincode which limit and by how much
+type stateLimitError struct {
+	msg     string
+	details map[string]string
+}
+
+func (e *stateLimitError) Error() string {
+	return e.msg
+}
+
+// checkKey returns a stateLimitError if key is too long or has characters
+// that are not allowed
+func (l *stateKeyLimits) checkKey(key string) error {
+	if l == nil {
+		return nil
+	}
+	if l.maxKeyLength > 0 && len(key) > l.maxKeyLength {
+		return &stateLimitError{
+			msg:     fmt.Sprintf("Key of %d bytes exceeds the maximum of %d", len(key), l.maxKeyLength),
+			details: map[string]string{"limit": "maxKeyLength", "max": strconv.Itoa(l.maxKeyLength), "size": strconv.Itoa(len(key))},
+		}
+	}
+	if l.keyPattern != nil && !l.keyPattern.MatchString(key) {
+		return &stateLimitError{
+			msg:     fmt.Sprintf("Key %q has characters that are not allowed", key),
+			details: map[string]string{"key": key, "limit": "keyCharacters"},
+		}
+	}
+	return nil
+}
+
+// checkValue returns a stateLimitError if value, written to key, is too large
+func (l *stateKeyLimits) checkValue(key string, value []byte) error {
+	if l == nil || l.maxValueSize <= 0 || len(value) <= l.maxValueSize {
+		return nil
+	}
+	return &stateLimitError{
+		msg:     fmt.Sprintf("Value of %d bytes for key %q exceeds the maximum of %d", len(value), key, l.maxValueSize),
+		details: map[string]string{"key": key, "limit": "maxValueSize", "max": strconv.Itoa(l.maxValueSize), "size": strconv.Itoa(len(value))},
+	}
+}
+
+// stateLimitMessage returns the ERROR answering msg if the state request
+// breaks the limits, nil if it does not
+func (handler *Handler) stateLimitMessage(msg *pb.ChaincodeMessage, key string, value []byte) *pb.ChaincodeMessage {
+	if handler.chaincodeSupport == nil {
+		return nil
+	}
+	limits := handler.chaincodeSupport.stateKeyLimits
+	err := limits.checkKey(key)
+	if err == nil && value != nil {
+		err = limits.checkValue(key, value)
+	}
+	if err == nil {
+		return nil
+	}
+	handler.log(msg).Warning("Refusing %s: %s", msg.Type, err)
+	return handler.errorMessage(msg, pb.ChaincodeError_INVALID_ARGUMENT, err, err.(*stateLimitError).details)
+}
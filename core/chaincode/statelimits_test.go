@@ -0,0 +1,75 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"strings"
+	"testing"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestStateKeyLimits(t *testing.T) {
+	if _, err := newStateKeyLimits(0, 0, "z-a"); err == nil {
+		t.Fatal("Expected an error for invalid key characters")
+	}
+	limits, err := newStateKeyLimits(8, 4, "a-z0-9_")
+	if err != nil {
+		t.Fatalf("Error creating limits: %s", err)
+	}
+	if err = limits.checkKey("key_1"); err != nil {
+		t.Fatalf("Unexpected error for a valid key: %s", err)
+	}
+	if err = limits.checkKey("much_too_long"); err == nil || err.(*stateLimitError).details["limit"] != "maxKeyLength" {
+		t.Fatalf("Expected maxKeyLength to be enforced, got %v", err)
+	}
+	if err = limits.checkKey("Key"); err == nil || err.(*stateLimitError).details["limit"] != "keyCharacters" {
+		t.Fatalf("Expected keyCharacters to be enforced, got %v", err)
+	}
+	if err = limits.checkValue("key", []byte("1234")); err != nil {
+		t.Fatalf("Unexpected error for a value at the limit: %s", err)
+	}
+	if err = limits.checkValue("key", []byte("12345")); err == nil || err.(*stateLimitError).details["size"] != "5" {
+		t.Fatalf("Expected maxValueSize to be enforced, got %v", err)
+	}
+
+	var unlimited *stateKeyLimits
+	if unlimited.checkKey(strings.Repeat("K", 1024)) != nil || unlimited.checkValue("k", make([]byte, 1024)) != nil {
+		t.Fatal("Expected no limits to allow anything")
+	}
+}
+
+func TestHandleGetStateRefusesInvalidKey(t *testing.T) {
+	limits, _ := newStateKeyLimits(0, 0, "a-z")
+	stream := newMockChaincodeStream()
+	handler := newTestHandler(stream)
+	handler.chaincodeSupport = &ChaincodeSupport{stateKeyLimits: limits}
+	handler.protocolVersion = pb.ChaincodeProtocolV6
+
+	handler.handleGetState(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_GET_STATE, Payload: []byte("BAD KEY"), Uuid: "tx1"})
+	msg := waitForNotification(t, stream.sendCh)
+	if msg.Type != pb.ChaincodeMessage_ERROR || msg.Uuid != "tx1" {
+		t.Fatalf("Expected ERROR for tx1, got %s for %s", msg.Type, msg.Uuid)
+	}
+	chaincodeErr := pb.ChaincodeErrorFromPayload(handler.protocolVersion, msg.Payload)
+	if chaincodeErr.Code != pb.ChaincodeError_INVALID_ARGUMENT || chaincodeErr.Details["key"] != "BAD KEY" {
+		t.Fatalf("Expected %s for the key, got %v", pb.ChaincodeError_INVALID_ARGUMENT, chaincodeErr)
+	}
+}
@@ -0,0 +1,316 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/tecbot/gorocksdb"
+
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+)
+
+// StateStore is the state chaincodes read and write with GET_STATE,
+// PUT_STATE, DEL_STATE and RANGE_QUERY_STATE. It is satisfied by
+// *ledger.Ledger, the default backend. Writes to other backends are not part
+// of the blocks nor of their state hash.
+type StateStore interface {
+	GetState(chaincodeID string, key string, committed bool) ([]byte, error)
+	SetState(chaincodeID string, key string, value []byte) error
+	DeleteState(chaincodeID string, key string) error
+	// GetStateRangeScanIterator iterates the keys from startKey to endKey,
+	// both included, to the last key if endKey is empty
+	GetStateRangeScanIterator(chaincodeID string, startKey string, endKey string, committed bool) (statemgmt.RangeScanIterator, error)
+}
+
+// State store backends selected with chaincode.stateStore.backend
+const (
+	LedgerStateStore  = "ledger"
+	MemoryStateStore  = "memory"
+	RocksDBStateStore = "rocksdb"
+)
+
+// newStateStore returns the store of backend, nil for the ledger which is
+// resolved per chain
+func newStateStore(backend string, path string) (StateStore, error) {
+	switch backend {
+	case "", LedgerStateStore:
+		return nil, nil
+	case MemoryStateStore:
+		return NewMemStateStore(), nil
+	case RocksDBStateStore:
+		return NewRocksDBStateStore(path)
+	}
+	return nil, fmt.Errorf("Unknown state store backend %s", backend)
+}
+
+// SetStateStore replaces the store chaincode state operations are served
+// from, nil for the ledger of the chain
+func (chaincodeSupport *ChaincodeSupport) SetStateStore(store StateStore) {
+	chaincodeSupport.stateStore = store
+}
+
+// memStateStore is a StateStore keeping the state in memory. Writes are
+// visible at once, committed or not.
+type memStateStore struct {
+	sync.RWMutex
+	state map[string]map[string][]byte
+}
+
+// NewMemStateStore returns an empty in-memory StateStore, for tests
+func NewMemStateStore() StateStore {
+	return &memStateStore{state: make(map[string]map[string][]byte)}
+}
+
+func (m *memStateStore) GetState(chaincodeID string, key string, committed bool) ([]byte, error) {
+	m.RLock()
+	defer m.RUnlock()
+	value, ok := m.state[chaincodeID][key]
+	if !ok {
+		return nil, nil
+	}
+	return statemgmt.Copy(value), nil
+}
+
+func (m *memStateStore) SetState(chaincodeID string, key string, value []byte) error {
+	if value == nil {
+		return fmt.Errorf("Cannot set the state of key %s to nil", key)
+	}
+	m.Lock()
+	defer m.Unlock()
+	if m.state[chaincodeID] == nil {
+		m.state[chaincodeID] = make(map[string][]byte)
+	}
+	m.state[chaincodeID][key] = statemgmt.Copy(value)
+	return nil
+}
+
+func (m *memStateStore) DeleteState(chaincodeID string, key string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.state[chaincodeID], key)
+	return nil
+}
+
+// WriteStates applies writes atomically
+func (m *memStateStore) WriteStates(writes []*stateWriteOp) error {
+	if err := validateStateWrites(writes); err != nil {
+		return err
+	}
+	m.Lock()
+	defer m.Unlock()
+	for _, write := range writes {
+		if write.isDelete {
+			delete(m.state[write.chaincodeID], write.key)
+			continue
+		}
+		if m.state[write.chaincodeID] == nil {
+			m.state[write.chaincodeID] = make(map[string][]byte)
+		}
+		m.state[write.chaincodeID][write.key] = statemgmt.Copy(write.value)
+	}
+	return nil
+}
+
+func (m *memStateStore) GetStateRangeScanIterator(chaincodeID string, startKey string, endKey string, committed bool) (statemgmt.RangeScanIterator, error) {
+	m.RLock()
+	defer m.RUnlock()
+	itr := &memRangeScanIterator{position: -1}
+	for key, value := range m.state[chaincodeID] {
+		if key >= startKey && (endKey == "" || key <= endKey) {
+			itr.keys = append(itr.keys, key)
+			itr.values = append(itr.values, value)
+		}
+	}
+	sort.Sort(itr)
+	return itr, nil
+}
+
+// memRangeScanIterator iterates a copy of the keys in range taken when the
+// scan started
+type memRangeScanIterator struct {
+	keys     []string
+	values   [][]byte
+	position int
+}
+
+func (itr *memRangeScanIterator) Len() int           { return len(itr.keys) }
+func (itr *memRangeScanIterator) Less(i, j int) bool { return itr.keys[i] < itr.keys[j] }
+func (itr *memRangeScanIterator) Swap(i, j int) {
+	itr.keys[i], itr.keys[j] = itr.keys[j], itr.keys[i]
+	itr.values[i], itr.values[j] = itr.values[j], itr.values[i]
+}
+
+// Next - see interface 'statemgmt.RangeScanIterator' for details
+func (itr *memRangeScanIterator) Next() bool {
+	if itr.position < len(itr.keys) {
+		itr.position++
+	}
+	return itr.position < len(itr.keys)
+}
+
+// GetKeyValue - see interface 'statemgmt.RangeScanIterator' for details
+func (itr *memRangeScanIterator) GetKeyValue() (string, []byte) {
+	return itr.keys[itr.position], statemgmt.Copy(itr.values[itr.position])
+}
+
+// Close - see interface 'statemgmt.RangeScanIterator' for details
+func (itr *memRangeScanIterator) Close() {}
+
+// rocksDBStateStore is a StateStore keeping the state in a RocksDB database
+// of its own, apart from the ledger. Writes are persisted at once, committed
+// or not.
+type rocksDBStateStore struct {
+	db *gorocksdb.DB
+}
+
+// NewRocksDBStateStore opens, creating it if needed, the RocksDB database at
+// path as a StateStore
+func NewRocksDBStateStore(path string) (StateStore, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, fmt.Errorf("Error creating state store directory %s: %s", path, err)
+	}
+	opts := gorocksdb.NewDefaultOptions()
+	defer opts.Destroy()
+	opts.SetCreateIfMissing(true)
+	db, err := gorocksdb.OpenDb(opts, path)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening state store %s: %s", path, err)
+	}
+	return &rocksDBStateStore{db: db}, nil
+}
+
+func (r *rocksDBStateStore) GetState(chaincodeID string, key string, committed bool) ([]byte, error) {
+	opts := gorocksdb.NewDefaultReadOptions()
+	defer opts.Destroy()
+	slice, err := r.db.Get(opts, statemgmt.ConstructCompositeKey(chaincodeID, key))
+	if err != nil {
+		return nil, err
+	}
+	defer slice.Free()
+	if slice.Data() == nil {
+		return nil, nil
+	}
+	return statemgmt.Copy(slice.Data()), nil
+}
+
+func (r *rocksDBStateStore) SetState(chaincodeID string, key string, value []byte) error {
+	if value == nil {
+		return fmt.Errorf("Cannot set the state of key %s to nil", key)
+	}
+	opts := gorocksdb.NewDefaultWriteOptions()
+	defer opts.Destroy()
+	return r.db.Put(opts, statemgmt.ConstructCompositeKey(chaincodeID, key), value)
+}
+
+func (r *rocksDBStateStore) DeleteState(chaincodeID string, key string) error {
+	opts := gorocksdb.NewDefaultWriteOptions()
+	defer opts.Destroy()
+	return r.db.Delete(opts, statemgmt.ConstructCompositeKey(chaincodeID, key))
+}
+
+// WriteStates applies writes atomically in one batch, synced to disk before
+// it returns
+func (r *rocksDBStateStore) WriteStates(writes []*stateWriteOp) error {
+	batch := gorocksdb.NewWriteBatch()
+	defer batch.Destroy()
+	for _, write := range writes {
+		if write.isDelete {
+			batch.Delete(statemgmt.ConstructCompositeKey(write.chaincodeID, write.key))
+		} else if write.value == nil {
+			return fmt.Errorf("Cannot set the state of key %s to nil", write.key)
+		} else {
+			batch.Put(statemgmt.ConstructCompositeKey(write.chaincodeID, write.key), write.value)
+		}
+	}
+	opts := gorocksdb.NewDefaultWriteOptions()
+	defer opts.Destroy()
+	opts.SetSync(true)
+	return r.db.Write(opts, batch)
+}
+
+func (r *rocksDBStateStore) GetStateRangeScanIterator(chaincodeID string, startKey string, endKey string, committed bool) (statemgmt.RangeScanIterator, error) {
+	opts := gorocksdb.NewDefaultReadOptions()
+	defer opts.Destroy()
+	dbItr := r.db.NewIterator(opts)
+	dbItr.Seek(statemgmt.ConstructCompositeKey(chaincodeID, startKey))
+	end := statemgmt.ConstructCompositeKey(chaincodeID, endKey)
+	if endKey == "" {
+		// composite keys of chaincodeID are followed by a zero byte
+		end = []byte(chaincodeID + "\x01")
+	}
+	return &rocksDBRangeScanIterator{dbItr: dbItr, endKey: end, inclusive: endKey != ""}, nil
+}
+
+// rocksDBRangeScanIterator iterates the composite keys of a state store up
+// to endKey
+type rocksDBRangeScanIterator struct {
+	dbItr     *gorocksdb.Iterator
+	endKey    []byte
+	inclusive bool
+	started   bool
+	key       string
+	value     []byte
+}
+
+// Next - see interface 'statemgmt.RangeScanIterator' for details
+func (itr *rocksDBRangeScanIterator) Next() bool {
+	if itr.started {
+		itr.dbItr.Next()
+	}
+	itr.started = true
+	if !itr.dbItr.Valid() {
+		return false
+	}
+	keySlice := itr.dbItr.Key()
+	defer keySlice.Free()
+	compositeKey := keySlice.Data()
+	if c := bytes.Compare(compositeKey, itr.endKey); c > 0 || (c == 0 && !itr.inclusive) {
+		return false
+	}
+	valueSlice := itr.dbItr.Value()
+	defer valueSlice.Free()
+	_, itr.key = statemgmt.DecodeCompositeKey(compositeKey)
+	itr.value = statemgmt.Copy(valueSlice.Data())
+	return true
+}
+
+// GetKeyValue - see interface 'statemgmt.RangeScanIterator' for details
+func (itr *rocksDBRangeScanIterator) GetKeyValue() (string, []byte) {
+	return itr.key, itr.value
+}
+
+// Close - see interface 'statemgmt.RangeScanIterator' for details
+func (itr *rocksDBRangeScanIterator) Close() {
+	itr.dbItr.Close()
+}
+
+// stateStoreOf returns the store of the state of the chain whose ledger is
+// ledgerObj
+func (chaincodeSupport *ChaincodeSupport) stateStoreOf(ledgerObj StateStore) StateStore {
+	if chaincodeSupport == nil || chaincodeSupport.stateStore == nil {
+		return ledgerObj
+	}
+	return chaincodeSupport.stateStore
+}
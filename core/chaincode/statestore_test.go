@@ -0,0 +1,137 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func scanKeys(t *testing.T, store StateStore, chaincodeID string, startKey string, endKey string) []string {
+	itr, err := store.GetStateRangeScanIterator(chaincodeID, startKey, endKey, true)
+	if err != nil {
+		t.Fatalf("Error scanning %s-%s: %s", startKey, endKey, err)
+	}
+	defer itr.Close()
+	var keys []string
+	for itr.Next() {
+		key, value := itr.GetKeyValue()
+		if string(value) != key {
+			t.Fatalf("Expected value %s for key %s, got %s", key, key, value)
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func testStateStore(t *testing.T, store StateStore) {
+	for _, key := range []string{"b", "a", "c", "d"} {
+		if err := store.SetState("cc", key, []byte(key)); err != nil {
+			t.Fatalf("Error setting %s: %s", key, err)
+		}
+	}
+	store.SetState("other", "a", []byte("a"))
+	store.SetState("cc2", "a", []byte("a"))
+
+	if v, err := store.GetState("cc", "a", false); err != nil || string(v) != "a" {
+		t.Fatalf("Expected a, got %s (%v)", v, err)
+	}
+	if err := store.DeleteState("cc", "d"); err != nil {
+		t.Fatalf("Error deleting d: %s", err)
+	}
+	if v, err := store.GetState("cc", "d", true); err != nil || v != nil {
+		t.Fatalf("Expected deleted key to be nil, got %s (%v)", v, err)
+	}
+	if err := store.SetState("cc", "e", nil); err == nil {
+		t.Fatal("Expected setting a nil value to fail")
+	}
+
+	if keys := scanKeys(t, store, "cc", "b", "c"); len(keys) != 2 || keys[0] != "b" || keys[1] != "c" {
+		t.Fatalf("Expected [b c] with the end key included, got %v", keys)
+	}
+	if keys := scanKeys(t, store, "cc", "", ""); len(keys) != 3 || keys[0] != "a" || keys[2] != "c" {
+		t.Fatalf("Expected the keys of cc only, got %v", keys)
+	}
+	if keys := scanKeys(t, store, "none", "", ""); len(keys) != 0 {
+		t.Fatalf("Expected no keys, got %v", keys)
+	}
+
+	batcher := store.(batchStateWriter)
+	if err := batcher.WriteStates([]*stateWriteOp{{chaincodeID: "cc", key: "f", value: []byte("f")}, {chaincodeID: "cc", key: "a", isDelete: true}}); err != nil {
+		t.Fatalf("Error writing a batch: %s", err)
+	}
+	if keys := scanKeys(t, store, "cc", "", ""); len(keys) != 3 || keys[0] != "b" || keys[2] != "f" {
+		t.Fatalf("Expected [b c f] after the batch, got %v", keys)
+	}
+	if err := batcher.WriteStates([]*stateWriteOp{{chaincodeID: "cc", key: "g", value: []byte("g")}, {chaincodeID: "cc", key: "h"}}); err == nil {
+		t.Fatal("Expected a batch setting a nil value to fail")
+	}
+	if v, _ := store.GetState("cc", "g", false); v != nil {
+		t.Fatalf("Expected nothing of a failed batch to be written, got %s", v)
+	}
+}
+
+func TestMemStateStore(t *testing.T) {
+	testStateStore(t, NewMemStateStore())
+}
+
+func TestRocksDBStateStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := NewRocksDBStateStore(dir)
+	if err != nil {
+		t.Fatalf("Error opening store: %s", err)
+	}
+	testStateStore(t, store)
+}
+
+func TestStateStoreSelection(t *testing.T) {
+	if store, err := newStateStore(LedgerStateStore, ""); err != nil || store != nil {
+		t.Fatalf("Expected the ledger backend to be resolved per chain, got %v (%v)", store, err)
+	}
+	if _, err := newStateStore("bolt", ""); err == nil {
+		t.Fatal("Expected an unknown backend to be rejected")
+	}
+	store, err := newStateStore(MemoryStateStore, "")
+	if err != nil {
+		t.Fatalf("Error creating memory store: %s", err)
+	}
+
+	cs := &ChaincodeSupport{stateCache: newStateCache(10)}
+	ledgerState := &countingState{mapState: mapState{}}
+	if cs.stateStoreOf(nil) != nil {
+		t.Fatal("Expected the ledger to be used without a state store")
+	}
+	cs.SetStateStore(store)
+	if cs.stateStoreOf(nil) != store {
+		t.Fatal("Expected the configured state store to replace the ledger")
+	}
+	// only the ledger reports the changes the cache is invalidated with
+	if _, ok := cs.stateAccess(store).(*cachedState); ok {
+		t.Fatal("Expected a state store other than the ledger not to be cached")
+	}
+	if _, ok := cs.stateAccess(ledgerState).(*cachedState); !ok {
+		t.Fatal("Expected the ledger state to be cached")
+	}
+}
@@ -0,0 +1,220 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"time"
+)
+
+// stateWriteOp is a SetState or DeleteState of a chaincode
+type stateWriteOp struct {
+	chaincodeID string
+	key         string
+	value       []byte
+	isDelete    bool
+}
+
+// batchStateWriter is a state store that can apply many writes atomically
+type batchStateWriter interface {
+	WriteStates(writes []*stateWriteOp) error
+}
+
+// stateWriteRequest are the writes of one state request of a handler, acked
+// on done once applied
+type stateWriteRequest struct {
+	state  stateAccessor
+	writes []*stateWriteOp
+	done   chan error
+}
+
+var errStateWriterStopped = fmt.Errorf("state writer stopped")
+
+// stateWriter applies the state writes of all the handlers of a chain in
+// batches, flushed every flushInterval or as soon as flushSize writes are
+// pending. Requests are applied in the order they were made, so writes to a
+// key are applied in order. A request returns once its writes are applied.
+type stateWriter struct {
+	requests      chan *stateWriteRequest
+	flushInterval time.Duration
+	flushSize     int
+	stop          chan struct{}
+	done          chan struct{}
+
+	// counted by the writer goroutine, read once it stopped
+	batches uint64
+	written uint64
+}
+
+func newStateWriter(flushInterval time.Duration, flushSize int) *stateWriter {
+	w := &stateWriter{
+		requests:      make(chan *stateWriteRequest),
+		flushInterval: flushInterval,
+		flushSize:     flushSize,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// write applies writes to state with the next batch and returns once it has
+func (w *stateWriter) write(state stateAccessor, writes ...*stateWriteOp) error {
+	req := &stateWriteRequest{state: state, writes: writes, done: make(chan error, 1)}
+	select {
+	case w.requests <- req:
+	case <-w.done:
+		return errStateWriterStopped
+	}
+	return <-req.done
+}
+
+// close flushes the pending writes and stops the writer
+func (w *stateWriter) close() {
+	close(w.stop)
+	<-w.done
+}
+
+func (w *stateWriter) run() {
+	defer close(w.done)
+	var pending []*stateWriteRequest
+	size := 0
+	timer := time.NewTimer(w.flushInterval)
+	timer.Stop()
+	flush := func() {
+		timer.Stop()
+		w.flush(pending)
+		pending, size = nil, 0
+	}
+	for {
+		select {
+		case req := <-w.requests:
+			pending = append(pending, req)
+			size += len(req.writes)
+			if size >= w.flushSize {
+				flush()
+			} else if len(pending) == 1 {
+				timer.Reset(w.flushInterval)
+			}
+		case <-timer.C:
+			flush()
+		case <-w.stop:
+			flush()
+			return
+		}
+	}
+}
+
+// flush applies the writes of the requests, those to the same state in one
+// batch if it can apply them atomically, and acks the requests. The error of
+// a request is only returned to it: a request with an invalid write is
+// refused before the batch, and should the batch fail anyway its requests
+// are applied one at a time.
+func (w *stateWriter) flush(pending []*stateWriteRequest) {
+	if len(pending) == 0 {
+		return
+	}
+	var order []stateAccessor
+	groups := make(map[stateAccessor][]*stateWriteRequest)
+	for _, req := range pending {
+		if _, ok := groups[req.state]; !ok {
+			order = append(order, req.state)
+		}
+		groups[req.state] = append(groups[req.state], req)
+	}
+	for _, state := range order {
+		reqs := groups[state]
+		if batcher, ok := state.(batchStateWriter); ok {
+			w.writeBatch(batcher, reqs)
+		} else {
+			for _, req := range reqs {
+				req.done <- applyStateWrites(state, req.writes)
+			}
+		}
+		w.batches++
+		for _, req := range reqs {
+			w.written += uint64(len(req.writes))
+		}
+	}
+}
+
+// writeBatch applies the valid requests of reqs in one batch with batcher
+func (w *stateWriter) writeBatch(batcher batchStateWriter, reqs []*stateWriteRequest) {
+	var valid []*stateWriteRequest
+	var writes []*stateWriteOp
+	for _, req := range reqs {
+		if err := validateStateWrites(req.writes); err != nil {
+			req.done <- err
+			continue
+		}
+		valid = append(valid, req)
+		writes = append(writes, req.writes...)
+	}
+	if len(valid) == 0 {
+		return
+	}
+	err := batcher.WriteStates(writes)
+	if err == nil || len(valid) == 1 {
+		for _, req := range valid {
+			req.done <- err
+		}
+		return
+	}
+	// The batch applied none of its writes, each request gets the error of
+	// its own writes
+	chaincodeLog.Warning("Error writing a batch of %d state writes, writing its %d requests one at a time: %s", len(writes), len(valid), err)
+	for _, req := range valid {
+		req.done <- batcher.WriteStates(req.writes)
+	}
+}
+
+// validateStateWrites returns an error if a write of writes cannot be applied
+func validateStateWrites(writes []*stateWriteOp) error {
+	for _, write := range writes {
+		if !write.isDelete && write.value == nil {
+			return fmt.Errorf("Cannot set the state of key %s to nil", write.key)
+		}
+	}
+	return nil
+}
+
+// applyStateWrites applies writes to state one after the other
+func applyStateWrites(state stateAccessor, writes []*stateWriteOp) error {
+	for _, write := range writes {
+		var err error
+		if write.isDelete {
+			err = state.DeleteState(write.chaincodeID, write.key)
+		} else {
+			err = state.SetState(write.chaincodeID, write.key, write.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeState applies writes to state, through the state writer if enabled
+func (chaincodeSupport *ChaincodeSupport) writeState(state stateAccessor, writes ...*stateWriteOp) error {
+	if chaincodeSupport == nil || chaincodeSupport.stateWriter == nil {
+		return applyStateWrites(state, writes)
+	}
+	return chaincodeSupport.stateWriter.write(state, writes...)
+}
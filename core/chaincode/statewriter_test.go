@@ -0,0 +1,166 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingStateStore is a StateStore recording the size of the batches it
+// was written
+type recordingStateStore struct {
+	StateStore
+	sync.Mutex
+	batches []int
+}
+
+func (r *recordingStateStore) WriteStates(writes []*stateWriteOp) error {
+	r.Lock()
+	r.batches = append(r.batches, len(writes))
+	r.Unlock()
+	return r.StateStore.(batchStateWriter).WriteStates(writes)
+}
+
+func TestStateWriterCoalescesRequests(t *testing.T) {
+	store := &recordingStateStore{StateStore: NewMemStateStore()}
+	w := newStateWriter(time.Hour, 3)
+	defer w.close()
+
+	var wg sync.WaitGroup
+	for _, key := range []string{"a", "b", "c"} {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			if err := w.write(store, &stateWriteOp{chaincodeID: "cc", key: key, value: []byte(key)}); err != nil {
+				t.Errorf("Error writing %s: %s", key, err)
+			}
+		}(key)
+	}
+	wg.Wait()
+
+	// the writes are acked only once flushed, which flushSize triggers
+	if len(store.batches) != 1 || store.batches[0] != 3 {
+		t.Fatalf("Expected a single batch of 3 writes, got %v", store.batches)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if value, _ := store.GetState("cc", key, false); string(value) != key {
+			t.Fatalf("Expected %s to be written, got %q", key, value)
+		}
+	}
+}
+
+// failingStateStore is a StateStore failing the batches writing key fail
+type failingStateStore struct {
+	StateStore
+}
+
+func (f *failingStateStore) WriteStates(writes []*stateWriteOp) error {
+	for _, write := range writes {
+		if write.key == "fail" {
+			return fmt.Errorf("cannot write %s", write.key)
+		}
+	}
+	return f.StateStore.(batchStateWriter).WriteStates(writes)
+}
+
+func TestStateWriterKeepsErrorsWithTheirRequest(t *testing.T) {
+	for name, store := range map[string]StateStore{
+		"invalid write": &recordingStateStore{StateStore: NewMemStateStore()},
+		"failed batch":  &failingStateStore{StateStore: NewMemStateStore()},
+	} {
+		w := newStateWriter(time.Hour, 3)
+		bad := &stateWriteOp{chaincodeID: "cc", key: "nil"}
+		if name == "failed batch" {
+			bad = &stateWriteOp{chaincodeID: "cc", key: "fail", value: []byte("v")}
+		}
+		errs := make(map[string]chan error)
+		for _, write := range []*stateWriteOp{{chaincodeID: "cc", key: "a", value: []byte("a")}, bad, {chaincodeID: "cc", key: "b", value: []byte("b")}} {
+			done := make(chan error, 1)
+			errs[write.key] = done
+			go func(write *stateWriteOp) { done <- w.write(store, write) }(write)
+		}
+		if err := <-errs[bad.key]; err == nil {
+			t.Fatalf("%s: Expected the bad write to fail", name)
+		}
+		for _, key := range []string{"a", "b"} {
+			if err := <-errs[key]; err != nil {
+				t.Fatalf("%s: Expected %s to be written, got %s", name, key, err)
+			}
+			if value, _ := store.GetState("cc", key, false); string(value) != key {
+				t.Fatalf("%s: Expected %s to be written, got %q", name, key, value)
+			}
+		}
+		w.close()
+	}
+}
+
+func TestStateWriterFlushesOnInterval(t *testing.T) {
+	store := NewMemStateStore()
+	w := newStateWriter(10*time.Millisecond, 100)
+	defer w.close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- w.write(store,
+			&stateWriteOp{chaincodeID: "cc", key: "k", value: []byte("1")},
+			&stateWriteOp{chaincodeID: "cc", key: "k", isDelete: true},
+			&stateWriteOp{chaincodeID: "cc", key: "k", value: []byte("2")})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Error writing: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the flush")
+	}
+	// writes to a key are applied in order
+	if value, _ := store.GetState("cc", "k", false); string(value) != "2" {
+		t.Fatalf("Expected k to be 2, got %q", value)
+	}
+}
+
+func TestStateWriterAppliesWritesOneByOneWithoutBatches(t *testing.T) {
+	// a state that cannot apply batches, like the ledger
+	state := struct{ stateAccessor }{NewMemStateStore()}
+	w := newStateWriter(time.Millisecond, 100)
+
+	err := w.write(state, &stateWriteOp{chaincodeID: "cc", key: "k", value: []byte("v")})
+	if err != nil {
+		t.Fatalf("Error writing: %s", err)
+	}
+	if err = w.write(state, &stateWriteOp{chaincodeID: "cc", key: "nil"}); err == nil {
+		t.Fatal("Expected the error of the state to be returned")
+	}
+	if value, _ := state.GetState("cc", "k", false); string(value) != "v" {
+		t.Fatalf("Expected k to be written, got %q", value)
+	}
+
+	w.close()
+	if w.written != 2 {
+		t.Fatalf("Expected 2 writes to be counted, got %d", w.written)
+	}
+	if err = w.write(state, &stateWriteOp{chaincodeID: "cc", key: "k", isDelete: true}); err != errStateWriterStopped {
+		t.Fatalf("Expected %s once stopped, got %v", errStateWriterStopped, err)
+	}
+}
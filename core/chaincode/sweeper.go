@@ -0,0 +1,90 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// staleEntry is a transaction notifier or state request UUID released by
+// sweepStale
+type staleEntry struct {
+	uuid     string
+	deadline time.Time
+}
+
+// sweepTicker returns the ticks on which the stale entries of the handler
+// are swept, nil if chaincode.sweeper.interval disables the sweeper
+func (handler *Handler) sweepTicker() *time.Ticker {
+	if handler.chaincodeSupport == nil || handler.chaincodeSupport.sweepInterval <= 0 {
+		return nil
+	}
+	return time.NewTicker(handler.chaincodeSupport.sweepInterval)
+}
+
+// sweepStale releases the transaction notifiers and state request UUIDs
+// whose transaction exceeded its deadline by more than grace at now. The
+// waiter of a notifier receives an ERROR and the chaincode is told to abort
+// the transaction, should it still be executing it. A UUID of no transaction
+// in flight is given the execution timeout from its first request.
+func (handler *Handler) sweepStale(now time.Time, grace time.Duration) []staleEntry {
+	execTimeout := handler.chaincodeSupport.getExecTimeout()
+	var stale []staleEntry
+	handler.Lock()
+	for uuid, tctx := range handler.txCtxs {
+		if tctx.deadline.IsZero() || now.Before(tctx.deadline.Add(grace)) {
+			continue
+		}
+		for _, v := range tctx.rangeQueryIteratorMap {
+			v.Close()
+		}
+		payload := []byte(fmt.Sprintf("transaction exceeded its deadline %s", tctx.deadline))
+		tctx.respond(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_ERROR, Payload: payload, Uuid: uuid})
+		delete(handler.txCtxs, uuid)
+		handler.releaseUUIDEntry(uuid)
+		delete(handler.isTransaction, uuid)
+		stale = append(stale, staleEntry{uuid, tctx.deadline})
+	}
+	for uuid, created := range handler.uuidMap {
+		if _, inFlight := handler.txCtxs[uuid]; inFlight {
+			continue
+		}
+		if deadline := created.Add(execTimeout); !now.Before(deadline.Add(grace)) {
+			handler.releaseUUIDEntry(uuid)
+			delete(handler.isTransaction, uuid)
+			stale = append(stale, staleEntry{uuid, deadline})
+		}
+	}
+	handler.staleSwept += uint64(len(stale))
+	handler.Unlock()
+
+	for _, entry := range stale {
+		handler.logTx(entry.uuid).Warning("Released orphaned transaction %s of chaincode %s, past its deadline %s", entry.uuid, handler.traceChaincodeName(), entry.deadline)
+		reason := fmt.Errorf("transaction %s exceeded its deadline %s", entry.uuid, entry.deadline)
+		abortMsg := handler.errorMessage(&pb.ChaincodeMessage{Uuid: entry.uuid}, pb.ChaincodeError_TIMEOUT, reason, nil)
+		if err := handler.serialSend(abortMsg); err != nil {
+			handler.logTx(entry.uuid).Debug("Error sending abort of orphaned transaction: %s", err)
+		}
+	}
+	return stale
+}
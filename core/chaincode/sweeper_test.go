@@ -0,0 +1,96 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestSweepStaleReleasesEntriesPastDeadline(t *testing.T) {
+	stream := newMockChaincodeStream()
+	handler := newTestHandler(stream)
+	defer handler.stopWriter()
+	now := time.Now()
+	grace := 5 * time.Second
+
+	expired, err := handler.createTxContext("expired", nil)
+	if err != nil {
+		t.Fatalf("Error creating tx context: %s", err)
+	}
+	expired.deadline = now.Add(-10 * time.Second)
+	if _, err = handler.createTxContext("within-grace", nil); err != nil {
+		t.Fatalf("Error creating tx context: %s", err)
+	}
+	handler.txCtxs["within-grace"].deadline = now.Add(-time.Second)
+	if _, err = handler.createTxContext("no-deadline", nil); err != nil {
+		t.Fatalf("Error creating tx context: %s", err)
+	}
+	handler.uuidMap["expired"] = now
+	handler.uuidMap["orphan"] = now.Add(-2 * execTimeoutDefault)
+	handler.uuidMap["fresh"] = now
+
+	stale := handler.sweepStale(now, grace)
+	if len(stale) != 2 {
+		t.Fatalf("Expected 2 entries to be released, got %v", stale)
+	}
+	for _, uuid := range []string{"within-grace", "no-deadline"} {
+		if handler.txCtxs[uuid] == nil {
+			t.Fatalf("Expected the notifier of %s to be kept", uuid)
+		}
+	}
+	if _, ok := handler.uuidMap["fresh"]; !ok {
+		t.Fatal("Expected the fresh UUID entry to be kept")
+	}
+	for _, uuid := range []string{"expired", "orphan"} {
+		if _, ok := handler.uuidMap[uuid]; ok {
+			t.Fatalf("Expected the UUID entry of %s to be released", uuid)
+		}
+	}
+	if handler.txCtxs["expired"] != nil {
+		t.Fatal("Expected the expired notifier to be released")
+	}
+	if msg := waitForNotification(t, expired.responseNotifier); msg.Type != pb.ChaincodeMessage_ERROR || !strings.Contains(string(msg.Payload), "deadline") {
+		t.Fatalf("Expected the waiter to receive an ERROR, got %s %s", msg.Type, msg.Payload)
+	}
+
+	// the chaincode is told to abort both transactions
+	aborted := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-stream.sendCh:
+			if msg.Type != pb.ChaincodeMessage_ERROR {
+				t.Fatalf("Expected an abort, got %s", msg.Type)
+			}
+			aborted[msg.Uuid] = true
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the aborts")
+		}
+	}
+	if !aborted["expired"] || !aborted["orphan"] {
+		t.Fatalf("Expected expired and orphan to be aborted, got %v", aborted)
+	}
+	if handler.staleSwept != 2 {
+		t.Fatalf("Expected 2 releases to be counted, got %d", handler.staleSwept)
+	}
+}
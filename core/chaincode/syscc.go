@@ -0,0 +1,60 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/container"
+)
+
+// SystemChaincode is a trusted chaincode compiled into the peer. It talks to
+// the peer over an in-memory stream instead of gRPC and does not run in a
+// container. It is invoked by Name like any deployed chaincode and is never
+// deployed, deploy transactions naming it are refused.
+type SystemChaincode struct {
+	//Name the chaincode is invoked by
+	Name string
+
+	//Chaincode implementation
+	Chaincode shim.Chaincode
+}
+
+// RegisterSystemChaincode makes syscc available to the peer. Register system
+// chaincodes before the peer serves transactions.
+func RegisterSystemChaincode(syscc *SystemChaincode) error {
+	if syscc == nil || syscc.Name == "" {
+		return fmt.Errorf("system chaincode name not set")
+	}
+	if syscc.Chaincode == nil {
+		return fmt.Errorf("system chaincode %s has no implementation", syscc.Name)
+	}
+	if container.IsSystemChaincode(syscc.Name) {
+		return fmt.Errorf("system chaincode %s already registered", syscc.Name)
+	}
+
+	cc := syscc.Chaincode
+	container.RegisterSystemChaincode(syscc.Name, func(name string, stream container.ChaincodeStream) error {
+		return shim.StartInProc(name, stream, cc)
+	})
+	chaincodeLog.Info("registered system chaincode %s", syscc.Name)
+	return nil
+}
@@ -0,0 +1,160 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/util"
+	pb "github.com/hyperledger/fabric/protos"
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+)
+
+// kvChaincode stores and reads a single key
+type kvChaincode struct {
+}
+
+func (cc *kvChaincode) Init(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	return nil, nil
+}
+
+func (cc *kvChaincode) Invoke(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	if function == "put" && len(args) == 2 {
+		return nil, stub.PutState(args[0], []byte(args[1]))
+	}
+	return nil, nil
+}
+
+func (cc *kvChaincode) Query(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, errors.New("expected a key")
+	}
+	return stub.GetState(args[0])
+}
+
+func TestRegisterSystemChaincodeValidates(t *testing.T) {
+	if err := RegisterSystemChaincode(&SystemChaincode{Chaincode: &kvChaincode{}}); err == nil {
+		t.Fatal("Expected system chaincode without a name to be rejected")
+	}
+	if err := RegisterSystemChaincode(&SystemChaincode{Name: "nocode"}); err == nil {
+		t.Fatal("Expected system chaincode without an implementation to be rejected")
+	}
+	if err := RegisterSystemChaincode(&SystemChaincode{Name: "dupsyscc", Chaincode: &kvChaincode{}}); err != nil {
+		t.Fatalf("Error registering system chaincode: %s", err)
+	}
+	if err := RegisterSystemChaincode(&SystemChaincode{Name: "dupsyscc", Chaincode: &kvChaincode{}}); err == nil {
+		t.Fatal("Expected duplicate system chaincode to be rejected")
+	}
+}
+
+func TestSystemChaincodeRunsInProcess(t *testing.T) {
+	viper.Set("peer.fileSystemPath", "/var/hyperledger/test/tmpdb")
+	getPeerEndpoint := func() (*pb.PeerEndpoint, error) {
+		return &pb.PeerEndpoint{ID: &pb.PeerID{Name: "testpeer"}, Address: "0.0.0.0:40303"}, nil
+	}
+	NewChaincodeSupport(DefaultChain, getPeerEndpoint, false, 10*time.Second, nil)
+
+	if err := RegisterSystemChaincode(&SystemChaincode{Name: "kvsyscc", Chaincode: &kvChaincode{}}); err != nil {
+		t.Fatalf("Error registering system chaincode: %s", err)
+	}
+	cID := &pb.ChaincodeID{Name: "kvsyscc"}
+	ctxt := context.Background()
+	defer GetChain(DefaultChain).StopChaincode(ctxt, cID)
+
+	//no deploy, the chaincode is part of the peer
+	spec := &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_GOLANG, ChaincodeID: cID, CtorMsg: &pb.ChaincodeInput{Function: "put", Args: []string{"a", "100"}}}
+	if _, _, err := invoke(ctxt, spec, pb.Transaction_CHAINCODE_INVOKE); err != nil {
+		t.Fatalf("Error invoking system chaincode: %s", err)
+	}
+
+	spec = &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_GOLANG, ChaincodeID: cID, CtorMsg: &pb.ChaincodeInput{Function: "get", Args: []string{"a"}}}
+	_, value, err := invoke(ctxt, spec, pb.Transaction_CHAINCODE_QUERY)
+	if err != nil {
+		t.Fatalf("Error querying system chaincode: %s", err)
+	}
+	if string(value) != "100" {
+		t.Fatalf("Expected 100, got %q", value)
+	}
+
+	deployment := &pb.ChaincodeDeploymentSpec{ChaincodeSpec: &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_GOLANG, ChaincodeID: cID}}
+	tx, err := pb.NewChaincodeDeployTransaction(deployment, "kvsyscc")
+	if err != nil {
+		t.Fatalf("Error creating deploy transaction: %s", err)
+	}
+	if _, err = Execute(ctxt, GetChain(DefaultChain), tx); err == nil {
+		t.Fatal("Expected a deploy of a system chaincode to be refused")
+	}
+}
+
+func TestSystemChaincodeRunsOnSecondChain(t *testing.T) {
+	viper.Set("peer.fileSystemPath", "/var/hyperledger/test/tmpdb")
+	getPeerEndpoint := func() (*pb.PeerEndpoint, error) {
+		return &pb.PeerEndpoint{ID: &pb.PeerID{Name: "testpeer"}, Address: "0.0.0.0:40303"}, nil
+	}
+	NewChaincodeSupport(DefaultChain, getPeerEndpoint, false, 10*time.Second, nil)
+	chainID := "chain-" + util.GenerateUUID()
+	chain := NewChaincodeSupport(ChainName(chainID), getPeerEndpoint, false, 10*time.Second, nil)
+	defer chain.Stop()
+
+	if err := RegisterSystemChaincode(&SystemChaincode{Name: "chainsyscc", Chaincode: &kvChaincode{}}); err != nil {
+		t.Fatalf("Error registering system chaincode: %s", err)
+	}
+	cID := &pb.ChaincodeID{Name: "chainsyscc"}
+	ctxt := context.Background()
+	defer chain.StopChaincode(ctxt, cID)
+
+	chainLedger, err := chain.getLedger("")
+	if err != nil {
+		t.Fatalf("Error getting the ledger of chain %s: %s", chainID, err)
+	}
+	defaultLedger, err := ledger.GetLedger()
+	if err != nil {
+		t.Fatalf("Error getting the default ledger: %s", err)
+	}
+	if chainLedger == defaultLedger {
+		t.Fatal("Expected the second chain to have a ledger of its own")
+	}
+
+	spec := &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_GOLANG, ChaincodeID: cID, CtorMsg: &pb.ChaincodeInput{Function: "put", Args: []string{"a", "200"}}}
+	tx, err := pb.NewChaincodeExecute(&pb.ChaincodeInvocationSpec{ChaincodeSpec: spec}, util.GenerateUUID(), pb.Transaction_CHAINCODE_INVOKE)
+	if err != nil {
+		t.Fatalf("Error creating transaction: %s", err)
+	}
+	chainLedger.BeginTxBatch("1")
+	if _, err = Execute(ctxt, chain, tx); err != nil {
+		t.Fatalf("Error invoking system chaincode on chain %s: %s", chainID, err)
+	}
+	if err = chainLedger.CommitTxBatch("1", []*pb.Transaction{tx}, nil, nil); err != nil {
+		t.Fatalf("Error committing on chain %s: %s", chainID, err)
+	}
+
+	value, err := chainLedger.GetState("chainsyscc", "a", true)
+	if err != nil || string(value) != "200" {
+		t.Fatalf("Expected 200 on chain %s, got %q, %v", chainID, value, err)
+	}
+	if value, _ = defaultLedger.GetState("chainsyscc", "a", true); value != nil {
+		t.Fatalf("Expected the default chain not to see the write, got %q", value)
+	}
+}
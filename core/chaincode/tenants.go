@@ -0,0 +1,239 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"io"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// tenantMux demultiplexes the messages of a chaincode support stream shared
+// by several chaincodes. The chaincode that opens the stream lists the
+// others, its tenants, in its REGISTER. Each of them then registers with a
+// REGISTER of its own and its messages carry its name in chaincodeName. Every
+// chaincode of the stream is served by a Handler of its own, as if it had a
+// stream of its own.
+type tenantMux struct {
+	sync.Mutex
+	chaincodeSupport *ChaincodeSupport
+	stream           PeerChaincodeStream
+	// sendLock serializes the sends of the handlers on the stream
+	sendLock sync.Mutex
+	// declared holds the tenants of the stream, nil until the chaincode
+	// that opened it registers some
+	declared map[string]bool
+	tenants  map[string]*tenantStream
+	handlers sync.WaitGroup
+	closed   bool
+}
+
+// tenantStream is the stream of one of the chaincodes sharing a chaincode
+// support stream, fed by the demultiplexer of the stream
+type tenantStream struct {
+	sync.Mutex
+	mux *tenantMux
+	// name is empty for the chaincode that opened the stream
+	name   string
+	queue  []*pb.ChaincodeMessage
+	err    error
+	signal chan struct{}
+}
+
+// serveChaincodeStream serves the chaincode that opened stream and the
+// tenants it registers, until the stream or that chaincode ends
+func serveChaincodeStream(chaincodeSupport *ChaincodeSupport, stream PeerChaincodeStream) error {
+	mux := &tenantMux{chaincodeSupport: chaincodeSupport, stream: stream, tenants: make(map[string]*tenantStream)}
+	primary := newTenantStream(mux, "")
+	go mux.demultiplex(primary)
+
+	err := newChaincodeSupportHandler(chaincodeSupport, primary).processStream()
+
+	// the tenants do not outlive the chaincode that opened the stream
+	mux.Lock()
+	mux.closed = true
+	for _, ts := range mux.tenants {
+		ts.end(io.EOF)
+	}
+	mux.Unlock()
+	mux.handlers.Wait()
+	return err
+}
+
+// demultiplex routes the messages received on the stream to the chaincode
+// they belong to, until the stream fails
+func (mux *tenantMux) demultiplex(primary *tenantStream) {
+	for {
+		msg, err := mux.stream.Recv()
+		if err != nil {
+			mux.Lock()
+			for _, ts := range mux.tenants {
+				ts.end(err)
+			}
+			mux.Unlock()
+			primary.end(err)
+			return
+		}
+		if msg == nil || msg.ChaincodeName == "" {
+			if msg != nil && msg.Type == pb.ChaincodeMessage_REGISTER {
+				mux.declare(msg)
+			}
+			primary.deliver(msg)
+			continue
+		}
+		if ts := mux.tenant(msg); ts != nil {
+			ts.deliver(msg)
+		} else {
+			chaincodeLogger.Warning("[%s]Dropping %s of chaincode %s, not a registered tenant of the stream", shortuuid(msg.Uuid), msg.Type, msg.ChaincodeName)
+		}
+	}
+}
+
+// declare records the tenants listed by the REGISTER msg of the chaincode
+// that opened the stream, if the peer accepts that many and the protocol
+// version negotiated serves them
+func (mux *tenantMux) declare(msg *pb.ChaincodeMessage) {
+	registration := &pb.ChaincodeRegistration{}
+	if proto.Unmarshal(msg.Payload, registration) != nil || len(registration.Tenants) == 0 {
+		return
+	}
+	version, err := negotiateProtocolVersion(registration.MinProtocolVersion, registration.MaxProtocolVersion)
+	if err != nil || version < pb.ChaincodeProtocolV10 {
+		chaincodeLogger.Warning("Ignoring the tenants of chaincode %s, the protocol version negotiated does not serve them", registration.Name)
+		return
+	}
+	if max := mux.chaincodeSupport.maxTenants; len(registration.Tenants) > max {
+		chaincodeLogger.Warning("Ignoring the %d tenants of chaincode %s, at most %d are accepted", len(registration.Tenants), registration.Name, max)
+		return
+	}
+	mux.Lock()
+	defer mux.Unlock()
+	if mux.declared != nil {
+		return
+	}
+	mux.declared = make(map[string]bool)
+	for _, name := range registration.Tenants {
+		if name != "" && name != registration.Name {
+			mux.declared[name] = true
+		}
+	}
+	chaincodeLogger.Debug("Chaincode %s shares its stream with tenants %v", registration.Name, registration.Tenants)
+}
+
+// tenant returns the stream of the tenant msg belongs to. The REGISTER of a
+// declared tenant opens its stream and starts the handler serving it.
+func (mux *tenantMux) tenant(msg *pb.ChaincodeMessage) *tenantStream {
+	mux.Lock()
+	defer mux.Unlock()
+	if ts, ok := mux.tenants[msg.ChaincodeName]; ok {
+		return ts
+	}
+	if mux.closed || !mux.declared[msg.ChaincodeName] || msg.Type != pb.ChaincodeMessage_REGISTER {
+		return nil
+	}
+	// a tenant registers under the name it was declared with only
+	registration := &pb.ChaincodeRegistration{}
+	if proto.Unmarshal(msg.Payload, registration) != nil || registration.Name != msg.ChaincodeName {
+		return nil
+	}
+	ts := newTenantStream(mux, msg.ChaincodeName)
+	mux.tenants[ts.name] = ts
+	mux.handlers.Add(1)
+	go func() {
+		defer mux.handlers.Done()
+		err := newChaincodeSupportHandler(mux.chaincodeSupport, ts).processStream()
+		chaincodeLogger.Debug("Tenant %s of the chaincode support stream ended: %s", ts.name, err)
+		// the tenant may register again
+		mux.Lock()
+		if mux.tenants[ts.name] == ts {
+			delete(mux.tenants, ts.name)
+		}
+		mux.Unlock()
+		ts.end(io.EOF)
+	}()
+	return ts
+}
+
+func newTenantStream(mux *tenantMux, name string) *tenantStream {
+	return &tenantStream{mux: mux, name: name, signal: make(chan struct{}, 1)}
+}
+
+// deliver queues msg for the handler of the stream. Queueing rather than
+// waiting for the handler keeps a busy chaincode from holding up the others.
+func (ts *tenantStream) deliver(msg *pb.ChaincodeMessage) {
+	ts.Lock()
+	if ts.err == nil {
+		ts.queue = append(ts.queue, msg)
+	}
+	ts.Unlock()
+	ts.wake()
+}
+
+// end ends the stream with err once the messages queued are received
+func (ts *tenantStream) end(err error) {
+	ts.Lock()
+	if ts.err == nil {
+		ts.err = err
+	}
+	ts.Unlock()
+	ts.wake()
+}
+
+func (ts *tenantStream) wake() {
+	select {
+	case ts.signal <- struct{}{}:
+	default:
+	}
+}
+
+// Recv returns the next message of the chaincode of the stream
+func (ts *tenantStream) Recv() (*pb.ChaincodeMessage, error) {
+	for {
+		ts.Lock()
+		if len(ts.queue) > 0 {
+			msg := ts.queue[0]
+			ts.queue[0] = nil
+			ts.queue = ts.queue[1:]
+			ts.Unlock()
+			return msg, nil
+		}
+		err := ts.err
+		ts.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		<-ts.signal
+	}
+}
+
+// Send sends msg on the shared stream, stamped with the name of the tenant
+func (ts *tenantStream) Send(msg *pb.ChaincodeMessage) error {
+	if ts.name != "" {
+		stamped := *msg
+		stamped.ChaincodeName = ts.name
+		msg = &stamped
+	}
+	ts.mux.sendLock.Lock()
+	defer ts.mux.sendLock.Unlock()
+	return ts.mux.stream.Send(msg)
+}
@@ -0,0 +1,124 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func newTenantTestSupport(maxTenants int) *ChaincodeSupport {
+	getPeerEndpoint := func() (*pb.PeerEndpoint, error) {
+		return &pb.PeerEndpoint{ID: &pb.PeerID{Name: "testpeer"}, Address: "0.0.0.0:40303"}, nil
+	}
+	chain := NewChaincodeSupport(DefaultChain, getPeerEndpoint, false, time.Second, nil)
+	chain.maxTenants = maxTenants
+	return chain
+}
+
+func sendRegister(t *testing.T, stream *mockChaincodeStream, name string, chaincodeName string, tenants ...string) {
+	payload, err := proto.Marshal(&pb.ChaincodeRegistration{Name: name, MinProtocolVersion: pb.MinChaincodeProtocol, MaxProtocolVersion: pb.MaxChaincodeProtocol, Tenants: tenants})
+	if err != nil {
+		t.Fatalf("Error marshalling registration: %s", err)
+	}
+	stream.recvCh <- recvResult{&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_REGISTER, Payload: payload, ChaincodeName: chaincodeName}, nil}
+}
+
+func expectRegistered(t *testing.T, stream *mockChaincodeStream, chaincodeName string) {
+	select {
+	case msg := <-stream.sendCh:
+		if msg.Type != pb.ChaincodeMessage_REGISTERED || msg.ChaincodeName != chaincodeName {
+			t.Fatalf("Expected REGISTERED for %q, got %s for %q", chaincodeName, msg.Type, msg.ChaincodeName)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for REGISTERED for %q", chaincodeName)
+	}
+}
+
+func expectNothingSent(t *testing.T, stream *mockChaincodeStream) {
+	select {
+	case msg := <-stream.sendCh:
+		t.Fatalf("Expected nothing to be sent, got %s for %q", msg.Type, msg.ChaincodeName)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func endTenantStream(t *testing.T, stream *mockChaincodeStream, done chan error) {
+	stream.recvCh <- recvResult{nil, io.EOF}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the stream to end")
+	}
+}
+
+func TestTenantsShareStream(t *testing.T) {
+	chain := newTenantTestSupport(1)
+	stream := newMockChaincodeStream()
+	done := make(chan error, 1)
+	go func() { done <- serveChaincodeStream(chain, stream) }()
+
+	sendRegister(t, stream, "host", "", "tenant")
+	expectRegistered(t, stream, "")
+	sendRegister(t, stream, "tenant", "tenant")
+	expectRegistered(t, stream, "tenant")
+
+	for _, name := range []string{"host", "tenant"} {
+		if handler, ok := chain.chaincodeHasBeenLaunched(name); !ok || !handler.registered {
+			t.Fatalf("Expected %s to be registered", name)
+		}
+	}
+
+	// chaincodes the host did not declare are not served over its stream
+	sendRegister(t, stream, "intruder", "intruder")
+	expectNothingSent(t, stream)
+	if _, ok := chain.chaincodeHasBeenLaunched("intruder"); ok {
+		t.Fatal("Expected an undeclared tenant not to be registered")
+	}
+
+	endTenantStream(t, stream, done)
+	for _, name := range []string{"host", "tenant"} {
+		if _, ok := chain.chaincodeHasBeenLaunched(name); ok {
+			t.Fatalf("Expected %s to be deregistered with the stream", name)
+		}
+	}
+}
+
+func TestTenantsBeyondMaxIgnored(t *testing.T) {
+	chain := newTenantTestSupport(1)
+	stream := newMockChaincodeStream()
+	done := make(chan error, 1)
+	go func() { done <- serveChaincodeStream(chain, stream) }()
+
+	sendRegister(t, stream, "host", "", "tenant-a", "tenant-b")
+	expectRegistered(t, stream, "")
+	sendRegister(t, stream, "tenant-a", "tenant-a")
+	expectNothingSent(t, stream)
+	if _, ok := chain.chaincodeHasBeenLaunched("tenant-a"); ok {
+		t.Fatal("Expected the tenants beyond chaincode.maxTenants to be ignored")
+	}
+
+	endTenantStream(t, stream, done)
+}
@@ -0,0 +1,125 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"sync"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// RetryLater is the payload prefix of the ERROR message sent back to a chaincode
+// whose state request was rejected because its handler is saturated
+const RetryLater = pb.ChaincodeRetryLater
+
+// The interval at which a request waiting for the limiter tries again
+const stateRequestWaitInterval = 10 * time.Millisecond
+
+// stateRequestLimiter bounds the number of state requests of a chaincode being
+// processed concurrently and the rate at which new ones are accepted (token bucket).
+// A zero maxConcurrent or ratePerSec disables the corresponding limit. The limits
+// can be changed while requests are being processed.
+type stateRequestLimiter struct {
+	sync.Mutex
+	maxConcurrent int
+	active        int
+
+	ratePerSec float64
+	tokens     float64
+	last       time.Time
+}
+
+func newStateRequestLimiter(maxConcurrent int, ratePerSec int) *stateRequestLimiter {
+	l := &stateRequestLimiter{last: time.Now()}
+	l.setLimits(maxConcurrent, ratePerSec)
+	return l
+}
+
+// setLimits replaces the limits of the limiter. Requests being processed count
+// against the new concurrency limit
+func (l *stateRequestLimiter) setLimits(maxConcurrent int, ratePerSec int) {
+	if l == nil {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.maxConcurrent = maxConcurrent
+	if l.ratePerSec <= 0 || l.tokens > float64(ratePerSec) {
+		l.tokens = float64(ratePerSec)
+	}
+	l.ratePerSec = float64(ratePerSec)
+}
+
+// takeToken refills the bucket for the time elapsed since the last call and
+// consumes a token if one is available. It must be called with the lock held
+func (l *stateRequestLimiter) takeToken() bool {
+	if l.ratePerSec <= 0 {
+		return true
+	}
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.ratePerSec
+	if l.tokens > l.ratePerSec {
+		l.tokens = l.ratePerSec
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// acquire returns true if the request may be processed, in which case release
+// must be called once the request is done
+func (l *stateRequestLimiter) acquire() bool {
+	if l == nil {
+		return true
+	}
+	l.Lock()
+	defer l.Unlock()
+	if l.maxConcurrent > 0 && l.active >= l.maxConcurrent {
+		return false
+	}
+	if !l.takeToken() {
+		return false
+	}
+	l.active++
+	return true
+}
+
+// wait blocks until the request may be processed, release must then be called
+// once the request is done
+func (l *stateRequestLimiter) wait() {
+	for !l.acquire() {
+		time.Sleep(stateRequestWaitInterval)
+	}
+}
+
+func (l *stateRequestLimiter) release() {
+	if l == nil {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	if l.active > 0 {
+		l.active--
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"testing"
+)
+
+func TestStateRequestLimiterConcurrency(t *testing.T) {
+	l := newStateRequestLimiter(2, 0)
+	if !l.acquire() || !l.acquire() {
+		t.Fatalf("Expected to acquire up to the concurrency limit")
+	}
+	if l.acquire() {
+		t.Fatalf("Expected acquire to fail when saturated")
+	}
+	l.release()
+	if !l.acquire() {
+		t.Fatalf("Expected acquire to succeed after release")
+	}
+}
+
+func TestStateRequestLimiterRate(t *testing.T) {
+	l := newStateRequestLimiter(0, 3)
+	for i := 0; i < 3; i++ {
+		if !l.acquire() {
+			t.Fatalf("Expected request %d to be within rate", i)
+		}
+	}
+	if l.acquire() {
+		t.Fatalf("Expected request to exceed rate")
+	}
+}
+
+func TestStateRequestLimiterUnlimited(t *testing.T) {
+	var nilLimiter *stateRequestLimiter
+	l := newStateRequestLimiter(0, 0)
+	for i := 0; i < 1000; i++ {
+		if !l.acquire() || !nilLimiter.acquire() {
+			t.Fatalf("Expected unlimited limiter to always accept")
+		}
+	}
+}
+
+func TestStateRequestLimiterSetLimits(t *testing.T) {
+	l := newStateRequestLimiter(1, 0)
+	if !l.acquire() || l.acquire() {
+		t.Fatalf("Expected a single request to be accepted")
+	}
+	l.setLimits(2, 0)
+	if !l.acquire() {
+		t.Fatalf("Expected acquire to succeed after raising the concurrency limit")
+	}
+	if l.acquire() {
+		t.Fatalf("Expected the requests in process to count against the new limit")
+	}
+	l.release()
+	l.release()
+	l.setLimits(0, 1)
+	if !l.acquire() || l.acquire() {
+		t.Fatalf("Expected the new rate to be enforced")
+	}
+}
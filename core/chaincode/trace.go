@@ -0,0 +1,203 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/looplab/fsm"
+	"golang.org/x/net/context"
+
+	"github.com/hyperledger/fabric/core/container"
+	"github.com/hyperledger/fabric/core/fsmaudit"
+	"github.com/hyperledger/fabric/core/tracing"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// traceStore keeps the message exchange, FSM transitions and state operations
+// of the most recent transactions so that they can be put in a support bundle.
+// Once more than max transactions are traced the oldest one is dropped.
+type traceStore struct {
+	sync.Mutex
+	max     int
+	entries map[string][]*pb.TraceEntry
+	order   []string
+}
+
+func newTraceStore(max int) *traceStore {
+	return &traceStore{max: max, entries: make(map[string][]*pb.TraceEntry)}
+}
+
+// record appends entry to the trace of uuid. A nil store records nothing.
+func (ts *traceStore) record(uuid string, entry *pb.TraceEntry) {
+	if ts == nil || uuid == "" {
+		return
+	}
+	entry.TimestampNanos = time.Now().UnixNano()
+
+	ts.Lock()
+	defer ts.Unlock()
+	if _, ok := ts.entries[uuid]; !ok {
+		if len(ts.order) >= ts.max {
+			delete(ts.entries, ts.order[0])
+			ts.order = ts.order[1:]
+		}
+		ts.order = append(ts.order, uuid)
+	}
+	ts.entries[uuid] = append(ts.entries[uuid], entry)
+}
+
+func (ts *traceStore) get(uuid string) []*pb.TraceEntry {
+	if ts == nil {
+		return nil
+	}
+	ts.Lock()
+	defer ts.Unlock()
+	return append([]*pb.TraceEntry(nil), ts.entries[uuid]...)
+}
+
+func (handler *Handler) traces() *traceStore {
+	if handler.chaincodeSupport == nil {
+		return nil
+	}
+	return handler.chaincodeSupport.traces
+}
+
+func (handler *Handler) traceChaincodeName() string {
+	if handler.ChaincodeID == nil {
+		return ""
+	}
+	return handler.ChaincodeID.Name
+}
+
+// traceMessage records a message exchanged with the chaincode
+func (handler *Handler) traceMessage(kind pb.TraceEntry_Kind, msg *pb.ChaincodeMessage) {
+	handler.traces().record(msg.Uuid, &pb.TraceEntry{Kind: kind, ChaincodeID: handler.traceChaincodeName(), Type: msg.Type.String(), PayloadSize: int32(len(msg.Payload))})
+}
+
+// traceTransition records a transition of the handler FSM
+func (handler *Handler) traceTransition(e *fsm.Event) {
+	if len(e.Args) == 0 {
+		return
+	}
+	if msg, ok := e.Args[0].(*pb.ChaincodeMessage); ok {
+		handler.traces().record(msg.Uuid, &pb.TraceEntry{Kind: pb.TraceEntry_TRANSITION, ChaincodeID: handler.traceChaincodeName(), Type: e.Event, SrcState: e.Src, DstState: e.Dst})
+	}
+}
+
+// recordTransition records the outcome of an event on the handler FSM in the
+// transition audit log
+func (handler *Handler) recordTransition(msg *pb.ChaincodeMessage, src string, err error) {
+	if handler.chaincodeSupport == nil {
+		return
+	}
+	handler.chaincodeSupport.transitions.Record(fsmaudit.ChaincodeHandler, handler.traceChaincodeName(), msg.Uuid, msg.Type.String(), src, handler.FSM.Current(), err)
+}
+
+// FSMTransitions returns the recorded chaincode handler transitions matching req
+func (chaincodeSupport *ChaincodeSupport) FSMTransitions(req *pb.FSMTransitionsRequest) []*pb.FSMTransition {
+	return chaincodeSupport.transitions.Transitions(req)
+}
+
+// traceStateOp records the outcome of a ledger operation done for the chaincode
+func (handler *Handler) traceStateOp(uuid string, op pb.ChaincodeMessage_Type, key string, err error) {
+	detail := key
+	if err != nil {
+		detail = fmt.Sprintf("%s: %s", key, err)
+	}
+	handler.traces().record(uuid, &pb.TraceEntry{Kind: pb.TraceEntry_STATE_OP, ChaincodeID: handler.traceChaincodeName(), Type: op.String(), Detail: detail})
+}
+
+// SupportBundle collects the trace of transaction uuid and the logs written
+// during the transaction by the containers of the chaincodes it involved
+func (chaincodeSupport *ChaincodeSupport) SupportBundle(context context.Context, uuid string, logTailLines int) (*pb.SupportBundle, error) {
+	if chaincodeSupport.traces == nil {
+		return nil, fmt.Errorf("transaction tracing is disabled")
+	}
+	entries := chaincodeSupport.traces.get(uuid)
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no trace for transaction %s", uuid)
+	}
+
+	first, last := entries[0].TimestampNanos, entries[len(entries)-1].TimestampNanos
+	bundle := &pb.SupportBundle{Uuid: uuid, DurationNanos: last - first, Entries: entries}
+
+	if chaincodeSupport.userRunsCC {
+		return bundle, nil
+	}
+
+	seen := make(map[string]bool)
+	since := time.Unix(0, first).Add(-time.Second).Unix()
+	for _, entry := range entries {
+		if entry.ChaincodeID == "" || seen[entry.ChaincodeID] {
+			continue
+		}
+		seen[entry.ChaincodeID] = true
+
+		ccLog := &pb.ContainerLog{ChaincodeID: entry.ChaincodeID}
+		lr := container.LogsReq{ID: container.GetVMFromName(entry.ChaincodeID), Since: since, Tail: logTailLines}
+		resp, err := container.VMCProcess(context, chaincodeSupport.getVMType(entry.ChaincodeID), lr)
+		if err == nil {
+			err = resp.(container.VMCResp).Err
+		}
+		if err != nil {
+			ccLog.Error = err.Error()
+		} else {
+			ccLog.Log, _ = resp.(container.VMCResp).Resp.([]byte)
+		}
+		bundle.ContainerLogs = append(bundle.ContainerLogs, ccLog)
+	}
+	return bundle, nil
+}
+
+// startStateSpan starts the span of a request of the chaincode, a ledger
+// operation or the invocation of another chaincode, as a child of the span of
+// the execution the chaincode made it for
+func (handler *Handler) startStateSpan(msg *pb.ChaincodeMessage) *tracing.Span {
+	name := "ledger " + msg.Type.String()
+	if msg.Type == pb.ChaincodeMessage_INVOKE_CHAINCODE || msg.Type == pb.ChaincodeMessage_INVOKE_QUERY {
+		name = "invoke " + msg.Type.String()
+	}
+	span := tracing.StartSpan(name, msg.TraceContext, msg.Uuid)
+	span.SetTag("chaincode", handler.traceChaincodeName())
+	return span
+}
+
+// finishExecuteSpan finishes the span sendExecuteMessage started for uuid
+func (handler *Handler) finishExecuteSpan(uuid string, err error) {
+	handler.Lock()
+	var span *tracing.Span
+	if txctx := handler.txCtxs[uuid]; txctx != nil {
+		span, txctx.span = txctx.span, nil
+	}
+	handler.Unlock()
+	span.Finish(err)
+}
+
+// replyError returns the error a request of the chaincode is answered with,
+// nil unless reply is an ERROR
+func (handler *Handler) replyError(reply *pb.ChaincodeMessage) error {
+	if reply == nil || reply.Type != pb.ChaincodeMessage_ERROR {
+		return nil
+	}
+	return pb.ChaincodeErrorFromPayload(handler.protocolVersion, reply.Payload)
+}
@@ -0,0 +1,74 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"testing"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestTraceStoreEvictsOldest(t *testing.T) {
+	ts := newTraceStore(2)
+	ts.record("tx1", &pb.TraceEntry{Type: "A"})
+	ts.record("tx2", &pb.TraceEntry{Type: "B"})
+	ts.record("tx1", &pb.TraceEntry{Type: "C"})
+	ts.record("tx3", &pb.TraceEntry{Type: "D"})
+
+	if entries := ts.get("tx1"); len(entries) != 0 {
+		t.Fatalf("Expected oldest transaction to be evicted, got %v", entries)
+	}
+	if entries := ts.get("tx2"); len(entries) != 1 {
+		t.Fatalf("Expected 1 entry for tx2, got %v", entries)
+	}
+	if entries := ts.get("tx3"); len(entries) != 1 || entries[0].TimestampNanos == 0 {
+		t.Fatalf("Expected 1 timestamped entry for tx3, got %v", entries)
+	}
+}
+
+func TestSupportBundle(t *testing.T) {
+	chaincodeSupport := &ChaincodeSupport{traces: newTraceStore(10), userRunsCC: true}
+	handler := newTestHandler(newMockChaincodeStream())
+	handler.chaincodeSupport = chaincodeSupport
+	handler.ChaincodeID = &pb.ChaincodeID{Name: "traced"}
+
+	handler.serialSend(&pb.ChaincodeMessage{Type: pb.ChaincodeMessage_TRANSACTION, Uuid: "tx1", Payload: []byte("args")})
+	handler.traceStateOp("tx1", pb.ChaincodeMessage_PUT_STATE, "a", nil)
+	handler.traceMessage(pb.TraceEntry_RECEIVED, &pb.ChaincodeMessage{Type: pb.ChaincodeMessage_COMPLETED, Uuid: "tx1"})
+
+	bundle, err := chaincodeSupport.SupportBundle(nil, "tx1", 0)
+	if err != nil {
+		t.Fatalf("Error getting support bundle: %s", err)
+	}
+	if len(bundle.Entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %v", bundle.Entries)
+	}
+	sent := bundle.Entries[0]
+	if sent.Kind != pb.TraceEntry_SENT || sent.ChaincodeID != "traced" || sent.PayloadSize != 4 {
+		t.Fatalf("Unexpected entry for sent message: %v", sent)
+	}
+	if op := bundle.Entries[1]; op.Kind != pb.TraceEntry_STATE_OP || op.Detail != "a" {
+		t.Fatalf("Unexpected entry for state operation: %v", op)
+	}
+
+	if _, err = chaincodeSupport.SupportBundle(nil, "unknown", 0); err == nil {
+		t.Fatalf("Expected error for a transaction without trace")
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/tracing"
+	pb "github.com/hyperledger/fabric/protos"
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+)
+
+func TestTraceContextPropagatedToChaincode(t *testing.T) {
+	viper.Set("peer.fileSystemPath", "/var/hyperledger/test/tmpdb")
+	getPeerEndpoint := func() (*pb.PeerEndpoint, error) {
+		return &pb.PeerEndpoint{ID: &pb.PeerID{Name: "testpeer"}, Address: "0.0.0.0:40303"}, nil
+	}
+	NewChaincodeSupport(DefaultChain, getPeerEndpoint, false, 10*time.Second, nil)
+
+	var lock sync.Mutex
+	var spans []*tracing.Span
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []*tracing.Span
+		json.NewDecoder(r.Body).Decode(&batch)
+		lock.Lock()
+		spans = append(spans, batch...)
+		lock.Unlock()
+	}))
+	defer server.Close()
+	// every span is posted as soon as it is finished, so that the test can
+	// wait for the spans the handler finishes after the invocation returned
+	exporter := tracing.NewExporter(server.URL, "testpeer", 100, 1, 0, time.Second)
+	done := make(chan struct{})
+	go func() {
+		exporter.Run()
+		close(done)
+	}()
+	tracing.SetExporter(exporter)
+
+	if err := RegisterSystemChaincode(&SystemChaincode{Name: "tracesyscc", Chaincode: &kvChaincode{}}); err != nil {
+		t.Fatalf("Error registering system chaincode: %s", err)
+	}
+	cID := &pb.ChaincodeID{Name: "tracesyscc"}
+	root := tracing.StartSpan("submit", nil, "")
+	ctxt := tracing.ContextWithSpan(context.Background(), root)
+	spec := &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_GOLANG, ChaincodeID: cID, CtorMsg: &pb.ChaincodeInput{Function: "put", Args: []string{"a", "1"}}}
+	_, _, err := invoke(ctxt, spec, pb.Transaction_CHAINCODE_INVOKE)
+	GetChain(DefaultChain).StopChaincode(context.Background(), cID)
+	if err != nil {
+		t.Fatalf("Error invoking chaincode: %s", err)
+	}
+
+	expected := []string{
+		"execute " + pb.ChaincodeMessage_TRANSACTION.String(),
+		"ledger " + pb.ChaincodeMessage_PUT_STATE.String(),
+		"handle " + pb.ChaincodeMessage_PUT_STATE.String(),
+	}
+	byName := make(map[string]*tracing.Span)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		lock.Lock()
+		for _, span := range spans {
+			if span.TraceID == root.TraceID {
+				byName[span.Name] = span
+			}
+		}
+		lock.Unlock()
+		finished := true
+		for _, name := range expected {
+			finished = finished && byName[name] != nil
+		}
+		if finished {
+			break
+		}
+	}
+	tracing.SetExporter(nil)
+	exporter.Close()
+	<-done
+
+	execute := byName["execute "+pb.ChaincodeMessage_TRANSACTION.String()]
+	if execute == nil || execute.ParentID != root.ID {
+		t.Fatalf("Expected the execution to be traced as a child of the caller, got %+v", byName)
+	}
+	if execute.Tags["chaincode"] != "tracesyscc" {
+		t.Fatalf("Expected the execution to be tagged with the chaincode, got %v", execute.Tags)
+	}
+	put := byName["ledger "+pb.ChaincodeMessage_PUT_STATE.String()]
+	if put == nil || put.ParentID != execute.ID {
+		t.Fatalf("Expected the chaincode to pass the trace context on with PUT_STATE, got %+v", byName)
+	}
+	if handled := byName["handle "+pb.ChaincodeMessage_PUT_STATE.String()]; handled == nil || handled.ParentID != execute.ID {
+		t.Fatalf("Expected the handling of PUT_STATE to be traced, got %+v", byName)
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// upgradeRecord is the routing of upgraded chaincodes as kept on disk, so that
+// requests for a chaincode that has been upgraded still reach its replacement,
+// on the state of the original, after the peer restarts
+type upgradeRecord struct {
+	// Upgraded chaincodes, mapped to the name of their replacement
+	Upgraded map[string]string `json:"upgraded"`
+	// State namespace of the replacements
+	Namespaces map[string]string `json:"namespaces"`
+}
+
+// loadUpgrades reads the upgrade record at path, an empty record if there is
+// no file yet
+func loadUpgrades(path string) (*upgradeRecord, error) {
+	record := &upgradeRecord{Upgraded: make(map[string]string), Namespaces: make(map[string]string)}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return record, nil
+		}
+		return nil, err
+	}
+	if err = json.Unmarshal(data, record); err != nil {
+		return nil, fmt.Errorf("Error reading chaincode upgrades from %s: %s", path, err)
+	}
+	return record, nil
+}
+
+// saveUpgrades writes record to path, synced to disk before it replaces the
+// previous record
+func saveUpgrades(path string, record *upgradeRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("Error replacing %s: %s", path, err)
+	}
+	return nil
+}
+
+// loadUpgrades restores the routing of the chaincodes upgraded before the peer
+// restarted
+func (chaincodeSupport *ChaincodeSupport) loadUpgrades() error {
+	record, err := loadUpgrades(chaincodeSupport.upgradesPath)
+	if err != nil {
+		return err
+	}
+	chaincodeSupport.handlerMap.Lock()
+	defer chaincodeSupport.handlerMap.Unlock()
+	for name, replacement := range record.Upgraded {
+		chaincodeSupport.handlerMap.upgradedMap[name] = replacement
+	}
+	for name, namespace := range record.Namespaces {
+		chaincodeSupport.handlerMap.namespaceMap[name] = namespace
+	}
+	return nil
+}
+
+// recordUpgrade persists the routing of the chaincodes upgraded so far with
+// chaincode now upgraded to replacement, call this under lock
+func (chaincodeSupport *ChaincodeSupport) recordUpgrade(chaincode string, replacement string) error {
+	record := &upgradeRecord{Upgraded: map[string]string{chaincode: replacement}, Namespaces: make(map[string]string)}
+	for name, upgraded := range chaincodeSupport.handlerMap.upgradedMap {
+		record.Upgraded[name] = upgraded
+	}
+	for name, namespace := range chaincodeSupport.handlerMap.namespaceMap {
+		record.Namespaces[name] = namespace
+	}
+	return saveUpgrades(chaincodeSupport.upgradesPath, record)
+}
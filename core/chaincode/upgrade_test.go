@@ -0,0 +1,115 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/util"
+	pb "github.com/hyperledger/fabric/protos"
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+)
+
+func TestUpgradeChaincode(t *testing.T) {
+	viper.Set("peer.fileSystemPath", "/var/hyperledger/test/tmpdb")
+	upgrades := filepath.Join("/var/hyperledger/test/tmpdb", "chaincode", string(DefaultChain)+"-upgrades.json")
+	os.Remove(upgrades)
+	defer os.Remove(upgrades)
+	getPeerEndpoint := func() (*pb.PeerEndpoint, error) {
+		return &pb.PeerEndpoint{ID: &pb.PeerID{Name: "testpeer"}, Address: "0.0.0.0:40303"}, nil
+	}
+	chain := NewChaincodeSupport(DefaultChain, getPeerEndpoint, false, 10*time.Second, nil)
+
+	for _, name := range []string{"upgradesyscc1", "upgradesyscc2"} {
+		if err := RegisterSystemChaincode(&SystemChaincode{Name: name, Chaincode: &kvChaincode{}}); err != nil {
+			t.Fatalf("Error registering system chaincode: %s", err)
+		}
+		defer chain.StopChaincode(context.Background(), &pb.ChaincodeID{Name: name})
+	}
+	query := newBatchTransaction(t, "upgradesyscc1", pb.Transaction_CHAINCODE_QUERY, "get", "a")
+	if _, _, err := chain.LaunchChaincode(context.Background(), query); err != nil {
+		t.Fatalf("Error launching chaincode: %s", err)
+	}
+
+	chain.handlerMap.Lock()
+	old := chain.handlerMap.chaincodeMap["upgradesyscc1"]
+	chain.handlerMap.Unlock()
+
+	// Once quiesced, the old version takes no new transaction, even from
+	// callers that found it running before
+	old.setQuiesced(true)
+	if _, err := old.createTxContext(util.GenerateUUID(), query); err == nil {
+		t.Fatal("Expected a quiesced handler to refuse new transactions")
+	}
+	old.setQuiesced(false)
+
+	spec := &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_GOLANG, ChaincodeID: &pb.ChaincodeID{Name: "upgradesyscc2"}}
+	upgrade, err := pb.NewChaincodeDeployTransaction(&pb.ChaincodeDeploymentSpec{ChaincodeSpec: spec, Upgrades: "upgradesyscc1"}, util.GenerateUUID())
+	if err != nil {
+		t.Fatalf("Error creating transaction: %s", err)
+	}
+	if upgraded := upgradedByDeployment(upgrade); upgraded != "upgradesyscc1" {
+		t.Fatalf("Expected the deployment to upgrade upgradesyscc1, got %q", upgraded)
+	}
+	if _, err = chain.UpgradeChaincode(context.Background(), "upgradesyscc1", upgrade); err != nil {
+		t.Fatalf("Error upgrading chaincode: %s", err)
+	}
+
+	// Requests for the old version are served by the new one
+	cID, _, err := chain.LaunchChaincode(context.Background(), newBatchTransaction(t, "upgradesyscc1", pb.Transaction_CHAINCODE_QUERY, "get", "a"))
+	if err != nil {
+		t.Fatalf("Error launching chaincode: %s", err)
+	}
+	if cID.Name != "upgradesyscc2" {
+		t.Fatalf("Expected upgradesyscc1 to be served by upgradesyscc2, got %s", cID.Name)
+	}
+
+	// The routing survives a restart
+	restarted := NewChaincodeSupport(DefaultChain, getPeerEndpoint, false, 10*time.Second, nil)
+	chains[DefaultChain] = chain
+	restarted.handlerMap.Lock()
+	resolved := restarted.resolveChaincodeName("upgradesyscc1")
+	namespace := restarted.handlerMap.namespaceMap["upgradesyscc2"]
+	restarted.handlerMap.Unlock()
+	if resolved != "upgradesyscc2" || namespace != "upgradesyscc1" {
+		t.Fatalf("Expected the upgrade to be restored, got %s in namespace %q", resolved, namespace)
+	}
+}
+
+func TestUpgradedByDeployment(t *testing.T) {
+	spec := &pb.ChaincodeSpec{Type: pb.ChaincodeSpec_GOLANG, ChaincodeID: &pb.ChaincodeID{Name: "mycc"}}
+	deploy, err := pb.NewChaincodeDeployTransaction(&pb.ChaincodeDeploymentSpec{ChaincodeSpec: spec}, util.GenerateUUID())
+	if err != nil {
+		t.Fatalf("Error creating transaction: %s", err)
+	}
+	if upgraded := upgradedByDeployment(deploy); upgraded != "" {
+		t.Fatalf("Expected a plain deployment, got an upgrade of %s", upgraded)
+	}
+	invoke := newBatchTransaction(t, "mycc", pb.Transaction_CHAINCODE_INVOKE, "put", "a", "1")
+	invoke.Payload, _ = proto.Marshal(&pb.ChaincodeDeploymentSpec{ChaincodeSpec: spec, Upgrades: "other"})
+	if upgraded := upgradedByDeployment(invoke); upgraded != "" {
+		t.Fatalf("Expected an invocation not to upgrade, got an upgrade of %s", upgraded)
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package chaincode
+
+import (
+	"fmt"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// The number of messages waiting for the writer of a handler, used when not configured
+const outboundBufferSizeDefault = 100
+
+// outboundMessage is a message waiting for the writer of a handler, sent is
+// called from the writer with the result of sending it
+type outboundMessage struct {
+	msg  *pb.ChaincodeMessage
+	sent func(error)
+}
+
+// startWriter starts the goroutine sending the messages of the handler. gRPC
+// streams do not support concurrent Send, the writer is the only goroutine
+// calling Send on ChatStream.
+func (handler *Handler) startWriter(size int) {
+	if size <= 0 {
+		size = outboundBufferSizeDefault
+	}
+	handler.outbound = make(chan *outboundMessage, size)
+	handler.writerStop = make(chan struct{})
+	handler.writerDone = make(chan struct{})
+	go handler.writer()
+}
+
+func (handler *Handler) writer() {
+	defer close(handler.writerDone)
+	for {
+		select {
+		case out := <-handler.outbound:
+			err := handler.ChatStream.Send(out.msg)
+			if err != nil {
+				handler.log(out.msg).Error("Error sending %s: %s", out.msg.Type.String(), err)
+				err = fmt.Errorf("Error sending %s: %s", out.msg.Type.String(), err)
+			}
+			if out.sent != nil {
+				out.sent(err)
+			}
+		case <-handler.writerStop:
+			// fail what is left, messages handed over from now on are dropped
+			for {
+				select {
+				case out := <-handler.outbound:
+					if out.sent != nil {
+						out.sent(errWriterStopped(out.msg))
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// stopWriter stops the writer and waits for it to exit, no message is sent on
+// ChatStream once it returns
+func (handler *Handler) stopWriter() {
+	handler.writerStopOnce.Do(func() {
+		close(handler.writerStop)
+	})
+	<-handler.writerDone
+}
+
+// sendAsync hands msg to the writer. If not nil, sent is called with the
+// result of sending it unless the writer stops first.
+func (handler *Handler) sendAsync(msg *pb.ChaincodeMessage, sent func(error)) {
+	select {
+	case <-handler.writerStop:
+		if sent != nil {
+			sent(errWriterStopped(msg))
+		}
+		return
+	default:
+	}
+	select {
+	case handler.outbound <- &outboundMessage{msg: msg, sent: sent}:
+	case <-handler.writerStop:
+		if sent != nil {
+			sent(errWriterStopped(msg))
+		}
+	}
+}
+
+func errWriterStopped(msg *pb.ChaincodeMessage) error {
+	return fmt.Errorf("Error sending %s: chaincode support stream closed", msg.Type.String())
+}
@@ -0,0 +1,102 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package config
+
+import (
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/op/go-logging"
+)
+
+// lockedLeveledBackend is a logging.LeveledBackend whose levels are guarded by
+// a lock. The leveled backend of go-logging keeps them in a map without one,
+// so changing a level at runtime, with the Admin API or a reload of the
+// config file, would race with the goroutines logging. A module named
+// <parent>.<child> follows the level of its parent until its own is set.
+type lockedLeveledBackend struct {
+	sync.RWMutex
+	backend logging.Backend
+	levels  map[string]logging.Level
+}
+
+// GetLevel returns the level of module, that of its closest parent with a
+// level if it has none, the default level if none of them has
+func (b *lockedLeveledBackend) GetLevel(module string) logging.Level {
+	b.RLock()
+	defer b.RUnlock()
+	for {
+		if level, ok := b.levels[module]; ok {
+			return level
+		}
+		if module == "" {
+			return logging.DEBUG
+		}
+		if i := strings.LastIndex(module, "."); i >= 0 {
+			module = module[:i]
+		} else {
+			module = ""
+		}
+	}
+}
+
+// SetLevel sets the level of module, the default level if module is ""
+func (b *lockedLeveledBackend) SetLevel(level logging.Level, module string) {
+	b.Lock()
+	defer b.Unlock()
+	b.levels[module] = level
+}
+
+// IsEnabledFor returns true if lines of level are logged for module
+func (b *lockedLeveledBackend) IsEnabledFor(level logging.Level, module string) bool {
+	return level <= b.GetLevel(module)
+}
+
+// Log passes rec to the backend if its level is enabled for its module
+func (b *lockedLeveledBackend) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	if !b.IsEnabledFor(level, rec.Module) {
+		return nil
+	}
+	return b.backend.Log(level, calldepth+1, rec)
+}
+
+// SetLogBackend sets the backends of go-logging like logging.SetBackend, with
+// the levels of the modules guarded by a lock. The levels set before are
+// lost, they are to be set on the returned backend or with logging.SetLevel.
+// Backends must only be set during startup, logging.SetBackend is not to be
+// called once SetLogBackend was.
+func SetLogBackend(backends ...logging.Backend) logging.LeveledBackend {
+	// The levels of the leveled backends of go-logging wrapped are never
+	// set, all lines pass them
+	var backend logging.LeveledBackend
+	if len(backends) == 1 {
+		backend = logging.AddModuleLevel(backends[0])
+	} else {
+		backend = logging.MultiLogger(backends...)
+	}
+	return logging.SetBackend(&lockedLeveledBackend{backend: backend, levels: make(map[string]logging.Level)})
+}
+
+// The default backend of go-logging, with its levels guarded by a lock
+func init() {
+	SetLogBackend(logging.NewLogBackend(os.Stderr, "", log.LstdFlags)).SetLevel(logging.DEBUG, "")
+}
@@ -0,0 +1,186 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+)
+
+// reloaded holds the settings changed by a reload of the config file. Viper
+// is not safe for concurrent writes, so the reloaded values are kept here and
+// read with Get and the typed getters instead of being set on viper.
+var reloaded = struct {
+	sync.RWMutex
+	values map[string]interface{}
+}{values: make(map[string]interface{})}
+
+// Set sets key to value for the readers of the reloadable settings, as a
+// reload of the config file changing it does. A nil value reverts key to the
+// value of viper.
+func Set(key string, value interface{}) {
+	reloaded.Lock()
+	defer reloaded.Unlock()
+	if value == nil {
+		delete(reloaded.values, key)
+		return
+	}
+	reloaded.values[key] = value
+}
+
+// Get returns the value of key, the reloaded value if a reload changed it,
+// else the value of viper
+func Get(key string) interface{} {
+	reloaded.RLock()
+	value, ok := reloaded.values[key]
+	reloaded.RUnlock()
+	if ok {
+		return value
+	}
+	return viper.Get(key)
+}
+
+// GetString returns the value of key as a string, see Get
+func GetString(key string) string {
+	return cast.ToString(Get(key))
+}
+
+// GetInt returns the value of key as an int, see Get
+func GetInt(key string) int {
+	return cast.ToInt(Get(key))
+}
+
+// GetDuration returns the value of key as a duration, see Get
+func GetDuration(key string) time.Duration {
+	return cast.ToDuration(Get(key))
+}
+
+// reloader applies the changes of the config file to the settings that have
+// listeners. A changed setting takes precedence over viper, and so over the
+// environment, from then on and its listeners are called.
+type reloader struct {
+	sync.Mutex
+	listeners map[string][]func()
+	// the file values of the watched settings when last read
+	values  map[string]interface{}
+	path    string
+	modTime time.Time
+}
+
+var configReloader = &reloader{listeners: make(map[string][]func())}
+
+// OnChange calls listener whenever a reload of the config file changes the
+// value of key. Listeners run on the goroutine of WatchConfig and read the
+// new value with Get or the typed getters.
+func OnChange(key string, listener func()) {
+	configReloader.Lock()
+	defer configReloader.Unlock()
+	configReloader.listeners[key] = append(configReloader.listeners[key], listener)
+}
+
+// WatchConfig checks the config file at path every interval and applies the
+// changes made to the settings that have listeners. A zero interval disables
+// the watch.
+func WatchConfig(path string, interval time.Duration) error {
+	if interval <= 0 {
+		return nil
+	}
+	if err := configReloader.load(path); err != nil {
+		return err
+	}
+	configLogger.Info("Watching %s for configuration changes every %s", path, interval)
+	go func() {
+		for range time.Tick(interval) {
+			if err := configReloader.reload(); err != nil {
+				configLogger.Warning("Error reloading the configuration: %s", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// readFile returns the values of the watched keys in the config file at path
+func (r *reloader) readFile(path string) (map[string]interface{}, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("Error reading config file %s: %s", path, err)
+	}
+	values := make(map[string]interface{}, len(r.listeners))
+	for key := range r.listeners {
+		values[key] = v.Get(key)
+	}
+	return values, nil
+}
+
+// load reads the config file at path as the base the changes are found from
+func (r *reloader) load(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("Error watching config file: %s", err)
+	}
+	r.Lock()
+	defer r.Unlock()
+	values, err := r.readFile(path)
+	if err != nil {
+		return err
+	}
+	r.path, r.modTime, r.values = path, info.ModTime(), values
+	return nil
+}
+
+// reload reads the config file again if it was modified, sets the watched
+// settings whose value changed and calls their listeners
+func (r *reloader) reload() error {
+	r.Lock()
+	info, err := os.Stat(r.path)
+	if err != nil || info.ModTime().Equal(r.modTime) {
+		r.Unlock()
+		return err
+	}
+	values, err := r.readFile(r.path)
+	if err != nil {
+		r.Unlock()
+		return err
+	}
+	r.modTime = info.ModTime()
+	var changed []func()
+	for key, value := range values {
+		if reflect.DeepEqual(value, r.values[key]) {
+			continue
+		}
+		configLogger.Info("Configuration setting %s changed to %v", key, value)
+		Set(key, value)
+		changed = append(changed, r.listeners[key]...)
+	}
+	r.values = values
+	r.Unlock()
+
+	for _, listener := range changed {
+		listener()
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package config
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/spf13/viper"
+)
+
+func TestReloadSetsChangedSettings(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "core.yaml")
+	if err = ioutil.WriteFile(path, []byte("peer:\n    discovery:\n        period: 5s\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("peer.discovery.period", "5s")
+	defer viper.Set("peer.discovery.period", nil)
+	defer Set("peer.discovery.period", nil)
+
+	r := &reloader{listeners: make(map[string][]func())}
+	changed := make(chan struct{}, 1)
+	r.listeners["peer.discovery.period"] = []func(){func() { changed <- struct{}{} }}
+	if err = r.load(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Readers run while the file is reloaded
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					GetDuration("peer.discovery.period")
+				}
+			}
+		}()
+	}
+	if err = ioutil.WriteFile(path, []byte("peer:\n    discovery:\n        period: 10ms\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Second)
+	if err = os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err = r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	close(done)
+	readers.Wait()
+
+	select {
+	case <-changed:
+	default:
+		t.Fatal("Expected the listener of the changed setting to be called")
+	}
+	if period := GetDuration("peer.discovery.period"); period != 10*time.Millisecond {
+		t.Fatalf("Expected the reloaded period, got %s", period)
+	}
+	if period := viper.GetDuration("peer.discovery.period"); period != 5*time.Second {
+		t.Fatalf("Expected viper to be left unchanged, got %s", period)
+	}
+	Set("peer.discovery.period", nil)
+	if period := GetDuration("peer.discovery.period"); period != 5*time.Second {
+		t.Fatalf("Expected the value of viper once reverted, got %s", period)
+	}
+}
+
+func TestLogLevelsChangeWhileLogging(t *testing.T) {
+	logger := logging.MustGetLogger("reloadtest")
+	backend := SetLogBackend(logging.NewLogBackend(ioutil.Discard, "", 0))
+	defer func() { SetLogBackend(logging.NewLogBackend(os.Stderr, "", log.LstdFlags)).SetLevel(logging.DEBUG, "") }()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			logger.Info("line %d", i)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			logging.SetLevel(logging.Level(i%6), "reloadtest")
+		}
+	}()
+	wg.Wait()
+
+	backend.SetLevel(logging.WARNING, "")
+	logging.SetLevel(logging.ERROR, "reloadtest")
+	if logging.GetLevel("reloadtest") != logging.ERROR || logging.GetLevel("other") != logging.WARNING {
+		t.Fatalf("Expected the level of the module and the default level, got %s and %s", logging.GetLevel("reloadtest"), logging.GetLevel("other"))
+	}
+	// Child modules follow their parent until their own level is set
+	if level := logging.GetLevel("reloadtest.child.grandchild"); level != logging.ERROR {
+		t.Fatalf("Expected the level of the parent, got %s", level)
+	}
+	logging.SetLevel(logging.INFO, "reloadtest.child")
+	logging.SetLevel(logging.DEBUG, "reloadtest")
+	if level := logging.GetLevel("reloadtest.child.grandchild"); level != logging.INFO {
+		t.Fatalf("Expected the level of the closest parent, got %s", level)
+	}
+}
@@ -34,8 +34,10 @@ import (
 //abstract virtual image for supporting arbitrary virual machines
 type vm interface {
 	build(ctxt context.Context, id string, args []string, env []string, attachstdin bool, attachstdout bool, reader io.Reader) error
-	start(ctxt context.Context, id string, args []string, env []string, attachstdin bool, attachstdout bool) error
+	start(ctxt context.Context, id string, args []string, env []string, attachstdin bool, attachstdout bool, limits *ResourceLimits) error
 	stop(ctxt context.Context, id string, timeout uint, dontkill bool, dontremove bool) error
+	logs(ctxt context.Context, id string, since int64, tail int) ([]byte, error)
+	inspect(ctxt context.Context, id string) (*ContainerState, error)
 }
 
 //dockerVM is a vm. It is identified by an image id
@@ -85,7 +87,7 @@ func (vm *dockerVM) build(ctxt context.Context, id string, args []string, env []
 	return nil
 }
 
-func (vm *dockerVM) start(ctxt context.Context, imageID string, args []string, env []string, attachstdin bool, attachstdout bool) error {
+func (vm *dockerVM) start(ctxt context.Context, imageID string, args []string, env []string, attachstdin bool, attachstdout bool, limits *ResourceLimits) error {
 	client, err := vm.newClient()
 	if err != nil {
 		vmLogger.Debug("start - cannot create client %s", err)
@@ -104,7 +106,7 @@ func (vm *dockerVM) start(ctxt context.Context, imageID string, args []string, e
 		vmLogger.Error(fmt.Sprintf("start-could not recreate container %s", err))
 		return err
 	}
-	err = client.StartContainer(containerID, &docker.HostConfig{NetworkMode: "host"})
+	err = client.StartContainer(containerID, limits.hostConfig())
 	if err != nil {
 		vmLogger.Error(fmt.Sprintf("start-could not start container %s", err))
 		return err
@@ -127,6 +129,43 @@ func (vm *dockerVM) stop(ctxt context.Context, id string, timeout uint, dontkill
 	return err
 }
 
+//logs returns the stdout and stderr of the container written since the given
+//unix time, limited to the last tail lines (all lines if tail <= 0)
+func (vm *dockerVM) logs(ctxt context.Context, id string, since int64, tail int) ([]byte, error) {
+	client, err := vm.newClient()
+	if err != nil {
+		vmLogger.Debug("logs - cannot create client %s", err)
+		return nil, err
+	}
+	id = strings.Replace(id, ":", "_", -1)
+
+	outputbuf := bytes.NewBuffer(nil)
+	opts := docker.LogsOptions{Container: id, OutputStream: outputbuf, ErrorStream: outputbuf, Stdout: true, Stderr: true, Since: since, Timestamps: true}
+	if tail > 0 {
+		opts.Tail = fmt.Sprintf("%d", tail)
+	}
+	if err = client.Logs(opts); err != nil {
+		return nil, fmt.Errorf("Error getting logs of container %s: %s", id, err)
+	}
+	return outputbuf.Bytes(), nil
+}
+
+//inspect returns the state of the container
+func (vm *dockerVM) inspect(ctxt context.Context, id string) (*ContainerState, error) {
+	client, err := vm.newClient()
+	if err != nil {
+		vmLogger.Debug("inspect - cannot create client %s", err)
+		return nil, err
+	}
+	id = strings.Replace(id, ":", "_", -1)
+
+	c, err := client.InspectContainer(id)
+	if err != nil {
+		return nil, fmt.Errorf("Error inspecting container %s: %s", id, err)
+	}
+	return &ContainerState{Running: c.State.Running, OOMKilled: c.State.OOMKilled, ExitCode: c.State.ExitCode}, nil
+}
+
 func (vm *dockerVM) stopInternal(ctxt context.Context, client *docker.Client, id string, timeout uint, dontkill bool, dontremove bool) error {
 	err := client.StopContainer(id, timeout)
 	if err != nil {
@@ -156,6 +195,7 @@ func (vm *dockerVM) stopInternal(ctxt context.Context, client *docker.Client, id
 //constants for supported containers
 const (
 	DOCKER = "Docker"
+	SYSTEM = "System"
 )
 
 type image struct {
@@ -196,6 +236,8 @@ func (vmc *VMController) newVM(typ string) vm {
 	switch typ {
 	case DOCKER:
 		v = &dockerVM{}
+	case SYSTEM:
+		v = &systemVM{}
 	case "":
 		v = &dockerVM{}
 	}
@@ -285,11 +327,13 @@ type StartImageReq struct {
 	Env          []string
 	AttachStdin  bool
 	AttachStdout bool
+	//resources of the container, unbounded if nil
+	Limits *ResourceLimits
 }
 
 func (si StartImageReq) do(ctxt context.Context, v vm) VMCResp {
 	var resp VMCResp
-	if err := v.start(ctxt, si.ID, si.Args, si.Env, si.AttachStdin, si.AttachStdout); err != nil {
+	if err := v.start(ctxt, si.ID, si.Args, si.Env, si.AttachStdin, si.AttachStdout, si.Limits); err != nil {
 		resp = VMCResp{Err: err}
 	} else {
 		resp = VMCResp{}
@@ -327,6 +371,46 @@ func (si StopImageReq) getID() string {
 	return si.ID
 }
 
+//LogsReq - properties for retrieving the log of a container. The log is
+//returned as []byte in VMCResp.Resp
+type LogsReq struct {
+	ID string
+	//unix time of the oldest log line, 0 for all
+	Since int64
+	//number of trailing lines, 0 for all
+	Tail int
+}
+
+func (lr LogsReq) do(ctxt context.Context, v vm) VMCResp {
+	log, err := v.logs(ctxt, lr.ID, lr.Since, lr.Tail)
+	if err != nil {
+		return VMCResp{Err: err}
+	}
+	return VMCResp{Resp: log}
+}
+
+func (lr LogsReq) getID() string {
+	return lr.ID
+}
+
+//InspectReq - retrieves the state of a container, returned as
+//*ContainerState in VMCResp.Resp
+type InspectReq struct {
+	ID string
+}
+
+func (ir InspectReq) do(ctxt context.Context, v vm) VMCResp {
+	state, err := v.inspect(ctxt, ir.ID)
+	if err != nil {
+		return VMCResp{Err: err}
+	}
+	return VMCResp{Resp: state}
+}
+
+func (ir InspectReq) getID() string {
+	return ir.ID
+}
+
 //VMCProcess should be used as follows
 //   . construct a context
 //   . construct req of the right type (e.g., CreateImageReq)
@@ -0,0 +1,116 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package container
+
+import (
+	"io"
+	"strings"
+	"sync"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// ChaincodeStream is the stream over which a chaincode and the peer exchange
+// ChaincodeMessages
+type ChaincodeStream interface {
+	Send(*pb.ChaincodeMessage) error
+	Recv() (*pb.ChaincodeMessage, error)
+}
+
+var inProcRuntime = struct {
+	sync.Mutex
+	connect map[string]func(ChaincodeStream) error
+}{connect: make(map[string]func(ChaincodeStream) error)}
+
+// ChainIDEnv is the variable of the environment of a chaincode naming the
+// chain it is launched for
+const ChainIDEnv = "CORE_CHAINCODE_CHAINID"
+
+// SetInProcessConnector sets the function serving the peer side of the
+// streams of in-process chaincode launched for chainID, it returns when the
+// stream ends
+func SetInProcessConnector(chainID string, connect func(ChaincodeStream) error) {
+	inProcRuntime.Lock()
+	defer inProcRuntime.Unlock()
+	inProcRuntime.connect[chainID] = connect
+}
+
+//getInProcessConnector returns the connector of the chain named by the
+//ChainIDEnv variable of env
+func getInProcessConnector(env []string) func(ChaincodeStream) error {
+	chainID := ""
+	for _, e := range env {
+		if strings.HasPrefix(e, ChainIDEnv+"=") {
+			chainID = strings.TrimPrefix(e, ChainIDEnv+"=")
+		}
+	}
+	inProcRuntime.Lock()
+	defer inProcRuntime.Unlock()
+	return inProcRuntime.connect[chainID]
+}
+
+//inProcStream is one end of an in-process ChaincodeStream. Closing either end
+//ends the stream for both.
+type inProcStream struct {
+	send chan<- *pb.ChaincodeMessage
+	recv <-chan *pb.ChaincodeMessage
+	done chan struct{}
+	once *sync.Once
+}
+
+//inProcStreamBuffer lets a side send without waiting for the other to receive,
+//as a network stream would
+const inProcStreamBuffer = 16
+
+func newInProcStreamPair() (*inProcStream, *inProcStream) {
+	toPeer := make(chan *pb.ChaincodeMessage, inProcStreamBuffer)
+	toChaincode := make(chan *pb.ChaincodeMessage, inProcStreamBuffer)
+	done := make(chan struct{})
+	once := &sync.Once{}
+	return &inProcStream{send: toChaincode, recv: toPeer, done: done, once: once},
+		&inProcStream{send: toPeer, recv: toChaincode, done: done, once: once}
+}
+
+func (s *inProcStream) Send(msg *pb.ChaincodeMessage) error {
+	select {
+	case <-s.done:
+		return io.ErrClosedPipe
+	default:
+	}
+	select {
+	case s.send <- msg:
+		return nil
+	case <-s.done:
+		return io.ErrClosedPipe
+	}
+}
+
+func (s *inProcStream) Recv() (*pb.ChaincodeMessage, error) {
+	select {
+	case msg := <-s.recv:
+		return msg, nil
+	case <-s.done:
+		return nil, io.EOF
+	}
+}
+
+func (s *inProcStream) close() {
+	s.once.Do(func() { close(s.done) })
+}
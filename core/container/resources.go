@@ -0,0 +1,118 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package container
+
+import (
+	"fmt"
+
+	"github.com/fsouza/go-dockerclient"
+)
+
+// The network of chaincode containers unless their limits say otherwise
+const defaultNetworkMode = "host"
+
+// networkModes are the network modes chaincode containers may be started
+// with, by how isolated the container is
+var networkModes = map[string]int{"host": 0, "bridge": 1, "none": 2}
+
+// ResourceLimits bounds the resources of a chaincode container. A zero value
+// leaves the corresponding resource unbounded.
+type ResourceLimits struct {
+	// relative CPU weight, 1024 being the weight of other containers
+	CPUShares int64
+	// memory limit in bytes, the container is OOM-killed when exceeding it
+	Memory int64
+	// maximum number of processes and threads, enforced with the nproc
+	// ulimit as the docker API in use predates pids limits
+	PidsLimit int64
+	// docker network mode, host if empty
+	NetworkMode string
+}
+
+// Validate returns an error if the limits cannot be applied to a container
+func (l *ResourceLimits) Validate() error {
+	if l == nil {
+		return nil
+	}
+	if l.CPUShares < 0 || l.Memory < 0 || l.PidsLimit < 0 {
+		return fmt.Errorf("Invalid resource limits %+v, limits cannot be negative", *l)
+	}
+	if _, ok := networkModes[l.networkMode()]; !ok {
+		return fmt.Errorf("Invalid network mode %s, must be host, bridge or none", l.NetworkMode)
+	}
+	return nil
+}
+
+// Within returns an error if the limits exceed those of ceiling: a limit
+// above that of ceiling, unbounded where ceiling bounds it, or a network mode
+// less isolated than that of ceiling
+func (l *ResourceLimits) Within(ceiling *ResourceLimits) error {
+	exceeds := func(limit, max int64) bool {
+		return max > 0 && (limit == 0 || limit > max)
+	}
+	if exceeds(l.CPUShares, ceiling.CPUShares) {
+		return fmt.Errorf("CPU shares %d exceed the limit of %d", l.CPUShares, ceiling.CPUShares)
+	}
+	if exceeds(l.Memory, ceiling.Memory) {
+		return fmt.Errorf("Memory limit %d exceeds the limit of %d", l.Memory, ceiling.Memory)
+	}
+	if exceeds(l.PidsLimit, ceiling.PidsLimit) {
+		return fmt.Errorf("Pids limit %d exceeds the limit of %d", l.PidsLimit, ceiling.PidsLimit)
+	}
+	if networkModes[l.networkMode()] < networkModes[ceiling.networkMode()] {
+		return fmt.Errorf("Network mode %s is less isolated than %s", l.networkMode(), ceiling.networkMode())
+	}
+	return nil
+}
+
+// networkMode returns the network mode of the container
+func (l *ResourceLimits) networkMode() string {
+	if l.NetworkMode == "" {
+		return defaultNetworkMode
+	}
+	return l.NetworkMode
+}
+
+// hostConfig returns the docker host configuration enforcing the limits
+func (l *ResourceLimits) hostConfig() *docker.HostConfig {
+	hostConfig := &docker.HostConfig{NetworkMode: defaultNetworkMode}
+	if l == nil {
+		return hostConfig
+	}
+	hostConfig.NetworkMode = l.networkMode()
+	hostConfig.CPUShares = l.CPUShares
+	if l.Memory > 0 {
+		// no swap, exceeding the limit must get the container killed
+		hostConfig.Memory = l.Memory
+		hostConfig.MemorySwap = l.Memory
+	}
+	if l.PidsLimit > 0 {
+		hostConfig.Ulimits = []docker.ULimit{{Name: "nproc", Soft: l.PidsLimit, Hard: l.PidsLimit}}
+	}
+	return hostConfig
+}
+
+// ContainerState is the state of a container, as returned by InspectReq
+type ContainerState struct {
+	Running bool
+	// the container was killed for exceeding its memory limit
+	OOMKilled bool
+	ExitCode  int
+}
@@ -0,0 +1,82 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package container
+
+import (
+	"testing"
+)
+
+func TestResourceLimitsValidate(t *testing.T) {
+	valid := []*ResourceLimits{nil, {}, {CPUShares: 512, Memory: 1 << 28, PidsLimit: 100, NetworkMode: "none"}}
+	for _, l := range valid {
+		if err := l.Validate(); err != nil {
+			t.Fatalf("Expected %+v to be valid, got %s", l, err)
+		}
+	}
+	invalid := []*ResourceLimits{{Memory: -1}, {PidsLimit: -1}, {NetworkMode: "container:peer"}}
+	for _, l := range invalid {
+		if err := l.Validate(); err == nil {
+			t.Fatalf("Expected %+v to be invalid", l)
+		}
+	}
+}
+
+func TestResourceLimitsHostConfig(t *testing.T) {
+	var unbounded *ResourceLimits
+	if hostConfig := unbounded.hostConfig(); hostConfig.NetworkMode != "host" || hostConfig.Memory != 0 || len(hostConfig.Ulimits) != 0 {
+		t.Fatalf("Expected containers without limits to be unbounded on the host network, got %+v", hostConfig)
+	}
+
+	l := &ResourceLimits{CPUShares: 512, Memory: 1 << 28, PidsLimit: 100, NetworkMode: "bridge"}
+	hostConfig := l.hostConfig()
+	if hostConfig.NetworkMode != "bridge" || hostConfig.CPUShares != 512 {
+		t.Fatalf("Unexpected host config %+v", hostConfig)
+	}
+	if hostConfig.Memory != 1<<28 || hostConfig.MemorySwap != 1<<28 {
+		t.Fatalf("Expected memory and swap limited to %d, got %d and %d", 1<<28, hostConfig.Memory, hostConfig.MemorySwap)
+	}
+	if len(hostConfig.Ulimits) != 1 || hostConfig.Ulimits[0].Name != "nproc" || hostConfig.Ulimits[0].Hard != 100 {
+		t.Fatalf("Expected the pids limit as nproc ulimit, got %+v", hostConfig.Ulimits)
+	}
+}
+
+func TestResourceLimitsWithin(t *testing.T) {
+	ceiling := &ResourceLimits{Memory: 1 << 28, PidsLimit: 100, NetworkMode: "bridge"}
+	within := []*ResourceLimits{
+		{Memory: 1 << 28, PidsLimit: 100, NetworkMode: "bridge"},
+		{CPUShares: 2048, Memory: 1 << 20, PidsLimit: 10, NetworkMode: "none"},
+	}
+	for _, l := range within {
+		if err := l.Within(ceiling); err != nil {
+			t.Fatalf("Expected %+v within %+v: %s", *l, *ceiling, err)
+		}
+	}
+	exceeding := []*ResourceLimits{
+		{Memory: 1 << 29, PidsLimit: 100, NetworkMode: "bridge"},
+		{PidsLimit: 100, NetworkMode: "bridge"},
+		{Memory: 1 << 28, PidsLimit: 101, NetworkMode: "bridge"},
+		{Memory: 1 << 28, PidsLimit: 100},
+	}
+	for _, l := range exceeding {
+		if err := l.Within(ceiling); err == nil {
+			t.Fatalf("Expected %+v to exceed %+v", *l, *ceiling)
+		}
+	}
+}
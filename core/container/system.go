@@ -0,0 +1,138 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package container
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// SystemChaincodeRunner runs a chaincode compiled into the peer, registering
+// it as name over stream. It returns when the stream ends.
+type SystemChaincodeRunner func(name string, stream ChaincodeStream) error
+
+var systemRuntime = struct {
+	sync.Mutex
+	runners   map[string]SystemChaincodeRunner
+	instances map[string]*inProcStream
+}{runners: make(map[string]SystemChaincodeRunner), instances: make(map[string]*inProcStream)}
+
+// RegisterSystemChaincode makes the chaincode named name run in-process by
+// run, in place of a container
+func RegisterSystemChaincode(name string, run SystemChaincodeRunner) {
+	systemRuntime.Lock()
+	defer systemRuntime.Unlock()
+	systemRuntime.runners[name] = run
+}
+
+// IsSystemChaincode reports whether chaincode name is compiled into the peer
+func IsSystemChaincode(name string) bool {
+	systemRuntime.Lock()
+	defer systemRuntime.Unlock()
+	_, ok := systemRuntime.runners[name]
+	return ok
+}
+
+//systemVM is a vm running the chaincodes compiled into the peer in-process,
+//over in-memory streams. Nothing is built, stopping a chaincode ends its stream.
+type systemVM struct {
+}
+
+//call this under lock
+func (vm *systemVM) runner(id string) (string, SystemChaincodeRunner, error) {
+	for name, run := range systemRuntime.runners {
+		if GetVMFromName(name) == id {
+			return name, run, nil
+		}
+	}
+	return "", nil, fmt.Errorf("%s is not a system chaincode", id)
+}
+
+func (vm *systemVM) build(ctxt context.Context, id string, args []string, env []string, attachstdin bool, attachstdout bool, reader io.Reader) error {
+	systemRuntime.Lock()
+	defer systemRuntime.Unlock()
+	_, _, err := vm.runner(id)
+	return err
+}
+
+//start runs the chaincode with an in-process stream connected to the peer.
+//Resource limits do not apply to system chaincode.
+func (vm *systemVM) start(ctxt context.Context, id string, args []string, env []string, attachstdin bool, attachstdout bool, resources *ResourceLimits) error {
+	connect := getInProcessConnector(env)
+	if connect == nil {
+		return fmt.Errorf("in-process chaincode is not supported on the chain of %s", id)
+	}
+
+	systemRuntime.Lock()
+	defer systemRuntime.Unlock()
+	name, run, err := vm.runner(id)
+	if err != nil {
+		return err
+	}
+	//stop if necessary
+	vm.stopInternal(id)
+
+	peerSide, ccSide := newInProcStreamPair()
+	systemRuntime.instances[id] = peerSide
+	go func() {
+		if err := connect(peerSide); err != nil {
+			vmLogger.Debug("System chaincode %s stream ended: %s", name, err)
+		}
+	}()
+	go func() {
+		if err := run(name, ccSide); err != nil {
+			vmLogger.Error(fmt.Sprintf("System chaincode %s exited: %s", name, err))
+		}
+		ccSide.close()
+	}()
+	vmLogger.Debug("Started system chaincode %s", name)
+	return nil
+}
+
+func (vm *systemVM) stop(ctxt context.Context, id string, timeout uint, dontkill bool, dontremove bool) error {
+	systemRuntime.Lock()
+	defer systemRuntime.Unlock()
+	vm.stopInternal(id)
+	return nil
+}
+
+//call this under lock
+func (vm *systemVM) stopInternal(id string) {
+	if stream, ok := systemRuntime.instances[id]; ok {
+		stream.close()
+		delete(systemRuntime.instances, id)
+		vmLogger.Debug("Stopped system chaincode %s", id)
+	}
+}
+
+func (vm *systemVM) inspect(ctxt context.Context, id string) (*ContainerState, error) {
+	systemRuntime.Lock()
+	defer systemRuntime.Unlock()
+	_, ok := systemRuntime.instances[id]
+	return &ContainerState{Running: ok}, nil
+}
+
+//logs of system chaincode go to the peer log
+func (vm *systemVM) logs(ctxt context.Context, id string, since int64, tail int) ([]byte, error) {
+	return nil, fmt.Errorf("system chaincode %s logs to the peer log", id)
+}
@@ -65,6 +65,18 @@ func (client *clientImpl) encryptTxVersion1_1(tx *obc.Transaction) error {
 		tx.Metadata = encryptedMetadata
 	}
 
+	// Encrypt Transient
+	if len(tx.Transient) != 0 {
+		transientKey := utils.HMACTruncated(txKey, []byte{4}, utils.AESKeyLength)
+		for k, v := range tx.Transient {
+			encryptedValue, err := utils.CBCPKCS7Encrypt(transientKey, v)
+			if err != nil {
+				return err
+			}
+			tx.Transient[k] = encryptedValue
+		}
+	}
+
 	return nil
 }
 
@@ -194,5 +206,16 @@ func (client *clientImpl) encryptTxVersion1_2(tx *obc.Transaction) error {
 		tx.Metadata = encryptedMetadata
 	}
 
+	// Encrypt transient data using pkC
+	for k, v := range tx.Transient {
+		encryptedValue, err := cipher.Process(v)
+		if err != nil {
+			client.error("Failed encrypting transient data: [%s]", err)
+
+			return err
+		}
+		tx.Transient[k] = encryptedValue
+	}
+
 	return nil
 }
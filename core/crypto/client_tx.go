@@ -1,7 +1,6 @@
 package crypto
 
 import (
-	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/crypto/utils"
 	obc "github.com/hyperledger/fabric/protos"
 )
@@ -80,6 +79,9 @@ func (client *clientImpl) createExecuteTx(chaincodeInvocation *obc.ChaincodeInvo
 		tx.Nonce = nonce
 	}
 
+	// Bind the transient data under the final nonce, before it is encrypted
+	tx.SetTransientHash()
+
 	// Handle confidentiality
 	if chaincodeInvocation.ChaincodeSpec.ConfidentialityLevel == obc.ConfidentialityLevel_CONFIDENTIAL {
 		// 1. set confidentiality level and nonce
@@ -122,6 +124,9 @@ func (client *clientImpl) createQueryTx(chaincodeInvocation *obc.ChaincodeInvoca
 		tx.Nonce = nonce
 	}
 
+	// Bind the transient data under the final nonce, before it is encrypted
+	tx.SetTransientHash()
+
 	// Handle confidentiality
 	if chaincodeInvocation.ChaincodeSpec.ConfidentialityLevel == obc.ConfidentialityLevel_CONFIDENTIAL {
 		// 1. set confidentiality level and nonce
@@ -158,7 +163,7 @@ func (client *clientImpl) newChaincodeDeployUsingTCert(chaincodeDeploymentSpec *
 
 	// Sign the transaction and append the signature
 	// 1. Marshall tx to bytes
-	rawTx, err := proto.Marshal(tx)
+	rawTx, err := tx.SigningBytes()
 	if err != nil {
 		client.error("Failed marshaling tx [%s].", err.Error())
 		return nil, err
@@ -195,7 +200,7 @@ func (client *clientImpl) newChaincodeExecuteUsingTCert(chaincodeInvocation *obc
 
 	// Sign the transaction and append the signature
 	// 1. Marshall tx to bytes
-	rawTx, err := proto.Marshal(tx)
+	rawTx, err := tx.SigningBytes()
 	if err != nil {
 		client.error("Failed marshaling tx [%s].", err.Error())
 		return nil, err
@@ -232,7 +237,7 @@ func (client *clientImpl) newChaincodeQueryUsingTCert(chaincodeInvocation *obc.C
 
 	// Sign the transaction and append the signature
 	// 1. Marshall tx to bytes
-	rawTx, err := proto.Marshal(tx)
+	rawTx, err := tx.SigningBytes()
 	if err != nil {
 		client.error("Failed marshaling tx [%s].", err.Error())
 		return nil, err
@@ -269,7 +274,7 @@ func (client *clientImpl) newChaincodeDeployUsingECert(chaincodeDeploymentSpec *
 
 	// Sign the transaction and append the signature
 	// 1. Marshall tx to bytes
-	rawTx, err := proto.Marshal(tx)
+	rawTx, err := tx.SigningBytes()
 	if err != nil {
 		client.error("Failed marshaling tx [%s].", err.Error())
 		return nil, err
@@ -306,7 +311,7 @@ func (client *clientImpl) newChaincodeExecuteUsingECert(chaincodeInvocation *obc
 
 	// Sign the transaction and append the signature
 	// 1. Marshall tx to bytes
-	rawTx, err := proto.Marshal(tx)
+	rawTx, err := tx.SigningBytes()
 	if err != nil {
 		client.error("Failed marshaling tx [%s].", err.Error())
 		return nil, err
@@ -343,7 +348,7 @@ func (client *clientImpl) newChaincodeQueryUsingECert(chaincodeInvocation *obc.C
 
 	// Sign the transaction and append the signature
 	// 1. Marshall tx to bytes
-	rawTx, err := proto.Marshal(tx)
+	rawTx, err := tx.SigningBytes()
 	if err != nil {
 		client.error("Failed marshaling tx [%s].", err.Error())
 		return nil, err
@@ -387,14 +392,11 @@ func (client *clientImpl) checkTransaction(tx *obc.Transaction) error {
 		// TODO: verify cert
 
 		// 3. Marshall tx without signature
-		signature := tx.Signature
-		tx.Signature = nil
-		rawTx, err := proto.Marshal(tx)
+		rawTx, err := tx.SigningBytes()
 		if err != nil {
 			client.error("Failed marshaling tx [%s].", err.Error())
 			return err
 		}
-		tx.Signature = signature
 
 		// 2. Verify signature
 		ver, err := client.verify(cert.PublicKey, rawTx, tx.Signature)
@@ -64,6 +64,9 @@ type Peer interface {
 	// GetEnrollmentID returns this peer's enrollment id
 	GetEnrollmentID() string
 
+	// GetEnrollmentCertificate returns the DER of this peer's enrollment certificate
+	GetEnrollmentCertificate() []byte
+
 	// TransactionPreValidation verifies that the transaction is
 	// well formed with the respect to the security layer
 	// prescriptions (i.e. signature verification).
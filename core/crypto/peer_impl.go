@@ -20,7 +20,6 @@ under the License.
 package crypto
 
 import (
-	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/crypto/utils"
 	obc "github.com/hyperledger/fabric/protos"
 )
@@ -43,6 +42,11 @@ func (peer *peerImpl) GetEnrollmentID() string {
 	return peer.enrollID
 }
 
+// GetEnrollmentCertificate returns the DER of this peer's enrollment certificate
+func (peer *peerImpl) GetEnrollmentCertificate() []byte {
+	return utils.Clone(peer.enrollCert.Raw)
+}
+
 // TransactionPreValidation verifies that the transaction is
 // well formed with the respect to the security layer
 // prescriptions (i.e. signature verification).
@@ -66,14 +70,11 @@ func (peer *peerImpl) TransactionPreValidation(tx *obc.Transaction) (*obc.Transa
 		// TODO: verify cert
 
 		// 3. Marshall tx without signature
-		signature := tx.Signature
-		tx.Signature = nil
-		rawTx, err := proto.Marshal(tx)
+		rawTx, err := tx.SigningBytes()
 		if err != nil {
 			peer.error("TransactionPreExecution: failed marshaling tx [%s] [%s].", err.Error())
 			return tx, err
 		}
-		tx.Signature = signature
 
 		// 2. Verify signature
 		ok, err := peer.verify(cert.PublicKey, rawTx, tx.Signature)
@@ -110,6 +110,19 @@ func (validator *validatorImpl) deepCloneAndDecryptTx1_1(tx *obc.Transaction) (*
 		clone.Metadata = metadata
 	}
 
+	// Decrypt transient data
+	if len(clone.Transient) != 0 {
+		transientKey := utils.HMACTruncated(key, []byte{4}, utils.AESKeyLength)
+		for k, v := range clone.Transient {
+			value, err := utils.CBCPKCS7Decrypt(transientKey, utils.Clone(v))
+			if err != nil {
+				validator.error("Failed decrypting transient data [%s].", err.Error())
+				return nil, err
+			}
+			clone.Transient[k] = value
+		}
+	}
+
 	return clone, nil
 }
 
@@ -191,5 +204,15 @@ func (validator *validatorImpl) deepCloneAndDecryptTx1_2(tx *obc.Transaction) (*
 		clone.Metadata = metadata
 	}
 
+	// Decrypt transient data
+	for k, v := range clone.Transient {
+		value, err := cipher.Process(v)
+		if err != nil {
+			validator.error("Failed decrypting transient data [%s].", err.Error())
+			return nil, err
+		}
+		clone.Transient[k] = value
+	}
+
 	return clone, nil
 }
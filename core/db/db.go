@@ -25,6 +25,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/op/go-logging"
 	"github.com/spf13/viper"
@@ -47,6 +48,11 @@ type OpenchainDB struct {
 	StateCF      *gorocksdb.ColumnFamilyHandle
 	StateDeltaCF *gorocksdb.ColumnFamilyHandle
 	IndexesCF    *gorocksdb.ColumnFamilyHandle
+
+	committer *groupCommitter
+	syncWrite bool
+	// chainID is set for the database of a chain other than the default one
+	chainID string
 }
 
 var openchainDB *OpenchainDB
@@ -54,7 +60,10 @@ var isOpen bool
 
 // CreateDB creates a rocks db database
 func CreateDB() error {
-	dbPath := getDBPath()
+	return createDB(getDBPath())
+}
+
+func createDB(dbPath string) error {
 	dbLogger.Debug("Creating DB at [%s]", dbPath)
 	missing, err := dirMissingOrEmpty(dbPath)
 	if err != nil {
@@ -109,6 +118,25 @@ func GetDBHandle() *OpenchainDB {
 	return openchainDB
 }
 
+// OpenChainDB opens the database of a chain other than the default one,
+// creating it if needed. Each chain has a database of its own, under
+// peer.fileSystemPath/chains, apart from the one of GetDBHandle.
+func OpenChainDB(chainID string) (*OpenchainDB, error) {
+	if chainID == "" || chainID == "." || chainID == ".." || strings.ContainsAny(chainID, "/\\") {
+		return nil, fmt.Errorf("Invalid chain ID [%s]", chainID)
+	}
+	dbPath := getChainDBPath(chainID)
+	if err := createDBIfPathEmpty(dbPath); err != nil {
+		return nil, err
+	}
+	chainDB, err := openDBAt(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	chainDB.chainID = chainID
+	return chainDB, nil
+}
+
 // GetFromBlockchainCF get value for given key from column family - blockchainCF
 func (openchainDB *OpenchainDB) GetFromBlockchainCF(key []byte) ([]byte, error) {
 	return openchainDB.get(openchainDB.BlockchainCF, key)
@@ -156,6 +184,11 @@ func (openchainDB *OpenchainDB) GetStateDeltaCFIterator() *gorocksdb.Iterator {
 	return openchainDB.getIterator(openchainDB.StateDeltaCF)
 }
 
+// GetIndexesCFIterator get iterator for column family - indexCF
+func (openchainDB *OpenchainDB) GetIndexesCFIterator() *gorocksdb.Iterator {
+	return openchainDB.getIterator(openchainDB.IndexesCF)
+}
+
 // GetSnapshot returns a point-in-time view of the DB. You MUST call snapshot.Release()
 // when you are done with the snapshot.
 func (openchainDB *OpenchainDB) GetSnapshot() *gorocksdb.Snapshot {
@@ -173,18 +206,22 @@ func getDBPath() string {
 	return dbPath + "db"
 }
 
+func getChainDBPath(chainID string) string {
+	return path.Join(path.Dir(getDBPath()), "chains", chainID, "db")
+}
+
 func createDBIfDBPathEmpty() error {
-	dbPath := getDBPath()
+	return createDBIfPathEmpty(getDBPath())
+}
+
+func createDBIfPathEmpty(dbPath string) error {
 	missing, err := dirMissingOrEmpty(dbPath)
 	if err != nil {
 		return err
 	}
 	dbLogger.Debug("Is db path [%s] empty [%t]", dbPath, missing)
 	if missing {
-		err := CreateDB()
-		if err != nil {
-			return nil
-		}
+		return createDB(dbPath)
 	}
 	return nil
 }
@@ -193,8 +230,15 @@ func openDB() (*OpenchainDB, error) {
 	if isOpen {
 		return openchainDB, nil
 	}
+	openchainDB, err := openDBAt(getDBPath())
+	if err != nil {
+		return nil, err
+	}
+	isOpen = true
+	return openchainDB, nil
+}
 
-	dbPath := getDBPath()
+func openDBAt(dbPath string) (*OpenchainDB, error) {
 	opts := gorocksdb.NewDefaultOptions()
 	defer opts.Destroy()
 
@@ -206,8 +250,27 @@ func openDB() (*OpenchainDB, error) {
 		fmt.Println("Error opening DB", err)
 		return nil, err
 	}
-	isOpen = true
-	return &OpenchainDB{db, cfHandlers[1], cfHandlers[2], cfHandlers[3], cfHandlers[4]}, nil
+	openchainDB := &OpenchainDB{DB: db, BlockchainCF: cfHandlers[1], StateCF: cfHandlers[2], StateDeltaCF: cfHandlers[3], IndexesCF: cfHandlers[4]}
+	openchainDB.syncWrite = viper.GetBool("ledger.groupCommit.sync")
+	maxLatency := time.Duration(viper.GetInt("ledger.groupCommit.maxLatency")) * time.Millisecond
+	openchainDB.committer = newGroupCommitter(maxLatency, viper.GetInt("ledger.groupCommit.maxBatches"), openchainDB.writeData)
+	return openchainDB, nil
+}
+
+// Commit writes the write batch, along with the batches committed
+// concurrently, in a single write. With ledger.groupCommit.sync the write is
+// synced to disk before Commit returns.
+func (openchainDB *OpenchainDB) Commit(writeBatch *gorocksdb.WriteBatch) error {
+	return openchainDB.committer.commit(writeBatch.Data())
+}
+
+func (openchainDB *OpenchainDB) writeData(data []byte) error {
+	writeBatch := gorocksdb.WriteBatchFrom(data)
+	defer writeBatch.Destroy()
+	opt := gorocksdb.NewDefaultWriteOptions()
+	defer opt.Destroy()
+	opt.SetSync(openchainDB.syncWrite)
+	return openchainDB.DB.Write(opt, writeBatch)
 }
 
 // CloseDB releases all column family handles and closes rocksdb
@@ -216,7 +279,9 @@ func (openchainDB *OpenchainDB) CloseDB() {
 	openchainDB.StateCF.Destroy()
 	openchainDB.StateDeltaCF.Destroy()
 	openchainDB.DB.Close()
-	isOpen = false
+	if openchainDB.chainID == "" {
+		isOpen = false
+	}
 }
 
 // DeleteState delets ALL state keys/values from the DB. This is generally
@@ -0,0 +1,131 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Size of the header of a serialized rocksdb WriteBatch, an 8 bytes sequence
+// number followed by a 4 bytes count of records, both little endian
+const writeBatchHeaderSize = 12
+
+// mergeWriteBatches concatenates serialized write batches into a single one
+// holding all of their records, in order
+func mergeWriteBatches(batches [][]byte) ([]byte, error) {
+	if len(batches) == 1 {
+		return batches[0], nil
+	}
+	size := writeBatchHeaderSize
+	for _, batch := range batches {
+		if len(batch) < writeBatchHeaderSize {
+			return nil, fmt.Errorf("Invalid write batch of %d bytes", len(batch))
+		}
+		size += len(batch) - writeBatchHeaderSize
+	}
+	merged := make([]byte, writeBatchHeaderSize, size)
+	var count uint32
+	for _, batch := range batches {
+		count += binary.LittleEndian.Uint32(batch[8:writeBatchHeaderSize])
+		merged = append(merged, batch[writeBatchHeaderSize:]...)
+	}
+	binary.LittleEndian.PutUint32(merged[8:writeBatchHeaderSize], count)
+	return merged, nil
+}
+
+// groupCommitter merges the write batches committed concurrently into a single
+// write, so that a synced write pays for one fsync for all of them (group
+// commit). The first batch of a group waits up to maxLatency, or until the
+// group holds maxBatches batches, for others to join. Batches committed while
+// a group is being written form the next group.
+type groupCommitter struct {
+	maxLatency time.Duration
+	maxBatches int
+	write      func(data []byte) error
+
+	sync.Mutex
+	pending *commitGroup
+	// serializes the writes of successive groups
+	writing sync.Mutex
+}
+
+// commitGroup is the set of batches written together, all of them fail if the
+// write fails
+type commitGroup struct {
+	batches [][]byte
+	full    chan struct{}
+	done    chan struct{}
+	err     error
+}
+
+func newGroupCommitter(maxLatency time.Duration, maxBatches int, write func(data []byte) error) *groupCommitter {
+	return &groupCommitter{maxLatency: maxLatency, maxBatches: maxBatches, write: write}
+}
+
+// commit writes the serialized write batch along with those committed
+// concurrently and returns once they are written
+func (c *groupCommitter) commit(data []byte) error {
+	c.Lock()
+	group := c.pending
+	leader := group == nil
+	if leader {
+		group = &commitGroup{full: make(chan struct{}), done: make(chan struct{})}
+		c.pending = group
+	}
+	group.batches = append(group.batches, data)
+	if len(group.batches) == c.maxBatches {
+		close(group.full)
+	}
+	c.Unlock()
+
+	if !leader {
+		<-group.done
+		return group.err
+	}
+
+	if c.maxLatency > 0 {
+		timer := time.NewTimer(c.maxLatency)
+		select {
+		case <-timer.C:
+		case <-group.full:
+			timer.Stop()
+		}
+	}
+	// the group keeps growing until the previous one is written
+	c.writing.Lock()
+	defer c.writing.Unlock()
+	c.Lock()
+	c.pending = nil
+	c.Unlock()
+
+	merged, err := mergeWriteBatches(group.batches)
+	if err == nil {
+		err = c.write(merged)
+	}
+	if len(group.batches) > 1 {
+		dbLogger.Debug("Group committed %d write batches, error: %v", len(group.batches), err)
+	}
+	group.err = err
+	close(group.done)
+	return err
+}
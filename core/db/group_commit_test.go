@@ -0,0 +1,109 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testWriteBatch builds a serialized write batch of count records
+func testWriteBatch(count uint32, records string) []byte {
+	data := make([]byte, writeBatchHeaderSize)
+	binary.LittleEndian.PutUint32(data[8:], count)
+	return append(data, records...)
+}
+
+func TestMergeWriteBatches(t *testing.T) {
+	merged, err := mergeWriteBatches([][]byte{testWriteBatch(1, "a"), testWriteBatch(2, "bc"), testWriteBatch(0, "")})
+	if err != nil {
+		t.Fatalf("Error merging write batches: %s", err)
+	}
+	if expected := testWriteBatch(3, "abc"); !bytes.Equal(merged, expected) {
+		t.Fatalf("Expected %x, got %x", expected, merged)
+	}
+	if _, err := mergeWriteBatches([][]byte{testWriteBatch(1, "a"), []byte("short")}); err == nil {
+		t.Fatal("Expected an error merging a truncated write batch")
+	}
+}
+
+func TestGroupCommitMergesConcurrentCommits(t *testing.T) {
+	var writes [][]byte
+	var mutex sync.Mutex
+	committer := newGroupCommitter(time.Minute, 5, func(data []byte) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		writes = append(writes, data)
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := committer.commit(testWriteBatch(1, "x")); err != nil {
+				t.Errorf("Error committing: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+	// the minute long latency budget is cut short by the fifth batch
+	if len(writes) != 1 || !bytes.Equal(writes[0], testWriteBatch(5, "xxxxx")) {
+		t.Fatalf("Expected a single write of the 5 batches, got %x", writes)
+	}
+}
+
+func TestGroupCommitFailsWholeGroup(t *testing.T) {
+	committer := newGroupCommitter(time.Minute, 2, func(data []byte) error {
+		return fmt.Errorf("disk full")
+	})
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			errs <- committer.commit(testWriteBatch(1, "x"))
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err == nil {
+			t.Fatal("Expected every batch of the group to fail")
+		}
+	}
+}
+
+func TestGroupCommitWithoutLatency(t *testing.T) {
+	writes := 0
+	committer := newGroupCommitter(0, 0, func(data []byte) error {
+		writes++
+		return nil
+	})
+	for i := 0; i < 3; i++ {
+		if err := committer.commit(testWriteBatch(1, "x")); err != nil {
+			t.Fatalf("Error committing: %s", err)
+		}
+	}
+	if writes != 3 {
+		t.Fatalf("Expected sequential commits to be written one by one, got %d writes", writes)
+	}
+}
@@ -22,6 +22,8 @@ package core
 import (
 	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/op/go-logging"
 	"github.com/spf13/viper"
@@ -42,14 +44,27 @@ var devopsLogger = logging.MustGetLogger("devops")
 func NewDevopsServer(coord peer.MessageHandlerCoordinator) *Devops {
 	d := new(Devops)
 	d.coord = coord
+	d.queries = make(map[string]*chaincode.PendingExecution)
 	return d
 }
 
 // Devops implementation of Devops services
 type Devops struct {
 	coord peer.MessageHandlerCoordinator
+
+	// queries started with QueryAsync by UUID, until polled or expired
+	queriesLock sync.Mutex
+	queries     map[string]*chaincode.PendingExecution
 }
 
+// asyncQueryTTL is how long the outcome of a query started with QueryAsync is
+// kept for PollQuery once the query completed
+const asyncQueryTTL = 10 * time.Minute
+
+// ErrUnknownQuery is returned by PollQuery for a query it does not know of,
+// or whose outcome was already returned or expired
+var ErrUnknownQuery = errors.New("Unknown query")
+
 // Login establishes the security context with the Devops service
 func (d *Devops) Login(ctx context.Context, secret *pb.Secret) (*pb.Response, error) {
 	if err := crypto.RegisterClient(secret.EnrollId, nil, secret.EnrollId, secret.EnrollSecret); nil != err {
@@ -83,6 +98,9 @@ func (*Devops) Build(context context.Context, spec *pb.ChaincodeSpec) (*pb.Chain
 		}
 	}
 	chaincodeDeploymentSpec := &pb.ChaincodeDeploymentSpec{ChaincodeSpec: spec, CodePackage: codePackageBytes}
+	if codePackageBytes != nil {
+		chaincodeDeploymentSpec.CodePackageHash = util.ComputeCryptoHash(codePackageBytes)
+	}
 	return chaincodeDeploymentSpec, nil
 }
 
@@ -105,6 +123,9 @@ func (*Devops) getChaincodeBytes(context context.Context, spec *pb.ChaincodeSpec
 		}
 	}
 	chaincodeDeploymentSpec := &pb.ChaincodeDeploymentSpec{ChaincodeSpec: spec, CodePackage: codePackageBytes}
+	if codePackageBytes != nil {
+		chaincodeDeploymentSpec.CodePackageHash = util.ComputeCryptoHash(codePackageBytes)
+	}
 	return chaincodeDeploymentSpec, nil
 }
 
@@ -198,7 +219,7 @@ func (d *Devops) invokeOrQuery(ctx context.Context, chaincodeInvocationSpec *pb.
 		devopsLogger.Debug("Sending invocation transaction (%s) to validator", transaction.Uuid)
 	}
 	resp := d.coord.ExecuteTransaction(transaction)
-	if resp.Status == pb.Response_FAILURE {
+	if resp.Status == pb.Response_FAILURE || resp.Status == pb.Response_EXPIRED {
 		err = fmt.Errorf(string(resp.Msg))
 	} else {
 		if !invoke && nil != sec && viper.GetBool("security.privacy") {
@@ -254,6 +275,114 @@ func (d *Devops) Query(ctx context.Context, chaincodeInvocationSpec *pb.Chaincod
 	return d.invokeOrQuery(ctx, chaincodeInvocationSpec, false)
 }
 
+// Simulate executes the supplied invocation against the committed state of
+// this peer and returns the response and read-write set it produced. The
+// transaction is never submitted for consensus nor committed. Only validating
+// and follower peers run chaincode, so simulation is not available on others.
+func (d *Devops) Simulate(ctx context.Context, chaincodeInvocationSpec *pb.ChaincodeInvocationSpec) (*pb.SimulationResult, error) {
+	if !viper.GetBool("peer.validator.enabled") && !peer.IsFollower() {
+		return nil, fmt.Errorf("Simulation is only available on validating and follower peers")
+	}
+	if chaincodeInvocationSpec.ChaincodeSpec.ChaincodeID.Name == "" {
+		return nil, fmt.Errorf("name not given for simulation")
+	}
+
+	uuid := util.GenerateUUID()
+	var sec crypto.Client
+	var err error
+	if viper.GetBool("security.enabled") {
+		sec, err = crypto.InitClient(chaincodeInvocationSpec.ChaincodeSpec.SecureContext, nil)
+		defer crypto.CloseClient(sec)
+		chaincodeInvocationSpec.ChaincodeSpec.SecureContext = ""
+		if nil != err {
+			return nil, err
+		}
+	}
+	transaction, err := d.createExecTx(chaincodeInvocationSpec, uuid, true, sec)
+	if err != nil {
+		return nil, err
+	}
+	if devopsLogger.IsEnabledFor(logging.DEBUG) {
+		devopsLogger.Debug("Simulating invocation transaction (%s)", transaction.Uuid)
+	}
+	return chaincode.Simulate(ctx, chaincode.GetChain(chaincode.DefaultChain), transaction)
+}
+
+// QueryAsync starts the supplied query on the specified chaincode and returns
+// without waiting for its result. The response carries the UUID of the query,
+// its result is retrieved with PollQuery. Only validating and follower peers
+// run chaincode, and confidential results are not supported.
+func (d *Devops) QueryAsync(ctx context.Context, chaincodeInvocationSpec *pb.ChaincodeInvocationSpec) (*pb.Response, error) {
+	if !viper.GetBool("peer.validator.enabled") && !peer.IsFollower() {
+		return nil, fmt.Errorf("Asynchronous queries are only available on validating and follower peers")
+	}
+	if viper.GetBool("security.privacy") {
+		return nil, fmt.Errorf("Asynchronous queries are not available when privacy is enabled")
+	}
+	if chaincodeInvocationSpec.ChaincodeSpec.ChaincodeID.Name == "" {
+		return nil, fmt.Errorf("name not given for query")
+	}
+
+	uuid := util.GenerateUUID()
+	var sec crypto.Client
+	var err error
+	if viper.GetBool("security.enabled") {
+		sec, err = crypto.InitClient(chaincodeInvocationSpec.ChaincodeSpec.SecureContext, nil)
+		defer crypto.CloseClient(sec)
+		chaincodeInvocationSpec.ChaincodeSpec.SecureContext = ""
+		if nil != err {
+			return nil, err
+		}
+	}
+	transaction, err := d.createExecTx(chaincodeInvocationSpec, uuid, false, sec)
+	if err != nil {
+		return nil, err
+	}
+	if devopsLogger.IsEnabledFor(logging.DEBUG) {
+		devopsLogger.Debug("Starting query transaction (%s)", transaction.Uuid)
+	}
+	pending, err := chaincode.QueryAsync(ctx, chaincode.GetChain(chaincode.DefaultChain), transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	d.queriesLock.Lock()
+	d.queries[uuid] = pending
+	d.queriesLock.Unlock()
+	// the outcome of a query nobody polls is dropped after a while
+	pending.OnComplete(func(*pb.ChaincodeMessage, error) {
+		time.AfterFunc(asyncQueryTTL, func() { d.forgetQuery(uuid) })
+	})
+	return &pb.Response{Status: pb.Response_SUCCESS, Msg: []byte(uuid)}, nil
+}
+
+// PollQuery returns the result of query uuid started with QueryAsync, done is
+// false while the query is running. The result is returned once, the query is
+// then forgotten.
+func (d *Devops) PollQuery(uuid string) (resp *pb.Response, done bool, err error) {
+	d.queriesLock.Lock()
+	pending, ok := d.queries[uuid]
+	d.queriesLock.Unlock()
+	if !ok {
+		return nil, false, ErrUnknownQuery
+	}
+	msg, done, err := pending.Poll()
+	if !done {
+		return nil, false, nil
+	}
+	d.forgetQuery(uuid)
+	if err != nil {
+		return nil, true, err
+	}
+	return &pb.Response{Status: pb.Response_SUCCESS, Msg: msg.Payload}, true, nil
+}
+
+func (d *Devops) forgetQuery(uuid string) {
+	d.queriesLock.Lock()
+	defer d.queriesLock.Unlock()
+	delete(d.queries, uuid)
+}
+
 // CheckSpec to see if chaincode resides within current package capture for language.
 func CheckSpec(spec *pb.ChaincodeSpec) error {
 	// Don't allow nil value
@@ -95,3 +95,11 @@ func TestDevops_Spec_NoVersion(t *testing.T) {
 	t.Logf("Deploy result = %s, err = %s", buildResult, err)
 	//performHandshake(t, peerClientConn)
 }
+
+func TestDevops_PollQuery_Unknown(t *testing.T) {
+	devopsServer := NewDevopsServer(nil)
+
+	if _, done, err := devopsServer.PollQuery("unknown"); err != ErrUnknownQuery || done {
+		t.Fatalf("Expected ErrUnknownQuery for a query never started, got %t, %v", done, err)
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package errors
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ComponentCode identifies the component an error originates from
+type ComponentCode string
+
+// ReasonCode identifies the reason of an error within its component
+type ReasonCode string
+
+// DefaultLanguage is the language of the canonical messages, the ones written
+// to the logs and returned when no translation exists
+const DefaultLanguage = "en"
+
+// Components
+const (
+	Rest ComponentCode = "REST"
+)
+
+// Reasons
+const (
+	MissingSecret        ReasonCode = "MISSING_SECRET"
+	BlankCredentials     ReasonCode = "BLANK_CREDENTIALS"
+	LoginRequired        ReasonCode = "LOGIN_REQUIRED"
+	DeleteLoginToken     ReasonCode = "DELETE_LOGIN_TOKEN"
+	DeleteLoginDirectory ReasonCode = "DELETE_LOGIN_DIRECTORY"
+	NoCertificateHandler ReasonCode = "NO_CERTIFICATE_HANDLER"
+	NilEnrollmentCert    ReasonCode = "NIL_ENROLLMENT_CERT"
+	EmptyEnrollmentCert  ReasonCode = "EMPTY_ENROLLMENT_CERT"
+	SecurityDisabled     ReasonCode = "SECURITY_DISABLED"
+)
+
+// messages is the catalog of message templates, indexed by error code then
+// language. Templates are fmt format strings, every translation of a message
+// must take the same arguments in the same order.
+var messages = map[string]map[string]string{
+	code(Rest, MissingSecret): {
+		"en": "Payload must contain object Secret with enrollId and enrollSecret fields.",
+		"fr": "Le contenu doit contenir un objet Secret avec les champs enrollId et enrollSecret.",
+		"es": "El contenido debe incluir un objeto Secret con los campos enrollId y enrollSecret.",
+	},
+	code(Rest, BlankCredentials): {
+		"en": "enrollId and enrollSecret may not be blank.",
+		"fr": "enrollId et enrollSecret ne peuvent pas être vides.",
+		"es": "enrollId y enrollSecret no pueden estar vacíos.",
+	},
+	code(Rest, LoginRequired): {
+		"en": "User %s must log in.",
+		"fr": "L'utilisateur %s doit se connecter.",
+		"es": "El usuario %s debe iniciar sesión.",
+	},
+	code(Rest, DeleteLoginToken): {
+		"en": "Error trying to delete login token for user %s: %s",
+		"fr": "Erreur lors de la suppression du jeton de connexion de l'utilisateur %s : %s",
+		"es": "Error al eliminar el token de sesión del usuario %s: %s",
+	},
+	code(Rest, DeleteLoginDirectory): {
+		"en": "Error trying to delete login directory for user %s: %s",
+		"fr": "Erreur lors de la suppression du répertoire de connexion de l'utilisateur %s : %s",
+		"es": "Error al eliminar el directorio de sesión del usuario %s: %s",
+	},
+	code(Rest, NoCertificateHandler): {
+		"en": "Error retrieving certificate handler.",
+		"fr": "Erreur lors de la récupération du gestionnaire de certificats.",
+		"es": "Error al obtener el gestor de certificados.",
+	},
+	code(Rest, NilEnrollmentCert): {
+		"en": "Enrollment certificate is nil.",
+		"fr": "Le certificat d'inscription est absent.",
+		"es": "El certificado de inscripción no existe.",
+	},
+	code(Rest, EmptyEnrollmentCert): {
+		"en": "Enrollment certificate length is 0.",
+		"fr": "Le certificat d'inscription est vide.",
+		"es": "El certificado de inscripción está vacío.",
+	},
+	code(Rest, SecurityDisabled): {
+		"en": "Security functionality must be enabled before requesting client certificates.",
+		"fr": "La sécurité doit être activée avant de demander des certificats client.",
+		"es": "La seguridad debe estar habilitada antes de solicitar certificados de cliente.",
+	},
+}
+
+func code(component ComponentCode, reason ReasonCode) string {
+	return string(component) + "-" + string(reason)
+}
+
+// CodedError is an error with a stable code that clients can map to their own
+// text. Error returns the code and the canonical English message.
+type CodedError struct {
+	Component ComponentCode
+	Reason    ReasonCode
+	Args      []interface{}
+}
+
+// Error creates a CodedError. args fill the message template of the code.
+func Error(component ComponentCode, reason ReasonCode, args ...interface{}) *CodedError {
+	return &CodedError{Component: component, Reason: reason, Args: args}
+}
+
+// Code returns the stable error code, e.g. REST-LOGIN_REQUIRED
+func (e *CodedError) Code() string {
+	return code(e.Component, e.Reason)
+}
+
+// Message returns the canonical English message
+func (e *CodedError) Message() string {
+	return e.MessageIn(DefaultLanguage)
+}
+
+// MessageIn returns the message in the given language, falling back to the
+// canonical English message if there is no translation
+func (e *CodedError) MessageIn(language string) string {
+	templates, ok := messages[e.Code()]
+	if !ok {
+		return fmt.Sprintf("%s %v", e.Code(), e.Args)
+	}
+	template, ok := templates[language]
+	if !ok {
+		template = templates[DefaultLanguage]
+	}
+	return fmt.Sprintf(template, e.Args...)
+}
+
+func (e *CodedError) Error() string {
+	return fmt.Sprintf("%s - %s", e.Code(), e.Message())
+}
+
+// PreferredLanguage picks the first language of an HTTP Accept-Language header
+// value that the catalog supports, ignoring quality values and regions.
+// DefaultLanguage is returned if none is supported.
+func PreferredLanguage(acceptLanguage string) string {
+	for _, tag := range strings.Split(acceptLanguage, ",") {
+		tag = strings.TrimSpace(strings.SplitN(tag, ";", 2)[0])
+		tag = strings.ToLower(strings.SplitN(tag, "-", 2)[0])
+		if supported[tag] {
+			return tag
+		}
+	}
+	return DefaultLanguage
+}
+
+var supported = map[string]bool{"en": true, "fr": true, "es": true}
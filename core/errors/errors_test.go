@@ -0,0 +1,73 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package errors
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestError(t *testing.T) {
+	err := Error(Rest, LoginRequired, "jim")
+	if err.Code() != "REST-LOGIN_REQUIRED" {
+		t.Fatalf("Unexpected code %s", err.Code())
+	}
+	if err.Error() != "REST-LOGIN_REQUIRED - User jim must log in." {
+		t.Fatalf("Unexpected error string %s", err.Error())
+	}
+	if msg := err.MessageIn("fr"); msg != "L'utilisateur jim doit se connecter." {
+		t.Fatalf("Unexpected french message %s", msg)
+	}
+	if msg := err.MessageIn("xx"); msg != err.Message() {
+		t.Fatalf("Expected fallback to the canonical message, got %s", msg)
+	}
+}
+
+func TestCatalogTranslations(t *testing.T) {
+	for code, templates := range messages {
+		en, ok := templates[DefaultLanguage]
+		if !ok {
+			t.Fatalf("No canonical message for %s", code)
+		}
+		for language, template := range templates {
+			if !supported[language] {
+				t.Fatalf("Unsupported language %s for %s", language, code)
+			}
+			if strings.Count(template, "%") != strings.Count(en, "%") {
+				t.Fatalf("Message of %s in %s does not take the same arguments as the canonical one", code, language)
+			}
+		}
+	}
+}
+
+func TestPreferredLanguage(t *testing.T) {
+	cases := map[string]string{
+		"":                    "en",
+		"fr-CH, fr;q=0.9, en": "fr",
+		"de-DE, es;q=0.8":     "es",
+		"ja":                  "en",
+		" ES-mx ; q=1.0":      "es",
+	}
+	for header, expected := range cases {
+		if language := PreferredLanguage(header); language != expected {
+			t.Fatalf("Expected %s for %q, got %s", expected, header, language)
+		}
+	}
+}
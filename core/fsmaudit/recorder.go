@@ -0,0 +1,107 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package fsmaudit records the FSM transitions of the chaincode and peer
+// handlers in a ring buffer, so that the history that led a handler into a
+// bad state can be exported through the Admin service.
+package fsmaudit
+
+import (
+	"sync"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// Handler kinds recorded in FSMTransition.Handler
+const (
+	ChaincodeHandler = "chaincode"
+	PeerHandler      = "peer"
+)
+
+// Recorder keeps the most recent transitions. A nil Recorder records nothing.
+type Recorder struct {
+	sync.Mutex
+	entries []*pb.FSMTransition
+	next    int
+	full    bool
+}
+
+// NewRecorder returns a recorder keeping the last size transitions, nil if
+// size is not positive
+func NewRecorder(size int) *Recorder {
+	if size <= 0 {
+		return nil
+	}
+	return &Recorder{entries: make([]*pb.FSMTransition, size)}
+}
+
+// Record appends a transition, overwriting the oldest one once full. err is
+// the reason the event was refused or failed, if it was.
+func (r *Recorder) Record(handler, name, uuid, event, src, dst string, err error) {
+	if r == nil {
+		return
+	}
+	t := &pb.FSMTransition{
+		TimestampNanos: time.Now().UnixNano(),
+		Handler:        handler,
+		Name:           name,
+		Uuid:           uuid,
+		Event:          event,
+		SrcState:       src,
+		DstState:       dst,
+	}
+	if err != nil {
+		t.Error = err.Error()
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	r.entries[r.next] = t
+	r.next++
+	if r.next == len(r.entries) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// Transitions returns the recorded transitions matching req, oldest first.
+// Empty fields of req match everything.
+func (r *Recorder) Transitions(req *pb.FSMTransitionsRequest) []*pb.FSMTransition {
+	if r == nil {
+		return nil
+	}
+	r.Lock()
+	defer r.Unlock()
+	var ordered []*pb.FSMTransition
+	if r.full {
+		ordered = append(ordered, r.entries[r.next:]...)
+	}
+	ordered = append(ordered, r.entries[:r.next]...)
+
+	var matched []*pb.FSMTransition
+	for _, t := range ordered {
+		if (req.Handler == "" || req.Handler == t.Handler) &&
+			(req.Name == "" || req.Name == t.Name) &&
+			(req.Uuid == "" || req.Uuid == t.Uuid) {
+			matched = append(matched, t)
+		}
+	}
+	return matched
+}
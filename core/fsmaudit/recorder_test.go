@@ -0,0 +1,72 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package fsmaudit
+
+import (
+	"fmt"
+	"testing"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestRecorderWraparound(t *testing.T) {
+	r := NewRecorder(3)
+	for i := 0; i < 5; i++ {
+		r.Record(ChaincodeHandler, "mycc", fmt.Sprintf("tx%d", i), "TRANSACTION", "ready", "busyxact", nil)
+	}
+	got := r.Transitions(&pb.FSMTransitionsRequest{})
+	if len(got) != 3 {
+		t.Fatalf("Expected 3 transitions, got %d", len(got))
+	}
+	for i, tr := range got {
+		if expected := fmt.Sprintf("tx%d", i+2); tr.Uuid != expected {
+			t.Fatalf("Expected transition %d to be %s, got %s", i, expected, tr.Uuid)
+		}
+	}
+}
+
+func TestRecorderFilter(t *testing.T) {
+	r := NewRecorder(10)
+	r.Record(ChaincodeHandler, "mycc", "tx1", "TRANSACTION", "ready", "busyxact", nil)
+	r.Record(ChaincodeHandler, "other", "tx2", "PUT_STATE", "ready", "ready", fmt.Errorf("refused"))
+	r.Record(PeerHandler, "vp1", "", "DISC_HELLO", "created", "established", nil)
+
+	if got := r.Transitions(&pb.FSMTransitionsRequest{Handler: PeerHandler}); len(got) != 1 || got[0].Name != "vp1" {
+		t.Fatalf("Unexpected peer transitions: %v", got)
+	}
+	got := r.Transitions(&pb.FSMTransitionsRequest{Name: "other", Uuid: "tx2"})
+	if len(got) != 1 || got[0].Error != "refused" {
+		t.Fatalf("Unexpected filtered transitions: %v", got)
+	}
+	if got := r.Transitions(&pb.FSMTransitionsRequest{Uuid: "tx3"}); len(got) != 0 {
+		t.Fatalf("Expected no transitions, got %v", got)
+	}
+}
+
+func TestRecorderDisabled(t *testing.T) {
+	r := NewRecorder(0)
+	if r != nil {
+		t.Fatalf("Expected a nil recorder")
+	}
+	r.Record(PeerHandler, "vp1", "", "DISC_HELLO", "created", "established", nil)
+	if got := r.Transitions(&pb.FSMTransitionsRequest{}); got != nil {
+		t.Fatalf("Expected no transitions, got %v", got)
+	}
+}
@@ -0,0 +1,295 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package fsmtest drives the FSMs of the chaincode and peer handlers through
+// scripted sequences of messages over fake streams. A script delivers
+// messages to the handler, expects the messages it sends in answer and the
+// states its FSM reaches. Independent scripts can be interleaved at random to
+// look for orderings the handler does not cope with.
+package fsmtest
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// DefaultTimeout is how long a Runner waits for an expected message or state
+// unless told otherwise
+const DefaultTimeout = 5 * time.Second
+
+// Matcher checks a message sent by the handler, returning why it does not
+// match
+type Matcher func(msg proto.Message) error
+
+// Step of a Script, one of delivering a message to the handler, expecting a
+// message from it or expecting the state of its FSM
+type Step struct {
+	// Deliver is handed to the handler as if received on its stream
+	Deliver proto.Message
+	// Refused requires the handler to return an error for Deliver
+	Refused bool
+	// Expect matches a message the handler sent
+	Expect Matcher
+	// State is the state the FSM of the handler must reach
+	State string
+}
+
+// Script is a sequence of steps run in order
+type Script []Step
+
+// Deliver returns the step delivering msg to the handler
+func Deliver(msg proto.Message) Step {
+	return Step{Deliver: msg}
+}
+
+// Refuse returns the step delivering msg to the handler, which must fail to
+// handle it
+func Refuse(msg proto.Message) Step {
+	return Step{Deliver: msg, Refused: true}
+}
+
+// Expect returns the step expecting the handler to send a message matched by
+// matcher
+func Expect(matcher Matcher) Step {
+	return Step{Expect: matcher}
+}
+
+// ExpectState returns the step expecting the FSM of the handler to reach
+// state
+func ExpectState(state string) Step {
+	return Step{State: state}
+}
+
+func (s Step) String() string {
+	switch {
+	case s.Deliver != nil && s.Refused:
+		return fmt.Sprintf("refuse %s", describe(s.Deliver))
+	case s.Deliver != nil:
+		return fmt.Sprintf("deliver %s", describe(s.Deliver))
+	case s.Expect != nil:
+		return "expect message"
+	}
+	return fmt.Sprintf("expect state %s", s.State)
+}
+
+// ChaincodeMessageOf matches the ChaincodeMessage of type t, and of uuid
+// unless empty
+func ChaincodeMessageOf(t pb.ChaincodeMessage_Type, uuid string) Matcher {
+	return func(msg proto.Message) error {
+		m, ok := msg.(*pb.ChaincodeMessage)
+		if !ok {
+			return fmt.Errorf("expected a ChaincodeMessage, got %T", msg)
+		}
+		if m.Type != t || (uuid != "" && m.Uuid != uuid) {
+			return fmt.Errorf("expected %s of uuid %q, got %s", t, uuid, describe(m))
+		}
+		return nil
+	}
+}
+
+// MessageOf matches the Message of type t
+func MessageOf(t pb.Message_Type) Matcher {
+	return func(msg proto.Message) error {
+		m, ok := msg.(*pb.Message)
+		if !ok {
+			return fmt.Errorf("expected a Message, got %T", msg)
+		}
+		if m.Type != t {
+			return fmt.Errorf("expected %s, got %s", t, describe(m))
+		}
+		return nil
+	}
+}
+
+func describe(msg proto.Message) string {
+	switch m := msg.(type) {
+	case *pb.ChaincodeMessage:
+		return fmt.Sprintf("%s of uuid %q", m.Type, m.Uuid)
+	case *pb.Message:
+		return m.Type.String()
+	}
+	return fmt.Sprintf("%T", msg)
+}
+
+// Runner runs scripts against a handler
+type Runner struct {
+	// Deliver hands msg to the handler, e.g. its HandleMessage or the
+	// Deliver of the fake stream it receives from
+	Deliver func(msg proto.Message) error
+	// State returns the current state of the FSM of the handler
+	State func() string
+	// Sent delivers the messages the handler sends
+	Sent <-chan proto.Message
+	// Timeout bounds the wait for each expected message or state,
+	// DefaultTimeout if 0
+	Timeout time.Duration
+
+	// unmatched holds the messages sent that no expectation matched yet
+	unmatched []proto.Message
+}
+
+// Run runs the steps of script in order. An expectation is matched against
+// the messages sent in the order they were sent, the messages it skips are
+// left for later expectations, so that the answers to interleaved scripts
+// may come in any order.
+func (r *Runner) Run(script Script) error {
+	for i, step := range script {
+		if err := r.run(step); err != nil {
+			return fmt.Errorf("step %d (%s): %s", i, step, err)
+		}
+	}
+	return nil
+}
+
+// Unmatched returns the messages sent that no expectation matched
+func (r *Runner) Unmatched() []proto.Message {
+	r.drain()
+	return r.unmatched
+}
+
+func (r *Runner) timeout() time.Duration {
+	if r.Timeout > 0 {
+		return r.Timeout
+	}
+	return DefaultTimeout
+}
+
+func (r *Runner) run(step Step) error {
+	switch {
+	case step.Deliver != nil:
+		err := r.Deliver(step.Deliver)
+		if step.Refused && err == nil {
+			return fmt.Errorf("expected the handler to fail")
+		} else if !step.Refused && err != nil {
+			return err
+		}
+		return nil
+	case step.Expect != nil:
+		return r.expect(step.Expect)
+	}
+	return r.expectState(step.State)
+}
+
+func (r *Runner) expect(matcher Matcher) error {
+	var mismatches []error
+	match := func(i int) bool {
+		err := matcher(r.unmatched[i])
+		if err == nil {
+			r.unmatched = append(r.unmatched[:i], r.unmatched[i+1:]...)
+			return true
+		}
+		mismatches = append(mismatches, err)
+		return false
+	}
+	r.drain()
+	for i := range r.unmatched {
+		if match(i) {
+			return nil
+		}
+	}
+	expire := time.After(r.timeout())
+	for {
+		select {
+		case msg := <-r.Sent:
+			r.unmatched = append(r.unmatched, msg)
+			if match(len(r.unmatched) - 1) {
+				return nil
+			}
+		case <-expire:
+			return fmt.Errorf("no matching message sent within %s: %v", r.timeout(), mismatches)
+		}
+	}
+}
+
+// drain moves the messages already sent to unmatched
+func (r *Runner) drain() {
+	for {
+		select {
+		case msg := <-r.Sent:
+			r.unmatched = append(r.unmatched, msg)
+		default:
+			return
+		}
+	}
+}
+
+func (r *Runner) expectState(state string) error {
+	expire := time.After(r.timeout())
+	for {
+		current := r.State()
+		if current == state {
+			return nil
+		}
+		select {
+		case <-expire:
+			return fmt.Errorf("state is %s", current)
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// Interleave merges scripts into one, drawing from rnd which script the next
+// step is taken from. The steps of each script keep their order.
+func Interleave(rnd *rand.Rand, scripts ...Script) Script {
+	var merged Script
+	next := make([]int, len(scripts))
+	remaining := 0
+	for _, script := range scripts {
+		remaining += len(script)
+	}
+	for ; remaining > 0; remaining-- {
+		pick := rnd.Intn(remaining)
+		for i, script := range scripts {
+			if left := len(script) - next[i]; pick >= left {
+				pick -= left
+				continue
+			}
+			merged = append(merged, script[next[i]])
+			next[i]++
+			break
+		}
+	}
+	return merged
+}
+
+// Fuzz runs rounds interleavings of scripts drawn from seed, each against
+// the runner newRunner returns for a fresh handler. newRunner also returns
+// the function releasing the handler. The first interleaving failing is
+// returned in the error along with its round.
+func Fuzz(seed int64, rounds int, newRunner func() (*Runner, func(), error), scripts ...Script) error {
+	rnd := rand.New(rand.NewSource(seed))
+	for round := 0; round < rounds; round++ {
+		script := Interleave(rnd, scripts...)
+		runner, release, err := newRunner()
+		if err != nil {
+			return fmt.Errorf("round %d of seed %d: %s", round, seed, err)
+		}
+		err = runner.Run(script)
+		release()
+		if err != nil {
+			return fmt.Errorf("round %d of seed %d, interleaving %v: %s", round, seed, script, err)
+		}
+	}
+	return nil
+}
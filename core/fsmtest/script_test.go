@@ -0,0 +1,165 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package fsmtest
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/looplab/fsm"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// discoveryHandler is a toy handler answering hellos and requests for peers
+type discoveryHandler struct {
+	stream *ChatStream
+	FSM    *fsm.FSM
+	// maxRequests refuses the requests for peers beyond it, 0 is unlimited
+	maxRequests int
+	requests    int
+}
+
+func newDiscoveryHandler(stream *ChatStream) *discoveryHandler {
+	h := &discoveryHandler{stream: stream}
+	h.FSM = fsm.NewFSM("created", fsm.Events{
+		{Name: pb.Message_DISC_HELLO.String(), Src: []string{"created"}, Dst: "established"},
+		{Name: pb.Message_DISC_GET_PEERS.String(), Src: []string{"established"}, Dst: "established"},
+	}, fsm.Callbacks{
+		"after_" + pb.Message_DISC_HELLO.String(): func(e *fsm.Event) {
+			h.stream.Send(&pb.Message{Type: pb.Message_DISC_HELLO})
+		},
+		"after_" + pb.Message_DISC_GET_PEERS.String(): func(e *fsm.Event) {
+			h.stream.Send(&pb.Message{Type: pb.Message_DISC_PEERS})
+		},
+	})
+	return h
+}
+
+func (h *discoveryHandler) handle(msg proto.Message) error {
+	m := msg.(*pb.Message)
+	if m.Type == pb.Message_DISC_GET_PEERS {
+		if h.requests++; h.maxRequests > 0 && h.requests > h.maxRequests {
+			return fmt.Errorf("too many requests")
+		}
+	}
+	err := h.FSM.Event(m.Type.String())
+	if noTransition, ok := err.(*fsm.NoTransitionError); ok && noTransition.Err == nil {
+		return nil
+	}
+	return err
+}
+
+func newDiscoveryRunner(h *discoveryHandler) *Runner {
+	return &Runner{Deliver: h.handle, State: h.FSM.Current, Sent: h.stream.Sent(), Timeout: 100 * time.Millisecond}
+}
+
+var hello = Script{
+	Deliver(&pb.Message{Type: pb.Message_DISC_HELLO}),
+	Expect(MessageOf(pb.Message_DISC_HELLO)),
+	ExpectState("established"),
+}
+
+var getPeers = Script{
+	Deliver(&pb.Message{Type: pb.Message_DISC_GET_PEERS}),
+	Expect(MessageOf(pb.Message_DISC_PEERS)),
+}
+
+func TestRunScript(t *testing.T) {
+	h := newDiscoveryHandler(NewChatStream())
+	runner := newDiscoveryRunner(h)
+	if err := runner.Run(hello); err != nil {
+		t.Fatalf("Expected the hello to be answered: %s", err)
+	}
+	if err := runner.Run(getPeers); err != nil {
+		t.Fatalf("Expected the request for peers to be answered: %s", err)
+	}
+	// a second hello is not an event of the established state
+	if err := runner.Run(Script{Refuse(&pb.Message{Type: pb.Message_DISC_HELLO})}); err != nil {
+		t.Fatalf("Expected the second hello to be refused: %s", err)
+	}
+	if unmatched := runner.Unmatched(); len(unmatched) != 0 {
+		t.Fatalf("Expected every message sent to be matched, %d were not", len(unmatched))
+	}
+}
+
+func TestRunScriptReportsFailingStep(t *testing.T) {
+	h := newDiscoveryHandler(NewChatStream())
+	runner := newDiscoveryRunner(h)
+	err := runner.Run(Script{ExpectState("created"), Deliver(&pb.Message{Type: pb.Message_DISC_HELLO}), Expect(MessageOf(pb.Message_DISC_PEERS))})
+	if err == nil || !strings.HasPrefix(err.Error(), "step 2") {
+		t.Fatalf("Expected the expectation of step 2 to fail, got %v", err)
+	}
+	// the message that did not match is left for later expectations
+	if err = runner.Run(Script{Expect(MessageOf(pb.Message_DISC_HELLO))}); err != nil {
+		t.Fatalf("Expected the hello sent to be matched later: %s", err)
+	}
+}
+
+func TestInterleaveKeepsOrderOfEachScript(t *testing.T) {
+	first := Script{ExpectState("a1"), ExpectState("a2"), ExpectState("a3")}
+	second := Script{ExpectState("b1"), ExpectState("b2")}
+	rnd := rand.New(rand.NewSource(1))
+	orders := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		merged := Interleave(rnd, first, second)
+		if len(merged) != len(first)+len(second) {
+			t.Fatalf("Expected %d steps, got %d", len(first)+len(second), len(merged))
+		}
+		var states []string
+		next := map[byte]int{'a': 1, 'b': 1}
+		for _, step := range merged {
+			script := step.State[0]
+			if want := fmt.Sprintf("%c%d", script, next[script]); step.State != want {
+				t.Fatalf("Expected %s next, got %s", want, step.State)
+			}
+			next[script]++
+			states = append(states, step.State)
+		}
+		orders[strings.Join(states, ",")] = true
+	}
+	// 5 steps of which 2 are of the second script: 10 interleavings
+	if len(orders) != 10 {
+		t.Fatalf("Expected all 10 interleavings to be drawn, got %d", len(orders))
+	}
+}
+
+func TestFuzz(t *testing.T) {
+	newRunner := func(maxRequests int) func() (*Runner, func(), error) {
+		return func() (*Runner, func(), error) {
+			stream := NewChatStream()
+			h := newDiscoveryHandler(stream)
+			h.maxRequests = maxRequests
+			runner := newDiscoveryRunner(h)
+			return runner, stream.Close, runner.Run(hello)
+		}
+	}
+	if err := Fuzz(1, 10, newRunner(0), getPeers, getPeers, getPeers); err != nil {
+		t.Fatalf("Expected every interleaving to pass: %s", err)
+	}
+	err := Fuzz(1, 10, newRunner(2), getPeers, getPeers, getPeers)
+	if err == nil || !strings.Contains(err.Error(), "round 0 of seed 1") {
+		t.Fatalf("Expected the third request to fail the first round, got %v", err)
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package fsmtest
+
+import (
+	"io"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// stream is the fake stream both ChaincodeStream and ChatStream build on.
+// The messages delivered are received by the handler, the messages the
+// handler sends are queued on sent.
+type stream struct {
+	recv   chan proto.Message
+	sent   chan proto.Message
+	closed chan struct{}
+	once   sync.Once
+}
+
+// sentBuffer is how many sent messages a stream queues before Send blocks
+const sentBuffer = 64
+
+func newStream() stream {
+	return stream{recv: make(chan proto.Message), sent: make(chan proto.Message, sentBuffer), closed: make(chan struct{})}
+}
+
+// Deliver waits for the handler to receive msg from the stream
+func (s *stream) Deliver(msg proto.Message) error {
+	select {
+	case s.recv <- msg:
+		return nil
+	case <-s.closed:
+		return io.ErrClosedPipe
+	}
+}
+
+// Sent delivers the messages the handler sends
+func (s *stream) Sent() <-chan proto.Message {
+	return s.sent
+}
+
+// Close ends the stream, the handler receives io.EOF
+func (s *stream) Close() {
+	s.once.Do(func() { close(s.closed) })
+}
+
+func (s *stream) send(msg proto.Message) error {
+	select {
+	case s.sent <- msg:
+		return nil
+	case <-s.closed:
+		return io.ErrClosedPipe
+	}
+}
+
+func (s *stream) receive() (proto.Message, error) {
+	select {
+	case msg := <-s.recv:
+		return msg, nil
+	case <-s.closed:
+		return nil, io.EOF
+	}
+}
+
+// ChaincodeStream is a fake chaincode support stream
+type ChaincodeStream struct {
+	stream
+}
+
+// NewChaincodeStream returns an open ChaincodeStream
+func NewChaincodeStream() *ChaincodeStream {
+	return &ChaincodeStream{newStream()}
+}
+
+// Send queues msg on Sent
+func (s *ChaincodeStream) Send(msg *pb.ChaincodeMessage) error {
+	return s.send(msg)
+}
+
+// Recv returns the next message delivered
+func (s *ChaincodeStream) Recv() (*pb.ChaincodeMessage, error) {
+	msg, err := s.receive()
+	if err != nil {
+		return nil, err
+	}
+	return msg.(*pb.ChaincodeMessage), nil
+}
+
+// ChatStream is a fake peer chat stream
+type ChatStream struct {
+	stream
+}
+
+// NewChatStream returns an open ChatStream
+func NewChatStream() *ChatStream {
+	return &ChatStream{newStream()}
+}
+
+// Send queues msg on Sent
+func (s *ChatStream) Send(msg *pb.Message) error {
+	return s.send(msg)
+}
+
+// Recv returns the next message delivered
+func (s *ChatStream) Recv() (*pb.Message, error) {
+	msg, err := s.receive()
+	if err != nil {
+		return nil, err
+	}
+	return msg.(*pb.Message), nil
+}
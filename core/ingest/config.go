@@ -0,0 +1,114 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package ingest
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/viper"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// Start creates an ingester from the configuration, submitting transactions
+// with submit, and starts it. It does nothing unless ingest.enabled is set.
+func Start(submit func(*pb.Transaction) *pb.Response) (*Ingester, error) {
+	if !viper.GetBool("ingest.enabled") {
+		return nil, nil
+	}
+	config, err := getMQTTConfig()
+	if err != nil {
+		return nil, err
+	}
+	open := func() (Source, error) {
+		return DialMQTT(config)
+	}
+	deadLetterPath := filepath.Join(viper.GetString("peer.fileSystemPath"), "ingest", "deadletter")
+	in := NewIngester(open, submit, viper.GetDuration("ingest.retryInterval"), viper.GetInt("ingest.maxAttempts"), fileDeadLetter(deadLetterPath))
+	in.Start()
+	logger.Info("Ingesting transactions published on %s at MQTT broker %s", config.Topic, config.Address)
+	return in, nil
+}
+
+// getMQTTConfig reads and validates the broker configured under ingest.mqtt
+func getMQTTConfig() (*MQTTConfig, error) {
+	config := &MQTTConfig{
+		Address:   viper.GetString("ingest.mqtt.address"),
+		Topic:     viper.GetString("ingest.mqtt.topic"),
+		ClientID:  viper.GetString("ingest.mqtt.clientID"),
+		Username:  viper.GetString("ingest.mqtt.username"),
+		Password:  viper.GetString("ingest.mqtt.password"),
+		KeepAlive: viper.GetDuration("ingest.mqtt.keepalive"),
+	}
+	if config.Address == "" || config.Topic == "" || config.ClientID == "" {
+		return nil, fmt.Errorf("ingest requires an MQTT broker address, topic and clientID")
+	}
+	if viper.GetBool("ingest.mqtt.tls.enabled") {
+		config.TLS = &tls.Config{}
+		if file := viper.GetString("ingest.mqtt.tls.rootcert.file"); file != "" {
+			pem, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("Error reading MQTT broker root certificate: %s", err)
+			}
+			config.TLS.RootCAs = x509.NewCertPool()
+			if !config.TLS.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("No certificate found in %s", file)
+			}
+		}
+	}
+	return config, nil
+}
+
+// deadLetterRecord is a transaction envelope given up on, as kept on disk
+type deadLetterRecord struct {
+	Envelope []byte    `json:"envelope"`
+	Reason   string    `json:"reason"`
+	Time     time.Time `json:"time"`
+}
+
+// fileDeadLetter returns a dead letter keeping each envelope in a file of
+// dir named after its hash
+func fileDeadLetter(dir string) DeadLetter {
+	return func(payload []byte, reason string) error {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		data, err := json.Marshal(&deadLetterRecord{Envelope: payload, Reason: reason, Time: time.Now()})
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(payload)
+		path := filepath.Join(dir, hex.EncodeToString(hash[:])+".json")
+		tmp := path + ".tmp"
+		if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+			return err
+		}
+		return os.Rename(tmp, path)
+	}
+}
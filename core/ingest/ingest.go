@@ -0,0 +1,198 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package ingest submits the transactions consumed from a message queue to
+// the network, for clients such as fleets of devices that publish to a
+// broker rather than hold a gRPC connection to a peer.
+package ingest
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/op/go-logging"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+var logger = logging.MustGetLogger("ingest")
+
+// Message is a transaction envelope, a marshaled Transaction, consumed from
+// a queue
+type Message struct {
+	Payload []byte
+	// identifier the queue acknowledges the message with
+	id uint16
+}
+
+// Source is a queue the transaction envelopes are consumed from. A message
+// is delivered again, after the source is reopened, until it is acknowledged
+type Source interface {
+	// Receive blocks until the next message, it fails once the connection
+	// to the queue is lost
+	Receive() (*Message, error)
+	// Ack acknowledges msg, the queue will not deliver it again
+	Ack(msg *Message) error
+	Close() error
+}
+
+// DeadLetter keeps the envelope of a transaction given up on, with the reason
+type DeadLetter func(payload []byte, reason string) error
+
+// Ingester consumes the transaction envelopes of a source and submits them
+// to the network. A message is acknowledged only once its transaction was
+// accepted, or if it can never be: malformed envelopes, queries and expired
+// transactions are dropped. When a transaction is rejected the source is
+// reopened after a delay so that the queue delivers it again, up to
+// maxAttempts submissions after which it is handed to the dead letter and
+// acknowledged.
+type Ingester struct {
+	open          func() (Source, error)
+	submit        func(*pb.Transaction) *pb.Response
+	retryInterval time.Duration
+	maxAttempts   int
+	deadLetter    DeadLetter
+	// rejections of the envelopes being retried, by hash of the envelope
+	rejections map[[sha256.Size]byte]int
+	stop       chan struct{}
+	stopped    sync.WaitGroup
+	lock       sync.Mutex
+	source     Source
+}
+
+// NewIngester returns an ingester consuming the sources returned by open
+// and submitting the transactions with submit. A transaction rejected
+// maxAttempts times is handed to deadLetter, 0 retries it forever.
+func NewIngester(open func() (Source, error), submit func(*pb.Transaction) *pb.Response, retryInterval time.Duration, maxAttempts int, deadLetter DeadLetter) *Ingester {
+	return &Ingester{open: open, submit: submit, retryInterval: retryInterval, maxAttempts: maxAttempts, deadLetter: deadLetter, rejections: make(map[[sha256.Size]byte]int), stop: make(chan struct{})}
+}
+
+// Start consumes the source in the background until Stop is called
+func (in *Ingester) Start() {
+	in.stopped.Add(1)
+	go func() {
+		defer in.stopped.Done()
+		for {
+			if err := in.consume(); err != nil {
+				logger.Warning("Ingesting transactions interrupted, retrying in %s: %s", in.retryInterval, err)
+			}
+			select {
+			case <-in.stop:
+				return
+			case <-time.After(in.retryInterval):
+			}
+		}
+	}()
+}
+
+// Stop closes the source and waits for the ingester to stop
+func (in *Ingester) Stop() {
+	close(in.stop)
+	in.lock.Lock()
+	if in.source != nil {
+		in.source.Close()
+	}
+	in.lock.Unlock()
+	in.stopped.Wait()
+}
+
+// consume opens the source and submits its messages until a transaction is
+// rejected or the source fails
+func (in *Ingester) consume() error {
+	source, err := in.open()
+	if err != nil {
+		return err
+	}
+	in.lock.Lock()
+	select {
+	case <-in.stop:
+		in.lock.Unlock()
+		source.Close()
+		return nil
+	default:
+	}
+	in.source = source
+	in.lock.Unlock()
+	defer func() {
+		in.lock.Lock()
+		in.source = nil
+		in.lock.Unlock()
+		source.Close()
+	}()
+
+	for {
+		msg, err := source.Receive()
+		if err != nil {
+			select {
+			case <-in.stop:
+				return nil
+			default:
+			}
+			return err
+		}
+		if err = in.process(msg); err != nil {
+			return err
+		}
+		if err = source.Ack(msg); err != nil {
+			return fmt.Errorf("Error acknowledging message: %s", err)
+		}
+	}
+}
+
+// process submits the transaction in msg. An error is returned if the
+// transaction was rejected and the message must not be acknowledged
+func (in *Ingester) process(msg *Message) error {
+	tx := &pb.Transaction{}
+	if err := proto.Unmarshal(msg.Payload, tx); err != nil {
+		logger.Warning("Dropping malformed transaction envelope: %s", err)
+		return nil
+	}
+	if tx.Type != pb.Transaction_CHAINCODE_DEPLOY && tx.Type != pb.Transaction_CHAINCODE_INVOKE {
+		logger.Warning("Dropping transaction %s of type %s, only deployments and invocations are ingested", tx.Uuid, tx.Type)
+		return nil
+	}
+	key := sha256.Sum256(msg.Payload)
+	resp := in.submit(tx)
+	switch resp.Status {
+	case pb.Response_SUCCESS:
+		logger.Debug("Transaction %s accepted", tx.Uuid)
+		delete(in.rejections, key)
+		return nil
+	case pb.Response_EXPIRED:
+		logger.Warning("Dropping transaction %s: %s", tx.Uuid, resp.Msg)
+		delete(in.rejections, key)
+		return nil
+	}
+	err := fmt.Errorf("Transaction %s rejected: %s", tx.Uuid, resp.Msg)
+	in.rejections[key]++
+	if in.maxAttempts <= 0 || in.rejections[key] < in.maxAttempts {
+		return err
+	}
+	if in.deadLetter != nil {
+		if dlErr := in.deadLetter(msg.Payload, err.Error()); dlErr != nil {
+			return fmt.Errorf("%s, and could not be dead-lettered: %s", err, dlErr)
+		}
+	}
+	logger.Error("Giving up on transaction %s after %d attempts: %s", tx.Uuid, in.rejections[key], resp.Msg)
+	delete(in.rejections, key)
+	return nil
+}
@@ -0,0 +1,214 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package ingest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// fakeSource delivers its messages then fails
+type fakeSource struct {
+	sync.Mutex
+	messages []*Message
+	acked    []*Message
+	closed   bool
+}
+
+func (f *fakeSource) Receive() (*Message, error) {
+	f.Lock()
+	defer f.Unlock()
+	if len(f.messages) == 0 {
+		return nil, errors.New("connection lost")
+	}
+	msg := f.messages[0]
+	f.messages = f.messages[1:]
+	return msg, nil
+}
+
+func (f *fakeSource) Ack(msg *Message) error {
+	f.Lock()
+	defer f.Unlock()
+	f.acked = append(f.acked, msg)
+	return nil
+}
+
+func (f *fakeSource) Close() error {
+	f.Lock()
+	defer f.Unlock()
+	f.closed = true
+	return nil
+}
+
+func envelope(t *testing.T, uuid string, typ pb.Transaction_Type) *Message {
+	tx := &pb.Transaction{Type: typ, Uuid: uuid}
+	payload, err := proto.Marshal(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Message{Payload: payload}
+}
+
+func TestIngesterAcksAcceptedTransactions(t *testing.T) {
+	accepted := envelope(t, "accepted", pb.Transaction_CHAINCODE_INVOKE)
+	expired := envelope(t, "expired", pb.Transaction_CHAINCODE_INVOKE)
+	query := envelope(t, "query", pb.Transaction_CHAINCODE_QUERY)
+	malformed := &Message{Payload: []byte{0xff, 0xff}}
+	rejected := envelope(t, "rejected", pb.Transaction_CHAINCODE_INVOKE)
+	source := &fakeSource{messages: []*Message{accepted, expired, query, malformed, rejected}}
+
+	var submitted []string
+	submit := func(tx *pb.Transaction) *pb.Response {
+		submitted = append(submitted, tx.Uuid)
+		switch tx.Uuid {
+		case "expired":
+			return &pb.Response{Status: pb.Response_EXPIRED}
+		case "rejected":
+			return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte("no validator")}
+		}
+		return &pb.Response{Status: pb.Response_SUCCESS}
+	}
+	in := NewIngester(func() (Source, error) { return source, nil }, submit, time.Second, 0, nil)
+	if err := in.consume(); err == nil {
+		t.Fatal("Expected the rejected transaction to interrupt ingesting")
+	}
+
+	if len(submitted) != 3 || submitted[0] != "accepted" || submitted[1] != "expired" || submitted[2] != "rejected" {
+		t.Fatalf("Expected the invocations to be submitted, got %v", submitted)
+	}
+	if len(source.acked) != 4 || source.acked[0] != accepted || source.acked[3] != malformed {
+		t.Fatalf("Expected all but the rejected transaction to be acknowledged, got %d", len(source.acked))
+	}
+	if !source.closed {
+		t.Fatal("Expected the source to be closed once interrupted")
+	}
+}
+
+func TestIngesterRetriesRejectedTransactions(t *testing.T) {
+	var lock sync.Mutex
+	opened := 0
+	var sources []*fakeSource
+	open := func() (Source, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		opened++
+		if opened == 1 {
+			return nil, errors.New("broker unreachable")
+		}
+		// the queue redelivers the unacknowledged transaction
+		source := &fakeSource{messages: []*Message{envelope(t, "tx", pb.Transaction_CHAINCODE_INVOKE)}}
+		sources = append(sources, source)
+		return source, nil
+	}
+	attempts := 0
+	submit := func(tx *pb.Transaction) *pb.Response {
+		lock.Lock()
+		defer lock.Unlock()
+		attempts++
+		if attempts == 1 {
+			return &pb.Response{Status: pb.Response_FAILURE}
+		}
+		return &pb.Response{Status: pb.Response_SUCCESS}
+	}
+	in := NewIngester(open, submit, time.Millisecond, 0, nil)
+	in.Start()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		lock.Lock()
+		done := len(sources) >= 2 && attempts >= 2
+		lock.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the transaction to be submitted again")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	in.Stop()
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(sources[0].acked) != 0 {
+		t.Fatal("Expected the rejected transaction not to be acknowledged")
+	}
+	if len(sources[1].acked) != 1 {
+		t.Fatal("Expected the transaction accepted on retry to be acknowledged")
+	}
+}
+
+func TestIngesterDeadLettersAfterMaxAttempts(t *testing.T) {
+	rejected := envelope(t, "rejected", pb.Transaction_CHAINCODE_INVOKE)
+	attempts := 0
+	submit := func(tx *pb.Transaction) *pb.Response {
+		attempts++
+		return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte("no validator")}
+	}
+	var source *fakeSource
+	open := func() (Source, error) {
+		// the queue redelivers the unacknowledged transaction
+		source = &fakeSource{messages: []*Message{rejected}}
+		return source, nil
+	}
+	dir, err := ioutil.TempDir("", "deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	in := NewIngester(open, submit, time.Millisecond, 3, fileDeadLetter(dir))
+
+	for i := 0; i < 2; i++ {
+		if err := in.consume(); err == nil || len(source.acked) != 0 {
+			t.Fatalf("Expected attempt %d to be rejected and not acknowledged", i+1)
+		}
+	}
+	if err := in.consume(); err == nil || err.Error() != "connection lost" {
+		t.Fatalf("Expected the third attempt to be given up on, got %v", err)
+	}
+	if attempts != 3 || len(source.acked) != 1 {
+		t.Fatalf("Expected 3 attempts and the last one acknowledged, got %d attempts and %d acks", attempts, len(source.acked))
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected the envelope in the dead letter, got %v (%v)", files, err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := &deadLetterRecord{}
+	if err = json.Unmarshal(data, record); err != nil || !bytes.Equal(record.Envelope, rejected.Payload) || record.Reason == "" {
+		t.Fatalf("Expected the envelope and the reason it was rejected, got %+v (%v)", record, err)
+	}
+	if len(in.rejections) != 0 {
+		t.Fatal("Expected the given up transaction to be forgotten")
+	}
+}
@@ -0,0 +1,337 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package ingest
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// MQTT 3.1.1 control packet types
+const (
+	mqttConnect    = 1
+	mqttConnack    = 2
+	mqttPublish    = 3
+	mqttPuback     = 4
+	mqttSubscribe  = 8
+	mqttSuback     = 9
+	mqttPingreq    = 12
+	mqttPingresp   = 13
+	mqttDisconnect = 14
+)
+
+// the longest packet accepted from the broker
+const mqttMaxPacketSize = 16 << 20
+
+// MQTTConfig describes the broker and topic an MQTTSource subscribes to
+type MQTTConfig struct {
+	// Address of the broker, host:port
+	Address string
+	// Topic filter the transaction envelopes are published on
+	Topic string
+	// ClientID identifies the session of the peer on the broker. Messages
+	// not yet acknowledged are redelivered when a client with the same ID
+	// reconnects, so it must be unique to the peer
+	ClientID string
+	Username string
+	Password string
+	// KeepAlive is the longest time without traffic on the connection, 0
+	// to disable keepalives
+	KeepAlive time.Duration
+	// TLS, if not nil, is used to secure the connection
+	TLS *tls.Config
+}
+
+// MQTTSource is a Source consuming the messages published on an MQTT topic
+// with QoS 1. The session it opens on the broker is persistent, so that a
+// message is redelivered until the source acknowledges it
+type MQTTSource struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	wlock    sync.Mutex
+	messages chan *Message
+	done     chan struct{}
+	closed   sync.Once
+	err      error
+}
+
+// DialMQTT connects to the broker and subscribes to the topic of config
+func DialMQTT(config *MQTTConfig) (*MQTTSource, error) {
+	if config.ClientID == "" {
+		return nil, errors.New("MQTT client ID is required for the broker to keep the session of the peer")
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	var err error
+	if config.TLS != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", config.Address, config.TLS)
+	} else {
+		conn, err = dialer.Dial("tcp", config.Address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to MQTT broker %s: %s", config.Address, err)
+	}
+	src := &MQTTSource{conn: conn, reader: bufio.NewReader(conn), messages: make(chan *Message), done: make(chan struct{})}
+	pending, err := src.handshake(config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go src.readLoop(pending)
+	if config.KeepAlive > 0 {
+		go src.keepAlive(config.KeepAlive / 2)
+	}
+	return src, nil
+}
+
+// handshake opens the session and subscribes to the topic. Messages left
+// unacknowledged by the previous session may be redelivered before the
+// subscription is acknowledged, they are returned
+func (src *MQTTSource) handshake(config *MQTTConfig) ([]*Message, error) {
+	src.conn.SetDeadline(time.Now().Add(30 * time.Second))
+	defer src.conn.SetDeadline(time.Time{})
+
+	// clean session is not set, the broker keeps the unacknowledged messages
+	var flags byte
+	payload := mqttString(config.ClientID)
+	if config.Username != "" {
+		flags |= 0x80
+		payload = append(payload, mqttString(config.Username)...)
+		if config.Password != "" {
+			flags |= 0x40
+			payload = append(payload, mqttString(config.Password)...)
+		}
+	}
+	body := append(mqttString("MQTT"), 4, flags)
+	body = append(body, mqttUint16(uint16(config.KeepAlive/time.Second))...)
+	if err := src.writePacket(mqttConnect<<4, append(body, payload...)); err != nil {
+		return nil, err
+	}
+	header, body, err := src.readPacket()
+	if err != nil {
+		return nil, fmt.Errorf("Error reading MQTT CONNACK: %s", err)
+	}
+	if header>>4 != mqttConnack || len(body) != 2 {
+		return nil, fmt.Errorf("Expected MQTT CONNACK, got packet type %d", header>>4)
+	}
+	if body[1] != 0 {
+		return nil, fmt.Errorf("MQTT broker refused the connection with return code %d", body[1])
+	}
+
+	// subscribe with QoS 1, the packet identifier is 1
+	body = append(mqttUint16(1), mqttString(config.Topic)...)
+	if err = src.writePacket(mqttSubscribe<<4|0x02, append(body, 1)); err != nil {
+		return nil, err
+	}
+	var pending []*Message
+	for {
+		header, body, err = src.readPacket()
+		if err != nil {
+			return nil, fmt.Errorf("Error reading MQTT SUBACK: %s", err)
+		}
+		if header>>4 == mqttPublish {
+			msg, err := parseMQTTPublish(header, body)
+			if err != nil {
+				return nil, err
+			}
+			pending = append(pending, msg)
+			continue
+		}
+		if header>>4 != mqttSuback || len(body) != 3 {
+			return nil, fmt.Errorf("Expected MQTT SUBACK, got packet type %d", header>>4)
+		}
+		if body[2] == 0x80 {
+			return nil, fmt.Errorf("MQTT broker refused the subscription to %s", config.Topic)
+		}
+		return pending, nil
+	}
+}
+
+func (src *MQTTSource) readLoop(pending []*Message) {
+	defer close(src.messages)
+	for _, msg := range pending {
+		select {
+		case src.messages <- msg:
+		case <-src.done:
+			return
+		}
+	}
+	for {
+		header, body, err := src.readPacket()
+		if err != nil {
+			src.fail(err)
+			return
+		}
+		switch header >> 4 {
+		case mqttPublish:
+			msg, err := parseMQTTPublish(header, body)
+			if err != nil {
+				src.fail(err)
+				return
+			}
+			select {
+			case src.messages <- msg:
+			case <-src.done:
+				return
+			}
+		case mqttPingresp:
+		default:
+			logger.Debug("Ignoring MQTT packet type %d", header>>4)
+		}
+	}
+}
+
+func (src *MQTTSource) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := src.writePacket(mqttPingreq<<4, nil); err != nil {
+				src.fail(err)
+				return
+			}
+		case <-src.done:
+			return
+		}
+	}
+}
+
+// fail records the error the connection was lost with and closes it
+func (src *MQTTSource) fail(err error) {
+	src.closed.Do(func() {
+		src.err = err
+		close(src.done)
+		src.conn.Close()
+	})
+}
+
+// Receive implements Source
+func (src *MQTTSource) Receive() (*Message, error) {
+	msg, ok := <-src.messages
+	if !ok {
+		if src.err == io.EOF {
+			return nil, errors.New("MQTT broker closed the connection")
+		}
+		return nil, src.err
+	}
+	return msg, nil
+}
+
+// Ack implements Source. Messages published with QoS 0 need no acknowledgment
+func (src *MQTTSource) Ack(msg *Message) error {
+	if msg.id == 0 {
+		return nil
+	}
+	return src.writePacket(mqttPuback<<4, mqttUint16(msg.id))
+}
+
+// Close implements Source, disconnecting from the broker
+func (src *MQTTSource) Close() error {
+	src.writePacket(mqttDisconnect<<4, nil)
+	src.fail(errors.New("MQTT source closed"))
+	return nil
+}
+
+// parseMQTTPublish reads the message in a PUBLISH packet
+func parseMQTTPublish(header byte, body []byte) (*Message, error) {
+	if len(body) < 2 {
+		return nil, errors.New("Malformed MQTT PUBLISH")
+	}
+	topicLen := int(body[0])<<8 | int(body[1])
+	body = body[2:]
+	if len(body) < topicLen {
+		return nil, errors.New("Malformed MQTT PUBLISH")
+	}
+	body = body[topicLen:]
+	msg := &Message{}
+	if qos := (header >> 1) & 0x03; qos > 0 {
+		if qos > 1 || len(body) < 2 {
+			return nil, fmt.Errorf("Unsupported MQTT PUBLISH with QoS %d", qos)
+		}
+		msg.id = uint16(body[0])<<8 | uint16(body[1])
+		body = body[2:]
+	}
+	msg.Payload = body
+	return msg, nil
+}
+
+func (src *MQTTSource) writePacket(header byte, body []byte) error {
+	packet := []byte{header}
+	length := len(body)
+	for {
+		digit := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			digit |= 0x80
+		}
+		packet = append(packet, digit)
+		if length == 0 {
+			break
+		}
+	}
+	src.wlock.Lock()
+	defer src.wlock.Unlock()
+	_, err := src.conn.Write(append(packet, body...))
+	return err
+}
+
+func (src *MQTTSource) readPacket() (byte, []byte, error) {
+	header, err := src.reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length := 0
+	for multiplier := 1; ; multiplier *= 128 {
+		digit, err := src.reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(digit&0x7f) * multiplier
+		if digit&0x80 == 0 {
+			break
+		}
+		if multiplier > 128*128 {
+			return 0, nil, errors.New("Malformed MQTT remaining length")
+		}
+	}
+	if length > mqttMaxPacketSize {
+		return 0, nil, fmt.Errorf("MQTT packet of %d bytes exceeds the limit of %d", length, mqttMaxPacketSize)
+	}
+	body := make([]byte, length)
+	if _, err = io.ReadFull(src.reader, body); err != nil {
+		return 0, nil, err
+	}
+	return header, body, nil
+}
+
+func mqttString(s string) []byte {
+	return append(mqttUint16(uint16(len(s))), s...)
+}
+
+func mqttUint16(v uint16) []byte {
+	return []byte{byte(v >> 8), byte(v)}
+}
@@ -0,0 +1,138 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeBroker accepts a single MQTT client, publishing payload with QoS 1
+// once subscribed, and reports the packets it receives
+type fakeBroker struct {
+	listener net.Listener
+	packets  chan []byte
+}
+
+func newFakeBroker(t *testing.T, payload []byte) *fakeBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{listener: l, packets: make(chan []byte, 10)}
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		src := &MQTTSource{conn: conn, reader: bufio.NewReader(conn)}
+		for {
+			header, body, err := src.readPacket()
+			if err != nil {
+				close(b.packets)
+				return
+			}
+			b.packets <- append([]byte{header}, body...)
+			switch header >> 4 {
+			case mqttConnect:
+				src.writePacket(mqttConnack<<4, []byte{0, 0})
+			case mqttSubscribe:
+				src.writePacket(mqttSuback<<4, []byte{body[0], body[1], 1})
+				publish := append(mqttString("fabric/transactions"), 0, 7)
+				src.writePacket(mqttPublish<<4|0x02, append(publish, payload...))
+			case mqttPingreq:
+				src.writePacket(mqttPingresp<<4, nil)
+			}
+		}
+	}()
+	return b
+}
+
+func (b *fakeBroker) next(t *testing.T, typ byte) []byte {
+	select {
+	case packet := <-b.packets:
+		if packet[0]>>4 != typ {
+			t.Fatalf("Expected MQTT packet type %d, got %d", typ, packet[0]>>4)
+		}
+		return packet[1:]
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for MQTT packet type %d", typ)
+	}
+	return nil
+}
+
+func TestMQTTSourceReceivesAndAcks(t *testing.T) {
+	broker := newFakeBroker(t, []byte("envelope"))
+	defer broker.listener.Close()
+
+	src, err := DialMQTT(&MQTTConfig{Address: broker.listener.Addr().String(), Topic: "fabric/transactions", ClientID: "peer0", Username: "user", Password: "secret"})
+	if err != nil {
+		t.Fatalf("Error connecting to broker: %s", err)
+	}
+	defer src.Close()
+
+	connect := broker.next(t, mqttConnect)
+	if !bytes.Contains(connect, []byte("peer0")) || connect[7]&0x02 != 0 || connect[7]&0xc0 != 0xc0 {
+		t.Fatalf("Expected a persistent session with credentials, got flags %x", connect[7])
+	}
+	subscribe := broker.next(t, mqttSubscribe)
+	if subscribe[len(subscribe)-1] != 1 {
+		t.Fatal("Expected a subscription with QoS 1")
+	}
+
+	msg, err := src.Receive()
+	if err != nil {
+		t.Fatalf("Error receiving message: %s", err)
+	}
+	if string(msg.Payload) != "envelope" || msg.id != 7 {
+		t.Fatalf("Unexpected message %q with id %d", msg.Payload, msg.id)
+	}
+	if err = src.Ack(msg); err != nil {
+		t.Fatalf("Error acknowledging message: %s", err)
+	}
+	if puback := broker.next(t, mqttPuback); !bytes.Equal(puback, []byte{0, 7}) {
+		t.Fatalf("Expected PUBACK of message 7, got %v", puback)
+	}
+}
+
+func TestMQTTSourceFailsOnceDisconnected(t *testing.T) {
+	broker := newFakeBroker(t, []byte("envelope"))
+
+	src, err := DialMQTT(&MQTTConfig{Address: broker.listener.Addr().String(), Topic: "fabric/transactions", ClientID: "peer0"})
+	if err != nil {
+		t.Fatalf("Error connecting to broker: %s", err)
+	}
+	if _, err = src.Receive(); err != nil {
+		t.Fatalf("Error receiving message: %s", err)
+	}
+	src.Close()
+	if _, err = src.Receive(); err == nil {
+		t.Fatal("Expected receiving from a closed source to fail")
+	}
+	broker.listener.Close()
+
+	if _, err = DialMQTT(&MQTTConfig{Address: broker.listener.Addr().String(), Topic: "fabric/transactions"}); err == nil {
+		t.Fatal("Expected a client ID to be required")
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package interceptor runs interceptors around the gRPC streams of the peer,
+// the Chat streams between peers and the chaincode support streams, which
+// the vendored gRPC does not intercept itself. The services pass the stream
+// they are handed through ServeStream, the clients open theirs with
+// OpenStream.
+package interceptor
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/op/go-logging"
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+var logger = logging.MustGetLogger("interceptor")
+
+// TokenKey is the metadata key of the token authenticating a stream
+const TokenKey = "fabric-stream-token"
+
+// StreamInfo describes an intercepted stream
+type StreamInfo struct {
+	// FullMethod is the gRPC method of the stream, e.g. /protos.Peer/Chat
+	FullMethod string
+}
+
+// StreamHandler serves a server stream
+type StreamHandler func(stream grpc.ServerStream) error
+
+// StreamServerInterceptor runs around the handler of a server stream, it
+// calls handler, possibly with a wrapped stream, for the stream to be served
+type StreamServerInterceptor func(stream grpc.ServerStream, info *StreamInfo, handler StreamHandler) error
+
+// Streamer opens a client stream
+type Streamer func(ctx context.Context) (grpc.ClientStream, error)
+
+// StreamClientInterceptor runs around the opening of a client stream, it
+// calls streamer, possibly with a derived context, and may wrap the stream
+// returned
+type StreamClientInterceptor func(ctx context.Context, info *StreamInfo, streamer Streamer) (grpc.ClientStream, error)
+
+// ChainServer returns the interceptor running interceptors in order, the
+// first one outermost
+func ChainServer(interceptors ...StreamServerInterceptor) StreamServerInterceptor {
+	return func(stream grpc.ServerStream, info *StreamInfo, handler StreamHandler) error {
+		next := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, inner := interceptors[i], next
+			next = func(stream grpc.ServerStream) error { return interceptor(stream, info, inner) }
+		}
+		return next(stream)
+	}
+}
+
+// ChainClient returns the interceptor running interceptors in order, the
+// first one outermost
+func ChainClient(interceptors ...StreamClientInterceptor) StreamClientInterceptor {
+	return func(ctx context.Context, info *StreamInfo, streamer Streamer) (grpc.ClientStream, error) {
+		next := streamer
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, inner := interceptors[i], next
+			next = func(ctx context.Context) (grpc.ClientStream, error) { return interceptor(ctx, info, inner) }
+		}
+		return next(ctx)
+	}
+}
+
+// ServeStream serves stream of method with handler through the server
+// interceptors configured under peer.streams: panic recovery, then
+// authentication if a token is set, then metrics if enabled
+func ServeStream(stream grpc.ServerStream, method string, handler StreamHandler) error {
+	interceptors := []StreamServerInterceptor{Recovery()}
+	if token := viper.GetString("peer.streams.token"); token != "" {
+		interceptors = append(interceptors, Authenticate(token))
+	}
+	if viper.GetBool("peer.streams.metrics") {
+		interceptors = append(interceptors, ServerMetrics())
+	}
+	return ChainServer(interceptors...)(stream, &StreamInfo{FullMethod: method}, handler)
+}
+
+// OpenStream opens a stream of method with streamer through the client
+// interceptors configured under peer.streams: the token is attached if set
+// and metrics are recorded if enabled
+func OpenStream(ctx context.Context, method string, streamer Streamer) (grpc.ClientStream, error) {
+	var interceptors []StreamClientInterceptor
+	if token := viper.GetString("peer.streams.token"); token != "" {
+		interceptors = append(interceptors, AttachToken(token))
+	}
+	if viper.GetBool("peer.streams.metrics") {
+		interceptors = append(interceptors, ClientMetrics())
+	}
+	return ChainClient(interceptors...)(ctx, &StreamInfo{FullMethod: method}, streamer)
+}
+
+// Recovery recovers from a panic of the handler, which ends the stream with
+// an Internal error instead of crashing the peer. Panics of goroutines the
+// handler started are not recovered.
+func Recovery() StreamServerInterceptor {
+	return func(stream grpc.ServerStream, info *StreamInfo, handler StreamHandler) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Error(fmt.Sprintf("Panic serving stream %s: %v\n%s", info.FullMethod, r, debug.Stack()))
+				defaultStats.panicked(info.FullMethod)
+				err = grpc.Errorf(codes.Internal, "Panic serving stream %s: %v", info.FullMethod, r)
+			}
+		}()
+		return handler(stream)
+	}
+}
+
+// Authenticate refuses the streams whose metadata does not carry token
+// under TokenKey
+func Authenticate(token string) StreamServerInterceptor {
+	return func(stream grpc.ServerStream, info *StreamInfo, handler StreamHandler) error {
+		md, _ := metadata.FromContext(stream.Context())
+		var presented string
+		if values := md[TokenKey]; len(values) > 0 {
+			presented = values[0]
+		}
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			logger.Warning("Refusing unauthenticated stream %s", info.FullMethod)
+			defaultStats.refused(info.FullMethod)
+			return grpc.Errorf(codes.Unauthenticated, "Stream %s not authenticated", info.FullMethod)
+		}
+		return handler(stream)
+	}
+}
+
+// AttachToken attaches token under TokenKey to the metadata of the streams
+// opened
+func AttachToken(token string) StreamClientInterceptor {
+	return func(ctx context.Context, info *StreamInfo, streamer Streamer) (grpc.ClientStream, error) {
+		md, ok := metadata.FromContext(ctx)
+		if ok {
+			md = md.Copy()
+		} else {
+			md = metadata.MD{}
+		}
+		md[TokenKey] = []string{token}
+		return streamer(metadata.NewContext(ctx, md))
+	}
+}
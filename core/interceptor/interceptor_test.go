@@ -0,0 +1,135 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package interceptor
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+type mockServerStream struct {
+	ctx  context.Context
+	sent []interface{}
+}
+
+func (s *mockServerStream) Context() context.Context     { return s.ctx }
+func (s *mockServerStream) SendHeader(metadata.MD) error { return nil }
+func (s *mockServerStream) SetTrailer(metadata.MD)       {}
+func (s *mockServerStream) SendMsg(m interface{}) error  { s.sent = append(s.sent, m); return nil }
+func (s *mockServerStream) RecvMsg(m interface{}) error {
+	*m.(*pb.Message) = pb.Message{Payload: []byte("abc")}
+	return nil
+}
+func (s *mockServerStream) withToken(token string) *mockServerStream {
+	s.ctx = metadata.NewContext(s.ctx, metadata.Pairs(TokenKey, token))
+	return s
+}
+
+func TestChainServerOrder(t *testing.T) {
+	var order []string
+	record := func(name string) StreamServerInterceptor {
+		return func(stream grpc.ServerStream, info *StreamInfo, handler StreamHandler) error {
+			order = append(order, name)
+			return handler(stream)
+		}
+	}
+	chain := ChainServer(record("first"), record("second"))
+	err := chain(&mockServerStream{ctx: context.Background()}, &StreamInfo{FullMethod: "/test"}, func(grpc.ServerStream) error {
+		order = append(order, "handler")
+		return nil
+	})
+	if err != nil || strings.Join(order, ",") != "first,second,handler" {
+		t.Fatalf("Expected the interceptors in order then the handler, got %v, %v", order, err)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	info := &StreamInfo{FullMethod: "/test/Recovery"}
+	err := Recovery()(&mockServerStream{ctx: context.Background()}, info, func(grpc.ServerStream) error {
+		panic("boom")
+	})
+	if grpc.Code(err) != codes.Internal || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("Expected the panic as an Internal error, got %v", err)
+	}
+	handlerErr := errors.New("handler error")
+	if err = Recovery()(&mockServerStream{ctx: context.Background()}, info, func(grpc.ServerStream) error { return handlerErr }); err != handlerErr {
+		t.Fatalf("Expected the error of the handler, got %v", err)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	info := &StreamInfo{FullMethod: "/test/Authenticate"}
+	served := 0
+	handler := func(grpc.ServerStream) error { served++; return nil }
+	for _, stream := range []*mockServerStream{
+		{ctx: context.Background()},
+		(&mockServerStream{ctx: context.Background()}).withToken("wrong"),
+	} {
+		if err := Authenticate("secret")(stream, info, handler); grpc.Code(err) != codes.Unauthenticated {
+			t.Fatalf("Expected Unauthenticated, got %v", err)
+		}
+	}
+	if served != 0 {
+		t.Fatal("Expected unauthenticated streams not to be served")
+	}
+
+	// The token attached by the client authenticates the stream
+	var stream *mockServerStream
+	AttachToken("secret")(context.Background(), info, func(ctx context.Context) (grpc.ClientStream, error) {
+		stream = &mockServerStream{ctx: ctx}
+		return nil, nil
+	})
+	if err := Authenticate("secret")(stream, info, handler); err != nil || served != 1 {
+		t.Fatalf("Expected the stream with the token to be served, got %v", err)
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	stats := &streamStats{methods: make(map[string]*MethodStats)}
+	info := &StreamInfo{FullMethod: "/test/Metrics"}
+	err := serverMetrics(stats)(&mockServerStream{ctx: context.Background()}, info, func(stream grpc.ServerStream) error {
+		if active := stats.snapshot()[0].Active; active != 1 {
+			t.Fatalf("Expected the stream to be active while served, got %d", active)
+		}
+		msg := &pb.Message{}
+		stream.RecvMsg(msg)
+		return stream.SendMsg(msg)
+	})
+	if err != nil {
+		t.Fatalf("Error serving stream: %s", err)
+	}
+	m := stats.snapshot()[0]
+	if m.Method != info.FullMethod || m.Streams != 1 || m.Active != 0 {
+		t.Fatalf("Expected one stream served, got %+v", m)
+	}
+	size := uint64(proto.Size(&pb.Message{Payload: []byte("abc")}))
+	if m.Received.Messages != 1 || m.Received.Bytes != size || m.Sent.Messages != 1 || m.Sent.Bytes != size {
+		t.Fatalf("Expected one message of %d bytes each way, got %+v", size, m)
+	}
+}
@@ -0,0 +1,203 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package interceptor
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// MessageStats aggregates the size of the messages sent or received on the
+// streams of a method, and how long sending or receiving each took
+type MessageStats struct {
+	Messages   uint64        `json:"messages"`
+	Bytes      uint64        `json:"bytes"`
+	MaxBytes   int           `json:"maxBytes"`
+	Latency    time.Duration `json:"latencyNs"`
+	MaxLatency time.Duration `json:"maxLatencyNs"`
+	Errors     uint64        `json:"errors"`
+	LastError  string        `json:"lastError,omitempty"`
+}
+
+func (s *MessageStats) record(msg interface{}, latency time.Duration, err error) {
+	if err != nil {
+		s.Errors++
+		s.LastError = err.Error()
+		return
+	}
+	size := 0
+	if m, ok := msg.(proto.Message); ok {
+		size = proto.Size(m)
+	}
+	s.Messages++
+	s.Bytes += uint64(size)
+	if size > s.MaxBytes {
+		s.MaxBytes = size
+	}
+	s.Latency += latency
+	if latency > s.MaxLatency {
+		s.MaxLatency = latency
+	}
+}
+
+// MethodStats holds the stream metrics of a gRPC method
+type MethodStats struct {
+	Method   string       `json:"method"`
+	Streams  uint64       `json:"streams"`
+	Active   int64        `json:"active"`
+	Refused  uint64       `json:"refused"`
+	Panics   uint64       `json:"panics"`
+	Sent     MessageStats `json:"sent"`
+	Received MessageStats `json:"received"`
+}
+
+type streamStats struct {
+	sync.Mutex
+	methods map[string]*MethodStats
+}
+
+var defaultStats = &streamStats{methods: make(map[string]*MethodStats)}
+
+// Stats returns the metrics of the streams intercepted so far, by method
+func Stats() []MethodStats {
+	return defaultStats.snapshot()
+}
+
+func (s *streamStats) snapshot() []MethodStats {
+	s.Lock()
+	defer s.Unlock()
+	stats := make([]MethodStats, 0, len(s.methods))
+	for _, m := range s.methods {
+		stats = append(stats, *m)
+	}
+	sort.Sort(statsByMethod(stats))
+	return stats
+}
+
+type statsByMethod []MethodStats
+
+func (s statsByMethod) Len() int           { return len(s) }
+func (s statsByMethod) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s statsByMethod) Less(i, j int) bool { return s[i].Method < s[j].Method }
+
+// update calls f with the metrics of method under the lock
+func (s *streamStats) update(method string, f func(m *MethodStats)) {
+	s.Lock()
+	defer s.Unlock()
+	m, ok := s.methods[method]
+	if !ok {
+		m = &MethodStats{Method: method}
+		s.methods[method] = m
+	}
+	f(m)
+}
+
+func (s *streamStats) panicked(method string) {
+	s.update(method, func(m *MethodStats) { m.Panics++ })
+}
+
+func (s *streamStats) refused(method string) {
+	s.update(method, func(m *MethodStats) { m.Refused++ })
+}
+
+// measuredStream records the messages sent and received on a stream
+type measuredStream struct {
+	stats  *streamStats
+	method string
+}
+
+func (ms *measuredStream) send(send func(interface{}) error, msg interface{}) error {
+	start := time.Now()
+	err := send(msg)
+	latency := time.Since(start)
+	ms.stats.update(ms.method, func(m *MethodStats) { m.Sent.record(msg, latency, err) })
+	return err
+}
+
+func (ms *measuredStream) recv(recv func(interface{}) error, msg interface{}) error {
+	start := time.Now()
+	err := recv(msg)
+	latency := time.Since(start)
+	ms.stats.update(ms.method, func(m *MethodStats) { m.Received.record(msg, latency, err) })
+	return err
+}
+
+type measuredServerStream struct {
+	grpc.ServerStream
+	measuredStream
+}
+
+func (s *measuredServerStream) SendMsg(msg interface{}) error {
+	return s.send(s.ServerStream.SendMsg, msg)
+}
+
+func (s *measuredServerStream) RecvMsg(msg interface{}) error {
+	return s.recv(s.ServerStream.RecvMsg, msg)
+}
+
+type measuredClientStream struct {
+	grpc.ClientStream
+	measuredStream
+}
+
+func (s *measuredClientStream) SendMsg(msg interface{}) error {
+	return s.send(s.ClientStream.SendMsg, msg)
+}
+
+func (s *measuredClientStream) RecvMsg(msg interface{}) error {
+	return s.recv(s.ClientStream.RecvMsg, msg)
+}
+
+// ServerMetrics records the streams served and the size and latency of
+// each message sent and received on them, reported by Stats
+func ServerMetrics() StreamServerInterceptor {
+	return serverMetrics(defaultStats)
+}
+
+func serverMetrics(stats *streamStats) StreamServerInterceptor {
+	return func(stream grpc.ServerStream, info *StreamInfo, handler StreamHandler) error {
+		stats.update(info.FullMethod, func(m *MethodStats) { m.Streams++; m.Active++ })
+		defer stats.update(info.FullMethod, func(m *MethodStats) { m.Active-- })
+		return handler(&measuredServerStream{ServerStream: stream, measuredStream: measuredStream{stats: stats, method: info.FullMethod}})
+	}
+}
+
+// ClientMetrics records the streams opened and the size and latency of each
+// message sent and received on them, reported by Stats. Client streams are
+// not counted as active, nothing tells when the client is done with them.
+func ClientMetrics() StreamClientInterceptor {
+	return clientMetrics(defaultStats)
+}
+
+func clientMetrics(stats *streamStats) StreamClientInterceptor {
+	return func(ctx context.Context, info *StreamInfo, streamer Streamer) (grpc.ClientStream, error) {
+		stream, err := streamer(ctx)
+		if err != nil {
+			return nil, err
+		}
+		stats.update(info.FullMethod, func(m *MethodStats) { m.Streams++ })
+		return &measuredClientStream{ClientStream: stream, measuredStream: measuredStream{stats: stats, method: info.FullMethod}}, nil
+	}
+}
@@ -0,0 +1,177 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package genesis
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/core/util"
+	"github.com/hyperledger/fabric/protos"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// ConfigNamespace is the state namespace under which the genesis
+	// configuration is committed in block 0.
+	ConfigNamespace = "genesis"
+	configKey       = "config"
+	configTxUUID    = "genesis-config"
+)
+
+// configFile mirrors the layout of the genesis configuration file
+type configFile struct {
+	NetworkID        string            `yaml:"networkID"`
+	Validators       []string          `yaml:"validators"`
+	Policies         map[string]string `yaml:"policies"`
+	Capabilities     []string          `yaml:"capabilities"`
+	SystemChaincodes []string          `yaml:"systemChaincodes"`
+}
+
+// LoadConfig reads the genesis configuration from the given YAML file. The
+// lists of the returned configuration are sorted so that every peer loading
+// the same file produces the same bytes, regardless of the order entries
+// were written in.
+func LoadConfig(path string) (*protos.GenesisConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading genesis configuration %s: %s", path, err)
+	}
+	var file configFile
+	if err = yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("Error parsing genesis configuration %s: %s", path, err)
+	}
+	if file.NetworkID == "" {
+		return nil, fmt.Errorf("Genesis configuration %s does not define a networkID", path)
+	}
+
+	config := &protos.GenesisConfig{NetworkID: file.NetworkID}
+	if config.Validators, err = sortedUnique("validator", file.Validators); err != nil {
+		return nil, err
+	}
+	if config.Capabilities, err = sortedUnique("capability", file.Capabilities); err != nil {
+		return nil, err
+	}
+	if config.SystemChaincodes, err = sortedUnique("system chaincode", file.SystemChaincodes); err != nil {
+		return nil, err
+	}
+	for name, rule := range file.Policies {
+		config.Policies = append(config.Policies, &protos.GenesisPolicy{Name: name, Rule: rule})
+	}
+	sort.Sort(policiesByName(config.Policies))
+	return config, nil
+}
+
+// GetConfiguredConfig loads the genesis configuration file named by
+// ledger.blockchain.genesisConfig in core.yaml. It returns nil if no file
+// is configured.
+func GetConfiguredConfig() (*protos.GenesisConfig, error) {
+	path := viper.GetString("ledger.blockchain.genesisConfig")
+	if path == "" {
+		return nil, nil
+	}
+	return LoadConfig(path)
+}
+
+// ConfigHash returns the hash identifying the given genesis configuration.
+// Peers may compare it out of band to confirm they share a configuration.
+func ConfigHash(config *protos.GenesisConfig) ([]byte, error) {
+	data, err := proto.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	return util.ComputeCryptoHash(data), nil
+}
+
+// GetConfig returns the genesis configuration committed in block 0 of the
+// given ledger, or nil if the ledger was created without one.
+func GetConfig(l *ledger.Ledger) (*protos.GenesisConfig, error) {
+	data, err := l.GetState(ConfigNamespace, configKey, true)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	config := &protos.GenesisConfig{}
+	if err = proto.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("Error unmarshalling committed genesis configuration: %s", err)
+	}
+	return config, nil
+}
+
+// writeConfig adds the genesis configuration to the state of the block
+// being built, which must be block 0, so that the state hash of the genesis
+// block covers it.
+func writeConfig(l *ledger.Ledger, config *protos.GenesisConfig) error {
+	data, err := proto.Marshal(config)
+	if err != nil {
+		return err
+	}
+	l.TxBegin(configTxUUID)
+	if err = l.SetState(ConfigNamespace, configKey, data); err != nil {
+		l.TxFinished(configTxUUID, false)
+		return err
+	}
+	l.TxFinished(configTxUUID, true)
+	return nil
+}
+
+// verifyConfig checks that the genesis configuration committed in block 0
+// matches the one this peer is configured with.
+func verifyConfig(l *ledger.Ledger, config *protos.GenesisConfig) error {
+	committed, err := l.GetState(ConfigNamespace, configKey, true)
+	if err != nil {
+		return err
+	}
+	if committed == nil {
+		return fmt.Errorf("The ledger was created without a genesis configuration, but network %s is configured", config.NetworkID)
+	}
+	expected, err := proto.Marshal(config)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(committed, expected) {
+		return fmt.Errorf("The genesis configuration differs from the one committed in block 0, refusing to start with a drifted configuration")
+	}
+	return nil
+}
+
+func sortedUnique(kind string, values []string) ([]string, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+	sorted := append([]string(nil), values...)
+	sort.Strings(sorted)
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i] == sorted[i-1] {
+			return nil, fmt.Errorf("Duplicate %s %s in genesis configuration", kind, sorted[i])
+		}
+	}
+	return sorted, nil
+}
+
+type policiesByName []*protos.GenesisPolicy
+
+func (p policiesByName) Len() int           { return len(p) }
+func (p policiesByName) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p policiesByName) Less(i, j int) bool { return p[i].Name < p[j].Name }
@@ -0,0 +1,124 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package genesis
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/ledger"
+	"github.com/hyperledger/fabric/protos"
+)
+
+func writeTempConfig(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "genesis")
+	if err != nil {
+		t.Fatalf("Error creating config file: %s", err)
+	}
+	defer f.Close()
+	if _, err = f.WriteString(content); err != nil {
+		t.Fatalf("Error writing config file: %s", err)
+	}
+	return f.Name()
+}
+
+func TestLoadConfigIsOrderIndependent(t *testing.T) {
+	first := writeTempConfig(t, `
+networkID: test
+validators: [vp1, vp0]
+policies:
+  invoke: any
+  deploy: validators
+capabilities: [b, a]
+systemChaincodes: [sc1]
+`)
+	defer os.Remove(first)
+	second := writeTempConfig(t, `
+networkID: test
+validators: [vp0, vp1]
+policies:
+  deploy: validators
+  invoke: any
+capabilities: [a, b]
+systemChaincodes: [sc1]
+`)
+	defer os.Remove(second)
+
+	firstConfig, err := LoadConfig(first)
+	if err != nil {
+		t.Fatalf("Error loading config: %s", err)
+	}
+	secondConfig, err := LoadConfig(second)
+	if err != nil {
+		t.Fatalf("Error loading config: %s", err)
+	}
+	if firstConfig.Validators[0] != "vp0" || firstConfig.Policies[0].Name != "deploy" {
+		t.Fatalf("Expected sorted configuration, got %s", firstConfig)
+	}
+
+	firstHash, _ := ConfigHash(firstConfig)
+	secondHash, _ := ConfigHash(secondConfig)
+	if !bytes.Equal(firstHash, secondHash) {
+		t.Fatalf("Expected equal hashes, got %x and %x", firstHash, secondHash)
+	}
+}
+
+func TestLoadConfigRejectsInvalidConfig(t *testing.T) {
+	for _, content := range []string{
+		"validators: [vp0]\n",
+		"networkID: test\nvalidators: [vp0, vp0]\n",
+	} {
+		path := writeTempConfig(t, content)
+		defer os.Remove(path)
+		if _, err := LoadConfig(path); err == nil {
+			t.Fatalf("Expected an error loading %q", content)
+		}
+	}
+}
+
+func TestConfigCommittedToGenesisBlock(t *testing.T) {
+	l := ledger.InitTestLedger(t)
+	config := &protos.GenesisConfig{NetworkID: "test", Validators: []string{"vp0", "vp1"}}
+
+	l.BeginTxBatch(0)
+	if err := writeConfig(l, config); err != nil {
+		t.Fatalf("Error writing config: %s", err)
+	}
+	if err := l.CommitTxBatch(0, nil, nil, nil); err != nil {
+		t.Fatalf("Error committing genesis block: %s", err)
+	}
+
+	committed, err := GetConfig(l)
+	if err != nil {
+		t.Fatalf("Error reading committed config: %s", err)
+	}
+	if committed.NetworkID != "test" || len(committed.Validators) != 2 {
+		t.Fatalf("Unexpected committed config %s", committed)
+	}
+	if err = verifyConfig(l, config); err != nil {
+		t.Fatalf("Expected the same config to verify, got %s", err)
+	}
+	drifted := &protos.GenesisConfig{NetworkID: "test", Validators: []string{"vp0"}}
+	if err = verifyConfig(l, drifted); err == nil {
+		t.Fatalf("Expected a drifted config to fail verification")
+	}
+}
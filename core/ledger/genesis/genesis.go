@@ -49,14 +49,36 @@ func MakeGenesis() error {
 			return
 		}
 
+		config, err := GetConfiguredConfig()
+		if err != nil {
+			makeGenesisError = err
+			return
+		}
+
 		if ledger.GetBlockchainSize() > 0 {
-			// genesis block already exists
+			// genesis block already exists, make sure it was created from
+			// the same configuration this peer is started with
+			if config != nil {
+				makeGenesisError = verifyConfig(ledger, config)
+			}
 			return
 		}
 
 		genesisLogger.Info("Creating genesis block.")
 
 		ledger.BeginTxBatch(0)
+		if config != nil {
+			configHash, err := ConfigHash(config)
+			if err != nil {
+				makeGenesisError = err
+				return
+			}
+			genesisLogger.Info("Committing genesis configuration of network %s with hash %x", config.NetworkID, configHash)
+			if err = writeConfig(ledger, config); err != nil {
+				makeGenesisError = err
+				return
+			}
+		}
 		var genesisTransactions []*protos.Transaction
 		
 		//We are disabling the validity period deployment for now, we shouldn't even allow it if it's enabled in the configuration
@@ -0,0 +1,146 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package ledger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/db"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	"github.com/hyperledger/fabric/core/ledger/statemgmt/state"
+	"github.com/hyperledger/fabric/protos"
+	"github.com/spf13/viper"
+	"github.com/tecbot/gorocksdb"
+)
+
+// The history of a key is kept in the indexes column family, one entry per
+// tx that set or deleted the key, under the key prefix followed by the block
+// number and the position of the tx among those of the block that changed
+// the state, so that the entries of a key are ordered from the oldest
+var prefixHistoryKey = byte(4)
+
+// historyEnabled tells whether the ledger records the history of the keys
+// changed by the transactions it commits
+func historyEnabled() bool {
+	return viper.GetBool("ledger.history.enabled")
+}
+
+func encodeHistoryKeyPrefix(chaincodeID string, key string) []byte {
+	b := proto.NewBuffer([]byte{prefixHistoryKey})
+	b.EncodeRawBytes([]byte(chaincodeID))
+	b.EncodeRawBytes([]byte(key))
+	return b.Bytes()
+}
+
+func encodeHistoryKey(chaincodeID string, key string, blockNumber uint64, txIndex uint64) []byte {
+	historyKey := encodeHistoryKeyPrefix(chaincodeID, key)
+	suffix := make([]byte, 16)
+	binary.BigEndian.PutUint64(suffix, blockNumber)
+	binary.BigEndian.PutUint64(suffix[8:], txIndex)
+	return append(historyKey, suffix...)
+}
+
+// addHistoryForPersistence adds to writeBatch an entry for every key changed
+// by the txs of the batch committed as block blockNumber
+func addHistoryForPersistence(openchainDB *db.OpenchainDB, blockNumber uint64, txStateDeltas []*state.TxStateDelta,
+	transactions []*protos.Transaction, writeBatch *gorocksdb.WriteBatch) error {
+	txs := make(map[string]*protos.Transaction)
+	for _, tx := range transactions {
+		txs[tx.Uuid] = tx
+	}
+	cf := openchainDB.IndexesCF
+	for txIndex, txStateDelta := range txStateDeltas {
+		tx := txs[txStateDelta.TxUUID]
+		for _, chaincodeID := range txStateDelta.Delta.GetUpdatedChaincodeIds(false) {
+			for key, updatedValue := range txStateDelta.Delta.GetUpdates(chaincodeID) {
+				modification := &protos.KeyModification{
+					TxID:      txStateDelta.TxUUID,
+					Timestamp: tx.GetTimestamp(),
+					Value:     updatedValue.GetValue(),
+					IsDelete:  updatedValue.IsDelete(),
+				}
+				modificationBytes, err := proto.Marshal(modification)
+				if err != nil {
+					return fmt.Errorf("Could not marshal history of key [%s] of chaincode [%s]: %s", key, chaincodeID, err)
+				}
+				writeBatch.PutCF(cf, encodeHistoryKey(chaincodeID, key, blockNumber, uint64(txIndex)), modificationBytes)
+			}
+		}
+	}
+	return nil
+}
+
+// HistoryIterator iterates over the values taken by a key, from the oldest.
+// It implements 'statemgmt.RangeScanIterator', the value of each key-value
+// being a marshaled protos.KeyModification
+type HistoryIterator struct {
+	dbItr        *gorocksdb.Iterator
+	prefix       []byte
+	key          string
+	currentValue []byte
+	started      bool
+	done         bool
+}
+
+func newHistoryIterator(openchainDB *db.OpenchainDB, chaincodeID string, key string) *HistoryIterator {
+	prefix := encodeHistoryKeyPrefix(chaincodeID, key)
+	dbItr := openchainDB.GetIndexesCFIterator()
+	dbItr.Seek(prefix)
+	return &HistoryIterator{dbItr: dbItr, prefix: prefix, key: key}
+}
+
+// Next - see interface 'statemgmt.RangeScanIterator' for details
+func (itr *HistoryIterator) Next() bool {
+	if itr.done {
+		return false
+	}
+	if itr.started {
+		itr.dbItr.Next()
+	}
+	itr.started = true
+	if !itr.dbItr.Valid() || !bytes.HasPrefix(itr.dbItr.Key().Data(), itr.prefix) {
+		itr.done = true
+		return false
+	}
+	itr.currentValue = statemgmt.Copy(itr.dbItr.Value().Data())
+	return true
+}
+
+// GetKeyValue - see interface 'statemgmt.RangeScanIterator' for details
+func (itr *HistoryIterator) GetKeyValue() (string, []byte) {
+	return itr.key, itr.currentValue
+}
+
+// GetKeyModification returns the value of the key the iterator is at
+func (itr *HistoryIterator) GetKeyModification() (*protos.KeyModification, error) {
+	modification := &protos.KeyModification{}
+	if err := proto.Unmarshal(itr.currentValue, modification); err != nil {
+		return nil, err
+	}
+	return modification, nil
+}
+
+// Close - see interface 'statemgmt.RangeScanIterator' for details
+func (itr *HistoryIterator) Close() {
+	itr.dbItr.Close()
+}
@@ -0,0 +1,106 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package ledger
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/core/ledger/testutil"
+	"github.com/hyperledger/fabric/protos"
+	"github.com/spf13/viper"
+)
+
+func readHistory(t *testing.T, ledger *Ledger, chaincodeID string, key string) []*protos.KeyModification {
+	itr, err := ledger.GetHistoryForKey(chaincodeID, key)
+	testutil.AssertNoError(t, err, "Error while getting history")
+	defer itr.Close()
+	var history []*protos.KeyModification
+	for itr.Next() {
+		k, _ := itr.GetKeyValue()
+		testutil.AssertEquals(t, k, key)
+		modification, err := itr.GetKeyModification()
+		testutil.AssertNoError(t, err, "Error while reading history")
+		history = append(history, modification)
+	}
+	return history
+}
+
+func TestLedgerHistoryForKey(t *testing.T) {
+	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
+	ledger := ledgerTestWrapper.ledger
+
+	tx1, uuid1 := buildTestTx(t)
+	tx2, uuid2 := buildTestTx(t)
+	ledger.BeginTxBatch(1)
+	ledger.TxBegin(uuid1)
+	ledger.SetState("chaincode1", "key1", []byte("value1"))
+	ledger.SetState("chaincode1", "key11", []byte("value11"))
+	ledger.TxFinished(uuid1, true)
+	ledger.TxBegin(uuid2)
+	ledger.SetState("chaincode1", "key1", []byte("value2"))
+	ledger.SetState("chaincode2", "key1", []byte("other"))
+	ledger.TxFinished(uuid2, true)
+	ledger.TxBegin("failed")
+	ledger.SetState("chaincode1", "key1", []byte("discarded"))
+	ledger.TxFinished("failed", false)
+	testutil.AssertNoError(t, ledger.CommitTxBatch(1, []*protos.Transaction{tx1, tx2}, nil, nil), "Error while committing")
+
+	tx3, uuid3 := buildTestTx(t)
+	ledger.BeginTxBatch(2)
+	ledger.TxBegin(uuid3)
+	ledger.DeleteState("chaincode1", "key1")
+	ledger.TxFinished(uuid3, true)
+	testutil.AssertNoError(t, ledger.CommitTxBatch(2, []*protos.Transaction{tx3}, nil, nil), "Error while committing")
+
+	history := readHistory(t, ledger, "chaincode1", "key1")
+	testutil.AssertEquals(t, len(history), 3)
+	testutil.AssertEquals(t, history[0].TxID, uuid1)
+	testutil.AssertEquals(t, history[0].Value, []byte("value1"))
+	testutil.AssertEquals(t, history[0].Timestamp, tx1.Timestamp)
+	testutil.AssertEquals(t, history[1].TxID, uuid2)
+	testutil.AssertEquals(t, history[1].Value, []byte("value2"))
+	testutil.AssertEquals(t, history[1].IsDelete, false)
+	testutil.AssertEquals(t, history[2].TxID, uuid3)
+	testutil.AssertEquals(t, history[2].IsDelete, true)
+
+	testutil.AssertEquals(t, len(readHistory(t, ledger, "chaincode1", "key11")), 1)
+	testutil.AssertEquals(t, len(readHistory(t, ledger, "chaincode2", "key1")), 1)
+	testutil.AssertEquals(t, len(readHistory(t, ledger, "chaincode1", "missing")), 0)
+}
+
+func TestLedgerHistoryDisabled(t *testing.T) {
+	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
+	ledger := ledgerTestWrapper.ledger
+	viper.Set("ledger.history.enabled", false)
+	defer viper.Set("ledger.history.enabled", true)
+
+	tx, uuid := buildTestTx(t)
+	ledger.BeginTxBatch(1)
+	ledger.TxBegin(uuid)
+	ledger.SetState("chaincode1", "key1", []byte("value1"))
+	ledger.TxFinished(uuid, true)
+	testutil.AssertNoError(t, ledger.CommitTxBatch(1, []*protos.Transaction{tx}, nil, nil), "Error while committing")
+
+	_, err := ledger.GetHistoryForKey("chaincode1", "key1")
+	testutil.AssertEquals(t, err, ErrHistoryDisabled)
+
+	viper.Set("ledger.history.enabled", true)
+	testutil.AssertEquals(t, len(readHistory(t, ledger, "chaincode1", "key1")), 0)
+}
@@ -24,12 +24,15 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"strconv"
 	"sync"
+	"sync/atomic"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/db"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt/state"
+	"github.com/hyperledger/fabric/core/opevents"
 	"github.com/hyperledger/fabric/events/producer"
 	"github.com/op/go-logging"
 	"github.com/tecbot/gorocksdb"
@@ -46,13 +49,20 @@ var (
 
 	// ErrResourceNotFound is returned if a resource is not found
 	ErrResourceNotFound = errors.New("ledger: resource not found")
+
+	// ErrHistoryDisabled is returned if the history of a key is requested
+	// while ledger.history.enabled is not set
+	ErrHistoryDisabled = errors.New("ledger: history of keys is not recorded")
 )
 
 // Ledger - the struct for openchain ledger
 type Ledger struct {
+	db         *db.OpenchainDB
 	blockchain *blockchain
 	state      *state.State
 	currentID  interface{}
+	generation uint64
+	changes    stateChangeLog
 }
 
 var ledger *Ledger
@@ -62,19 +72,19 @@ var once sync.Once
 // GetLedger - gives a reference to a 'singleton' ledger
 func GetLedger() (*Ledger, error) {
 	once.Do(func() {
-		ledger, ledgerError = newLedger()
+		ledger, ledgerError = newLedger(db.GetDBHandle())
 	})
 	return ledger, ledgerError
 }
 
-func newLedger() (*Ledger, error) {
-	blockchain, err := newBlockchain()
+func newLedger(openchainDB *db.OpenchainDB) (*Ledger, error) {
+	blockchain, err := newBlockchain(openchainDB)
 	if err != nil {
 		return nil, err
 	}
 
-	state := state.NewState()
-	return &Ledger{blockchain, state, nil}, nil
+	state := state.NewState(openchainDB)
+	return &Ledger{db: openchainDB, blockchain: blockchain, state: state}, nil
 }
 
 /////////////////// Transaction-batch related methods ///////////////////////////////
@@ -126,6 +136,8 @@ func (ledger *Ledger) CommitTxBatch(id interface{}, transactions []*protos.Trans
 
 	writeBatch := gorocksdb.NewWriteBatch()
 	defer writeBatch.Destroy()
+	// Transient data is only for the chaincode, it is never stored
+	protos.StripTransient(transactions)
 	block := protos.NewBlock(transactions, metadata)
 	block.NonHashData = &protos.NonHashData{TransactionResults: transactionResults}
 	newBlockNumber, err := ledger.blockchain.addPersistenceChangesForNewBlock(context.TODO(), block, stateHash, writeBatch)
@@ -134,10 +146,16 @@ func (ledger *Ledger) CommitTxBatch(id interface{}, transactions []*protos.Trans
 		ledger.blockchain.blockPersistenceStatus(false)
 		return err
 	}
+	if historyEnabled() {
+		err = addHistoryForPersistence(ledger.db, newBlockNumber, ledger.state.GetTxStateDeltas(), transactions, writeBatch)
+		if err != nil {
+			ledger.resetForNextTxGroup(false)
+			ledger.blockchain.blockPersistenceStatus(false)
+			return err
+		}
+	}
 	ledger.state.AddChangesForPersistence(newBlockNumber, writeBatch)
-	opt := gorocksdb.NewDefaultWriteOptions()
-	defer opt.Destroy()
-	dbErr := db.GetDBHandle().DB.Write(opt, writeBatch)
+	dbErr := ledger.db.Commit(writeBatch)
 	if dbErr != nil {
 		ledger.resetForNextTxGroup(false)
 		ledger.blockchain.blockPersistenceStatus(false)
@@ -148,6 +166,10 @@ func (ledger *Ledger) CommitTxBatch(id interface{}, transactions []*protos.Trans
 	ledger.blockchain.blockPersistenceStatus(true)
 
 	sendProducerBlockEvent(block)
+	opevents.Publish(opevents.BlockCommitted, map[string]string{
+		"block":        strconv.FormatUint(newBlockNumber, 10),
+		"transactions": strconv.Itoa(len(transactions)),
+	})
 	return nil
 }
 
@@ -171,7 +193,11 @@ func (ledger *Ledger) TxBegin(txUUID string) {
 // TxFinished - Marks the finish of the on-going transaction.
 // If txSuccessful is false, the state changes made by the transaction are discarded
 func (ledger *Ledger) TxFinished(txUUID string, txSuccessful bool) {
+	discarded := ledger.state.GetCurrentTxStateDelta()
 	ledger.state.TxFinish(txUUID, txSuccessful)
+	if !txSuccessful {
+		ledger.nextStateGeneration(discarded)
+	}
 }
 
 /////////////////// world-state related methods /////////////////////////////////////
@@ -206,6 +232,17 @@ func (ledger *Ledger) GetStateRangeScanIterator(chaincodeID string, startKey str
 	return ledger.state.GetRangeScanIterator(chaincodeID, startKey, endKey, committed)
 }
 
+// GetHistoryForKey returns an iterator over the values taken by key of
+// chaincodeID in the committed blocks, from the oldest. Only the blocks
+// committed by this ledger while ledger.history.enabled is set are covered,
+// not those received by state transfer
+func (ledger *Ledger) GetHistoryForKey(chaincodeID string, key string) (*HistoryIterator, error) {
+	if !historyEnabled() {
+		return nil, ErrHistoryDisabled
+	}
+	return newHistoryIterator(ledger.db, chaincodeID, key), nil
+}
+
 // SetState sets state to given value for chaincodeID and key. Does not immideatly writes to DB
 func (ledger *Ledger) SetState(chaincodeID string, key string, value []byte) error {
 	return ledger.state.Set(chaincodeID, key, value)
@@ -220,8 +257,8 @@ func (ledger *Ledger) DeleteState(chaincodeID string, key string) error {
 // should be used when transfering the state from one peer to another peer. You must call
 // stateSnapshot.Release() once you are done with the snapsnot to free up resources.
 func (ledger *Ledger) GetStateSnapshot() (*state.StateSnapshot, error) {
-	dbSnapshot := db.GetDBHandle().GetSnapshot()
-	blockHeight, err := fetchBlockchainSizeFromSnapshot(dbSnapshot)
+	dbSnapshot := ledger.db.GetSnapshot()
+	blockHeight, err := fetchBlockchainSizeFromSnapshot(ledger.db, dbSnapshot)
 	if err != nil {
 		dbSnapshot.Release()
 		return nil, err
@@ -267,6 +304,7 @@ func (ledger *Ledger) ApplyStateDelta(id interface{}, delta *statemgmt.StateDelt
 	}
 	ledger.currentID = id
 	ledger.state.ApplyStateDelta(delta)
+	ledger.nextStateGeneration(delta)
 	return nil
 }
 
@@ -296,9 +334,31 @@ func (ledger *Ledger) RollbackStateDelta(id interface{}) error {
 // This is generally only used during state synchronization when creating a
 // new state from a snapshot.
 func (ledger *Ledger) DeleteALLStateKeysAndValues() error {
+	defer ledger.nextStateGeneration(nil)
 	return ledger.state.DeleteState()
 }
 
+// GetStateGeneration returns a number that changes whenever state is committed
+// or discarded, i.e. on every change not made through SetState and DeleteState.
+// It allows state read earlier to be recognized as possibly outdated.
+func (ledger *Ledger) GetStateGeneration() uint64 {
+	return atomic.LoadUint64(&ledger.generation)
+}
+
+// GetStateChangesSince returns the keys, by chaincode ID, whose state may have
+// changed other than through SetState and DeleteState since generation. ok is
+// false if the changes are no longer known, in which case any key may have
+// changed.
+func (ledger *Ledger) GetStateChangesSince(generation uint64) (keys map[string][]string, ok bool) {
+	return ledger.changes.since(generation)
+}
+
+// nextStateGeneration moves to the next state generation, the keys of delta
+// being those that changed, all keys if delta is nil
+func (ledger *Ledger) nextStateGeneration(delta *statemgmt.StateDelta) {
+	ledger.changes.record(&ledger.generation, delta)
+}
+
 /////////////////// blockchain related methods /////////////////////////////////////
 /////////////////////////////////////////////////////////////////////////////////////
 
@@ -402,7 +462,10 @@ func (ledger *Ledger) checkValidIDCommitORRollback(id interface{}) error {
 func (ledger *Ledger) resetForNextTxGroup(txCommited bool) {
 	ledgerLogger.Debug("resetting ledger state for next transaction batch")
 	ledger.currentID = nil
+	// committed or discarded, the keys of the batch change
+	delta := ledger.state.GetStateDelta()
 	ledger.state.ClearInMemoryChanges(txCommited)
+	ledger.nextStateGeneration(delta)
 }
 
 func sendProducerBlockEvent(block *protos.Block) {
@@ -31,7 +31,7 @@ func InitTestLedger(t *testing.T) *Ledger {
 	testDBWrapper.CreateFreshDB(t)
 	_, err := GetLedger()
 	testutil.AssertNoError(t, err, "Error while constructing ledger")
-	newLedger, err := newLedger()
+	newLedger, err := newLedger(db.GetDBHandle())
 	testutil.AssertNoError(t, err, "Error while constructing ledger")
 	ledger = newLedger
 	return newLedger
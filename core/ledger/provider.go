@@ -0,0 +1,124 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package ledger
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/hyperledger/fabric/core/db"
+)
+
+// DefaultChainID identifies the chain backed by the peer's own ledger, the
+// one returned by GetLedger
+const DefaultChainID = "default"
+
+var chainLedgers = struct {
+	sync.Mutex
+	ledgers map[string]*Ledger
+}{ledgers: make(map[string]*Ledger)}
+
+// GetChainLedger returns the ledger of chainID, opening its database on first
+// use. The default chain, or an empty chain ID, gets the ledger of GetLedger.
+func GetChainLedger(chainID string) (*Ledger, error) {
+	if chainID == "" || chainID == DefaultChainID {
+		return GetLedger()
+	}
+	chainLedgers.Lock()
+	defer chainLedgers.Unlock()
+	if ledger, ok := chainLedgers.ledgers[chainID]; ok {
+		return ledger, nil
+	}
+	chainDB, err := db.OpenChainDB(chainID)
+	if err != nil {
+		return nil, err
+	}
+	ledger, err := newLedger(chainDB)
+	if err != nil {
+		chainDB.CloseDB()
+		return nil, err
+	}
+	chainLedgers.ledgers[chainID] = ledger
+	return ledger, nil
+}
+
+// LedgerProvider resolves the ledger of a chain, so that one peer can host
+// several isolated chains
+type LedgerProvider interface {
+	GetLedger(chainID string) (*Ledger, error)
+}
+
+// ChainLedgers is a LedgerProvider serving the peer's ledger for the default
+// chain, or an empty chain ID, and the ledgers registered for other chains
+type ChainLedgers struct {
+	sync.RWMutex
+	ledgers map[string]*Ledger
+}
+
+// NewChainLedgers returns a provider with no other chain than the default one
+func NewChainLedgers() *ChainLedgers {
+	return &ChainLedgers{ledgers: make(map[string]*Ledger)}
+}
+
+// Register makes ledger the ledger of chainID
+func (c *ChainLedgers) Register(chainID string, ledger *Ledger) error {
+	if chainID == "" || chainID == DefaultChainID {
+		return fmt.Errorf("Cannot replace the ledger of the default chain")
+	}
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.ledgers[chainID]; ok {
+		return fmt.Errorf("Ledger already registered for chain %s", chainID)
+	}
+	c.ledgers[chainID] = ledger
+	return nil
+}
+
+// Join opens the ledger of chainID, see GetChainLedger, and registers it.
+// Joining the default chain, or a chain already joined, does nothing.
+func (c *ChainLedgers) Join(chainID string) error {
+	if chainID == "" || chainID == DefaultChainID {
+		return nil
+	}
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.ledgers[chainID]; ok {
+		return nil
+	}
+	ledger, err := GetChainLedger(chainID)
+	if err != nil {
+		return err
+	}
+	c.ledgers[chainID] = ledger
+	return nil
+}
+
+// GetLedger implements LedgerProvider
+func (c *ChainLedgers) GetLedger(chainID string) (*Ledger, error) {
+	if chainID == "" || chainID == DefaultChainID {
+		return GetLedger()
+	}
+	c.RLock()
+	defer c.RUnlock()
+	if ledger, ok := c.ledgers[chainID]; ok {
+		return ledger, nil
+	}
+	return nil, fmt.Errorf("No ledger for chain %s", chainID)
+}
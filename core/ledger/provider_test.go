@@ -0,0 +1,81 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package ledger
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/core/ledger/testutil"
+	"github.com/hyperledger/fabric/core/util"
+	"github.com/hyperledger/fabric/protos"
+)
+
+func TestChainLedgers(t *testing.T) {
+	provider := NewChainLedgers()
+	other := &Ledger{}
+	if err := provider.Register("other", other); err != nil {
+		t.Fatalf("Error registering ledger: %s", err)
+	}
+	if err := provider.Register("other", &Ledger{}); err == nil {
+		t.Fatal("Expected error registering a chain twice")
+	}
+	if err := provider.Register(DefaultChainID, &Ledger{}); err == nil {
+		t.Fatal("Expected error replacing the default chain")
+	}
+
+	l, err := provider.GetLedger("other")
+	if err != nil || l != other {
+		t.Fatalf("Expected the registered ledger, got %v, %v", l, err)
+	}
+	if _, err = provider.GetLedger("unknown"); err == nil {
+		t.Fatal("Expected error for an unknown chain")
+	}
+}
+
+func TestChainLedgersJoin(t *testing.T) {
+	ledgerTestWrapper := createFreshDBAndTestLedgerWrapper(t)
+	chainID := "chain-" + util.GenerateUUID()
+	provider := NewChainLedgers()
+	testutil.AssertNoError(t, provider.Join(chainID), "Error joining chain")
+	testutil.AssertNoError(t, provider.Join(chainID), "Error joining chain again")
+	testutil.AssertNoError(t, provider.Join(DefaultChainID), "Error joining default chain")
+
+	chainLedger, err := provider.GetLedger(chainID)
+	testutil.AssertNoError(t, err, "Error getting chain ledger")
+	sameLedger, err := GetChainLedger(chainID)
+	testutil.AssertNoError(t, err, "Error getting chain ledger")
+	testutil.AssertSame(t, sameLedger, chainLedger)
+
+	chainLedger.BeginTxBatch(1)
+	chainLedger.TxBegin("txUuid")
+	chainLedger.SetState("chaincode1", "key1", []byte("value1"))
+	chainLedger.TxFinished("txUuid", true)
+	transaction, _ := buildTestTx(t)
+	testutil.AssertNoError(t, chainLedger.CommitTxBatch(1, []*protos.Transaction{transaction}, nil, []byte("proof")), "Error committing chain batch")
+
+	value, err := chainLedger.GetState("chaincode1", "key1", true)
+	testutil.AssertNoError(t, err, "Error getting state from chain ledger")
+	testutil.AssertEquals(t, value, []byte("value1"))
+	testutil.AssertNil(t, ledgerTestWrapper.GetState("chaincode1", "key1", true))
+	testutil.AssertEquals(t, chainLedger.GetBlockchainSize(), uint64(1))
+
+	_, err = GetChainLedger("../escape")
+	testutil.AssertError(t, err, "Expected error for an invalid chain ID")
+}
@@ -0,0 +1,92 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package ledger
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+)
+
+// The number of state generations whose changed keys are remembered
+const stateChangeLogSize = 128
+
+// stateChange lists the keys, by chaincode ID, that changed when the state
+// moved to generation. A nil keys map means that any key may have changed.
+type stateChange struct {
+	generation uint64
+	keys       map[string][]string
+}
+
+// stateChangeLog remembers the keys changed by the most recent state
+// generations, so that caches of state can be invalidated precisely
+type stateChangeLog struct {
+	sync.Mutex
+	changes []*stateChange
+}
+
+// record moves generation to the next one and records the keys of delta as
+// its changes, all keys if delta is nil
+func (l *stateChangeLog) record(generation *uint64, delta *statemgmt.StateDelta) {
+	var keys map[string][]string
+	if delta != nil {
+		keys = make(map[string][]string)
+		for _, chaincodeID := range delta.GetUpdatedChaincodeIds(false) {
+			for key := range delta.GetUpdates(chaincodeID) {
+				keys[chaincodeID] = append(keys[chaincodeID], key)
+			}
+		}
+	}
+	l.Lock()
+	defer l.Unlock()
+	change := &stateChange{generation: atomic.AddUint64(generation, 1), keys: keys}
+	if len(l.changes) == stateChangeLogSize {
+		l.changes[0] = nil
+		l.changes = l.changes[1:]
+	}
+	l.changes = append(l.changes, change)
+}
+
+// since returns the keys changed in the generations after generation, ok is
+// false if they are not all remembered
+func (l *stateChangeLog) since(generation uint64) (keys map[string][]string, ok bool) {
+	l.Lock()
+	defer l.Unlock()
+	keys = make(map[string][]string)
+	if len(l.changes) == 0 || l.changes[len(l.changes)-1].generation <= generation {
+		return keys, true
+	}
+	if l.changes[0].generation > generation+1 {
+		return nil, false
+	}
+	for _, change := range l.changes {
+		if change.generation <= generation {
+			continue
+		}
+		if change.keys == nil {
+			return nil, false
+		}
+		for chaincodeID, changed := range change.keys {
+			keys[chaincodeID] = append(keys[chaincodeID], changed...)
+		}
+	}
+	return keys, true
+}
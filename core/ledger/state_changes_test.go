@@ -0,0 +1,75 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package ledger
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+)
+
+func TestStateChangeLog(t *testing.T) {
+	var log stateChangeLog
+	var generation uint64
+
+	delta := statemgmt.NewStateDelta()
+	delta.Set("cc1", "a", []byte("1"), nil)
+	delta.Delete("cc2", "b", nil)
+	log.record(&generation, delta)
+	delta = statemgmt.NewStateDelta()
+	delta.Set("cc1", "c", []byte("1"), nil)
+	log.record(&generation, delta)
+
+	if generation != 2 {
+		t.Fatalf("Expected generation 2, got %d", generation)
+	}
+	keys, ok := log.since(0)
+	if !ok {
+		t.Fatal("Expected the changes since generation 0 to be known")
+	}
+	sort.Strings(keys["cc1"])
+	if len(keys) != 2 || len(keys["cc1"]) != 2 || keys["cc1"][0] != "a" || keys["cc1"][1] != "c" || keys["cc2"][0] != "b" {
+		t.Fatalf("Unexpected changes %v", keys)
+	}
+	if keys, ok = log.since(1); !ok || len(keys) != 1 || keys["cc1"][0] != "c" {
+		t.Fatalf("Unexpected changes since generation 1: %v", keys)
+	}
+	if keys, ok = log.since(2); !ok || len(keys) != 0 {
+		t.Fatalf("Expected no changes since the current generation, got %v", keys)
+	}
+
+	// changes to all keys
+	log.record(&generation, nil)
+	if _, ok = log.since(2); ok {
+		t.Fatal("Expected the changes to be unknown after a change to all keys")
+	}
+
+	// forgotten changes
+	for i := 0; i < stateChangeLogSize; i++ {
+		log.record(&generation, statemgmt.NewStateDelta())
+	}
+	if _, ok = log.since(2); ok {
+		t.Fatal("Expected the changes of forgotten generations to be unknown")
+	}
+	if _, ok = log.since(3); !ok {
+		t.Fatal("Expected the changes of remembered generations to be known")
+	}
+}
@@ -22,6 +22,7 @@ package buckettree
 import (
 	"testing"
 
+	"github.com/hyperledger/fabric/core/db"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
 	"github.com/hyperledger/fabric/core/ledger/testutil"
 	"github.com/op/go-logging"
@@ -46,7 +47,7 @@ func testGetRootHashes(t *testing.T, enableBlockCache bool) ([]byte, []byte, []b
 	testHasher.populate("chaincodeID3", "key3", 26)
 
 	if !enableBlockCache {
-		stateImplTestWrapper.stateImpl.bucketCache = newBucketCache(0)
+		stateImplTestWrapper.stateImpl.bucketCache = newBucketCache(db.GetDBHandle(), 0)
 	}
 	stateDelta.Set("chaincodeID1", "key1", []byte("value1"), nil)
 	stateDelta.Set("chaincodeID2", "key2", []byte("value2"), nil)
@@ -65,7 +66,7 @@ func testGetRootHashes(t *testing.T, enableBlockCache bool) ([]byte, []byte, []b
 	stateImplTestWrapper.persistChangesAndResetInMemoryChanges()
 
 	if enableBlockCache {
-		stateImplTestWrapper.stateImpl.bucketCache = newBucketCache(20)
+		stateImplTestWrapper.stateImpl.bucketCache = newBucketCache(db.GetDBHandle(), 20)
 		stateImplTestWrapper.stateImpl.bucketCache.loadAllBucketNodesFromDB()
 	}
 	stateDelta = statemgmt.NewStateDelta()
@@ -59,7 +59,7 @@ func TestStateSnapshotIterator(t *testing.T) {
 	//check that the key is deleted
 	testutil.AssertNil(t, stateImplTestWrapper.get("chaincodeID5", "key5"))
 
-	itr, err := newStateSnapshotIterator(dbSnapshot)
+	itr, err := newStateSnapshotIterator(db.GetDBHandle(), dbSnapshot)
 	testutil.AssertNoError(t, err, "Error while getting state snapeshot iterator")
 	numKeys := 0
 	for itr.Next() {
@@ -37,12 +37,11 @@ var logger = logging.MustGetLogger("state")
 
 const detaultStateImpl = "buckettree"
 
-var stateImpl statemgmt.HashableState
-
 // State structure for maintaining world state.
 // This encapsulates a particular implementation for managing the state persistence
 // This is not thread safe
 type State struct {
+	db                    *db.OpenchainDB
 	stateImpl             statemgmt.HashableState
 	stateDelta            *statemgmt.StateDelta
 	currentTxStateDelta   *statemgmt.StateDelta
@@ -50,10 +49,19 @@ type State struct {
 	txStateDeltaHash      map[string][]byte
 	updateStateImpl       bool
 	historyStateDeltaSize uint64
+	txStateDeltas         []*TxStateDelta
+}
+
+// TxStateDelta is the change in state made by a successful tx of the current
+// transaction-batch
+type TxStateDelta struct {
+	TxUUID string
+	Delta  *statemgmt.StateDelta
 }
 
-// NewState constructs a new State. This Initializes encapsulated state implementation
-func NewState() *State {
+// NewState constructs a new State kept in openchainDB. This Initializes
+// encapsulated state implementation
+func NewState(openchainDB *db.OpenchainDB) *State {
 	stateImplName := viper.GetString("ledger.state.dataStructure.name")
 	stateImplConfigs := viper.GetStringMap("ledger.state.dataStructure.configs")
 
@@ -64,13 +72,14 @@ func NewState() *State {
 
 	logger.Info("Initializing state implementation [%s]", stateImplName)
 
+	var stateImpl statemgmt.HashableState
 	switch stateImplName {
 	case "buckettree":
-		stateImpl = buckettree.NewStateImpl()
+		stateImpl = buckettree.NewStateImpl(openchainDB)
 	case "trie":
-		stateImpl = trie.NewStateTrie()
+		stateImpl = trie.NewStateTrie(openchainDB)
 	case "raw":
-		stateImpl = raw.NewRawState()
+		stateImpl = raw.NewRawState(openchainDB)
 	default:
 		panic(fmt.Errorf("Error during initialization of state implementation. State data structure '%s' is not valid.", stateImplName))
 	}
@@ -83,8 +92,8 @@ func NewState() *State {
 	if deltaHistorySize < 0 {
 		panic(fmt.Errorf("Delta history size must be greater than or equal to 0. Current value is %d.", deltaHistorySize))
 	}
-	return &State{stateImpl, statemgmt.NewStateDelta(), statemgmt.NewStateDelta(), "", make(map[string][]byte),
-		false, uint64(deltaHistorySize)}
+	return &State{openchainDB, stateImpl, statemgmt.NewStateDelta(), statemgmt.NewStateDelta(), "", make(map[string][]byte),
+		false, uint64(deltaHistorySize), nil}
 }
 
 // TxBegin marks begin of a new tx. If a tx is already in progress, this call panics
@@ -107,6 +116,7 @@ func (state *State) TxFinish(txUUID string, txSuccessful bool) {
 			logger.Debug("txFinish() for txUuid [%s] merging state changes", txUUID)
 			state.stateDelta.ApplyChanges(state.currentTxStateDelta)
 			state.txStateDeltaHash[txUUID] = state.currentTxStateDelta.ComputeCryptoHash()
+			state.txStateDeltas = append(state.txStateDeltas, &TxStateDelta{txUUID, state.currentTxStateDelta})
 			state.updateStateImpl = true
 		} else {
 			state.txStateDeltaHash[txUUID] = nil
@@ -227,6 +237,7 @@ func (state *State) GetTxStateDeltaHash() map[string][]byte {
 func (state *State) ClearInMemoryChanges(changesPersisted bool) {
 	state.stateDelta = statemgmt.NewStateDelta()
 	state.txStateDeltaHash = make(map[string][]byte)
+	state.txStateDeltas = nil
 	state.stateImpl.ClearWorkingSet(changesPersisted)
 }
 
@@ -235,15 +246,33 @@ func (state *State) getStateDelta() *statemgmt.StateDelta {
 	return state.stateDelta
 }
 
+// GetStateDelta returns the changes in state of the finished txs since the
+// most recent call to ClearInMemoryChanges
+func (state *State) GetStateDelta() *statemgmt.StateDelta {
+	return state.stateDelta
+}
+
+// GetTxStateDeltas returns the changes in state of each finished tx since the
+// most recent call to ClearInMemoryChanges, in the order the txs finished.
+// Txs that failed or did not change the state are left out
+func (state *State) GetTxStateDeltas() []*TxStateDelta {
+	return state.txStateDeltas
+}
+
+// GetCurrentTxStateDelta returns the changes in state made by the tx in progress
+func (state *State) GetCurrentTxStateDelta() *statemgmt.StateDelta {
+	return state.currentTxStateDelta
+}
+
 // GetSnapshot returns a snapshot of the global state for the current block. stateSnapshot.Release()
 // must be called once you are done.
 func (state *State) GetSnapshot(blockNumber uint64, dbSnapshot *gorocksdb.Snapshot) (*StateSnapshot, error) {
-	return newStateSnapshot(blockNumber, dbSnapshot)
+	return newStateSnapshot(state.stateImpl, blockNumber, dbSnapshot)
 }
 
 // FetchStateDeltaFromDB fetches the StateDelta corrsponding to given blockNumber
 func (state *State) FetchStateDeltaFromDB(blockNumber uint64) (*statemgmt.StateDelta, error) {
-	stateDeltaBytes, err := db.GetDBHandle().GetFromStateDeltaCF(encodeStateDeltaKey(blockNumber))
+	stateDeltaBytes, err := state.db.GetFromStateDeltaCF(encodeStateDeltaKey(blockNumber))
 	if err != nil {
 		return nil, err
 	}
@@ -265,7 +294,7 @@ func (state *State) AddChangesForPersistence(blockNumber uint64, writeBatch *gor
 	state.stateImpl.AddChangesForPersistence(writeBatch)
 
 	serializedStateDelta := state.stateDelta.Marshal()
-	cf := db.GetDBHandle().StateDeltaCF
+	cf := state.db.StateDeltaCF
 	logger.Debug("Adding state-delta corresponding to block number[%d]", blockNumber)
 	writeBatch.PutCF(cf, encodeStateDeltaKey(blockNumber), serializedStateDelta)
 	if blockNumber >= state.historyStateDeltaSize {
@@ -298,9 +327,7 @@ func (state *State) CommitStateDelta() error {
 	writeBatch := gorocksdb.NewWriteBatch()
 	defer writeBatch.Destroy()
 	state.stateImpl.AddChangesForPersistence(writeBatch)
-	opt := gorocksdb.NewDefaultWriteOptions()
-	defer opt.Destroy()
-	return db.GetDBHandle().DB.Write(opt, writeBatch)
+	return state.db.Commit(writeBatch)
 }
 
 // DeleteState deletes ALL state keys/values from the DB. This is generally
@@ -308,7 +335,7 @@ func (state *State) CommitStateDelta() error {
 // a snapshot.
 func (state *State) DeleteState() error {
 	state.ClearInMemoryChanges(false)
-	err := db.GetDBHandle().DeleteState()
+	err := state.db.DeleteState()
 	if err != nil {
 		logger.Error("Error deleting state", err)
 	}
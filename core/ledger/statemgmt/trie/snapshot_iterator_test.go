@@ -74,7 +74,7 @@ func TestStateSnapshotIterator(t *testing.T) {
 	testutil.AssertEquals(t, stateTrieTestWrapper.Get("chaincodeID2", "key2"), []byte("value2_new"))
 	testutil.AssertEquals(t, stateTrieTestWrapper.Get("chaincodeID5", "key5"), []byte("value5_new"))
 
-	itr, err := newStateSnapshotIterator(dbSnapshot)
+	itr, err := newStateSnapshotIterator(db.GetDBHandle(), dbSnapshot)
 	testutil.AssertNoError(t, err, "Error while getting state snapeshot iterator")
 
 	stateDeltaFromSnapshot := statemgmt.NewStateDelta()
@@ -22,9 +22,13 @@ package core
 import (
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/op/go-logging"
 	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/core/chaincode"
+	"github.com/hyperledger/fabric/core/config"
 )
 
 // A logger to log logging logs!
@@ -48,7 +52,7 @@ func LoggingInit(command string) {
 	var err error
 	spec := viper.GetString("logging_level")
 	if spec == "" {
-		spec = viper.GetString("logging." + command)
+		spec = config.GetString("logging." + command)
 	}
 	if spec != "" {
 		fields := strings.Split(spec, ":")
@@ -71,7 +75,12 @@ func LoggingInit(command string) {
 				} else {
 					modules := strings.Split(split[0], ",")
 					for _, module := range modules {
-						logging.SetLevel(level, module)
+						if chaincode.IsChaincodeLogModule(module) {
+							// chaincode.<name> modules follow chaincode unless set
+							chaincode.SetLogLevel(module, level)
+						} else {
+							logging.SetLevel(level, module)
+						}
 						loggingLogger.Debug("Setting logging level for module '%s' to %s", module, level)
 					}
 				}
@@ -85,14 +94,66 @@ func LoggingInit(command string) {
 	loggingLogger.Debug("Setting default logging level to %s for command '%s'", defaultLevel, command)
 }
 
-// Initiate 'leveled' logging to stderr.
+// The number of log lines kept in memory for support bundles
+const recentLogSize = 2000
+
+// recentLog keeps the most recent log lines, formatted when they are logged
+type recentLog struct {
+	sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+var recentLogs = &recentLog{lines: make([]string, recentLogSize)}
+
+// Log implements logging.Backend
+func (r *recentLog) Log(level logging.Level, calldepth int, rec *logging.Record) error {
+	line := rec.Formatted(calldepth + 1)
+	r.Lock()
+	defer r.Unlock()
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % len(r.lines)
+	if r.next == 0 {
+		r.full = true
+	}
+	return nil
+}
+
+// tail returns the last n lines, oldest first. n <= 0 returns all lines kept.
+func (r *recentLog) tail(n int) []string {
+	r.Lock()
+	defer r.Unlock()
+	var lines []string
+	if r.full {
+		lines = append(lines, r.lines[r.next:]...)
+	}
+	lines = append(lines, r.lines[:r.next]...)
+	if n > 0 && n < len(lines) {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// RecentLogs returns the last n lines logged by the peer, oldest first. n <= 0
+// returns all the lines kept in memory.
+func RecentLogs(n int) []string {
+	return recentLogs.tail(n)
+}
+
+// Initiate 'leveled' logging to stderr, keeping the most recent lines in memory.
 func init() {
 
 	format := logging.MustStringFormatter(
 		"%{color}%{time:15:04:05.000} [%{module}] %{shortfunc} -> %{level:.4s} %{id:03x}%{color:reset} %{message}",
 	)
+	plainFormat := logging.MustStringFormatter(
+		"%{time:2006-01-02 15:04:05.000} [%{module}] %{shortfunc} -> %{level:.4s} %{id:03x} %{message}",
+	)
 
 	backend := logging.NewLogBackend(os.Stderr, "", 0)
 	backendFormatter := logging.NewBackendFormatter(backend, format)
-	logging.SetBackend(backendFormatter).SetLevel(loggingDefaultLevel, "")
+	recentFormatter := logging.NewBackendFormatter(recentLogs, plainFormat)
+	// The levels are changed at runtime, they are guarded by a lock
+	config.SetLogBackend(backendFormatter, recentFormatter).SetLevel(loggingDefaultLevel, "")
 }
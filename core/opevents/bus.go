@@ -0,0 +1,147 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package opevents is the internal bus carrying the operational lifecycle
+// events of a peer (chaincode launched or crashed, peer connected or evicted,
+// sync started or completed, block committed). The event hub, the webhook
+// sink and the metrics all consume the same stream.
+package opevents
+
+import (
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+var logger = logging.MustGetLogger("opevents")
+
+// Kind is the type of a lifecycle event
+type Kind string
+
+// Lifecycle event kinds
+const (
+	ChaincodeLaunched Kind = "chaincode.launched"
+	ChaincodeCrashed  Kind = "chaincode.crashed"
+	PeerConnected     Kind = "peer.connected"
+	PeerEvicted       Kind = "peer.evicted"
+	SyncStarted       Kind = "sync.started"
+	SyncCompleted     Kind = "sync.completed"
+	BlockCommitted    Kind = "block.committed"
+)
+
+// Event is a lifecycle event. Attributes carry the kind specific details,
+// e.g. the chaincode name or the block number.
+type Event struct {
+	Kind       Kind              `json:"kind"`
+	Timestamp  time.Time         `json:"timestamp"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// Subscription receives the events published on a bus on C. Events are
+// dropped, never blocking the publisher, when C is full.
+type Subscription struct {
+	C       <-chan *Event
+	c       chan *Event
+	bus     *Bus
+	dropped uint64
+}
+
+// Dropped returns the number of events dropped because C was full
+func (s *Subscription) Dropped() uint64 {
+	s.bus.Lock()
+	defer s.bus.Unlock()
+	return s.dropped
+}
+
+// Cancel removes the subscription from the bus and closes C
+func (s *Subscription) Cancel() {
+	s.bus.Lock()
+	defer s.bus.Unlock()
+	if _, ok := s.bus.subs[s]; ok {
+		delete(s.bus.subs, s)
+		close(s.c)
+	}
+}
+
+// Bus fans published events out to its subscriptions and counts them by kind
+type Bus struct {
+	sync.Mutex
+	subs   map[*Subscription]bool
+	counts map[Kind]uint64
+}
+
+// NewBus returns an empty bus
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]bool), counts: make(map[Kind]uint64)}
+}
+
+// Subscribe returns a subscription buffering up to size events
+func (b *Bus) Subscribe(size int) *Subscription {
+	c := make(chan *Event, size)
+	s := &Subscription{C: c, c: c, bus: b}
+	b.Lock()
+	defer b.Unlock()
+	b.subs[s] = true
+	return s
+}
+
+// Publish sends an event of the given kind to all subscriptions
+func (b *Bus) Publish(kind Kind, attrs map[string]string) {
+	e := &Event{Kind: kind, Timestamp: time.Now().UTC(), Attributes: attrs}
+	b.Lock()
+	defer b.Unlock()
+	b.counts[kind]++
+	for s := range b.subs {
+		select {
+		case s.c <- e:
+		default:
+			s.dropped++
+			logger.Debug("Subscription full, dropping %s event", kind)
+		}
+	}
+}
+
+// Counts returns the number of events published so far, by kind
+func (b *Bus) Counts() map[Kind]uint64 {
+	b.Lock()
+	defer b.Unlock()
+	counts := make(map[Kind]uint64, len(b.counts))
+	for k, v := range b.counts {
+		counts[k] = v
+	}
+	return counts
+}
+
+var defaultBus = NewBus()
+
+// Publish sends an event on the peer's bus
+func Publish(kind Kind, attrs map[string]string) {
+	defaultBus.Publish(kind, attrs)
+}
+
+// Subscribe subscribes to the peer's bus
+func Subscribe(size int) *Subscription {
+	return defaultBus.Subscribe(size)
+}
+
+// Counts returns the number of events published on the peer's bus, by kind
+func Counts() map[Kind]uint64 {
+	return defaultBus.Counts()
+}
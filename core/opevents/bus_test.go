@@ -0,0 +1,99 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package opevents
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBusFanout(t *testing.T) {
+	b := NewBus()
+	s1 := b.Subscribe(10)
+	s2 := b.Subscribe(1)
+
+	b.Publish(ChaincodeLaunched, map[string]string{"chaincode": "mycc"})
+	b.Publish(BlockCommitted, map[string]string{"block": "1"})
+
+	e := <-s1.C
+	if e.Kind != ChaincodeLaunched || e.Attributes["chaincode"] != "mycc" {
+		t.Fatalf("Unexpected first event: %v", e)
+	}
+	if e = <-s1.C; e.Kind != BlockCommitted {
+		t.Fatalf("Unexpected second event: %v", e)
+	}
+	if e = <-s2.C; e.Kind != ChaincodeLaunched {
+		t.Fatalf("Unexpected event: %v", e)
+	}
+	if s2.Dropped() != 1 {
+		t.Fatalf("Expected the full subscription to drop 1 event, dropped %d", s2.Dropped())
+	}
+
+	counts := b.Counts()
+	if counts[ChaincodeLaunched] != 1 || counts[BlockCommitted] != 1 || counts[PeerEvicted] != 0 {
+		t.Fatalf("Unexpected counts: %v", counts)
+	}
+}
+
+func TestBusCancel(t *testing.T) {
+	b := NewBus()
+	s := b.Subscribe(10)
+	s.Cancel()
+	s.Cancel()
+	b.Publish(SyncStarted, nil)
+	if _, ok := <-s.C; ok {
+		t.Fatal("Expected no event after cancel")
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	received := make(chan *Event, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e := &Event{}
+		if err := json.NewDecoder(r.Body).Decode(e); err != nil {
+			t.Errorf("Error decoding event: %s", err)
+		}
+		received <- e
+	}))
+	defer server.Close()
+
+	b := NewBus()
+	s := b.Subscribe(10)
+	done := make(chan struct{})
+	go func() {
+		NewWebhookSink(server.URL, time.Second).Run(s)
+		close(done)
+	}()
+
+	b.Publish(PeerConnected, map[string]string{"peer": "vp1"})
+	select {
+	case e := <-received:
+		if e.Kind != PeerConnected || e.Attributes["peer"] != "vp1" {
+			t.Fatalf("Unexpected event: %v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the webhook")
+	}
+	s.Cancel()
+	<-done
+}
@@ -0,0 +1,102 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package opevents
+
+import (
+	"sync"
+)
+
+// HandlerEvent is a step in the life of a chaincode or peer handler
+type HandlerEvent int
+
+// Handler lifecycle events
+const (
+	// The handler was added to the handlers of its chaincode support or peer
+	HandlerRegistered HandlerEvent = iota
+	// The handler is ready to serve transactions or messages
+	HandlerReady
+	// The stream of the handler failed, it is about to be deregistered
+	HandlerError
+	// The handler was removed from the handlers of its chaincode support or peer
+	HandlerDeregistered
+)
+
+var handlerEventNames = map[HandlerEvent]string{
+	HandlerRegistered:   "registered",
+	HandlerReady:        "ready",
+	HandlerError:        "error",
+	HandlerDeregistered: "deregistered",
+}
+
+func (e HandlerEvent) String() string {
+	return handlerEventNames[e]
+}
+
+// LifecycleListener is called with the name of the chaincode or peer of a
+// handler when the handler goes through event. err is the reason of a
+// HandlerError, nil for the other events. Listeners are called synchronously,
+// possibly with locks of the caller held, so they must neither block nor call
+// back into the chaincode support or peer.
+type LifecycleListener func(name string, event HandlerEvent, err error)
+
+// Listeners is a set of lifecycle listeners, the zero value has none
+type Listeners struct {
+	sync.RWMutex
+	listeners []LifecycleListener
+}
+
+// Add registers listener for all later events
+func (l *Listeners) Add(listener LifecycleListener) {
+	l.Lock()
+	defer l.Unlock()
+	l.listeners = append(l.listeners, listener)
+}
+
+// Fire calls every listener, in the order they were added
+func (l *Listeners) Fire(name string, event HandlerEvent, err error) {
+	l.RLock()
+	listeners := l.listeners
+	l.RUnlock()
+	for _, listener := range listeners {
+		listener(name, event, err)
+	}
+}
+
+// ChaincodeListener publishes the launches and crashes of chaincodes on the
+// peer's bus
+func ChaincodeListener(name string, event HandlerEvent, err error) {
+	switch event {
+	case HandlerRegistered:
+		Publish(ChaincodeLaunched, map[string]string{"chaincode": name})
+	case HandlerError:
+		Publish(ChaincodeCrashed, map[string]string{"chaincode": name})
+	}
+}
+
+// PeerListener publishes the connections and evictions of peers on the
+// peer's bus
+func PeerListener(name string, event HandlerEvent, err error) {
+	switch event {
+	case HandlerRegistered:
+		Publish(PeerConnected, map[string]string{"peer": name})
+	case HandlerDeregistered:
+		Publish(PeerEvicted, map[string]string{"peer": name})
+	}
+}
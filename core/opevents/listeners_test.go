@@ -0,0 +1,71 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package opevents
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestListenersFireInOrder(t *testing.T) {
+	var l Listeners
+	var calls []string
+	l.Add(func(name string, event HandlerEvent, err error) {
+		calls = append(calls, "first "+name+" "+event.String())
+	})
+	l.Add(func(name string, event HandlerEvent, err error) {
+		if event == HandlerError && err == nil {
+			t.Fatal("Expected the reason of the error")
+		}
+		calls = append(calls, "second "+name+" "+event.String())
+	})
+
+	l.Fire("mycc", HandlerRegistered, nil)
+	l.Fire("mycc", HandlerError, errors.New("stream ended"))
+	expected := []string{"first mycc registered", "second mycc registered", "first mycc error", "second mycc error"}
+	if len(calls) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, calls)
+	}
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, calls)
+		}
+	}
+}
+
+func TestChaincodeListenerPublishes(t *testing.T) {
+	s := Subscribe(10)
+	defer s.Cancel()
+
+	ChaincodeListener("mycc", HandlerRegistered, nil)
+	ChaincodeListener("mycc", HandlerReady, nil)
+	ChaincodeListener("mycc", HandlerError, errors.New("stream ended"))
+
+	for _, kind := range []Kind{ChaincodeLaunched, ChaincodeCrashed} {
+		if e := <-s.C; e.Kind != kind || e.Attributes["chaincode"] != "mycc" {
+			t.Fatalf("Expected %s of mycc, got %v", kind, e)
+		}
+	}
+	select {
+	case e := <-s.C:
+		t.Fatalf("Unexpected event %v", e)
+	default:
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// Roles a remote endpoint can have in the authorization policy
+const (
+	RoleValidator    = "validator"
+	RoleNonValidator = "nonvalidator"
+	RoleClient       = "client"
+)
+
+// AuthorizationPolicy decides whether a message received from a remote
+// endpoint is handled. from is nil until the endpoint has identified itself
+// with DISC_HELLO.
+type AuthorizationPolicy interface {
+	Authorize(from *pb.PeerEndpoint, msg *pb.Message) error
+}
+
+// endpointRole returns the role of a remote endpoint. Endpoints that have not
+// identified themselves as a validator or non-validator are clients.
+func endpointRole(from *pb.PeerEndpoint) string {
+	if from != nil {
+		switch from.Type {
+		case pb.PeerEndpoint_VALIDATOR:
+			return RoleValidator
+		case pb.PeerEndpoint_NON_VALIDATOR:
+			return RoleNonValidator
+		}
+	}
+	return RoleClient
+}
+
+// rolePolicy accepts the message types configured for the role of the sender
+type rolePolicy struct {
+	allowed map[string]map[pb.Message_Type]bool
+	all     map[string]bool
+}
+
+// newRolePolicy returns a policy accepting the message types listed for each
+// role, "*" accepts every message type
+func newRolePolicy(roles map[string][]string) (*rolePolicy, error) {
+	p := &rolePolicy{allowed: make(map[string]map[pb.Message_Type]bool), all: make(map[string]bool)}
+	for role, types := range roles {
+		role = strings.ToLower(role)
+		if role != RoleValidator && role != RoleNonValidator && role != RoleClient {
+			return nil, fmt.Errorf("Unknown role %s in authorization policy", role)
+		}
+		p.allowed[role] = make(map[pb.Message_Type]bool)
+		for _, t := range types {
+			if t == "*" {
+				p.all[role] = true
+				continue
+			}
+			v, ok := pb.Message_Type_value[strings.ToUpper(t)]
+			if !ok {
+				return nil, fmt.Errorf("Unknown message type %s for role %s in authorization policy", t, role)
+			}
+			p.allowed[role][pb.Message_Type(v)] = true
+		}
+	}
+	return p, nil
+}
+
+// Authorize implements AuthorizationPolicy. DISC_HELLO is always accepted as
+// it is how the sender's role is learned.
+func (p *rolePolicy) Authorize(from *pb.PeerEndpoint, msg *pb.Message) error {
+	if msg.Type == pb.Message_DISC_HELLO {
+		return nil
+	}
+	role := endpointRole(from)
+	if p.all[role] || p.allowed[role][msg.Type] {
+		return nil
+	}
+	return fmt.Errorf("%s not authorized for %s", msg.Type, role)
+}
+
+var authorization struct {
+	sync.Mutex
+	policy AuthorizationPolicy
+	loaded bool
+}
+
+// SetAuthorizationPolicy replaces the policy applied to received messages, nil
+// accepts every message
+func SetAuthorizationPolicy(policy AuthorizationPolicy) {
+	authorization.Lock()
+	defer authorization.Unlock()
+	authorization.policy = policy
+	authorization.loaded = true
+}
+
+// getAuthorizationPolicy returns the policy set by SetAuthorizationPolicy, or
+// the one configured under peer.authorization
+func getAuthorizationPolicy() (AuthorizationPolicy, error) {
+	authorization.Lock()
+	defer authorization.Unlock()
+	if !authorization.loaded {
+		if viper.GetBool("peer.authorization.enabled") {
+			var roles map[string][]string
+			if err := viper.UnmarshalKey("peer.authorization.roles", &roles); err != nil {
+				return nil, err
+			}
+			policy, err := newRolePolicy(roles)
+			if err != nil {
+				return nil, err
+			}
+			authorization.policy = policy
+		}
+		authorization.loaded = true
+	}
+	return authorization.policy, nil
+}
+
+// AuthorizeMessage checks msg received from the endpoint from against the
+// authorization policy. It must be called before the message is handled.
+func AuthorizeMessage(from *pb.PeerEndpoint, msg *pb.Message) error {
+	policy, err := getAuthorizationPolicy()
+	if err != nil {
+		return fmt.Errorf("Error loading authorization policy: %s", err)
+	}
+	if policy == nil {
+		return nil
+	}
+	if err = policy.Authorize(from, msg); err != nil {
+		return fmt.Errorf("Rejected message from %s: %s", endpointRole(from), err)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/spf13/viper"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestRolePolicy(t *testing.T) {
+	policy, err := newRolePolicy(map[string][]string{
+		"validator":    {"*"},
+		"nonvalidator": {"DISC_GET_PEERS", "sync_get_blocks"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	validator := &pb.PeerEndpoint{Type: pb.PeerEndpoint_VALIDATOR}
+	nonValidator := &pb.PeerEndpoint{Type: pb.PeerEndpoint_NON_VALIDATOR}
+
+	cases := []struct {
+		from    *pb.PeerEndpoint
+		msgType pb.Message_Type
+		allowed bool
+	}{
+		{validator, pb.Message_CONSENSUS, true},
+		{nonValidator, pb.Message_DISC_GET_PEERS, true},
+		{nonValidator, pb.Message_SYNC_GET_BLOCKS, true},
+		{nonValidator, pb.Message_CONSENSUS, false},
+		{&pb.PeerEndpoint{}, pb.Message_CHAIN_TRANSACTION, false},
+		{nil, pb.Message_DISC_GET_PEERS, false},
+		// The role is not known before the hello
+		{nil, pb.Message_DISC_HELLO, true},
+	}
+	for _, c := range cases {
+		err := policy.Authorize(c.from, &pb.Message{Type: c.msgType})
+		if (err == nil) != c.allowed {
+			t.Errorf("%s from %s: expected allowed=%t, got %v", c.msgType, endpointRole(c.from), c.allowed, err)
+		}
+	}
+
+	if _, err = newRolePolicy(map[string][]string{"auditor": {"*"}}); err == nil {
+		t.Error("Expected unknown role to be rejected")
+	}
+	if _, err = newRolePolicy(map[string][]string{"client": {"DISC_BOGUS"}}); err == nil {
+		t.Error("Expected unknown message type to be rejected")
+	}
+}
+
+func TestAuthorizeMessageFromConfig(t *testing.T) {
+	defer resetTestConfig()
+	defer SetAuthorizationPolicy(nil)
+	viper.SetConfigType("yaml")
+	config := []byte(`
+peer:
+    authorization:
+        enabled: true
+        roles:
+            validator: ["*"]
+            client:
+                - CHAIN_TRANSACTION
+`)
+	if err := viper.ReadConfig(bytes.NewBuffer(config)); err != nil {
+		t.Fatal(err)
+	}
+	authorization.Lock()
+	authorization.loaded = false
+	authorization.Unlock()
+
+	if err := AuthorizeMessage(nil, &pb.Message{Type: pb.Message_CHAIN_TRANSACTION}); err != nil {
+		t.Fatalf("Expected transaction from client to be accepted, got %s", err)
+	}
+	if err := AuthorizeMessage(nil, &pb.Message{Type: pb.Message_SYNC_GET_BLOCKS}); err == nil {
+		t.Fatal("Expected sync request from client to be rejected")
+	}
+
+	SetAuthorizationPolicy(nil)
+	if err := AuthorizeMessage(nil, &pb.Message{Type: pb.Message_SYNC_GET_BLOCKS}); err != nil {
+		t.Fatalf("Expected every message to be accepted without a policy, got %s", err)
+	}
+}
@@ -0,0 +1,171 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// bandwidthMeter counts the bytes a handler sends to and receives from its
+// peer, and holds back the messages exceeding the peer.bandwidth caps so
+// that one chatty peer cannot take the whole network budget of the node. A
+// nil meter counts nothing.
+type bandwidthMeter struct {
+	sent     uint64 // accessed atomically
+	received uint64 // accessed atomically
+	send     *byteBucket
+	receive  *byteBucket
+}
+
+func newBandwidthMeter(sendRate, receiveRate int) *bandwidthMeter {
+	return &bandwidthMeter{send: newByteBucket(sendRate), receive: newByteBucket(receiveRate)}
+}
+
+// sending accounts msg, as put on the wire, and waits for the send cap to
+// let it through unless aborted is closed first
+func (m *bandwidthMeter) sending(msg *pb.Message, aborted <-chan struct{}) {
+	if m == nil {
+		return
+	}
+	n := proto.Size(msg)
+	atomic.AddUint64(&m.sent, uint64(n))
+	m.send.wait(n, !keepsChatIdle(msg.Type), aborted)
+}
+
+// receiving accounts msg, as read from the wire, and waits for the receive
+// cap to let it through unless aborted is closed first. Not reading from
+// the stream meanwhile pushes back on the peer.
+func (m *bandwidthMeter) receiving(msg *pb.Message, aborted <-chan struct{}) {
+	if m == nil {
+		return
+	}
+	n := proto.Size(msg)
+	atomic.AddUint64(&m.received, uint64(n))
+	m.receive.wait(n, !keepsChatIdle(msg.Type), aborted)
+}
+
+// stats returns the bytes exchanged and the time spent waiting on the caps
+func (m *bandwidthMeter) stats() *pb.PeerBandwidth {
+	if m == nil {
+		return &pb.PeerBandwidth{}
+	}
+	stats := &pb.PeerBandwidth{BytesSent: atomic.LoadUint64(&m.sent), BytesReceived: atomic.LoadUint64(&m.received)}
+	if m.send != nil {
+		stats.SendCap = uint64(m.send.rate)
+		stats.SendThrottledNanos = atomic.LoadInt64(&m.send.throttled)
+	}
+	if m.receive != nil {
+		stats.ReceiveCap = uint64(m.receive.rate)
+		stats.ReceiveThrottledNanos = atomic.LoadInt64(&m.receive.throttled)
+	}
+	return stats
+}
+
+// byteBucket is a token bucket of bytes refilled at rate per second, holding
+// at most one second worth. A message takes its size from the bucket even
+// beyond what is left and waits for the debt to be refilled, so that no
+// message is too large to ever pass.
+type byteBucket struct {
+	sync.Mutex
+	rate      float64
+	tokens    float64
+	refilled  time.Time
+	throttled int64 // nanoseconds, accessed atomically
+}
+
+// newByteBucket returns nil, capping nothing, if rate is not positive
+func newByteBucket(rate int) *byteBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &byteBucket{rate: float64(rate), tokens: float64(rate), refilled: time.Now()}
+}
+
+// take takes n bytes from the bucket and returns how long they must wait
+func (b *byteBucket) take(n int) time.Duration {
+	b.Lock()
+	defer b.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.refilled).Seconds() * b.rate
+	b.refilled = now
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// wait takes n bytes from the bucket and, if hold, waits until they may pass
+// or aborted is closed. Keepalive and discovery messages are not held, for a
+// busy chat not to be taken for a hung one.
+func (b *byteBucket) wait(n int, hold bool, aborted <-chan struct{}) {
+	if b == nil {
+		return
+	}
+	delay := b.take(n)
+	if delay == 0 || !hold {
+		return
+	}
+	atomic.AddInt64(&b.throttled, int64(delay))
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-aborted:
+	}
+}
+
+// GetPeerBandwidth returns the bytes exchanged with each connected peer and
+// the time spent waiting on the caps, ordered by peer ID
+func (p *PeerImpl) GetPeerBandwidth() *pb.PeerBandwidthStatus {
+	p.handlerMap.Lock()
+	defer p.handlerMap.Unlock()
+	status := &pb.PeerBandwidthStatus{}
+	for id, msgHandler := range p.handlerMap.m {
+		h, ok := msgHandler.(*Handler)
+		if !ok {
+			continue
+		}
+		stats := h.meter.stats()
+		stats.PeerID = &pb.PeerID{Name: id.Name}
+		if h.ToPeerEndpoint != nil {
+			stats.Address = h.ToPeerEndpoint.Address
+		}
+		status.Peers = append(status.Peers, stats)
+	}
+	sort.Sort(bandwidthByID(status.Peers))
+	return status
+}
+
+type bandwidthByID []*pb.PeerBandwidth
+
+func (a bandwidthByID) Len() int           { return len(a) }
+func (a bandwidthByID) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a bandwidthByID) Less(i, j int) bool { return a[i].PeerID.Name < a[j].PeerID.Name }
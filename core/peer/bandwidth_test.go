@@ -0,0 +1,92 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestByteBucket(t *testing.T) {
+	if newByteBucket(0) != nil {
+		t.Fatal("Expected no bucket without a rate")
+	}
+	b := newByteBucket(1000)
+	if delay := b.take(600); delay != 0 {
+		t.Fatalf("Expected bytes within the rate to pass, got a delay of %s", delay)
+	}
+	// 200 bytes over what is left, a fifth of a second at 1000 per second
+	if delay := b.take(600); delay < 150*time.Millisecond || delay > 200*time.Millisecond {
+		t.Fatalf("Expected a delay of about 200ms, got %s", delay)
+	}
+}
+
+func TestHandler_BandwidthCaps(t *testing.T) {
+	mock := &mockChatStream{sent: make(chan *pb.Message, 10)}
+	h := newMeshTestHandler("chatty", pb.PeerEndpoint_VALIDATOR)
+	h.ChatStream = newAbortableChatStream(mock)
+	h.protocolVersion = ProtocolVersion
+	h.meter = newBandwidthMeter(100000, 1000)
+
+	// 10000 bytes over the cap, a tenth of a second
+	start := time.Now()
+	if err := h.SendMessage(&pb.Message{Type: pb.Message_SYNC_BLOCKS, Payload: make([]byte, 110000)}); err != nil {
+		t.Fatalf("Error sending: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Fatalf("Expected the message over the cap to wait, sent after %s", elapsed)
+	}
+	start = time.Now()
+	if err := h.SendMessage(&pb.Message{Type: pb.Message_DISC_PING}); err != nil {
+		t.Fatalf("Error sending: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("Expected keepalive not to wait, sent after %s", elapsed)
+	}
+	var sent int
+	for i := 0; i < 2; i++ {
+		sent += proto.Size(<-mock.sent)
+	}
+
+	// Held for 9 seconds unless the chat ends
+	received := &pb.Message{Type: pb.Message_SYNC_BLOCKS, Payload: make([]byte, 10000)}
+	go h.abortChat()
+	h.meter.receiving(received, h.abortedChan())
+
+	p := newMeshTestPeer(0)
+	if err := p.RegisterHandler(h); err != nil {
+		t.Fatalf("Error registering handler: %s", err)
+	}
+	status := p.GetPeerBandwidth()
+	if len(status.Peers) != 1 {
+		t.Fatalf("Expected the bandwidth of 1 peer, got %v", status)
+	}
+	stats := status.Peers[0]
+	if stats.PeerID.Name != "chatty" || stats.Address != "chatty:30303" || stats.BytesSent != uint64(sent) || stats.BytesReceived != uint64(proto.Size(received)) {
+		t.Fatalf("Unexpected bandwidth %v", stats)
+	}
+	if stats.SendCap != 100000 || stats.ReceiveCap != 1000 || stats.SendThrottledNanos < int64(80*time.Millisecond) || stats.ReceiveThrottledNanos < int64(8*time.Second) {
+		t.Fatalf("Unexpected caps or throttling %v", stats)
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/opevents"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// BlockSyncCursor tracks the progress of a forward block sync over the range
+// [Start, End]. Next is the next block to apply, so a sync interrupted by an
+// error can be resumed from the cursor, with the same or another peer.
+type BlockSyncCursor struct {
+	Start uint64
+	End   uint64
+	Next  uint64
+}
+
+// NewBlockSyncCursor returns a cursor over the blocks start to end inclusively
+func NewBlockSyncCursor(start, end uint64) *BlockSyncCursor {
+	return &BlockSyncCursor{Start: start, End: end, Next: start}
+}
+
+// Done returns true once every block of the range has been applied
+func (c *BlockSyncCursor) Done() bool {
+	return c.Next > c.End
+}
+
+// Remaining returns the range of the blocks still to be applied
+func (c *BlockSyncCursor) Remaining() *pb.SyncBlockRange {
+	return &pb.SyncBlockRange{Start: c.Next, End: c.End}
+}
+
+func (c *BlockSyncCursor) String() string {
+	return fmt.Sprintf("%d/%d-%d", c.Next, c.Start, c.End)
+}
+
+// SyncBlocks requests the remaining blocks of cursor from remote and hands
+// them in order to apply, advancing the cursor after each applied block.
+// progress, if not nil, is called after every batch received. Blocks are
+// streamed in batches of up to peer.sync.blocks.batchSize by the remote, which
+// ends the stream early with an empty batch when it does not have them all, in
+// which case SyncBlocks returns without error and with the cursor not done.
+func SyncBlocks(remote RemoteLedger, cursor *BlockSyncCursor, timeout time.Duration, apply func(blockNumber uint64, block *pb.Block) error, progress func(cursor *BlockSyncCursor)) error {
+	if cursor.Done() {
+		return nil
+	}
+	blocks, err := remote.RequestBlocks(cursor.Remaining())
+	if err != nil {
+		return err
+	}
+	opevents.Publish(opevents.SyncStarted, syncAttributes(cursor))
+	for !cursor.Done() {
+		var syncBlocks *pb.SyncBlocks
+		select {
+		case syncBlocks = <-blocks:
+		case <-time.After(timeout):
+			return fmt.Errorf("Timed out waiting for block %d", cursor.Next)
+		}
+		if syncBlocks == nil {
+			return fmt.Errorf("Block stream closed before block %d", cursor.Next)
+		}
+		if syncBlocks.Range == nil || syncBlocks.Range.Start > syncBlocks.Range.End {
+			// Not a response to a forward request
+			continue
+		}
+		if len(syncBlocks.Blocks) == 0 {
+			peerLogger.Debug("Remote has no blocks beyond %d", cursor.Next)
+			opevents.Publish(opevents.SyncCompleted, syncAttributes(cursor))
+			return nil
+		}
+		for i, block := range syncBlocks.Blocks {
+			// Blocks may be duplicated or come from an earlier request
+			if syncBlocks.Range.Start+uint64(i) != cursor.Next {
+				continue
+			}
+			if err := apply(cursor.Next, block); err != nil {
+				return err
+			}
+			cursor.Next++
+		}
+		if progress != nil {
+			progress(cursor)
+		}
+	}
+	opevents.Publish(opevents.SyncCompleted, syncAttributes(cursor))
+	return nil
+}
+
+func syncAttributes(cursor *BlockSyncCursor) map[string]string {
+	return map[string]string{
+		"start": strconv.FormatUint(cursor.Start, 10),
+		"end":   strconv.FormatUint(cursor.End, 10),
+		"next":  strconv.FormatUint(cursor.Next, 10),
+	}
+}
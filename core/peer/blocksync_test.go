@@ -0,0 +1,79 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestSyncBlocksResumesFromCursor(t *testing.T) {
+	remote := newMemRemote(6)
+	cursor := NewBlockSyncCursor(0, 5)
+	var applied []uint64
+	var batches int
+	failAt := uint64(3)
+	apply := func(blockNumber uint64, block *pb.Block) error {
+		if blockNumber == failAt {
+			return fmt.Errorf("interrupted")
+		}
+		applied = append(applied, blockNumber)
+		return nil
+	}
+	progress := func(cursor *BlockSyncCursor) { batches++ }
+
+	if err := SyncBlocks(remote, cursor, 10*time.Millisecond, apply, progress); err == nil {
+		t.Fatal("Expected the sync to be interrupted")
+	}
+	if cursor.Next != 3 || cursor.Done() {
+		t.Fatalf("Expected the cursor to stop at block 3, got %s", cursor)
+	}
+
+	failAt = 100
+	if err := SyncBlocks(remote, cursor, 10*time.Millisecond, apply, progress); err != nil {
+		t.Fatalf("Unexpected error resuming the sync: %s", err)
+	}
+	if !cursor.Done() || len(applied) != 6 {
+		t.Fatalf("Expected all 6 blocks applied once, got %v", applied)
+	}
+	for i, n := range applied {
+		if n != uint64(i) {
+			t.Fatalf("Expected blocks applied in order, got %v", applied)
+		}
+	}
+	if batches != 3 {
+		t.Fatalf("Expected progress reported after each of 3 batches, got %d", batches)
+	}
+}
+
+func TestSyncBlocksStopsAtRemoteHeight(t *testing.T) {
+	remote := newMemRemote(3)
+	cursor := NewBlockSyncCursor(1, 10)
+	err := SyncBlocks(remote, cursor, 10*time.Millisecond, func(uint64, *pb.Block) error { return nil }, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if cursor.Next != 3 || cursor.Done() {
+		t.Fatalf("Expected the cursor to stop at the remote height, got %s", cursor)
+	}
+}
@@ -0,0 +1,143 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// bootstrap brings an empty ledger to at least height blocks without
+// replaying the chain: the state snapshot of remote is installed together with
+// the block it was taken at, and the blocks following it are then synced as
+// usual. The blocks below the snapshot are never fetched. A height of 0 stops
+// at the snapshot.
+func (f *follower) bootstrap(remote RemoteLedger, height uint64) error {
+	if size := f.ledger.GetBlockchainSize(); size > 0 {
+		return fmt.Errorf("Cannot bootstrap a ledger which already has %d block(s)", size)
+	}
+	blockNumber, delta, err := f.receiveSnapshot(remote)
+	if err != nil {
+		return err
+	}
+	peerLogger.Info("Received state snapshot at block %d, installing it", blockNumber)
+	if err = f.installSnapshot(remote, blockNumber, delta); err != nil {
+		return err
+	}
+
+	for f.ledger.GetBlockchainSize() < height {
+		synced, err := f.sync(remote)
+		if err != nil {
+			return err
+		}
+		if synced == 0 {
+			return fmt.Errorf("Remote has no blocks beyond %d, below the requested height %d", f.ledger.GetBlockchainSize(), height)
+		}
+	}
+	return nil
+}
+
+// receiveSnapshot merges the pieces of the state snapshot of remote into a
+// single delta, so that it is applied to the ledger at once
+func (f *follower) receiveSnapshot(remote RemoteLedger) (uint64, *statemgmt.StateDelta, error) {
+	pieces, err := remote.RequestStateSnapshot()
+	if err != nil {
+		return 0, nil, err
+	}
+	delta := statemgmt.NewStateDelta()
+	for counter := 0; ; counter++ {
+		var piece *pb.SyncStateSnapshot
+		select {
+		case piece = <-pieces:
+		case <-time.After(f.timeout):
+		}
+		if piece == nil {
+			return 0, nil, fmt.Errorf("State snapshot interrupted after %d deltas", counter)
+		}
+		if len(piece.Delta) == 0 {
+			return piece.BlockNumber, delta, nil
+		}
+		pieceDelta := statemgmt.NewStateDelta()
+		if err := pieceDelta.Unmarshal(piece.Delta); err != nil {
+			return 0, nil, fmt.Errorf("Received a corrupt delta after %d deltas: %s", counter, err)
+		}
+		delta.ApplyChanges(pieceDelta)
+	}
+}
+
+// installSnapshot applies the snapshot delta taken at blockNumber and commits
+// it with that block, once the state hash has been checked against the block
+func (f *follower) installSnapshot(remote RemoteLedger, blockNumber uint64, delta *statemgmt.StateDelta) error {
+	var block *pb.Block
+	cursor := NewBlockSyncCursor(blockNumber, blockNumber)
+	err := SyncBlocks(remote, cursor, f.timeout, func(_ uint64, b *pb.Block) error {
+		block = b
+		return nil
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if block == nil {
+		return fmt.Errorf("Remote did not send the snapshot block %d", blockNumber)
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	id := fmt.Sprintf("bootstrap-%d", blockNumber)
+	if err = f.ledger.ApplyStateDelta(id, delta); err != nil {
+		return err
+	}
+	stateHash, err := f.ledger.GetTempStateHash()
+	if err == nil && !bytes.Equal(stateHash, block.StateHash) {
+		err = fmt.Errorf("State hash of the snapshot does not match block %d", blockNumber)
+	}
+	if err != nil {
+		f.ledger.RollbackStateDelta(id)
+		return err
+	}
+	if err = f.ledger.CommitStateDelta(id); err != nil {
+		return err
+	}
+	return f.ledger.PutRawBlock(block, blockNumber)
+}
+
+// bootstrapFrom bootstraps the ledger from the peer named from, retrying until
+// it succeeds
+func (p *PeerImpl) bootstrapFrom(f *follower, from string, height uint64) {
+	interval := viper.GetDuration("peer.follower.interval")
+	peerLogger.Info("Bootstrapping from peer %s to height %d", from, height)
+	for {
+		remote, err := p.GetRemoteLedger(&pb.PeerID{Name: from})
+		if err == nil {
+			if err = f.bootstrap(remote, height); err == nil {
+				peerLogger.Info("Bootstrapped from peer %s, ledger height is %d", from, f.ledger.GetBlockchainSize())
+				return
+			}
+		}
+		peerLogger.Warning("Error bootstrapping from peer %s, retrying in %s: %s", from, interval, err)
+		time.Sleep(interval)
+	}
+}
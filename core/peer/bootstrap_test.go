@@ -0,0 +1,60 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBootstrapFromSnapshot(t *testing.T) {
+	remote := newMemRemote(7)
+	remote.snapshotAt = 3
+	local := &memLedger{}
+	f := &follower{ledger: local, lock: &sync.Mutex{}, batchSize: 2, timeout: 10 * time.Millisecond}
+
+	if err := f.bootstrap(remote, 7); err != nil {
+		t.Fatalf("Unexpected error bootstrapping: %s", err)
+	}
+	if local.GetBlockchainSize() != 7 || string(local.stateHash()) != string(remote.chain[6].StateHash) {
+		t.Fatal("Expected local chain and state to match the remote")
+	}
+	if _, err := local.GetBlockByNumber(2); err == nil {
+		t.Fatal("Expected the blocks below the snapshot not to be fetched")
+	}
+	if err := f.bootstrap(remote, 7); err == nil {
+		t.Fatal("Expected bootstrapping a non empty ledger to fail")
+	}
+}
+
+func TestBootstrapRejectsMismatchedSnapshot(t *testing.T) {
+	remote := newMemRemote(3)
+	remote.chain[2].StateHash = []byte("tampered")
+	local := &memLedger{}
+	f := &follower{ledger: local, lock: &sync.Mutex{}, batchSize: 2, timeout: 10 * time.Millisecond}
+
+	if err := f.bootstrap(remote, 0); err == nil {
+		t.Fatal("Expected the snapshot not matching its block to be rejected")
+	}
+	if local.GetBlockchainSize() != 0 || local.stateHash() != nil {
+		t.Fatal("Expected the snapshot to be rolled back")
+	}
+}
@@ -0,0 +1,368 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/hyperledger/fabric/core/crypto"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// ChatTrace is the record of the chat of a peer handler with one remote
+// peer. Traces of a known-good run, written to peer.chatTrace.dir, serve as
+// golden files for ReplayChatTrace.
+type ChatTrace struct {
+	Initiated bool              `json:"initiated"`
+	Entries   []*ChatTraceEntry `json:"entries"`
+	// Truncated is set when the recorder stopped at peer.chatTrace.maxMessages,
+	// the entries are then the beginning of the chat only
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// ChatTraceEntry is either a message received by the handler, with the FSM
+// transition it caused, a digest of the state it left the handler in and the
+// messages sent while handling it, or a message sent on behalf of another
+// part of the peer, such as the discovery loop or the consensus engine.
+type ChatTraceEntry struct {
+	Received  *pb.Message   `json:"received,omitempty"`
+	SrcState  string        `json:"srcState,omitempty"`
+	DstState  string        `json:"dstState,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	StateHash string        `json:"stateHash,omitempty"`
+	Responses []*pb.Message `json:"responses,omitempty"`
+	Sent      *pb.Message   `json:"sent,omitempty"`
+}
+
+// LoadChatTrace reads a trace written by a peer with peer.chatTrace.dir set
+func LoadChatTrace(path string) (*ChatTrace, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading chat trace: %s", err)
+	}
+	trace := &ChatTrace{}
+	if err = json.Unmarshal(data, trace); err != nil {
+		return nil, fmt.Errorf("Error parsing chat trace %s: %s", path, err)
+	}
+	return trace, nil
+}
+
+// unsolicited reports whether messages of type typ are only sent by the
+// discovery loop, never in response to a message. Whether such a message is
+// sent while another one is handled depends on timing alone.
+func unsolicited(typ pb.Message_Type) bool {
+	return typ == pb.Message_DISC_GET_PEERS || typ == pb.Message_DISC_PING
+}
+
+// chatTraceRecorder records the ChatTrace of a handler. A nil chatTraceRecorder
+// records nothing. Once maxMessages messages are recorded the trace is
+// truncated, so that a long lived chat does not grow it without bound.
+type chatTraceRecorder struct {
+	sync.Mutex
+	dir         string
+	maxMessages int
+	messages    int
+	trace       ChatTrace
+	handling    *ChatTraceEntry
+}
+
+func newChatTraceRecorder(dir string, maxMessages int, initiated bool) *chatTraceRecorder {
+	if dir == "" {
+		return nil
+	}
+	return &chatTraceRecorder{dir: dir, maxMessages: maxMessages, trace: ChatTrace{Initiated: initiated}}
+}
+
+// full reports whether no more messages are recorded, and marks the trace
+// truncated when so, dropping the entry of the message being handled as its
+// responses are incomplete. Called with the lock held.
+func (r *chatTraceRecorder) full() bool {
+	if r.maxMessages > 0 && r.messages >= r.maxMessages {
+		r.trace.Truncated = true
+		for i, entry := range r.trace.Entries {
+			if entry == r.handling {
+				r.trace.Entries = append(r.trace.Entries[:i], r.trace.Entries[i+1:]...)
+				break
+			}
+		}
+		r.handling = nil
+		return true
+	}
+	r.messages++
+	return false
+}
+
+// received starts the entry of msg, the messages sent until it is handled
+// are recorded as its responses
+func (r *chatTraceRecorder) received(msg *pb.Message) {
+	if r == nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	if r.full() {
+		return
+	}
+	r.handling = &ChatTraceEntry{Received: proto.Clone(msg).(*pb.Message)}
+	r.trace.Entries = append(r.trace.Entries, r.handling)
+}
+
+// handled completes the entry of the message being handled
+func (r *chatTraceRecorder) handled(src, dst string, err error, stateHash string) {
+	if r == nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	if r.handling == nil {
+		return
+	}
+	r.handling.SrcState, r.handling.DstState, r.handling.StateHash = src, dst, stateHash
+	if err != nil {
+		r.handling.Error = err.Error()
+	}
+	r.handling = nil
+}
+
+func (r *chatTraceRecorder) sent(msg *pb.Message) {
+	if r == nil {
+		return
+	}
+	r.Lock()
+	defer r.Unlock()
+	if r.full() {
+		return
+	}
+	msg = proto.Clone(msg).(*pb.Message)
+	if r.handling != nil && !unsolicited(msg.Type) {
+		r.handling.Responses = append(r.handling.Responses, msg)
+		return
+	}
+	r.trace.Entries = append(r.trace.Entries, &ChatTraceEntry{Sent: msg})
+}
+
+// save writes the trace of the chat with the peer named name. The name is
+// chosen by the remote peer, so the file is named after its digest.
+func (r *chatTraceRecorder) save(name string) error {
+	if r == nil {
+		return nil
+	}
+	r.Lock()
+	defer r.Unlock()
+	data, err := json.MarshalIndent(&r.trace, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error marshalling chat trace: %s", err)
+	}
+	path := filepath.Join(r.dir, fmt.Sprintf("%s-%d.json", chatTracePrefix(name), time.Now().UnixNano()))
+	if err = ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("Error writing chat trace: %s", err)
+	}
+	peerLogger.Info("Wrote trace of chat with %s to %s", name, path)
+	return nil
+}
+
+// chatTracePrefix is the beginning of the name of the trace files of the
+// chats with the peer named name
+func chatTracePrefix(name string) string {
+	digest := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(digest[:8])
+}
+
+// stateHash digests the state of the handler that received messages change
+func (d *Handler) stateHash() string {
+	d.chatMutex.Lock()
+	compression := d.compression
+	d.chatMutex.Unlock()
+	h := sha256.New()
+	fmt.Fprintf(h, "%s/%t/%s/%d/", d.FSM.Current(), d.registered, compression, atomic.LoadUint32(&d.protocolVersion))
+	if d.ToPeerEndpoint != nil {
+		data, _ := proto.Marshal(d.ToPeerEndpoint)
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// replayChatStream collects the messages sent by a replayed handler
+type replayChatStream struct {
+	sync.Mutex
+	sent []*pb.Message
+}
+
+// Send records msg as it was before compression, the way the recorder did
+func (s *replayChatStream) Send(msg *pb.Message) error {
+	if err := decompressMessage(msg); err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+func (s *replayChatStream) Recv() (*pb.Message, error) {
+	select {}
+}
+
+// responses returns the messages sent since the last call, but for the
+// unsolicited ones
+func (s *replayChatStream) responses() []*pb.Message {
+	s.Lock()
+	defer s.Unlock()
+	var responses []*pb.Message
+	for _, msg := range s.sent {
+		if !unsolicited(msg.Type) {
+			responses = append(responses, msg)
+		}
+	}
+	s.sent = nil
+	return responses
+}
+
+// replayCoordinator answers the discovery requests of a replayed handler
+// from the trace and delegates everything else to the embedded coordinator
+type replayCoordinator struct {
+	MessageHandlerCoordinator
+	trace   *ChatTrace
+	current *ChatTraceEntry
+}
+
+// NewOpenchainDiscoveryHello returns the DISC_HELLO sent in the trace
+func (c *replayCoordinator) NewOpenchainDiscoveryHello() (*pb.Message, error) {
+	for _, entry := range c.trace.Entries {
+		if entry.Sent != nil && entry.Sent.Type == pb.Message_DISC_HELLO {
+			return entry.Sent, nil
+		}
+		for _, msg := range entry.Responses {
+			if msg.Type == pb.Message_DISC_HELLO {
+				return msg, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("No %s sent in the chat trace", pb.Message_DISC_HELLO)
+}
+
+// GetPeerEndpoint returns the endpoint of the DISC_HELLO sent in the trace
+func (c *replayCoordinator) GetPeerEndpoint() (*pb.PeerEndpoint, error) {
+	msg, err := c.NewOpenchainDiscoveryHello()
+	if err != nil {
+		return nil, err
+	}
+	hello := &pb.HelloMessage{}
+	if err = proto.Unmarshal(msg.Payload, hello); err != nil {
+		return nil, fmt.Errorf("Error unmarshalling HelloMessage: %s", err)
+	}
+	return hello.PeerEndpoint, nil
+}
+
+// GetPeers returns the peers of the DISC_PEERS sent in response to the
+// message being replayed, without the local peer the handler appends
+func (c *replayCoordinator) GetPeers() (*pb.PeersMessage, error) {
+	peers := &pb.PeersMessage{}
+	if c.current == nil {
+		return peers, nil
+	}
+	for _, msg := range c.current.Responses {
+		if msg.Type != pb.Message_DISC_PEERS {
+			continue
+		}
+		if err := proto.Unmarshal(msg.Payload, peers); err != nil {
+			return nil, fmt.Errorf("Error unmarshalling PeersMessage: %s", err)
+		}
+		if len(peers.Peers) > 0 {
+			peers.Peers = peers.Peers[:len(peers.Peers)-1]
+		}
+	}
+	return peers, nil
+}
+
+func (c *replayCoordinator) RegisterHandler(messageHandler MessageHandler) error {
+	return nil
+}
+
+func (c *replayCoordinator) DeregisterHandler(messageHandler MessageHandler) error {
+	return nil
+}
+
+func (c *replayCoordinator) PeersDiscovered(peers *pb.PeersMessage) error {
+	return nil
+}
+
+func (c *replayCoordinator) GetSecHelper() crypto.Peer {
+	return nil
+}
+
+// ReplayChatTrace replays the messages received in trace against a new
+// Handler. It returns an error describing the first message whose FSM
+// transition, resulting handler state or responses differ from the recorded
+// ones. Discovery requests of the handler are answered from the trace, all
+// other requests go to coord, which may be nil for traces that only contain
+// discovery messages. Messages are recorded decrypted, so traces are replayed
+// with peer.discovery.encryption disabled.
+func ReplayChatTrace(trace *ChatTrace, coord MessageHandlerCoordinator) error {
+	stream := &replayChatStream{}
+	replayCoord := &replayCoordinator{MessageHandlerCoordinator: coord, trace: trace}
+	messageHandler, err := NewPeerHandler(replayCoord, stream, trace.Initiated, nil)
+	if err != nil {
+		return fmt.Errorf("Error creating handler: %s", err)
+	}
+	handler := messageHandler.(*Handler)
+	defer handler.Stop()
+	// Only responses are compared, the DISC_HELLO of an initiated chat is not one
+	stream.responses()
+
+	for i, entry := range trace.Entries {
+		if entry.Received == nil {
+			continue
+		}
+		replayCoord.current = entry
+		src := handler.FSM.Current()
+		var errString string
+		if err = handler.HandleMessage(proto.Clone(entry.Received).(*pb.Message)); err != nil {
+			errString = err.Error()
+		}
+		if dst := handler.FSM.Current(); src != entry.SrcState || dst != entry.DstState || errString != entry.Error {
+			return fmt.Errorf("Entry %d (%s): expected transition %s -> %s (error %q), got %s -> %s (error %q)", i, entry.Received.Type, entry.SrcState, entry.DstState, entry.Error, src, dst, errString)
+		}
+		if hash := handler.stateHash(); hash != entry.StateHash {
+			return fmt.Errorf("Entry %d (%s): expected handler state %s, got %s", i, entry.Received.Type, entry.StateHash, hash)
+		}
+		responses := stream.responses()
+		if len(responses) != len(entry.Responses) {
+			return fmt.Errorf("Entry %d (%s): expected %d responses, got %d", i, entry.Received.Type, len(entry.Responses), len(responses))
+		}
+		for j, expected := range entry.Responses {
+			if responses[j].Type != expected.Type || !bytes.Equal(responses[j].Payload, expected.Payload) {
+				return fmt.Errorf("Entry %d (%s): response %d differs, expected %s with payload %x, got %s with payload %x", i, entry.Received.Type, j, expected.Type, expected.Payload, responses[j].Type, responses[j].Payload)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/core/crypto"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+var updateChatTraces = flag.Bool("chattrace.update", false, "rewrite the golden chat traces in testdata/chattrace")
+
+// discoveryCoordinator is the coordinator of a peer that only does discovery
+type discoveryCoordinator struct {
+	MessageHandlerCoordinator
+	endpoint *pb.PeerEndpoint
+	peers    []*pb.PeerEndpoint
+}
+
+func (c *discoveryCoordinator) GetPeerEndpoint() (*pb.PeerEndpoint, error) {
+	return c.endpoint, nil
+}
+
+func (c *discoveryCoordinator) NewOpenchainDiscoveryHello() (*pb.Message, error) {
+	data, err := proto.Marshal(&pb.HelloMessage{PeerEndpoint: c.endpoint, BlockchainInfo: &pb.BlockchainInfo{Height: 1}, ProtocolVersion: ProtocolVersion, MinProtocolVersion: MinProtocolVersion})
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Message{Type: pb.Message_DISC_HELLO, Payload: data}, nil
+}
+
+func (c *discoveryCoordinator) GetPeers() (*pb.PeersMessage, error) {
+	return &pb.PeersMessage{Peers: c.peers}, nil
+}
+
+func (c *discoveryCoordinator) RegisterHandler(messageHandler MessageHandler) error {
+	return nil
+}
+
+func (c *discoveryCoordinator) DeregisterHandler(messageHandler MessageHandler) error {
+	return nil
+}
+
+func (c *discoveryCoordinator) PeersDiscovered(peers *pb.PeersMessage) error {
+	return nil
+}
+
+func (c *discoveryCoordinator) GetSecHelper() crypto.Peer {
+	return nil
+}
+
+// pipeChatStream delivers the messages sent on it to the inbox of the other end
+type pipeChatStream struct {
+	inbox chan *pb.Message
+}
+
+func (s *pipeChatStream) Send(msg *pb.Message) error {
+	s.inbox <- proto.Clone(msg).(*pb.Message)
+	return nil
+}
+
+func (s *pipeChatStream) Recv() (*pb.Message, error) {
+	select {}
+}
+
+// pump hands the messages of inbox to handler and signals each one handled
+func pump(handler MessageHandler, inbox <-chan *pb.Message, handled chan<- struct{}) {
+	for msg := range inbox {
+		handler.HandleMessage(msg)
+		handled <- struct{}{}
+	}
+}
+
+func setupChatTraceConfig(dir string) {
+	viper.Set("peer.chatTrace.dir", dir)
+	viper.Set("peer.discovery.period", "1h")
+	viper.Set("peer.keepalive.interval", "0")
+	viper.Set("peer.compression.enabled", "false")
+	viper.Set("peer.discovery.encryption.enabled", "false")
+	viper.Set("security.enabled", "false")
+}
+
+// recordChat runs a discovery chat between two handlers and returns the
+// traces recorded by the initiating and the responding peer
+func recordChat(t *testing.T) (*ChatTrace, *ChatTrace) {
+	dir, err := ioutil.TempDir("", "chattrace")
+	if err != nil {
+		t.Fatalf("Error creating directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	setupChatTraceConfig(dir)
+	defer viper.Set("peer.chatTrace.dir", "")
+
+	initiator := &pb.PeerEndpoint{ID: &pb.PeerID{Name: "initiator"}, Address: "127.0.0.1:30304", Type: pb.PeerEndpoint_VALIDATOR}
+	responder := &pb.PeerEndpoint{ID: &pb.PeerID{Name: "responder"}, Address: "127.0.0.1:30305", Type: pb.PeerEndpoint_VALIDATOR}
+	other := &pb.PeerEndpoint{ID: &pb.PeerID{Name: "other"}, Address: "127.0.0.1:30306", Type: pb.PeerEndpoint_NON_VALIDATOR}
+
+	toInitiator := &pipeChatStream{inbox: make(chan *pb.Message, 10)}
+	toResponder := &pipeChatStream{inbox: make(chan *pb.Message, 10)}
+	initiatorHandler, err := NewPeerHandler(&discoveryCoordinator{endpoint: initiator}, toResponder, true, nil)
+	if err != nil {
+		t.Fatalf("Error creating handler: %s", err)
+	}
+	responderHandler, err := NewPeerHandler(&discoveryCoordinator{endpoint: responder, peers: []*pb.PeerEndpoint{other}}, toInitiator, false, nil)
+	if err != nil {
+		t.Fatalf("Error creating handler: %s", err)
+	}
+	handled := make(chan struct{}, 10)
+	go pump(initiatorHandler, toInitiator.inbox, handled)
+	go pump(responderHandler, toResponder.inbox, handled)
+	wait := func(n int) {
+		for i := 0; i < n; i++ {
+			select {
+			case <-handled:
+			case <-time.After(time.Second):
+				t.Fatal("Timed out waiting for the chat")
+			}
+		}
+	}
+
+	// HELLO both ways, then a discovery round and a keepalive round
+	wait(2)
+	initiatorHandler.SendMessage(&pb.Message{Type: pb.Message_DISC_GET_PEERS})
+	wait(2)
+	initiatorHandler.SendMessage(&pb.Message{Type: pb.Message_DISC_PING})
+	wait(2)
+	// A second HELLO, which the FSM refuses once established
+	responderHandler.SendMessage(&pb.Message{Type: pb.Message_DISC_HELLO, Payload: []byte("again")})
+	wait(1)
+
+	initiatorHandler.Stop()
+	responderHandler.Stop()
+	initiatorTraces, _ := filepath.Glob(filepath.Join(dir, chatTracePrefix("responder")+"-*.json"))
+	responderTraces, _ := filepath.Glob(filepath.Join(dir, chatTracePrefix("initiator")+"-*.json"))
+	if len(initiatorTraces) != 1 || len(responderTraces) != 1 {
+		t.Fatalf("Expected a trace of each end of the chat, got %v and %v", initiatorTraces, responderTraces)
+	}
+	initiatorTrace, err := LoadChatTrace(initiatorTraces[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	responderTrace, err := LoadChatTrace(responderTraces[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *updateChatTraces {
+		for name, path := range map[string]string{"initiator.json": initiatorTraces[0], "responder.json": responderTraces[0]} {
+			data, _ := ioutil.ReadFile(path)
+			if err = ioutil.WriteFile(filepath.Join("testdata", "chattrace", name), data, 0644); err != nil {
+				t.Fatalf("Error updating golden chat trace: %s", err)
+			}
+		}
+	}
+	return initiatorTrace, responderTrace
+}
+
+func TestChatTrace_RecordAndReplay(t *testing.T) {
+	initiatorTrace, responderTrace := recordChat(t)
+	if !initiatorTrace.Initiated || responderTrace.Initiated {
+		t.Fatal("Expected the traces to record which end initiated the chat")
+	}
+	// HELLO, PEERS, PONG; the responder also got a second HELLO it refused
+	if received := countReceived(initiatorTrace); received != 4 {
+		t.Fatalf("Expected 4 messages received by the initiator, got %d", received)
+	}
+	last := initiatorTrace.Entries[len(initiatorTrace.Entries)-1]
+	if last.Received == nil || last.Received.Type != pb.Message_DISC_HELLO || last.Error == "" {
+		t.Fatalf("Expected the second %s to be refused, got %+v", pb.Message_DISC_HELLO, last)
+	}
+	for _, trace := range []*ChatTrace{initiatorTrace, responderTrace} {
+		if err := ReplayChatTrace(trace, nil); err != nil {
+			t.Fatalf("Error replaying the chat just recorded: %s", err)
+		}
+	}
+}
+
+func countReceived(trace *ChatTrace) int {
+	n := 0
+	for _, entry := range trace.Entries {
+		if entry.Received != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// TestChatTrace_Golden replays the traces of known-good chats, run with
+// -chattrace.update to record them again after an intended protocol change
+func TestChatTrace_Golden(t *testing.T) {
+	setupChatTraceConfig("")
+	paths, _ := filepath.Glob(filepath.Join("testdata", "chattrace", "*.json"))
+	if len(paths) == 0 {
+		t.Fatal("Expected golden chat traces in testdata/chattrace")
+	}
+	for _, path := range paths {
+		trace, err := LoadChatTrace(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ReplayChatTrace(trace, nil); err != nil {
+			t.Errorf("Chat trace %s no longer replays: %s", path, err)
+		}
+	}
+}
+
+func TestChatTrace_ReplayDetectsDifferences(t *testing.T) {
+	setupChatTraceConfig("")
+	for _, tamper := range []func(entry *ChatTraceEntry){
+		func(entry *ChatTraceEntry) { entry.DstState = "created" },
+		func(entry *ChatTraceEntry) { entry.StateHash = "" },
+		func(entry *ChatTraceEntry) { entry.Responses = nil },
+	} {
+		trace, err := LoadChatTrace(filepath.Join("testdata", "chattrace", "responder.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The first entry is the HELLO, answered with a HELLO
+		tamper(trace.Entries[0])
+		if err = ReplayChatTrace(trace, nil); err == nil {
+			t.Fatal("Expected the replay of a tampered trace to fail")
+		}
+	}
+}
+
+func TestChatTrace_SaveStaysInDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chattrace")
+	if err != nil {
+		t.Fatalf("Error creating directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	recorder := newChatTraceRecorder(filepath.Join(dir, "traces"), 0, false)
+	if err = os.Mkdir(recorder.dir, 0755); err != nil {
+		t.Fatalf("Error creating directory: %s", err)
+	}
+	if err = recorder.save("../escaped"); err != nil {
+		t.Fatal(err)
+	}
+	if escaped, _ := filepath.Glob(filepath.Join(dir, "escaped*")); len(escaped) != 0 {
+		t.Fatalf("Expected the trace to be written in the trace directory, got %v", escaped)
+	}
+	if saved, _ := filepath.Glob(filepath.Join(recorder.dir, chatTracePrefix("../escaped")+"-*.json")); len(saved) != 1 {
+		t.Fatalf("Expected the trace in the trace directory, got %v", saved)
+	}
+}
+
+func TestChatTrace_Truncated(t *testing.T) {
+	recorder := newChatTraceRecorder("unused", 3, false)
+	recorder.received(&pb.Message{Type: pb.Message_DISC_HELLO})
+	recorder.sent(&pb.Message{Type: pb.Message_DISC_HELLO})
+	recorder.handled("created", "established", nil, "")
+	recorder.received(&pb.Message{Type: pb.Message_DISC_GET_PEERS})
+	recorder.sent(&pb.Message{Type: pb.Message_DISC_PEERS})
+	recorder.handled("established", "established", nil, "")
+	recorder.sent(&pb.Message{Type: pb.Message_DISC_PING})
+	if !recorder.trace.Truncated {
+		t.Fatal("Expected the trace to be truncated")
+	}
+	// The GET_PEERS whose response was not recorded is dropped
+	if len(recorder.trace.Entries) != 1 || len(recorder.trace.Entries[0].Responses) != 1 {
+		t.Fatalf("Expected the HELLO entry only, got %+v", recorder.trace.Entries)
+	}
+}
@@ -0,0 +1,163 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/spf13/viper"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// payloadCodec compresses and decompresses Message payloads
+type payloadCodec interface {
+	compress(data []byte) ([]byte, error)
+	decompress(data []byte, maxSize int) ([]byte, error)
+}
+
+// maxDecompressedSizeDefault is the size a decompressed payload may have when
+// peer.compression.maxDecompressedSize is not set, that of the largest message
+// gRPC receives
+const maxDecompressedSizeDefault = 4 * 1024 * 1024
+
+// payloadCodecs holds the compression algorithms this peer can handle.
+// SNAPPY is part of the wire protocol but is not available in this build.
+var payloadCodecs = map[pb.Message_Compression]payloadCodec{
+	pb.Message_GZIP: gzipCodec{},
+}
+
+// compressionPreference is the order in which algorithms are picked when
+// both ends of a stream support more than one
+var compressionPreference = []pb.Message_Compression{pb.Message_SNAPPY, pb.Message_GZIP}
+
+type gzipCodec struct{}
+
+func (gzipCodec) compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) decompress(data []byte, maxSize int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	payload, err := ioutil.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) > maxSize {
+		return nil, fmt.Errorf("payload exceeds %d bytes once decompressed", maxSize)
+	}
+	return payload, nil
+}
+
+// getMaxDecompressedSize returns the size a payload may have once decompressed
+func getMaxDecompressedSize() int {
+	if size := viper.GetInt("peer.compression.maxDecompressedSize"); size > 0 {
+		return size
+	}
+	return maxDecompressedSizeDefault
+}
+
+// capabilityFor returns the PeerEndpoint capability bit for the algorithm
+func capabilityFor(c pb.Message_Compression) uint32 {
+	return 1 << uint32(c)
+}
+
+// getLocalCapabilities returns the capability bits advertised in this peer's
+// PeerEndpoint, based upon the peer.compression configuration
+func getLocalCapabilities() uint32 {
+	if !viper.GetBool("peer.compression.enabled") {
+		return 0
+	}
+	var caps uint32
+	for c := range payloadCodecs {
+		caps |= capabilityFor(c)
+	}
+	return caps
+}
+
+// negotiateCompression returns the preferred algorithm supported by both the
+// local and remote capability sets, or NONE if there is none in common
+func negotiateCompression(local, remote uint32) pb.Message_Compression {
+	for _, c := range compressionPreference {
+		if local&remote&capabilityFor(c) != 0 {
+			return c
+		}
+	}
+	return pb.Message_NONE
+}
+
+// compressMessage returns a copy of msg with its payload compressed using the
+// supplied algorithm. The original message is returned unchanged if the payload
+// is smaller than minSize or compression would not make it smaller.
+func compressMessage(msg *pb.Message, compression pb.Message_Compression, minSize int) (*pb.Message, error) {
+	if compression == pb.Message_NONE || msg.Compression != pb.Message_NONE || len(msg.Payload) < minSize {
+		return msg, nil
+	}
+	codec, ok := payloadCodecs[compression]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported compression algorithm: %s", compression)
+	}
+	payload, err := codec.compress(msg.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("Error compressing %s payload: %s", msg.Type, err)
+	}
+	if len(payload) >= len(msg.Payload) {
+		return msg, nil
+	}
+	compressed := *msg
+	compressed.Payload = payload
+	compressed.Compression = compression
+	return &compressed, nil
+}
+
+// decompressMessage restores the payload of a compressed message in place so
+// that handlers only ever see the original payload
+func decompressMessage(msg *pb.Message) error {
+	if msg.Compression == pb.Message_NONE {
+		return nil
+	}
+	codec, ok := payloadCodecs[msg.Compression]
+	if !ok {
+		return fmt.Errorf("Unsupported compression algorithm %s on %s message", msg.Compression, msg.Type)
+	}
+	payload, err := codec.decompress(msg.Payload, getMaxDecompressedSize())
+	if err != nil {
+		return fmt.Errorf("Error decompressing %s payload: %s", msg.Type, err)
+	}
+	msg.Payload = payload
+	msg.Compression = pb.Message_NONE
+	return nil
+}
@@ -0,0 +1,182 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// connectionManager keeps an outbound chat open to each desired peer address.
+// When a chat ends or cannot be established the address is dialed again after
+// an exponential backoff with jitter. Static addresses (root nodes) are dialed
+// forever, discovered addresses are dropped after maxAttempts consecutive
+// failures (0 is unlimited). A chat closed while idle is only dialed again on
+// demand.
+type connectionManager struct {
+	sync.Mutex
+	desired map[string]bool          // address -> static
+	idle    map[string]chan struct{} // address -> closed on demand
+
+	// chat dials address and blocks until the chat ends. A nil error means the
+	// chat was established, so the backoff is reset.
+	chat func(address string) error
+
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	maxAttempts int
+}
+
+func newConnectionManager(chat func(string) error, minBackoff, maxBackoff time.Duration, maxAttempts int) *connectionManager {
+	if minBackoff <= 0 {
+		minBackoff = time.Second
+	}
+	if maxBackoff < minBackoff {
+		maxBackoff = minBackoff
+	}
+	return &connectionManager{desired: make(map[string]bool), idle: make(map[string]chan struct{}), chat: chat, minBackoff: minBackoff, maxBackoff: maxBackoff, maxAttempts: maxAttempts}
+}
+
+// add starts maintaining a chat with address unless it is already maintained
+func (cm *connectionManager) add(address string, static bool) {
+	if len(address) == 0 {
+		return
+	}
+	cm.Lock()
+	defer cm.Unlock()
+	if wasStatic, ok := cm.desired[address]; ok {
+		cm.desired[address] = wasStatic || static
+		return
+	}
+	cm.desired[address] = static
+	go cm.maintain(address)
+}
+
+// remove stops re-dialing address once its current chat ends
+func (cm *connectionManager) remove(address string) {
+	cm.Lock()
+	defer cm.Unlock()
+	delete(cm.desired, address)
+	cm.wakeInternal(address)
+}
+
+// demand dials address again if its chat was closed while idle
+func (cm *connectionManager) demand(address string) {
+	cm.Lock()
+	defer cm.Unlock()
+	cm.wakeInternal(address)
+}
+
+// demandAll dials again every address whose chat was closed while idle
+func (cm *connectionManager) demandAll() {
+	cm.Lock()
+	defer cm.Unlock()
+	for address := range cm.idle {
+		cm.wakeInternal(address)
+	}
+}
+
+//call this under lock
+func (cm *connectionManager) wakeInternal(address string) {
+	if wake, ok := cm.idle[address]; ok {
+		close(wake)
+		delete(cm.idle, address)
+	}
+}
+
+// waitForDemand blocks until address is demanded or removed
+func (cm *connectionManager) waitForDemand(address string) {
+	cm.Lock()
+	if _, ok := cm.desired[address]; !ok {
+		cm.Unlock()
+		return
+	}
+	wake := make(chan struct{})
+	cm.idle[address] = wake
+	cm.Unlock()
+	peerLogger.Debug("Chat with peer address=%s closed while idle, dialing again on demand", address)
+	<-wake
+}
+
+// addresses returns the addresses currently maintained
+func (cm *connectionManager) addresses() []string {
+	cm.Lock()
+	defer cm.Unlock()
+	addrs := make([]string, 0, len(cm.desired))
+	for address := range cm.desired {
+		addrs = append(addrs, address)
+	}
+	return addrs
+}
+
+func (cm *connectionManager) isDesired(address string) (desired bool, static bool) {
+	cm.Lock()
+	defer cm.Unlock()
+	static, desired = cm.desired[address]
+	return
+}
+
+func (cm *connectionManager) maintain(address string) {
+	backoff := cm.minBackoff
+	failures := 0
+	for {
+		desired, static := cm.isDesired(address)
+		if !desired {
+			peerLogger.Debug("No longer maintaining chat with peer address: %s", address)
+			return
+		}
+		err := cm.chat(address)
+		if err == errChatIdle {
+			failures = 0
+			backoff = cm.minBackoff
+			cm.waitForDemand(address)
+			continue
+		}
+		if err == nil {
+			// The chat was up and has ended, re-dial without growing the backoff
+			failures = 0
+			backoff = cm.minBackoff
+			time.Sleep(withJitter(backoff))
+			continue
+		}
+
+		failures++
+		peerLogger.Error("Chat with peer address=%s failed (attempt %d): %s", address, failures, err)
+		if !static && cm.maxAttempts > 0 && failures >= cm.maxAttempts {
+			peerLogger.Warning("Giving up on discovered peer address=%s after %d attempts", address, failures)
+			cm.remove(address)
+			return
+		}
+		time.Sleep(withJitter(backoff))
+		if backoff *= 2; backoff > cm.maxBackoff {
+			backoff = cm.maxBackoff
+		}
+	}
+}
+
+// withJitter returns a random duration in [d/2, d)
+func withJitter(d time.Duration) time.Duration {
+	half := int64(d / 2)
+	if half <= 0 {
+		return d
+	}
+	return time.Duration(half + rand.Int63n(half))
+}
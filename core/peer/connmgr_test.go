@@ -0,0 +1,124 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type chatRecorder struct {
+	sync.Mutex
+	calls map[string]int
+	fail  bool
+}
+
+func (r *chatRecorder) chat(address string) error {
+	r.Lock()
+	defer r.Unlock()
+	r.calls[address]++
+	if r.fail {
+		return fmt.Errorf("connection refused")
+	}
+	return nil
+}
+
+func (r *chatRecorder) count(address string) int {
+	r.Lock()
+	defer r.Unlock()
+	return r.calls[address]
+}
+
+func TestConnectionManager_Redials(t *testing.T) {
+	r := &chatRecorder{calls: make(map[string]int)}
+	cm := newConnectionManager(r.chat, 2*time.Millisecond, 4*time.Millisecond, 0)
+	cm.add("peer1:30303", true)
+	cm.add("peer1:30303", false)
+	time.Sleep(100 * time.Millisecond)
+	cm.remove("peer1:30303")
+
+	if n := r.count("peer1:30303"); n < 2 {
+		t.Fatalf("Expected peer to be dialed again after the chat ended, dialed %d times", n)
+	}
+	if len(cm.addresses()) != 0 {
+		t.Fatalf("Expected no maintained addresses, got %v", cm.addresses())
+	}
+}
+
+func TestConnectionManager_DropsDiscoveredPeer(t *testing.T) {
+	r := &chatRecorder{calls: make(map[string]int), fail: true}
+	cm := newConnectionManager(r.chat, time.Millisecond, time.Millisecond, 3)
+	cm.add("discovered:30303", false)
+	cm.add("root:30303", true)
+	time.Sleep(100 * time.Millisecond)
+
+	if n := r.count("discovered:30303"); n != 3 {
+		t.Fatalf("Expected discovered peer to be dialed 3 times, dialed %d times", n)
+	}
+	if addrs := cm.addresses(); len(addrs) != 1 || addrs[0] != "root:30303" {
+		t.Fatalf("Expected only the root node to be maintained, got %v", addrs)
+	}
+	if n := r.count("root:30303"); n <= 3 {
+		t.Fatalf("Expected root node to be dialed more than 3 times, dialed %d times", n)
+	}
+	cm.remove("root:30303")
+}
+
+func TestConnectionManager_IdleChatDialedOnDemand(t *testing.T) {
+	var calls int32
+	cm := newConnectionManager(func(address string) error {
+		atomic.AddInt32(&calls, 1)
+		return errChatIdle
+	}, time.Millisecond, time.Millisecond, 0)
+	cm.add("peer1:30303", false)
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("Expected chat closed while idle not to be dialed again, dialed %d times", n)
+	}
+
+	cm.demand("peer1:30303")
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("Expected chat to be dialed again on demand, dialed %d times", n)
+	}
+
+	cm.demandAll()
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Fatalf("Expected chat to be dialed again on demand of all, dialed %d times", n)
+	}
+
+	cm.remove("peer1:30303")
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Fatalf("Expected removed address not to be dialed, dialed %d times", n)
+	}
+}
+
+func TestWithJitter(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if d := withJitter(time.Second); d < 500*time.Millisecond || d >= time.Second {
+			t.Fatalf("Jittered backoff %s out of range", d)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"sync"
+	"time"
+
+	"github.com/looplab/fsm"
+	"github.com/spf13/viper"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// departureRecorder is implemented by coordinators that drop the peers
+// leaving the network from their peer tables
+type departureRecorder interface {
+	peerDeparted(ep *pb.PeerEndpoint)
+}
+
+// departures remembers the addresses of the peers that left the network for
+// ttl, so that the endpoints other peers still advertise for them are not
+// dialed
+type departures struct {
+	sync.Mutex
+	ttl  time.Duration
+	left map[string]time.Time // address -> departure
+}
+
+func newDepartures(ttl time.Duration) *departures {
+	return &departures{ttl: ttl, left: make(map[string]time.Time)}
+}
+
+// add records the departure of the peer at address
+func (d *departures) add(address string) {
+	if d == nil || d.ttl <= 0 {
+		return
+	}
+	d.Lock()
+	defer d.Unlock()
+	d.left[address] = time.Now()
+}
+
+// departed returns whether the peer at address left less than ttl ago
+func (d *departures) departed(address string) bool {
+	if d == nil {
+		return false
+	}
+	d.Lock()
+	defer d.Unlock()
+	for a, at := range d.left {
+		if time.Since(at) >= d.ttl {
+			delete(d.left, a)
+		}
+	}
+	_, ok := d.left[address]
+	return ok
+}
+
+// returned forgets the departure of the peer at address, which connected again
+func (d *departures) returned(address string) {
+	if d == nil {
+		return
+	}
+	d.Lock()
+	defer d.Unlock()
+	delete(d.left, address)
+}
+
+// beforeDisconnect handles the departure of the remote peer, which is
+// shutting down. It is dropped from the peer tables and the chat is closed,
+// which deregisters the handler so that the peer is no longer advertised.
+func (d *Handler) beforeDisconnect(e *fsm.Event) {
+	peerLogger.Info("Peer %s is leaving the network", d.ToPeerEndpoint.GetID())
+	if recorder, ok := d.Coordinator.(departureRecorder); ok && d.ToPeerEndpoint != nil {
+		recorder.peerDeparted(d.ToPeerEndpoint)
+	}
+	d.abortChat()
+}
+
+// peerDeparted drops the peer that left the network: its discovered address is
+// no longer dialed, nor is the address advertised by other peers for
+// peer.discovery.departure.ttl. Root nodes are still dialed.
+func (p *PeerImpl) peerDeparted(ep *pb.PeerEndpoint) {
+	if _, static := p.connMgr.isDesired(ep.Address); !static {
+		p.connMgr.remove(ep.Address)
+	}
+	if ep.ID != nil {
+		p.inventory.remove(ep.ID)
+	}
+	p.departures.add(ep.Address)
+}
+
+// Depart is called as this peer shuts down. It stops dialing other peers and
+// forwarding queued transactions to consensus, sends DISC_DISCONNECT to the
+// peers it chats with, then waits up to peer.discovery.departure.timeout for
+// them to close the chats.
+func (p *PeerImpl) Depart() {
+	for _, address := range p.connMgr.addresses() {
+		p.connMgr.remove(address)
+	}
+	if p.fairQueue != nil {
+		p.fairQueue.stop()
+	}
+	var told []pb.PeerID
+	for id, msgHandler := range p.cloneHandlerMap(pb.PeerEndpoint_UNDEFINED) {
+		if err := msgHandler.SendMessage(&pb.Message{Type: pb.Message_DISC_DISCONNECT}); err != nil {
+			// Peers predating DISC_DISCONNECT notice through keepalive
+			peerLogger.Debug("Could not send %s to %s: %s", pb.Message_DISC_DISCONNECT, id.Name, err)
+			continue
+		}
+		told = append(told, id)
+	}
+	peerLogger.Info("Leaving the network, told %d peers", len(told))
+	deadline := time.Now().Add(viper.GetDuration("peer.discovery.departure.timeout"))
+	for ; time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if p.connectedTo(told) == 0 {
+			return
+		}
+	}
+	peerLogger.Warning("%d peers did not close their chat after %s", p.connectedTo(told), pb.Message_DISC_DISCONNECT)
+}
+
+// connectedTo returns how many of the peers ids a handler is registered for
+func (p *PeerImpl) connectedTo(ids []pb.PeerID) int {
+	p.handlerMap.RLock()
+	defer p.handlerMap.RUnlock()
+	connected := 0
+	for _, id := range ids {
+		if _, ok := p.handlerMap.m[id]; ok {
+			connected++
+		}
+	}
+	return connected
+}
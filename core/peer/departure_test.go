@@ -0,0 +1,130 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/looplab/fsm"
+	"github.com/spf13/viper"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func newDepartureTestHandler(p *PeerImpl, name string, version uint32) (*Handler, *mockChatStream) {
+	stream := &mockChatStream{sent: make(chan *pb.Message, 10)}
+	d := newMeshTestHandler(name, pb.PeerEndpoint_VALIDATOR)
+	d.ToPeerEndpoint.Metadata = &pb.PeerMetadata{}
+	d.ChatStream = newAbortableChatStream(stream)
+	d.Coordinator = p
+	d.protocolVersion = version
+	d.FSM = fsm.NewFSM("established",
+		fsm.Events{
+			{Name: pb.Message_DISC_DISCONNECT.String(), Src: []string{"established"}, Dst: "established"},
+		},
+		fsm.Callbacks{
+			"before_" + pb.Message_DISC_DISCONNECT.String(): func(e *fsm.Event) { d.beforeDisconnect(e) },
+		})
+	return d, stream
+}
+
+func TestHandler_DisconnectDropsPeer(t *testing.T) {
+	p := newMeshTestPeer(0)
+	p.departures = newDepartures(time.Minute)
+	d, _ := newDepartureTestHandler(p, "vp1", ProtocolVersion)
+	if err := p.RegisterHandler(d); err != nil {
+		t.Fatalf("Error registering handler: %s", err)
+	}
+	address := d.ToPeerEndpoint.Address
+	p.connMgr.add(address, false)
+
+	if err := d.handleMessage(&pb.Message{Type: pb.Message_DISC_DISCONNECT}); err != nil {
+		t.Fatalf("Error handling %s: %s", pb.Message_DISC_DISCONNECT, err)
+	}
+	if !d.chatAborted() {
+		t.Fatal("Expected the chat with the departed peer to be closed")
+	}
+	if desired, _ := p.connMgr.isDesired(address); desired {
+		t.Fatal("Expected the departed peer not to be dialed again")
+	}
+	if p.inventory.address(d.ToPeerEndpoint.ID) != "" {
+		t.Fatal("Expected the departed peer to be dropped from the inventory")
+	}
+
+	// Peers that did not see it leave still advertise it
+	p.DeregisterHandler(d)
+	if err := p.PeersDiscovered(&pb.PeersMessage{Peers: []*pb.PeerEndpoint{d.ToPeerEndpoint}}); err != nil {
+		t.Fatalf("Error discovering peers: %s", err)
+	}
+	if desired, _ := p.connMgr.isDesired(address); desired {
+		t.Fatal("Expected the departed peer not to be dialed when advertised")
+	}
+
+	// Until it connects again
+	if err := p.RegisterHandler(d); err != nil {
+		t.Fatalf("Error registering handler: %s", err)
+	}
+	if p.departures.departed(address) {
+		t.Fatal("Expected the departure to be forgotten once the peer connected again")
+	}
+}
+
+func TestHandler_DisconnectKeepsRootNode(t *testing.T) {
+	p := newMeshTestPeer(0)
+	d, _ := newDepartureTestHandler(p, "root", ProtocolVersion)
+	p.connMgr.add(d.ToPeerEndpoint.Address, true)
+	if err := d.handleMessage(&pb.Message{Type: pb.Message_DISC_DISCONNECT}); err != nil {
+		t.Fatalf("Error handling %s: %s", pb.Message_DISC_DISCONNECT, err)
+	}
+	if desired, _ := p.connMgr.isDesired(d.ToPeerEndpoint.Address); !desired {
+		t.Fatal("Expected the root node to be dialed again")
+	}
+}
+
+func TestDepart_TellsPeersAndWaits(t *testing.T) {
+	viper.Set("peer.discovery.departure.timeout", "5s")
+	p := newMeshTestPeer(0)
+	current, currentStream := newDepartureTestHandler(p, "vp1", ProtocolVersion)
+	old, oldStream := newDepartureTestHandler(p, "vp2", 7)
+	for _, d := range []*Handler{current, old} {
+		if err := p.RegisterHandler(d); err != nil {
+			t.Fatalf("Error registering handler: %s", err)
+		}
+	}
+	p.connMgr.add(old.ToPeerEndpoint.Address, true)
+	go func() {
+		// The remote peer closes the chat
+		<-currentStream.sent
+		p.DeregisterHandler(current)
+	}()
+
+	start := time.Now()
+	p.Depart()
+	if elapsed := time.Since(start); elapsed >= 5*time.Second {
+		t.Fatalf("Expected Depart to return once the chat was closed, took %s", elapsed)
+	}
+	if msgs := received(oldStream); len(msgs) != 0 {
+		t.Fatalf("Expected nothing sent to a peer predating %s, got %v", pb.Message_DISC_DISCONNECT, msgs)
+	}
+	if len(p.connMgr.addresses()) != 0 {
+		t.Fatal("Expected no peer to be dialed once departing")
+	}
+}
@@ -0,0 +1,216 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// discoveryKeyLabel separates the discovery key from anything else that may
+// be derived from the same network secret
+const discoveryKeyLabel = "hyperledger fabric discovery payload key"
+
+// discoveryKey is an AES-256-GCM key derived from a network secret
+type discoveryKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+func newDiscoveryKey(secret string) (*discoveryKey, error) {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(discoveryKeyLabel))
+	key := mac.Sum(nil)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// The ID lets the receiver pick the right key while a rotation is in progress
+	// without revealing the key itself
+	id := sha256.Sum256(key)
+	return &discoveryKey{id: hex.EncodeToString(id[:8]), aead: aead}, nil
+}
+
+// discoveryKeyring holds the key used to encrypt discovery payloads and the
+// keys accepted when decrypting them
+type discoveryKeyring struct {
+	current  *discoveryKey
+	accepted map[string]*discoveryKey
+}
+
+// newDiscoveryKeyring returns a keyring encrypting with the key derived from
+// secret, and also accepting payloads encrypted with any of the previous secrets
+func newDiscoveryKeyring(secret string, previous ...string) (*discoveryKeyring, error) {
+	current, err := newDiscoveryKey(secret)
+	if err != nil {
+		return nil, err
+	}
+	ring := &discoveryKeyring{current: current, accepted: map[string]*discoveryKey{current.id: current}}
+	for _, s := range previous {
+		if s == "" {
+			continue
+		}
+		key, err := newDiscoveryKey(s)
+		if err != nil {
+			return nil, err
+		}
+		ring.accepted[key.id] = key
+	}
+	return ring, nil
+}
+
+// isDiscoveryMessage returns true for the message types that reveal the network topology
+func isDiscoveryMessage(t pb.Message_Type) bool {
+	return t == pb.Message_DISC_HELLO || t == pb.Message_DISC_PEERS || t == pb.Message_DISC_BUSY
+}
+
+// seal returns a copy of msg with its payload encrypted. The message type,
+// timestamp and signature are bound to the ciphertext as additional data.
+func (r *discoveryKeyring) seal(msg *pb.Message) (*pb.Message, error) {
+	nonce := make([]byte, r.current.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("Error generating nonce for %s message: %s", msg.Type, err)
+	}
+	payload := &pb.EncryptedPayload{
+		KeyID:      r.current.id,
+		Nonce:      nonce,
+		Ciphertext: r.current.aead.Seal(nil, nonce, msg.Payload, additionalData(msg)),
+	}
+	data, err := proto.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("Error marshalling encrypted %s payload: %s", msg.Type, err)
+	}
+	sealed := *msg
+	sealed.Payload = data
+	sealed.Encrypted = true
+	return &sealed, nil
+}
+
+// open decrypts the payload of msg in place
+func (r *discoveryKeyring) open(msg *pb.Message) error {
+	payload := &pb.EncryptedPayload{}
+	if err := proto.Unmarshal(msg.Payload, payload); err != nil {
+		return fmt.Errorf("Error unmarshalling encrypted %s payload: %s", msg.Type, err)
+	}
+	key, ok := r.accepted[payload.KeyID]
+	if !ok {
+		return fmt.Errorf("Received %s message encrypted with unknown key %s", msg.Type, payload.KeyID)
+	}
+	if len(payload.Nonce) != key.aead.NonceSize() {
+		return fmt.Errorf("Received %s message with invalid nonce size %d", msg.Type, len(payload.Nonce))
+	}
+	plaintext, err := key.aead.Open(nil, payload.Nonce, payload.Ciphertext, additionalData(msg))
+	if err != nil {
+		return fmt.Errorf("Error decrypting %s payload: %s", msg.Type, err)
+	}
+	msg.Payload = plaintext
+	msg.Encrypted = false
+	return nil
+}
+
+func additionalData(msg *pb.Message) []byte {
+	ad := []byte(msg.Type.String() + ":" + msg.Compression.String())
+	if msg.Timestamp != nil {
+		ad = append(ad, []byte(fmt.Sprintf(":%d.%d", msg.Timestamp.Seconds, msg.Timestamp.Nanos))...)
+	}
+	return append(ad, msg.Signature...)
+}
+
+// discoveryKeys caches the keyring built from the peer.discovery.encryption
+// configuration. It is rebuilt whenever the secrets change, which is how keys
+// are rotated: set the new secret and keep the old one in previousSecret until
+// every peer has been reconfigured.
+var discoveryKeys struct {
+	sync.Mutex
+	secret, previous string
+	ring             *discoveryKeyring
+}
+
+// getDiscoveryKeyring returns the keyring for the current configuration, or
+// nil if discovery payloads are not encrypted
+func getDiscoveryKeyring() (*discoveryKeyring, error) {
+	if !viper.GetBool("peer.discovery.encryption.enabled") {
+		return nil, nil
+	}
+	secret := viper.GetString("peer.discovery.encryption.secret")
+	previous := viper.GetString("peer.discovery.encryption.previousSecret")
+	if secret == "" {
+		return nil, fmt.Errorf("Discovery encryption is enabled but peer.discovery.encryption.secret is not set")
+	}
+	discoveryKeys.Lock()
+	defer discoveryKeys.Unlock()
+	if discoveryKeys.ring == nil || discoveryKeys.secret != secret || discoveryKeys.previous != previous {
+		ring, err := newDiscoveryKeyring(secret, previous)
+		if err != nil {
+			return nil, fmt.Errorf("Error deriving discovery keys: %s", err)
+		}
+		peerLogger.Info("Discovery payloads encrypted with key %s", ring.current.id)
+		discoveryKeys.secret, discoveryKeys.previous, discoveryKeys.ring = secret, previous, ring
+	}
+	return discoveryKeys.ring, nil
+}
+
+// encryptDiscoveryMessage returns msg with its payload encrypted if it is a
+// discovery message and encryption is enabled, otherwise msg itself
+func encryptDiscoveryMessage(msg *pb.Message) (*pb.Message, error) {
+	if !isDiscoveryMessage(msg.Type) || msg.Encrypted {
+		return msg, nil
+	}
+	ring, err := getDiscoveryKeyring()
+	if err != nil || ring == nil {
+		return msg, err
+	}
+	return ring.seal(msg)
+}
+
+// decryptDiscoveryMessage decrypts the payload of msg in place. Plaintext
+// discovery messages are rejected when encryption is enabled so that a peer
+// cannot be tricked into revealing the network to an outsider.
+func decryptDiscoveryMessage(msg *pb.Message) error {
+	ring, err := getDiscoveryKeyring()
+	if err != nil {
+		return err
+	}
+	if !msg.Encrypted {
+		if ring != nil && isDiscoveryMessage(msg.Type) {
+			return fmt.Errorf("Received unencrypted %s message while discovery encryption is enabled", msg.Type)
+		}
+		return nil
+	}
+	if ring == nil {
+		return fmt.Errorf("Received encrypted %s message while discovery encryption is disabled", msg.Type)
+	}
+	return ring.open(msg)
+}
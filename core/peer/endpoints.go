@@ -0,0 +1,57 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"github.com/spf13/viper"
+)
+
+// Service groups that can be served on their own endpoint, configured under
+// peer.endpoints. The Peer service is always served on peer.listenAddress.
+const (
+	ClientEndpoint    = "client"
+	ChaincodeEndpoint = "chaincode"
+)
+
+// EndpointListenAddress returns the address the service group listens on,
+// empty if it is served along with the Peer service
+func EndpointListenAddress(group string) string {
+	return viper.GetString("peer.endpoints." + group + ".listenAddress")
+}
+
+// EndpointAddress returns the address to connect to for the service group
+func EndpointAddress(group string) string {
+	if EndpointListenAddress(group) != "" {
+		if address := viper.GetString("peer.endpoints." + group + ".address"); address != "" {
+			return address
+		}
+	}
+	return viper.GetString("peer.address")
+}
+
+// EndpointTLSKey returns the configuration key of the TLS settings of the
+// service group, peer.tls unless the group has its own
+func EndpointTLSKey(group string) string {
+	key := "peer.endpoints." + group + ".tls"
+	if EndpointListenAddress(group) != "" && viper.IsSet(key+".enabled") {
+		return key
+	}
+	return "peer.tls"
+}
@@ -0,0 +1,69 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestServiceEndpoints(t *testing.T) {
+	defer resetTestConfig()
+	viper.SetConfigType("yaml")
+	config := []byte(`
+peer:
+    address: 10.0.0.1:30303
+    endpoints:
+        client:
+            listenAddress: 0.0.0.0:30304
+            address: 192.168.0.1:30304
+            tls:
+                enabled: true
+        chaincode:
+            listenAddress:
+            address: 10.0.0.1:30305
+`)
+	if err := viper.ReadConfig(bytes.NewBuffer(config)); err != nil {
+		t.Fatal(err)
+	}
+
+	if addr := EndpointListenAddress(ClientEndpoint); addr != "0.0.0.0:30304" {
+		t.Errorf("Unexpected client listen address %s", addr)
+	}
+	if addr := EndpointAddress(ClientEndpoint); addr != "192.168.0.1:30304" {
+		t.Errorf("Unexpected client address %s", addr)
+	}
+	if key := EndpointTLSKey(ClientEndpoint); key != "peer.endpoints.client.tls" {
+		t.Errorf("Expected the client TLS settings, got %s", key)
+	}
+
+	// The chaincode group is served with the Peer service
+	if addr := EndpointListenAddress(ChaincodeEndpoint); addr != "" {
+		t.Errorf("Unexpected chaincode listen address %s", addr)
+	}
+	if addr := EndpointAddress(ChaincodeEndpoint); addr != "10.0.0.1:30303" {
+		t.Errorf("Expected the peer address for chaincode, got %s", addr)
+	}
+	if key := EndpointTLSKey(ChaincodeEndpoint); key != "peer.tls" {
+		t.Errorf("Expected the peer TLS settings for chaincode, got %s", key)
+	}
+}
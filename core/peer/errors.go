@@ -21,6 +21,7 @@ package peer
 
 import (
 	"fmt"
+	"time"
 
 	pb "github.com/hyperledger/fabric/protos"
 )
@@ -31,7 +32,7 @@ type DuplicateHandlerError struct {
 }
 
 func (d *DuplicateHandlerError) Error() string {
-	return fmt.Sprintf("Duplicate Handler error: %s", d.To)
+	return fmt.Sprintf("Duplicate Handler error: %v", d.To)
 }
 
 func newDuplicateHandlerError(msgHandler MessageHandler) error {
@@ -41,3 +42,109 @@ func newDuplicateHandlerError(msgHandler MessageHandler) error {
 	}
 	return &DuplicateHandlerError{To: to}
 }
+
+// HandlerTimeoutError returned when handling a message takes longer than the
+// limit configured for its type
+type HandlerTimeoutError struct {
+	Type  pb.Message_Type
+	Limit time.Duration
+}
+
+func (e *HandlerTimeoutError) Error() string {
+	return fmt.Sprintf("Handling %s took longer than %s", e.Type, e.Limit)
+}
+
+// UnsupportedProtocolVersionError returned when a peer speaks no protocol
+// version in common with this peer
+type UnsupportedProtocolVersionError struct {
+	Min uint32
+	Max uint32
+}
+
+func (e *UnsupportedProtocolVersionError) Error() string {
+	return fmt.Sprintf("No common protocol version, remote peer speaks versions %d to %d, this peer %d to %d", e.Min, e.Max, MinProtocolVersion, ProtocolVersion)
+}
+
+// UnsupportedMessageError returned when a message is sent or received whose
+// type is not available at the protocol version negotiated for the stream
+type UnsupportedMessageError struct {
+	Type    pb.Message_Type
+	Version uint32
+}
+
+func (e *UnsupportedMessageError) Error() string {
+	return fmt.Sprintf("Message type %s is not supported at protocol version %d", e.Type, e.Version)
+}
+
+// ChannelUnsupportedError returned when a request is sent on a channel of a
+// stream whose negotiated protocol version predates channels
+type ChannelUnsupportedError struct {
+	Version uint32
+}
+
+func (e *ChannelUnsupportedError) Error() string {
+	return fmt.Sprintf("Channels are not supported at protocol version %d", e.Version)
+}
+
+// PeerBusyError returned when an inbound connection is refused because the
+// connection budget is used up. Alternatives are peers to connect to instead.
+type PeerBusyError struct {
+	MaxConnections int
+	Alternatives   *pb.PeersMessage
+}
+
+func (e *PeerBusyError) Error() string {
+	return fmt.Sprintf("Connection budget of %d exhausted", e.MaxConnections)
+}
+
+// Kinds of protocol violations tracked to greylist and blacklist peers
+const (
+	ViolationBadSignature = iota
+	ViolationMalformedPayload
+	ViolationInvalidMessage
+)
+
+// ProtocolViolationError returned when a message received from a peer breaks
+// the protocol. Kind is one of the Violation constants.
+type ProtocolViolationError struct {
+	Kind int
+	Err  error
+}
+
+func (e *ProtocolViolationError) Error() string {
+	return e.Err.Error()
+}
+
+func badSignature(err error) error {
+	return &ProtocolViolationError{Kind: ViolationBadSignature, Err: err}
+}
+
+func malformedPayload(err error) error {
+	return &ProtocolViolationError{Kind: ViolationMalformedPayload, Err: err}
+}
+
+func invalidMessage(err error) error {
+	return &ProtocolViolationError{Kind: ViolationInvalidMessage, Err: err}
+}
+
+// PeerBlacklistedError returned when a chat with a peer is refused or closed
+// because it violated the protocol too often
+type PeerBlacklistedError struct {
+	ID    pb.PeerID
+	Until time.Time
+}
+
+func (e *PeerBlacklistedError) Error() string {
+	return fmt.Sprintf("Peer %s is blacklisted until %s", e.ID.Name, e.Until.Format(time.RFC3339))
+}
+
+// PeerGreylistedError returned when a message from a greylisted peer is
+// dropped because the peer exceeded its message rate
+type PeerGreylistedError struct {
+	ID   pb.PeerID
+	Type pb.Message_Type
+}
+
+func (e *PeerGreylistedError) Error() string {
+	return fmt.Sprintf("Dropped %s from greylisted peer %s exceeding its message rate", e.Type, e.ID.Name)
+}
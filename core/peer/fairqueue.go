@@ -0,0 +1,261 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cast"
+	"github.com/spf13/viper"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// OrgQueueStats are the metrics of the fair scheduler queue of an
+// organization
+type OrgQueueStats struct {
+	Organization string `json:"organization"`
+	Weight       int    `json:"weight"`
+	// transactions waiting for their turn, and the most that ever waited
+	Depth     int    `json:"depth"`
+	MaxDepth  int    `json:"maxDepth"`
+	Queued    uint64 `json:"queued"`
+	Forwarded uint64 `json:"forwarded"`
+	// refused because the queue was full
+	Refused uint64 `json:"refused"`
+}
+
+type orgQueue struct {
+	pending []func()
+	stats   OrgQueueStats
+}
+
+// fairQueue forwards the transactions a validator receives to consensus,
+// taking turns between the organizations that submitted them. On its turn an
+// organization forwards as many transactions as its weight, then passes the
+// turn on. Each organization queues at most maxDepth transactions, so a burst
+// from one of them fills its own queue without delaying the others for
+// longer than a turn.
+type fairQueue struct {
+	sync.Mutex
+	maxDepth      int
+	defaultWeight int
+	weights       map[string]int
+	queues        map[string]*orgQueue
+	// the organizations with transactions pending, the first one has the turn
+	turns []*orgQueue
+	// what the organization with the turn may still forward on it
+	credit int
+	signal chan struct{}
+	// closed by stop, ends run
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// newFairQueue returns nil, scheduling nothing, if maxDepth is not positive.
+// Organizations not in weights, or with a weight that is not positive, have
+// defaultWeight, at least 1.
+func newFairQueue(maxDepth, defaultWeight int, weights map[string]int) *fairQueue {
+	if maxDepth <= 0 {
+		return nil
+	}
+	if defaultWeight <= 0 {
+		defaultWeight = 1
+	}
+	return &fairQueue{maxDepth: maxDepth, defaultWeight: defaultWeight, weights: weights,
+		queues: make(map[string]*orgQueue), signal: make(chan struct{}, 1), done: make(chan struct{})}
+}
+
+// fairQueueWeights reads the weights of organizations from
+// peer.fairness.weights in core.yaml
+func fairQueueWeights() map[string]int {
+	weights := make(map[string]int)
+	// viper lower cases the keys of maps
+	for org, weight := range viper.GetStringMap("peer.fairness.weights") {
+		weights[org] = cast.ToInt(weight)
+	}
+	return weights
+}
+
+// transactionOrganization returns the organization, lower cased, of the
+// certificate transaction is signed with, empty if it has none
+func transactionOrganization(transaction *pb.Transaction) string {
+	if len(transaction.Cert) == 0 {
+		return ""
+	}
+	cert, err := x509.ParseCertificate(transaction.Cert)
+	if err != nil || len(cert.Subject.Organization) == 0 {
+		return ""
+	}
+	return strings.ToLower(cert.Subject.Organization[0])
+}
+
+func (q *fairQueue) queue(org string) *orgQueue {
+	queue, ok := q.queues[org]
+	if !ok {
+		weight := q.weights[org]
+		if weight <= 0 {
+			weight = q.defaultWeight
+		}
+		queue = &orgQueue{stats: OrgQueueStats{Organization: org, Weight: weight}}
+		q.queues[org] = queue
+	}
+	return queue
+}
+
+// push queues forward behind the transactions of org, refusing it if the
+// queue of org is full
+func (q *fairQueue) push(org string, forward func()) error {
+	q.Lock()
+	defer q.Unlock()
+	select {
+	case <-q.done:
+		return fmt.Errorf("The transaction queue is stopped")
+	default:
+	}
+	queue := q.queue(org)
+	if len(queue.pending) >= q.maxDepth {
+		queue.stats.Refused++
+		return fmt.Errorf("The transaction queue of organization %q is full, %d transactions are waiting", org, len(queue.pending))
+	}
+	queue.pending = append(queue.pending, forward)
+	queue.stats.Queued++
+	queue.stats.Depth = len(queue.pending)
+	if queue.stats.Depth > queue.stats.MaxDepth {
+		queue.stats.MaxDepth = queue.stats.Depth
+	}
+	if len(queue.pending) == 1 {
+		q.turns = append(q.turns, queue)
+		if len(q.turns) == 1 {
+			q.credit = queue.stats.Weight
+		}
+	}
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// next returns the transaction to forward next, false if none is pending
+func (q *fairQueue) next() (func(), bool) {
+	q.Lock()
+	defer q.Unlock()
+	if len(q.turns) == 0 {
+		return nil, false
+	}
+	queue := q.turns[0]
+	forward := queue.pending[0]
+	queue.pending[0] = nil
+	queue.pending = queue.pending[1:]
+	queue.stats.Depth = len(queue.pending)
+	queue.stats.Forwarded++
+	q.credit--
+	switch {
+	case len(queue.pending) == 0:
+		q.turns = q.turns[1:]
+	case q.credit == 0:
+		q.turns = append(q.turns[1:], queue)
+	default:
+		return forward, true
+	}
+	if len(q.turns) > 0 {
+		q.credit = q.turns[0].stats.Weight
+	}
+	return forward, true
+}
+
+// run forwards the transactions queued, one at a time, until the queue is
+// stopped. The forwarding functions are called from this goroutine only.
+func (q *fairQueue) run() {
+	for {
+		select {
+		case <-q.done:
+			return
+		default:
+		}
+		forward, ok := q.next()
+		if !ok {
+			select {
+			case <-q.signal:
+			case <-q.done:
+				return
+			}
+			continue
+		}
+		forward()
+	}
+}
+
+// stop ends run once the transaction being forwarded, if any, is. The
+// transactions still queued are not forwarded, and new ones are refused.
+func (q *fairQueue) stop() {
+	q.stopOnce.Do(func() { close(q.done) })
+}
+
+// stats returns the metrics of the queue of every organization seen so far
+func (q *fairQueue) stats() []OrgQueueStats {
+	q.Lock()
+	defer q.Unlock()
+	stats := make([]OrgQueueStats, 0, len(q.queues))
+	for _, queue := range q.queues {
+		stats = append(stats, queue.stats)
+	}
+	sort.Sort(statsByOrganization(stats))
+	return stats
+}
+
+type statsByOrganization []OrgQueueStats
+
+func (s statsByOrganization) Len() int           { return len(s) }
+func (s statsByOrganization) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s statsByOrganization) Less(i, j int) bool { return s[i].Organization < s[j].Organization }
+
+// QueueTransaction hands forward, which sends transaction to consensus, to
+// the fair scheduler of the pool to run on the turn of the organization of
+// transaction. It returns false, leaving forwarding to the caller, when fair
+// scheduling is disabled. A transaction refused because the queue of its
+// organization is full is dropped from the pool so that it is not taken for
+// a duplicate when submitted again.
+func (p *PeerImpl) QueueTransaction(transaction *pb.Transaction, forward func()) (bool, error) {
+	if p.fairQueue == nil {
+		return false, nil
+	}
+	if err := p.fairQueue.push(transactionOrganization(transaction), forward); err != nil {
+		if p.txPool != nil {
+			p.txPool.remove(transaction)
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// FairQueueStats returns the metrics of the fair scheduler queues, nil when
+// fair scheduling is disabled
+func (p *PeerImpl) FairQueueStats() []OrgQueueStats {
+	if p.fairQueue == nil {
+		return nil
+	}
+	return p.fairQueue.stats()
+}
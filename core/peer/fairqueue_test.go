@@ -0,0 +1,177 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// drainFairQueue forwards the transactions queued in turn
+func drainFairQueue(q *fairQueue) {
+	for {
+		forward, ok := q.next()
+		if !ok {
+			return
+		}
+		forward()
+	}
+}
+
+// pushFairQueue queues n transactions of org that append org to order when
+// forwarded
+func pushFairQueue(t *testing.T, q *fairQueue, org string, n int, order *[]string) {
+	for i := 0; i < n; i++ {
+		if err := q.push(org, func() { *order = append(*order, org) }); err != nil {
+			t.Fatalf("Error queuing a transaction of %s: %s", org, err)
+		}
+	}
+}
+
+func TestFairQueue_TakesTurnsByWeight(t *testing.T) {
+	q := newFairQueue(10, 1, map[string]int{"org1": 2})
+	var order []string
+	// org1 bursts before the others submit
+	pushFairQueue(t, q, "org1", 6, &order)
+	pushFairQueue(t, q, "org2", 2, &order)
+	pushFairQueue(t, q, "org3", 1, &order)
+	drainFairQueue(q)
+	expected := []string{"org1", "org1", "org2", "org3", "org1", "org1", "org2", "org1", "org1"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Fatalf("Expected the transactions forwarded in order %v, got %v", expected, order)
+	}
+}
+
+func TestFairQueue_RefusesBeyondMaxDepth(t *testing.T) {
+	q := newFairQueue(2, 1, nil)
+	var order []string
+	pushFairQueue(t, q, "org1", 2, &order)
+	if err := q.push("org1", func() {}); err == nil {
+		t.Fatal("Expected the third transaction of org1 to be refused")
+	}
+	// The queues of other organizations are not affected
+	pushFairQueue(t, q, "org2", 1, &order)
+	q.next()
+
+	expected := []OrgQueueStats{
+		{Organization: "org1", Weight: 1, Depth: 1, MaxDepth: 2, Queued: 2, Forwarded: 1, Refused: 1},
+		{Organization: "org2", Weight: 1, Depth: 1, MaxDepth: 1, Queued: 1},
+	}
+	if stats := q.stats(); !reflect.DeepEqual(stats, expected) {
+		t.Fatalf("Expected stats %+v, got %+v", expected, stats)
+	}
+	if newFairQueue(0, 1, nil) != nil {
+		t.Fatal("Expected no queue without a positive maxDepth")
+	}
+}
+
+func TestFairQueue_Run(t *testing.T) {
+	q := newFairQueue(10, 1, nil)
+	go q.run()
+	forwarded := make(chan string, 2)
+	for _, org := range []string{"org1", "org2"} {
+		org := org
+		if err := q.push(org, func() { forwarded <- org }); err != nil {
+			t.Fatalf("Error queuing a transaction of %s: %s", org, err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-forwarded:
+		case <-time.After(time.Second):
+			t.Fatal("Expected the queued transactions to be forwarded")
+		}
+	}
+}
+
+func TestFairQueue_Stop(t *testing.T) {
+	q := newFairQueue(10, 1, nil)
+	stopped := make(chan struct{})
+	go func() {
+		q.run()
+		close(stopped)
+	}()
+	q.stop()
+	q.stop()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Expected run to return once stopped")
+	}
+	if err := q.push("org1", func() {}); err == nil {
+		t.Fatal("Expected a stopped queue to refuse transactions")
+	}
+}
+
+func TestTransactionOrganization(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "user1", Organization: []string{"Org1"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %s", err)
+	}
+	if org := transactionOrganization(&pb.Transaction{Cert: der}); org != "org1" {
+		t.Fatalf("Expected organization org1, got %q", org)
+	}
+	for _, cert := range [][]byte{nil, []byte("not a certificate")} {
+		if org := transactionOrganization(&pb.Transaction{Cert: cert}); org != "" {
+			t.Fatalf("Expected no organization, got %q", org)
+		}
+	}
+}
+
+func TestQueueTransaction_UnpoolsRefused(t *testing.T) {
+	p := &PeerImpl{txPool: newTxPool(time.Minute, 10, 64, 0), fairQueue: newFairQueue(1, 1, nil)}
+	for _, uuid := range []string{"tx1", "tx2"} {
+		if _, err := p.PoolTransaction(newPoolTestTransaction(uuid)); err != nil {
+			t.Fatalf("Error pooling %s: %s", uuid, err)
+		}
+	}
+	if queued, err := p.QueueTransaction(newPoolTestTransaction("tx1"), func() {}); !queued || err != nil {
+		t.Fatalf("Expected tx1 to be queued, got %t, %v", queued, err)
+	}
+	if _, err := p.QueueTransaction(newPoolTestTransaction("tx2"), func() {}); err == nil {
+		t.Fatal("Expected tx2 to be refused")
+	}
+	// Refused, tx2 can be submitted again
+	if p.txPool.contains(newPoolTestTransaction("tx2")) || !p.txPool.contains(newPoolTestTransaction("tx1")) {
+		t.Fatal("Expected tx2 only to be dropped from the pool")
+	}
+	if queued, err := (&PeerImpl{}).QueueTransaction(newPoolTestTransaction("tx3"), func() {}); queued || err != nil {
+		t.Fatalf("Expected nothing queued with fair scheduling disabled, got %t, %v", queued, err)
+	}
+}
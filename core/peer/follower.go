@@ -0,0 +1,235 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// IsFollower returns true if this peer is a read-only follower. A follower
+// never executes invokes, it replicates the blocks and state of the
+// validators and serves queries and events from its own ledger.
+func IsFollower() bool {
+	return viper.GetBool("peer.follower.enabled") && !viper.GetBool("peer.validator.enabled")
+}
+
+// QueryExecutor executes a query transaction against the local ledger
+type QueryExecutor func(transaction *pb.Transaction) ([]byte, error)
+
+var queryExecutor struct {
+	sync.RWMutex
+	execute QueryExecutor
+}
+
+// SetQueryExecutor sets the function followers execute queries with
+func SetQueryExecutor(execute QueryExecutor) {
+	queryExecutor.Lock()
+	defer queryExecutor.Unlock()
+	queryExecutor.execute = execute
+}
+
+// executeFollowerTransaction executes queries locally and rejects everything
+// else, a follower never changes state on its own
+func (p *PeerImpl) executeFollowerTransaction(transaction *pb.Transaction) *pb.Response {
+	if transaction.Type != pb.Transaction_CHAINCODE_QUERY {
+		return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(fmt.Sprintf("Read-only follower cannot execute %s transactions", transaction.Type))}
+	}
+	queryExecutor.RLock()
+	execute := queryExecutor.execute
+	queryExecutor.RUnlock()
+	if execute == nil {
+		return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte("No query executor set on follower")}
+	}
+	if secHelper := p.GetSecHelper(); secHelper != nil {
+		var err error
+		if transaction, err = secHelper.TransactionPreValidation(transaction); err != nil {
+			return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(err.Error())}
+		}
+	}
+	result, err := execute(transaction)
+	if err != nil {
+		return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(fmt.Sprintf("Error:%s", err))}
+	}
+	return &pb.Response{Status: pb.Response_SUCCESS, Msg: result}
+}
+
+// followerLedger is the part of the ledger a follower replicates into. It is
+// satisfied by *ledger.Ledger.
+type followerLedger interface {
+	GetBlockchainSize() uint64
+	GetBlockByNumber(blockNumber uint64) (*pb.Block, error)
+	ApplyStateDelta(id interface{}, delta *statemgmt.StateDelta) error
+	GetTempStateHash() ([]byte, error)
+	CommitStateDelta(id interface{}) error
+	RollbackStateDelta(id interface{}) error
+	PutRawBlock(block *pb.Block, blockNumber uint64) error
+}
+
+// follower pulls the blocks following the local chain, and their state
+// deltas, from validators and appends them to the local ledger
+type follower struct {
+	ledger    followerLedger
+	lock      sync.Locker
+	batchSize uint64
+	timeout   time.Duration
+}
+
+// sync appends the blocks remote has beyond the local chain, up to batchSize
+// of them, and returns how many were appended. Running out of blocks before
+// the end of the batch means the follower has caught up and is not an error.
+func (f *follower) sync(remote RemoteLedger) (uint64, error) {
+	height := f.ledger.GetBlockchainSize()
+	cursor := NewBlockSyncCursor(height, height+f.batchSize-1)
+	deltas, err := remote.RequestStateDeltas(cursor.Remaining())
+	if err != nil {
+		return 0, err
+	}
+
+	err = SyncBlocks(remote, cursor, f.timeout, func(blockNumber uint64, block *pb.Block) error {
+		var syncDeltas *pb.SyncStateDeltas
+		select {
+		case syncDeltas = <-deltas:
+		case <-time.After(f.timeout):
+		}
+		if syncDeltas == nil {
+			return fmt.Errorf("No state delta received for block %d", blockNumber)
+		}
+		if len(syncDeltas.Deltas) != 1 || syncDeltas.Range.Start != blockNumber {
+			return fmt.Errorf("Unexpected state deltas received for block %d", blockNumber)
+		}
+		return f.append(blockNumber, block, syncDeltas.Deltas[0])
+	}, func(cursor *BlockSyncCursor) {
+		peerLogger.Debug("Follower sync progress %s", cursor)
+	})
+	return cursor.Next - height, err
+}
+
+// append checks that block links to the local chain and that applying the
+// delta results in the state hash recorded in the block before committing
+// either of them
+func (f *follower) append(blockNumber uint64, block *pb.Block, deltaBytes []byte) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	// blocks pushed with STATE_DELTA race with those pulled
+	if height := f.ledger.GetBlockchainSize(); blockNumber != height {
+		return fmt.Errorf("Block %d is not the next block of the local chain, at height %d", blockNumber, height)
+	}
+	if blockNumber > 0 {
+		previous, err := f.ledger.GetBlockByNumber(blockNumber - 1)
+		if err != nil {
+			return fmt.Errorf("Error reading block %d: %s", blockNumber-1, err)
+		}
+		previousHash, err := previous.GetHash()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(previousHash, block.PreviousBlockHash) {
+			return fmt.Errorf("Block %d does not link to the local chain", blockNumber)
+		}
+	}
+
+	delta := statemgmt.NewStateDelta()
+	if err := delta.Unmarshal(deltaBytes); err != nil {
+		return fmt.Errorf("Error unmarshalling state delta for block %d: %s", blockNumber, err)
+	}
+	id := fmt.Sprintf("follower-%d", blockNumber)
+	if err := f.ledger.ApplyStateDelta(id, delta); err != nil {
+		return err
+	}
+	stateHash, err := f.ledger.GetTempStateHash()
+	if err == nil && !bytes.Equal(stateHash, block.StateHash) {
+		err = fmt.Errorf("State hash after applying the delta of block %d does not match the block", blockNumber)
+	}
+	if err != nil {
+		f.ledger.RollbackStateDelta(id)
+		return err
+	}
+	if err = f.ledger.CommitStateDelta(id); err != nil {
+		return err
+	}
+	// Blocks are added after their state so that block event consumers see it
+	return f.ledger.PutRawBlock(block, blockNumber)
+}
+
+// follow keeps the local ledger in step with the validators this peer is
+// connected to until the process exits
+func (p *PeerImpl) follow() {
+	f := &follower{
+		ledger:    p.ledgerWrapper.ledger,
+		lock:      p.ledgerWrapper,
+		batchSize: uint64(viper.GetInt("peer.follower.batchSize")),
+		timeout:   viper.GetDuration("peer.follower.timeout"),
+	}
+	if f.batchSize == 0 {
+		f.batchSize = 1
+	}
+	if from := viper.GetString("peer.bootstrap.from"); from != "" && f.ledger.GetBlockchainSize() == 0 {
+		p.bootstrapFrom(f, from, uint64(viper.GetInt("peer.bootstrap.height")))
+	}
+	// validators push the blocks they commit, see receiveStateDelta
+	p.deltaFollower.set(f)
+	interval := viper.GetDuration("peer.follower.interval")
+	peerLogger.Info("Following validators every %s as a read-only peer", interval)
+	for {
+		synced := p.followOnce(f)
+		// Keep pulling without waiting while there are blocks to catch up on
+		if synced < f.batchSize {
+			time.Sleep(interval)
+		}
+	}
+}
+
+// followOnce syncs from the first connected validator that succeeds, trying
+// the validators that advertised the highest ledgers first
+func (p *PeerImpl) followOnce(f *follower) uint64 {
+	peers, err := p.GetFilteredPeers(PeersOfType(pb.PeerEndpoint_VALIDATOR))
+	if err != nil {
+		peerLogger.Error(fmt.Sprintf("Error getting peers to follow: %s", err))
+		return 0
+	}
+	p.inventory.sortByLedgerHeight(peers.Peers)
+	for _, endpoint := range peers.Peers {
+		remote, err := p.GetRemoteLedger(endpoint.ID)
+		if err != nil {
+			continue
+		}
+		synced, err := f.sync(remote)
+		if synced > 0 {
+			peerLogger.Debug("Appended %d block(s) from %s", synced, endpoint.ID)
+		}
+		if err != nil {
+			peerLogger.Warning("Error following %s: %s", endpoint.ID, err)
+			if synced == 0 {
+				continue
+			}
+		}
+		return synced
+	}
+	return 0
+}
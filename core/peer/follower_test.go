@@ -0,0 +1,206 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/ledger/statemgmt"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// memLedger hashes the keys and values of its state as its state hash
+type memLedger struct {
+	blocks  map[uint64]*pb.Block
+	size    uint64
+	state   *statemgmt.StateDelta
+	pending *statemgmt.StateDelta
+}
+
+func (l *memLedger) GetBlockchainSize() uint64 { return l.size }
+
+func (l *memLedger) GetBlockByNumber(blockNumber uint64) (*pb.Block, error) {
+	block, ok := l.blocks[blockNumber]
+	if !ok {
+		return nil, fmt.Errorf("block %d not found", blockNumber)
+	}
+	return block, nil
+}
+
+func (l *memLedger) ApplyStateDelta(id interface{}, delta *statemgmt.StateDelta) error {
+	l.pending = statemgmt.NewStateDelta()
+	if l.state != nil {
+		l.pending.ApplyChanges(l.state)
+	}
+	l.pending.ApplyChanges(delta)
+	return nil
+}
+
+func (l *memLedger) GetTempStateHash() ([]byte, error) {
+	if l.pending != nil {
+		return l.pending.ComputeCryptoHash(), nil
+	}
+	return l.stateHash(), nil
+}
+
+func (l *memLedger) stateHash() []byte {
+	if l.state == nil {
+		return nil
+	}
+	return l.state.ComputeCryptoHash()
+}
+
+func (l *memLedger) CommitStateDelta(id interface{}) error {
+	l.state, l.pending = l.pending, nil
+	return nil
+}
+
+func (l *memLedger) RollbackStateDelta(id interface{}) error {
+	l.pending = nil
+	return nil
+}
+
+func (l *memLedger) PutRawBlock(block *pb.Block, blockNumber uint64) error {
+	if blockNumber < l.size {
+		return fmt.Errorf("unexpected block number %d", blockNumber)
+	}
+	if l.blocks == nil {
+		l.blocks = make(map[uint64]*pb.Block)
+	}
+	l.blocks[blockNumber] = block
+	l.size = blockNumber + 1
+	return nil
+}
+
+// memRemote serves the blocks and deltas of a validator's chain
+type memRemote struct {
+	deltas [][]byte
+	chain  []*pb.Block
+	// block the state snapshot is taken at
+	snapshotAt int
+}
+
+// newMemRemote builds a chain of n blocks, each setting one key
+func newMemRemote(n int) *memRemote {
+	r := &memRemote{snapshotAt: n - 1}
+	builder := &memLedger{}
+	for i := 0; i < n; i++ {
+		delta := statemgmt.NewStateDelta()
+		delta.Set("cc", fmt.Sprintf("key%d", i), []byte("value"), nil)
+		builder.ApplyStateDelta(i, delta)
+		builder.CommitStateDelta(i)
+		block := &pb.Block{StateHash: builder.stateHash()}
+		if i > 0 {
+			block.PreviousBlockHash, _ = r.chain[i-1].GetHash()
+		}
+		r.chain = append(r.chain, block)
+		r.deltas = append(r.deltas, delta.Marshal())
+	}
+	return r
+}
+
+func (r *memRemote) RequestBlocks(syncRange *pb.SyncBlockRange) (<-chan *pb.SyncBlocks, error) {
+	// Stream in batches of 2, ending early with an empty batch like Handler.sendBlocks
+	c := make(chan *pb.SyncBlocks, 10)
+	n := syncRange.Start
+	for n <= syncRange.End && n < uint64(len(r.chain)) {
+		end := n + 1
+		if end > syncRange.End || end >= uint64(len(r.chain)) {
+			end = n
+		}
+		c <- &pb.SyncBlocks{Range: &pb.SyncBlockRange{Start: n, End: end}, Blocks: r.chain[n : end+1]}
+		n = end + 1
+	}
+	if n <= syncRange.End {
+		c <- &pb.SyncBlocks{Range: &pb.SyncBlockRange{Start: n, End: n}}
+	}
+	return c, nil
+}
+
+func (r *memRemote) RequestStateDeltas(syncRange *pb.SyncBlockRange) (<-chan *pb.SyncStateDeltas, error) {
+	c := make(chan *pb.SyncStateDeltas, 10)
+	for n := syncRange.Start; n <= syncRange.End && n < uint64(len(r.deltas)); n++ {
+		c <- &pb.SyncStateDeltas{Range: &pb.SyncBlockRange{Start: n, End: n}, Deltas: [][]byte{r.deltas[n]}}
+	}
+	return c, nil
+}
+
+// RequestStateSnapshot sends the state at block snapshotAt, one delta per block
+func (r *memRemote) RequestStateSnapshot() (<-chan *pb.SyncStateSnapshot, error) {
+	c := make(chan *pb.SyncStateSnapshot, r.snapshotAt+2)
+	blockNumber := uint64(r.snapshotAt)
+	for i := 0; i <= r.snapshotAt; i++ {
+		c <- &pb.SyncStateSnapshot{Delta: r.deltas[i], Sequence: uint64(i), BlockNumber: blockNumber}
+	}
+	c <- &pb.SyncStateSnapshot{Delta: []byte{}, Sequence: uint64(r.snapshotAt + 1), BlockNumber: blockNumber}
+	return c, nil
+}
+
+func TestFollowerSync(t *testing.T) {
+	remote := newMemRemote(7)
+	local := &memLedger{}
+	f := &follower{ledger: local, lock: &sync.Mutex{}, batchSize: 5, timeout: 10 * time.Millisecond}
+
+	if synced, err := f.sync(remote); err != nil || synced != 5 {
+		t.Fatalf("Expected a full batch of 5 blocks, got %d: %v", synced, err)
+	}
+	if synced, err := f.sync(remote); err != nil || synced != 2 {
+		t.Fatalf("Expected the remaining 2 blocks, got %d: %v", synced, err)
+	}
+	if synced, err := f.sync(remote); err != nil || synced != 0 {
+		t.Fatalf("Expected follower to have caught up, got %d: %v", synced, err)
+	}
+	if local.GetBlockchainSize() != 7 || string(local.stateHash()) != string(remote.chain[6].StateHash) {
+		t.Fatal("Expected local chain and state to match the remote")
+	}
+}
+
+func TestFollowerRejectsMismatchedState(t *testing.T) {
+	remote := newMemRemote(3)
+	remote.chain[1].StateHash = []byte("tampered")
+	local := &memLedger{}
+	f := &follower{ledger: local, lock: &sync.Mutex{}, batchSize: 5, timeout: 10 * time.Millisecond}
+
+	synced, err := f.sync(remote)
+	if err == nil || synced != 1 {
+		t.Fatalf("Expected sync to stop at the tampered block, got %d: %v", synced, err)
+	}
+	if local.GetBlockchainSize() != 1 || string(local.stateHash()) != string(remote.chain[0].StateHash) {
+		t.Fatal("Expected the state delta of the tampered block to be rolled back")
+	}
+}
+
+func TestFollowerRejectsInvokes(t *testing.T) {
+	p := &PeerImpl{}
+	resp := p.executeFollowerTransaction(&pb.Transaction{Type: pb.Transaction_CHAINCODE_INVOKE})
+	if resp.Status != pb.Response_FAILURE {
+		t.Fatal("Expected invoke to be rejected by a follower")
+	}
+
+	defer SetQueryExecutor(nil)
+	SetQueryExecutor(func(transaction *pb.Transaction) ([]byte, error) { return []byte("42"), nil })
+	resp = p.executeFollowerTransaction(&pb.Transaction{Type: pb.Transaction_CHAINCODE_QUERY})
+	if resp.Status != pb.Response_SUCCESS || string(resp.Msg) != "42" {
+		t.Fatalf("Expected query to be executed locally, got %v", resp)
+	}
+}
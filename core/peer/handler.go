@@ -22,12 +22,15 @@ package peer
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/looplab/fsm"
 	"github.com/spf13/viper"
 
+	"github.com/hyperledger/fabric/core/config"
+	"github.com/hyperledger/fabric/core/fsmaudit"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
 	pb "github.com/hyperledger/fabric/protos"
 )
@@ -35,7 +38,9 @@ import (
 // Handler peer handler implementation.
 type Handler struct {
 	chatMutex                     sync.Mutex
+	sendScheduler                 sendScheduler // Orders the senders waiting for ChatStream by message priority
 	ToPeerEndpoint                *pb.PeerEndpoint
+	VerifiedIdentity              *VerifiedIdentity // Proven during DISC_HELLO when peer identities are verified
 	Coordinator                   MessageHandlerCoordinator
 	ChatStream                    ChatStream
 	doneChan                      chan struct{}
@@ -46,6 +51,17 @@ type Handler struct {
 	syncBlocks                    chan *pb.SyncBlocks
 	snapshotRequestHandler        *syncStateSnapshotRequestHandler
 	syncStateDeltasRequestHandler *syncStateDeltasHandler
+	pongChan                      chan struct{}
+	compression                   pb.Message_Compression // Negotiated during DISC_HELLO, guarded by chatMutex
+	compressionMinSize            int
+	protocolVersion               uint32 // Negotiated during DISC_HELLO, accessed atomically
+	trace                         *chatTraceRecorder
+	connectedAt                   time.Time
+	messages                      uint64 // Received, keepalive and discovery excluded, accessed atomically
+	meter                         *bandwidthMeter
+	channels                      requestChannels // Requests sent on a channel awaiting their RESPONSE
+	identityVerified              bool            // The hello of the peer was signed by the identity it claims
+	validator                     bool            // This peer is a validator
 }
 
 // NewPeerHandler returns a new Peer handler
@@ -56,8 +72,16 @@ func NewPeerHandler(coord MessageHandlerCoordinator, stream ChatStream, initiate
 		ChatStream:      stream,
 		initiatedStream: initiatedStream,
 		Coordinator:     coord,
+		connectedAt:     time.Now(),
 	}
 	d.doneChan = make(chan struct{})
+	d.pongChan = make(chan struct{}, 1)
+	d.compressionMinSize = viper.GetInt("peer.compression.minSize")
+	d.validator = viper.GetBool("peer.validator.enabled")
+	d.meter = newBandwidthMeter(viper.GetInt("peer.bandwidth.sendRate"), viper.GetInt("peer.bandwidth.receiveRate"))
+	// Only DISC_HELLO is exchanged until the version is negotiated
+	d.protocolVersion = ProtocolVersion
+	d.trace = newChatTraceRecorder(viper.GetString("peer.chatTrace.dir"), viper.GetInt("peer.chatTrace.maxMessages"), initiatedStream)
 
 	d.snapshotRequestHandler = newSyncStateSnapshotRequestHandler()
 	d.syncStateDeltasRequestHandler = newSyncStateDeltasHandler()
@@ -67,6 +91,16 @@ func NewPeerHandler(coord MessageHandlerCoordinator, stream ChatStream, initiate
 			{Name: pb.Message_DISC_HELLO.String(), Src: []string{"created"}, Dst: "established"},
 			{Name: pb.Message_DISC_GET_PEERS.String(), Src: []string{"established"}, Dst: "established"},
 			{Name: pb.Message_DISC_PEERS.String(), Src: []string{"established"}, Dst: "established"},
+			{Name: pb.Message_DISC_PING.String(), Src: []string{"established"}, Dst: "established"},
+			{Name: pb.Message_DISC_PONG.String(), Src: []string{"established"}, Dst: "established"},
+			{Name: pb.Message_DISC_BUSY.String(), Src: []string{"established"}, Dst: "established"},
+			{Name: pb.Message_SUB.String(), Src: []string{"established"}, Dst: "established"},
+			{Name: pb.Message_UNSUB.String(), Src: []string{"established"}, Dst: "established"},
+			{Name: pb.Message_PUBLISH.String(), Src: []string{"established"}, Dst: "established"},
+			{Name: pb.Message_TX_DIGEST.String(), Src: []string{"established"}, Dst: "established"},
+			{Name: pb.Message_TX_POOL.String(), Src: []string{"established"}, Dst: "established"},
+			{Name: pb.Message_STATE_DELTA.String(), Src: []string{"established"}, Dst: "established"},
+			{Name: pb.Message_DISC_DISCONNECT.String(), Src: []string{"established"}, Dst: "established"},
 			{Name: pb.Message_SYNC_BLOCK_ADDED.String(), Src: []string{"established"}, Dst: "established"},
 			{Name: pb.Message_SYNC_GET_BLOCKS.String(), Src: []string{"established"}, Dst: "established"},
 			{Name: pb.Message_SYNC_BLOCKS.String(), Src: []string{"established"}, Dst: "established"},
@@ -80,6 +114,16 @@ func NewPeerHandler(coord MessageHandlerCoordinator, stream ChatStream, initiate
 			"before_" + pb.Message_DISC_HELLO.String():              func(e *fsm.Event) { d.beforeHello(e) },
 			"before_" + pb.Message_DISC_GET_PEERS.String():          func(e *fsm.Event) { d.beforeGetPeers(e) },
 			"before_" + pb.Message_DISC_PEERS.String():              func(e *fsm.Event) { d.beforePeers(e) },
+			"before_" + pb.Message_DISC_PING.String():               func(e *fsm.Event) { d.beforePing(e) },
+			"before_" + pb.Message_DISC_PONG.String():               func(e *fsm.Event) { d.beforePong(e) },
+			"before_" + pb.Message_DISC_BUSY.String():               func(e *fsm.Event) { d.beforeBusy(e) },
+			"before_" + pb.Message_SUB.String():                     func(e *fsm.Event) { d.beforeSubscribe(e, true) },
+			"before_" + pb.Message_UNSUB.String():                   func(e *fsm.Event) { d.beforeSubscribe(e, false) },
+			"before_" + pb.Message_PUBLISH.String():                 func(e *fsm.Event) { d.beforePublish(e) },
+			"before_" + pb.Message_TX_DIGEST.String():               func(e *fsm.Event) { d.beforeTxDigest(e) },
+			"before_" + pb.Message_TX_POOL.String():                 func(e *fsm.Event) { d.beforeTxPool(e) },
+			"before_" + pb.Message_STATE_DELTA.String():             func(e *fsm.Event) { d.beforeStateDelta(e) },
+			"before_" + pb.Message_DISC_DISCONNECT.String():         func(e *fsm.Event) { d.beforeDisconnect(e) },
 			"before_" + pb.Message_SYNC_BLOCK_ADDED.String():        func(e *fsm.Event) { d.beforeBlockAdded(e) },
 			"before_" + pb.Message_SYNC_GET_BLOCKS.String():         func(e *fsm.Event) { d.beforeSyncGetBlocks(e) },
 			"before_" + pb.Message_SYNC_BLOCKS.String():             func(e *fsm.Event) { d.beforeSyncBlocks(e) },
@@ -120,6 +164,34 @@ func (d *Handler) deregister() error {
 	return err
 }
 
+// abortChat ends the chat of this handler, whose owner then stops the handler
+func (d *Handler) abortChat() {
+	if s, ok := d.ChatStream.(*abortableChatStream); ok {
+		s.Abort()
+	}
+}
+
+// chatAborted reports whether the chat of this handler is ending
+func (d *Handler) chatAborted() bool {
+	if s, ok := d.ChatStream.(*abortableChatStream); ok {
+		select {
+		case <-s.aborted:
+			return true
+		default:
+		}
+	}
+	return false
+}
+
+// abortedChan returns a channel closed when the chat of this handler is
+// ending, nil if the chat cannot be aborted
+func (d *Handler) abortedChan() <-chan struct{} {
+	if s, ok := d.ChatStream.(*abortableChatStream); ok {
+		return s.aborted
+	}
+	return nil
+}
+
 // To return the PeerEndpoint this Handler is connected to.
 func (d *Handler) To() (pb.PeerEndpoint, error) {
 	if d.ToPeerEndpoint == nil {
@@ -130,11 +202,19 @@ func (d *Handler) To() (pb.PeerEndpoint, error) {
 
 // Stop stops this handler, which will trigger the Deregister from the MessageHandlerCoordinator.
 func (d *Handler) Stop() error {
+	d.channels.close()
 	// Deregister the handler
 	err := d.deregister()
 	if err != nil {
 		return fmt.Errorf("Error stopping MessageHandler: %s", err)
 	}
+	name := "unknown"
+	if d.ToPeerEndpoint != nil {
+		name = d.ToPeerEndpoint.ID.Name
+	}
+	if err = d.trace.save(name); err != nil {
+		peerLogger.Error(err.Error())
+	}
 	return nil
 }
 
@@ -150,22 +230,58 @@ func (d *Handler) beforeHello(e *fsm.Event) {
 	helloMessage := &pb.HelloMessage{}
 	err := proto.Unmarshal(msg.Payload, helloMessage)
 	if err != nil {
-		e.Cancel(fmt.Errorf("Error unmarshalling HelloMessage: %s", err))
+		e.Cancel(malformedPayload(fmt.Errorf("Error unmarshalling HelloMessage: %s", err)))
+		return
+	}
+	if helloMessage.PeerEndpoint == nil || helloMessage.PeerEndpoint.ID == nil {
+		e.Cancel(malformedPayload(fmt.Errorf("Received HelloMessage without PeerEndpoint")))
 		return
 	}
-	// Store the PeerEndpoint
-	d.ToPeerEndpoint = helloMessage.PeerEndpoint
 	peerLogger.Debug("Received %s from endpoint=%s", e.Event, helloMessage)
 
 	// If security enabled, need to verify the signature on the hello message
 	if viper.GetBool("security.enabled") {
 		if err := d.Coordinator.GetSecHelper().Verify(helloMessage.PeerEndpoint.PkiID, msg.Signature, msg.Payload); err != nil {
-			e.Cancel(fmt.Errorf("Error Verifying signature for received HelloMessage: %s", err))
+			e.Cancel(badSignature(fmt.Errorf("Error Verifying signature for received HelloMessage: %s", err)))
 			return
 		}
 		peerLogger.Debug("Verified signature for %s", e.Event)
+		d.identityVerified = true
 	}
 
+	verifier, err := getIdentityVerifier()
+	if err != nil {
+		e.Cancel(fmt.Errorf("Error loading peer identity verification: %s", err))
+		return
+	}
+	if verifier != nil {
+		if err := d.verifyIdentity(verifier, helloMessage, msg); err != nil {
+			e.Cancel(badSignature(err))
+			return
+		}
+		d.identityVerified = true
+	}
+
+	// Store the PeerEndpoint only once the signature is verified
+	d.ToPeerEndpoint = helloMessage.PeerEndpoint
+	d.chatMutex.Lock()
+	d.compression = negotiateCompression(getLocalCapabilities(), helloMessage.PeerEndpoint.Capabilities)
+	d.chatMutex.Unlock()
+	peerLogger.Debug("Using %s compression for messages to %s", d.compression, helloMessage.PeerEndpoint.ID)
+
+	if err := verifyPeerMetadata(helloMessage.PeerEndpoint, d.metadataVerifier()); err != nil {
+		peerLogger.Warning(err.Error())
+		d.reportViolation(badSignature(err))
+	}
+
+	version, err := negotiateProtocolVersion(helloMessage)
+	if err != nil {
+		e.Cancel(err)
+		return
+	}
+	atomic.StoreUint32(&d.protocolVersion, version)
+	peerLogger.Debug("Using protocol version %d for messages to %s", version, helloMessage.PeerEndpoint.GetID())
+
 	if d.initiatedStream == false {
 		// Did NOT intitiate the stream, need to send back HELLO
 		peerLogger.Debug("Received %s, sending back %s", e.Event, pb.Message_DISC_HELLO.String())
@@ -183,6 +299,12 @@ func (d *Handler) beforeHello(e *fsm.Event) {
 	// Register
 	err = d.Coordinator.RegisterHandler(d)
 	if err != nil {
+		switch err := err.(type) {
+		case *PeerBusyError:
+			d.refuseBusy(err)
+		case *PeerBlacklistedError:
+			defer d.abortChat()
+		}
 		e.Cancel(fmt.Errorf("Error registering Handler: %s", err))
 	} else {
 		// Registered successfully
@@ -191,12 +313,203 @@ func (d *Handler) beforeHello(e *fsm.Event) {
 	}
 }
 
+// refuseBusy tells the peer that its connection is refused for lack of budget
+// and where else to connect, then ends the chat
+func (d *Handler) refuseBusy(busy *PeerBusyError) {
+	defer d.abortChat()
+	if !messageSupported(pb.Message_DISC_BUSY, atomic.LoadUint32(&d.protocolVersion)) {
+		return
+	}
+	payload, err := proto.Marshal(busy.Alternatives)
+	if err != nil {
+		peerLogger.Error(fmt.Sprintf("Error marshalling %s: %s", pb.Message_DISC_BUSY, err))
+		return
+	}
+	if err = d.SendMessage(&pb.Message{Type: pb.Message_DISC_BUSY, Payload: payload}); err != nil {
+		peerLogger.Warning("Error sending %s to %s: %s", pb.Message_DISC_BUSY, d.ToPeerEndpoint.GetID(), err)
+	}
+}
+
+// beforeBusy handles the refusal of the connection by a busy peer, the peers
+// it suggests are discovered instead
+func (d *Handler) beforeBusy(e *fsm.Event) {
+	msg, ok := e.Args[0].(*pb.Message)
+	if !ok {
+		e.Cancel(fmt.Errorf("Received unexpected message type"))
+		return
+	}
+	peersMessage := &pb.PeersMessage{}
+	if err := proto.Unmarshal(msg.Payload, peersMessage); err != nil {
+		e.Cancel(malformedPayload(fmt.Errorf("Error unmarshalling PeersMessage: %s", err)))
+		return
+	}
+	peerLogger.Warning("Peer %s is busy and refused the connection, it suggested %d other peers", d.ToPeerEndpoint.GetID(), len(peersMessage.Peers))
+	d.Coordinator.PeersDiscovered(peersMessage)
+}
+
+// beforeSubscribe records the topics the remote peer subscribes to with SUB,
+// or no longer subscribes to with UNSUB
+func (d *Handler) beforeSubscribe(e *fsm.Event, subscribe bool) {
+	msg, ok := e.Args[0].(*pb.Message)
+	if !ok {
+		e.Cancel(fmt.Errorf("Received unexpected message type"))
+		return
+	}
+	topicsMessage := &pb.TopicsMessage{}
+	if err := proto.Unmarshal(msg.Payload, topicsMessage); err != nil {
+		e.Cancel(malformedPayload(fmt.Errorf("Error unmarshalling TopicsMessage: %s", err)))
+		return
+	}
+	if subscriber, ok := d.Coordinator.(topicSubscriber); ok && d.ToPeerEndpoint != nil {
+		subscriber.subscribePeer(d.ToPeerEndpoint.ID, topicsMessage.Topics, subscribe)
+	}
+}
+
+// beforePublish hands a message the remote peer published on a topic to the
+// listeners of this peer
+func (d *Handler) beforePublish(e *fsm.Event) {
+	msg, ok := e.Args[0].(*pb.Message)
+	if !ok {
+		e.Cancel(fmt.Errorf("Received unexpected message type"))
+		return
+	}
+	publishMessage := &pb.PublishMessage{}
+	if err := proto.Unmarshal(msg.Payload, publishMessage); err != nil {
+		e.Cancel(malformedPayload(fmt.Errorf("Error unmarshalling PublishMessage: %s", err)))
+		return
+	}
+	if subscriber, ok := d.Coordinator.(topicSubscriber); ok && d.ToPeerEndpoint != nil {
+		subscriber.deliverPublished(d.ToPeerEndpoint.ID, publishMessage)
+	}
+}
+
+// sendTxDigest sends the digest of the transactions pooled by this peer, so
+// that the remote peer rebroadcasts those it lacks
+func (d *Handler) sendTxDigest() {
+	gossiper, ok := d.Coordinator.(transactionGossiper)
+	if !ok {
+		return
+	}
+	digest := gossiper.transactionDigest()
+	if digest == nil {
+		return
+	}
+	payload, err := proto.Marshal(digest)
+	if err != nil {
+		peerLogger.Error(fmt.Sprintf("Error marshalling %s: %s", pb.Message_TX_DIGEST, err))
+		return
+	}
+	err = d.SendMessage(&pb.Message{Type: pb.Message_TX_DIGEST, Payload: payload})
+	if _, unsupported := err.(*UnsupportedMessageError); err != nil && !unsupported {
+		peerLogger.Error(fmt.Sprintf("Error sending %s during handler discovery tick: %s", pb.Message_TX_DIGEST, err))
+	}
+}
+
+// beforeTxDigest rebroadcasts to the remote peer the pooled transactions
+// missing from its digest
+func (d *Handler) beforeTxDigest(e *fsm.Event) {
+	msg, ok := e.Args[0].(*pb.Message)
+	if !ok {
+		e.Cancel(fmt.Errorf("Received unexpected message type"))
+		return
+	}
+	digest := &pb.TransactionDigest{}
+	if err := proto.Unmarshal(msg.Payload, digest); err != nil {
+		e.Cancel(malformedPayload(fmt.Errorf("Error unmarshalling TransactionDigest: %s", err)))
+		return
+	}
+	gossiper, ok := d.Coordinator.(transactionGossiper)
+	if !ok {
+		return
+	}
+	transactions, err := gossiper.missingTransactions(digest)
+	if err != nil {
+		e.Cancel(malformedPayload(err))
+		return
+	}
+	if len(transactions) == 0 {
+		return
+	}
+	payload, err := proto.Marshal(&pb.TransactionBlock{Transactions: transactions})
+	if err != nil {
+		e.Cancel(fmt.Errorf("Error marshalling TransactionBlock: %s", err))
+		return
+	}
+	peerLogger.Debug("Rebroadcasting %d transactions to %s", len(transactions), d.ToPeerEndpoint.GetID())
+	if err = d.SendMessage(&pb.Message{Type: pb.Message_TX_POOL, Payload: payload}); err != nil {
+		e.Cancel(fmt.Errorf("Error sending %s: %s", pb.Message_TX_POOL, err))
+	}
+}
+
+// beforeTxPool hands the transactions rebroadcast by the remote peer to the
+// pool of this peer
+func (d *Handler) beforeTxPool(e *fsm.Event) {
+	msg, ok := e.Args[0].(*pb.Message)
+	if !ok {
+		e.Cancel(fmt.Errorf("Received unexpected message type"))
+		return
+	}
+	block := &pb.TransactionBlock{}
+	if err := proto.Unmarshal(msg.Payload, block); err != nil {
+		e.Cancel(malformedPayload(fmt.Errorf("Error unmarshalling TransactionBlock: %s", err)))
+		return
+	}
+	if gossiper, ok := d.Coordinator.(transactionGossiper); ok && d.ToPeerEndpoint != nil {
+		gossiper.receivePooled(d.ToPeerEndpoint, block.Transactions)
+	}
+}
+
+// handleUnsupported handles the UNSUPPORTED reply of the remote peer to a
+// message this peer sent
+func (d *Handler) handleUnsupported(msg *pb.Message) error {
+	unsupported := &pb.UnsupportedMessage{}
+	if err := proto.Unmarshal(msg.Payload, unsupported); err != nil {
+		return malformedPayload(fmt.Errorf("Error unmarshalling UnsupportedMessage: %s", err))
+	}
+	peerLogger.Warning("Peer %s does not support %s at protocol version %d", d.ToPeerEndpoint.GetID(), unsupported.Type, unsupported.ProtocolVersion)
+	return nil
+}
+
+// rejectUnsupported answers a message whose type is not available at the
+// negotiated protocol version with UNSUPPORTED, if the remote peer
+// understands it, and returns the corresponding UnsupportedMessageError
+func (d *Handler) rejectUnsupported(msg *pb.Message, version uint32) error {
+	unsupportedErr := &UnsupportedMessageError{Type: msg.Type, Version: version}
+	if !messageSupported(pb.Message_UNSUPPORTED, version) {
+		return unsupportedErr
+	}
+	data, err := proto.Marshal(&pb.UnsupportedMessage{Type: msg.Type, ProtocolVersion: version})
+	if err != nil {
+		return fmt.Errorf("Error marshalling UnsupportedMessage: %s", err)
+	}
+	if err = d.SendMessage(&pb.Message{Type: pb.Message_UNSUPPORTED, Payload: data}); err != nil {
+		peerLogger.Error(fmt.Sprintf("Error sending %s to %s: %s", pb.Message_UNSUPPORTED, d.ToPeerEndpoint.GetID(), err))
+	}
+	return unsupportedErr
+}
+
+// metadataVerifier returns the function checking the signature of the
+// PeerMetadata of received endpoints, nil if security is disabled
+func (d *Handler) metadataVerifier() func(vkID, signature, message []byte) error {
+	if !viper.GetBool("security.enabled") {
+		return nil
+	}
+	return d.Coordinator.GetSecHelper().Verify
+}
+
 func (d *Handler) beforeGetPeers(e *fsm.Event) {
 	peersMessage, err := d.Coordinator.GetPeers()
 	if err != nil {
 		e.Cancel(fmt.Errorf("Error Getting Peers: %s", err))
 		return
 	}
+	// Include this peer so that the remote peer learns its current metadata
+	self, err := d.Coordinator.GetPeerEndpoint()
+	if err != nil {
+		e.Cancel(fmt.Errorf("Error Getting Peer Endpoint: %s", err))
+		return
+	}
+	peersMessage.Peers = append(peersMessage.Peers, self)
 	data, err := proto.Marshal(peersMessage)
 	if err != nil {
 		e.Cancel(fmt.Errorf("Error Marshalling PeersMessage: %s", err))
@@ -220,11 +533,17 @@ func (d *Handler) beforePeers(e *fsm.Event) {
 	peersMessage := &pb.PeersMessage{}
 	err := proto.Unmarshal(msg.Payload, peersMessage)
 	if err != nil {
-		e.Cancel(fmt.Errorf("Error unmarshalling PeersMessage: %s", err))
+		e.Cancel(malformedPayload(fmt.Errorf("Error unmarshalling PeersMessage: %s", err)))
 		return
 	}
 
 	peerLogger.Debug("Received PeersMessage with Peers: %s", peersMessage)
+	verify := d.metadataVerifier()
+	for _, peerEndpoint := range peersMessage.Peers {
+		if err := verifyPeerMetadata(peerEndpoint, verify); err != nil {
+			peerLogger.Warning(err.Error())
+		}
+	}
 	d.Coordinator.PeersDiscovered(peersMessage)
 
 	// // Can be used to demonstrate Broadcast function
@@ -234,6 +553,19 @@ func (d *Handler) beforePeers(e *fsm.Event) {
 
 }
 
+func (d *Handler) beforePing(e *fsm.Event) {
+	if err := d.SendMessage(&pb.Message{Type: pb.Message_DISC_PONG}); err != nil {
+		e.Cancel(fmt.Errorf("Error sending response to %s:  %s", e.Event, err))
+	}
+}
+
+func (d *Handler) beforePong(e *fsm.Event) {
+	select {
+	case d.pongChan <- struct{}{}:
+	default:
+	}
+}
+
 func (d *Handler) beforeBlockAdded(e *fsm.Event) {
 	peerLogger.Debug("Received message: %s", e.Event)
 	msg, ok := e.Args[0].(*pb.Message)
@@ -251,29 +583,167 @@ func (d *Handler) when(stateToCheck string) bool {
 
 // HandleMessage handles the Openchain messages for the Peer.
 func (d *Handler) HandleMessage(msg *pb.Message) error {
+	d.meter.receiving(msg, d.abortedChan())
+	if reporter, ok := d.Coordinator.(violationReporter); ok && !d.consensusPeer() && d.offender() != nil {
+		if err := reporter.admitMessage(d.offender(), msg.Type); err != nil {
+			if _, blacklisted := err.(*PeerBlacklistedError); blacklisted {
+				d.abortChat()
+			}
+			return err
+		}
+	}
+	err := d.handleMessage(msg)
+	if violation, ok := err.(*ProtocolViolationError); ok {
+		d.reportViolation(violation)
+	}
+	return err
+}
+
+// reportViolation reports a protocol violation by the remote peer to the
+// coordinator, closing the chat if the peer gets blacklisted. The chats
+// between validators carry consensus and are never closed for violations.
+func (d *Handler) reportViolation(err error) {
+	reporter, ok := d.Coordinator.(violationReporter)
+	offender := d.offender()
+	if !ok || offender == nil {
+		return
+	}
+	if reporter.reportViolation(offender, err.(*ProtocolViolationError)) == pb.PeerStanding_BLACKLISTED && !d.consensusPeer() {
+		d.abortChat()
+	}
+}
+
+// offender returns the endpoint the protocol violations of the remote peer
+// are charged to. The ID the peer declares is only used once its hello is
+// verified by signature, the address always is the host the transport is
+// connected to, nil if unknown.
+func (d *Handler) offender() *pb.PeerEndpoint {
+	remote, ok := d.ChatStream.(interface {
+		RemoteAddress() string
+	})
+	if !ok || remote.RemoteAddress() == "" {
+		return nil
+	}
+	ep := &pb.PeerEndpoint{Address: addressHost(remote.RemoteAddress())}
+	if d.identityVerified && d.ToPeerEndpoint != nil {
+		ep.ID = d.ToPeerEndpoint.ID
+	}
+	return ep
+}
+
+// consensusPeer returns true if both this peer and the verified remote peer
+// are validators, so that the chat carries consensus
+func (d *Handler) consensusPeer() bool {
+	return d.validator && d.identityVerified && d.ToPeerEndpoint != nil && d.ToPeerEndpoint.Type == pb.PeerEndpoint_VALIDATOR
+}
+
+func (d *Handler) handleMessage(msg *pb.Message) error {
 	peerLogger.Debug("Handling Message of type: %s ", msg.Type)
+	// The chat decrypted the payload already, see handleChat
+	if err := decompressMessage(msg); err != nil {
+		return malformedPayload(err)
+	}
+	if err := AuthorizeMessage(d.AuthorizedEndpoint(), msg); err != nil {
+		return err
+	}
+	d.trace.received(msg)
+	if !keepsChatIdle(msg.Type) {
+		atomic.AddUint64(&d.messages, 1)
+	}
+	src := d.FSM.Current()
+	if msg.Type == pb.Message_RESPONSE && msg.Channel != 0 {
+		// Answers a request sent on a channel, outside of the FSM
+		if !d.channels.deliver(msg) {
+			peerLogger.Debug("Dropping %s on channel %d, no request is waiting on it", msg.Type, msg.Channel)
+		}
+		d.recordTransition(msg, src, nil)
+		return nil
+	}
+	if msg.Type == pb.Message_UNSUPPORTED {
+		d.recordTransition(msg, src, nil)
+		return d.handleUnsupported(msg)
+	}
+	if version := atomic.LoadUint32(&d.protocolVersion); !messageSupported(msg.Type, version) {
+		err := d.rejectUnsupported(msg, version)
+		d.recordTransition(msg, src, err)
+		return err
+	}
 	if d.FSM.Cannot(msg.Type.String()) {
-		return fmt.Errorf("Peer FSM cannot handle message (%s) with payload size (%d) while in state: %s", msg.Type.String(), len(msg.Payload), d.FSM.Current())
+		err := invalidMessage(fmt.Errorf("Peer FSM cannot handle message (%s) with payload size (%d) while in state: %s", msg.Type.String(), len(msg.Payload), d.FSM.Current()))
+		d.recordTransition(msg, src, err)
+		return err
 	}
 	err := d.FSM.Event(msg.Type.String(), msg)
 	if err != nil {
 		if _, ok := err.(*fsm.NoTransitionError); !ok {
 			// Only allow NoTransitionError's, all others are considered true error.
-			return fmt.Errorf("Peer FSM failed while handling message (%s): current state: %s, error: %s", msg.Type.String(), d.FSM.Current(), err)
+			cause := err
+			err = fmt.Errorf("Peer FSM failed while handling message (%s): current state: %s, error: %s", msg.Type.String(), d.FSM.Current(), err)
+			if canceled, ok := cause.(*fsm.CanceledError); ok {
+				if violation, ok := canceled.Err.(*ProtocolViolationError); ok {
+					err = &ProtocolViolationError{Kind: violation.Kind, Err: err}
+				}
+			}
+			d.recordTransition(msg, src, err)
+			return err
 			//t.Error("expected only 'NoTransitionError'")
 		}
 	}
+	d.recordTransition(msg, src, nil)
 	return nil
 }
 
+var transitions struct {
+	sync.Once
+	recorder *fsmaudit.Recorder
+}
+
+// GetFSMRecorder returns the recorder of peer handler transitions configured
+// by peer.fsmAudit.size, nil if disabled
+func GetFSMRecorder() *fsmaudit.Recorder {
+	transitions.Do(func() {
+		transitions.recorder = fsmaudit.NewRecorder(viper.GetInt("peer.fsmAudit.size"))
+	})
+	return transitions.recorder
+}
+
+func (d *Handler) recordTransition(msg *pb.Message, src string, err error) {
+	var name string
+	if d.ToPeerEndpoint != nil {
+		name = d.ToPeerEndpoint.ID.Name
+	}
+	GetFSMRecorder().Record(fsmaudit.PeerHandler, name, "", msg.Type.String(), src, d.FSM.Current(), err)
+	if d.trace != nil {
+		d.trace.handled(src, d.FSM.Current(), err, d.stateHash())
+	}
+}
+
 // SendMessage sends a message to the remote PEER through the stream
 func (d *Handler) SendMessage(msg *pb.Message) error {
 	//make sure Sends are serialized. Also make sure everyone uses SendMessage
 	//instead of calling Send directly on the grpc stream
+	d.sendScheduler.acquire(msg.Priority())
+	defer d.sendScheduler.release()
 	d.chatMutex.Lock()
 	defer d.chatMutex.Unlock()
 	peerLogger.Debug("Sending message to stream of type: %s ", msg.Type)
-	err := d.ChatStream.Send(msg)
+	if version := atomic.LoadUint32(&d.protocolVersion); !messageSupported(msg.Type, version) {
+		return &UnsupportedMessageError{Type: msg.Type, Version: version}
+	}
+	d.trace.sent(msg)
+	var err error
+	// The HELLO is always sent as is, compression is only known once both have been exchanged
+	if msg.Type != pb.Message_DISC_HELLO {
+		if msg, err = compressMessage(msg, d.compression, d.compressionMinSize); err != nil {
+			return err
+		}
+	}
+	// Encrypt last, compressing ciphertext gains nothing
+	if msg, err = encryptDiscoveryMessage(msg); err != nil {
+		return err
+	}
+	d.meter.sending(msg, d.abortedChan())
+	err = d.ChatStream.Send(msg)
 	if err != nil {
 		return fmt.Errorf("Error Sending message through ChatStream: %s", err)
 	}
@@ -282,15 +752,58 @@ func (d *Handler) SendMessage(msg *pb.Message) error {
 
 // start starts the Peer server function
 func (d *Handler) start() error {
-	discPeriod := viper.GetDuration("peer.discovery.period")
-	tickChan := time.NewTicker(discPeriod).C
+	discTicker := time.NewTicker(config.GetDuration("peer.discovery.period"))
+	defer func() { discTicker.Stop() }()
+	periodChanged := discoveryPeriodChanged()
+
+	// A nil channel never fires, keepalive stays off if no interval is configured
+	var keepaliveChan, pongTimeoutChan <-chan time.Time
+	var pingSent time.Time
+	keepaliveTimeout := viper.GetDuration("peer.keepalive.timeout")
+	if keepaliveInterval := viper.GetDuration("peer.keepalive.interval"); keepaliveInterval > 0 {
+		keepaliveChan = time.NewTicker(keepaliveInterval).C
+	}
 	peerLogger.Debug("Starting Peer discovery service")
 	for {
 		select {
-		case <-tickChan:
+		case <-keepaliveChan:
+			if pongTimeoutChan != nil {
+				// Still waiting for the PONG of the previous PING
+				continue
+			}
+			if err := d.SendMessage(&pb.Message{Type: pb.Message_DISC_PING}); err != nil {
+				if _, ok := err.(*UnsupportedMessageError); ok {
+					// The remote peer predates keepalive, it would never answer
+					keepaliveChan = nil
+					continue
+				}
+				peerLogger.Error(fmt.Sprintf("Error sending %s during handler keepalive tick: %s", pb.Message_DISC_PING, err))
+			}
+			pingSent = time.Now()
+			pongTimeoutChan = time.After(keepaliveTimeout)
+		case <-d.pongChan:
+			if pongTimeoutChan != nil && d.ToPeerEndpoint != nil {
+				// The round trip scores the peer when connections are limited
+				recordLatency(d.ToPeerEndpoint.ID, time.Since(pingSent))
+			}
+			pongTimeoutChan = nil
+		case <-pongTimeoutChan:
+			peerLogger.Error(fmt.Sprintf("No %s received from %s within %s, closing stream", pb.Message_DISC_PONG, d.ToPeerEndpoint, keepaliveTimeout))
+			// The stream owner stops this handler, which deregisters it and ends this loop through doneChan
+			keepaliveChan, pongTimeoutChan = nil, nil
+			d.abortChat()
+		case <-periodChanged:
+			// The period was reloaded, the next tick is one new period away
+			periodChanged = discoveryPeriodChanged()
+			discTicker.Stop()
+			period := config.GetDuration("peer.discovery.period")
+			discTicker = time.NewTicker(period)
+			peerLogger.Debug("Restarted discovery ticker with period %s", period)
+		case <-discTicker.C:
 			if err := d.SendMessage(&pb.Message{Type: pb.Message_DISC_GET_PEERS}); err != nil {
 				peerLogger.Error(fmt.Sprintf("Error sending %s during handler discovery tick: %s", pb.Message_DISC_GET_PEERS, err))
 			}
+			d.sendTxDigest()
 			// // TODO: For testing only, remove eventually.  Test the blocks transfer functionality.
 			// syncBlocksChannel, _ := d.RequestBlocks(&pb.SyncBlockRange{Start: 0, End: 0})
 			// go func() {
@@ -386,7 +899,7 @@ func (d *Handler) beforeSyncGetBlocks(e *fsm.Event) {
 	syncBlockRange := &pb.SyncBlockRange{}
 	err := proto.Unmarshal(msg.Payload, syncBlockRange)
 	if err != nil {
-		e.Cancel(fmt.Errorf("Error unmarshalling SyncBlockRange in GetBlocks: %s", err))
+		e.Cancel(malformedPayload(fmt.Errorf("Error unmarshalling SyncBlockRange in GetBlocks: %s", err)))
 		return
 	}
 
@@ -404,7 +917,7 @@ func (d *Handler) beforeSyncBlocks(e *fsm.Event) {
 	syncBlocks := &pb.SyncBlocks{}
 	err := proto.Unmarshal(msg.Payload, syncBlocks)
 	if err != nil {
-		e.Cancel(fmt.Errorf("Error unmarshalling SyncBlocks in beforeSyncBlocks: %s", err))
+		e.Cancel(malformedPayload(fmt.Errorf("Error unmarshalling SyncBlocks in beforeSyncBlocks: %s", err)))
 		return
 	}
 
@@ -441,27 +954,52 @@ func (d *Handler) sendBlocks(syncBlockRange *pb.SyncBlockRange) {
 			blockNums = append(blockNums, i)
 		}
 	}
-	for _, currBlockNum := range blockNums {
+	batchSize := viper.GetInt("peer.sync.blocks.batchSize")
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	var batch []*pb.Block
+	for i, currBlockNum := range blockNums {
 		// Get the Block from
 		block, err := d.Coordinator.GetBlockByNumber(currBlockNum)
 		if err != nil {
-			peerLogger.Error(fmt.Sprintf("Error sending blockNum %d: %s", currBlockNum, err))
-			break
-		}
-		// Encode a SyncBlocks into the payload
-		syncBlocks := &pb.SyncBlocks{Range: &pb.SyncBlockRange{Start: currBlockNum, End: currBlockNum}, Blocks: []*pb.Block{block}}
-		syncBlocksBytes, err := proto.Marshal(syncBlocks)
-		if err != nil {
-			peerLogger.Error(fmt.Sprintf("Error marshalling syncBlocks for BlockNum = %d: %s", currBlockNum, err))
-			break
+			peerLogger.Debug("Stopping to send blocks at blockNum %d: %s", currBlockNum, err)
+			// Flush what was read, then an empty batch to let the requester know no more blocks follow
+			if d.sendBlocksBatch(blockNums[i-len(batch):i], batch) == nil {
+				d.sendBlocksBatch([]uint64{currBlockNum}, nil)
+			}
+			return
 		}
-		if err := d.SendMessage(&pb.Message{Type: pb.Message_SYNC_BLOCKS, Payload: syncBlocksBytes}); err != nil {
-			peerLogger.Error(fmt.Sprintf("Error sending blockNum %d: %s", currBlockNum, err))
-			break
+		batch = append(batch, block)
+		if len(batch) == batchSize || i == len(blockNums)-1 {
+			if d.sendBlocksBatch(blockNums[i+1-len(batch):i+1], batch) != nil {
+				return
+			}
+			batch = nil
 		}
 	}
 }
 
+// sendBlocksBatch sends the blocks numbered blockNums in a single SYNC_BLOCKS
+// message, an empty batch only carries the range of blockNums
+func (d *Handler) sendBlocksBatch(blockNums []uint64, blocks []*pb.Block) error {
+	if len(blockNums) == 0 {
+		return nil
+	}
+	// Encode a SyncBlocks into the payload
+	syncBlocks := &pb.SyncBlocks{Range: &pb.SyncBlockRange{Start: blockNums[0], End: blockNums[len(blockNums)-1]}, Blocks: blocks}
+	syncBlocksBytes, err := proto.Marshal(syncBlocks)
+	if err != nil {
+		peerLogger.Error(fmt.Sprintf("Error marshalling syncBlocks for range %d-%d: %s", syncBlocks.Range.Start, syncBlocks.Range.End, err))
+		return err
+	}
+	if err := d.SendMessage(&pb.Message{Type: pb.Message_SYNC_BLOCKS, Payload: syncBlocksBytes}); err != nil {
+		peerLogger.Error(fmt.Sprintf("Error sending blocks %d-%d: %s", syncBlocks.Range.Start, syncBlocks.Range.End, err))
+		return err
+	}
+	return nil
+}
+
 // ----------------------------------------------------------------------------
 //
 //  State sync Snapshot functionality
@@ -503,7 +1041,7 @@ func (d *Handler) beforeSyncStateGetSnapshot(e *fsm.Event) {
 	syncStateSnapshotRequest := &pb.SyncStateSnapshotRequest{}
 	err := proto.Unmarshal(msg.Payload, syncStateSnapshotRequest)
 	if err != nil {
-		e.Cancel(fmt.Errorf("Error unmarshalling SyncStateSnapshotRequest in beforeSyncStateGetSnapshot: %s", err))
+		e.Cancel(malformedPayload(fmt.Errorf("Error unmarshalling SyncStateSnapshotRequest in beforeSyncStateGetSnapshot: %s", err)))
 		return
 	}
 
@@ -523,7 +1061,7 @@ func (d *Handler) beforeSyncStateSnapshot(e *fsm.Event) {
 	syncStateSnapshot := &pb.SyncStateSnapshot{}
 	err := proto.Unmarshal(msg.Payload, syncStateSnapshot)
 	if err != nil {
-		e.Cancel(fmt.Errorf("Error unmarshalling syncStateSnapshot in beforeSyncStateSnapshot: %s", err))
+		e.Cancel(malformedPayload(fmt.Errorf("Error unmarshalling syncStateSnapshot in beforeSyncStateSnapshot: %s", err)))
 		return
 	}
 
@@ -644,7 +1182,7 @@ func (d *Handler) beforeSyncStateGetDeltas(e *fsm.Event) {
 	syncStateDeltasRequest := &pb.SyncStateDeltasRequest{}
 	err := proto.Unmarshal(msg.Payload, syncStateDeltasRequest)
 	if err != nil {
-		e.Cancel(fmt.Errorf("Error unmarshalling SyncStateDeltasRequest in beforeSyncStateGetDeltas: %s", err))
+		e.Cancel(malformedPayload(fmt.Errorf("Error unmarshalling SyncStateDeltasRequest in beforeSyncStateGetDeltas: %s", err)))
 		return
 	}
 
@@ -706,7 +1244,7 @@ func (d *Handler) beforeSyncStateDeltas(e *fsm.Event) {
 	syncStateDeltas := &pb.SyncStateDeltas{}
 	err := proto.Unmarshal(msg.Payload, syncStateDeltas)
 	if err != nil {
-		e.Cancel(fmt.Errorf("Error unmarshalling SyncStateDeltas in beforeSyncStateDeltas: %s", err))
+		e.Cancel(malformedPayload(fmt.Errorf("Error unmarshalling SyncStateDeltas in beforeSyncStateDeltas: %s", err)))
 		return
 	}
 	peerLogger.Debug("Sending state delta onto channel for start = %d and end = %d", syncStateDeltas.Range.Start, syncStateDeltas.Range.End)
@@ -0,0 +1,72 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/hyperledger/fabric/core/fsmtest"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// Discovery requests and keepalives are answered in whatever order they
+// arrive on an established chat
+func TestScriptedDiscoveryChat(t *testing.T) {
+	setupChatTraceConfig("")
+	initiator := &discoveryCoordinator{endpoint: &pb.PeerEndpoint{ID: &pb.PeerID{Name: "initiator"}, Address: "127.0.0.1:30304", Type: pb.PeerEndpoint_VALIDATOR}}
+	responder := &discoveryCoordinator{endpoint: &pb.PeerEndpoint{ID: &pb.PeerID{Name: "responder"}, Address: "127.0.0.1:30305", Type: pb.PeerEndpoint_VALIDATOR}}
+	hello, err := initiator.NewOpenchainDiscoveryHello()
+	if err != nil {
+		t.Fatalf("Error creating hello: %s", err)
+	}
+
+	newRunner := func() (*fsmtest.Runner, func(), error) {
+		stream := fsmtest.NewChatStream()
+		messageHandler, err := NewPeerHandler(responder, stream, false, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		handler := messageHandler.(*Handler)
+		runner := &fsmtest.Runner{
+			Deliver: func(msg proto.Message) error { return handler.HandleMessage(msg.(*pb.Message)) },
+			State:   handler.FSM.Current,
+			Sent:    stream.Sent(),
+		}
+		release := func() {
+			handler.Stop()
+			stream.Close()
+		}
+		return runner, release, runner.Run(fsmtest.Script{
+			fsmtest.Deliver(hello),
+			fsmtest.Expect(fsmtest.MessageOf(pb.Message_DISC_HELLO)),
+			fsmtest.ExpectState("established"),
+		})
+	}
+
+	err = fsmtest.Fuzz(1, 10, newRunner,
+		fsmtest.Script{fsmtest.Deliver(&pb.Message{Type: pb.Message_DISC_GET_PEERS}), fsmtest.Expect(fsmtest.MessageOf(pb.Message_DISC_PEERS))},
+		fsmtest.Script{fsmtest.Deliver(&pb.Message{Type: pb.Message_DISC_PING}), fsmtest.Expect(fsmtest.MessageOf(pb.Message_DISC_PONG))},
+		fsmtest.Script{fsmtest.Refuse(&pb.Message{Type: pb.Message_DISC_HELLO}), fsmtest.ExpectState("established")})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
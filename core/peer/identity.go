@@ -0,0 +1,197 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/core/crypto"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// VerifiedIdentity is the identity a remote peer proved during DISC_HELLO
+// with an enrollment certificate chaining to one of the configured CAs
+type VerifiedIdentity struct {
+	// EnrollmentID is the common name of the enrollment certificate
+	EnrollmentID string
+	Certificate  *x509.Certificate
+}
+
+// identityVerifier verifies the enrollment certificates received in
+// DISC_HELLO and provides the certificate chain sent with this peer's own
+type identityVerifier struct {
+	roots    *x509.CertPool
+	chain    [][]byte
+	required bool
+}
+
+var identities struct {
+	sync.Mutex
+	verifier *identityVerifier
+	loaded   bool
+}
+
+// getIdentityVerifier returns the verifier configured under peer.identity, nil
+// if peer identities are not verified
+func getIdentityVerifier() (*identityVerifier, error) {
+	identities.Lock()
+	defer identities.Unlock()
+	if !identities.loaded {
+		if viper.GetBool("peer.identity.enabled") {
+			verifier, err := newIdentityVerifier(viper.GetStringSlice("peer.identity.caCerts"), viper.GetString("peer.identity.chain"), viper.GetBool("peer.identity.required"))
+			if err != nil {
+				return nil, err
+			}
+			identities.verifier = verifier
+		}
+		identities.loaded = true
+	}
+	return identities.verifier, nil
+}
+
+func newIdentityVerifier(caFiles []string, chainFile string, required bool) (*identityVerifier, error) {
+	if len(caFiles) == 0 {
+		return nil, fmt.Errorf("No CA certificates configured to verify peer identities")
+	}
+	v := &identityVerifier{roots: x509.NewCertPool(), required: required}
+	for _, file := range caFiles {
+		certs, err := loadPEMCertificates(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, cert := range certs {
+			v.roots.AddCert(cert)
+		}
+	}
+	if chainFile != "" {
+		certs, err := loadPEMCertificates(chainFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, cert := range certs {
+			v.chain = append(v.chain, cert.Raw)
+		}
+	}
+	return v, nil
+}
+
+// loadPEMCertificates returns the certificates of the PEM file at path
+func loadPEMCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading certificates: %s", err)
+	}
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing certificate in %s: %s", path, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("No certificate found in %s", path)
+	}
+	return certs, nil
+}
+
+// attach sets this peer's enrollment certificate and its chain on hello. The
+// enrollment certificate is only available when security is enabled.
+func (v *identityVerifier) attach(hello *pb.HelloMessage, secHelper crypto.Peer) {
+	if secHelper == nil {
+		return
+	}
+	hello.EnrollmentCert = secHelper.GetEnrollmentCertificate()
+	hello.CertChain = v.chain
+}
+
+// verify returns the identity proven by the DISC_HELLO msg carrying hello.
+// When security is enabled the message must be signed with the key of the
+// enrollment certificate, otherwise the certificate is only checked against
+// the CAs.
+func (v *identityVerifier) verify(hello *pb.HelloMessage, msg *pb.Message) (*VerifiedIdentity, error) {
+	if len(hello.EnrollmentCert) == 0 {
+		return nil, fmt.Errorf("No enrollment certificate in %s", pb.Message_DISC_HELLO)
+	}
+	cert, err := x509.ParseCertificate(hello.EnrollmentCert)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing enrollment certificate: %s", err)
+	}
+	intermediates := x509.NewCertPool()
+	for _, der := range hello.CertChain {
+		intermediate, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing certificate chain: %s", err)
+		}
+		intermediates.AddCert(intermediate)
+	}
+	opts := x509.VerifyOptions{Roots: v.roots, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}
+	if _, err = cert.Verify(opts); err != nil {
+		return nil, fmt.Errorf("Error verifying enrollment certificate of %s: %s", cert.Subject.CommonName, err)
+	}
+	if viper.GetBool("security.enabled") {
+		key, isECDSA := cert.PublicKey.(*ecdsa.PublicKey)
+		if !isECDSA {
+			return nil, fmt.Errorf("Enrollment certificate of %s does not have an ECDSA key", cert.Subject.CommonName)
+		}
+		if ok, err := utils.ECDSAVerify(key, msg.Payload, msg.Signature); err != nil || !ok {
+			return nil, fmt.Errorf("%s not signed with the key of the enrollment certificate of %s", pb.Message_DISC_HELLO, cert.Subject.CommonName)
+		}
+	}
+	return &VerifiedIdentity{EnrollmentID: cert.Subject.CommonName, Certificate: cert}, nil
+}
+
+// verifyIdentity sets the VerifiedIdentity of the handler from the received
+// hello. A peer whose identity cannot be verified is refused if identities
+// are required, and authorized as a client otherwise.
+func (d *Handler) verifyIdentity(verifier *identityVerifier, hello *pb.HelloMessage, msg *pb.Message) error {
+	identity, err := verifier.verify(hello, msg)
+	if err != nil {
+		if verifier.required {
+			return err
+		}
+		peerLogger.Warning("Authorizing %s as a %s: %s", hello.PeerEndpoint.GetID(), RoleClient, err)
+		return nil
+	}
+	peerLogger.Debug("Verified identity %s of %s", identity.EnrollmentID, hello.PeerEndpoint.GetID())
+	d.VerifiedIdentity = identity
+	return nil
+}
+
+// AuthorizedEndpoint returns the endpoint whose role the messages received
+// are authorized for. It is nil, the client role, for a peer whose identity
+// was not verified while identities are verified.
+func (d *Handler) AuthorizedEndpoint() *pb.PeerEndpoint {
+	if verifier, _ := getIdentityVerifier(); verifier != nil && d.VerifiedIdentity == nil {
+		return nil
+	}
+	return d.ToPeerEndpoint
+}
@@ -0,0 +1,182 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/core/crypto/conf"
+	"github.com/hyperledger/fabric/core/crypto/utils"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// newTestCertificate returns a certificate for name issued by parent, a self
+// signed one if parent is nil
+func newTestCertificate(t *testing.T, name string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Error parsing certificate: %s", err)
+	}
+	return cert, key
+}
+
+func writePEMCertificates(t *testing.T, path string, certs ...*x509.Certificate) {
+	var data []byte
+	for _, cert := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Error writing certificates: %s", err)
+	}
+}
+
+func TestIdentityVerifier_VerifiesChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "identity")
+	if err != nil {
+		t.Fatalf("Error creating directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	root, rootKey := newTestCertificate(t, "root", true, nil, nil)
+	intermediate, intermediateKey := newTestCertificate(t, "intermediate", true, root, rootKey)
+	enrollment, _ := newTestCertificate(t, "peer1", false, intermediate, intermediateKey)
+	other, otherKey := newTestCertificate(t, "other", true, nil, nil)
+	stranger, _ := newTestCertificate(t, "stranger", false, other, otherKey)
+	writePEMCertificates(t, filepath.Join(dir, "ca.pem"), root)
+	writePEMCertificates(t, filepath.Join(dir, "chain.pem"), intermediate)
+
+	verifier, err := newIdentityVerifier([]string{filepath.Join(dir, "ca.pem")}, filepath.Join(dir, "chain.pem"), false)
+	if err != nil {
+		t.Fatalf("Error creating verifier: %s", err)
+	}
+	hello := &pb.HelloMessage{EnrollmentCert: enrollment.Raw, CertChain: verifier.chain}
+	identity, err := verifier.verify(hello, &pb.Message{})
+	if err != nil {
+		t.Fatalf("Error verifying identity: %s", err)
+	}
+	if identity.EnrollmentID != "peer1" {
+		t.Fatalf("Expected enrollment ID peer1, got %s", identity.EnrollmentID)
+	}
+
+	for name, hello := range map[string]*pb.HelloMessage{
+		"no certificate":   {},
+		"missing chain":    {EnrollmentCert: enrollment.Raw},
+		"unknown CA":       {EnrollmentCert: stranger.Raw},
+		"malformed":        {EnrollmentCert: []byte("garbage")},
+		"malformed chain":  {EnrollmentCert: enrollment.Raw, CertChain: [][]byte{[]byte("garbage")}},
+		"chain to unknown": {EnrollmentCert: stranger.Raw, CertChain: [][]byte{other.Raw}},
+	} {
+		if _, err := verifier.verify(hello, &pb.Message{}); err == nil {
+			t.Errorf("Expected %s to fail verification", name)
+		}
+	}
+}
+
+func TestIdentityVerifier_RequiresSignatureWithSecurity(t *testing.T) {
+	viper.Set("security.enabled", "true")
+	defer viper.Set("security.enabled", "false")
+	// Initialized by the crypto layer when security is enabled
+	if err := conf.InitSecurityLevel(256); err != nil {
+		t.Fatalf("Error initializing security level: %s", err)
+	}
+
+	root, rootKey := newTestCertificate(t, "root", true, nil, nil)
+	enrollment, key := newTestCertificate(t, "peer1", false, root, rootKey)
+	verifier := &identityVerifier{roots: x509.NewCertPool()}
+	verifier.roots.AddCert(root)
+
+	hello := &pb.HelloMessage{EnrollmentCert: enrollment.Raw}
+	msg := &pb.Message{Type: pb.Message_DISC_HELLO, Payload: []byte("hello")}
+	if _, err := verifier.verify(hello, msg); err == nil {
+		t.Fatalf("Expected an unsigned HELLO to fail verification")
+	}
+	if msg.Signature, _ = utils.ECDSASign(rootKey, msg.Payload); msg.Signature == nil {
+		t.Fatalf("Error signing HELLO")
+	}
+	if _, err := verifier.verify(hello, msg); err == nil {
+		t.Fatalf("Expected a HELLO signed with another key to fail verification")
+	}
+	msg.Signature, _ = utils.ECDSASign(key, msg.Payload)
+	if _, err := verifier.verify(hello, msg); err != nil {
+		t.Fatalf("Error verifying signed HELLO: %s", err)
+	}
+}
+
+func TestHandler_UnverifiedIdentityIsClient(t *testing.T) {
+	root, rootKey := newTestCertificate(t, "root", true, nil, nil)
+	enrollment, _ := newTestCertificate(t, "peer1", false, root, rootKey)
+	verifier := &identityVerifier{roots: x509.NewCertPool()}
+	verifier.roots.AddCert(root)
+	identities.verifier, identities.loaded = verifier, true
+	defer func() { identities.verifier, identities.loaded = nil, false }()
+
+	endpoint := &pb.PeerEndpoint{ID: &pb.PeerID{Name: "peer1"}, Type: pb.PeerEndpoint_VALIDATOR}
+	handler := &Handler{ToPeerEndpoint: endpoint}
+	if err := handler.verifyIdentity(verifier, &pb.HelloMessage{PeerEndpoint: endpoint}, &pb.Message{}); err != nil {
+		t.Fatalf("Expected an unverified identity to be accepted when not required: %s", err)
+	}
+	if handler.AuthorizedEndpoint() != nil {
+		t.Fatalf("Expected a peer without verified identity to be authorized as a client")
+	}
+
+	verifier.required = true
+	if err := handler.verifyIdentity(verifier, &pb.HelloMessage{PeerEndpoint: endpoint}, &pb.Message{}); err == nil {
+		t.Fatalf("Expected an unverified identity to be refused when required")
+	}
+	if err := handler.verifyIdentity(verifier, &pb.HelloMessage{PeerEndpoint: endpoint, EnrollmentCert: enrollment.Raw}, &pb.Message{}); err != nil {
+		t.Fatalf("Error verifying identity: %s", err)
+	}
+	if handler.VerifiedIdentity == nil || handler.AuthorizedEndpoint() != endpoint {
+		t.Fatalf("Expected a verified peer to be authorized for its endpoint")
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+
+	"github.com/hyperledger/fabric/core/interceptor"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// chatMethod is the gRPC method of the Chat streams
+const chatMethod = "/protos.Peer/Chat"
+
+// chatServerStream gives an intercepted Chat server stream back its
+// message types
+type chatServerStream struct {
+	grpc.ServerStream
+}
+
+func (s chatServerStream) Send(msg *pb.Message) error {
+	return s.SendMsg(msg)
+}
+
+func (s chatServerStream) Recv() (*pb.Message, error) {
+	msg := new(pb.Message)
+	if err := s.RecvMsg(msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// chatClientStream gives an intercepted Chat client stream back its
+// message types
+type chatClientStream struct {
+	grpc.ClientStream
+}
+
+func (s chatClientStream) Send(msg *pb.Message) error {
+	return s.SendMsg(msg)
+}
+
+func (s chatClientStream) Recv() (*pb.Message, error) {
+	msg := new(pb.Message)
+	if err := s.RecvMsg(msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// openChat opens a Chat stream with client through the configured client
+// interceptors
+func openChat(ctx context.Context, client pb.PeerClient) (pb.Peer_ChatClient, error) {
+	stream, err := interceptor.OpenStream(ctx, chatMethod, func(ctx context.Context) (grpc.ClientStream, error) {
+		return client.Chat(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return chatClientStream{stream}, nil
+}
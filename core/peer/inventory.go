@@ -0,0 +1,151 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// peerInventory holds the most recent verified PeerEndpoint of every peer
+// learned through DISC_HELLO and DISC_PEERS
+type peerInventory struct {
+	sync.RWMutex
+	entries map[pb.PeerID]*pb.NetworkInventoryEntry
+}
+
+func newPeerInventory() *peerInventory {
+	return &peerInventory{entries: make(map[pb.PeerID]*pb.NetworkInventoryEntry)}
+}
+
+// update records the endpoint if it carries metadata more recent than the
+// one already known for the peer
+func (i *peerInventory) update(ep *pb.PeerEndpoint) {
+	if ep.GetID() == nil || ep.Metadata == nil {
+		return
+	}
+	i.Lock()
+	defer i.Unlock()
+	if known, ok := i.entries[*ep.ID]; ok && !newerMetadata(ep.Metadata, known.Endpoint.Metadata) {
+		return
+	}
+	i.entries[*ep.ID] = &pb.NetworkInventoryEntry{
+		Endpoint:     proto.Clone(ep).(*pb.PeerEndpoint),
+		UpdatedNanos: time.Now().UnixNano(),
+	}
+}
+
+// metadata returns the most recent PeerMetadata known for the peer, nil if
+// there is none
+func (i *peerInventory) metadata(id *pb.PeerID) *pb.PeerMetadata {
+	i.RLock()
+	defer i.RUnlock()
+	if entry, ok := i.entries[*id]; ok {
+		return entry.Endpoint.Metadata
+	}
+	return nil
+}
+
+// address returns the most recent address known for the peer, empty if there
+// is none
+func (i *peerInventory) address(id *pb.PeerID) string {
+	i.RLock()
+	defer i.RUnlock()
+	if entry, ok := i.entries[*id]; ok {
+		return entry.Endpoint.Address
+	}
+	return ""
+}
+
+// remove forgets the peer
+func (i *peerInventory) remove(id *pb.PeerID) {
+	i.Lock()
+	defer i.Unlock()
+	delete(i.entries, *id)
+}
+
+// list returns a copy of the inventory ordered by peer ID, connected holds
+// the peers this peer has a stream to
+func (i *peerInventory) list(connected map[pb.PeerID]bool) *pb.NetworkInventory {
+	i.RLock()
+	defer i.RUnlock()
+	inventory := &pb.NetworkInventory{}
+	for id, entry := range i.entries {
+		entry = proto.Clone(entry).(*pb.NetworkInventoryEntry)
+		entry.Connected = connected[id]
+		inventory.Entries = append(inventory.Entries, entry)
+	}
+	sort.Sort(inventoryByID(inventory.Entries))
+	return inventory
+}
+
+// sortByLedgerHeight orders the endpoints by the ledger height they most
+// recently advertised, highest first
+func (i *peerInventory) sortByLedgerHeight(endpoints []*pb.PeerEndpoint) {
+	heights := make(map[*pb.PeerEndpoint]uint64, len(endpoints))
+	for _, ep := range endpoints {
+		metadata := ep.Metadata
+		if ep.ID != nil {
+			if known := i.metadata(ep.ID); known != nil {
+				metadata = known
+			}
+		}
+		if metadata != nil {
+			heights[ep] = metadata.LedgerHeight
+		}
+	}
+	sort.Stable(byLedgerHeight{endpoints, heights})
+}
+
+// newerMetadata returns whether a was created after b
+func newerMetadata(a, b *pb.PeerMetadata) bool {
+	ta, tb := a.GetTimestamp(), b.GetTimestamp()
+	if tb == nil {
+		return true
+	}
+	if ta == nil {
+		return false
+	}
+	return ta.Seconds > tb.Seconds || (ta.Seconds == tb.Seconds && ta.Nanos > tb.Nanos)
+}
+
+type inventoryByID []*pb.NetworkInventoryEntry
+
+func (a inventoryByID) Len() int           { return len(a) }
+func (a inventoryByID) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a inventoryByID) Less(i, j int) bool { return a[i].Endpoint.ID.Name < a[j].Endpoint.ID.Name }
+
+type byLedgerHeight struct {
+	endpoints []*pb.PeerEndpoint
+	heights   map[*pb.PeerEndpoint]uint64
+}
+
+func (a byLedgerHeight) Len() int { return len(a.endpoints) }
+func (a byLedgerHeight) Swap(i, j int) {
+	a.endpoints[i], a.endpoints[j] = a.endpoints[j], a.endpoints[i]
+}
+func (a byLedgerHeight) Less(i, j int) bool {
+	return a.heights[a.endpoints[i]] > a.heights[a.endpoints[j]]
+}
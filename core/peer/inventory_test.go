@@ -0,0 +1,80 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"testing"
+
+	google_protobuf "google/protobuf"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func inventoryEndpoint(name string, height uint64, seconds int64) *pb.PeerEndpoint {
+	return &pb.PeerEndpoint{
+		ID:       &pb.PeerID{Name: name},
+		Metadata: &pb.PeerMetadata{LedgerHeight: height, Timestamp: &google_protobuf.Timestamp{Seconds: seconds}},
+	}
+}
+
+func TestPeerInventoryKeepsMostRecentMetadata(t *testing.T) {
+	inventory := newPeerInventory()
+	inventory.update(inventoryEndpoint("vp1", 10, 100))
+	// A stale copy relayed by another peer does not replace it
+	inventory.update(inventoryEndpoint("vp1", 5, 50))
+	if height := inventory.metadata(&pb.PeerID{Name: "vp1"}).LedgerHeight; height != 10 {
+		t.Fatalf("Expected height 10, got %d", height)
+	}
+	inventory.update(inventoryEndpoint("vp1", 12, 150))
+	if height := inventory.metadata(&pb.PeerID{Name: "vp1"}).LedgerHeight; height != 12 {
+		t.Fatalf("Expected height 12, got %d", height)
+	}
+	// Endpoints without verified metadata are not recorded
+	inventory.update(&pb.PeerEndpoint{ID: &pb.PeerID{Name: "vp2"}})
+	if inventory.metadata(&pb.PeerID{Name: "vp2"}) != nil {
+		t.Fatal("Expected no metadata for vp2")
+	}
+
+	inventory.update(inventoryEndpoint("vp0", 3, 100))
+	list := inventory.list(map[pb.PeerID]bool{pb.PeerID{Name: "vp1"}: true})
+	if len(list.Entries) != 2 || list.Entries[0].Endpoint.ID.Name != "vp0" || list.Entries[1].Endpoint.ID.Name != "vp1" {
+		t.Fatalf("Expected vp0 and vp1 in order, got %s", list)
+	}
+	if list.Entries[0].Connected || !list.Entries[1].Connected {
+		t.Fatalf("Expected only vp1 to be connected, got %s", list)
+	}
+}
+
+func TestPeerInventorySortByLedgerHeight(t *testing.T) {
+	inventory := newPeerInventory()
+	inventory.update(inventoryEndpoint("vp2", 20, 100))
+	endpoints := []*pb.PeerEndpoint{
+		{ID: &pb.PeerID{Name: "vp0"}},
+		inventoryEndpoint("vp1", 10, 100),
+		// The inventory knows a more recent height than the hello
+		inventoryEndpoint("vp2", 1, 10),
+	}
+	inventory.sortByLedgerHeight(endpoints)
+	for i, name := range []string{"vp2", "vp1", "vp0"} {
+		if endpoints[i].ID.Name != name {
+			t.Fatalf("Expected %s at position %d, got %s", name, i, endpoints[i].ID.Name)
+		}
+	}
+}
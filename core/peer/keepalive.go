@@ -0,0 +1,104 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// errChatIdle ends a chat that carried no traffic for peer.keepalive.idleTimeout
+var errChatIdle = errors.New("Chat idle, closing stream")
+
+// keepsChatIdle reports whether messages of type t leave a chat idle. Keepalive
+// and the periodic discovery exchange run on every chat, they alone do not
+// warrant keeping a connection open.
+func keepsChatIdle(t pb.Message_Type) bool {
+	switch t {
+	case pb.Message_DISC_PING, pb.Message_DISC_PONG, pb.Message_DISC_GET_PEERS, pb.Message_DISC_PEERS:
+		return true
+	}
+	return false
+}
+
+// chatActivity records when a chat last carried traffic other than keepalive
+// and discovery
+type chatActivity struct {
+	last int64 // UnixNano, accessed atomically
+}
+
+func newChatActivity() *chatActivity {
+	return &chatActivity{last: time.Now().UnixNano()}
+}
+
+func (a *chatActivity) touch(t pb.Message_Type) {
+	if !keepsChatIdle(t) {
+		atomic.StoreInt64(&a.last, time.Now().UnixNano())
+	}
+}
+
+func (a *chatActivity) idleFor(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, atomic.LoadInt64(&a.last)))
+}
+
+// tcpKeepaliveDialer returns a grpc dial option enabling TCP keepalive with the
+// given period on outbound connections, nil if period is not positive
+func tcpKeepaliveDialer(period time.Duration) grpc.DialOption {
+	if period <= 0 {
+		return nil
+	}
+	return grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+		d := &net.Dialer{Timeout: timeout, KeepAlive: period}
+		return d.Dial("tcp", addr)
+	})
+}
+
+// NewKeepaliveListener returns a listener enabling TCP keepalive with the given
+// period on the connections accepted by lis. lis is returned as is if period is
+// not positive.
+func NewKeepaliveListener(lis net.Listener, period time.Duration) net.Listener {
+	if period <= 0 {
+		return lis
+	}
+	return &keepaliveListener{Listener: lis, period: period}
+}
+
+type keepaliveListener struct {
+	net.Listener
+	period time.Duration
+}
+
+func (l *keepaliveListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetKeepAlive(true)
+		tcpConn.SetKeepAlivePeriod(l.period)
+	}
+	return conn, nil
+}
@@ -0,0 +1,70 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestChatActivity_IgnoresKeepaliveAndDiscovery(t *testing.T) {
+	a := newChatActivity()
+	a.last = time.Now().Add(-time.Minute).UnixNano()
+	for _, typ := range []pb.Message_Type{pb.Message_DISC_PING, pb.Message_DISC_PONG, pb.Message_DISC_GET_PEERS, pb.Message_DISC_PEERS} {
+		a.touch(typ)
+	}
+	if idle := a.idleFor(time.Now()); idle < time.Minute {
+		t.Fatalf("Expected keepalive and discovery to leave the chat idle, idle for %s", idle)
+	}
+	a.touch(pb.Message_CONSENSUS)
+	if idle := a.idleFor(time.Now()); idle >= time.Minute {
+		t.Fatalf("Expected %s to count as activity, idle for %s", pb.Message_CONSENSUS, idle)
+	}
+}
+
+func TestKeepaliveListener(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %s", err)
+	}
+	if NewKeepaliveListener(lis, 0) != lis {
+		t.Fatal("Expected listener to be returned as is when TCP keepalive is disabled")
+	}
+	lis = NewKeepaliveListener(lis, time.Minute)
+	defer lis.Close()
+
+	go func() {
+		if conn, err := net.Dial("tcp", lis.Addr().String()); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := lis.Accept()
+	if err != nil {
+		t.Fatalf("Error accepting connection: %s", err)
+	}
+	conn.Close()
+
+	if tcpKeepaliveDialer(0) != nil {
+		t.Fatal("Expected no dial option when TCP keepalive is disabled")
+	}
+}
@@ -0,0 +1,75 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+
+	"github.com/hyperledger/fabric/core/opevents"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// brokenChatStream fails every Recv
+type brokenChatStream struct {
+}
+
+func (s *brokenChatStream) Send(msg *pb.Message) error {
+	return nil
+}
+
+func (s *brokenChatStream) Recv() (*pb.Message, error) {
+	return nil, errors.New("connection reset")
+}
+
+func TestLifecycleListeners(t *testing.T) {
+	p := newMeshTestPeer(0)
+	var events []string
+	p.RegisterLifecycleListener(func(name string, event opevents.HandlerEvent, err error) {
+		events = append(events, name+" "+event.String())
+		if event == opevents.HandlerError && !strings.Contains(err.Error(), "connection reset") {
+			t.Errorf("Expected the reason of the error, got %v", err)
+		}
+	})
+
+	h := newMeshTestHandler("vp1", pb.PeerEndpoint_VALIDATOR)
+	if err := p.RegisterHandler(h); err != nil {
+		t.Fatalf("Error registering handler: %s", err)
+	}
+	if err := p.DeregisterHandler(h); err != nil {
+		t.Fatalf("Error deregistering handler: %s", err)
+	}
+
+	// A chat whose stream fails reports the error of its handler
+	p.handlerFactory = func(coord MessageHandlerCoordinator, stream ChatStream, initiatedStream bool, next MessageHandler) (MessageHandler, error) {
+		return newMeshTestHandler("vp2", pb.PeerEndpoint_VALIDATOR), nil
+	}
+	if err := p.handleChat(context.Background(), &brokenChatStream{}, false, ""); err == nil {
+		t.Fatal("Expected the chat to fail")
+	}
+
+	expected := []string{"vp1 registered", "vp1 ready", "vp1 deregistered", "vp2 error"}
+	if strings.Join(events, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected events %v, got %v", expected, events)
+	}
+}
@@ -0,0 +1,363 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// Scores used to rank the peers to keep connections to, a peer scores the sum
+// of those it qualifies for. Anchors always outrank the others.
+const (
+	anchorScore    = 1000
+	validatorScore = 100
+	// awarded in full for a round trip of 0, decreasing to none at
+	// maxScoredLatency. Peers never measured get half.
+	latencyScore     = 100
+	maxScoredLatency = time.Second
+	// connected peers also score for their usefulness so far, awarded in
+	// proportion to how long they have been connected and how many messages
+	// they sent, in full at maxScoredAge and maxScoredMessages
+	ageScore          = 50
+	maxScoredAge      = 10 * time.Minute
+	volumeScore       = 50
+	maxScoredMessages = 1000
+	// most peers suggested to an inbound peer refused for lack of budget
+	maxBusyAlternatives = 10
+)
+
+// latencies holds the last keepalive round trip measured to each peer, kept
+// after the connection ends to score the peer when it is discovered again
+var latencies = struct {
+	sync.Mutex
+	m map[pb.PeerID]time.Duration
+}{m: make(map[pb.PeerID]time.Duration)}
+
+func recordLatency(id *pb.PeerID, rtt time.Duration) {
+	if id == nil {
+		return
+	}
+	latencies.Lock()
+	defer latencies.Unlock()
+	latencies.m[*id] = rtt
+}
+
+func lastLatency(id *pb.PeerID) time.Duration {
+	if id == nil {
+		return 0
+	}
+	latencies.Lock()
+	defer latencies.Unlock()
+	return latencies.m[*id]
+}
+
+// scorePeer scores the peer at endpoint ep
+func scorePeer(ep *pb.PeerEndpoint, anchor bool, transient bool) *pb.MeshPeerScore {
+	s := &pb.MeshPeerScore{PeerID: ep.ID, Address: ep.Address, Validator: ep.Type == pb.PeerEndpoint_VALIDATOR, Anchor: anchor, Transient: transient, LatencyNanos: int64(lastLatency(ep.ID))}
+	if s.Anchor {
+		s.Score += anchorScore
+	}
+	if s.Validator {
+		s.Score += validatorScore
+	}
+	rtt := time.Duration(s.LatencyNanos)
+	if rtt == 0 {
+		rtt = maxScoredLatency / 2
+	}
+	if rtt < maxScoredLatency {
+		s.Score += latencyScore * int64(maxScoredLatency-rtt) / int64(maxScoredLatency)
+	}
+	return s
+}
+
+// scoreUsefulness adds to s the score of a peer connected for connected that
+// sent messages messages
+func scoreUsefulness(s *pb.MeshPeerScore, connected time.Duration, messages uint64) {
+	s.ConnectedNanos = int64(connected)
+	s.Messages = messages
+	if connected > maxScoredAge {
+		connected = maxScoredAge
+	}
+	s.Score += ageScore * int64(connected) / int64(maxScoredAge)
+	if messages > maxScoredMessages {
+		messages = maxScoredMessages
+	}
+	s.Score += volumeScore * int64(messages) / maxScoredMessages
+}
+
+// worstEvictable returns the lowest scoring of scores, nil if all of them are
+// exempt from the budget
+func (m *meshLimiter) worstEvictable(scores []*pb.MeshPeerScore) *pb.MeshPeerScore {
+	var worst *pb.MeshPeerScore
+	for _, s := range scores {
+		if m.exempt(s) {
+			continue
+		}
+		if worst == nil || s.Score < worst.Score {
+			worst = s
+		}
+	}
+	return worst
+}
+
+// meshLimiter keeps the number of peers chatted with within a budget so that
+// large networks do not form a full mesh. Anchors, transient connections,
+// opened on demand for state transfer, and on a validator the connections to
+// other validators, which consensus needs, do not count against the budget.
+type meshLimiter struct {
+	sync.Mutex
+	maxConnections int  // 0 is unlimited
+	validator      bool // this peer is a validator
+	transientTTL   time.Duration
+	transient      map[string]bool // address -> transient
+	decisions      []*pb.MeshDecision
+	maxDecisions   int
+}
+
+func newMeshLimiter(maxConnections int, transientTTL time.Duration, maxDecisions int, validator bool) *meshLimiter {
+	return &meshLimiter{maxConnections: maxConnections, validator: validator, transientTTL: transientTTL, transient: make(map[string]bool), maxDecisions: maxDecisions}
+}
+
+func (m *meshLimiter) limited() bool {
+	return m.maxConnections > 0
+}
+
+// exempt reports whether the connection to the peer scored s is outside of
+// the budget, so neither counted nor evicted
+func (m *meshLimiter) exempt(s *pb.MeshPeerScore) bool {
+	return s.Anchor || s.Transient || (m.validator && s.Validator)
+}
+
+func (m *meshLimiter) isTransient(address string) bool {
+	m.Lock()
+	defer m.Unlock()
+	return m.transient[address]
+}
+
+// setTransient marks the connection to address transient, it returns false if
+// it already was
+func (m *meshLimiter) setTransient(address string, transient bool) bool {
+	m.Lock()
+	defer m.Unlock()
+	if m.transient[address] == transient {
+		return false
+	}
+	if transient {
+		m.transient[address] = true
+	} else {
+		delete(m.transient, address)
+	}
+	return true
+}
+
+func (m *meshLimiter) record(action pb.MeshDecision_Action, peer *pb.MeshPeerScore, comparedScore int64) {
+	peerLogger.Info("Mesh limit: %s %s (score %d, compared to %d)", action, peer.Address, peer.Score, comparedScore)
+	if m.maxDecisions <= 0 {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	if len(m.decisions) == m.maxDecisions {
+		m.decisions = m.decisions[1:]
+	}
+	m.decisions = append(m.decisions, &pb.MeshDecision{Action: action, Peer: peer, ComparedScore: comparedScore, TimestampNanos: time.Now().UnixNano()})
+}
+
+func (m *meshLimiter) getDecisions() []*pb.MeshDecision {
+	m.Lock()
+	defer m.Unlock()
+	return append([]*pb.MeshDecision(nil), m.decisions...)
+}
+
+// scoreHandler scores the peer of msgHandler, nil if its chat is ending. Call
+// this under handlerMap lock.
+func (p *PeerImpl) scoreHandler(msgHandler MessageHandler) *pb.MeshPeerScore {
+	if h, ok := msgHandler.(*Handler); ok && h.chatAborted() {
+		return nil
+	}
+	ep, err := msgHandler.To()
+	if err != nil {
+		return nil
+	}
+	_, static := p.connMgr.isDesired(ep.Address)
+	s := scorePeer(&ep, static, p.mesh.isTransient(ep.Address))
+	if h, ok := msgHandler.(*Handler); ok {
+		scoreUsefulness(s, time.Since(h.connectedAt), atomic.LoadUint64(&h.messages))
+	}
+	return s
+}
+
+// call this under handlerMap lock
+func (p *PeerImpl) connectedScores() (scores []*pb.MeshPeerScore, budgeted int) {
+	for _, msgHandler := range p.handlerMap.m {
+		if s := p.scoreHandler(msgHandler); s != nil {
+			scores = append(scores, s)
+			if !p.mesh.exempt(s) {
+				budgeted++
+			}
+		}
+	}
+	return scores, budgeted
+}
+
+// shouldDial reports whether the discovered peer at ep should be dialed given
+// the budget. Call this under handlerMap lock.
+func (p *PeerImpl) shouldDial(ep *pb.PeerEndpoint) bool {
+	if !p.mesh.limited() {
+		return true
+	}
+	if _, maintained := p.connMgr.isDesired(ep.Address); maintained {
+		return true
+	}
+	candidate := scorePeer(ep, false, false)
+	if p.mesh.exempt(candidate) {
+		return true
+	}
+	scores, budgeted := p.connectedScores()
+	if budgeted < p.mesh.maxConnections {
+		return true
+	}
+	worst := p.mesh.worstEvictable(scores)
+	if worst != nil && candidate.Score > worst.Score {
+		p.mesh.record(pb.MeshDecision_DIAL, candidate, worst.Score)
+		return true
+	}
+	var compared int64
+	if worst != nil {
+		compared = worst.Score
+	}
+	p.mesh.record(pb.MeshDecision_SKIP, candidate, compared)
+	return false
+}
+
+// enforceBudget evicts the lowest scoring connection if the newly registered
+// msgHandler takes the connections over budget. It returns an error if that is
+// msgHandler itself, a *PeerBusyError if msgHandler is an inbound connection,
+// which is then refused rather than evicted. Call this under handlerMap lock,
+// after msgHandler has been added.
+func (p *PeerImpl) enforceBudget(msgHandler MessageHandler) error {
+	if !p.mesh.limited() {
+		return nil
+	}
+	scores, budgeted := p.connectedScores()
+	if budgeted <= p.mesh.maxConnections {
+		return nil
+	}
+	worst := p.mesh.worstEvictable(scores)
+	if worst == nil {
+		return nil
+	}
+	victim := p.handlerMap.m[*worst.PeerID]
+	if h, ok := victim.(*Handler); ok && victim == msgHandler && !h.initiatedStream {
+		// The handler ends the chat once it told the peer where else to connect
+		delete(p.handlerMap.m, *worst.PeerID)
+		p.mesh.record(pb.MeshDecision_REFUSE, worst, 0)
+		return &PeerBusyError{MaxConnections: p.mesh.maxConnections, Alternatives: p.busyAlternatives(worst.PeerID)}
+	}
+	p.mesh.record(pb.MeshDecision_EVICT, worst, 0)
+	if !worst.Anchor {
+		// Do not dial it again, it would only be evicted again
+		p.connMgr.remove(worst.Address)
+	}
+	if h, ok := victim.(*Handler); ok {
+		h.abortChat()
+	}
+	if victim == msgHandler {
+		delete(p.handlerMap.m, *worst.PeerID)
+		return fmt.Errorf("Connection budget of %d exhausted", p.mesh.maxConnections)
+	}
+	return nil
+}
+
+// busyAlternatives returns the best scoring connected peers other than the
+// refused one, suggested to it instead. Call this under handlerMap lock.
+func (p *PeerImpl) busyAlternatives(refused *pb.PeerID) *pb.PeersMessage {
+	scores, _ := p.connectedScores()
+	sort.Sort(meshScoresByScore(scores))
+	alternatives := &pb.PeersMessage{}
+	for _, s := range scores {
+		if len(alternatives.Peers) == maxBusyAlternatives {
+			break
+		}
+		if *s.PeerID == *refused {
+			continue
+		}
+		if ep, err := p.handlerMap.m[*s.PeerID].To(); err == nil {
+			alternatives.Peers = append(alternatives.Peers, &ep)
+		}
+	}
+	return alternatives
+}
+
+// connectTransient opens a connection to address outside of the budget, for
+// state transfer with a peer not connected to. It is closed after
+// peer.discovery.mesh.transientTTL.
+func (p *PeerImpl) connectTransient(address string) {
+	if !p.mesh.setTransient(address, true) {
+		return
+	}
+	p.mesh.record(pb.MeshDecision_TRANSIENT, &pb.MeshPeerScore{Address: address, Transient: true}, 0)
+	p.connMgr.add(address, false)
+	time.AfterFunc(p.mesh.transientTTL, func() { p.releaseTransient(address) })
+}
+
+// releaseTransient closes the transient connection to address
+func (p *PeerImpl) releaseTransient(address string) {
+	if !p.mesh.setTransient(address, false) {
+		return
+	}
+	p.connMgr.remove(address)
+	p.handlerMap.Lock()
+	defer p.handlerMap.Unlock()
+	for _, msgHandler := range p.handlerMap.m {
+		if ep, err := msgHandler.To(); err == nil && ep.Address == address {
+			if h, ok := msgHandler.(*Handler); ok {
+				h.abortChat()
+			}
+		}
+	}
+}
+
+// GetMeshStatus returns the scores of the connected peers and the recent
+// decisions taken to keep within the connection budget
+func (p *PeerImpl) GetMeshStatus() *pb.MeshStatus {
+	p.handlerMap.Lock()
+	scores, _ := p.connectedScores()
+	p.handlerMap.Unlock()
+	sort.Sort(meshScoresByScore(scores))
+	return &pb.MeshStatus{MaxConnections: int32(p.mesh.maxConnections), Connected: scores, Decisions: p.mesh.getDecisions()}
+}
+
+type meshScoresByScore []*pb.MeshPeerScore
+
+func (s meshScoresByScore) Len() int      { return len(s) }
+func (s meshScoresByScore) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s meshScoresByScore) Less(i, j int) bool {
+	if s[i].Score != s[j].Score {
+		return s[i].Score > s[j].Score
+	}
+	return s[i].Address < s[j].Address
+}
@@ -0,0 +1,256 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/looplab/fsm"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func newMeshTestPeer(maxConnections int) *PeerImpl {
+	return &PeerImpl{
+		handlerMap: &handlerMap{m: make(map[pb.PeerID]MessageHandler)},
+		connMgr:    newConnectionManager(func(string) error { return errChatIdle }, time.Millisecond, time.Millisecond, 0),
+		inventory:  newPeerInventory(),
+		mesh:       newMeshLimiter(maxConnections, time.Minute, 10, false),
+	}
+}
+
+func newMeshTestHandler(name string, typ pb.PeerEndpoint_Type) *Handler {
+	return &Handler{
+		ToPeerEndpoint: &pb.PeerEndpoint{ID: &pb.PeerID{Name: name}, Address: name + ":30303", Type: typ},
+		ChatStream:     newAbortableChatStream(nil),
+	}
+}
+
+func TestScorePeer(t *testing.T) {
+	validator := scorePeer(&pb.PeerEndpoint{ID: &pb.PeerID{Name: "v"}, Type: pb.PeerEndpoint_VALIDATOR}, false, false)
+	nonValidator := scorePeer(&pb.PeerEndpoint{ID: &pb.PeerID{Name: "nv"}, Type: pb.PeerEndpoint_NON_VALIDATOR}, false, false)
+	anchor := scorePeer(&pb.PeerEndpoint{ID: &pb.PeerID{Name: "a"}, Type: pb.PeerEndpoint_NON_VALIDATOR}, true, false)
+	if !(anchor.Score > validator.Score && validator.Score > nonValidator.Score) {
+		t.Fatalf("Expected anchor > validator > non validator, got %d, %d, %d", anchor.Score, validator.Score, nonValidator.Score)
+	}
+
+	recordLatency(&pb.PeerID{Name: "near"}, time.Millisecond)
+	recordLatency(&pb.PeerID{Name: "far"}, 900*time.Millisecond)
+	near := scorePeer(&pb.PeerEndpoint{ID: &pb.PeerID{Name: "near"}}, false, false)
+	far := scorePeer(&pb.PeerEndpoint{ID: &pb.PeerID{Name: "far"}}, false, false)
+	if near.Score <= far.Score {
+		t.Fatalf("Expected low latency peer to outscore high latency one, got %d and %d", near.Score, far.Score)
+	}
+	if near.LatencyNanos != int64(time.Millisecond) {
+		t.Fatalf("Expected latency to be reported, got %d", near.LatencyNanos)
+	}
+}
+
+func TestScoreUsefulness(t *testing.T) {
+	ep := &pb.PeerEndpoint{ID: &pb.PeerID{Name: "useful"}, Type: pb.PeerEndpoint_NON_VALIDATOR}
+	fresh := scorePeer(ep, false, false)
+	scoreUsefulness(fresh, 0, 0)
+	old := scorePeer(ep, false, false)
+	scoreUsefulness(old, maxScoredAge/2, 0)
+	busy := scorePeer(ep, false, false)
+	scoreUsefulness(busy, maxScoredAge/2, 10*maxScoredMessages)
+	if !(busy.Score > old.Score && old.Score > fresh.Score) {
+		t.Fatalf("Expected busy > old > fresh, got %d, %d, %d", busy.Score, old.Score, fresh.Score)
+	}
+	if busy.Score-fresh.Score != ageScore/2+volumeScore {
+		t.Fatalf("Expected the usefulness score to be capped, got %d", busy.Score-fresh.Score)
+	}
+	if busy.ConnectedNanos != int64(maxScoredAge/2) || busy.Messages != 10*maxScoredMessages {
+		t.Fatalf("Expected the usefulness to be reported, got %v", busy)
+	}
+}
+
+// busyCoordinator refuses every peer as busy and records the peers discovered
+type busyCoordinator struct {
+	discoveryCoordinator
+	discovered chan *pb.PeersMessage
+}
+
+func (c *busyCoordinator) RegisterHandler(messageHandler MessageHandler) error {
+	return &PeerBusyError{MaxConnections: 1, Alternatives: &pb.PeersMessage{Peers: c.peers}}
+}
+
+func (c *busyCoordinator) PeersDiscovered(peers *pb.PeersMessage) error {
+	c.discovered <- peers
+	return nil
+}
+
+func TestHandler_BusyPeerSuggestsAlternatives(t *testing.T) {
+	setupChatTraceConfig("")
+	alternative := &pb.PeerEndpoint{ID: &pb.PeerID{Name: "other"}, Address: "other:30303"}
+	busyPeer := &busyCoordinator{discoveryCoordinator: discoveryCoordinator{endpoint: &pb.PeerEndpoint{ID: &pb.PeerID{Name: "busy"}, Address: "busy:30303"}, peers: []*pb.PeerEndpoint{alternative}}}
+	dialer := &busyCoordinator{discoveryCoordinator: discoveryCoordinator{endpoint: &pb.PeerEndpoint{ID: &pb.PeerID{Name: "dialer"}, Address: "dialer:30303"}}, discovered: make(chan *pb.PeersMessage, 1)}
+
+	sent := &mockChatStream{sent: make(chan *pb.Message, 10)}
+	stream := newAbortableChatStream(sent)
+	h, err := NewPeerHandler(busyPeer, stream, false, nil)
+	if err != nil {
+		t.Fatalf("Error creating handler: %s", err)
+	}
+	hello, _ := dialer.NewOpenchainDiscoveryHello()
+	h.HandleMessage(hello)
+	if !h.(*Handler).chatAborted() {
+		t.Fatal("Expected the chat with the refused peer to be closed")
+	}
+	if msg := <-sent.sent; msg.Type != pb.Message_DISC_HELLO {
+		t.Fatalf("Expected the hello to be answered first, got %s", msg.Type)
+	}
+	busy := <-sent.sent
+	if busy.Type != pb.Message_DISC_BUSY {
+		t.Fatalf("Expected %s, got %s", pb.Message_DISC_BUSY, busy.Type)
+	}
+
+	// The refused peer discovers the suggested peers
+	d := &Handler{Coordinator: dialer, ToPeerEndpoint: busyPeer.endpoint, protocolVersion: ProtocolVersion}
+	d.FSM = fsm.NewFSM("established", fsm.Events{{Name: pb.Message_DISC_BUSY.String(), Src: []string{"established"}, Dst: "established"}},
+		fsm.Callbacks{"before_" + pb.Message_DISC_BUSY.String(): func(e *fsm.Event) { d.beforeBusy(e) }})
+	if err = d.HandleMessage(busy); err != nil {
+		t.Fatalf("Error handling %s: %s", busy.Type, err)
+	}
+	select {
+	case peers := <-dialer.discovered:
+		if len(peers.Peers) != 1 || peers.Peers[0].ID.Name != "other" {
+			t.Fatalf("Expected the suggested peer to be discovered, got %v", peers)
+		}
+	default:
+		t.Fatal("Expected the suggested peers to be discovered")
+	}
+}
+
+func TestMeshLimit_EvictsLowestScore(t *testing.T) {
+	p := newMeshTestPeer(2)
+	handlers := []*Handler{
+		newMeshTestHandler("v1", pb.PeerEndpoint_VALIDATOR),
+		newMeshTestHandler("nv1", pb.PeerEndpoint_NON_VALIDATOR),
+		newMeshTestHandler("v2", pb.PeerEndpoint_VALIDATOR),
+	}
+	for _, h := range handlers {
+		if err := p.RegisterHandler(h); err != nil {
+			t.Fatalf("Error registering %s: %s", h.ToPeerEndpoint.ID.Name, err)
+		}
+	}
+	if !handlers[1].chatAborted() || handlers[0].chatAborted() || handlers[2].chatAborted() {
+		t.Fatal("Expected only the chat with the non validator to be evicted")
+	}
+
+	// An inbound newcomer scoring lowest is refused and told where else to connect
+	nv2 := newMeshTestHandler("nv2", pb.PeerEndpoint_NON_VALIDATOR)
+	busy, ok := p.RegisterHandler(nv2).(*PeerBusyError)
+	if !ok {
+		t.Fatal("Expected inbound peer outside of the budget to be refused as busy")
+	}
+	if len(busy.Alternatives.Peers) != 2 {
+		t.Fatalf("Expected the connected peers to be suggested, got %v", busy.Alternatives)
+	}
+	if _, ok := p.getHandler(nv2.ToPeerEndpoint.ID); ok {
+		t.Fatal("Expected the refused peer not to be registered")
+	}
+
+	// An outbound one is evicted
+	nv3 := newMeshTestHandler("nv3", pb.PeerEndpoint_NON_VALIDATOR)
+	nv3.initiatedStream = true
+	if err := p.RegisterHandler(nv3); err == nil {
+		t.Fatal("Expected peer outside of the budget to be refused")
+	}
+	if !nv3.chatAborted() {
+		t.Fatal("Expected the chat with the refused peer to be closed")
+	}
+
+	status := p.GetMeshStatus()
+	if status.MaxConnections != 2 || len(status.Connected) != 2 {
+		t.Fatalf("Expected 2 connected peers within a budget of 2, got %v", status)
+	}
+	if status.Connected[0].Score < status.Connected[1].Score {
+		t.Fatal("Expected connected peers ordered by score")
+	}
+	if len(status.Decisions) != 3 || status.Decisions[0].Action != pb.MeshDecision_EVICT || status.Decisions[1].Action != pb.MeshDecision_REFUSE || status.Decisions[2].Action != pb.MeshDecision_EVICT {
+		t.Fatalf("Expected an eviction, a refusal and an eviction, got %v", status.Decisions)
+	}
+}
+
+func TestMeshLimit_ExemptsValidatorsOnValidators(t *testing.T) {
+	p := newMeshTestPeer(1)
+	p.mesh.validator = true
+	handlers := []*Handler{
+		newMeshTestHandler("v1", pb.PeerEndpoint_VALIDATOR),
+		newMeshTestHandler("v2", pb.PeerEndpoint_VALIDATOR),
+		newMeshTestHandler("nv1", pb.PeerEndpoint_NON_VALIDATOR),
+		newMeshTestHandler("v3", pb.PeerEndpoint_VALIDATOR),
+	}
+	for _, h := range handlers {
+		h.initiatedStream = true
+		if err := p.RegisterHandler(h); err != nil {
+			t.Fatalf("Error registering %s: %s", h.ToPeerEndpoint.ID.Name, err)
+		}
+	}
+	for _, h := range handlers {
+		if h.chatAborted() {
+			t.Fatalf("Expected no chat to be evicted, %s was", h.ToPeerEndpoint.ID.Name)
+		}
+	}
+
+	p.handlerMap.Lock()
+	validator := p.shouldDial(&pb.PeerEndpoint{ID: &pb.PeerID{Name: "v4"}, Address: "v4:30303", Type: pb.PeerEndpoint_VALIDATOR})
+	nonValidator := p.shouldDial(&pb.PeerEndpoint{ID: &pb.PeerID{Name: "nv2"}, Address: "nv2:30303", Type: pb.PeerEndpoint_NON_VALIDATOR})
+	p.handlerMap.Unlock()
+	if !validator || nonValidator {
+		t.Fatalf("Expected only the validator to be dialed over budget, validator %t, non validator %t", validator, nonValidator)
+	}
+}
+
+func TestMeshLimit_SkipsDiscoveredPeers(t *testing.T) {
+	p := newMeshTestPeer(1)
+	if err := p.RegisterHandler(newMeshTestHandler("nv1", pb.PeerEndpoint_NON_VALIDATOR)); err != nil {
+		t.Fatalf("Error registering: %s", err)
+	}
+
+	p.handlerMap.Lock()
+	skipped := p.shouldDial(&pb.PeerEndpoint{ID: &pb.PeerID{Name: "nv2"}, Address: "nv2:30303", Type: pb.PeerEndpoint_NON_VALIDATOR})
+	dialed := p.shouldDial(&pb.PeerEndpoint{ID: &pb.PeerID{Name: "v1"}, Address: "v1:30303", Type: pb.PeerEndpoint_VALIDATOR})
+	p.handlerMap.Unlock()
+	if skipped {
+		t.Fatal("Expected peer not outscoring the connected ones to be skipped")
+	}
+	if !dialed {
+		t.Fatal("Expected validator to be dialed")
+	}
+	decisions := p.mesh.getDecisions()
+	if len(decisions) != 2 || decisions[0].Action != pb.MeshDecision_SKIP || decisions[1].Action != pb.MeshDecision_DIAL {
+		t.Fatalf("Expected SKIP then DIAL, got %v", decisions)
+	}
+}
+
+func TestMeshLimit_KeepsRecentDecisions(t *testing.T) {
+	m := newMeshLimiter(1, time.Minute, 3, false)
+	for i := 0; i < 5; i++ {
+		m.record(pb.MeshDecision_SKIP, &pb.MeshPeerScore{Address: fmt.Sprintf("peer%d", i)}, 0)
+	}
+	decisions := m.getDecisions()
+	if len(decisions) != 3 || decisions[0].Peer.Address != "peer2" {
+		t.Fatalf("Expected the 3 most recent decisions, got %v", decisions)
+	}
+}
@@ -0,0 +1,217 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+
+	"github.com/hyperledger/fabric/core/util"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// RoleFollower is the role advertised in the PeerMetadata of read-only
+// followers, validators and non-validators advertise RoleValidator and
+// RoleNonValidator
+const RoleFollower = "follower"
+
+// getLocalMetadata returns the PeerMetadata advertised in this peer's
+// PeerEndpoint, based upon the peer.metadata configuration. The ledger height
+// is filled in by the PeerImpl.
+func getLocalMetadata() *pb.PeerMetadata {
+	metadata := &pb.PeerMetadata{
+		ProtocolVersion:   fmt.Sprint(ProtocolVersion),
+		MessageTypes:      supportedMessageTypes(),
+		ChaincodeRuntimes: viper.GetStringSlice("peer.metadata.chaincodeRuntimes"),
+		SoftwareVersion:   viper.GetString("peer.version"),
+		Role:              localRole(),
+		Timestamp:         util.CreateUtcTimestamp(),
+	}
+	labels := viper.GetStringMapString("peer.metadata.labels")
+	for k, v := range labels {
+		if v == "" {
+			continue
+		}
+		if metadata.Labels == nil {
+			metadata.Labels = make(map[string]string)
+		}
+		metadata.Labels[k] = v
+	}
+	return metadata
+}
+
+func localRole() string {
+	switch {
+	case viper.GetBool("peer.validator.enabled"):
+		return RoleValidator
+	case IsFollower():
+		return RoleFollower
+	default:
+		return RoleNonValidator
+	}
+}
+
+// signPeerMetadata sets the SignedMetadata of the endpoint from its Metadata.
+// sign is nil when security is disabled, the metadata is then sent unsigned.
+func signPeerMetadata(ep *pb.PeerEndpoint, sign func(msg []byte) ([]byte, error)) error {
+	data, err := proto.Marshal(ep.Metadata)
+	if err != nil {
+		return fmt.Errorf("Error marshalling PeerMetadata: %s", err)
+	}
+	signed := &pb.SignedPeerMetadata{Metadata: data}
+	if sign != nil {
+		if signed.Signature, err = sign(data); err != nil {
+			return fmt.Errorf("Error signing PeerMetadata: %s", err)
+		}
+	}
+	ep.SignedMetadata = signed
+	return nil
+}
+
+// verifyPeerMetadata replaces the Metadata of a received endpoint with the
+// content of its SignedMetadata. verify checks the signature against the
+// PkiID of the endpoint, it is nil when security is disabled. Metadata that
+// cannot be verified is removed from the endpoint so that it is neither
+// trusted nor relayed.
+func verifyPeerMetadata(ep *pb.PeerEndpoint, verify func(vkID, signature, message []byte) error) error {
+	if ep == nil {
+		return nil
+	}
+	signed := ep.SignedMetadata
+	if signed == nil {
+		if verify != nil {
+			ep.Metadata = nil
+		}
+		return nil
+	}
+	var err error
+	if verify != nil {
+		err = verify(ep.PkiID, signed.Signature, signed.Metadata)
+	}
+	metadata := &pb.PeerMetadata{}
+	if err == nil {
+		err = proto.Unmarshal(signed.Metadata, metadata)
+	}
+	if err != nil {
+		ep.Metadata, ep.SignedMetadata = nil, nil
+		return fmt.Errorf("Error verifying metadata of peer %s: %s", ep.ID, err)
+	}
+	ep.Metadata = metadata
+	return nil
+}
+
+// supportedMessageTypes returns the Message types this peer handles, in
+// ascending order
+func supportedMessageTypes() []pb.Message_Type {
+	var types []pb.Message_Type
+	for v := range pb.Message_Type_name {
+		if pb.Message_Type(v) != pb.Message_UNDEFINED {
+			types = append(types, pb.Message_Type(v))
+		}
+	}
+	sort.Sort(messageTypes(types))
+	return types
+}
+
+type messageTypes []pb.Message_Type
+
+func (t messageTypes) Len() int           { return len(t) }
+func (t messageTypes) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t messageTypes) Less(i, j int) bool { return t[i] < t[j] }
+
+// PeerFilter selects PeerEndpoints, see MessageHandlerCoordinator.GetFilteredPeers.
+// Peers that did not advertise PeerMetadata only match filters that do not
+// look at it.
+type PeerFilter func(*pb.PeerEndpoint) bool
+
+// PeersOfType selects the peers of the given type
+func PeersOfType(peerType pb.PeerEndpoint_Type) PeerFilter {
+	return func(ep *pb.PeerEndpoint) bool {
+		return ep.Type == peerType
+	}
+}
+
+// PeersSupportingMessageType selects the peers that handle the given Message type
+func PeersSupportingMessageType(msgType pb.Message_Type) PeerFilter {
+	return func(ep *pb.PeerEndpoint) bool {
+		metadata := ep.GetMetadata()
+		if metadata == nil {
+			return false
+		}
+		for _, t := range metadata.MessageTypes {
+			if t == msgType {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// PeersWithChaincodeRuntime selects the peers running the given chaincode
+// runtime. runtime is either a type such as GOLANG, matching any version, or
+// a TYPE:version pair matching that version only.
+func PeersWithChaincodeRuntime(runtime string) PeerFilter {
+	return func(ep *pb.PeerEndpoint) bool {
+		metadata := ep.GetMetadata()
+		if metadata == nil {
+			return false
+		}
+		for _, r := range metadata.ChaincodeRuntimes {
+			if r == runtime || strings.HasPrefix(r, runtime+":") {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// PeersWithLabel selects the peers labelled with the given value, such as
+// PeersWithLabel("region", "eu-west")
+func PeersWithLabel(key, value string) PeerFilter {
+	return func(ep *pb.PeerEndpoint) bool {
+		v, ok := ep.GetMetadata().GetLabels()[key]
+		return ok && v == value
+	}
+}
+
+// PeersWithMinLedgerHeight selects the peers that advertised a ledger at
+// least height blocks high
+func PeersWithMinLedgerHeight(height uint64) PeerFilter {
+	return func(ep *pb.PeerEndpoint) bool {
+		metadata := ep.GetMetadata()
+		return metadata != nil && metadata.LedgerHeight >= height
+	}
+}
+
+// AllOf selects the peers matching every one of the given filters
+func AllOf(filters ...PeerFilter) PeerFilter {
+	return func(ep *pb.PeerEndpoint) bool {
+		for _, filter := range filters {
+			if !filter(ep) {
+				return false
+			}
+		}
+		return true
+	}
+}
@@ -0,0 +1,154 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestLocalMetadata(t *testing.T) {
+	defer resetTestConfig()
+	viper.SetConfigType("yaml")
+	config := []byte(`
+peer:
+    metadata:
+        chaincodeRuntimes:
+            - GOLANG:1.6
+        labels:
+            region: eu-west
+            rack:
+`)
+	if err := viper.ReadConfig(bytes.NewBuffer(config)); err != nil {
+		t.Fatal(err)
+	}
+
+	metadata := getLocalMetadata()
+	if metadata.ProtocolVersion != fmt.Sprint(ProtocolVersion) {
+		t.Errorf("Unexpected protocol version %s", metadata.ProtocolVersion)
+	}
+	if len(metadata.Labels) != 1 || metadata.Labels["region"] != "eu-west" {
+		t.Errorf("Expected only the region label, got %v", metadata.Labels)
+	}
+
+	// The metadata must survive the DISC_HELLO exchange
+	data, err := proto.Marshal(&pb.HelloMessage{PeerEndpoint: &pb.PeerEndpoint{Metadata: metadata}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hello := &pb.HelloMessage{}
+	if err = proto.Unmarshal(data, hello); err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(hello.PeerEndpoint.Metadata, metadata) {
+		t.Errorf("Expected %s, got %s", metadata, hello.PeerEndpoint.Metadata)
+	}
+}
+
+func TestPeerFilters(t *testing.T) {
+	golang := &pb.PeerEndpoint{
+		Type: pb.PeerEndpoint_VALIDATOR,
+		Metadata: &pb.PeerMetadata{
+			MessageTypes:      []pb.Message_Type{pb.Message_DISC_HELLO, pb.Message_SYNC_GET_BLOCKS},
+			ChaincodeRuntimes: []string{"GOLANG:1.6"},
+			Labels:            map[string]string{"region": "eu-west"},
+		},
+	}
+	legacy := &pb.PeerEndpoint{Type: pb.PeerEndpoint_VALIDATOR}
+
+	tests := []struct {
+		filter  PeerFilter
+		golang  bool
+		legacy  bool
+		comment string
+	}{
+		{PeersOfType(pb.PeerEndpoint_VALIDATOR), true, true, "type"},
+		{PeersOfType(pb.PeerEndpoint_NON_VALIDATOR), false, false, "other type"},
+		{PeersSupportingMessageType(pb.Message_SYNC_GET_BLOCKS), true, false, "message type"},
+		{PeersSupportingMessageType(pb.Message_SYNC_STATE_GET_SNAPSHOT), false, false, "unsupported message type"},
+		{PeersWithChaincodeRuntime("GOLANG"), true, false, "runtime type"},
+		{PeersWithChaincodeRuntime("GOLANG:1.6"), true, false, "runtime version"},
+		{PeersWithChaincodeRuntime("GOLANG:1.5"), false, false, "other runtime version"},
+		{PeersWithLabel("region", "eu-west"), true, false, "label"},
+		{PeersWithLabel("region", "us-east"), false, false, "other label"},
+		{AllOf(PeersOfType(pb.PeerEndpoint_VALIDATOR), PeersWithLabel("region", "eu-west")), true, false, "combined"},
+	}
+	for _, test := range tests {
+		if test.filter(golang) != test.golang {
+			t.Errorf("Filter %s: expected %t for the peer with metadata", test.comment, test.golang)
+		}
+		if test.filter(legacy) != test.legacy {
+			t.Errorf("Filter %s: expected %t for the peer without metadata", test.comment, test.legacy)
+		}
+	}
+}
+
+func TestSignedPeerMetadata(t *testing.T) {
+	sign := func(msg []byte) ([]byte, error) { return append([]byte("signed:"), msg...), nil }
+	verify := func(vkID, signature, message []byte) error {
+		if !bytes.Equal(signature, append([]byte("signed:"), message...)) {
+			return fmt.Errorf("bad signature")
+		}
+		return nil
+	}
+	newEndpoint := func() *pb.PeerEndpoint {
+		ep := &pb.PeerEndpoint{ID: &pb.PeerID{Name: "vp1"}, Metadata: &pb.PeerMetadata{SoftwareVersion: "0.1.0", LedgerHeight: 7}}
+		if err := signPeerMetadata(ep, sign); err != nil {
+			t.Fatal(err)
+		}
+		return ep
+	}
+
+	// The receiver only trusts what was signed
+	ep := newEndpoint()
+	ep.Metadata.LedgerHeight = 1000
+	if err := verifyPeerMetadata(ep, verify); err != nil {
+		t.Fatalf("Error verifying metadata: %s", err)
+	}
+	if ep.Metadata.LedgerHeight != 7 {
+		t.Errorf("Expected the signed ledger height 7, got %d", ep.Metadata.LedgerHeight)
+	}
+
+	ep = newEndpoint()
+	ep.SignedMetadata.Signature = []byte("forged")
+	if err := verifyPeerMetadata(ep, verify); err == nil || ep.Metadata != nil || ep.SignedMetadata != nil {
+		t.Error("Expected metadata with a bad signature to be removed")
+	}
+
+	ep = &pb.PeerEndpoint{Metadata: &pb.PeerMetadata{LedgerHeight: 7}}
+	if err := verifyPeerMetadata(ep, verify); err != nil || ep.Metadata != nil {
+		t.Error("Expected unsigned metadata to be removed when security is enabled")
+	}
+
+	// Without security the metadata is unsigned
+	ep = &pb.PeerEndpoint{Metadata: &pb.PeerMetadata{LedgerHeight: 7}}
+	if err := signPeerMetadata(ep, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyPeerMetadata(ep, nil); err != nil || ep.Metadata.LedgerHeight != 7 {
+		t.Errorf("Expected unsigned metadata to be accepted without security, got %v", err)
+	}
+}
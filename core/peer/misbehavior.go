@@ -0,0 +1,285 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// Weight added to the score of a peer for each kind of violation
+var violationWeights = map[int]int64{
+	ViolationBadSignature:     10,
+	ViolationMalformedPayload: 5,
+	ViolationInvalidMessage:   1,
+}
+
+// violationReporter is implemented by coordinators that track the protocol
+// violations of peers, handlers report to it and ask it to admit messages
+type violationReporter interface {
+	reportViolation(ep *pb.PeerEndpoint, violation *ProtocolViolationError) pb.PeerStanding_Level
+	admitMessage(ep *pb.PeerEndpoint, msgType pb.Message_Type) error
+}
+
+// misbehaviorTracker scores the protocol violations of each peer. A peer
+// whose score reaches greylistScore has its messages rate limited, one that
+// reaches blacklistScore has its chats closed and refused for cooldown. The
+// score is forgotten cooldown after the last violation. Peers are tracked by
+// their ID once it is verified by signature, else by the host they connect
+// from, see Handler.offender.
+type misbehaviorTracker struct {
+	sync.Mutex
+	greylistScore  int64
+	blacklistScore int64
+	cooldown       time.Duration
+	// messages per second admitted from a greylisted peer
+	rate  float64
+	peers map[string]*misbehavior
+}
+
+type misbehavior struct {
+	standing pb.PeerStanding
+	// rate limit of a greylisted peer
+	tokens   float64
+	refilled time.Time
+}
+
+// newMisbehaviorTracker returns nil, tracking nothing, if both scores are 0
+func newMisbehaviorTracker(greylistScore, blacklistScore int, cooldown time.Duration, rate int) *misbehaviorTracker {
+	if greylistScore <= 0 && blacklistScore <= 0 {
+		return nil
+	}
+	if rate <= 0 {
+		rate = 1
+	}
+	return &misbehaviorTracker{greylistScore: int64(greylistScore), blacklistScore: int64(blacklistScore), cooldown: cooldown, rate: float64(rate), peers: make(map[string]*misbehavior)}
+}
+
+// offenderKey returns the key the peer at ep is tracked by, its ID if set,
+// else its address
+func offenderKey(ep *pb.PeerEndpoint) string {
+	if ep.ID != nil {
+		return "id " + ep.ID.Name
+	}
+	return "host " + addressHost(ep.Address)
+}
+
+// offenderID returns the ID of the tracked peer, its host if its ID is not
+// verified
+func offenderID(s *pb.PeerStanding) pb.PeerID {
+	if s.PeerID != nil {
+		return *s.PeerID
+	}
+	return pb.PeerID{Name: s.Address}
+}
+
+// addressHost returns the host of address, address itself if it has no port
+func addressHost(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
+
+// refresh lets the standing of m lapse once its cooldown is over
+func (t *misbehaviorTracker) refresh(m *misbehavior, now time.Time) {
+	s := &m.standing
+	switch {
+	case s.Level == pb.PeerStanding_BLACKLISTED && now.UnixNano() < s.UntilNanos:
+	case now.UnixNano()-s.LastViolationNanos < int64(t.cooldown):
+	default:
+		s.Level, s.Score, s.UntilNanos = pb.PeerStanding_GOOD, 0, 0
+	}
+}
+
+// report records violation by the peer at ep and returns its standing
+func (t *misbehaviorTracker) report(ep *pb.PeerEndpoint, violation *ProtocolViolationError) pb.PeerStanding_Level {
+	t.Lock()
+	defer t.Unlock()
+	now := time.Now()
+	for key, m := range t.peers {
+		if t.refresh(m, now); m.standing.Score == 0 {
+			delete(t.peers, key)
+		}
+	}
+	key := offenderKey(ep)
+	m, ok := t.peers[key]
+	if !ok {
+		m = &misbehavior{standing: pb.PeerStanding{PeerID: ep.ID}}
+		t.peers[key] = m
+	}
+	s := &m.standing
+	s.Address = addressHost(ep.Address)
+	switch violation.Kind {
+	case ViolationBadSignature:
+		s.BadSignatures++
+	case ViolationMalformedPayload:
+		s.MalformedPayloads++
+	default:
+		s.InvalidMessages++
+	}
+	s.Score += violationWeights[violation.Kind]
+	s.LastViolation = violation.Error()
+	s.LastViolationNanos = now.UnixNano()
+
+	level := s.Level
+	if t.blacklistScore > 0 && s.Score >= t.blacklistScore {
+		s.Level = pb.PeerStanding_BLACKLISTED
+		s.UntilNanos = now.Add(t.cooldown).UnixNano()
+	} else if t.greylistScore > 0 && s.Score >= t.greylistScore && level == pb.PeerStanding_GOOD {
+		s.Level = pb.PeerStanding_GREYLISTED
+		m.tokens, m.refilled = t.rate, now
+	}
+	if s.Level != level {
+		peerLogger.Warning("Peer %s is %s after %d protocol violation score, last: %s", offenderID(s).Name, s.Level, s.Score, s.LastViolation)
+	}
+	return s.Level
+}
+
+// admit returns an error if a message of type msgType from the peer at ep is
+// to be dropped
+func (t *misbehaviorTracker) admit(ep *pb.PeerEndpoint, msgType pb.Message_Type) error {
+	t.Lock()
+	defer t.Unlock()
+	m, ok := t.peers[offenderKey(ep)]
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	t.refresh(m, now)
+	switch m.standing.Level {
+	case pb.PeerStanding_BLACKLISTED:
+		return &PeerBlacklistedError{ID: offenderID(&m.standing), Until: time.Unix(0, m.standing.UntilNanos)}
+	case pb.PeerStanding_GREYLISTED:
+		m.tokens += now.Sub(m.refilled).Seconds() * t.rate
+		m.refilled = now
+		if m.tokens > t.rate {
+			m.tokens = t.rate
+		}
+		if m.tokens < 1 {
+			return &PeerGreylistedError{ID: offenderID(&m.standing), Type: msgType}
+		}
+		m.tokens--
+	}
+	return nil
+}
+
+// blacklisted returns an error if the peer with the given ID, or any peer on
+// the host of address, is blacklisted
+func (t *misbehaviorTracker) blacklisted(id *pb.PeerID, address string) error {
+	t.Lock()
+	defer t.Unlock()
+	now := time.Now()
+	host := addressHost(address)
+	for _, m := range t.peers {
+		s := &m.standing
+		if !(id != nil && s.PeerID != nil && *s.PeerID == *id) && !(host != "" && s.Address == host) {
+			continue
+		}
+		if t.refresh(m, now); s.Level == pb.PeerStanding_BLACKLISTED {
+			return &PeerBlacklistedError{ID: offenderID(s), Until: time.Unix(0, s.UntilNanos)}
+		}
+	}
+	return nil
+}
+
+// standings returns the standing of the peers with recent violations ordered
+// by peer ID, or host for the peers not verified
+func (t *misbehaviorTracker) standings() *pb.PeerStandings {
+	t.Lock()
+	defer t.Unlock()
+	now := time.Now()
+	standings := &pb.PeerStandings{}
+	for _, m := range t.peers {
+		t.refresh(m, now)
+		s := m.standing
+		standings.Peers = append(standings.Peers, &s)
+	}
+	sort.Sort(standingsByID(standings.Peers))
+	return standings
+}
+
+// clear forgets the violations of the peer with the given ID, or of the
+// peers not verified on the host named by it, of all peers if id is nil
+func (t *misbehaviorTracker) clear(id *pb.PeerID) {
+	t.Lock()
+	defer t.Unlock()
+	if id == nil {
+		t.peers = make(map[string]*misbehavior)
+		return
+	}
+	delete(t.peers, offenderKey(&pb.PeerEndpoint{ID: id}))
+	delete(t.peers, offenderKey(&pb.PeerEndpoint{Address: id.Name}))
+}
+
+type standingsByID []*pb.PeerStanding
+
+func (a standingsByID) Len() int           { return len(a) }
+func (a standingsByID) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a standingsByID) Less(i, j int) bool { return offenderID(a[i]).Name < offenderID(a[j]).Name }
+
+// reportViolation records a protocol violation by the peer at ep and returns
+// its standing. Addresses on the host of a blacklisted peer are refused when
+// dialed until the cooldown ends.
+func (p *PeerImpl) reportViolation(ep *pb.PeerEndpoint, violation *ProtocolViolationError) pb.PeerStanding_Level {
+	if p.misbehavior == nil {
+		return pb.PeerStanding_GOOD
+	}
+	return p.misbehavior.report(ep, violation)
+}
+
+// admitMessage returns an error if a message of type msgType from the peer at
+// ep is to be dropped
+func (p *PeerImpl) admitMessage(ep *pb.PeerEndpoint, msgType pb.Message_Type) error {
+	if p.misbehavior == nil {
+		return nil
+	}
+	return p.misbehavior.admit(ep, msgType)
+}
+
+// checkBlacklist returns an error if the peer with the given ID or address is
+// blacklisted
+func (p *PeerImpl) checkBlacklist(id *pb.PeerID, address string) error {
+	if p.misbehavior == nil {
+		return nil
+	}
+	return p.misbehavior.blacklisted(id, address)
+}
+
+// GetPeerStandings returns the peers with recent protocol violations
+func (p *PeerImpl) GetPeerStandings() *pb.PeerStandings {
+	if p.misbehavior == nil {
+		return &pb.PeerStandings{}
+	}
+	return p.misbehavior.standings()
+}
+
+// ClearPeerStandings forgets the violations of the peer with the given ID, of
+// all peers if id is nil, and returns the remaining standings
+func (p *PeerImpl) ClearPeerStandings(id *pb.PeerID) *pb.PeerStandings {
+	if p.misbehavior != nil {
+		p.misbehavior.clear(id)
+	}
+	return p.GetPeerStandings()
+}
@@ -0,0 +1,182 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/looplab/fsm"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestMisbehaviorTracker_GreylistsThenBlacklists(t *testing.T) {
+	tracker := newMisbehaviorTracker(5, 20, time.Minute, 2)
+	ep := &pb.PeerEndpoint{ID: &pb.PeerID{Name: "rogue"}, Address: "rogue:30303"}
+
+	if level := tracker.report(ep, &ProtocolViolationError{Kind: ViolationInvalidMessage, Err: fmt.Errorf("invalid")}); level != pb.PeerStanding_GOOD {
+		t.Fatalf("Expected the peer to stay in good standing, got %s", level)
+	}
+	if level := tracker.report(ep, &ProtocolViolationError{Kind: ViolationMalformedPayload, Err: fmt.Errorf("malformed")}); level != pb.PeerStanding_GREYLISTED {
+		t.Fatalf("Expected the peer to be greylisted, got %s", level)
+	}
+	for i := 0; i < 2; i++ {
+		if err := tracker.admit(ep, pb.Message_DISC_PEERS); err != nil {
+			t.Fatalf("Expected message %d to be admitted: %s", i, err)
+		}
+	}
+	if _, ok := tracker.admit(ep, pb.Message_DISC_PEERS).(*PeerGreylistedError); !ok {
+		t.Fatal("Expected messages over the rate to be dropped")
+	}
+
+	for i := 0; i < 2; i++ {
+		tracker.report(ep, &ProtocolViolationError{Kind: ViolationBadSignature, Err: fmt.Errorf("bad signature")})
+	}
+	if _, ok := tracker.admit(ep, pb.Message_DISC_PEERS).(*PeerBlacklistedError); !ok {
+		t.Fatal("Expected the peer to be blacklisted")
+	}
+	if tracker.blacklisted(nil, "rogue:30304") == nil {
+		t.Fatal("Expected the host of the peer to be blacklisted")
+	}
+	if tracker.blacklisted(&pb.PeerID{Name: "other"}, "other:30303") != nil {
+		t.Fatal("Expected other peers not to be blacklisted")
+	}
+
+	standings := tracker.standings()
+	if len(standings.Peers) != 1 {
+		t.Fatalf("Expected 1 standing, got %v", standings)
+	}
+	s := standings.Peers[0]
+	if s.Level != pb.PeerStanding_BLACKLISTED || s.Score != 26 || s.BadSignatures != 2 || s.MalformedPayloads != 1 || s.InvalidMessages != 1 || s.LastViolation != "bad signature" || s.UntilNanos == 0 {
+		t.Fatalf("Unexpected standing %v", s)
+	}
+
+	tracker.clear(ep.ID)
+	if len(tracker.standings().Peers) != 0 || tracker.admit(ep, pb.Message_DISC_PEERS) != nil {
+		t.Fatal("Expected the violations of the peer to be forgotten")
+	}
+}
+
+func TestMisbehaviorTracker_UnverifiedPeersByHost(t *testing.T) {
+	tracker := newMisbehaviorTracker(0, 1, time.Minute, 0)
+	first := &pb.PeerEndpoint{Address: "10.0.0.9:40001"}
+	if level := tracker.report(first, &ProtocolViolationError{Kind: ViolationInvalidMessage, Err: fmt.Errorf("invalid")}); level != pb.PeerStanding_BLACKLISTED {
+		t.Fatalf("Expected the host to be blacklisted, got %s", level)
+	}
+	// Reconnecting from another port under another claimed ID does not help
+	if _, ok := tracker.admit(&pb.PeerEndpoint{Address: "10.0.0.9:40002"}, pb.Message_DISC_PEERS).(*PeerBlacklistedError); !ok {
+		t.Fatal("Expected the messages from the host to be dropped")
+	}
+	if tracker.blacklisted(&pb.PeerID{Name: "innocent"}, "10.0.0.9:30303") == nil {
+		t.Fatal("Expected the host to be refused")
+	}
+	if tracker.blacklisted(&pb.PeerID{Name: "10.0.0.9"}, "10.0.0.10:30303") != nil {
+		t.Fatal("Expected a peer naming itself after the host not to be refused")
+	}
+	if s := tracker.standings().Peers[0]; s.PeerID != nil || s.Address != "10.0.0.9" {
+		t.Fatalf("Expected the standing of the host, got %v", s)
+	}
+	tracker.clear(&pb.PeerID{Name: "10.0.0.9"})
+	if len(tracker.standings().Peers) != 0 {
+		t.Fatal("Expected the violations of the host to be forgotten")
+	}
+}
+
+func TestMisbehaviorTracker_Cooldown(t *testing.T) {
+	tracker := newMisbehaviorTracker(0, 1, 20*time.Millisecond, 0)
+	ep := &pb.PeerEndpoint{ID: &pb.PeerID{Name: "rogue"}, Address: "rogue:30303"}
+	if level := tracker.report(ep, &ProtocolViolationError{Kind: ViolationInvalidMessage, Err: fmt.Errorf("invalid")}); level != pb.PeerStanding_BLACKLISTED {
+		t.Fatalf("Expected the peer to be blacklisted, got %s", level)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if err := tracker.admit(ep, pb.Message_DISC_PEERS); err != nil {
+		t.Fatalf("Expected the blacklisting to lapse: %s", err)
+	}
+	if s := tracker.standings().Peers[0]; s.Level != pb.PeerStanding_GOOD || s.Score != 0 || s.InvalidMessages != 1 {
+		t.Fatalf("Expected the score to be forgotten, got %v", s)
+	}
+}
+
+func TestHandler_ViolationsBlacklistPeer(t *testing.T) {
+	p := newMeshTestPeer(0)
+	p.misbehavior = newMisbehaviorTracker(0, 6, time.Minute, 0)
+	rogue := &pb.PeerEndpoint{ID: &pb.PeerID{Name: "rogue"}, Address: "rogue:30303"}
+	stream := newAbortableChatStream(nil)
+	stream.remoteAddress = "rogue:40001"
+	h := &Handler{Coordinator: p, ToPeerEndpoint: rogue, ChatStream: stream, protocolVersion: ProtocolVersion, identityVerified: true}
+	h.FSM = fsm.NewFSM("established", fsm.Events{{Name: pb.Message_DISC_PEERS.String(), Src: []string{"established"}, Dst: "established"}},
+		fsm.Callbacks{"before_" + pb.Message_DISC_PEERS.String(): func(e *fsm.Event) { h.beforePeers(e) }})
+
+	// Not expected in the state of the chat
+	err := h.HandleMessage(&pb.Message{Type: pb.Message_SYNC_GET_BLOCKS})
+	if v, ok := err.(*ProtocolViolationError); !ok || v.Kind != ViolationInvalidMessage {
+		t.Fatalf("Expected an invalid message violation, got %v", err)
+	}
+	// Not a PeersMessage
+	err = h.HandleMessage(&pb.Message{Type: pb.Message_DISC_PEERS, Payload: []byte{0xff}})
+	if v, ok := err.(*ProtocolViolationError); !ok || v.Kind != ViolationMalformedPayload {
+		t.Fatalf("Expected a malformed payload violation, got %v", err)
+	}
+	if !h.chatAborted() {
+		t.Fatal("Expected the chat with the blacklisted peer to be closed")
+	}
+	if _, ok := h.HandleMessage(&pb.Message{Type: pb.Message_DISC_PEERS}).(*PeerBlacklistedError); !ok {
+		t.Fatal("Expected messages from the blacklisted peer to be dropped")
+	}
+	if _, ok := p.RegisterHandler(h).(*PeerBlacklistedError); !ok {
+		t.Fatal("Expected the blacklisted peer not to be registered")
+	}
+
+	standings := p.GetPeerStandings()
+	if len(standings.Peers) != 1 || standings.Peers[0].Level != pb.PeerStanding_BLACKLISTED {
+		t.Fatalf("Expected the peer to be reported blacklisted, got %v", standings)
+	}
+	if standings = p.ClearPeerStandings(nil); len(standings.Peers) != 0 {
+		t.Fatalf("Expected the standings to be cleared, got %v", standings)
+	}
+	if err = p.RegisterHandler(h); err != nil {
+		t.Fatalf("Expected the cleared peer to be registered: %s", err)
+	}
+}
+
+func TestHandler_ViolationsKeepConsensusChat(t *testing.T) {
+	p := newMeshTestPeer(0)
+	p.misbehavior = newMisbehaviorTracker(0, 1, time.Minute, 0)
+	vp := &pb.PeerEndpoint{ID: &pb.PeerID{Name: "vp1"}, Address: "vp1:30303", Type: pb.PeerEndpoint_VALIDATOR}
+	stream := newAbortableChatStream(nil)
+	stream.remoteAddress = "vp1:40001"
+	h := &Handler{Coordinator: p, ToPeerEndpoint: vp, ChatStream: stream, protocolVersion: ProtocolVersion, identityVerified: true, validator: true}
+	h.FSM = fsm.NewFSM("established", fsm.Events{}, fsm.Callbacks{})
+
+	if _, ok := h.HandleMessage(&pb.Message{Type: pb.Message_SYNC_GET_BLOCKS}).(*ProtocolViolationError); !ok {
+		t.Fatal("Expected a protocol violation")
+	}
+	if h.chatAborted() {
+		t.Fatal("Expected the chat between validators to be kept")
+	}
+	if standings := p.GetPeerStandings(); len(standings.Peers) != 1 || standings.Peers[0].Level != pb.PeerStanding_BLACKLISTED {
+		t.Fatalf("Expected the violation to be reported, got %v", standings)
+	}
+	if err := p.RegisterHandler(h); err != nil {
+		t.Fatalf("Expected the validator to be registered: %s", err)
+	}
+}
@@ -0,0 +1,266 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+
+	"github.com/hyperledger/fabric/core/tracing"
+	"github.com/hyperledger/fabric/core/util"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+var errChannelClosed = errors.New("Chat ended before the response was received")
+
+// connPool shares one gRPC connection per peer address between the chat,
+// the transactions forwarded to that peer and those sent to this peer itself.
+// A connection nobody uses is closed after idleTimeout.
+type connPool struct {
+	sync.Mutex
+	dial        func(address string) (*grpc.ClientConn, error)
+	idleTimeout time.Duration
+	conns       map[string]*pooledConn
+}
+
+type pooledConn struct {
+	conn    *grpc.ClientConn
+	refs    int
+	evicted bool // No longer handed out, closed once unused
+	idle    *time.Timer
+}
+
+func newConnPool(dial func(address string) (*grpc.ClientConn, error), idleTimeout time.Duration) *connPool {
+	return &connPool{dial: dial, idleTimeout: idleTimeout, conns: make(map[string]*pooledConn)}
+}
+
+// acquire returns the connection to address, dialing it if there is none.
+// The caller calls release once done with it, with broken set if the
+// connection failed so that the next caller dials again.
+func (cp *connPool) acquire(address string) (*grpc.ClientConn, func(broken bool), error) {
+	cp.Lock()
+	pc, ok := cp.conns[address]
+	if !ok {
+		// Don't hold the pool while dialing
+		cp.Unlock()
+		conn, err := cp.dial(address)
+		if err != nil {
+			return nil, nil, err
+		}
+		cp.Lock()
+		if pc, ok = cp.conns[address]; ok {
+			// Dialed concurrently, use the connection pooled first
+			conn.Close()
+		} else {
+			pc = &pooledConn{conn: conn}
+			cp.conns[address] = pc
+		}
+	}
+	pc.refs++
+	if pc.idle != nil {
+		pc.idle.Stop()
+		pc.idle = nil
+	}
+	cp.Unlock()
+	var once sync.Once
+	return pc.conn, func(broken bool) { once.Do(func() { cp.release(address, pc, broken) }) }, nil
+}
+
+func (cp *connPool) release(address string, pc *pooledConn, broken bool) {
+	cp.Lock()
+	defer cp.Unlock()
+	pc.refs--
+	if broken {
+		cp.evict(address, pc)
+	}
+	if pc.refs > 0 {
+		return
+	}
+	if pc.evicted || cp.idleTimeout <= 0 {
+		cp.evict(address, pc)
+		pc.conn.Close()
+		return
+	}
+	var idle *time.Timer
+	idle = time.AfterFunc(cp.idleTimeout, func() {
+		cp.Lock()
+		defer cp.Unlock()
+		// Acquired again meanwhile otherwise
+		if pc.idle == idle {
+			peerLogger.Debug("Closing connection to peer address=%s unused for %s", address, cp.idleTimeout)
+			cp.evict(address, pc)
+			pc.conn.Close()
+		}
+	})
+	pc.idle = idle
+}
+
+// evict stops handing out the connection pc, it is closed once unused
+func (cp *connPool) evict(address string, pc *pooledConn) {
+	pc.evicted = true
+	pc.idle = nil
+	if cp.conns[address] == pc {
+		delete(cp.conns, address)
+	}
+}
+
+// size returns the number of connections handed out
+func (cp *connPool) size() int {
+	cp.Lock()
+	defer cp.Unlock()
+	return len(cp.conns)
+}
+
+// requestChannels pairs the requests sent on logical channels of a chat with
+// the RESPONSE the remote peer sends on each
+type requestChannels struct {
+	sync.Mutex
+	next    uint64
+	pending map[uint64]chan *pb.Message
+	closed  bool
+}
+
+// open allocates a channel for a request, its response is delivered on the
+// returned chan which is closed if the chat ends first
+func (rc *requestChannels) open() (uint64, <-chan *pb.Message, error) {
+	rc.Lock()
+	defer rc.Unlock()
+	if rc.closed {
+		return 0, nil, errChannelClosed
+	}
+	if rc.pending == nil {
+		rc.pending = make(map[uint64]chan *pb.Message)
+	}
+	rc.next++
+	responses := make(chan *pb.Message, 1)
+	rc.pending[rc.next] = responses
+	return rc.next, responses, nil
+}
+
+// deliver passes msg to the request waiting on its channel, it returns false
+// if there is none
+func (rc *requestChannels) deliver(msg *pb.Message) bool {
+	rc.Lock()
+	defer rc.Unlock()
+	responses, ok := rc.pending[msg.Channel]
+	if ok {
+		delete(rc.pending, msg.Channel)
+		responses <- msg
+	}
+	return ok
+}
+
+// forget releases the channel of a request no longer waiting
+func (rc *requestChannels) forget(channel uint64) {
+	rc.Lock()
+	defer rc.Unlock()
+	delete(rc.pending, channel)
+}
+
+// close fails the requests waiting and those opened later
+func (rc *requestChannels) close() {
+	rc.Lock()
+	defer rc.Unlock()
+	rc.closed = true
+	for channel, responses := range rc.pending {
+		close(responses)
+		delete(rc.pending, channel)
+	}
+}
+
+// request sends msg on a new channel of the chat and returns the RESPONSE the
+// remote peer sends on that channel, waiting up to timeout
+func (d *Handler) request(msg *pb.Message, timeout time.Duration) (*pb.Message, error) {
+	if version := atomic.LoadUint32(&d.protocolVersion); version < channelProtocolVersion {
+		return nil, &ChannelUnsupportedError{Version: version}
+	}
+	channel, responses, err := d.channels.open()
+	if err != nil {
+		return nil, err
+	}
+	defer d.channels.forget(channel)
+	request := *msg
+	request.Channel = channel
+	if err = d.SendMessage(&request); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case response, ok := <-responses:
+		if !ok {
+			return nil, errChannelClosed
+		}
+		return response, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("No %s on channel %d within %s", pb.Message_RESPONSE, channel, timeout)
+	}
+}
+
+// chatTo returns the handler of the established chat to peerAddress, nil if
+// there is none
+func (p *PeerImpl) chatTo(peerAddress string) *Handler {
+	p.handlerMap.RLock()
+	defer p.handlerMap.RUnlock()
+	for _, msgHandler := range p.handlerMap.m {
+		if h, ok := msgHandler.(*Handler); ok {
+			if ep, err := h.To(); err == nil && ep.Address == peerAddress {
+				return h
+			}
+		}
+	}
+	return nil
+}
+
+// sendOnChannel sends transaction on a channel of the established chat to
+// peerAddress. It returns nil if the transaction could not be sent that way,
+// the caller then sends it on a chat of its own; the peer ignores the
+// transaction if it did receive it.
+func (p *PeerImpl) sendOnChannel(peerAddress string, transaction *pb.Transaction, span *tracing.Span) *pb.Response {
+	if !viper.GetBool("peer.multiplex.channels") {
+		return nil
+	}
+	handler := p.chatTo(peerAddress)
+	if handler == nil {
+		return nil
+	}
+	payload, err := proto.Marshal(transaction)
+	if err != nil {
+		return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(fmt.Sprintf("Error marshalling transaction to peer address=%s:  %s", peerAddress, err))}
+	}
+	msg := &pb.Message{Type: pb.Message_CHAIN_TRANSACTION, Payload: payload, Timestamp: util.CreateUtcTimestamp(), TraceContext: span.Context()}
+	in, err := handler.request(msg, viper.GetDuration("peer.multiplex.responseTimeout"))
+	if err != nil {
+		peerLogger.Debug("Could not send transaction %s on a channel to peer address=%s: %s", transaction.Uuid, peerAddress, err)
+		return nil
+	}
+	response := &pb.Response{}
+	if err = proto.Unmarshal(in.Payload, response); err != nil {
+		response = &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(fmt.Sprintf("Error unpacking Payload from %s message: %s", in.Type, err))}
+	}
+	return response
+}
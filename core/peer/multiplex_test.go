@@ -0,0 +1,163 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/looplab/fsm"
+	"github.com/spf13/viper"
+	"google.golang.org/grpc"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func newCountingPool(idleTimeout time.Duration) (*connPool, *int) {
+	dials := 0
+	return newConnPool(func(address string) (*grpc.ClientConn, error) {
+		dials++
+		return grpc.Dial(address, grpc.WithInsecure())
+	}, idleTimeout), &dials
+}
+
+func TestConnPool_SharesConnectionPerAddress(t *testing.T) {
+	pool, dials := newCountingPool(20 * time.Millisecond)
+	first, releaseFirst, err := pool.acquire("localhost:30399")
+	if err != nil {
+		t.Fatalf("Error acquiring connection: %s", err)
+	}
+	second, releaseSecond, _ := pool.acquire("localhost:30399")
+	if first != second || *dials != 1 {
+		t.Fatalf("Expected one connection shared per address, dialed %d", *dials)
+	}
+	_, releaseOther, _ := pool.acquire("localhost:30398")
+	if *dials != 2 || pool.size() != 2 {
+		t.Fatalf("Expected a connection per address, dialed %d", *dials)
+	}
+	releaseOther(false)
+	releaseFirst(false)
+	// Releasing twice must not drop the reference of the other user
+	releaseFirst(false)
+	time.Sleep(50 * time.Millisecond)
+	if pool.size() != 1 {
+		t.Fatalf("Expected the unused connection only to be closed, %d left", pool.size())
+	}
+	releaseSecond(false)
+	for deadline := time.Now().Add(time.Second); pool.size() != 0; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the connection to be closed once unused")
+		}
+	}
+}
+
+func TestConnPool_RedialsBrokenConnection(t *testing.T) {
+	pool, dials := newCountingPool(time.Minute)
+	broken, releaseBroken, _ := pool.acquire("localhost:30399")
+	_, releaseUser, _ := pool.acquire("localhost:30399")
+	releaseBroken(true)
+	fresh, releaseFresh, _ := pool.acquire("localhost:30399")
+	if fresh == broken || *dials != 2 {
+		t.Fatalf("Expected a broken connection to be dialed again, dialed %d", *dials)
+	}
+	releaseUser(false)
+	releaseFresh(false)
+	if pool.size() != 1 {
+		t.Fatalf("Expected the fresh connection only to be pooled, got %d", pool.size())
+	}
+}
+
+func newChannelTestHandler(p *PeerImpl, version uint32) (*Handler, *mockChatStream) {
+	stream := &mockChatStream{sent: make(chan *pb.Message, 10)}
+	d := newMeshTestHandler("vp0", pb.PeerEndpoint_VALIDATOR)
+	d.ChatStream = stream
+	d.Coordinator = p
+	d.protocolVersion = version
+	d.FSM = fsm.NewFSM("established", fsm.Events{}, fsm.Callbacks{})
+	return d, stream
+}
+
+// respond answers the next request sent on stream with response, the
+// returned channel is closed once the handler is done with it
+func respond(t *testing.T, d *Handler, stream *mockChatStream, response *pb.Response) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		msg := <-stream.sent
+		payload, _ := proto.Marshal(response)
+		if err := d.handleMessage(&pb.Message{Type: pb.Message_RESPONSE, Payload: payload, Channel: msg.Channel}); err != nil {
+			t.Errorf("Error handling %s: %s", pb.Message_RESPONSE, err)
+		}
+	}()
+	return done
+}
+
+func TestSendOnChannel_UsesEstablishedChat(t *testing.T) {
+	viper.Set("peer.multiplex.channels", true)
+	viper.Set("peer.multiplex.responseTimeout", "1s")
+	p := newMeshTestPeer(0)
+	d, stream := newChannelTestHandler(p, ProtocolVersion)
+	if err := p.RegisterHandler(d); err != nil {
+		t.Fatalf("Error registering handler: %s", err)
+	}
+	done := respond(t, d, stream, &pb.Response{Status: pb.Response_SUCCESS, Msg: []byte("tx1")})
+
+	tx := &pb.Transaction{Type: pb.Transaction_CHAINCODE_INVOKE, Uuid: "tx1"}
+	response := p.sendOnChannel("vp0:30303", tx, nil)
+	if response == nil || response.Status != pb.Response_SUCCESS || string(response.Msg) != "tx1" {
+		t.Fatalf("Expected the response on the channel, got %v", response)
+	}
+	<-done
+	if response := p.sendOnChannel("vp1:30303", tx, nil); response != nil {
+		t.Fatalf("Expected no channel without a chat to the peer, got %v", response)
+	}
+}
+
+func TestSendOnChannel_FallsBackBeforeChannels(t *testing.T) {
+	viper.Set("peer.multiplex.channels", true)
+	p := newMeshTestPeer(0)
+	d, _ := newChannelTestHandler(p, channelProtocolVersion-1)
+	if err := p.RegisterHandler(d); err != nil {
+		t.Fatalf("Error registering handler: %s", err)
+	}
+	if _, err := d.request(&pb.Message{Type: pb.Message_CHAIN_TRANSACTION}, time.Second); err == nil {
+		t.Fatal("Expected a request on a channel to fail at a protocol version predating channels")
+	}
+	tx := &pb.Transaction{Type: pb.Transaction_CHAINCODE_INVOKE, Uuid: "tx1"}
+	if response := p.sendOnChannel("vp0:30303", tx, nil); response != nil {
+		t.Fatalf("Expected the transaction to be sent on a chat of its own, got %v", response)
+	}
+}
+
+func TestHandler_RequestFailsWhenChatEnds(t *testing.T) {
+	d, stream := newChannelTestHandler(newMeshTestPeer(0), ProtocolVersion)
+	go func() {
+		<-stream.sent
+		d.Stop()
+	}()
+	if _, err := d.request(&pb.Message{Type: pb.Message_CHAIN_TRANSACTION}, time.Second); err != errChannelClosed {
+		t.Fatalf("Expected %s, got %v", errChannelClosed, err)
+	}
+	// A RESPONSE nobody waits for is dropped
+	if err := d.handleMessage(&pb.Message{Type: pb.Message_RESPONSE, Channel: 1}); err != nil {
+		t.Fatalf("Expected a late %s to be dropped, got %s", pb.Message_RESPONSE, err)
+	}
+}
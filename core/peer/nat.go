@@ -0,0 +1,176 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// externalAddress caches the host detected through peer.nat.detectURL, an
+// empty host records a failed detection so it is not retried on every hello
+var externalAddress struct {
+	sync.Mutex
+	url  string
+	host string
+}
+
+// AdvertisedAddress returns the address other peers are told to connect to
+// this peer on: peer.advertisedAddress if set, otherwise the external host
+// detected through peer.nat.detectURL with the port of the local address,
+// otherwise the local address
+func AdvertisedAddress() (string, error) {
+	if address := viper.GetString("peer.advertisedAddress"); address != "" {
+		return address, nil
+	}
+	localAddress, err := GetLocalAddress()
+	if err != nil {
+		return "", err
+	}
+	url := viper.GetString("peer.nat.detectURL")
+	if url == "" {
+		return localAddress, nil
+	}
+	host := detectExternalHost(url)
+	if host == "" {
+		return localAddress, nil
+	}
+	_, port, err := net.SplitHostPort(localAddress)
+	if err != nil {
+		return "", fmt.Errorf("Error building the advertised address: %s", err)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// detectExternalHost asks url, once, for the host this peer is seen as. The
+// response body is the host, surrounding white space is ignored.
+func detectExternalHost(url string) string {
+	externalAddress.Lock()
+	defer externalAddress.Unlock()
+	if externalAddress.url == url {
+		return externalAddress.host
+	}
+	externalAddress.url, externalAddress.host = url, ""
+	client := &http.Client{Timeout: defaultTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		peerLogger.Warning("Error detecting the external address, advertising the local address: %s", err)
+		return ""
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		peerLogger.Warning("Error detecting the external address, advertising the local address: status %d, %v", resp.StatusCode, err)
+		return ""
+	}
+	host := strings.TrimSpace(string(body))
+	if net.ParseIP(host) == nil {
+		peerLogger.Warning("Detected external address %q is not an IP, advertising the local address", host)
+		return ""
+	}
+	peerLogger.Info("Detected external address: %s", host)
+	externalAddress.host = host
+	return host
+}
+
+// reachability is the outcome of probing an address
+type reachability struct {
+	reachable bool
+	at        time.Time
+}
+
+// reachabilityProber checks that discovered addresses accept connections
+// before they are added to the peer list, so that addresses advertised from
+// behind NAT do not fill it with dead endpoints. Outcomes are kept for ttl.
+type reachabilityProber struct {
+	sync.Mutex
+	timeout time.Duration
+	ttl     time.Duration
+	results map[string]reachability
+	probing map[string]bool
+
+	// dial returns nil if address accepts a connection within timeout
+	dial func(address string, timeout time.Duration) error
+}
+
+// newReachabilityProber returns nil, probing nothing, if timeout is not positive
+func newReachabilityProber(timeout, ttl time.Duration) *reachabilityProber {
+	if timeout <= 0 {
+		return nil
+	}
+	return &reachabilityProber{timeout: timeout, ttl: ttl, results: make(map[string]reachability), probing: make(map[string]bool), dial: dialTCP}
+}
+
+func dialTCP(address string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// known returns whether address was found reachable by a probe that has not
+// expired, known is false if it needs probing
+func (r *reachabilityProber) known(address string) (reachable bool, known bool) {
+	r.Lock()
+	defer r.Unlock()
+	result, ok := r.results[address]
+	if !ok || time.Since(result.at) > r.ttl {
+		return false, false
+	}
+	return result.reachable, true
+}
+
+// probe dials address unless a probe of it is already running and calls
+// reachable if it accepts the connection
+func (r *reachabilityProber) probe(address string, reachable func()) {
+	r.Lock()
+	if r.probing[address] {
+		r.Unlock()
+		return
+	}
+	r.probing[address] = true
+	r.Unlock()
+
+	err := r.dial(address, r.timeout)
+	if err != nil {
+		peerLogger.Debug("Discovered peer address %s is not reachable: %s", address, err)
+	}
+
+	r.Lock()
+	delete(r.probing, address)
+	for other, result := range r.results {
+		if time.Since(result.at) > r.ttl {
+			delete(r.results, other)
+		}
+	}
+	r.results[address] = reachability{reachable: err == nil, at: time.Now()}
+	r.Unlock()
+	if err == nil {
+		reachable()
+	}
+}
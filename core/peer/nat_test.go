@@ -0,0 +1,119 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestAdvertisedAddress(t *testing.T) {
+	defer viper.Set("peer.advertisedAddress", "")
+	defer viper.Set("peer.nat.detectURL", "")
+	defer viper.Set("peer.address", viper.GetString("peer.address"))
+	defer viper.Set("peer.addressAutoDetect", viper.GetBool("peer.addressAutoDetect"))
+	viper.Set("peer.address", "10.0.0.5:30303")
+	viper.Set("peer.addressAutoDetect", false)
+	local, port := "10.0.0.5:30303", "30303"
+
+	if address, _ := AdvertisedAddress(); address != local {
+		t.Fatalf("Expected the local address %s to be advertised, got %s", local, address)
+	}
+
+	detections := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		detections++
+		fmt.Fprintln(w, "203.0.113.7")
+	}))
+	defer ts.Close()
+	viper.Set("peer.nat.detectURL", ts.URL)
+	for i := 0; i < 2; i++ {
+		if address, err := AdvertisedAddress(); err != nil || address != net.JoinHostPort("203.0.113.7", port) {
+			t.Fatalf("Expected the detected address to be advertised, got %s", address)
+		}
+	}
+	if detections != 1 {
+		t.Fatalf("Expected the external address to be detected once, detected %d times", detections)
+	}
+
+	viper.Set("peer.advertisedAddress", "peer0.example.com:30303")
+	if address, _ := AdvertisedAddress(); address != "peer0.example.com:30303" {
+		t.Fatalf("Expected the configured address to be advertised, got %s", address)
+	}
+
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html>")
+	}))
+	defer bad.Close()
+	viper.Set("peer.advertisedAddress", "")
+	viper.Set("peer.nat.detectURL", bad.URL)
+	if address, _ := AdvertisedAddress(); address != local {
+		t.Fatalf("Expected the local address to be advertised when detection fails, got %s", address)
+	}
+}
+
+func TestPeersDiscovered_ProbesReachability(t *testing.T) {
+	p := newMeshTestPeer(0)
+	p.reachability = newReachabilityProber(time.Second, time.Minute)
+	p.reachability.dial = func(address string, timeout time.Duration) error {
+		if address == "dead:30303" {
+			return fmt.Errorf("connection refused")
+		}
+		return nil
+	}
+	peers := &pb.PeersMessage{Peers: []*pb.PeerEndpoint{
+		{ID: &pb.PeerID{Name: "alive"}, Address: "alive:30303", Metadata: &pb.PeerMetadata{}},
+		{ID: &pb.PeerID{Name: "dead"}, Address: "dead:30303", Metadata: &pb.PeerMetadata{}},
+	}}
+	if err := p.PeersDiscovered(peers); err != nil {
+		t.Fatalf("Error processing discovered peers: %s", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for p.inventory.address(&pb.PeerID{Name: "alive"}) == "" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if p.inventory.address(&pb.PeerID{Name: "alive"}) == "" {
+		t.Fatal("Expected the reachable peer to be listed")
+	}
+	if reachable, known := p.reachability.known("dead:30303"); !known || reachable {
+		t.Fatalf("Expected the dead peer to be known unreachable, got %t, %t", reachable, known)
+	}
+
+	// Known outcomes are not probed again
+	p.reachability.dial = func(string, time.Duration) error {
+		t.Fatal("Expected no probe")
+		return nil
+	}
+	if err := p.PeersDiscovered(peers); err != nil {
+		t.Fatalf("Error processing discovered peers: %s", err)
+	}
+	if p.inventory.address(&pb.PeerID{Name: "dead"}) != "" {
+		t.Fatal("Expected the unreachable peer not to be listed")
+	}
+	p.connMgr.remove("alive:30303")
+}
@@ -32,15 +32,19 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/transport"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/op/go-logging"
 	"github.com/spf13/viper"
 
 	"github.com/hyperledger/fabric/core/crypto"
+	"github.com/hyperledger/fabric/core/interceptor"
 	"github.com/hyperledger/fabric/core/ledger"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt"
 	"github.com/hyperledger/fabric/core/ledger/statemgmt/state"
+	"github.com/hyperledger/fabric/core/opevents"
+	"github.com/hyperledger/fabric/core/tracing"
 	"github.com/hyperledger/fabric/core/util"
 	pb "github.com/hyperledger/fabric/protos"
 )
@@ -100,10 +104,23 @@ type MessageHandlerCoordinator interface {
 	DeregisterHandler(messageHandler MessageHandler) error
 	Broadcast(*pb.Message, pb.PeerEndpoint_Type) []error
 	Unicast(*pb.Message, *pb.PeerID) error
+	Publish(topic string, payload []byte) []error
+	Subscribe(topic string, listener TopicListener) error
+	Unsubscribe(topic string)
+	PoolTransaction(transaction *pb.Transaction) (bool, error)
 	GetPeers() (*pb.PeersMessage, error)
+	GetFilteredPeers(filter PeerFilter) (*pb.PeersMessage, error)
+	GetNetworkInventory() *pb.NetworkInventory
+	GetMeshStatus() *pb.MeshStatus
+	GetPeerStandings() *pb.PeerStandings
+	ClearPeerStandings(id *pb.PeerID) *pb.PeerStandings
+	GetPeerBandwidth() *pb.PeerBandwidthStatus
 	GetRemoteLedger(receiver *pb.PeerID) (RemoteLedger, error)
 	PeersDiscovered(*pb.PeersMessage) error
 	ExecuteTransaction(transaction *pb.Transaction) *pb.Response
+	QueueTransaction(transaction *pb.Transaction, forward func()) (bool, error)
+	FairQueueStats() []OrgQueueStats
+	RegisterLifecycleListener(listener opevents.LifecycleListener)
 }
 
 // ChatStream interface supported by stream between Peers
@@ -112,6 +129,47 @@ type ChatStream interface {
 	Recv() (*pb.Message, error)
 }
 
+// abortableChatStream is the ChatStream handed to handlers by handleChat. Abort
+// ends the chat even while Recv is blocked on a half-open connection.
+type abortableChatStream struct {
+	ChatStream
+	aborted       chan struct{}
+	once          sync.Once
+	activity      *chatActivity
+	remoteAddress string
+}
+
+func newAbortableChatStream(stream ChatStream) *abortableChatStream {
+	return &abortableChatStream{ChatStream: stream, aborted: make(chan struct{}), activity: newChatActivity()}
+}
+
+// RemoteAddress returns the address the transport of the stream is connected
+// to, the address dialed for initiated streams
+func (s *abortableChatStream) RemoteAddress() string {
+	return s.remoteAddress
+}
+
+// transportRemoteAddress returns the remote address of the transport serving
+// the stream with context ctx, "" if ctx is not the context of a server stream
+func transportRemoteAddress(ctx context.Context) string {
+	stream, ok := transport.StreamFromContext(ctx)
+	if !ok || stream.ServerTransport() == nil {
+		return ""
+	}
+	return stream.ServerTransport().RemoteAddr().String()
+}
+
+// Send sends msg and records the activity on the chat
+func (s *abortableChatStream) Send(msg *pb.Message) error {
+	s.activity.touch(msg.Type)
+	return s.ChatStream.Send(msg)
+}
+
+// Abort makes handleChat return and stop the handler of the stream
+func (s *abortableChatStream) Abort() {
+	s.once.Do(func() { close(s.aborted) })
+}
+
 // SecurityAccessor interface enables a Peer to hand out the crypto object for Peer
 type SecurityAccessor interface {
 	GetSecHelper() crypto.Peer
@@ -119,9 +177,10 @@ type SecurityAccessor interface {
 
 var peerLogger = logging.MustGetLogger("peer")
 
-// NewPeerClientConnection Returns a new grpc.ClientConn to the configured local PEER.
+// NewPeerClientConnection Returns a new grpc.ClientConn to the client facing
+// services of the configured local PEER.
 func NewPeerClientConnection() (*grpc.ClientConn, error) {
-	return NewPeerClientConnectionWithAddress(viper.GetString("peer.address"))
+	return newPeerClientConnection(EndpointAddress(ClientEndpoint), EndpointTLSKey(ClientEndpoint))
 }
 
 // GetLocalIP returns the non loopback local IP of the host
@@ -171,21 +230,26 @@ func GetPeerEndpoint() (*pb.PeerEndpoint, error) {
 	} else {
 		peerType = pb.PeerEndpoint_NON_VALIDATOR
 	}
-	return &pb.PeerEndpoint{ID: &pb.PeerID{Name: viper.GetString("peer.id")}, Address: peerAddress, Type: peerType}, nil
+	return &pb.PeerEndpoint{ID: &pb.PeerID{Name: viper.GetString("peer.id")}, Address: peerAddress, Type: peerType, Capabilities: getLocalCapabilities(), Follower: IsFollower(), Metadata: getLocalMetadata()}, nil
 }
 
 // NewPeerClientConnectionWithAddress Returns a new grpc.ClientConn to the configured local PEER.
 func NewPeerClientConnectionWithAddress(peerAddress string) (*grpc.ClientConn, error) {
+	return newPeerClientConnection(peerAddress, "peer.tls")
+}
+
+// newPeerClientConnection dials peerAddress with the TLS settings under tlsKey
+func newPeerClientConnection(peerAddress string, tlsKey string) (*grpc.ClientConn, error) {
 	var opts []grpc.DialOption
-	if viper.GetBool("peer.tls.enabled") {
+	if viper.GetBool(tlsKey + ".enabled") {
 		var sn string
-		if viper.GetString("peer.tls.serverhostoverride") != "" {
-			sn = viper.GetString("peer.tls.serverhostoverride")
+		if viper.GetString(tlsKey+".serverhostoverride") != "" {
+			sn = viper.GetString(tlsKey + ".serverhostoverride")
 		}
 		var creds credentials.TransportAuthenticator
-		if viper.GetString("peer.tls.cert.file") != "" {
+		if viper.GetString(tlsKey+".cert.file") != "" {
 			var err error
-			creds, err = credentials.NewClientTLSFromFile(viper.GetString("peer.tls.cert.file"), sn)
+			creds, err = credentials.NewClientTLSFromFile(viper.GetString(tlsKey+".cert.file"), sn)
 			if err != nil {
 				grpclog.Fatalf("Failed to create TLS credentials %v", err)
 			}
@@ -197,6 +261,9 @@ func NewPeerClientConnectionWithAddress(peerAddress string) (*grpc.ClientConn, e
 		// No security, disable in grpc
 		opts = append(opts, grpc.WithInsecure())
 	}
+	if dialer := tcpKeepaliveDialer(viper.GetDuration("peer.keepalive.tcp")); dialer != nil {
+		opts = append(opts, dialer)
+	}
 	opts = append(opts, grpc.WithTimeout(defaultTimeout))
 	opts = append(opts, grpc.WithBlock())
 	conn, err := grpc.Dial(peerAddress, opts...)
@@ -224,6 +291,18 @@ type PeerImpl struct {
 	handlerMap     *handlerMap
 	ledgerWrapper  *ledgerWrapper
 	secHelper      crypto.Peer
+	connMgr        *connectionManager
+	inventory      *peerInventory
+	mesh           *meshLimiter
+	fairQueue      *fairQueue
+	reachability   *reachabilityProber
+	misbehavior    *misbehaviorTracker
+	topics         *topicRouter
+	txPool         *txPool
+	deltaFollower  deltaFollower
+	lifecycle      opevents.Listeners
+	conns          *connPool
+	departures     *departures
 }
 
 // NewPeerWithHandler returns a Peer which uses the supplied handler factory function for creating new handlers on new Chat service invocations.
@@ -233,7 +312,11 @@ func NewPeerWithHandler(handlerFact HandlerFactory) (*PeerImpl, error) {
 		return nil, errors.New("Cannot supply nil handler factory")
 	}
 	peer.handlerFactory = handlerFact
+	peer.inventory = newPeerInventory()
 	peer.handlerMap = &handlerMap{m: make(map[pb.PeerID]MessageHandler)}
+	peer.topics = newTopicRouter()
+	peer.conns = newConnPool(NewPeerClientConnectionWithAddress, viper.GetDuration("peer.multiplex.idleTimeout"))
+	peer.lifecycle.Add(opevents.PeerListener)
 
 	// Install security object for peer
 	if viper.GetBool("security.enabled") {
@@ -268,17 +351,64 @@ func NewPeerWithHandler(handlerFact HandlerFactory) (*PeerImpl, error) {
 		return nil, fmt.Errorf("Error constructing NewPeerWithHandler: %s", err)
 	}
 	peer.ledgerWrapper = &ledgerWrapper{ledger: ledgerPtr}
-	go peer.chatWithPeer(viper.GetString("peer.discovery.rootnode"))
+	peer.mesh = newMeshLimiter(viper.GetInt("peer.discovery.mesh.maxConnections"),
+		viper.GetDuration("peer.discovery.mesh.transientTTL"),
+		viper.GetInt("peer.discovery.mesh.maxDecisions"),
+		viper.GetBool("peer.validator.enabled"))
+	peer.connMgr = newConnectionManager(peer.chatWithPeer,
+		viper.GetDuration("peer.discovery.reconnect.minBackoff"),
+		viper.GetDuration("peer.discovery.reconnect.maxBackoff"),
+		viper.GetInt("peer.discovery.reconnect.maxAttempts"))
+	peer.reachability = newReachabilityProber(viper.GetDuration("peer.nat.probeTimeout"),
+		viper.GetDuration("peer.nat.probeTTL"))
+	peer.misbehavior = newMisbehaviorTracker(viper.GetInt("peer.misbehavior.greylistScore"),
+		viper.GetInt("peer.misbehavior.blacklistScore"),
+		viper.GetDuration("peer.misbehavior.cooldown"),
+		viper.GetInt("peer.misbehavior.greylistRate"))
+	peer.departures = newDepartures(viper.GetDuration("peer.discovery.departure.ttl"))
+	if viper.GetBool("peer.txpool.enabled") {
+		peer.txPool = newTxPool(viper.GetDuration("peer.txpool.ttl"),
+			viper.GetInt("peer.txpool.maxSize"),
+			viper.GetInt("peer.txpool.filterSize"),
+			viper.GetInt("peer.txpool.maxRebroadcast"))
+	}
+	if viper.GetBool("peer.fairness.enabled") {
+		peer.fairQueue = newFairQueue(viper.GetInt("peer.fairness.maxDepth"),
+			viper.GetInt("peer.fairness.defaultWeight"),
+			fairQueueWeights())
+		if peer.fairQueue != nil {
+			go peer.fairQueue.run()
+		}
+	}
+	if rootNode := viper.GetString("peer.discovery.rootnode"); len(rootNode) == 0 {
+		peerLogger.Debug("Starting up the first peer")
+	} else {
+		peer.connMgr.add(rootNode, true)
+	}
+	if viper.GetString("peer.discovery.seeds.dns") != "" || viper.GetString("peer.discovery.seeds.file") != "" {
+		go peer.dialSeeds()
+	}
+	if IsFollower() {
+		go peer.follow()
+	}
 	return peer, nil
 }
 
 // Chat implementation of the the Chat bidi streaming RPC function
 func (p *PeerImpl) Chat(stream pb.Peer_ChatServer) error {
-	return p.handleChat(stream.Context(), stream, false)
+	return interceptor.ServeStream(stream, chatMethod, func(intercepted grpc.ServerStream) error {
+		return p.handleChat(stream.Context(), chatServerStream{intercepted}, false, transportRemoteAddress(stream.Context()))
+	})
 }
 
 // GetPeers returns the currently registered PeerEndpoints
 func (p *PeerImpl) GetPeers() (*pb.PeersMessage, error) {
+	return p.GetFilteredPeers(nil)
+}
+
+// GetFilteredPeers returns the currently registered PeerEndpoints selected by
+// filter, a nil filter selects all of them
+func (p *PeerImpl) GetFilteredPeers(filter PeerFilter) (*pb.PeersMessage, error) {
 	p.handlerMap.Lock()
 	defer p.handlerMap.Unlock()
 	peers := []*pb.PeerEndpoint{}
@@ -287,21 +417,58 @@ func (p *PeerImpl) GetPeers() (*pb.PeersMessage, error) {
 		if err != nil {
 			return nil, fmt.Errorf("Error getting peers: %s", err)
 		}
+		if filter != nil && !filter(&peerEndpoint) {
+			continue
+		}
 		peers = append(peers, &peerEndpoint)
 	}
 	peersMessage := &pb.PeersMessage{Peers: peers}
 	return peersMessage, nil
 }
 
-// GetRemoteLedger returns the RemoteLedger interface for the remote Peer Endpoint
-func (p *PeerImpl) GetRemoteLedger(receiverHandle *pb.PeerID) (RemoteLedger, error) {
+// GetNetworkInventory returns the peers learned through discovery with the
+// most recent metadata verified for each
+func (p *PeerImpl) GetNetworkInventory() *pb.NetworkInventory {
 	p.handlerMap.Lock()
-	defer p.handlerMap.Unlock()
-	remoteLedger, ok := p.handlerMap.m[*receiverHandle]
-	if !ok {
+	connected := make(map[pb.PeerID]bool, len(p.handlerMap.m))
+	for id := range p.handlerMap.m {
+		connected[id] = true
+	}
+	p.handlerMap.Unlock()
+	return p.inventory.list(connected)
+}
+
+// GetRemoteLedger returns the RemoteLedger interface for the remote Peer Endpoint.
+// If this peer is not connected to it a transient connection is opened when
+// its address is known, as may be the case when connections are limited by
+// peer.discovery.mesh.maxConnections.
+func (p *PeerImpl) GetRemoteLedger(receiverHandle *pb.PeerID) (RemoteLedger, error) {
+	if remoteLedger, ok := p.getHandler(receiverHandle); ok {
+		return remoteLedger, nil
+	}
+	address := p.inventory.address(receiverHandle)
+	if address == "" {
 		return nil, fmt.Errorf("Remote ledger not found for receiver %s", receiverHandle.Name)
 	}
-	return remoteLedger, nil
+	p.connectTransient(address)
+	expire := time.After(defaultTimeout)
+	for {
+		select {
+		case <-expire:
+			return nil, fmt.Errorf("Remote ledger not found for receiver %s, timed out connecting to %s", receiverHandle.Name, address)
+		case <-time.After(10 * time.Millisecond):
+		}
+		if remoteLedger, ok := p.getHandler(receiverHandle); ok {
+			return remoteLedger, nil
+		}
+	}
+}
+
+func (p *PeerImpl) getHandler(id *pb.PeerID) (MessageHandler, bool) {
+	p.handlerMap.Lock()
+	defer p.handlerMap.Unlock()
+	msgHandler, ok := p.handlerMap.m[*id]
+	return msgHandler, ok
 }
 
 // PeersDiscovered used by MessageHandlers for notifying this coordinator of discovered PeerEndoints. May include this Peer's PeerEndpoint.
@@ -315,15 +482,45 @@ func (p *PeerImpl) PeersDiscovered(peersMessage *pb.PeersMessage) error {
 	for _, peerEndpoint := range peersMessage.Peers {
 		// Filter out THIS Peer's endpoint
 		if *getHandlerKeyFromPeerEndpoint(thisPeersEndpoint) == *getHandlerKeyFromPeerEndpoint(peerEndpoint) {
-			// NOOP
-		} else if _, ok := p.handlerMap.m[*getHandlerKeyFromPeerEndpoint(peerEndpoint)]; ok == false {
-			// Start chat with Peer
-			go p.chatWithPeer(peerEndpoint.Address)
+			continue
+		}
+		if p.checkBlacklist(peerEndpoint.ID, peerEndpoint.Address) != nil {
+			continue
+		}
+		if p.departures.departed(peerEndpoint.Address) {
+			// Advertised by a peer that did not see it leave
+			continue
+		}
+		_, connected := p.handlerMap.m[*getHandlerKeyFromPeerEndpoint(peerEndpoint)]
+		if !connected && p.reachability != nil {
+			// Only list peers whose advertised address accepts connections
+			if reachable, known := p.reachability.known(peerEndpoint.Address); !known {
+				ep := peerEndpoint
+				go p.reachability.probe(ep.Address, func() { p.addDiscoveredPeer(ep) })
+				continue
+			} else if !reachable {
+				continue
+			}
+		}
+		p.inventory.update(peerEndpoint)
+		if !connected && p.shouldDial(peerEndpoint) {
+			// Start chat with Peer, the connection manager ignores addresses it already maintains
+			p.connMgr.add(peerEndpoint.Address, false)
 		}
 	}
 	return nil
 }
 
+// addDiscoveredPeer lists a discovered peer once its address was found reachable
+func (p *PeerImpl) addDiscoveredPeer(peerEndpoint *pb.PeerEndpoint) {
+	p.handlerMap.Lock()
+	defer p.handlerMap.Unlock()
+	p.inventory.update(peerEndpoint)
+	if _, ok := p.handlerMap.m[*getHandlerKeyFromPeerEndpoint(peerEndpoint)]; !ok && p.shouldDial(peerEndpoint) {
+		p.connMgr.add(peerEndpoint.Address, false)
+	}
+}
+
 func getHandlerKey(peerMessageHandler MessageHandler) (*pb.PeerID, error) {
 	peerEndpoint, err := peerMessageHandler.To()
 	if err != nil {
@@ -342,6 +539,13 @@ func (p *PeerImpl) RegisterHandler(messageHandler MessageHandler) error {
 	if err != nil {
 		return fmt.Errorf("Error registering handler: %s", err)
 	}
+	if h, ok := messageHandler.(*Handler); ok && !h.consensusPeer() {
+		if offender := h.offender(); offender != nil {
+			if err := p.checkBlacklist(offender.ID, offender.Address); err != nil {
+				return err
+			}
+		}
+	}
 	p.handlerMap.Lock()
 	defer p.handlerMap.Unlock()
 	if _, ok := p.handlerMap.m[*key]; ok == true {
@@ -349,7 +553,21 @@ func (p *PeerImpl) RegisterHandler(messageHandler MessageHandler) error {
 		return newDuplicateHandlerError(messageHandler)
 	}
 	p.handlerMap.m[*key] = messageHandler
+	if err := p.enforceBudget(messageHandler); err != nil {
+		return err
+	}
+	if to, err := messageHandler.To(); err == nil {
+		p.departures.returned(to.Address)
+		p.inventory.update(&to)
+		if p.topics != nil && to.Metadata != nil {
+			// the topics the peer subscribes to are advertised in its HELLO
+			p.topics.setPeer(*key, to.Metadata.Topics)
+		}
+	}
 	peerLogger.Debug("registered handler with key: %s", key)
+	// The HELLO exchange is complete once a handler registers, so it is ready
+	p.lifecycle.Fire(key.Name, opevents.HandlerRegistered, nil)
+	p.lifecycle.Fire(key.Name, opevents.HandlerReady, nil)
 	return nil
 }
 
@@ -366,10 +584,20 @@ func (p *PeerImpl) DeregisterHandler(messageHandler MessageHandler) error {
 		return fmt.Errorf("Error deregistering handler, could not find handler with key: %s", key)
 	}
 	delete(p.handlerMap.m, *key)
+	if p.topics != nil {
+		p.topics.removePeer(*key)
+	}
 	peerLogger.Debug("Deregistered handler with key: %s", key)
+	p.lifecycle.Fire(key.Name, opevents.HandlerDeregistered, nil)
 	return nil
 }
 
+// RegisterLifecycleListener calls listener whenever the handler of a chat
+// with another peer is registered, becomes ready, fails or is deregistered
+func (p *PeerImpl) RegisterLifecycleListener(listener opevents.LifecycleListener) {
+	p.lifecycle.Add(listener)
+}
+
 //clone the handler so as to avoid lock across SendMessage
 func (p *PeerImpl) cloneHandlerMap(typ pb.PeerEndpoint_Type) map[pb.PeerID]MessageHandler {
 	p.handlerMap.Lock()
@@ -392,13 +620,16 @@ func (p *PeerImpl) cloneHandlerMap(typ pb.PeerEndpoint_Type) map[pb.PeerID]Messa
 // Broadcast broadcast a message to each of the currently registered PeerEndpoints of given type
 // Broadcast will broadcast to all registered PeerEndpoints if the type is PeerEndpoint_UNDEFINED
 func (p *PeerImpl) Broadcast(msg *pb.Message, typ pb.PeerEndpoint_Type) []error {
+	// Chats closed while idle are needed again, they receive later messages
+	// once re-established
+	p.connMgr.demandAll()
 	cloneMap := p.cloneHandlerMap(typ)
 	var errorsFromHandlers []error
 	for _, msgHandler := range cloneMap {
 		err := msgHandler.SendMessage(msg)
 		if err != nil {
 			toPeerEndpoint, _ := msgHandler.To()
-			errorsFromHandlers = append(errorsFromHandlers, fmt.Errorf("Error broadcasting msg (%s) to PeerEndpoint (%s): %s", msg.Type, toPeerEndpoint, err))
+			errorsFromHandlers = append(errorsFromHandlers, fmt.Errorf("Error broadcasting msg (%s) to PeerEndpoint (%v): %s", msg.Type, toPeerEndpoint, err))
 		}
 	}
 	return errorsFromHandlers
@@ -407,27 +638,43 @@ func (p *PeerImpl) Broadcast(msg *pb.Message, typ pb.PeerEndpoint_Type) []error
 // Unicast sends a message to a specific peer.
 func (p *PeerImpl) Unicast(msg *pb.Message, receiverHandle *pb.PeerID) error {
 	p.handlerMap.Lock()
-	msgHandler := p.handlerMap.m[*receiverHandle]
+	msgHandler, ok := p.handlerMap.m[*receiverHandle]
 	//don't lock across SendMessage
 	p.handlerMap.Unlock()
+	if !ok {
+		// The chat may have been closed while idle, re-establish it for the
+		// messages that follow
+		if address := p.inventory.address(receiverHandle); address != "" {
+			p.connMgr.demand(address)
+		}
+		return fmt.Errorf("Error unicasting msg (%s) to PeerID (%s): not connected", msg.Type, receiverHandle.Name)
+	}
 	err := msgHandler.SendMessage(msg)
 	if err != nil {
 		toPeerEndpoint, _ := msgHandler.To()
-		return fmt.Errorf("Error unicasting msg (%s) to PeerEndpoint (%s): %s", msg.Type, toPeerEndpoint, err)
+		return fmt.Errorf("Error unicasting msg (%s) to PeerEndpoint (%v): %s", msg.Type, toPeerEndpoint, err)
 	}
 	return nil
 }
 
 // SendTransactionsToPeer current temporary mechanism of forwarding transactions to the configured Validator.
 func (p *PeerImpl) SendTransactionsToPeer(peerAddress string, transaction *pb.Transaction) *pb.Response {
-	conn, err := NewPeerClientConnectionWithAddress(peerAddress)
+	span := startSendSpan(peerAddress, transaction)
+	defer span.Finish(nil)
+
+	if response := p.sendOnChannel(peerAddress, transaction, span); response != nil {
+		finishSendSpan(span, response)
+		return response
+	}
+	conn, release, err := p.conns.acquire(peerAddress)
 	if err != nil {
 		return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(fmt.Sprintf("Error creating client to peer address=%s:  %s", peerAddress, err))}
 	}
-	defer conn.Close()
+	defer release(false)
 	serverClient := pb.NewPeerClient(conn)
-	stream, err := serverClient.Chat(context.Background())
+	stream, err := openChat(context.Background(), serverClient)
 	if err != nil {
+		release(true)
 		return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(fmt.Sprintf("Error opening chat stream to peer address=%s:  %s", peerAddress, err))}
 	}
 
@@ -437,6 +684,9 @@ func (p *PeerImpl) SendTransactionsToPeer(peerAddress string, transaction *pb.Tr
 	if err != nil {
 		return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(fmt.Sprintf("Unexpected error creating new HelloMessage (%s):  %s", peerAddress, err))}
 	}
+	if helloMessage, err = encryptDiscoveryMessage(helloMessage); err != nil {
+		return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(fmt.Sprintf("Error encrypting hello to peer address=%s:  %s", peerAddress, err))}
+	}
 	if err = stream.Send(helloMessage); err != nil {
 		stream.CloseSend()
 		return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(fmt.Sprintf("Error sending hello to peer address=%s:  %s", peerAddress, err))}
@@ -472,7 +722,7 @@ func (p *PeerImpl) SendTransactionsToPeer(peerAddress string, transaction *pb.Tr
 					return
 				}
 
-				msg := &pb.Message{Type: pb.Message_CHAIN_TRANSACTION, Payload: payload, Timestamp: util.CreateUtcTimestamp()}
+				msg := &pb.Message{Type: pb.Message_CHAIN_TRANSACTION, Payload: payload, Timestamp: util.CreateUtcTimestamp(), TraceContext: span.Context()}
 				peerLogger.Debug("Sending message %s with timestamp %v to Peer %s", msg.Type, msg.Timestamp, peerAddress)
 				if err = stream.Send(msg); err != nil {
 					peerLogger.Error(fmt.Sprintf("Error sending message %s with timestamp %v to Peer %s:  %s", msg.Type, msg.Timestamp, peerAddress, err))
@@ -501,19 +751,24 @@ func (p *PeerImpl) SendTransactionsToPeer(peerAddress string, transaction *pb.Tr
 
 	//TODO Timeout handling
 	<-waitc
+	finishSendSpan(span, response)
 	return response
 }
 
-// SendTransactionsToPeer current temporary mechanism of forwarding transactions to the configured Validator
-func sendTransactionsToThisPeer(peerAddress string, transaction *pb.Transaction) *pb.Response {
-	conn, err := NewPeerClientConnectionWithAddress(peerAddress)
+// sendTransactionsToThisPeer sends transaction to this validator over the Chat service
+func (p *PeerImpl) sendTransactionsToThisPeer(peerAddress string, transaction *pb.Transaction) *pb.Response {
+	span := startSendSpan(peerAddress, transaction)
+	defer span.Finish(nil)
+
+	conn, release, err := p.conns.acquire(peerAddress)
 	if err != nil {
 		return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(fmt.Sprintf("Error sending transactions to peer address=%s:  %s", peerAddress, err))}
 	}
-	defer conn.Close()
+	defer release(false)
 	serverClient := pb.NewPeerClient(conn)
-	stream, err := serverClient.Chat(context.Background())
+	stream, err := openChat(context.Background(), serverClient)
 	if err != nil {
+		release(true)
 		return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(fmt.Sprintf("Error sending transactions to peer address=%s:  %s", peerAddress, err))}
 	}
 
@@ -557,7 +812,7 @@ func sendTransactionsToThisPeer(peerAddress string, transaction *pb.Transaction)
 		}
 	}()
 
-	msg := &pb.Message{Type: pb.Message_CHAIN_TRANSACTION, Payload: data, Timestamp: util.CreateUtcTimestamp()}
+	msg := &pb.Message{Type: pb.Message_CHAIN_TRANSACTION, Payload: data, Timestamp: util.CreateUtcTimestamp(), TraceContext: span.Context()}
 	peerLogger.Debug("Sending message %s with timestamp %v to self", msg.Type, msg.Timestamp)
 	if err = stream.Send(msg); err != nil {
 		peerLogger.Error(fmt.Sprintf("Error sending message %s with timestamp %v to Peer %s:  %s", msg.Type, msg.Timestamp, peerAddress, err))
@@ -568,48 +823,157 @@ func sendTransactionsToThisPeer(peerAddress string, transaction *pb.Transaction)
 
 	<-waitc
 
+	finishSendSpan(span, response)
 	return response
 }
 
+// startSendSpan starts the trace of transaction as it is sent to peerAddress,
+// the receiver continues it from the context of the CHAIN_TRANSACTION
+func startSendSpan(peerAddress string, transaction *pb.Transaction) *tracing.Span {
+	span := tracing.StartSpan("send "+pb.Message_CHAIN_TRANSACTION.String(), nil, transaction.Uuid)
+	span.SetTag("peer", peerAddress)
+	return span
+}
+
+// finishSendSpan finishes span with the outcome of sending the transaction
+func finishSendSpan(span *tracing.Span, response *pb.Response) {
+	if response != nil && response.Status != pb.Response_SUCCESS {
+		span.Finish(fmt.Errorf("%s: %s", response.Status, response.Msg))
+		return
+	}
+	span.Finish(nil)
+}
+
+// chatWithPeer dials peerAddress and chats until the stream ends. It returns an
+// error if the chat could not be established, errChatIdle if it was closed
+// while idle.
 func (p *PeerImpl) chatWithPeer(peerAddress string) error {
-	if len(peerAddress) == 0 {
-		peerLogger.Debug("Starting up the first peer")
-		return nil // nothing to do
+	peerLogger.Debug("Initiating Chat with peer address: %s", peerAddress)
+	if err := p.checkBlacklist(nil, peerAddress); err != nil {
+		return err
 	}
-	for {
-		time.Sleep(1 * time.Second)
-		peerLogger.Debug("Initiating Chat with peer address: %s", peerAddress)
-		conn, err := NewPeerClientConnectionWithAddress(peerAddress)
-		if err != nil {
-			e := fmt.Errorf("Error creating connection to peer address=%s:  %s", peerAddress, err)
-			peerLogger.Error(e.Error())
-			continue
-		}
-		serverClient := pb.NewPeerClient(conn)
-		ctx := context.Background()
-		stream, err := serverClient.Chat(ctx)
-		if err != nil {
-			e := fmt.Errorf("Error establishing chat with peer address=%s:  %s", peerAddress, err)
-			peerLogger.Error(fmt.Sprintf("%s", e.Error()))
-			continue
-		}
-		peerLogger.Debug("Established Chat with peer address: %s", peerAddress)
-		p.handleChat(ctx, stream, true)
-		stream.CloseSend()
+	conn, release, err := p.conns.acquire(peerAddress)
+	if err != nil {
+		return fmt.Errorf("Error creating connection to peer address=%s:  %s", peerAddress, err)
+	}
+	serverClient := pb.NewPeerClient(conn)
+	ctx, cancel := context.WithCancel(context.Background())
+	// Unblock a Recv pending on a dead connection before dialing again
+	defer cancel()
+	stream, err := openChat(ctx, serverClient)
+	if err != nil {
+		release(true)
+		return fmt.Errorf("Error establishing chat with peer address=%s:  %s", peerAddress, err)
 	}
+	peerLogger.Debug("Established Chat with peer address: %s", peerAddress)
+	err = p.handleChat(ctx, stream, true, peerAddress)
+	stream.CloseSend()
+	// The connection is kept for the next chat unless the chat failed
+	release(err != nil && err != errChatIdle)
+	if err == errChatIdle {
+		return err
+	}
+	if err != nil {
+		peerLogger.Debug("Chat with peer address=%s ended: %s", peerAddress, err)
+	}
+	return nil
 }
 
 // Chat implementation of the the Chat bidi streaming RPC function
-func (p *PeerImpl) handleChat(ctx context.Context, stream ChatStream, initiatedStream bool) error {
+func (p *PeerImpl) handleChat(ctx context.Context, stream ChatStream, initiatedStream bool, remoteAddress string) (err error) {
 	deadline, ok := ctx.Deadline()
 	peerLogger.Debug("Current context deadline = %s, ok = %v", deadline, ok)
-	handler, err := p.handlerFactory(p, stream, initiatedStream, nil)
+	abortable := newAbortableChatStream(stream)
+	abortable.remoteAddress = remoteAddress
+	handler, err := p.handlerFactory(p, abortable, initiatedStream, nil)
 	if err != nil {
 		return fmt.Errorf("Error creating handler during handleChat initiation: %s", err)
 	}
 	defer handler.Stop()
+	defer func() {
+		if err == nil || err == errChatIdle {
+			return
+		}
+		// Only registered handlers are known to listeners by name
+		if to, toErr := handler.To(); toErr == nil && to.ID != nil {
+			p.lifecycle.Fire(to.ID.Name, opevents.HandlerError, err)
+		}
+	}()
+
+	type recvResult struct {
+		msg *pb.Message
+		err error
+	}
+	// Only the peer that initiated the chat closes it while idle, it is the one
+	// re-establishing it on demand. A nil channel never fires.
+	var idleChan <-chan time.Time
+	idleTimeout := viper.GetDuration("peer.keepalive.idleTimeout")
+	if initiatedStream && idleTimeout > 0 {
+		idleTicker := time.NewTicker(idleTimeout / 2)
+		defer idleTicker.Stop()
+		idleChan = idleTicker.C
+	}
+
+	// Messages of independent flows are handled concurrently, a handler stuck
+	// on one past its timeout ends the chat
+	stuck := make(chan error, 1)
+	pipeline := newMessagePipeline(pipelineDepth(), func(msg *pb.Message) {
+		err := handleMessageWithTimeout(handler, msg)
+		if _, ok := err.(*HandlerTimeoutError); ok {
+			// The handler is stuck on the message, give up on the stream
+			// rather than wedging it
+			e := fmt.Errorf("Aborting Chat, stopping handler: %s", err)
+			peerLogger.Error(e.Error())
+			select {
+			case stuck <- e:
+			default:
+			}
+			abortable.Abort()
+			return
+		}
+		if err != nil {
+			peerLogger.Error(fmt.Sprintf("Error handling message: %s", err))
+		}
+	}, abortable.aborted)
+	defer pipeline.close()
+
+	recvChan := make(chan recvResult)
+	go func() {
+		for {
+			in, err := stream.Recv()
+			select {
+			case recvChan <- recvResult{in, err}:
+			case <-abortable.aborted:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
 	for {
-		in, err := stream.Recv()
+		var in *pb.Message
+		select {
+		case r := <-recvChan:
+			in, err = r.msg, r.err
+		case <-abortable.aborted:
+			select {
+			case e := <-stuck:
+				return e
+			default:
+			}
+			e := fmt.Errorf("Chat aborted, stopping handler")
+			peerLogger.Error(e.Error())
+			return e
+		case now := <-idleChan:
+			if idle := abortable.activity.idleFor(now); idle >= idleTimeout {
+				peerLogger.Info("Chat idle for %s, stopping handler", idle)
+				abortable.Abort()
+				return errChatIdle
+			}
+			continue
+		}
 		if err == io.EOF {
 			peerLogger.Debug("Received EOF, ending Chat")
 			return nil
@@ -619,7 +983,13 @@ func (p *PeerImpl) handleChat(ctx context.Context, stream ChatStream, initiatedS
 			peerLogger.Error(e.Error())
 			return e
 		}
-		err = handler.HandleMessage(in)
+		// Decrypt and decompress before any handler in the chain looks at the payload
+		if err = decryptDiscoveryMessage(in); err == nil {
+			abortable.activity.touch(in.Type)
+			if err = decompressMessage(in); err == nil {
+				pipeline.dispatch(in)
+			}
+		}
 		if err != nil {
 			peerLogger.Error(fmt.Sprintf("Error handling message: %s", err))
 			//return err
@@ -640,12 +1010,29 @@ func getValidatorStreamAddress() string {
 
 //ExecuteTransaction executes transactions decides to do execute in dev or prod mode
 func (p *PeerImpl) ExecuteTransaction(transaction *pb.Transaction) *pb.Response {
+	if transaction.IsExpired(time.Now()) {
+		return &pb.Response{Status: pb.Response_EXPIRED, Msg: []byte(fmt.Sprintf("Transaction %s expired before submission", transaction.Uuid))}
+	}
+	if IsFollower() {
+		return p.executeFollowerTransaction(transaction)
+	}
 	peerAddress := getValidatorStreamAddress()
 	var response *pb.Response
 	if viper.GetBool("peer.validator.enabled") { // send gRPC request to yourself
-		response = sendTransactionsToThisPeer(peerAddress, transaction)
+		response = p.sendTransactionsToThisPeer(peerAddress, transaction)
 
 	} else {
+		// A validator pools the transactions it receives before consensus
+		if transaction.Type != pb.Transaction_CHAINCODE_QUERY {
+			added, err := p.PoolTransaction(transaction)
+			if err != nil {
+				return &pb.Response{Status: pb.Response_FAILURE, Msg: []byte(err.Error())}
+			}
+			if !added {
+				peerLogger.Debug("Transaction %s was submitted already", transaction.Uuid)
+				return &pb.Response{Status: pb.Response_SUCCESS, Msg: []byte(transaction.Uuid)}
+			}
+		}
 		response = p.SendTransactionsToPeer(peerAddress, transaction)
 	}
 
@@ -655,11 +1042,30 @@ func (p *PeerImpl) ExecuteTransaction(transaction *pb.Transaction) *pb.Response
 // GetPeerEndpoint returns the endpoint for this peer
 func (p *PeerImpl) GetPeerEndpoint() (*pb.PeerEndpoint, error) {
 	ep, err := GetPeerEndpoint()
-	if err == nil && viper.GetBool("security.enabled") {
+	if err != nil {
+		return nil, err
+	}
+	// Other peers connect to the advertised address, which differs from the
+	// local one behind NAT
+	if ep.Address, err = AdvertisedAddress(); err != nil {
+		return nil, err
+	}
+	var sign func([]byte) ([]byte, error)
+	if viper.GetBool("security.enabled") {
 		// Set the PkiID on the PeerEndpoint if security is enabled
 		ep.PkiID = p.GetSecHelper().GetID()
+		sign = p.GetSecHelper().Sign
 	}
-	return ep, err
+	p.ledgerWrapper.RLock()
+	ep.Metadata.LedgerHeight = p.ledgerWrapper.ledger.GetBlockchainSize()
+	p.ledgerWrapper.RUnlock()
+	if p.topics != nil {
+		ep.Metadata.Topics = p.topics.localTopics()
+	}
+	if err = signPeerMetadata(ep, sign); err != nil {
+		return nil, err
+	}
+	return ep, nil
 }
 
 func (p *PeerImpl) newHelloMessage() (*pb.HelloMessage, error) {
@@ -674,7 +1080,15 @@ func (p *PeerImpl) newHelloMessage() (*pb.HelloMessage, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Error creating hello message, error getting block chain info: %s", err)
 	}
-	return &pb.HelloMessage{PeerEndpoint: endpoint, BlockchainInfo: blockChainInfo}, nil
+	hello := &pb.HelloMessage{PeerEndpoint: endpoint, BlockchainInfo: blockChainInfo, ProtocolVersion: ProtocolVersion, MinProtocolVersion: MinProtocolVersion}
+	verifier, err := getIdentityVerifier()
+	if err != nil {
+		return nil, fmt.Errorf("Error creating hello message, error loading peer identity verification: %s", err)
+	}
+	if verifier != nil {
+		verifier.attach(hello, p.secHelper)
+	}
+	return hello, nil
 }
 
 // GetBlockByNumber return a block by block number
@@ -20,6 +20,7 @@ under the License.
 package peer
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -27,6 +28,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/golang/protobuf/proto"
+	"github.com/looplab/fsm"
 	"github.com/spf13/viper"
 
 	"github.com/hyperledger/fabric/core/config"
@@ -38,10 +41,21 @@ import (
 
 var peerClientConn *grpc.ClientConn
 
-func TestMain(m *testing.M) {
+func setupTestConfig() {
 	config.SetupTestConfig("./../..")
 	viper.Set("ledger.blockchain.deploy-system-chaincode", "false")
 	viper.Set("peer.validator.validity-period.verification", "false")
+}
+
+// resetTestConfig drops the configuration a test loaded of its own and loads
+// the test configuration the other tests rely on again
+func resetTestConfig() {
+	viper.Reset()
+	setupTestConfig()
+}
+
+func TestMain(m *testing.M) {
+	setupTestConfig()
 
 	tmpConn, err := NewPeerClientConnection()
 	if err != nil {
@@ -138,3 +152,335 @@ func TestServer_Chat(t *testing.T) {
 	t.Skip()
 	performChat(t, peerClientConn)
 }
+
+type mockChatStream struct {
+	sent chan *pb.Message
+}
+
+func (s *mockChatStream) Send(msg *pb.Message) error {
+	s.sent <- msg
+	return nil
+}
+
+func (s *mockChatStream) Recv() (*pb.Message, error) {
+	select {}
+}
+
+func TestHandler_KeepaliveAbortsStream(t *testing.T) {
+	viper.Set("peer.keepalive.interval", "10ms")
+	viper.Set("peer.keepalive.timeout", "10ms")
+	defer viper.Set("peer.keepalive.interval", "0")
+
+	mock := &mockChatStream{sent: make(chan *pb.Message, 10)}
+	stream := newAbortableChatStream(mock)
+	messageHandler, err := NewPeerHandler(nil, stream, false, nil)
+	if err != nil {
+		t.Fatalf("Error creating handler: %s", err)
+	}
+	handler := messageHandler.(*Handler)
+	go handler.start()
+	defer func() { handler.doneChan <- struct{}{} }()
+
+	select {
+	case msg := <-mock.sent:
+		if msg.Type != pb.Message_DISC_PING {
+			t.Fatalf("Expected %s, got %s", pb.Message_DISC_PING, msg.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting for %s", pb.Message_DISC_PING)
+	}
+
+	select {
+	case <-stream.aborted:
+	case <-time.After(time.Second):
+		t.Fatalf("Stream not aborted after missing %s", pb.Message_DISC_PONG)
+	}
+}
+
+func TestHandler_DiscoveryPeriodReloadRestartsTicker(t *testing.T) {
+	viper.Set("peer.discovery.period", "1h")
+	defer viper.Set("peer.discovery.period", "5s")
+	defer config.Set("peer.discovery.period", nil)
+
+	mock := &mockChatStream{sent: make(chan *pb.Message, 10)}
+	messageHandler, err := NewPeerHandler(nil, mock, false, nil)
+	if err != nil {
+		t.Fatalf("Error creating handler: %s", err)
+	}
+	handler := messageHandler.(*Handler)
+	go handler.start()
+	defer func() { handler.doneChan <- struct{}{} }()
+
+	// Let the handler start its ticker with the initial period
+	time.Sleep(10 * time.Millisecond)
+	config.Set("peer.discovery.period", "10ms")
+	notifyDiscoveryPeriodChange()
+
+	select {
+	case msg := <-mock.sent:
+		if msg.Type != pb.Message_DISC_GET_PEERS {
+			t.Fatalf("Expected %s, got %s", pb.Message_DISC_GET_PEERS, msg.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Timeout waiting for %s with the reloaded discovery period", pb.Message_DISC_GET_PEERS)
+	}
+}
+
+func TestHandler_PingSendsPong(t *testing.T) {
+	mock := &mockChatStream{sent: make(chan *pb.Message, 10)}
+	messageHandler, err := NewPeerHandler(nil, mock, false, nil)
+	if err != nil {
+		t.Fatalf("Error creating handler: %s", err)
+	}
+	e := &fsm.Event{Event: pb.Message_DISC_PING.String()}
+	messageHandler.(*Handler).beforePing(e)
+	if e.Err != nil {
+		t.Fatalf("Error handling %s: %s", pb.Message_DISC_PING, e.Err)
+	}
+	if msg := <-mock.sent; msg.Type != pb.Message_DISC_PONG {
+		t.Fatalf("Expected %s, got %s", pb.Message_DISC_PONG, msg.Type)
+	}
+}
+
+func TestHandler_UnsupportedMessages(t *testing.T) {
+	mock := &mockChatStream{sent: make(chan *pb.Message, 10)}
+	messageHandler, err := NewPeerHandler(nil, mock, false, nil)
+	if err != nil {
+		t.Fatalf("Error creating handler: %s", err)
+	}
+	handler := messageHandler.(*Handler)
+
+	// A type introduced after this peer's protocol version is answered with UNSUPPORTED
+	err = handler.HandleMessage(&pb.Message{Type: pb.Message_Type(99)})
+	if _, ok := err.(*UnsupportedMessageError); !ok {
+		t.Fatalf("Expected an UnsupportedMessageError, got %v", err)
+	}
+	reply := <-mock.sent
+	unsupported := &pb.UnsupportedMessage{}
+	if reply.Type != pb.Message_UNSUPPORTED || proto.Unmarshal(reply.Payload, unsupported) != nil || unsupported.Type != pb.Message_Type(99) {
+		t.Fatalf("Expected %s for type 99, got %s", pb.Message_UNSUPPORTED, reply)
+	}
+
+	// Newer types are not sent to a peer that negotiated an older version
+	handler.protocolVersion = 1
+	err = handler.SendMessage(&pb.Message{Type: pb.Message_DISC_PING})
+	if _, ok := err.(*UnsupportedMessageError); !ok {
+		t.Fatalf("Expected an UnsupportedMessageError, got %v", err)
+	}
+}
+
+func TestHandler_SendMessageCompresses(t *testing.T) {
+	viper.Set("peer.compression.enabled", "true")
+	viper.Set("peer.compression.minSize", "16")
+	defer viper.Set("peer.compression.minSize", "1024")
+
+	mock := &mockChatStream{sent: make(chan *pb.Message, 10)}
+	messageHandler, err := NewPeerHandler(nil, mock, false, nil)
+	if err != nil {
+		t.Fatalf("Error creating handler: %s", err)
+	}
+	handler := messageHandler.(*Handler)
+	handler.compression = negotiateCompression(getLocalCapabilities(), capabilityFor(pb.Message_GZIP))
+	if handler.compression != pb.Message_GZIP {
+		t.Fatalf("Expected %s to be negotiated, got %s", pb.Message_GZIP, handler.compression)
+	}
+
+	payload := bytes.Repeat([]byte("payload"), 100)
+	orig := &pb.Message{Type: pb.Message_SYNC_BLOCKS, Payload: payload}
+	if err := handler.SendMessage(orig); err != nil {
+		t.Fatalf("Error sending message: %s", err)
+	}
+	sent := <-mock.sent
+	if sent.Compression != pb.Message_GZIP || len(sent.Payload) >= len(payload) {
+		t.Fatalf("Expected a smaller %s payload, got %s with %d bytes", pb.Message_GZIP, sent.Compression, len(sent.Payload))
+	}
+	if orig.Compression != pb.Message_NONE || !bytes.Equal(orig.Payload, payload) {
+		t.Fatal("SendMessage modified the caller's message")
+	}
+	if err := decompressMessage(sent); err != nil {
+		t.Fatalf("Error decompressing message: %s", err)
+	}
+	if !bytes.Equal(sent.Payload, payload) {
+		t.Fatal("Decompressed payload does not match the original")
+	}
+
+	// Payloads that would decompress beyond the limit are refused
+	viper.Set("peer.compression.maxDecompressedSize", len(payload)-1)
+	defer viper.Set("peer.compression.maxDecompressedSize", maxDecompressedSizeDefault)
+	bomb, err := compressMessage(orig, pb.Message_GZIP, 0)
+	if err != nil {
+		t.Fatalf("Error compressing message: %s", err)
+	}
+	if err := decompressMessage(bomb); err == nil {
+		t.Fatal("Expected a payload exceeding the decompressed size limit to be refused")
+	}
+
+	// Small payloads go out as is
+	if err := handler.SendMessage(&pb.Message{Type: pb.Message_SYNC_BLOCKS, Payload: []byte("tiny")}); err != nil {
+		t.Fatalf("Error sending message: %s", err)
+	}
+	if sent := <-mock.sent; sent.Compression != pb.Message_NONE {
+		t.Fatalf("Expected small payload to be uncompressed, got %s", sent.Compression)
+	}
+}
+
+func TestNegotiateCompression(t *testing.T) {
+	if c := negotiateCompression(capabilityFor(pb.Message_GZIP), 0); c != pb.Message_NONE {
+		t.Fatalf("Expected %s with a peer without capabilities, got %s", pb.Message_NONE, c)
+	}
+	both := capabilityFor(pb.Message_GZIP) | capabilityFor(pb.Message_SNAPPY)
+	if c := negotiateCompression(both, both); c != pb.Message_SNAPPY {
+		t.Fatalf("Expected %s to be preferred, got %s", pb.Message_SNAPPY, c)
+	}
+}
+
+func TestDiscoveryEncryptionRotation(t *testing.T) {
+	viper.Set("peer.discovery.encryption.enabled", "true")
+	viper.Set("peer.discovery.encryption.secret", "old-secret")
+	defer viper.Set("peer.discovery.encryption.enabled", "false")
+
+	payload := []byte("peer table")
+	orig := &pb.Message{Type: pb.Message_DISC_PEERS, Payload: payload}
+	sealedOld, err := encryptDiscoveryMessage(orig)
+	if err != nil {
+		t.Fatalf("Error encrypting message: %s", err)
+	}
+	if !sealedOld.Encrypted || bytes.Contains(sealedOld.Payload, payload) {
+		t.Fatal("Expected the payload to be encrypted")
+	}
+	if orig.Encrypted || !bytes.Equal(orig.Payload, payload) {
+		t.Fatal("Encryption modified the caller's message")
+	}
+
+	// Rotate, messages under the previous secret are still accepted
+	viper.Set("peer.discovery.encryption.secret", "new-secret")
+	viper.Set("peer.discovery.encryption.previousSecret", "old-secret")
+	sealedNew, err := encryptDiscoveryMessage(orig)
+	if err != nil {
+		t.Fatalf("Error encrypting message: %s", err)
+	}
+	for _, msg := range []*pb.Message{sealedOld, sealedNew} {
+		if err := decryptDiscoveryMessage(msg); err != nil {
+			t.Fatalf("Error decrypting message: %s", err)
+		}
+		if msg.Encrypted || !bytes.Equal(msg.Payload, payload) {
+			t.Fatal("Decrypted payload does not match the original")
+		}
+	}
+
+	// Once the rotation is complete the old key is refused, as is plaintext
+	viper.Set("peer.discovery.encryption.previousSecret", "")
+	oldRing, err := newDiscoveryKeyring("old-secret")
+	if err != nil {
+		t.Fatalf("Error deriving keys: %s", err)
+	}
+	stale, err := oldRing.seal(orig)
+	if err != nil {
+		t.Fatalf("Error encrypting message: %s", err)
+	}
+	if err := decryptDiscoveryMessage(stale); err == nil {
+		t.Fatal("Expected message encrypted with a retired key to be rejected")
+	}
+	if err := decryptDiscoveryMessage(&pb.Message{Type: pb.Message_DISC_PEERS, Payload: payload}); err == nil {
+		t.Fatal("Expected unencrypted discovery message to be rejected")
+	}
+}
+
+// chanChatStream is one end of a chat whose messages travel over channels,
+// the chat ends when in is closed
+type chanChatStream struct {
+	in  <-chan *pb.Message
+	out chan<- *pb.Message
+}
+
+func (s *chanChatStream) Send(msg *pb.Message) error {
+	s.out <- proto.Clone(msg).(*pb.Message)
+	return nil
+}
+
+func (s *chanChatStream) Recv() (*pb.Message, error) {
+	msg, ok := <-s.in
+	if !ok {
+		return nil, io.EOF
+	}
+	return msg, nil
+}
+
+// peersCoordinator reports the handlers it registers and the peers it
+// discovers
+type peersCoordinator struct {
+	discoveryCoordinator
+	registered chan MessageHandler
+	discovered chan *pb.PeersMessage
+}
+
+func (c *peersCoordinator) RegisterHandler(messageHandler MessageHandler) error {
+	c.registered <- messageHandler
+	return nil
+}
+
+func (c *peersCoordinator) PeersDiscovered(peers *pb.PeersMessage) error {
+	c.discovered <- peers
+	return nil
+}
+
+func TestChatWithDiscoveryEncryption(t *testing.T) {
+	setupChatTraceConfig("")
+	viper.Set("peer.discovery.encryption.enabled", "true")
+	viper.Set("peer.discovery.encryption.secret", "chat-secret")
+	defer viper.Set("peer.discovery.encryption.enabled", "false")
+
+	initiator := &peersCoordinator{discoveryCoordinator: discoveryCoordinator{endpoint: &pb.PeerEndpoint{ID: &pb.PeerID{Name: "initiator"}, Address: "127.0.0.1:30304", Type: pb.PeerEndpoint_VALIDATOR}}, registered: make(chan MessageHandler, 1), discovered: make(chan *pb.PeersMessage, 10)}
+	other := &pb.PeerEndpoint{ID: &pb.PeerID{Name: "other"}, Address: "127.0.0.1:30306", Type: pb.PeerEndpoint_NON_VALIDATOR}
+	responder := &peersCoordinator{discoveryCoordinator: discoveryCoordinator{endpoint: &pb.PeerEndpoint{ID: &pb.PeerID{Name: "responder"}, Address: "127.0.0.1:30305", Type: pb.PeerEndpoint_VALIDATOR}, peers: []*pb.PeerEndpoint{other}}, registered: make(chan MessageHandler, 1), discovered: make(chan *pb.PeersMessage, 10)}
+
+	toInitiator := make(chan *pb.Message, 10)
+	toResponder := make(chan *pb.Message, 10)
+	handlers := make(chan MessageHandler, 2)
+	chat := func(coord MessageHandlerCoordinator, stream ChatStream, initiated bool) {
+		p := newMeshTestPeer(0)
+		p.handlerFactory = func(_ MessageHandlerCoordinator, stream ChatStream, initiatedStream bool, next MessageHandler) (MessageHandler, error) {
+			handler, err := NewPeerHandler(coord, stream, initiatedStream, next)
+			handlers <- handler
+			return handler, err
+		}
+		p.handleChat(context.Background(), stream, initiated, "")
+	}
+	go chat(initiator, &chanChatStream{in: toInitiator, out: toResponder}, true)
+	go chat(responder, &chanChatStream{in: toResponder, out: toInitiator}, false)
+	defer close(toInitiator)
+	defer close(toResponder)
+
+	// The HELLO exchange is encrypted as well, each end sends it on its own
+	var initiatorHandler MessageHandler
+	for i := 0; i < 2; i++ {
+		handler := <-handlers
+		if handler.(*Handler).initiatedStream {
+			initiatorHandler = handler
+		}
+	}
+	// The initiator registers the handler once it received the HELLO of the
+	// responder
+	deadline := time.After(5 * time.Second)
+	select {
+	case <-initiator.registered:
+	case <-deadline:
+		t.Fatal("Timed out waiting for the HELLO exchange")
+	}
+	if err := initiatorHandler.SendMessage(&pb.Message{Type: pb.Message_DISC_GET_PEERS}); err != nil {
+		t.Fatalf("Error sending message: %s", err)
+	}
+	// The HELLO reported the responder itself, DISC_PEERS reports its peers
+	for {
+		select {
+		case peers := <-initiator.discovered:
+			for _, peer := range peers.Peers {
+				if peer.ID.Name == "other" {
+					return
+				}
+			}
+		case <-deadline:
+			t.Fatal("Timed out waiting for the peers of the responder")
+		}
+	}
+}
@@ -0,0 +1,141 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"sync"
+
+	"github.com/spf13/viper"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// Lanes of the messages received on a chat. Messages of one lane are handled
+// in the order they were received, those of different lanes concurrently.
+const (
+	barrierLane = iota - 1
+	defaultLane
+	keepaliveLane
+	discoveryLane
+	pubsubLane
+	transactionLane
+	syncRequestLane
+	syncLane
+	laneCount = iota - 1
+)
+
+// messageLane returns the lane of the messages of type t. The FSM of the
+// handler only moves on DISC_HELLO, every other message leaves the chat
+// established, so order only matters within each flow. A DISC_HELLO or
+// DISC_DISCONNECT is a barrier: it is handled once the lanes are idle,
+// before anything received after it. Consensus messages and those of unknown
+// types keep to the default lane.
+func messageLane(t pb.Message_Type) int {
+	switch t {
+	case pb.Message_DISC_HELLO, pb.Message_DISC_DISCONNECT:
+		return barrierLane
+	case pb.Message_DISC_PING, pb.Message_DISC_PONG:
+		return keepaliveLane
+	case pb.Message_DISC_GET_PEERS, pb.Message_DISC_PEERS, pb.Message_DISC_BUSY:
+		return discoveryLane
+	case pb.Message_SUB, pb.Message_UNSUB, pb.Message_PUBLISH:
+		return pubsubLane
+	case pb.Message_TX_DIGEST, pb.Message_TX_POOL:
+		return transactionLane
+	case pb.Message_SYNC_GET_BLOCKS, pb.Message_SYNC_STATE_GET_SNAPSHOT, pb.Message_SYNC_STATE_GET_DELTAS:
+		return syncRequestLane
+	case pb.Message_SYNC_BLOCK_ADDED, pb.Message_SYNC_BLOCKS, pb.Message_SYNC_STATE_SNAPSHOT, pb.Message_SYNC_STATE_DELTAS, pb.Message_STATE_DELTA:
+		return syncLane
+	}
+	return defaultLane
+}
+
+// pipelineDepth returns peer.pipeline.depth, 0 while chats are traced since
+// a trace pairs each received message with the ones sent handling it
+func pipelineDepth() int {
+	if viper.GetString("peer.chatTrace.dir") != "" {
+		return 0
+	}
+	return viper.GetInt("peer.pipeline.depth")
+}
+
+// messagePipeline hands the messages received on a chat to a worker per
+// lane, each queueing up to depth messages. A full lane holds the receive
+// loop back. With a depth of 0 every message is handled as it is
+// dispatched.
+type messagePipeline struct {
+	handle  func(*pb.Message)
+	aborted <-chan struct{}
+	lanes   []chan *pb.Message
+	pending sync.WaitGroup // messages queued or being handled
+	workers sync.WaitGroup
+}
+
+// newMessagePipeline returns a pipeline calling handle for each message
+// until aborted is closed
+func newMessagePipeline(depth int, handle func(*pb.Message), aborted <-chan struct{}) *messagePipeline {
+	p := &messagePipeline{handle: handle, aborted: aborted}
+	if depth <= 0 {
+		return p
+	}
+	p.lanes = make([]chan *pb.Message, laneCount)
+	for i := range p.lanes {
+		p.lanes[i] = make(chan *pb.Message, depth)
+		p.workers.Add(1)
+		go p.work(p.lanes[i])
+	}
+	return p
+}
+
+func (p *messagePipeline) work(lane chan *pb.Message) {
+	defer p.workers.Done()
+	for msg := range lane {
+		select {
+		case <-p.aborted:
+			// The chat is ending, the handler may no longer be used
+		default:
+			p.handle(msg)
+		}
+		p.pending.Done()
+	}
+}
+
+// dispatch queues msg on its lane. A barrier is handled before dispatch
+// returns, once the messages dispatched before it are handled. Only the
+// receive loop of the chat dispatches.
+func (p *messagePipeline) dispatch(msg *pb.Message) {
+	lane := messageLane(msg.Type)
+	if p.lanes == nil || lane == barrierLane {
+		p.pending.Wait()
+		p.handle(msg)
+		return
+	}
+	p.pending.Add(1)
+	p.lanes[lane] <- msg
+}
+
+// close handles the messages still queued, unless the chat was aborted, and
+// waits for the workers to end
+func (p *messagePipeline) close() {
+	for _, lane := range p.lanes {
+		close(lane)
+	}
+	p.workers.Wait()
+}
@@ -0,0 +1,113 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestMessagePipeline_LanesRunConcurrently(t *testing.T) {
+	release := make(chan struct{})
+	handled := make(chan *pb.Message, 10)
+	var mutex sync.Mutex
+	var blocks []uint64
+	p := newMessagePipeline(4, func(msg *pb.Message) {
+		switch msg.Type {
+		case pb.Message_DISC_GET_PEERS:
+			<-release
+		case pb.Message_SYNC_BLOCKS:
+			mutex.Lock()
+			blocks = append(blocks, uint64(msg.Payload[0]))
+			mutex.Unlock()
+		}
+		handled <- msg
+	}, make(chan struct{}))
+	defer p.close()
+
+	p.dispatch(&pb.Message{Type: pb.Message_DISC_GET_PEERS})
+	p.dispatch(&pb.Message{Type: pb.Message_DISC_PING})
+	select {
+	case msg := <-handled:
+		if msg.Type != pb.Message_DISC_PING {
+			t.Fatalf("Expected DISC_PING to be handled first, got %s", msg.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected DISC_PING not to wait for the slow DISC_GET_PEERS")
+	}
+	for i := byte(0); i < 3; i++ {
+		p.dispatch(&pb.Message{Type: pb.Message_SYNC_BLOCKS, Payload: []byte{i}})
+	}
+	for i := 0; i < 3; i++ {
+		<-handled
+	}
+	if len(blocks) != 3 || blocks[0] != 0 || blocks[1] != 1 || blocks[2] != 2 {
+		t.Fatalf("Expected the blocks in the order received, got %v", blocks)
+	}
+
+	// The barrier waits for the slow message
+	done := make(chan struct{})
+	go func() {
+		p.dispatch(&pb.Message{Type: pb.Message_DISC_HELLO})
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Expected DISC_HELLO to wait for the lanes to be idle")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-done
+	if first, second := <-handled, <-handled; first.Type != pb.Message_DISC_GET_PEERS || second.Type != pb.Message_DISC_HELLO {
+		t.Fatalf("Expected DISC_GET_PEERS then DISC_HELLO, got %s and %s", first.Type, second.Type)
+	}
+}
+
+func TestMessagePipeline_Serial(t *testing.T) {
+	var handled []pb.Message_Type
+	p := newMessagePipeline(0, func(msg *pb.Message) { handled = append(handled, msg.Type) }, make(chan struct{}))
+	p.dispatch(&pb.Message{Type: pb.Message_SYNC_BLOCKS})
+	p.dispatch(&pb.Message{Type: pb.Message_DISC_PING})
+	p.close()
+	if len(handled) != 2 || handled[0] != pb.Message_SYNC_BLOCKS || handled[1] != pb.Message_DISC_PING {
+		t.Fatalf("Expected the messages to be handled as dispatched, got %v", handled)
+	}
+}
+
+func TestMessagePipeline_AbortedSkipsQueued(t *testing.T) {
+	aborted := make(chan struct{})
+	release := make(chan struct{})
+	var handled int
+	p := newMessagePipeline(4, func(msg *pb.Message) {
+		<-release
+		handled++
+	}, aborted)
+	p.dispatch(&pb.Message{Type: pb.Message_TX_POOL})
+	p.dispatch(&pb.Message{Type: pb.Message_TX_POOL})
+	close(aborted)
+	close(release)
+	p.close()
+	if handled > 1 {
+		t.Fatalf("Expected the messages queued once aborted not to be handled, %d were", handled)
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"sync"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// numPriorities is the number of priority classes of messages
+const numPriorities = int(pb.Message_PRIORITY_CONSENSUS) + 1
+
+// sendScheduler serializes the sends on a chat stream. A sender that finds
+// the stream busy waits in the lane of the priority class of its message;
+// when the stream is released the oldest sender of the highest non-empty lane
+// goes next, so that consensus and discovery messages overtake pending
+// block sync traffic.
+type sendScheduler struct {
+	sync.Mutex
+	busy  bool
+	lanes [numPriorities][]chan struct{}
+}
+
+// acquire waits until a message of class priority may be sent on the stream
+func (s *sendScheduler) acquire(priority pb.Message_Priority) {
+	s.Lock()
+	if !s.busy {
+		s.busy = true
+		s.Unlock()
+		return
+	}
+	turn := make(chan struct{})
+	s.lanes[priority] = append(s.lanes[priority], turn)
+	s.Unlock()
+	<-turn
+}
+
+// release hands the stream to the next waiting sender, if any
+func (s *sendScheduler) release() {
+	s.Lock()
+	defer s.Unlock()
+	for priority := len(s.lanes) - 1; priority >= 0; priority-- {
+		if lane := s.lanes[priority]; len(lane) > 0 {
+			s.lanes[priority] = lane[1:]
+			close(lane[0])
+			return
+		}
+	}
+	s.busy = false
+}
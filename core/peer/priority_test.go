@@ -0,0 +1,92 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"testing"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestMessagePriority(t *testing.T) {
+	expected := map[pb.Message_Type]pb.Message_Priority{
+		pb.Message_CONSENSUS:         pb.Message_PRIORITY_CONSENSUS,
+		pb.Message_CHAIN_TRANSACTION: pb.Message_PRIORITY_TRANSACTION,
+		pb.Message_DISC_PING:         pb.Message_PRIORITY_DISCOVERY,
+		pb.Message_DISC_HELLO:        pb.Message_PRIORITY_DISCOVERY,
+		pb.Message_SYNC_BLOCKS:       pb.Message_PRIORITY_SYNC,
+		pb.Message_SYNC_STATE_DELTAS: pb.Message_PRIORITY_SYNC,
+	}
+	for typ, priority := range expected {
+		if p := (&pb.Message{Type: typ}).Priority(); p != priority {
+			t.Errorf("Expected %s to have priority %s, got %s", typ, priority, p)
+		}
+	}
+}
+
+func TestSendScheduler_HighestPriorityGoesFirst(t *testing.T) {
+	s := &sendScheduler{}
+	s.acquire(pb.Message_PRIORITY_SYNC)
+
+	order := make(chan pb.Message_Priority, 4)
+	waiting := 0
+	for _, priority := range []pb.Message_Priority{pb.Message_PRIORITY_SYNC, pb.Message_PRIORITY_DISCOVERY, pb.Message_PRIORITY_SYNC, pb.Message_PRIORITY_CONSENSUS} {
+		go func(priority pb.Message_Priority) {
+			s.acquire(priority)
+			order <- priority
+			s.release()
+		}(priority)
+		waiting++
+		// Wait for the sender to be queued so that the arrival order is known
+		for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+			s.Lock()
+			queued := 0
+			for _, lane := range s.lanes {
+				queued += len(lane)
+			}
+			s.Unlock()
+			if queued == waiting {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("Timed out waiting for the sender to be queued")
+			}
+		}
+	}
+
+	s.release()
+	expected := []pb.Message_Priority{pb.Message_PRIORITY_CONSENSUS, pb.Message_PRIORITY_DISCOVERY, pb.Message_PRIORITY_SYNC, pb.Message_PRIORITY_SYNC}
+	for _, priority := range expected {
+		select {
+		case p := <-order:
+			if p != priority {
+				t.Fatalf("Expected %s to be sent next, got %s", priority, p)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for the next sender")
+		}
+	}
+	s.Lock()
+	defer s.Unlock()
+	if s.busy {
+		t.Fatal("Expected the stream to be free once every sender was done")
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+const (
+	// ProtocolVersion is the highest peer to peer protocol version this peer
+	// speaks, it is raised whenever a Message type is added
+	ProtocolVersion uint32 = 8
+
+	// MinProtocolVersion is the lowest protocol version this peer still
+	// speaks, peers limited to older versions cannot connect
+	MinProtocolVersion uint32 = 1
+
+	// channelProtocolVersion is the protocol version from which a RESPONSE
+	// echoes the channel of the request it answers
+	channelProtocolVersion uint32 = 7
+)
+
+// messageVersions holds the protocol version that introduced each Message
+// type added after version 1, or that started handling it
+var messageVersions = map[pb.Message_Type]uint32{
+	pb.Message_DISC_PING:       2,
+	pb.Message_DISC_PONG:       2,
+	pb.Message_UNSUPPORTED:     2,
+	pb.Message_DISC_BUSY:       3,
+	pb.Message_SUB:             4,
+	pb.Message_UNSUB:           4,
+	pb.Message_PUBLISH:         4,
+	pb.Message_TX_DIGEST:       5,
+	pb.Message_TX_POOL:         5,
+	pb.Message_STATE_DELTA:     6,
+	pb.Message_DISC_DISCONNECT: 8, // Declared in version 1, ignored before 8
+}
+
+// messageSupported returns whether the Message type may be exchanged on a
+// stream that negotiated the given protocol version
+func messageSupported(msgType pb.Message_Type, version uint32) bool {
+	if _, known := pb.Message_Type_name[int32(msgType)]; !known {
+		// Introduced by a protocol version newer than this peer's
+		return false
+	}
+	return messageVersions[msgType] <= version
+}
+
+// negotiateProtocolVersion returns the highest protocol version supported by
+// both this peer and the sender of the HelloMessage
+func negotiateProtocolVersion(hello *pb.HelloMessage) (uint32, error) {
+	remoteMax, remoteMin := hello.ProtocolVersion, hello.MinProtocolVersion
+	if remoteMax == 0 {
+		// Predates version negotiation
+		remoteMax, remoteMin = 1, 1
+	}
+	if remoteMin == 0 {
+		remoteMin = 1
+	}
+	version := ProtocolVersion
+	if remoteMax < version {
+		version = remoteMax
+	}
+	if version < MinProtocolVersion || version < remoteMin {
+		return 0, &UnsupportedProtocolVersionError{Min: remoteMin, Max: remoteMax}
+	}
+	return version, nil
+}
@@ -0,0 +1,63 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"testing"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestNegotiateProtocolVersion(t *testing.T) {
+	tests := []struct {
+		hello    *pb.HelloMessage
+		expected uint32
+	}{
+		// Peers that predate negotiation speak version 1 only
+		{&pb.HelloMessage{}, 1},
+		{&pb.HelloMessage{ProtocolVersion: ProtocolVersion, MinProtocolVersion: MinProtocolVersion}, ProtocolVersion},
+		{&pb.HelloMessage{ProtocolVersion: ProtocolVersion + 5, MinProtocolVersion: 1}, ProtocolVersion},
+	}
+	for _, test := range tests {
+		version, err := negotiateProtocolVersion(test.hello)
+		if err != nil {
+			t.Errorf("Error negotiating with %s: %s", test.hello, err)
+		} else if version != test.expected {
+			t.Errorf("Expected version %d with %s, got %d", test.expected, test.hello, version)
+		}
+	}
+
+	_, err := negotiateProtocolVersion(&pb.HelloMessage{ProtocolVersion: ProtocolVersion + 2, MinProtocolVersion: ProtocolVersion + 1})
+	if _, ok := err.(*UnsupportedProtocolVersionError); !ok {
+		t.Errorf("Expected an UnsupportedProtocolVersionError, got %v", err)
+	}
+}
+
+func TestMessageSupported(t *testing.T) {
+	if !messageSupported(pb.Message_SYNC_GET_BLOCKS, 1) {
+		t.Errorf("Expected %s at version 1", pb.Message_SYNC_GET_BLOCKS)
+	}
+	if messageSupported(pb.Message_DISC_PING, 1) || !messageSupported(pb.Message_DISC_PING, 2) {
+		t.Errorf("Expected %s from version 2 on", pb.Message_DISC_PING)
+	}
+	if messageSupported(pb.Message_Type(99), ProtocolVersion) {
+		t.Error("Expected types unknown to this peer to be unsupported")
+	}
+}
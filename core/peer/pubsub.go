@@ -0,0 +1,227 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// TopicListener is called with the payload of each message published on a
+// topic this peer subscribes to, from is the peer that published it
+type TopicListener func(from *pb.PeerID, payload []byte)
+
+// topicSubscriber is implemented by coordinators that route published
+// messages, handlers tell it about the subscriptions of their peer and hand
+// it the messages published to this peer
+type topicSubscriber interface {
+	subscribePeer(id *pb.PeerID, topics []string, subscribe bool)
+	deliverPublished(from *pb.PeerID, msg *pb.PublishMessage)
+}
+
+// topicRouter keeps the topics this peer subscribes to, with their
+// listeners, and the topics each connected peer subscribes to
+type topicRouter struct {
+	sync.RWMutex
+	listeners map[string][]TopicListener
+	peers     map[pb.PeerID]map[string]bool
+}
+
+func newTopicRouter() *topicRouter {
+	return &topicRouter{listeners: make(map[string][]TopicListener), peers: make(map[pb.PeerID]map[string]bool)}
+}
+
+// localTopics returns the topics this peer subscribes to, sorted
+func (r *topicRouter) localTopics() []string {
+	r.RLock()
+	defer r.RUnlock()
+	topics := make([]string, 0, len(r.listeners))
+	for topic := range r.listeners {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// subscribe adds listener to topic, returning true for the first listener
+func (r *topicRouter) subscribe(topic string, listener TopicListener) bool {
+	r.Lock()
+	defer r.Unlock()
+	r.listeners[topic] = append(r.listeners[topic], listener)
+	return len(r.listeners[topic]) == 1
+}
+
+// unsubscribe removes the listeners of topic, returning false if there were
+// none
+func (r *topicRouter) unsubscribe(topic string) bool {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.listeners[topic]; !ok {
+		return false
+	}
+	delete(r.listeners, topic)
+	return true
+}
+
+// setPeer replaces the topics the peer id subscribes to
+func (r *topicRouter) setPeer(id pb.PeerID, topics []string) {
+	r.Lock()
+	defer r.Unlock()
+	if len(topics) == 0 {
+		delete(r.peers, id)
+		return
+	}
+	subscribed := make(map[string]bool, len(topics))
+	for _, topic := range topics {
+		subscribed[topic] = true
+	}
+	r.peers[id] = subscribed
+}
+
+// updatePeer adds topics to or removes them from those the peer id
+// subscribes to
+func (r *topicRouter) updatePeer(id pb.PeerID, topics []string, subscribe bool) {
+	r.Lock()
+	defer r.Unlock()
+	subscribed := r.peers[id]
+	if subscribed == nil {
+		if !subscribe {
+			return
+		}
+		subscribed = make(map[string]bool)
+		r.peers[id] = subscribed
+	}
+	for _, topic := range topics {
+		if subscribe {
+			subscribed[topic] = true
+		} else {
+			delete(subscribed, topic)
+		}
+	}
+	if len(subscribed) == 0 {
+		delete(r.peers, id)
+	}
+}
+
+// removePeer forgets the subscriptions of a peer that disconnected
+func (r *topicRouter) removePeer(id pb.PeerID) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.peers, id)
+}
+
+// subscribed returns whether the peer id subscribes to topic
+func (r *topicRouter) subscribed(id pb.PeerID, topic string) bool {
+	r.RLock()
+	defer r.RUnlock()
+	return r.peers[id][topic]
+}
+
+// deliver calls the listeners of the topic of msg, returning false if this
+// peer does not subscribe to it
+func (r *topicRouter) deliver(from *pb.PeerID, msg *pb.PublishMessage) bool {
+	r.RLock()
+	listeners := r.listeners[msg.Topic]
+	r.RUnlock()
+	for _, listener := range listeners {
+		listener(from, msg.Payload)
+	}
+	return len(listeners) > 0
+}
+
+// Subscribe calls listener with the messages published on topic by the other
+// peers. The connected peers are told with SUB on the first subscription to
+// a topic, peers connecting later learn the topics from the PeerMetadata.
+func (p *PeerImpl) Subscribe(topic string, listener TopicListener) error {
+	if topic == "" || listener == nil {
+		return fmt.Errorf("Error subscribing: a topic and a listener are required")
+	}
+	if !p.topics.subscribe(topic, listener) {
+		return nil
+	}
+	peerLogger.Info("Subscribed to topic %s", topic)
+	p.sendTopics(pb.Message_SUB, topic)
+	return nil
+}
+
+// Unsubscribe removes the listeners of topic, the connected peers are told
+// with UNSUB to stop publishing on it to this peer
+func (p *PeerImpl) Unsubscribe(topic string) {
+	if !p.topics.unsubscribe(topic) {
+		return
+	}
+	peerLogger.Info("Unsubscribed from topic %s", topic)
+	p.sendTopics(pb.Message_UNSUB, topic)
+}
+
+// sendTopics sends a SUB or UNSUB for topic to the connected peers. Peers
+// whose protocol version predates them never get a PUBLISH anyway.
+func (p *PeerImpl) sendTopics(typ pb.Message_Type, topic string) {
+	payload, err := proto.Marshal(&pb.TopicsMessage{Topics: []string{topic}})
+	if err != nil {
+		peerLogger.Error(fmt.Sprintf("Error marshalling %s: %s", typ, err))
+		return
+	}
+	for _, msgHandler := range p.cloneHandlerMap(pb.PeerEndpoint_UNDEFINED) {
+		err := msgHandler.SendMessage(&pb.Message{Type: typ, Payload: payload})
+		if _, unsupported := err.(*UnsupportedMessageError); err != nil && !unsupported {
+			toPeerEndpoint, _ := msgHandler.To()
+			peerLogger.Warning("Error sending %s to %s: %s", typ, toPeerEndpoint.ID, err)
+		}
+	}
+}
+
+// Publish sends payload on topic to the connected peers subscribed to it
+func (p *PeerImpl) Publish(topic string, payload []byte) []error {
+	data, err := proto.Marshal(&pb.PublishMessage{Topic: topic, Payload: payload})
+	if err != nil {
+		return []error{fmt.Errorf("Error marshalling %s: %s", pb.Message_PUBLISH, err)}
+	}
+	var errorsFromHandlers []error
+	for id, msgHandler := range p.cloneHandlerMap(pb.PeerEndpoint_UNDEFINED) {
+		if !p.topics.subscribed(id, topic) {
+			continue
+		}
+		if err := msgHandler.SendMessage(&pb.Message{Type: pb.Message_PUBLISH, Payload: data}); err != nil {
+			toPeerEndpoint, _ := msgHandler.To()
+			errorsFromHandlers = append(errorsFromHandlers, fmt.Errorf("Error publishing on topic %s to PeerEndpoint (%s): %s", topic, toPeerEndpoint.ID, err))
+		}
+	}
+	return errorsFromHandlers
+}
+
+// subscribePeer records the SUB or UNSUB received from the peer id
+func (p *PeerImpl) subscribePeer(id *pb.PeerID, topics []string, subscribe bool) {
+	peerLogger.Debug("Peer %s subscribe=%t to topics %v", id, subscribe, topics)
+	p.topics.updatePeer(*id, topics, subscribe)
+}
+
+// deliverPublished hands a message published by the peer from to the
+// listeners of its topic
+func (p *PeerImpl) deliverPublished(from *pb.PeerID, msg *pb.PublishMessage) {
+	if !p.topics.deliver(from, msg) {
+		peerLogger.Debug("Dropping message published by %s on topic %s, not subscribed", from, msg.Topic)
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/looplab/fsm"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func newTopicTestHandler(p *PeerImpl, name string, topics ...string) (*Handler, *mockChatStream) {
+	stream := &mockChatStream{sent: make(chan *pb.Message, 10)}
+	d := newMeshTestHandler(name, pb.PeerEndpoint_VALIDATOR)
+	d.ToPeerEndpoint.Metadata = &pb.PeerMetadata{Topics: topics}
+	d.ChatStream = stream
+	d.Coordinator = p
+	d.protocolVersion = ProtocolVersion
+	d.FSM = fsm.NewFSM("established",
+		fsm.Events{
+			{Name: pb.Message_SUB.String(), Src: []string{"established"}, Dst: "established"},
+			{Name: pb.Message_UNSUB.String(), Src: []string{"established"}, Dst: "established"},
+			{Name: pb.Message_PUBLISH.String(), Src: []string{"established"}, Dst: "established"},
+		},
+		fsm.Callbacks{
+			"before_" + pb.Message_SUB.String():     func(e *fsm.Event) { d.beforeSubscribe(e, true) },
+			"before_" + pb.Message_UNSUB.String():   func(e *fsm.Event) { d.beforeSubscribe(e, false) },
+			"before_" + pb.Message_PUBLISH.String(): func(e *fsm.Event) { d.beforePublish(e) },
+		})
+	return d, stream
+}
+
+func received(stream *mockChatStream) []*pb.Message {
+	var msgs []*pb.Message
+	for {
+		select {
+		case msg := <-stream.sent:
+			msgs = append(msgs, msg)
+		default:
+			return msgs
+		}
+	}
+}
+
+func TestPubSub_PublishesToSubscribersOnly(t *testing.T) {
+	p := newMeshTestPeer(0)
+	p.topics = newTopicRouter()
+	validator, validatorStream := newTopicTestHandler(p, "v1", "consensus")
+	nonValidator, nonValidatorStream := newTopicTestHandler(p, "nv1")
+	for _, d := range []*Handler{validator, nonValidator} {
+		if err := p.RegisterHandler(d); err != nil {
+			t.Fatalf("Error registering handler: %s", err)
+		}
+	}
+
+	if errs := p.Publish("consensus", []byte("vote")); len(errs) != 0 {
+		t.Fatalf("Error publishing: %v", errs)
+	}
+	msgs := received(validatorStream)
+	if len(msgs) != 1 || msgs[0].Type != pb.Message_PUBLISH {
+		t.Fatalf("Expected the subscriber to get %s, got %v", pb.Message_PUBLISH, msgs)
+	}
+	if msgs = received(nonValidatorStream); len(msgs) != 0 {
+		t.Fatalf("Expected nothing for a peer not subscribed, got %v", msgs)
+	}
+
+	// The non validator subscribes, then unsubscribes
+	payload, _ := proto.Marshal(&pb.TopicsMessage{Topics: []string{"consensus"}})
+	if err := nonValidator.HandleMessage(&pb.Message{Type: pb.Message_SUB, Payload: payload}); err != nil {
+		t.Fatalf("Error handling %s: %s", pb.Message_SUB, err)
+	}
+	p.Publish("consensus", []byte("vote"))
+	if msgs = received(nonValidatorStream); len(msgs) != 1 {
+		t.Fatalf("Expected the new subscriber to get the message, got %v", msgs)
+	}
+	if err := nonValidator.HandleMessage(&pb.Message{Type: pb.Message_UNSUB, Payload: payload}); err != nil {
+		t.Fatalf("Error handling %s: %s", pb.Message_UNSUB, err)
+	}
+	p.Publish("consensus", []byte("vote"))
+	if msgs = received(nonValidatorStream); len(msgs) != 0 {
+		t.Fatalf("Expected nothing once unsubscribed, got %v", msgs)
+	}
+
+	// Subscriptions are forgotten with the handler
+	p.DeregisterHandler(validator)
+	if p.topics.subscribed(*validator.ToPeerEndpoint.ID, "consensus") {
+		t.Fatal("Expected the subscriptions of a deregistered peer to be forgotten")
+	}
+}
+
+func TestPubSub_SubscribeDeliversPublished(t *testing.T) {
+	p := newMeshTestPeer(0)
+	p.topics = newTopicRouter()
+	d, stream := newTopicTestHandler(p, "v1")
+	if err := p.RegisterHandler(d); err != nil {
+		t.Fatalf("Error registering handler: %s", err)
+	}
+
+	var got []string
+	if err := p.Subscribe("blocks", func(from *pb.PeerID, payload []byte) { got = append(got, from.Name+":"+string(payload)) }); err != nil {
+		t.Fatalf("Error subscribing: %s", err)
+	}
+	msgs := received(stream)
+	if len(msgs) != 1 || msgs[0].Type != pb.Message_SUB {
+		t.Fatalf("Expected the connected peer to be sent %s, got %v", pb.Message_SUB, msgs)
+	}
+	if topics := p.topics.localTopics(); len(topics) != 1 || topics[0] != "blocks" {
+		t.Fatalf("Expected the topic to be advertised, got %v", topics)
+	}
+
+	for _, topic := range []string{"blocks", "other"} {
+		payload, _ := proto.Marshal(&pb.PublishMessage{Topic: topic, Payload: []byte("b1")})
+		if err := d.HandleMessage(&pb.Message{Type: pb.Message_PUBLISH, Payload: payload}); err != nil {
+			t.Fatalf("Error handling %s: %s", pb.Message_PUBLISH, err)
+		}
+	}
+	if len(got) != 1 || got[0] != "v1:b1" {
+		t.Fatalf("Expected the message on the subscribed topic only, got %v", got)
+	}
+
+	p.Unsubscribe("blocks")
+	if msgs = received(stream); len(msgs) != 1 || msgs[0].Type != pb.Message_UNSUB {
+		t.Fatalf("Expected the connected peer to be sent %s, got %v", pb.Message_UNSUB, msgs)
+	}
+}
@@ -0,0 +1,52 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"sync"
+
+	"github.com/hyperledger/fabric/core/config"
+)
+
+// discoveryPeriodChange is closed, and replaced, when a config reload changes
+// peer.discovery.period, for the handlers to restart their discovery ticker
+var discoveryPeriodChange = struct {
+	sync.Mutex
+	changed chan struct{}
+}{changed: make(chan struct{})}
+
+func init() {
+	config.OnChange("peer.discovery.period", notifyDiscoveryPeriodChange)
+}
+
+// discoveryPeriodChanged returns a channel closed on the next change of the
+// discovery period
+func discoveryPeriodChanged() <-chan struct{} {
+	discoveryPeriodChange.Lock()
+	defer discoveryPeriodChange.Unlock()
+	return discoveryPeriodChange.changed
+}
+
+func notifyDiscoveryPeriodChange() {
+	discoveryPeriodChange.Lock()
+	defer discoveryPeriodChange.Unlock()
+	close(discoveryPeriodChange.changed)
+	discoveryPeriodChange.changed = make(chan struct{})
+}
@@ -0,0 +1,128 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Resolvers of the DNS seeds, replaced by tests
+var (
+	lookupSRV  = net.LookupSRV
+	lookupHost = net.LookupHost
+)
+
+// lookupDNSSeeds returns the peer addresses published for domain. The SRV
+// records of _peer._tcp.domain give host and port, when there are none the
+// addresses of domain itself are given port.
+func lookupDNSSeeds(domain string, port string) ([]string, error) {
+	if _, records, err := lookupSRV("peer", "tcp", domain); err == nil && len(records) > 0 {
+		addresses := make([]string, 0, len(records))
+		for _, srv := range records {
+			addresses = append(addresses, net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))))
+		}
+		return addresses, nil
+	}
+	hosts, err := lookupHost(domain)
+	if err != nil {
+		return nil, fmt.Errorf("Error resolving seed domain %s: %s", domain, err)
+	}
+	addresses := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		addresses = append(addresses, net.JoinHostPort(host, port))
+	}
+	return addresses, nil
+}
+
+// readSeedsFile returns the peer addresses listed in the file at path, one
+// host:port per line. Blank lines and lines starting with # are skipped.
+func readSeedsFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening seeds file: %s", err)
+	}
+	defer file.Close()
+	var addresses []string
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		address := strings.TrimSpace(scanner.Text())
+		if address == "" || strings.HasPrefix(address, "#") {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return nil, fmt.Errorf("Invalid address on line %d of seeds file %s: %s", line, path, err)
+		}
+		addresses = append(addresses, address)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading seeds file %s: %s", path, err)
+	}
+	return addresses, nil
+}
+
+// seedAddresses returns the peer addresses of the seed sources configured
+// under peer.discovery.seeds. A source that fails is logged and skipped.
+func seedAddresses() []string {
+	var addresses []string
+	if domain := viper.GetString("peer.discovery.seeds.dns"); domain != "" {
+		_, port, _ := net.SplitHostPort(viper.GetString("peer.address"))
+		if found, err := lookupDNSSeeds(domain, port); err != nil {
+			peerLogger.Warning("Ignoring DNS seeds: %s", err)
+		} else {
+			addresses = append(addresses, found...)
+		}
+	}
+	if path := viper.GetString("peer.discovery.seeds.file"); path != "" {
+		if found, err := readSeedsFile(path); err != nil {
+			peerLogger.Warning("Ignoring seeds file: %s", err)
+		} else {
+			addresses = append(addresses, found...)
+		}
+	}
+	return addresses
+}
+
+// dialSeeds chats with the seed peers, which then tell of the other peers of
+// the network like the rootnode does. Unlike the rootnode, a seed is dropped
+// when it cannot be reached, until the sources list it again after
+// peer.discovery.seeds.refresh.
+func (p *PeerImpl) dialSeeds() {
+	self, _ := GetLocalAddress()
+	for {
+		for _, address := range seedAddresses() {
+			if address != self {
+				p.connMgr.add(address, false)
+			}
+		}
+		refresh := viper.GetDuration("peer.discovery.seeds.refresh")
+		if refresh <= 0 {
+			return
+		}
+		time.Sleep(refresh)
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func stubSeedResolvers(srv []*net.SRV, hosts []string) func() {
+	savedSRV, savedHost := lookupSRV, lookupHost
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		if service != "peer" || proto != "tcp" {
+			return "", nil, fmt.Errorf("unexpected service _%s._%s", service, proto)
+		}
+		if len(srv) == 0 {
+			return "", nil, fmt.Errorf("no SRV records for %s", name)
+		}
+		return "_peer._tcp." + name, srv, nil
+	}
+	lookupHost = func(host string) ([]string, error) {
+		if len(hosts) == 0 {
+			return nil, fmt.Errorf("no such host %s", host)
+		}
+		return hosts, nil
+	}
+	return func() { lookupSRV, lookupHost = savedSRV, savedHost }
+}
+
+func TestLookupDNSSeeds(t *testing.T) {
+	restore := stubSeedResolvers([]*net.SRV{{Target: "vp1.example.com.", Port: 30303}, {Target: "vp2.example.com.", Port: 31303}}, []string{"10.0.0.1"})
+	addresses, err := lookupDNSSeeds("example.com", "30303")
+	restore()
+	if err != nil || !reflect.DeepEqual(addresses, []string{"vp1.example.com:30303", "vp2.example.com:31303"}) {
+		t.Fatalf("Expected the SRV targets, got %v (%v)", addresses, err)
+	}
+
+	restore = stubSeedResolvers(nil, []string{"10.0.0.1", "10.0.0.2"})
+	addresses, err = lookupDNSSeeds("example.com", "30303")
+	restore()
+	if err != nil || !reflect.DeepEqual(addresses, []string{"10.0.0.1:30303", "10.0.0.2:30303"}) {
+		t.Fatalf("Expected the addresses of the domain without SRV records, got %v (%v)", addresses, err)
+	}
+
+	restore = stubSeedResolvers(nil, nil)
+	defer restore()
+	if _, err = lookupDNSSeeds("example.com", "30303"); err == nil {
+		t.Fatal("Expected an unresolvable domain to fail")
+	}
+}
+
+func TestReadSeedsFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "seeds")
+	if err != nil {
+		t.Fatalf("Error creating seeds file: %s", err)
+	}
+	defer os.Remove(file.Name())
+	fmt.Fprint(file, "# seeds of the test network\n10.0.0.1:30303\n\n  vp2.example.com:30303  \n")
+	file.Close()
+
+	addresses, err := readSeedsFile(file.Name())
+	if err != nil || !reflect.DeepEqual(addresses, []string{"10.0.0.1:30303", "vp2.example.com:30303"}) {
+		t.Fatalf("Expected the addresses listed, got %v (%v)", addresses, err)
+	}
+
+	if err = ioutil.WriteFile(file.Name(), []byte("10.0.0.1\n"), 0644); err != nil {
+		t.Fatalf("Error writing seeds file: %s", err)
+	}
+	if _, err = readSeedsFile(file.Name()); err == nil {
+		t.Fatal("Expected an address without port to be rejected")
+	}
+}
+
+func TestSeedAddressesSkipsFailingSource(t *testing.T) {
+	defer viper.Set("peer.discovery.seeds.dns", "")
+	defer viper.Set("peer.discovery.seeds.file", "")
+	restore := stubSeedResolvers(nil, []string{"10.0.0.1"})
+	defer restore()
+	viper.Set("peer.discovery.seeds.dns", "example.com")
+	viper.Set("peer.discovery.seeds.file", "/nonexistent/seeds")
+
+	_, port, _ := net.SplitHostPort(viper.GetString("peer.address"))
+	if addresses := seedAddresses(); !reflect.DeepEqual(addresses, []string{net.JoinHostPort("10.0.0.1", port)}) {
+		t.Fatalf("Expected the DNS seeds despite the missing file, got %v", addresses)
+	}
+}
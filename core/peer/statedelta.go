@@ -0,0 +1,105 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/looplab/fsm"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// stateDeltaReceiver is implemented by coordinators that apply the blocks
+// and state deltas validators push with STATE_DELTA
+type stateDeltaReceiver interface {
+	receiveStateDelta(from *pb.PeerEndpoint, delta *pb.BlockStateDelta) error
+}
+
+// deltaFollower is the follower STATE_DELTA messages are appended by, set
+// once the ledger of the follower is bootstrapped
+type deltaFollower struct {
+	sync.RWMutex
+	follower *follower
+}
+
+func (d *deltaFollower) set(f *follower) {
+	d.Lock()
+	defer d.Unlock()
+	d.follower = f
+}
+
+func (d *deltaFollower) get() *follower {
+	d.RLock()
+	defer d.RUnlock()
+	return d.follower
+}
+
+// receiveStateDelta appends the block pushed by a validator if it is the
+// next one of the local chain. Other blocks are left to the follower loop,
+// which pulls whatever was missed.
+func (p *PeerImpl) receiveStateDelta(from *pb.PeerEndpoint, delta *pb.BlockStateDelta) error {
+	f := p.deltaFollower.get()
+	if f == nil || from.Type != pb.PeerEndpoint_VALIDATOR {
+		return nil
+	}
+	return f.appendPushed(delta)
+}
+
+// appendPushed appends the block of delta if it is the next one of the local
+// chain, see append
+func (f *follower) appendPushed(delta *pb.BlockStateDelta) error {
+	f.lock.Lock()
+	height := f.ledger.GetBlockchainSize()
+	f.lock.Unlock()
+	if delta.BlockNumber != height {
+		peerLogger.Debug("Ignoring the state delta of block %d at height %d", delta.BlockNumber, height)
+		return nil
+	}
+	if delta.Block == nil {
+		return fmt.Errorf("No block in the state delta of block %d", delta.BlockNumber)
+	}
+	return f.append(delta.BlockNumber, delta.Block, delta.StateDelta)
+}
+
+// beforeStateDelta applies the block and state delta pushed by the remote
+// validator
+func (d *Handler) beforeStateDelta(e *fsm.Event) {
+	msg, ok := e.Args[0].(*pb.Message)
+	if !ok {
+		e.Cancel(fmt.Errorf("Received unexpected message type"))
+		return
+	}
+	delta := &pb.BlockStateDelta{}
+	if err := proto.Unmarshal(msg.Payload, delta); err != nil {
+		e.Cancel(malformedPayload(fmt.Errorf("Error unmarshalling BlockStateDelta: %s", err)))
+		return
+	}
+	receiver, ok := d.Coordinator.(stateDeltaReceiver)
+	if !ok || d.ToPeerEndpoint == nil {
+		return
+	}
+	if err := receiver.receiveStateDelta(d.ToPeerEndpoint, delta); err != nil {
+		// the follower loop catches up on what could not be applied
+		peerLogger.Warning("Error applying the state delta of block %d from %s: %s", delta.BlockNumber, d.ToPeerEndpoint.ID, err)
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestReceiveStateDelta(t *testing.T) {
+	remote := newMemRemote(4)
+	local := &memLedger{}
+	p := &PeerImpl{}
+	validator := &pb.PeerEndpoint{ID: &pb.PeerID{Name: "vp"}, Type: pb.PeerEndpoint_VALIDATOR}
+	push := func(from *pb.PeerEndpoint, n int) error {
+		return p.receiveStateDelta(from, &pb.BlockStateDelta{BlockNumber: uint64(n), Block: remote.chain[n], StateDelta: remote.deltas[n]})
+	}
+
+	if err := push(validator, 0); err != nil || local.GetBlockchainSize() != 0 {
+		t.Fatalf("Expected a peer that is not following to ignore state deltas, got %v", err)
+	}
+	p.deltaFollower.set(&follower{ledger: local, lock: &sync.Mutex{}, batchSize: 5, timeout: 10 * time.Millisecond})
+
+	if err := push(validator, 1); err != nil || local.GetBlockchainSize() != 0 {
+		t.Fatalf("Expected a block beyond the next one to be left to the follower loop, got %v", err)
+	}
+	nonValidator := &pb.PeerEndpoint{ID: &pb.PeerID{Name: "nvp"}, Type: pb.PeerEndpoint_NON_VALIDATOR}
+	if err := push(nonValidator, 0); err != nil || local.GetBlockchainSize() != 0 {
+		t.Fatalf("Expected state deltas of non-validators to be ignored, got %v", err)
+	}
+	for n := 0; n < 3; n++ {
+		if err := push(validator, n); err != nil {
+			t.Fatalf("Error applying the state delta of block %d: %s", n, err)
+		}
+	}
+	if err := push(validator, 1); err != nil {
+		t.Fatalf("Expected a block appended already to be ignored, got %s", err)
+	}
+	if local.GetBlockchainSize() != 3 || string(local.stateHash()) != string(remote.chain[2].StateHash) {
+		t.Fatal("Expected local chain and state to match the remote")
+	}
+
+	remote.chain[3].StateHash = []byte("tampered")
+	if err := push(validator, 3); err == nil || local.GetBlockchainSize() != 3 {
+		t.Fatal("Expected a state delta not matching its block to be rejected")
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// handlerTimeouts is the maximum time the handler may spend on a message, by
+// message type. A zero limit does not bound the processing time.
+type handlerTimeouts struct {
+	byType       map[pb.Message_Type]time.Duration
+	defaultLimit time.Duration
+}
+
+// newHandlerTimeouts returns the limits for the given message types, other
+// types are bounded by defaultLimit
+func newHandlerTimeouts(defaultLimit time.Duration, types map[string]string) (*handlerTimeouts, error) {
+	t := &handlerTimeouts{byType: make(map[pb.Message_Type]time.Duration), defaultLimit: defaultLimit}
+	for name, limit := range types {
+		v, ok := pb.Message_Type_value[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("Unknown message type %s in handler timeouts", name)
+		}
+		d, err := time.ParseDuration(limit)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid timeout %s for message type %s: %s", limit, name, err)
+		}
+		t.byType[pb.Message_Type(v)] = d
+	}
+	return t, nil
+}
+
+func (t *handlerTimeouts) limit(msgType pb.Message_Type) time.Duration {
+	if t == nil {
+		return 0
+	}
+	if d, ok := t.byType[msgType]; ok {
+		return d
+	}
+	return t.defaultLimit
+}
+
+var timeouts struct {
+	sync.Mutex
+	limits *handlerTimeouts
+	loaded bool
+}
+
+// getHandlerTimeouts returns the limits configured under peer.handlerTimeouts
+func getHandlerTimeouts() (*handlerTimeouts, error) {
+	timeouts.Lock()
+	defer timeouts.Unlock()
+	if !timeouts.loaded {
+		var types map[string]string
+		if err := viper.UnmarshalKey("peer.handlerTimeouts.types", &types); err != nil {
+			return nil, err
+		}
+		limits, err := newHandlerTimeouts(viper.GetDuration("peer.handlerTimeouts.default"), types)
+		if err != nil {
+			return nil, err
+		}
+		timeouts.limits = limits
+		timeouts.loaded = true
+	}
+	return timeouts.limits, nil
+}
+
+// handleMessageWithTimeout hands msg to handler, giving up with a
+// HandlerTimeoutError once the limit configured for the message type has
+// passed. The handler is still processing the message at that point, so the
+// caller must stop using it.
+func handleMessageWithTimeout(handler MessageHandler, msg *pb.Message) error {
+	limits, err := getHandlerTimeouts()
+	if err != nil {
+		return fmt.Errorf("Error loading handler timeouts: %s", err)
+	}
+	limit := limits.limit(msg.Type)
+	if limit <= 0 {
+		return handler.HandleMessage(msg)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- handler.HandleMessage(msg)
+	}()
+	select {
+	case err = <-done:
+		return err
+	case <-time.After(limit):
+		return &HandlerTimeoutError{Type: msg.Type, Limit: limit}
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// slowHandler is a MessageHandler that blocks on messages until released
+type slowHandler struct {
+	MessageHandler
+	release chan struct{}
+}
+
+func (h *slowHandler) HandleMessage(msg *pb.Message) error {
+	<-h.release
+	return nil
+}
+
+func loadHandlerTimeouts(t *testing.T, config string) {
+	viper.SetConfigType("yaml")
+	if err := viper.ReadConfig(bytes.NewBufferString(config)); err != nil {
+		t.Fatal(err)
+	}
+	timeouts.Lock()
+	timeouts.loaded = false
+	timeouts.Unlock()
+}
+
+func TestHandlerTimeouts(t *testing.T) {
+	limits, err := newHandlerTimeouts(time.Second, map[string]string{"disc_get_peers": "5s", "SYNC_BLOCKS": "1m"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := limits.limit(pb.Message_DISC_GET_PEERS); d != 5*time.Second {
+		t.Errorf("Expected 5s for DISC_GET_PEERS, got %s", d)
+	}
+	if d := limits.limit(pb.Message_SYNC_BLOCKS); d != time.Minute {
+		t.Errorf("Expected 1m for SYNC_BLOCKS, got %s", d)
+	}
+	if d := limits.limit(pb.Message_CONSENSUS); d != time.Second {
+		t.Errorf("Expected the default for CONSENSUS, got %s", d)
+	}
+
+	if _, err = newHandlerTimeouts(0, map[string]string{"DISC_BOGUS": "5s"}); err == nil {
+		t.Error("Expected unknown message type to be rejected")
+	}
+	if _, err = newHandlerTimeouts(0, map[string]string{"DISC_GET_PEERS": "soon"}); err == nil {
+		t.Error("Expected invalid timeout to be rejected")
+	}
+}
+
+func TestHandleMessageWithTimeout(t *testing.T) {
+	defer resetTestConfig()
+	loadHandlerTimeouts(t, `
+peer:
+    handlerTimeouts:
+        default: 0s
+        types:
+            DISC_GET_PEERS: 50ms
+`)
+	h := &slowHandler{release: make(chan struct{})}
+	defer close(h.release)
+
+	err := handleMessageWithTimeout(h, &pb.Message{Type: pb.Message_DISC_GET_PEERS})
+	if e, ok := err.(*HandlerTimeoutError); !ok || e.Type != pb.Message_DISC_GET_PEERS {
+		t.Fatalf("Expected a HandlerTimeoutError for DISC_GET_PEERS, got %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- handleMessageWithTimeout(h, &pb.Message{Type: pb.Message_DISC_PING})
+	}()
+	select {
+	case err = <-done:
+		t.Fatalf("Expected DISC_PING not to be bounded, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	h.release <- struct{}{}
+	h.release <- struct{}{}
+	if err = <-done; err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+}
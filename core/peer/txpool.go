@@ -0,0 +1,304 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/hyperledger/fabric/core/util"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// maxDigestHashes bounds the bit positions per hash of a TransactionDigest
+const maxDigestHashes = 16
+
+// transactionGossiper is implemented by coordinators that pool transactions,
+// handlers exchange digests of the pool with their peer on the discovery
+// tick and hand it the transactions the peer rebroadcast
+type transactionGossiper interface {
+	transactionDigest() *pb.TransactionDigest
+	missingTransactions(digest *pb.TransactionDigest) ([]*pb.Transaction, error)
+	receivePooled(from *pb.PeerEndpoint, transactions []*pb.Transaction)
+}
+
+type pooledTransaction struct {
+	transaction *pb.Transaction
+	hash        string
+	added       time.Time
+}
+
+// txPool keeps the transactions submitted to this peer for ttl so that
+// duplicates, by UUID or by hash, are not forwarded to consensus again, and
+// so that they can be rebroadcast to peers whose digest lacks them. Once
+// maxSize transactions are pooled the oldest is dropped.
+type txPool struct {
+	sync.Mutex
+	ttl            time.Duration
+	maxSize        int
+	filterSize     int
+	maxRebroadcast int
+	byUUID         map[string]*pooledTransaction
+	byHash         map[string]*pooledTransaction
+	// in the order added, which is also the order of expiry
+	order []*pooledTransaction
+}
+
+// newTxPool returns nil, pooling nothing, if ttl or maxSize is not positive
+func newTxPool(ttl time.Duration, maxSize, filterSize, maxRebroadcast int) *txPool {
+	if ttl <= 0 || maxSize <= 0 {
+		return nil
+	}
+	if filterSize <= 0 {
+		filterSize = 1
+	}
+	return &txPool{ttl: ttl, maxSize: maxSize, filterSize: filterSize, maxRebroadcast: maxRebroadcast,
+		byUUID: make(map[string]*pooledTransaction), byHash: make(map[string]*pooledTransaction)}
+}
+
+// transactionHash hashes the signed part of transaction, so that the same
+// transaction signed again is still a duplicate
+func transactionHash(transaction *pb.Transaction) (string, error) {
+	data, err := transaction.SigningBytes()
+	if err != nil {
+		return "", fmt.Errorf("Error marshalling transaction %s: %s", transaction.Uuid, err)
+	}
+	return string(util.ComputeCryptoHash(data)), nil
+}
+
+// validateTransaction refuses transactions that cannot be pooled
+func validateTransaction(transaction *pb.Transaction, now time.Time) error {
+	switch {
+	case transaction.Uuid == "":
+		return errors.New("Transaction has no UUID")
+	case transaction.Type == pb.Transaction_UNDEFINED || transaction.Type == pb.Transaction_CHAINCODE_QUERY:
+		return fmt.Errorf("Transaction %s of type %s cannot be pooled", transaction.Uuid, transaction.Type)
+	case transaction.IsExpired(now):
+		return fmt.Errorf("Transaction %s expired", transaction.Uuid)
+	}
+	return nil
+}
+
+// prune drops the transactions pooled for longer than ttl
+func (pool *txPool) prune(now time.Time) {
+	for len(pool.order) > 0 && now.Sub(pool.order[0].added) >= pool.ttl {
+		pool.dropOldest()
+	}
+}
+
+func (pool *txPool) dropOldest() {
+	oldest := pool.order[0]
+	pool.order[0] = nil
+	pool.order = pool.order[1:]
+	delete(pool.byUUID, oldest.transaction.Uuid)
+	delete(pool.byHash, oldest.hash)
+}
+
+// add validates and pools transaction, returning false if it is a duplicate
+// of a pooled transaction
+func (pool *txPool) add(transaction *pb.Transaction) (bool, error) {
+	now := time.Now()
+	if err := validateTransaction(transaction, now); err != nil {
+		return false, err
+	}
+	hash, err := transactionHash(transaction)
+	if err != nil {
+		return false, err
+	}
+	pool.Lock()
+	defer pool.Unlock()
+	pool.prune(now)
+	if pool.byUUID[transaction.Uuid] != nil || pool.byHash[hash] != nil {
+		return false, nil
+	}
+	if len(pool.order) >= pool.maxSize {
+		pool.dropOldest()
+	}
+	pooled := &pooledTransaction{transaction: transaction, hash: hash, added: now}
+	pool.byUUID[transaction.Uuid] = pooled
+	pool.byHash[hash] = pooled
+	pool.order = append(pool.order, pooled)
+	return true, nil
+}
+
+// remove drops transaction, or the one pooled with its UUID, from the pool
+func (pool *txPool) remove(transaction *pb.Transaction) {
+	pool.Lock()
+	defer pool.Unlock()
+	pooled := pool.byUUID[transaction.Uuid]
+	if pooled == nil {
+		return
+	}
+	delete(pool.byUUID, pooled.transaction.Uuid)
+	delete(pool.byHash, pooled.hash)
+	for i, p := range pool.order {
+		if p == pooled {
+			pool.order = append(pool.order[:i], pool.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// contains returns whether transaction, or one with its UUID, is pooled
+func (pool *txPool) contains(transaction *pb.Transaction) bool {
+	pool.Lock()
+	defer pool.Unlock()
+	pool.prune(time.Now())
+	if pool.byUUID[transaction.Uuid] != nil {
+		return true
+	}
+	hash, err := transactionHash(transaction)
+	return err == nil && pool.byHash[hash] != nil
+}
+
+// digest returns a bloom filter of the pooled transactions, sized for the
+// number pooled
+func (pool *txPool) digest() *pb.TransactionDigest {
+	pool.Lock()
+	defer pool.Unlock()
+	pool.prune(time.Now())
+	bits := uint64(pool.filterSize) * 8
+	hashes := uint32(1)
+	if n := len(pool.order); n > 0 {
+		// The number of positions per hash minimizing false positives
+		hashes = uint32(math.Ceil(float64(bits) / float64(n) * math.Ln2))
+		if hashes < 1 {
+			hashes = 1
+		} else if hashes > maxDigestHashes {
+			hashes = maxDigestHashes
+		}
+	}
+	digest := &pb.TransactionDigest{Filter: make([]byte, pool.filterSize), Hashes: hashes}
+	for _, pooled := range pool.order {
+		for _, position := range digestPositions(pooled.hash, bits, hashes) {
+			digest.Filter[position/8] |= 1 << (position % 8)
+		}
+	}
+	return digest
+}
+
+// missing returns at most maxRebroadcast of the pooled transactions the
+// filter of digest lacks, oldest first
+func (pool *txPool) missing(digest *pb.TransactionDigest) ([]*pb.Transaction, error) {
+	if digest.Hashes > maxDigestHashes {
+		return nil, fmt.Errorf("Transaction digest uses %d positions per hash, at most %d are allowed", digest.Hashes, maxDigestHashes)
+	}
+	pool.Lock()
+	defer pool.Unlock()
+	pool.prune(time.Now())
+	bits := uint64(len(digest.Filter)) * 8
+	var transactions []*pb.Transaction
+	for _, pooled := range pool.order {
+		if pool.maxRebroadcast > 0 && len(transactions) == pool.maxRebroadcast {
+			break
+		}
+		if !digestContains(digest, pooled.hash, bits) {
+			transactions = append(transactions, pooled.transaction)
+		}
+	}
+	return transactions, nil
+}
+
+// digestContains returns whether the filter of digest may hold hash
+func digestContains(digest *pb.TransactionDigest, hash string, bits uint64) bool {
+	if bits == 0 || digest.Hashes == 0 {
+		return false
+	}
+	for _, position := range digestPositions(hash, bits, digest.Hashes) {
+		if digest.Filter[position/8]&(1<<(position%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// digestPositions derives the bit positions of hash in a filter of bits bits
+// from two halves of the hash, which is uniform already
+func digestPositions(hash string, bits uint64, hashes uint32) []uint64 {
+	h1 := binary.BigEndian.Uint64([]byte(hash[0:8]))
+	h2 := binary.BigEndian.Uint64([]byte(hash[8:16])) | 1
+	positions := make([]uint64, hashes)
+	for i := range positions {
+		positions[i] = (h1 + uint64(i)*h2) % bits
+	}
+	return positions
+}
+
+// PoolTransaction validates transaction and pools it, returning false if it
+// duplicates a pooled transaction and must not be forwarded to consensus
+// again. Every transaction is new when pooling is disabled.
+func (p *PeerImpl) PoolTransaction(transaction *pb.Transaction) (bool, error) {
+	if p.txPool == nil {
+		return true, nil
+	}
+	return p.txPool.add(transaction)
+}
+
+// transactionDigest returns the digest of the pool sent to the peers on the
+// discovery tick, nil when pooling is disabled
+func (p *PeerImpl) transactionDigest() *pb.TransactionDigest {
+	if p.txPool == nil {
+		return nil
+	}
+	return p.txPool.digest()
+}
+
+// missingTransactions returns the pooled transactions to rebroadcast to a
+// peer that sent digest
+func (p *PeerImpl) missingTransactions(digest *pb.TransactionDigest) ([]*pb.Transaction, error) {
+	if p.txPool == nil {
+		return nil, nil
+	}
+	return p.txPool.missing(digest)
+}
+
+// receivePooled pools the transactions rebroadcast by the peer from. A
+// validator forwarded them to consensus already, those of other peers are
+// executed, which forwards them to consensus and pools them.
+func (p *PeerImpl) receivePooled(from *pb.PeerEndpoint, transactions []*pb.Transaction) {
+	if p.txPool == nil {
+		return
+	}
+	for _, transaction := range transactions {
+		if p.txPool.contains(transaction) {
+			continue
+		}
+		if from.Type == pb.PeerEndpoint_VALIDATOR {
+			if _, err := p.txPool.add(transaction); err != nil {
+				peerLogger.Debug("Dropping transaction rebroadcast by %s: %s", from.ID, err)
+			}
+			continue
+		}
+		if err := validateTransaction(transaction, time.Now()); err != nil {
+			peerLogger.Debug("Dropping transaction rebroadcast by %s: %s", from.ID, err)
+			continue
+		}
+		peerLogger.Debug("Forwarding transaction %s rebroadcast by %s", transaction.Uuid, from.ID)
+		go func(transaction *pb.Transaction) {
+			if response := p.ExecuteTransaction(transaction); response.Status != pb.Response_SUCCESS {
+				peerLogger.Warning("Error forwarding transaction %s rebroadcast by %s: %s", transaction.Uuid, from.ID, response.Msg)
+			}
+		}(transaction)
+	}
+}
@@ -0,0 +1,162 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package peer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/looplab/fsm"
+	gp "google/protobuf"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func newPoolTestTransaction(uuid string) *pb.Transaction {
+	return &pb.Transaction{Type: pb.Transaction_CHAINCODE_INVOKE, Uuid: uuid, Payload: []byte("payload of " + uuid)}
+}
+
+func TestTxPool_Deduplicates(t *testing.T) {
+	pool := newTxPool(time.Minute, 2, 64, 0)
+	tx1 := newPoolTestTransaction("tx1")
+	if added, err := pool.add(tx1); !added || err != nil {
+		t.Fatalf("Expected tx1 to be pooled, got %t, %v", added, err)
+	}
+	// Same UUID, and same transaction signed again
+	resigned := newPoolTestTransaction("tx1")
+	resigned.Signature = []byte("other signature")
+	sameUUID := newPoolTestTransaction("tx1")
+	sameUUID.Payload = []byte("other payload")
+	for _, tx := range []*pb.Transaction{resigned, sameUUID} {
+		if added, err := pool.add(tx); added || err != nil {
+			t.Fatalf("Expected a duplicate of tx1, got %t, %v", added, err)
+		}
+	}
+
+	expired := newPoolTestTransaction("expired")
+	expired.Expiry = &gp.Timestamp{Seconds: time.Now().Add(-time.Minute).Unix()}
+	query := newPoolTestTransaction("query")
+	query.Type = pb.Transaction_CHAINCODE_QUERY
+	for _, tx := range []*pb.Transaction{expired, query, newPoolTestTransaction("")} {
+		if _, err := pool.add(tx); err == nil {
+			t.Fatalf("Expected transaction %q to be refused", tx.Uuid)
+		}
+	}
+
+	// Beyond maxSize the oldest is dropped
+	pool.add(newPoolTestTransaction("tx2"))
+	pool.add(newPoolTestTransaction("tx3"))
+	if pool.contains(tx1) || !pool.contains(newPoolTestTransaction("tx3")) {
+		t.Fatal("Expected tx1 to be dropped for tx3")
+	}
+
+	// And after ttl
+	pool.ttl = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if pool.contains(newPoolTestTransaction("tx3")) {
+		t.Fatal("Expected tx3 to expire from the pool")
+	}
+}
+
+func TestTxPool_DigestSelectsMissing(t *testing.T) {
+	pool := newTxPool(time.Minute, 100, 256, 0)
+	other := newTxPool(time.Minute, 100, 256, 0)
+	for i := 0; i < 20; i++ {
+		tx := newPoolTestTransaction(string('a' + rune(i)))
+		pool.add(tx)
+		if i%2 == 0 {
+			other.add(tx)
+		}
+	}
+	missing, err := pool.missing(other.digest())
+	if err != nil {
+		t.Fatalf("Error selecting missing transactions: %s", err)
+	}
+	if len(missing) != 10 {
+		t.Fatalf("Expected the 10 transactions missing from the digest, got %d", len(missing))
+	}
+	for _, tx := range missing {
+		if other.contains(tx) {
+			t.Fatalf("Transaction %s is not missing from the digest", tx.Uuid)
+		}
+	}
+
+	// An empty digest lacks everything, bounded by maxRebroadcast
+	pool.maxRebroadcast = 5
+	if missing, _ = pool.missing(&pb.TransactionDigest{}); len(missing) != 5 {
+		t.Fatalf("Expected maxRebroadcast transactions, got %d", len(missing))
+	}
+	if _, err = pool.missing(&pb.TransactionDigest{Filter: []byte{0xff}, Hashes: maxDigestHashes + 1}); err == nil {
+		t.Fatal("Expected a digest with too many positions per hash to be refused")
+	}
+}
+
+func TestTxPool_HandlersExchangeDigests(t *testing.T) {
+	p := newMeshTestPeer(0)
+	p.txPool = newTxPool(time.Minute, 100, 256, 0)
+	p.txPool.add(newPoolTestTransaction("tx1"))
+
+	stream := &mockChatStream{sent: make(chan *pb.Message, 10)}
+	d := newMeshTestHandler("v1", pb.PeerEndpoint_VALIDATOR)
+	d.ChatStream = stream
+	d.Coordinator = p
+	d.protocolVersion = ProtocolVersion
+	d.FSM = fsm.NewFSM("established",
+		fsm.Events{
+			{Name: pb.Message_TX_DIGEST.String(), Src: []string{"established"}, Dst: "established"},
+			{Name: pb.Message_TX_POOL.String(), Src: []string{"established"}, Dst: "established"},
+		},
+		fsm.Callbacks{
+			"before_" + pb.Message_TX_DIGEST.String(): func(e *fsm.Event) { d.beforeTxDigest(e) },
+			"before_" + pb.Message_TX_POOL.String():   func(e *fsm.Event) { d.beforeTxPool(e) },
+		})
+
+	d.sendTxDigest()
+	msgs := received(stream)
+	if len(msgs) != 1 || msgs[0].Type != pb.Message_TX_DIGEST {
+		t.Fatalf("Expected the pool digest to be sent, got %v", msgs)
+	}
+
+	// The remote peer pooled nothing, tx1 is rebroadcast to it
+	payload, _ := proto.Marshal(&pb.TransactionDigest{})
+	if err := d.HandleMessage(&pb.Message{Type: pb.Message_TX_DIGEST, Payload: payload}); err != nil {
+		t.Fatalf("Error handling %s: %s", pb.Message_TX_DIGEST, err)
+	}
+	msgs = received(stream)
+	if len(msgs) != 1 || msgs[0].Type != pb.Message_TX_POOL {
+		t.Fatalf("Expected %s, got %v", pb.Message_TX_POOL, msgs)
+	}
+	block := &pb.TransactionBlock{}
+	proto.Unmarshal(msgs[0].Payload, block)
+	if len(block.Transactions) != 1 || block.Transactions[0].Uuid != "tx1" {
+		t.Fatalf("Expected tx1 to be rebroadcast, got %v", block.Transactions)
+	}
+
+	// A validator rebroadcasting tx2 forwarded it to consensus already, it
+	// is only pooled
+	payload, _ = proto.Marshal(&pb.TransactionBlock{Transactions: []*pb.Transaction{newPoolTestTransaction("tx2")}})
+	if err := d.HandleMessage(&pb.Message{Type: pb.Message_TX_POOL, Payload: payload}); err != nil {
+		t.Fatalf("Error handling %s: %s", pb.Message_TX_POOL, err)
+	}
+	if !p.txPool.contains(newPoolTestTransaction("tx2")) {
+		t.Fatal("Expected the rebroadcast transaction to be pooled")
+	}
+}
@@ -42,6 +42,7 @@ import (
 	"github.com/hyperledger/fabric/core/chaincode"
 	"github.com/hyperledger/fabric/core/crypto"
 	"github.com/hyperledger/fabric/core/crypto/utils"
+	"github.com/hyperledger/fabric/core/errors"
 	pb "github.com/hyperledger/fabric/protos"
 )
 
@@ -64,8 +65,9 @@ type ServerOpenchainREST struct {
 
 // restResult defines the response payload for a general REST interface request.
 type restResult struct {
-	OK    string `json:",omitempty"`
-	Error string `json:",omitempty"`
+	OK        string `json:",omitempty"`
+	Error     string `json:",omitempty"`
+	ErrorCode string `json:",omitempty"`
 }
 
 // rpcRequest defines the JSON RPC 2.0 request payload for the /chaincode endpoint.
@@ -171,9 +173,7 @@ func (s *ServerOpenchainREST) Register(rw web.ResponseWriter, req *web.Request)
 
 		// Client must supply payload
 		if err == io.EOF {
-			rw.WriteHeader(http.StatusBadRequest)
-			fmt.Fprintf(rw, "{\"Error\": \"Payload must contain object Secret with enrollId and enrollSecret fields.\"}")
-			restLogger.Error("{\"Error\": \"Payload must contain object Secret with enrollId and enrollSecret fields.\"}")
+			writeCodedError(rw, req, http.StatusBadRequest, errors.Error(errors.Rest, errors.MissingSecret))
 		} else {
 			rw.WriteHeader(http.StatusBadRequest)
 			fmt.Fprintf(rw, "{\"Error\": \"%s\"}", errVal)
@@ -185,9 +185,7 @@ func (s *ServerOpenchainREST) Register(rw web.ResponseWriter, req *web.Request)
 
 	// Check that the enrollId and enrollSecret are not left blank.
 	if (loginSpec.EnrollId == "") || (loginSpec.EnrollSecret == "") {
-		rw.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(rw, "{\"Error\": \"enrollId and enrollSecret may not be blank.\"}")
-		restLogger.Error("{\"Error\": \"enrollId and enrollSecret may not be blank.\"}")
+		writeCodedError(rw, req, http.StatusBadRequest, errors.Error(errors.Rest, errors.BlankCredentials))
 
 		return
 	}
@@ -269,9 +267,7 @@ func (s *ServerOpenchainREST) GetEnrollmentID(rw web.ResponseWriter, req *web.Re
 		fmt.Fprintf(rw, "{\"OK\": \"User %s is already logged in.\"}", enrollmentID)
 		restLogger.Info("User '%s' is already logged in.\n", enrollmentID)
 	} else {
-		rw.WriteHeader(http.StatusUnauthorized)
-		fmt.Fprintf(rw, "{\"Error\": \"User %s must log in.\"}", enrollmentID)
-		restLogger.Info("User '%s' must log in.\n", enrollmentID)
+		writeCodedError(rw, req, http.StatusUnauthorized, errors.Error(errors.Rest, errors.LoginRequired, enrollmentID))
 	}
 
 	return
@@ -311,18 +307,14 @@ func (s *ServerOpenchainREST) DeleteEnrollmentID(rw web.ResponseWriter, req *web
 
 	// The user is logged in, delete the user's login token
 	if err := os.RemoveAll(loginTok); err != nil {
-		rw.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(rw, "{\"Error\": \"Error trying to delete login token for user %s: %s\"}", enrollmentID, err)
-		restLogger.Error(fmt.Sprintf("{\"Error\": \"Error trying to delete login token for user %s: %s\"}", enrollmentID, err))
+		writeCodedError(rw, req, http.StatusInternalServerError, errors.Error(errors.Rest, errors.DeleteLoginToken, enrollmentID, err))
 
 		return
 	}
 
 	// The user is logged in, delete the user's cert and key directory
 	if err := os.RemoveAll(cryptoDir); err != nil {
-		rw.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(rw, "{\"Error\": \"Error trying to delete login directory for user %s: %s\"}", enrollmentID, err)
-		restLogger.Error(fmt.Sprintf("{\"Error\": \"Error trying to delete login directory for user %s: %s\"}", enrollmentID, err))
+		writeCodedError(rw, req, http.StatusInternalServerError, errors.Error(errors.Rest, errors.DeleteLoginDirectory, enrollmentID, err))
 
 		return
 	}
@@ -371,9 +363,7 @@ func (s *ServerOpenchainREST) GetEnrollmentCert(rw web.ResponseWriter, req *web.
 
 		// Certificate handler can not be hil
 		if handler == nil {
-			rw.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(rw, "{\"Error\": \"Error retrieving certificate handler.\"}")
-			restLogger.Error("{\"Error\": \"Error retrieving certificate handler.\"}")
+			writeCodedError(rw, req, http.StatusInternalServerError, errors.Error(errors.Rest, errors.NoCertificateHandler))
 
 			return
 		}
@@ -383,18 +373,14 @@ func (s *ServerOpenchainREST) GetEnrollmentCert(rw web.ResponseWriter, req *web.
 
 		// Confirm the retrieved enrollment certificate is not nil
 		if certDER == nil {
-			rw.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(rw, "{\"Error\": \"Enrollment certificate is nil.\"}")
-			restLogger.Error("{\"Error\": \"Enrollment certificate is nil.\"}")
+			writeCodedError(rw, req, http.StatusInternalServerError, errors.Error(errors.Rest, errors.NilEnrollmentCert))
 
 			return
 		}
 
 		// Confirm the retrieved enrollment certificate has non-zero length
 		if len(certDER) == 0 {
-			rw.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(rw, "{\"Error\": \"Enrollment certificate length is 0.\"}")
-			restLogger.Error("{\"Error\": \"Enrollment certificate length is 0.\"}")
+			writeCodedError(rw, req, http.StatusInternalServerError, errors.Error(errors.Rest, errors.EmptyEnrollmentCert))
 
 			return
 		}
@@ -415,9 +401,7 @@ func (s *ServerOpenchainREST) GetEnrollmentCert(rw web.ResponseWriter, req *web.
 		}
 	} else {
 		// Security must be enabled to request enrollment certificates
-		rw.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(rw, "{\"Error\": \"Security functionality must be enabled before requesting client certificates.\"}")
-		restLogger.Error("{\"Error\": \"Security functionality must be enabled before requesting client certificates.\"}")
+		writeCodedError(rw, req, http.StatusBadRequest, errors.Error(errors.Rest, errors.SecurityDisabled))
 
 		return
 	}
@@ -493,9 +477,7 @@ func (s *ServerOpenchainREST) GetTransactionCert(rw web.ResponseWriter, req *web
 
 		// Certificate handler can not be hil
 		if handler == nil {
-			rw.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(rw, "{\"Error\": \"Error retrieving certificate handler.\"}")
-			restLogger.Error("{\"Error\": \"Error retrieving certificate handler.\"}")
+			writeCodedError(rw, req, http.StatusInternalServerError, errors.Error(errors.Rest, errors.NoCertificateHandler))
 
 			return
 		}
@@ -555,9 +537,7 @@ func (s *ServerOpenchainREST) GetTransactionCert(rw web.ResponseWriter, req *web
 		}
 	} else {
 		// Security must be enabled to request transaction certificates
-		rw.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(rw, "{\"Error\": \"Security functionality must be enabled before requesting client certificates.\"}")
-		restLogger.Error("{\"Error\": \"Security functionality must be enabled before requesting client certificates.\"}")
+		writeCodedError(rw, req, http.StatusBadRequest, errors.Error(errors.Rest, errors.SecurityDisabled))
 
 		return
 	}
@@ -651,7 +631,7 @@ func (s *ServerOpenchainREST) Deploy(rw web.ResponseWriter, req *web.Request) {
 
 	// Decode the incoming JSON payload
 	var spec pb.ChaincodeSpec
-	err := jsonpb.Unmarshal(req.Body, &spec)
+	err := jsonpb.Unmarshal(legacyCompatibleBody(req.Body), &spec)
 
 	// Check for proper JSON syntax
 	if err != nil {
@@ -794,7 +774,7 @@ func (s *ServerOpenchainREST) Invoke(rw web.ResponseWriter, req *web.Request) {
 
 	// Decode the incoming JSON payload
 	var spec pb.ChaincodeInvocationSpec
-	err := jsonpb.Unmarshal(req.Body, &spec)
+	err := jsonpb.Unmarshal(legacyCompatibleBody(req.Body), &spec)
 
 	// Check for proper JSON syntax
 	if err != nil {
@@ -928,9 +908,90 @@ func (s *ServerOpenchainREST) Invoke(rw web.ResponseWriter, req *web.Request) {
 func (s *ServerOpenchainREST) Query(rw web.ResponseWriter, req *web.Request) {
 	restLogger.Info("REST querying chaincode...")
 
+	spec, ok := decodeQuerySpec(rw, req)
+	if !ok {
+		return
+	}
+
+	// Query the chainCode
+	resp, err := s.devops.Query(context.Background(), spec)
+	if err != nil {
+		// Replace " characters with '
+		errVal := strings.Replace(err.Error(), "\"", "'", -1)
+
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(rw, "{\"Error\": \"%s\"}", errVal)
+		restLogger.Error(fmt.Sprintf("{\"Error\": \"Querying Chaincode -- %s\"}", errVal))
+
+		return
+	}
+
+	writeQueryResponse(rw, resp)
+}
+
+// QueryAsync starts the requested query on the target Chaincode and returns
+// its UUID without waiting for its result, which is retrieved with
+// GetQueryResult.
+func (s *ServerOpenchainREST) QueryAsync(rw web.ResponseWriter, req *web.Request) {
+	restLogger.Info("REST starting chaincode query...")
+
+	spec, ok := decodeQuerySpec(rw, req)
+	if !ok {
+		return
+	}
+
+	resp, err := s.devops.QueryAsync(context.Background(), spec)
+	if err != nil {
+		// Replace " characters with '
+		errVal := strings.Replace(err.Error(), "\"", "'", -1)
+
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(rw, "{\"Error\": \"%s\"}", errVal)
+		restLogger.Error(fmt.Sprintf("{\"Error\": \"Starting Chaincode query -- %s\"}", errVal))
+
+		return
+	}
+
+	rw.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(rw, "{\"OK\": \"Successfully started chainCode query.\",\"message\": \"%s\"}", string(resp.Msg))
+	restLogger.Info("Successfuly started chainCode query with txuuid (%s)\n", string(resp.Msg))
+}
+
+// GetQueryResult returns the result of a query started with QueryAsync, or
+// 202 Accepted while the query is running. The result is returned once.
+func (s *ServerOpenchainREST) GetQueryResult(rw web.ResponseWriter, req *web.Request) {
+	txUUID := req.PathParams["uuid"]
+
+	resp, done, err := s.devops.PollQuery(txUUID)
+	if err == core.ErrUnknownQuery {
+		rw.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(rw, "{\"Error\": \"Query %s is not found.\"}", txUUID)
+		return
+	} else if err != nil {
+		// Replace " characters with '
+		errVal := strings.Replace(err.Error(), "\"", "'", -1)
+
+		rw.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(rw, "{\"Error\": \"%s\"}", errVal)
+		restLogger.Error(fmt.Sprintf("{\"Error\": \"Querying Chaincode -- %s\"}", errVal))
+
+		return
+	} else if !done {
+		rw.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(rw, "{\"OK\": \"Query is running.\",\"message\": \"%s\"}", txUUID)
+		return
+	}
+
+	writeQueryResponse(rw, resp)
+}
+
+// decodeQuerySpec decodes the ChaincodeInvocationSpec of a query request and
+// adds the login token of its user when security is enabled. The error is
+// written to rw when it returns false.
+func decodeQuerySpec(rw web.ResponseWriter, req *web.Request) (*pb.ChaincodeInvocationSpec, bool) {
 	// Decode the incoming JSON payload
 	var spec pb.ChaincodeInvocationSpec
-	err := jsonpb.Unmarshal(req.Body, &spec)
+	err := jsonpb.Unmarshal(legacyCompatibleBody(req.Body), &spec)
 
 	// Check for proper JSON syntax
 	if err != nil {
@@ -950,7 +1011,7 @@ func (s *ServerOpenchainREST) Query(rw web.ResponseWriter, req *web.Request) {
 			restLogger.Error(fmt.Sprintf("{\"Error\": \"%s\"}", errVal))
 		}
 
-		return
+		return nil, false
 	}
 
 	// Check that the ChaincodeSpec is not left blank.
@@ -959,7 +1020,7 @@ func (s *ServerOpenchainREST) Query(rw web.ResponseWriter, req *web.Request) {
 		fmt.Fprintf(rw, "{\"Error\": \"Payload must contain a ChaincodeSpec.\"}")
 		restLogger.Error("{\"Error\": \"Payload must contain a ChaincodeSpec.\"}")
 
-		return
+		return nil, false
 	}
 
 	// Check that the ChaincodeID is not left blank.
@@ -968,7 +1029,7 @@ func (s *ServerOpenchainREST) Query(rw web.ResponseWriter, req *web.Request) {
 		fmt.Fprintf(rw, "{\"Error\": \"Payload must contain a ChaincodeID.\"}")
 		restLogger.Error("{\"Error\": \"Payload must contain a ChaincodeID.\"}")
 
-		return
+		return nil, false
 	}
 
 	// Check that the Chaincode name is not blank.
@@ -977,7 +1038,7 @@ func (s *ServerOpenchainREST) Query(rw web.ResponseWriter, req *web.Request) {
 		fmt.Fprintf(rw, "{\"Error\": \"Chaincode name may not be blank.\"}")
 		restLogger.Error("{\"Error\": \"Chaincode name may not be blank.\"}")
 
-		return
+		return nil, false
 	}
 
 	// Check that the CtorMsg is not left blank.
@@ -986,7 +1047,7 @@ func (s *ServerOpenchainREST) Query(rw web.ResponseWriter, req *web.Request) {
 		fmt.Fprintf(rw, "{\"Error\": \"Payload must contain a CtorMsg with a Chaincode function name.\"}")
 		restLogger.Error("{\"Error\": \"Payload must contain a CtorMsg with a Chaincode function name.\"}")
 
-		return
+		return nil, false
 	}
 
 	// If security is enabled, add client login token
@@ -997,7 +1058,7 @@ func (s *ServerOpenchainREST) Query(rw web.ResponseWriter, req *web.Request) {
 			fmt.Fprintf(rw, "{\"Error\": \"Must supply username for chaincode when security is enabled.\"}")
 			restLogger.Error("{\"Error\": \"Must supply username for chaincode when security is enabled.\"}")
 
-			return
+			return nil, false
 		}
 
 		// Retrieve the REST data storage path
@@ -1030,7 +1091,7 @@ func (s *ServerOpenchainREST) Query(rw web.ResponseWriter, req *web.Request) {
 				fmt.Fprintf(rw, "{\"Error\": \"User not logged in. Use the '/registrar' endpoint to obtain a security token.\"}")
 				restLogger.Error("{\"Error\": \"User not logged in. Use the '/registrar' endpoint to obtain a security token.\"}")
 
-				return
+				return nil, false
 			}
 			// Unexpected error
 			rw.WriteHeader(http.StatusInternalServerError)
@@ -1039,19 +1100,11 @@ func (s *ServerOpenchainREST) Query(rw web.ResponseWriter, req *web.Request) {
 		}
 	}
 
-	// Query the chainCode
-	resp, err := s.devops.Query(context.Background(), &spec)
-	if err != nil {
-		// Replace " characters with '
-		errVal := strings.Replace(err.Error(), "\"", "'", -1)
-
-		rw.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(rw, "{\"Error\": \"%s\"}", errVal)
-		restLogger.Error(fmt.Sprintf("{\"Error\": \"Querying Chaincode -- %s\"}", errVal))
-
-		return
-	}
+	return &spec, true
+}
 
+// writeQueryResponse writes the result of a query to rw
+func writeQueryResponse(rw web.ResponseWriter, resp *pb.Response) {
 	// Determine if the response received is JSON formatted
 	if isJSON(string(resp.Msg)) {
 		// Response is JSON formatted, return it as is
@@ -1113,7 +1166,7 @@ func (s *ServerOpenchainREST) ProcessChaincode(rw web.ResponseWriter, req *web.R
 
 	// Decode the request payload as an rpcRequest structure.	There will be an
 	// error here if the incoming JSON is invalid (e.g. missing brace or comma).
-	err = json.Unmarshal(reqBody, &requestPayload)
+	err = json.Unmarshal(renameLegacyFields(reqBody), &requestPayload)
 	if err != nil {
 		// Format the error appropriately
 		error := formatRPCError(ParseError.Code, ParseError.Message, fmt.Sprintf("Error unmarshalling chaincode request payload: %s", err))
@@ -1698,6 +1751,8 @@ func StartOpenchainRESTServer(server *ServerOpenchain, devops *core.Devops) {
 
 	// The /chaincode endpoint which superceedes the /devops endpoint from above
 	router.Post("/chaincode", (*ServerOpenchainREST).ProcessChaincode)
+	router.Post("/chaincode/queries", (*ServerOpenchainREST).QueryAsync)
+	router.Get("/chaincode/queries/:uuid", (*ServerOpenchainREST).GetQueryResult)
 
 	router.Get("/transactions/:uuid", (*ServerOpenchainREST).GetTransactionByUUID)
 
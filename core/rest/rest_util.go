@@ -19,7 +19,16 @@ under the License.
 
 package rest
 
-import "encoding/json"
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+
+	"github.com/gocraft/web"
+
+	"github.com/hyperledger/fabric/core/errors"
+)
 
 // isJSON is a helper function to determine if a given string is proper JSON.
 func isJSON(s string) bool {
@@ -27,6 +36,72 @@ func isJSON(s string) bool {
 	return json.Unmarshal([]byte(s), &js) == nil
 }
 
+// legacyFieldNames maps the JSON field names of requests from clients that
+// predate the renaming of chainlets to chaincodes to the current names
+var legacyFieldNames = map[string]string{
+	"chainletID":   "chaincodeID",
+	"chainletSpec": "chaincodeSpec",
+}
+
+// renameLegacyFields returns the JSON document with the legacy field names
+// replaced by the current ones, logging a deprecation warning for each. The
+// document is returned unchanged if it has none or is not valid JSON, so that
+// parsing errors are reported by the caller.
+func renameLegacyFields(data []byte) []byte {
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// Keep numbers as they were written, the JSON RPC id is an int64
+	decoder.UseNumber()
+	if decoder.Decode(&doc) != nil {
+		return data
+	}
+	if !renameLegacyKeys(doc) {
+		return data
+	}
+	renamed, err := json.Marshal(doc)
+	if err != nil {
+		return data
+	}
+	return renamed
+}
+
+func renameLegacyKeys(doc interface{}) bool {
+	renamed := false
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if renameLegacyKeys(value) {
+				renamed = true
+			}
+			if name, ok := legacyFieldNames[key]; ok {
+				restLogger.Warning("Request uses the deprecated field %s, use %s instead", key, name)
+				delete(v, key)
+				if _, exists := v[name]; !exists {
+					v[name] = value
+				}
+				renamed = true
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			if renameLegacyKeys(value) {
+				renamed = true
+			}
+		}
+	}
+	return renamed
+}
+
+// legacyCompatibleBody returns the request body with legacy field names
+// replaced, see renameLegacyFields
+func legacyCompatibleBody(body io.Reader) io.Reader {
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		restLogger.Error("Error reading request body: %s", err)
+	}
+	return bytes.NewReader(renameLegacyFields(data))
+}
+
 // formatRPCError formats the ERROR response to aid in JSON RPC 2.0 implementation
 func formatRPCError(code int64, msg string, data string) rpcResult {
 	err := &rpcError{Code: code, Message: msg, Data: data}
@@ -57,3 +132,15 @@ func formatRPCResponse(res rpcResult, id *int64) rpcResponse {
 
 	return response
 }
+
+// writeCodedError writes a coded error as the response to a REST request. The
+// message is translated to the language requested in the Accept-Language
+// header while the log keeps the code and the canonical English message.
+func writeCodedError(rw web.ResponseWriter, req *web.Request, status int, err *errors.CodedError) {
+	language := errors.PreferredLanguage(req.Header.Get("Accept-Language"))
+	jsonResponse, _ := json.Marshal(restResult{Error: err.MessageIn(language), ErrorCode: err.Code()})
+
+	rw.WriteHeader(status)
+	rw.Write(jsonResponse)
+	restLogger.Error(err.Error())
+}
@@ -0,0 +1,57 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package rest
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/golang/protobuf/jsonpb"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestRenameLegacyFields(t *testing.T) {
+	legacy := `{"chainletSpec": {"type": "GOLANG", "chainletID": {"name": "mycc"}, "ctorMsg": {"function": "query", "args": ["a"]}}}`
+
+	var spec pb.ChaincodeInvocationSpec
+	if err := jsonpb.UnmarshalString(string(renameLegacyFields([]byte(legacy))), &spec); err != nil {
+		t.Fatalf("Error unmarshalling legacy request: %s", err)
+	}
+	if spec.ChaincodeSpec.GetChaincodeID().Name != "mycc" || spec.ChaincodeSpec.CtorMsg.Function != "query" {
+		t.Fatalf("Unexpected spec %s", &spec)
+	}
+
+	// Current requests and invalid JSON are passed through as is
+	for _, body := range []string{`{"chaincodeID": {"name": "mycc"}, "id": 9007199254740993}`, `{"chainletID":`} {
+		if renamed := string(renameLegacyFields([]byte(body))); renamed != body {
+			t.Errorf("Expected %s unchanged, got %s", body, renamed)
+		}
+	}
+
+	// Numbers keep their precision
+	var request rpcRequest
+	if err := json.Unmarshal(renameLegacyFields([]byte(`{"params": {"chainletID": {"name": "mycc"}}, "id": 9007199254740993}`)), &request); err != nil {
+		t.Fatalf("Error unmarshalling legacy JSON RPC request: %s", err)
+	}
+	if *request.ID != 9007199254740993 || request.Params.GetChaincodeID().Name != "mycc" {
+		t.Fatalf("Unexpected request %v", request)
+	}
+}
@@ -1,4 +1,22 @@
 var urlParser = require("url");
+var http = require("http");
+var https = require("https");
+
+/**
+ * Request types routed by the chain client.  Queries go to read-only followers
+ * when any are known, invokes always go to validators.
+ */
+var QUERY = "query";
+var INVOKE = "invoke";
+
+/**
+ * The health of a peer, in [0,1], is smoothed over recent requests by this factor
+ */
+var HEALTH_DECAY = 0.8;
+/**
+ * Weight of a peer with the worst health, so that it is still retried now and then
+ */
+var MIN_WEIGHT = 0.01;
 
 /**
  * Create a chain client mgr
@@ -28,7 +46,7 @@ function ChainClientMgr() {
 ChainClientMgr.prototype.getChain = function (chainName, create) {
    var chain = this.chains[chainName];
    if (!chain && create) {
-      chain = new Chain(chainName,this);
+      chain = new ChainClient(chainName,this);
       this.chains[chainName] = chain;
    }
    return chain;
@@ -71,6 +89,9 @@ function ChainClient(name,mgr) {
    this.name = name;
    this.mgr = mgr;
    this.peers = [];
+   // Peers forced for a request type by setPeerOverride
+   this.overrides = {};
+   this.transport = restTransport;
 }
 
 /**
@@ -96,8 +117,8 @@ ChainClient.prototype.getChainClientMgr = function() {
  * @returns {Peer} Returns a new peer.
  */
 ChainClient.prototype.addPeer = function(endpoint) {
-   var peer = new Peer(endpoint);
-   this.peers.add(peer);
+   var peer = new Peer(endpoint,this);
+   this.peers.push(peer);
    return peer;
 };
 
@@ -115,9 +136,221 @@ ChainClient.prototype.setMemberServices  = function(memberServices) {
    this.memberServices = memberServices;
 };
 
+/**
+ * Discover the peers of the network from the REST endpoint of one of them and
+ * add the ones not yet known.  The role of every peer, validator or read-only
+ * follower, is taken from the discovery metadata.
+ * @param {string} restUrl The REST endpoint of a peer, e.g. "http://host:5000".
+ * @param {function} cb Called with an error, or null and the peers of the chain.
+ */
+ChainClient.prototype.discoverPeers = function(restUrl, cb) {
+   var self = this;
+   var url = urlParser.parse(restUrl);
+   var client = url.protocol === "https:" ? https : http;
+   var req = client.get(urlParser.resolve(restUrl, "/network/peers"), function(res) {
+      var body = "";
+      res.setEncoding("utf8");
+      res.on("data", function(chunk) { body += chunk; });
+      res.on("end", function() {
+         if (res.statusCode !== 200) {
+            return cb(new Error("peer discovery failed with status " + res.statusCode + ": " + body));
+         }
+         var msg;
+         try {
+            msg = JSON.parse(body);
+         } catch (err) {
+            return cb(err);
+         }
+         self.updatePeers(msg.peers || []);
+         cb(null, self.peers);
+      });
+   });
+   req.on("error", cb);
+};
+
+/**
+ * Update the peers of the chain from a list of discovered peer endpoints of
+ * the form: { ID: { name: "vp0" }, address: "host:port", type: 1, follower: false }
+ * Unknown peers are added with a "grpc://" url, known ones get their role updated.
+ * @param {Object[]} endpoints The discovered peer endpoints.
+ */
+ChainClient.prototype.updatePeers = function(endpoints) {
+   for (var i = 0; i < endpoints.length; i++) {
+      var ep = endpoints[i];
+      var url = "grpc://" + ep.address;
+      var peer = this.getPeerByUrl(url);
+      if (!peer) {
+         peer = this.addPeer({ url: url });
+      }
+      peer.setRole(ep.type === 1 || ep.type === "VALIDATOR", !!ep.follower);
+   }
+};
+
+/**
+ * Get the peer with the given url.
+ * @returns {Peer} The peer, or null if there is none.
+ */
+ChainClient.prototype.getPeerByUrl = function(url) {
+   for (var i = 0; i < this.peers.length; i++) {
+      if (this.peers[i].getUrl() === url) {
+         return this.peers[i];
+      }
+   }
+   return null;
+};
+
+/**
+ * Force all requests of a type to a peer, bypassing the load balancing.
+ * @param {string} requestType Either "query" or "invoke".
+ * @param {Peer} peer The peer to use, or null to restore load balancing.
+ */
+ChainClient.prototype.setPeerOverride = function(requestType, peer) {
+   if (peer) {
+      this.overrides[requestType] = peer;
+   } else {
+      delete this.overrides[requestType];
+   }
+};
+
+/**
+ * Select the peer to send a request to.  Invokes are sent to validators only.
+ * Queries are sent to followers, or to validators if there are no followers.
+ * Among the candidates the peer is picked at random, weighted by its health.
+ * Peers whose role has not been discovered are considered validators.
+ * @param {string} requestType Either "query" or "invoke".
+ * @returns {Peer} The selected peer, or null if there is no candidate.
+ */
+ChainClient.prototype.selectPeer = function(requestType) {
+   if (this.overrides[requestType]) {
+      return this.overrides[requestType];
+   }
+   var validators = [];
+   var followers = [];
+   for (var i = 0; i < this.peers.length; i++) {
+      var peer = this.peers[i];
+      if (peer.isFollower()) {
+         followers.push(peer);
+      } else if (peer.isValidator()) {
+         validators.push(peer);
+      }
+   }
+   var candidates = validators;
+   if (requestType === QUERY && followers.length > 0) {
+      candidates = followers;
+   }
+   return pickWeighted(candidates);
+};
+
+/**
+ * Set the function sending requests to peers, which replaces the REST
+ * transport used by default.
+ * @param {function} transport Called as transport(peer, requestType, request, cb)
+ * where cb is called with an error, or null and the response of the peer.  An
+ * error with a "rejected" property set is blamed on the request, not on the peer.
+ */
+ChainClient.prototype.setTransport = function(transport) {
+   this.transport = transport;
+};
+
+/**
+ * Send a request to the peer selected for its type, see selectPeer.  The
+ * outcome is recorded to the health of the peer, unless the peer rejected the
+ * request itself.
+ * @param {string} requestType Either "query" or "invoke".
+ * @param {Object} request The ChaincodeInvocationSpec to send.
+ * @param {function} cb Called with an error, or null and the response, and the peer the request was sent to.
+ */
+ChainClient.prototype.sendRequest = function(requestType, request, cb) {
+   var peer = this.selectPeer(requestType);
+   if (!peer) {
+      return cb(new Error("no peer to send the " + requestType + " to"));
+   }
+   this.transport(peer, requestType, request, function(err, response) {
+      if (!err || !err.rejected) {
+         peer.recordResult(!err);
+      }
+      cb(err, response, peer);
+   });
+};
+
+/**
+ * Query a chaincode, on a follower if any is known.
+ * @param {Object} request The ChaincodeInvocationSpec of the query.
+ * @param {function} cb Called with an error, or null and the result of the query.
+ */
+ChainClient.prototype.query = function(request, cb) {
+   this.sendRequest(QUERY, request, cb);
+};
+
+/**
+ * Invoke a chaincode on a validator.
+ * @param {Object} request The ChaincodeInvocationSpec of the invocation.
+ * @param {function} cb Called with an error, or null and the UUID of the transaction.
+ */
+ChainClient.prototype.invoke = function(request, cb) {
+   this.sendRequest(INVOKE, request, cb);
+};
+
+/**
+ * Send a request to the REST endpoint of a peer, its "restUrl", with POST /devops/query
+ * or /devops/invoke.  Requests the peer answers with a 4xx status are rejected.
+ */
+function restTransport(peer, requestType, request, cb) {
+   var restUrl = peer.getRestUrl();
+   if (!restUrl) {
+      return cb(new Error("peer " + peer.getUrl() + " has no REST endpoint"));
+   }
+   var options = urlParser.parse(urlParser.resolve(restUrl, "/devops/" + requestType));
+   options.method = "POST";
+   options.headers = { "Content-Type": "application/json" };
+   var client = options.protocol === "https:" ? https : http;
+   var req = client.request(options, function(res) {
+      var body = "";
+      res.setEncoding("utf8");
+      res.on("data", function(chunk) { body += chunk; });
+      res.on("end", function() {
+         var msg;
+         try {
+            msg = JSON.parse(body);
+         } catch (err) {
+            return cb(err);
+         }
+         if (res.statusCode !== 200) {
+            var err = new Error(requestType + " failed with status " + res.statusCode + ": " + (msg.Error || body));
+            err.rejected = res.statusCode >= 400 && res.statusCode < 500;
+            return cb(err);
+         }
+         cb(null, msg.OK);
+      });
+   });
+   req.on("error", cb);
+   req.end(JSON.stringify(request));
+}
+
+/**
+ * Pick a peer at random with a probability proportional to its weight.
+ */
+function pickWeighted(peers) {
+   if (peers.length === 0) {
+      return null;
+   }
+   var total = 0;
+   for (var i = 0; i < peers.length; i++) {
+      total += peers[i].getWeight();
+   }
+   var r = Math.random() * total;
+   for (var i = 0; i < peers.length; i++) {
+      r -= peers[i].getWeight();
+      if (r < 0) {
+         return peers[i];
+      }
+   }
+   return peers[peers.length - 1];
+}
+
 /**
  * Constructor for a peer given the endpoint config for the peer.
- * @param {Object} config The endpoint config of the form: { url: "grpcs://host:port", tls: { .... } }
+ * @param {Object} config The endpoint config of the form: { url: "grpcs://host:port", restUrl: "http://host:port", tls: { .... } }
  * TBD: The format of 'config.tls' depends upon the format expected by node's grpc module.
  * @param {Chain} The chain of which this peer is a member.
  * @returns {Peer} The new peer.
@@ -125,6 +358,10 @@ ChainClient.prototype.setMemberServices  = function(memberServices) {
 function Peer(endpoint,chain) {
    this.endpoint = endpoint;
    this.chain = chain;
+   // Until discovered otherwise a peer is assumed to be a validator
+   this.validator = true;
+   this.follower = false;
+   this.health = 1;
 }
 
 /**
@@ -140,7 +377,55 @@ Peer.prototype.getChain = function() {
  * @returns {string} Get the URL associated with the peer.
  */
 Peer.prototype.getUrl = function() {
+   return this.endpoint.url;
+};
 
+/**
+ * Get the URL of the REST endpoint of the peer.
+ * @returns {string} The REST URL of the peer, or undefined if it is not known.
+ */
+Peer.prototype.getRestUrl = function() {
+   return this.endpoint.restUrl;
+};
+
+/**
+ * Set the role of the peer as learned from discovery.
+ * @param {boolean} validator True if the peer is a validator.
+ * @param {boolean} follower True if the peer is a read-only follower.
+ */
+Peer.prototype.setRole = function(validator, follower) {
+   this.validator = validator;
+   this.follower = follower;
+};
+
+/**
+ * @returns {boolean} True if the peer is a validator, which accepts invokes.
+ */
+Peer.prototype.isValidator = function() {
+   return this.validator;
+};
+
+/**
+ * @returns {boolean} True if the peer is a read-only follower, which serves queries only.
+ */
+Peer.prototype.isFollower = function() {
+   return this.follower;
+};
+
+/**
+ * Record the outcome of a request sent to the peer, which updates its health.
+ * @param {boolean} ok True if the request succeeded.
+ */
+Peer.prototype.recordResult = function(ok) {
+   this.health = this.health * HEALTH_DECAY + (ok ? 1 - HEALTH_DECAY : 0);
+};
+
+/**
+ * Get the weight of the peer when load balancing requests, based on its health.
+ * @returns {number} The weight of the peer.
+ */
+Peer.prototype.getWeight = function() {
+   return Math.max(this.health, MIN_WEIGHT);
 };
 
 /**
@@ -168,8 +453,10 @@ MemberServices.prototype.register = function(registrationRequest,cb) {
    
 };
 
-MemberServices.prototype.enroll = function(,cb) {
+MemberServices.prototype.enroll = function(enrollmentRequest,cb) {
    
 };
 
-export.NewChainClientMgr = NewChainClientMgr;
+exports.NewChainClientMgr = NewChainClientMgr;
+exports.QUERY = QUERY;
+exports.INVOKE = INVOKE;
@@ -0,0 +1,134 @@
+/**
+ * Tests of the peer selection of the chain client, run with:
+ *    node client_sdk_test.js
+ */
+var assert = require("assert");
+var http = require("http");
+var sdk = require("./client_sdk");
+
+function newChain() {
+   return sdk.NewChainClientMgr().getChain("test", true);
+}
+
+function testInvokesGoToValidators() {
+   var chain = newChain();
+   var validator = chain.addPeer({ url: "grpc://vp0:30303" });
+   var follower = chain.addPeer({ url: "grpc://follower:30303" });
+   follower.setRole(false, true);
+   for (var i = 0; i < 20; i++) {
+      assert.strictEqual(chain.selectPeer(sdk.INVOKE), validator);
+      assert.strictEqual(chain.selectPeer(sdk.QUERY), follower);
+   }
+
+   // Without followers queries go to validators
+   follower.setRole(true, false);
+   var selected = chain.selectPeer(sdk.QUERY);
+   assert.ok(selected === validator || selected === follower);
+
+   // An override bypasses the load balancing
+   chain.setPeerOverride(sdk.INVOKE, follower);
+   assert.strictEqual(chain.selectPeer(sdk.INVOKE), follower);
+   chain.setPeerOverride(sdk.INVOKE, null);
+}
+
+function testRecordResultWeighsPeers() {
+   var chain = newChain();
+   var healthy = chain.addPeer({ url: "grpc://vp0:30303" });
+   var failing = chain.addPeer({ url: "grpc://vp1:30303" });
+   for (var i = 0; i < 50; i++) {
+      failing.recordResult(false);
+   }
+   assert.ok(failing.getWeight() > 0, "a failing peer is still retried now and then");
+   assert.ok(failing.getWeight() < healthy.getWeight() / 50);
+   var picks = 0;
+   for (var i = 0; i < 1000; i++) {
+      if (chain.selectPeer(sdk.INVOKE) === failing) {
+         picks++;
+      }
+   }
+   assert.ok(picks < 100, "failing peer selected " + picks + " times out of 1000");
+
+   // The health recovers with successful requests
+   for (var i = 0; i < 50; i++) {
+      failing.recordResult(true);
+   }
+   assert.ok(failing.getWeight() > 0.99);
+}
+
+function testSendRequestRecordsResults(done) {
+   var chain = newChain();
+   var peer = chain.addPeer({ url: "grpc://vp0:30303" });
+   var outcomes = [new Error("unavailable"), null];
+   var rejected = new Error("bad request");
+   rejected.rejected = true;
+   outcomes.push(rejected);
+   chain.setTransport(function(p, requestType, request, cb) {
+      assert.strictEqual(p, peer);
+      assert.strictEqual(requestType, sdk.INVOKE);
+      cb(outcomes.shift(), "uuid");
+   });
+   chain.invoke({}, function(err) {
+      assert.ok(err);
+      var weight = peer.getWeight();
+      assert.ok(weight < 1);
+      chain.invoke({}, function(err, uuid, p) {
+         assert.ifError(err);
+         assert.strictEqual(uuid, "uuid");
+         assert.strictEqual(p, peer);
+         var recovered = peer.getWeight();
+         assert.ok(recovered > weight);
+         // A request the peer rejects does not count against it
+         chain.invoke({}, function(err) {
+            assert.ok(err);
+            assert.strictEqual(peer.getWeight(), recovered);
+            done();
+         });
+      });
+   });
+}
+
+function testRestTransport(done) {
+   var server = http.createServer(function(req, res) {
+      var body = "";
+      req.on("data", function(chunk) { body += chunk; });
+      req.on("end", function() {
+         var spec = JSON.parse(body);
+         if (req.url === "/devops/query" && spec.chaincodeSpec) {
+            res.writeHead(200);
+            res.end(JSON.stringify({ OK: "100" }));
+         } else {
+            res.writeHead(400);
+            res.end(JSON.stringify({ Error: "Payload must contain a ChaincodeSpec." }));
+         }
+      });
+   });
+   server.listen(0, "127.0.0.1", function() {
+      var chain = newChain();
+      var restUrl = "http://127.0.0.1:" + server.address().port;
+      var follower = chain.addPeer({ url: "grpc://follower:30303", restUrl: restUrl });
+      follower.setRole(false, true);
+      chain.addPeer({ url: "grpc://vp0:30303" });
+      chain.query({ chaincodeSpec: { ctorMsg: { function: "query", args: ["a"] } } }, function(err, result, peer) {
+         assert.ifError(err);
+         assert.strictEqual(result, "100");
+         assert.strictEqual(peer, follower);
+         chain.query({}, function(err) {
+            assert.ok(err && err.rejected);
+            // The validator has no REST endpoint
+            chain.invoke({}, function(err) {
+               assert.ok(err && !err.rejected);
+               server.close();
+               done();
+            });
+         });
+      });
+   });
+}
+
+testInvokesGoToValidators();
+testRecordResultWeighsPeers();
+testSendRequestRecordsResults(function() {
+   testRestTransport(function() {
+      console.log("PASS");
+   });
+});
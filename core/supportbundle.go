@@ -0,0 +1,234 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"golang.org/x/net/context"
+
+	"github.com/hyperledger/fabric/core/chaincode"
+	"github.com/hyperledger/fabric/core/interceptor"
+	"github.com/hyperledger/fabric/core/opevents"
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+// Configuration keys containing one of these words have their value redacted
+var redactedKeyWords = []string{"secret", "password", "passwd", "token", "privatekey"}
+
+const redactedValue = "<redacted>"
+
+type bundleFile struct {
+	name string
+	data []byte
+}
+
+// GetPeerSupportBundle collects what operators attach to issue reports into one
+// gzipped tar archive: the configuration with secrets redacted, versions, the
+// most recent log lines, a runtime metrics snapshot, a goroutine dump, the peer
+// table, the transaction queues of organizations and the chaincode registry.
+// A section that cannot be collected holds the error instead so that the rest
+// of the bundle is still returned.
+func (s *ServerAdmin) GetPeerSupportBundle(ctx context.Context, req *pb.PeerSupportBundleRequest) (*pb.PeerSupportBundle, error) {
+	now := time.Now().UTC()
+	files := []bundleFile{
+		jsonBundleFile("config.json", redactSettings(viper.AllSettings())),
+		jsonBundleFile("versions.json", versionInfo()),
+		{name: "logs.txt", data: []byte(strings.Join(RecentLogs(int(req.LogLines)), "\n"))},
+		jsonBundleFile("metrics.json", metricsSnapshot()),
+		goroutineDump(),
+		s.peerTable(),
+		s.fairQueueStats(),
+		chaincodeRegistry(),
+		chaincodeStateCache(),
+		chaincodeMetrics(),
+	}
+
+	archive, err := writeBundleArchive(files, now)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating support bundle archive: %s", err)
+	}
+	name := fmt.Sprintf("peer-support-%s-%s.tar.gz", viper.GetString("peer.id"), now.Format("20060102T150405Z"))
+	log.Debug("returning support bundle %s of %d bytes", name, len(archive))
+	return &pb.PeerSupportBundle{Name: name, Archive: archive}, nil
+}
+
+func jsonBundleFile(name string, v interface{}) bundleFile {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return bundleFile{name: name, data: []byte(fmt.Sprintf("Error marshalling %s: %s", name, err))}
+	}
+	return bundleFile{name: name, data: data}
+}
+
+// redactSettings returns a copy of the settings with the values of secret keys replaced
+func redactSettings(settings map[string]interface{}) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(settings))
+	for k, v := range settings {
+		switch {
+		case isSecretKey(k):
+			redacted[k] = redactedValue
+		case isSettingsMap(v):
+			redacted[k] = redactSettings(toSettingsMap(v))
+		default:
+			redacted[k] = v
+		}
+	}
+	return redacted
+}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, word := range redactedKeyWords {
+		if strings.Contains(key, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSettingsMap(v interface{}) bool {
+	switch v.(type) {
+	case map[string]interface{}, map[interface{}]interface{}:
+		return true
+	}
+	return false
+}
+
+// toSettingsMap converts the nested maps produced by the YAML parser to string keyed maps
+func toSettingsMap(v interface{}) map[string]interface{} {
+	if m, ok := v.(map[string]interface{}); ok {
+		return m
+	}
+	m := make(map[string]interface{})
+	for k, val := range v.(map[interface{}]interface{}) {
+		m[fmt.Sprint(k)] = val
+	}
+	return m
+}
+
+func versionInfo() map[string]interface{} {
+	return map[string]interface{}{
+		"go":         runtime.Version(),
+		"os":         runtime.GOOS,
+		"arch":       runtime.GOARCH,
+		"cpus":       runtime.NumCPU(),
+		"gomaxprocs": runtime.GOMAXPROCS(0),
+	}
+}
+
+func metricsSnapshot() map[string]interface{} {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return map[string]interface{}{
+		"goroutines":      runtime.NumGoroutine(),
+		"cgoCalls":        runtime.NumCgoCall(),
+		"heapAlloc":       mem.HeapAlloc,
+		"heapSys":         mem.HeapSys,
+		"heapObjects":     mem.HeapObjects,
+		"totalAlloc":      mem.TotalAlloc,
+		"sys":             mem.Sys,
+		"numGC":           mem.NumGC,
+		"pauseTotalNs":    mem.PauseTotalNs,
+		"lastGCUnixNano":  mem.LastGC,
+		"lifecycleEvents": opevents.Counts(),
+		"streams":         interceptor.Stats(),
+	}
+}
+
+func goroutineDump() bundleFile {
+	var buf bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&buf, 2); err != nil {
+		buf.WriteString(fmt.Sprintf("Error collecting goroutine dump: %s", err))
+	}
+	return bundleFile{name: "goroutines.txt", data: buf.Bytes()}
+}
+
+func (s *ServerAdmin) peerTable() bundleFile {
+	if s.coord == nil {
+		return bundleFile{name: "peers.json", data: []byte("Peer not available to the Admin service")}
+	}
+	peers, err := s.coord.GetPeers()
+	if err != nil {
+		return bundleFile{name: "peers.json", data: []byte(fmt.Sprintf("Error getting peers: %s", err))}
+	}
+	return jsonBundleFile("peers.json", peers)
+}
+
+func (s *ServerAdmin) fairQueueStats() bundleFile {
+	if s.coord == nil {
+		return bundleFile{name: "fairqueue.json", data: []byte("Peer not available to the Admin service")}
+	}
+	return jsonBundleFile("fairqueue.json", s.coord.FairQueueStats())
+}
+
+func chaincodeStateCache() bundleFile {
+	chaincodeSupport := chaincode.GetChain(chaincode.DefaultChain)
+	if chaincodeSupport == nil {
+		return bundleFile{name: "statecache.json", data: []byte("chaincode support not initialized")}
+	}
+	return jsonBundleFile("statecache.json", chaincodeSupport.StateCacheStats())
+}
+
+func chaincodeMetrics() bundleFile {
+	chaincodeSupport := chaincode.GetChain(chaincode.DefaultChain)
+	if chaincodeSupport == nil {
+		return bundleFile{name: "chaincodemetrics.json", data: []byte("chaincode support not initialized")}
+	}
+	return jsonBundleFile("chaincodemetrics.json", chaincodeSupport.HandlerMetrics())
+}
+
+func chaincodeRegistry() bundleFile {
+	chaincodeSupport := chaincode.GetChain(chaincode.DefaultChain)
+	if chaincodeSupport == nil {
+		return bundleFile{name: "chaincodes.json", data: []byte("chaincode support not initialized")}
+	}
+	return jsonBundleFile("chaincodes.json", chaincodeSupport.RegisteredChaincodes())
+}
+
+func writeBundleArchive(files []bundleFile, modTime time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.data)), ModTime: modTime}); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
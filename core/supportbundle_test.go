@@ -0,0 +1,92 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestRedactSettings(t *testing.T) {
+	settings := map[string]interface{}{
+		"peer": map[interface{}]interface{}{
+			"id": "vp0",
+			"pki": map[string]interface{}{
+				"enrollSecret": "s3cr3t",
+			},
+		},
+		"adminPassword": "hunter2",
+	}
+	redacted := redactSettings(settings)
+	if redacted["adminPassword"] != redactedValue {
+		t.Fatalf("Expected adminPassword to be redacted, got %v", redacted["adminPassword"])
+	}
+	peer := redacted["peer"].(map[string]interface{})
+	if peer["id"] != "vp0" {
+		t.Fatalf("Expected peer.id to be kept, got %v", peer["id"])
+	}
+	if secret := peer["pki"].(map[string]interface{})["enrollSecret"]; secret != redactedValue {
+		t.Fatalf("Expected nested enrollSecret to be redacted, got %v", secret)
+	}
+}
+
+func TestWriteBundleArchive(t *testing.T) {
+	files := []bundleFile{{name: "a.txt", data: []byte("first")}, {name: "b.txt", data: []byte("second")}}
+	archive, err := writeBundleArchive(files, time.Now())
+	if err != nil {
+		t.Fatalf("Error writing archive: %s", err)
+	}
+	gr, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatalf("Error reading archive: %s", err)
+	}
+	tr := tar.NewReader(gr)
+	for _, f := range files {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("Error reading %s from archive: %s", f.name, err)
+		}
+		data, _ := ioutil.ReadAll(tr)
+		if hdr.Name != f.name || string(data) != string(f.data) {
+			t.Fatalf("Expected %s with %q, got %s with %q", f.name, f.data, hdr.Name, data)
+		}
+	}
+}
+
+func TestRecentLogTail(t *testing.T) {
+	r := &recentLog{lines: make([]string, 3)}
+	for _, line := range []string{"1", "2", "3", "4"} {
+		r.lines[r.next] = line
+		r.next = (r.next + 1) % len(r.lines)
+		if r.next == 0 {
+			r.full = true
+		}
+	}
+	if lines := r.tail(0); len(lines) != 3 || lines[0] != "2" || lines[2] != "4" {
+		t.Fatalf("Expected [2 3 4], got %v", lines)
+	}
+	if lines := r.tail(1); len(lines) != 1 || lines[0] != "4" {
+		t.Fatalf("Expected [4], got %v", lines)
+	}
+}
@@ -0,0 +1,161 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Exporter posts finished spans in batches to a Zipkin compatible collector,
+// e.g. http://zipkin:9411/api/v2/spans
+type Exporter struct {
+	url           string
+	service       string
+	client        *http.Client
+	spans         chan *Span
+	batchSize     int
+	flushInterval time.Duration
+
+	// closed is set by Close, under the lock, so that export never sends on
+	// the closed channel
+	sync.Mutex
+	closed bool
+}
+
+// NewExporter returns an exporter posting to url the spans of service. Up to
+// bufferSize spans wait to be posted, further spans are dropped. Spans are
+// posted by batches of batchSize, or every flushInterval, each request bounded
+// by timeout.
+func NewExporter(url, service string, bufferSize, batchSize int, flushInterval, timeout time.Duration) *Exporter {
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	return &Exporter{
+		url:           url,
+		service:       service,
+		client:        &http.Client{Timeout: timeout},
+		spans:         make(chan *Span, bufferSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+	}
+}
+
+// Start exports the spans of service to the collector configured under
+// peer.tracing in core.yaml. It does nothing unless peer.tracing.collector is
+// set.
+func Start(service string) *Exporter {
+	url := viper.GetString("peer.tracing.collector")
+	if url == "" {
+		return nil
+	}
+	e := NewExporter(url, service,
+		viper.GetInt("peer.tracing.bufferSize"),
+		viper.GetInt("peer.tracing.batchSize"),
+		viper.GetDuration("peer.tracing.flushInterval"),
+		viper.GetDuration("peer.tracing.timeout"))
+	go e.Run()
+	SetExporter(e)
+	logger.Info("Exporting the spans of %s to %s", service, url)
+	return e
+}
+
+func (e *Exporter) export(s *Span) {
+	e.Lock()
+	defer e.Unlock()
+	if e.closed {
+		logger.Debug("Dropping span %s of trace %s, the exporter is closed", s.Name, s.TraceID)
+		return
+	}
+	select {
+	case e.spans <- s:
+	default:
+		logger.Debug("Dropping span %s of trace %s, the collector is too slow", s.Name, s.TraceID)
+	}
+}
+
+// Run posts the spans queued until Close is called. Failed posts are logged
+// and not retried, so a slow or down collector only loses spans.
+func (e *Exporter) Run() {
+	var ticks <-chan time.Time
+	if e.flushInterval > 0 {
+		ticker := time.NewTicker(e.flushInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	var batch []*Span
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.post(batch); err != nil {
+			logger.Warning("Error posting %d spans to %s: %s", len(batch), e.url, err)
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case s, ok := <-e.spans:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) >= e.batchSize {
+				flush()
+			}
+		case <-ticks:
+			flush()
+		}
+	}
+}
+
+// Close makes Run post the spans still queued and return. Spans finished
+// with the exporter afterwards are dropped.
+func (e *Exporter) Close() {
+	e.Lock()
+	defer e.Unlock()
+	if !e.closed {
+		e.closed = true
+		close(e.spans)
+	}
+}
+
+func (e *Exporter) post(batch []*Span) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
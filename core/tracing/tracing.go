@@ -0,0 +1,189 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package tracing records spans of the execution of transactions, from the
+// peer that receives them through the chaincodes they invoke down to their
+// state operations, and exports them to a Zipkin compatible collector. The
+// context of a span travels with the traceContext field of Message and
+// ChaincodeMessage.
+package tracing
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+	"golang.org/x/net/context"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+var logger = logging.MustGetLogger("tracing")
+
+// Endpoint names the service that recorded a span
+type Endpoint struct {
+	ServiceName string `json:"serviceName"`
+}
+
+// Span is a timed step of a transaction, encoded as a Zipkin v2 span.
+// Timestamp and Duration are in microseconds.
+type Span struct {
+	TraceID       string            `json:"traceId"`
+	ID            string            `json:"id"`
+	ParentID      string            `json:"parentId,omitempty"`
+	Name          string            `json:"name"`
+	Timestamp     int64             `json:"timestamp"`
+	Duration      int64             `json:"duration"`
+	LocalEndpoint *Endpoint         `json:"localEndpoint,omitempty"`
+	Tags          map[string]string `json:"tags,omitempty"`
+
+	sync.Mutex
+	start    time.Time
+	exporter *Exporter
+	finished bool
+}
+
+var (
+	exporterLock sync.RWMutex
+	exporter     *Exporter
+)
+
+// SetExporter makes the spans started from now on exported by e, nil
+// disables tracing
+func SetExporter(e *Exporter) {
+	exporterLock.Lock()
+	defer exporterLock.Unlock()
+	exporter = e
+}
+
+func getExporter() *Exporter {
+	exporterLock.RLock()
+	defer exporterLock.RUnlock()
+	return exporter
+}
+
+// StartSpan starts a span named name, child of parent. A span without parent
+// starts a trace whose ID is derived from uuid, so that the spans recorded
+// for the same transaction by different peers end up in the same trace even
+// where no context could be passed along, e.g. through consensus. StartSpan
+// returns nil when tracing is disabled, the methods of a nil span do nothing.
+func StartSpan(name string, parent *pb.TraceContext, uuid string) *Span {
+	e := getExporter()
+	if e == nil {
+		return nil
+	}
+	now := time.Now()
+	s := &Span{
+		ID:            newSpanID(),
+		Name:          name,
+		Timestamp:     now.UnixNano() / int64(time.Microsecond),
+		LocalEndpoint: &Endpoint{ServiceName: e.service},
+		Tags:          make(map[string]string),
+		start:         now,
+		exporter:      e,
+	}
+	if parent != nil && parent.TraceID != "" {
+		s.TraceID = parent.TraceID
+		s.ParentID = parent.SpanID
+	} else {
+		s.TraceID = traceIDForUUID(uuid)
+	}
+	if uuid != "" {
+		s.Tags["uuid"] = uuid
+	}
+	return s
+}
+
+// Context returns the context to propagate to the children of the span
+func (s *Span) Context() *pb.TraceContext {
+	if s == nil {
+		return nil
+	}
+	return &pb.TraceContext{TraceID: s.TraceID, SpanID: s.ID}
+}
+
+// SetTag annotates the span with key and value, until it is finished
+func (s *Span) SetTag(key, value string) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	if !s.finished {
+		s.Tags[key] = value
+	}
+}
+
+// Finish ends the span and queues it for export, err is the reason the step
+// failed, if it did. Only the first call has an effect.
+func (s *Span) Finish(err error) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	if s.finished {
+		s.Unlock()
+		return
+	}
+	s.finished = true
+	s.Duration = int64(time.Since(s.start) / time.Microsecond)
+	if err != nil {
+		s.Tags["error"] = err.Error()
+	}
+	s.Unlock()
+	s.exporter.export(s)
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying span, the parent of the
+// spans started on behalf of ctx
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	if span == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the span carried by ctx, nil if none
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// traceIDForUUID derives a 128 bit trace ID from the uuid of a transaction
+func traceIDForUUID(uuid string) string {
+	if uuid == "" {
+		return newSpanID() + newSpanID()
+	}
+	sum := sha256.Sum256([]byte(uuid))
+	return hex.EncodeToString(sum[:16])
+}
+
+func newSpanID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,126 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+func TestStartSpanDisabled(t *testing.T) {
+	SetExporter(nil)
+	span := StartSpan("execute", nil, "uuid")
+	if span != nil {
+		t.Fatal("Expected no span while tracing is disabled")
+	}
+	// the methods of a nil span do nothing
+	span.SetTag("key", "value")
+	span.Finish(nil)
+	if span.Context() != nil {
+		t.Fatal("Expected a nil span to have no context")
+	}
+}
+
+func TestStartSpanParent(t *testing.T) {
+	e := NewExporter("", "peer0", 10, 1, 0, time.Second)
+	SetExporter(e)
+	defer SetExporter(nil)
+
+	root := StartSpan("send", nil, "uuid")
+	if root.TraceID != StartSpan("receive", nil, "uuid").TraceID {
+		t.Fatal("Expected the spans of a transaction to share a trace without parent")
+	}
+	if root.TraceID == StartSpan("send", nil, "other").TraceID {
+		t.Fatal("Expected the spans of different transactions to be in different traces")
+	}
+
+	child := StartSpan("execute", root.Context(), "uuid")
+	if child.TraceID != root.TraceID || child.ParentID != root.ID || child.ID == root.ID {
+		t.Fatalf("Expected %+v to be a child of %+v", child.Context(), root.Context())
+	}
+	if child.LocalEndpoint.ServiceName != "peer0" {
+		t.Fatalf("Expected the span to be recorded by peer0, got %s", child.LocalEndpoint.ServiceName)
+	}
+
+	ctx := ContextWithSpan(context.Background(), child)
+	if SpanFromContext(ctx) != child || SpanFromContext(context.Background()) != nil {
+		t.Fatal("Expected the span to be carried by the context only")
+	}
+
+	child.Finish(fmt.Errorf("failed"))
+	child.Finish(nil)
+	child.SetTag("late", "ignored")
+	if len(e.spans) != 1 {
+		t.Fatalf("Expected a span to be exported once, got %d", len(e.spans))
+	}
+	if exported := <-e.spans; exported.Tags["error"] != "failed" || exported.Tags["late"] != "" {
+		t.Fatalf("Unexpected tags %v", exported.Tags)
+	}
+}
+
+func TestExporterPostsBatches(t *testing.T) {
+	posted := make(chan []*Span, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var batch []*Span
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("Error decoding spans: %s", err)
+		}
+		posted <- batch
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	e := NewExporter(server.URL, "peer0", 10, 2, 0, time.Second)
+	SetExporter(e)
+	defer SetExporter(nil)
+	done := make(chan struct{})
+	go func() {
+		e.Run()
+		close(done)
+	}()
+
+	parent := &pb.TraceContext{TraceID: "0123456789abcdef0123456789abcdef", SpanID: "0123456789abcdef"}
+	for i := 0; i < 3; i++ {
+		StartSpan(fmt.Sprintf("span%d", i), parent, "uuid").Finish(nil)
+	}
+	if batch := <-posted; len(batch) != 2 || batch[0].Name != "span0" || batch[0].ParentID != parent.SpanID {
+		t.Fatalf("Expected a full batch to be posted, got %+v", batch)
+	}
+
+	// the last span is posted once the exporter is closed, a span still in
+	// progress then is dropped
+	late := StartSpan("late", parent, "uuid")
+	SetExporter(nil)
+	e.Close()
+	<-done
+	late.Finish(nil)
+	e.Close()
+	if batch := <-posted; len(batch) != 1 || batch[0].Name != "span2" {
+		t.Fatalf("Expected the queued span to be posted on close, got %+v", batch)
+	}
+}
@@ -20,6 +20,7 @@ under the License.
 package events
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
 	"os"
@@ -27,6 +28,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/hyperledger/fabric/core/opevents"
 	"github.com/hyperledger/fabric/events/consumer"
 	"github.com/hyperledger/fabric/events/producer"
 	ehpb "github.com/hyperledger/fabric/protos"
@@ -40,6 +42,7 @@ type Adapter struct {
 	sync.RWMutex
 	notfy chan struct{}
 	count int
+	last  *ehpb.Event
 }
 
 var peerAddress string
@@ -47,7 +50,7 @@ var adapter *Adapter
 var obcEHClient *consumer.EventsClient
 
 func (a *Adapter) GetInterestedEvents() ([]*ehpb.Interest, error) {
-	return []*ehpb.Interest{&ehpb.Interest{"block", ehpb.Interest_PROTOBUF}}, nil
+	return []*ehpb.Interest{&ehpb.Interest{EventType: "block", ResponseType: ehpb.Interest_PROTOBUF}, &ehpb.Interest{EventType: "lifecycle", ResponseType: ehpb.Interest_JSON}}, nil
 	//return [] *ehpb.Interest{ &ehpb.InterestedEvent{"block", ehpb.Interest_JSON }}, nil
 }
 
@@ -65,6 +68,7 @@ func (a *Adapter) Recv(msg *ehpb.Event) (bool, error) {
 		return false, fmt.Errorf("unexpected type %T", x)
 	}
 	a.Lock()
+	a.last = msg
 	a.count--
 	if a.count <= 0 {
 		a.notfy <- struct{}{}
@@ -108,6 +112,74 @@ func TestReceiveMessage(t *testing.T) {
 	}
 }
 
+func TestEventSchemaValidation(t *testing.T) {
+	if err := producer.RegisterEventSchema("asset.transfer", producer.NewJSONSchema("from", "to")); err != nil {
+		t.Fatalf("Error registering schema: %s", err)
+	}
+	if err := producer.RegisterEventSchema("asset.transfer", producer.NewJSONSchema()); err == nil {
+		t.Fatalf("Expected error registering schema twice")
+	}
+	if err := producer.RegisterEventSchema(producer.BlockType, producer.NewJSONSchema()); err == nil {
+		t.Fatalf("Expected error registering schema for internal event type")
+	}
+
+	if err := producer.Send(producer.CreateGenericEvent("asset.transfer", []byte(`{"from":"a"}`))); err == nil {
+		t.Fatalf("Expected error sending event with missing field")
+	}
+	if err := producer.Send(producer.CreateGenericEvent("asset.transfer", []byte("not json"))); err == nil {
+		t.Fatalf("Expected error sending event with invalid payload")
+	}
+	if err := producer.Send(producer.CreateGenericEvent("asset.transfer", []byte(`{"from":"a","to":"b"}`))); err != nil {
+		t.Fatalf("Error sending valid event: %s", err)
+	}
+	if err := producer.RegisterChaincodeEventSchema("assetcc", "asset.transfer", producer.NewJSONSchema()); err == nil {
+		t.Fatalf("Expected error registering a schema for an event type of the peer")
+	}
+}
+
+func TestChaincodeEventSchema(t *testing.T) {
+	if err := producer.RegisterChaincodeEventSchema("assetcc", "asset.issue", producer.NewJSONSchema("to")); err != nil {
+		t.Fatalf("Error registering schema: %s", err)
+	}
+	// a restarted chaincode registers its schemas again
+	if err := producer.RegisterChaincodeEventSchema("assetcc", "asset.issue", producer.NewJSONSchema("to", "amount")); err != nil {
+		t.Fatalf("Error registering schema again: %s", err)
+	}
+	if err := producer.Send(producer.CreateGenericEvent("asset.issue", []byte(`{"to":"a"}`))); err == nil {
+		t.Fatalf("Expected the schema registered last to validate the events")
+	}
+	if err := producer.RegisterChaincodeEventSchema("othercc", "asset.issue", producer.NewJSONSchema()); err == nil {
+		t.Fatalf("Expected error registering the event type of another chaincode")
+	}
+}
+
+func TestLifecycleEvents(t *testing.T) {
+	adapter.Lock()
+	adapter.count = 1
+	adapter.Unlock()
+	opevents.Publish(opevents.ChaincodeLaunched, map[string]string{"chaincode": "mycc"})
+
+	select {
+	case <-adapter.notfy:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out on lifecycle event")
+	}
+
+	adapter.Lock()
+	g := adapter.last.GetGeneric()
+	adapter.Unlock()
+	if g == nil || g.EventType != producer.LifecycleType {
+		t.Fatalf("Expected a lifecycle event, got %v", g)
+	}
+	e := &opevents.Event{}
+	if err := json.Unmarshal(g.Payload, e); err != nil {
+		t.Fatalf("Error decoding lifecycle event: %s", err)
+	}
+	if e.Kind != opevents.ChaincodeLaunched || e.Attributes["chaincode"] != "mycc" {
+		t.Fatalf("Unexpected lifecycle event: %v", e)
+	}
+}
+
 func BenchmarkMessages(b *testing.B) {
 	numMessages := 10000
 
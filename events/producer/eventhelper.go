@@ -27,3 +27,8 @@ import (
 func CreateBlockEvent(te *ehpb.Block) *ehpb.Event {
 	return &ehpb.Event{&ehpb.Event_Block{Block: te}}
 }
+
+//CreateGenericEvent creates a generic Event of the given type carrying payload
+func CreateGenericEvent(eventType string, payload []byte) *ehpb.Event {
+	return &ehpb.Event{Event: &ehpb.Event_Generic{Generic: &ehpb.Generic{EventType: eventType, Payload: payload}}}
+}
@@ -25,6 +25,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/hyperledger/fabric/core/opevents"
 	pb "github.com/hyperledger/fabric/protos"
 )
 
@@ -83,10 +84,21 @@ func (ep *eventProcessor) start() {
 		hl.Lock()
 		ep.Unlock()
 
+		schema := gSchemaRegistry.get(eType)
 		for h := range hl.handlers {
 			if rType := h.responseType(eType); rType != pb.Interest_DONTSEND {
-				//if Message is already a generic message, producer must have already converted
-				if eType != "generic" {
+				if schema != nil {
+					//generic event with a registered schema, decode the payload for JSON consumers
+					if rType == pb.Interest_JSON {
+						if b, err := schema.ToJSON(e.GetGeneric().Payload); err != nil {
+							producerLogger.Error(fmt.Sprintf("could not decode payload of event type %s: %s", eType, err))
+						} else {
+							h.SendMessage(&pb.Event{Event: &pb.Event_Generic{Generic: &pb.Generic{EventType: eType, Payload: b}}})
+						}
+						continue
+					}
+				} else if eType != GenericType && eType != LifecycleType {
+					//if Message is already a generic message, producer must have already converted
 					switch rType {
 					case pb.Interest_JSON:
 						if b, err := json.Marshal(e.Event); err != nil {
@@ -118,6 +130,23 @@ func initializeEvents(bufferSize uint, tout int) {
 
 	//start the event processor
 	go gEventProcessor.start()
+
+	go forwardLifecycleEvents(opevents.Subscribe(int(bufferSize)))
+}
+
+//forwardLifecycleEvents sends the peer's lifecycle events to the consumers of
+//LifecycleType as generic events with the JSON encoded opevents.Event as payload
+func forwardLifecycleEvents(sub *opevents.Subscription) {
+	for e := range sub.C {
+		b, err := json.Marshal(e)
+		if err != nil {
+			producerLogger.Error(fmt.Sprintf("could not marshall lifecycle event %v: %s", e, err))
+			continue
+		}
+		if err = Send(CreateGenericEvent(LifecycleType, b)); err != nil {
+			producerLogger.Warning(fmt.Sprintf("could not send lifecycle event %s: %s", e.Kind, err))
+		}
+	}
 }
 
 //AddEventType supported event
@@ -185,6 +214,13 @@ func Send(e *pb.Event) error {
 		return fmt.Errorf("event not set")
 	}
 
+	if g := e.GetGeneric(); g != nil {
+		if err := validateEvent(g); err != nil {
+			producerLogger.Error(err.Error())
+			return err
+		}
+	}
+
 	if gEventProcessor == nil {
 		return nil
 	}
@@ -30,17 +30,26 @@ import (
 
 //----Event Types -----
 const (
-	RegisterType = "register"
-	BlockType    = "block"
+	RegisterType  = "register"
+	BlockType     = "block"
+	GenericType   = "generic"
+	LifecycleType = "lifecycle"
 )
 
 func getMessageType(e *pb.Event) string {
-	switch e.Event.(type) {
+	switch x := e.Event.(type) {
 	case *pb.Event_Register:
 		return "register"
 	case *pb.Event_Block:
 		return "block"
 	case *pb.Event_Generic:
+		if x.Generic.EventType == LifecycleType {
+			return LifecycleType
+		}
+		//chaincode events with a registered schema are dispatched on their own type
+		if gSchemaRegistry.get(x.Generic.EventType) != nil {
+			return x.Generic.EventType
+		}
 		return "generic"
 	default:
 		return ""
@@ -51,4 +60,6 @@ func getMessageType(e *pb.Event) string {
 func addInternalEventTypes() {
 	AddEventType(BlockType)
 	AddEventType(RegisterType)
+	AddEventType(LifecycleType)
+	addSchemaEventTypes()
 }
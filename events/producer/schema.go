@@ -0,0 +1,191 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package producer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+
+	pb "github.com/hyperledger/fabric/protos"
+)
+
+//EventSchema describes the payload of a generic event type emitted by a chaincode.
+//Validate is called by Send before the event is queued, ToJSON when a consumer
+//registered interest in the event type with Interest_JSON
+type EventSchema interface {
+	Validate(payload []byte) error
+	ToJSON(payload []byte) ([]byte, error)
+}
+
+//protoSchema decodes payloads as a protobuf message
+type protoSchema struct {
+	newMsg func() proto.Message
+}
+
+//NewProtoSchema returns a schema for payloads that are marshalled protobuf
+//messages. newMsg must return a fresh, empty message on every call
+func NewProtoSchema(newMsg func() proto.Message) EventSchema {
+	return &protoSchema{newMsg: newMsg}
+}
+
+func (s *protoSchema) decode(payload []byte) (proto.Message, error) {
+	msg := s.newMsg()
+	if err := proto.Unmarshal(payload, msg); err != nil {
+		return nil, fmt.Errorf("payload is not a valid %T: %s", msg, err)
+	}
+	return msg, nil
+}
+
+func (s *protoSchema) Validate(payload []byte) error {
+	_, err := s.decode(payload)
+	return err
+}
+
+func (s *protoSchema) ToJSON(payload []byte) ([]byte, error) {
+	msg, err := s.decode(payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(msg)
+}
+
+//jsonSchema accepts JSON objects carrying a set of required fields
+type jsonSchema struct {
+	required []string
+}
+
+//NewJSONSchema returns a schema for payloads that are JSON objects containing
+//at least the given top level fields
+func NewJSONSchema(required ...string) EventSchema {
+	return &jsonSchema{required: required}
+}
+
+func (s *jsonSchema) Validate(payload []byte) error {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &obj); err != nil {
+		return fmt.Errorf("payload is not a JSON object: %s", err)
+	}
+	for _, f := range s.required {
+		if _, ok := obj[f]; !ok {
+			return fmt.Errorf("payload is missing required field %s", f)
+		}
+	}
+	return nil
+}
+
+func (s *jsonSchema) ToJSON(payload []byte) ([]byte, error) {
+	if err := s.Validate(payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+//registeredSchema is the schema of an event type and the chaincode that
+//registered it, empty if the peer did
+type registeredSchema struct {
+	schema EventSchema
+	owner  string
+}
+
+//schemaRegistry maps generic event types to the schema of their payload
+type schemaRegistry struct {
+	sync.RWMutex
+	schemas map[string]*registeredSchema
+}
+
+var gSchemaRegistry = &schemaRegistry{schemas: make(map[string]*registeredSchema)}
+
+func (r *schemaRegistry) get(eventType string) EventSchema {
+	r.RLock()
+	defer r.RUnlock()
+	if rs := r.schemas[eventType]; rs != nil {
+		return rs.schema
+	}
+	return nil
+}
+
+//RegisterEventSchema registers the payload schema of a chaincode event type and
+//makes the event type available to consumers. Generic events of that type are
+//validated on Send and delivered to the consumers interested in eventType
+func RegisterEventSchema(eventType string, schema EventSchema) error {
+	return registerSchema("", eventType, schema)
+}
+
+//RegisterChaincodeEventSchema registers the schema of eventType on behalf of
+//chaincode, as RegisterEventSchema does. A chaincode registering again, e.g.
+//when it is restarted, replaces the schemas of its event types, the event
+//types of other chaincodes or of the peer are refused.
+func RegisterChaincodeEventSchema(chaincode string, eventType string, schema EventSchema) error {
+	if chaincode == "" {
+		return fmt.Errorf("chaincode not set for event type %s", eventType)
+	}
+	return registerSchema(chaincode, eventType, schema)
+}
+
+func registerSchema(owner string, eventType string, schema EventSchema) error {
+	if schema == nil {
+		return fmt.Errorf("nil schema for event type %s", eventType)
+	}
+	switch eventType {
+	case "", RegisterType, BlockType, GenericType, LifecycleType:
+		return fmt.Errorf("cannot register a schema for event type \"%s\"", eventType)
+	}
+
+	gSchemaRegistry.Lock()
+	defer gSchemaRegistry.Unlock()
+	if rs, ok := gSchemaRegistry.schemas[eventType]; ok {
+		if owner == "" || rs.owner != owner {
+			return fmt.Errorf("schema already registered for event type %s", eventType)
+		}
+		rs.schema = schema
+		return nil
+	}
+	if gEventProcessor != nil {
+		if err := AddEventType(eventType); err != nil {
+			return err
+		}
+	}
+	gSchemaRegistry.schemas[eventType] = &registeredSchema{schema: schema, owner: owner}
+	return nil
+}
+
+//addSchemaEventTypes makes the event types of the schemas registered before
+//the event processor was initialized available to consumers
+func addSchemaEventTypes() {
+	gSchemaRegistry.RLock()
+	defer gSchemaRegistry.RUnlock()
+	for eventType := range gSchemaRegistry.schemas {
+		AddEventType(eventType)
+	}
+}
+
+//validateEvent checks the payload of a generic event against the schema
+//registered for its event type, if any
+func validateEvent(g *pb.Generic) error {
+	if s := gSchemaRegistry.get(g.EventType); s != nil {
+		if err := s.Validate(g.Payload); err != nil {
+			return fmt.Errorf("invalid payload for event type %s: %s", g.EventType, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func checkState(t *testing.T, stub *shim.MockStub, name string, value string) {
+	if string(stub.State[name]) != value {
+		t.Fatalf("Expected %s to hold %s, got %s", name, value, stub.State[name])
+	}
+}
+
+func TestExample02(t *testing.T) {
+	stub := shim.NewMockStub("ex02", new(SimpleChaincode))
+	defer stub.Close()
+
+	if _, err := stub.MockInit("1", "init", []string{"A", "100", "B", "200"}); err != nil {
+		t.Fatalf("Init failed: %s", err)
+	}
+	checkState(t, stub, "A", "100")
+	checkState(t, stub, "B", "200")
+
+	if _, err := stub.MockInvoke("2", "invoke", []string{"A", "B", "10"}); err != nil {
+		t.Fatalf("Invoke failed: %s", err)
+	}
+	checkState(t, stub, "A", "90")
+	checkState(t, stub, "B", "210")
+
+	res, err := stub.MockQuery("3", "query", []string{"B"})
+	if err != nil || string(res) != "210" {
+		t.Fatalf("Expected query of B to return 210, got %s, %v", res, err)
+	}
+
+	if _, err := stub.MockInvoke("4", "delete", []string{"A"}); err != nil {
+		t.Fatalf("Delete failed: %s", err)
+	}
+	if _, ok := stub.State["A"]; ok {
+		t.Fatal("Expected A to be deleted")
+	}
+	if _, err := stub.MockInvoke("5", "invoke", []string{"A", "B", "10"}); err == nil {
+		t.Fatal("Expected an invoke of a deleted entity to fail")
+	}
+	if _, err := stub.MockQuery("6", "query", []string{"A"}); err == nil {
+		t.Fatal("Expected a query of a deleted entity to fail")
+	}
+}
@@ -26,9 +26,11 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
+	"os/signal"
 	"runtime"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"golang.org/x/net/context"
@@ -45,11 +47,17 @@ import (
 
 	"github.com/hyperledger/fabric/consensus/helper"
 	"github.com/hyperledger/fabric/core"
+	"github.com/hyperledger/fabric/core/bridge"
 	"github.com/hyperledger/fabric/core/chaincode"
+	"github.com/hyperledger/fabric/core/config"
 	"github.com/hyperledger/fabric/core/crypto"
+	"github.com/hyperledger/fabric/core/ingest"
+	"github.com/hyperledger/fabric/core/ledger"
 	"github.com/hyperledger/fabric/core/ledger/genesis"
+	"github.com/hyperledger/fabric/core/opevents"
 	"github.com/hyperledger/fabric/core/peer"
 	"github.com/hyperledger/fabric/core/rest"
+	"github.com/hyperledger/fabric/core/tracing"
 	"github.com/hyperledger/fabric/events/producer"
 	pb "github.com/hyperledger/fabric/protos"
 )
@@ -134,6 +142,21 @@ var loginCmd = &cobra.Command{
 // 	},
 // }
 
+var supportBundleOutput string
+var supportBundleLogLines int
+
+var supportBundleCmd = &cobra.Command{
+	Use:   "support-bundle",
+	Short: "Collects a support bundle from the peer.",
+	Long:  `Collects the configuration (secrets redacted), versions, recent logs, metrics, goroutine dump, peer table and chaincode registry of the running peer into one archive to attach to issue reports.`,
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		core.LoggingInit("support-bundle")
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return supportBundle()
+	},
+}
+
 var networkCmd = &cobra.Command{
 	Use:   "network",
 	Short: "Lists all network peers.",
@@ -156,6 +179,7 @@ var (
 	chaincodeUsr      string
 	chaincodeQueryRaw bool
 	chaincodeQueryHex bool
+	chaincodeValidity uint32
 )
 
 var chaincodeCmd = &cobra.Command{
@@ -232,6 +256,11 @@ func main() {
 	viper.BindPFlag("peer_gomaxprocs", flags.Lookup("peer-gomaxprocs"))
 	viper.BindPFlag("peer_discovery_enabled", flags.Lookup("peer-discovery-enabled"))
 
+	flags.String("peer-bootstrap-from", "", "Name of the peer to bootstrap the ledger of this follower from, using its state snapshot")
+	flags.Int("peer-bootstrap-height", 0, "Minimum ledger height to reach when bootstrapping, 0 for the height of the snapshot")
+	viper.BindPFlag("peer.bootstrap.from", flags.Lookup("peer-bootstrap-from"))
+	viper.BindPFlag("peer.bootstrap.height", flags.Lookup("peer-bootstrap-height"))
+
 	// Now set the configuration file.
 	viper.SetConfigName(cmdRoot) // Name of config file (without extension)
 	viper.AddConfigPath("./")    // Path to look for the config file in
@@ -250,6 +279,10 @@ func main() {
 
 	mainCmd.AddCommand(networkCmd)
 
+	supportBundleCmd.Flags().StringVarP(&supportBundleOutput, "output", "o", "", "File to write the archive to, defaults to the name suggested by the peer")
+	supportBundleCmd.Flags().IntVarP(&supportBundleLogLines, "log-lines", "", 0, "Number of most recent log lines to include, 0 for all the peer keeps")
+	mainCmd.AddCommand(supportBundleCmd)
+
 	chaincodeCmd.PersistentFlags().StringVarP(&chaincodeLang, "lang", "l", "golang", fmt.Sprintf("Language the %s is written in", chainFuncName))
 	chaincodeCmd.PersistentFlags().StringVarP(&chaincodeCtorJSON, "ctor", "c", "{}", fmt.Sprintf("Constructor message for the %s in JSON format", chainFuncName))
 	chaincodeCmd.PersistentFlags().StringVarP(&chaincodePath, "path", "p", undefinedParamValue, fmt.Sprintf("Path to %s", chainFuncName))
@@ -258,6 +291,7 @@ func main() {
 
 	chaincodeQueryCmd.Flags().BoolVarP(&chaincodeQueryRaw, "raw", "r", false, "If true, output the query value as raw bytes, otherwise format as a printable string")
 	chaincodeQueryCmd.Flags().BoolVarP(&chaincodeQueryHex, "hex", "x", false, "If true, output the query value byte array in hexadecimal. Incompatible with --raw")
+	chaincodeInvokeCmd.Flags().Uint32VarP(&chaincodeValidity, "validity", "", 0, "Seconds during which the invocation may be executed, it is dropped afterwards. 0 for no limit")
 
 	chaincodeCmd.AddCommand(chaincodeDeployCmd)
 	chaincodeCmd.AddCommand(chaincodeInvokeCmd)
@@ -281,7 +315,7 @@ func createEventHubServer() (net.Listener, *grpc.Server, error) {
 	var lis net.Listener
 	var grpcServer *grpc.Server
 	var err error
-	if viper.GetBool("peer.validator.enabled") {
+	if viper.GetBool("peer.validator.enabled") || peer.IsFollower() {
 		lis, err = net.Listen("tcp", viper.GetString("peer.validator.events.address"))
 		if err != nil {
 			return nil, nil, fmt.Errorf("failed to listen: %v", err)
@@ -322,12 +356,22 @@ func serve(args []string) error {
 	if err != nil {
 		grpclog.Fatalf("Failed to listen: %v", err)
 	}
+	lis = peer.NewKeepaliveListener(lis, viper.GetDuration("peer.keepalive.tcp"))
 
 	ehubLis, ehubGrpcServer, err := createEventHubServer()
 	if err != nil {
 		grpclog.Fatalf("Failed to create ehub server: %v", err)
 	}
 
+	// Post the lifecycle events to the operators' webhook if configured
+	if url := viper.GetString("peer.lifecycleEvents.webhook.url"); url != "" {
+		sink := opevents.NewWebhookSink(url, viper.GetDuration("peer.lifecycleEvents.webhook.timeout"))
+		go sink.Run(opevents.Subscribe(viper.GetInt("peer.lifecycleEvents.webhook.bufferSize")))
+	}
+
+	// Export the spans of the transactions executed to the collector if configured
+	tracing.Start(peerEndpoint.ID.Name)
+
 	if chaincodeDevMode {
 		logger.Info("Running in chaincode development mode")
 		logger.Info("Set consensus to NOOPS and user starts chaincode")
@@ -346,22 +390,34 @@ func serve(args []string) error {
 	logger.Info("Security enabled status: %t", viper.GetBool("security.enabled"))
 	logger.Info("Privacy enabled status: %t", viper.GetBool("security.privacy"))
 
-	var opts []grpc.ServerOption
-	if viper.GetBool("peer.tls.enabled") {
-		creds, err := credentials.NewServerTLSFromFile(viper.GetString("peer.tls.cert.file"), viper.GetString("peer.tls.key.file"))
-		if err != nil {
-			grpclog.Fatalf("Failed to generate credentials %v", err)
-		}
-		opts = []grpc.ServerOption{grpc.Creds(creds)}
+	grpcServer, err := newGRPCServer("peer.tls")
+	if err != nil {
+		grpclog.Fatalf("Failed to create grpc server: %v", err)
+	}
+	clientServer, clientLis, err := serviceEndpoint(peer.ClientEndpoint, grpcServer)
+	if err != nil {
+		grpclog.Fatalf("Failed to create client endpoint: %v", err)
+	}
+	chaincodeServer, chaincodeLis, err := serviceEndpoint(peer.ChaincodeEndpoint, grpcServer)
+	if err != nil {
+		grpclog.Fatalf("Failed to create chaincode endpoint: %v", err)
 	}
 
-	grpcServer := grpc.NewServer(opts...)
+	if viper.GetString("peer.bootstrap.from") != "" && !peer.IsFollower() {
+		return fmt.Errorf("Only read-only followers can be bootstrapped from another peer")
+	}
 
 	var peerServer *peer.PeerImpl
 
 	if viper.GetBool("peer.validator.enabled") {
 		logger.Debug("Running as validating peer - installing consensus %s", viper.GetString("peer.validator.consensus"))
 		peerServer, err = peer.NewPeerWithHandler(helper.NewConsensusHandler)
+	} else if peer.IsFollower() {
+		logger.Debug("Running as read-only follower peer")
+		peer.SetQueryExecutor(func(transaction *pb.Transaction) ([]byte, error) {
+			return chaincode.Execute(context.Background(), chaincode.GetChain(chaincode.DefaultChain), transaction)
+		})
+		peerServer, err = peer.NewPeerWithHandler(peer.NewPeerHandler)
 	} else {
 		logger.Debug("Running as non-validating peer")
 		peerServer, err = peer.NewPeerWithHandler(peer.NewPeerHandler)
@@ -378,11 +434,10 @@ func serve(args []string) error {
 	pb.RegisterPeerServer(grpcServer, peerServer)
 
 	// Register the Admin server
-	pb.RegisterAdminServer(grpcServer, core.NewAdminServer())
+	pb.RegisterAdminServer(clientServer, core.NewAdminServerWithPeer(peerServer))
 
-	// Register ChaincodeSupport server...
-	// TODO : not the "DefaultChain" ... we have to revisit when we do multichain
-	// The ChaincodeSupport needs security helper to encrypt/decrypt state when
+	// Register ChaincodeSupport server, serving the default chain and the
+	// chains the peer joins. The ChaincodeSupport needs security helper to encrypt/decrypt state when
 	// privacy is enabled
 	var secHelper crypto.Peer
 	if viper.GetBool("security.privacy") {
@@ -390,11 +445,13 @@ func serve(args []string) error {
 	} else {
 		secHelper = nil
 	}
-	registerChaincodeSupport(chaincode.DefaultChain, grpcServer, secHelper)
+	if err = registerChaincodeSupport(chaincodeServer, secHelper); err != nil {
+		return err
+	}
 
 	// Register Devops server
 	serverDevops := core.NewDevopsServer(peerServer)
-	pb.RegisterDevopsServer(grpcServer, serverDevops)
+	pb.RegisterDevopsServer(clientServer, serverDevops)
 
 	// Register the ServerOpenchain server
 	serverOpenchain, err := rest.NewOpenchainServerWithPeerInfo(peerServer)
@@ -403,7 +460,7 @@ func serve(args []string) error {
 		return err
 	}
 
-	pb.RegisterOpenchainServer(grpcServer, serverOpenchain)
+	pb.RegisterOpenchainServer(clientServer, serverOpenchain)
 
 	// Create and register the REST service if configured
 	if viper.GetBool("rest.enabled") {
@@ -419,18 +476,27 @@ func serve(args []string) error {
 		peerEndpoint.ID, viper.GetString("peer.networkId"),
 		peerEndpoint.Address, rootNode, viper.GetBool("peer.validator.enabled"))
 
-	// Start the grpc server. Done in a goroutine so we can deploy the
+	// Start the grpc servers. Done in goroutines so we can deploy the
 	// genesis block if needed.
-	serve := make(chan error)
-	go func() {
+	serve := make(chan error, 4)
+	startServer := func(name string, server *grpc.Server, lis net.Listener) {
 		var grpcErr error
-		if grpcErr = grpcServer.Serve(lis); grpcErr != nil {
-			grpcErr = fmt.Errorf("grpc server exited with error: %s", grpcErr)
+		if grpcErr = server.Serve(lis); grpcErr != nil {
+			grpcErr = fmt.Errorf("%s grpc server exited with error: %s", name, grpcErr)
 		} else {
-			logger.Info("grpc server exited")
+			logger.Info("%s grpc server exited", name)
 		}
 		serve <- grpcErr
-	}()
+	}
+	go startServer("peer", grpcServer, lis)
+	if clientLis != nil {
+		logger.Info("Serving client APIs on %s", clientLis.Addr())
+		go startServer(peer.ClientEndpoint, clientServer, clientLis)
+	}
+	if chaincodeLis != nil {
+		logger.Info("Serving chaincode support on %s", chaincodeLis.Addr())
+		go startServer(peer.ChaincodeEndpoint, chaincodeServer, chaincodeLis)
+	}
 
 	// Deploy the genesis block if needed.
 	if viper.GetBool("peer.validator.enabled") {
@@ -445,6 +511,36 @@ func serve(args []string) error {
 		go ehubGrpcServer.Serve(ehubLis)
 	}
 
+	// Relay chaincode invocations to the remote network if configured
+	if viper.GetBool("bridge.enabled") {
+		go func() {
+			if _, bridgeErr := bridge.Start(); bridgeErr != nil {
+				logger.Error("Failed to start bridge: %s", bridgeErr)
+			}
+		}()
+	}
+
+	// Submit the transactions published on the message queue if configured
+	if _, ingestErr := ingest.Start(peerServer.ExecuteTransaction); ingestErr != nil {
+		logger.Error("Failed to start ingesting transactions: %s", ingestErr)
+	}
+
+	// Apply the changes made to the config file while the peer runs
+	config.OnChange("logging.peer", func() { core.LoggingInit("peer") })
+	if err := config.WatchConfig(viper.ConfigFileUsed(), viper.GetDuration("peer.configReload.interval")); err != nil {
+		logger.Error("Failed to watch the configuration: %s", err)
+	}
+
+	// Tell the other peers this peer is leaving when asked to shut down
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-sigs
+		logger.Info("Received %s, leaving the network", sig)
+		peerServer.Depart()
+		serve <- nil
+	}()
+
 	// Block until grpc server exits
 	return <-serve
 }
@@ -484,6 +580,31 @@ func stop() (err error) {
 	return nil
 }
 
+func supportBundle() (err error) {
+	clientConn, err := peer.NewPeerClientConnection()
+	if err != nil {
+		err = fmt.Errorf("Error trying to connect to local peer: %s", err)
+		return
+	}
+
+	serverClient := pb.NewAdminClient(clientConn)
+
+	bundle, err := serverClient.GetPeerSupportBundle(context.Background(), &pb.PeerSupportBundleRequest{LogLines: int32(supportBundleLogLines)})
+	if err != nil {
+		return
+	}
+	output := supportBundleOutput
+	if output == "" {
+		output = bundle.Name
+	}
+	if err = ioutil.WriteFile(output, bundle.Archive, 0600); err != nil {
+		err = fmt.Errorf("Error writing support bundle to %s: %s", output, err)
+		return
+	}
+	fmt.Printf("Support bundle written to %s\n", output)
+	return nil
+}
+
 // login confirms the enrollmentID and secret password of the client with the
 // CA and stores the enrollment certificate and key in the Devops server.
 func login(args []string) (err error) {
@@ -573,7 +694,10 @@ func getCliFilePath() string {
 	return localStore
 }
 
-func registerChaincodeSupport(chainname chaincode.ChainName, grpcServer *grpc.Server, secHelper crypto.Peer) {
+// registerChaincodeSupport creates the chaincode support of the default chain
+// and of each chain of peer.chains, opening their ledgers. The default chain
+// serves the chaincode streams, routing each to the chain it registers for.
+func registerChaincodeSupport(grpcServer *grpc.Server, secHelper crypto.Peer) error {
 	//get user mode
 	userRunsCC := false
 	if viper.GetString("chaincode.mode") == chaincode.DevModeUserRunsChaincode {
@@ -588,7 +712,60 @@ func registerChaincodeSupport(chainname chaincode.ChainName, grpcServer *grpc.Se
 	}
 	ccStartupTimeout := time.Duration(tOut) * time.Millisecond
 
-	pb.RegisterChaincodeSupportServer(grpcServer, chaincode.NewChaincodeSupport(chainname, peer.GetPeerEndpoint, userRunsCC, ccStartupTimeout, secHelper))
+	// Chaincodes connect to the chaincode endpoint
+	getPeerEndpoint := func() (*pb.PeerEndpoint, error) {
+		peerEndpoint, err := peer.GetPeerEndpoint()
+		if err == nil && peer.EndpointListenAddress(peer.ChaincodeEndpoint) != "" {
+			peerEndpoint.Address = peer.EndpointAddress(peer.ChaincodeEndpoint)
+		}
+		return peerEndpoint, err
+	}
+
+	for _, chainID := range viper.GetStringSlice("peer.chains") {
+		if chainID == string(chaincode.DefaultChain) {
+			continue
+		}
+		if _, err = ledger.GetChainLedger(chainID); err != nil {
+			return fmt.Errorf("Error joining chain %s: %s", chainID, err)
+		}
+		chaincode.NewChaincodeSupport(chaincode.ChainName(chainID), getPeerEndpoint, userRunsCC, ccStartupTimeout, secHelper)
+		logger.Info("Joined chain %s", chainID)
+	}
+
+	pb.RegisterChaincodeSupportServer(grpcServer, chaincode.NewChaincodeSupport(chaincode.DefaultChain, getPeerEndpoint, userRunsCC, ccStartupTimeout, secHelper))
+	return nil
+}
+
+// newGRPCServer returns a gRPC server using the TLS settings under tlsKey
+func newGRPCServer(tlsKey string) (*grpc.Server, error) {
+	var opts []grpc.ServerOption
+	if viper.GetBool(tlsKey + ".enabled") {
+		creds, err := credentials.NewServerTLSFromFile(viper.GetString(tlsKey+".cert.file"), viper.GetString(tlsKey+".key.file"))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to generate credentials %v", err)
+		}
+		opts = []grpc.ServerOption{grpc.Creds(creds)}
+	}
+	return grpc.NewServer(opts...), nil
+}
+
+// serviceEndpoint returns the gRPC server to register the services of group
+// with. A group with its own listen address gets a dedicated server and its
+// listener, the others share peerServer and the returned listener is nil.
+func serviceEndpoint(group string, peerServer *grpc.Server) (*grpc.Server, net.Listener, error) {
+	listenAddr := peer.EndpointListenAddress(group)
+	if listenAddr == "" {
+		return peerServer, nil, nil
+	}
+	server, err := newGRPCServer(peer.EndpointTLSKey(group))
+	if err != nil {
+		return nil, nil, err
+	}
+	lis, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to listen on %s: %v", listenAddr, err)
+	}
+	return server, lis, nil
 }
 
 func checkChaincodeCmdParams(cmd *cobra.Command) (err error) {
@@ -797,7 +974,7 @@ func chaincodeInvokeOrQuery(cmd *cobra.Command, args []string, invoke bool) (err
 	}
 
 	// Build the ChaincodeInvocationSpec message
-	invocation := &pb.ChaincodeInvocationSpec{ChaincodeSpec: spec}
+	invocation := &pb.ChaincodeInvocationSpec{ChaincodeSpec: spec, ValiditySeconds: chaincodeValidity}
 
 	var resp *pb.Response
 	if invoke {
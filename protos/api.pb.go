@@ -23,6 +23,7 @@ It has these top-level messages:
 	ChaincodeInvocationSpec
 	ChaincodeSecurityContext
 	ChaincodeMessage
+	TraceContext
 	PutStateInfo
 	RangeQueryState
 	RangeQueryStateNext
@@ -63,6 +63,37 @@ func (x ChaincodeSpec_Type) String() string {
 	return proto.EnumName(ChaincodeSpec_Type_name, int32(x))
 }
 
+// What becomes of a transaction arriving while the chaincode executes
+// maxConcurrentTransactions already
+type ChaincodeConcurrency_QueueingPolicy int32
+
+const (
+	ChaincodeConcurrency_DEFAULT ChaincodeConcurrency_QueueingPolicy = 0
+	// wait for its turn in order of arrival
+	ChaincodeConcurrency_FIFO ChaincodeConcurrency_QueueingPolicy = 1
+	// fail right away
+	ChaincodeConcurrency_REJECT ChaincodeConcurrency_QueueingPolicy = 2
+	// wait for its turn, the callers with the highest priority first
+	ChaincodeConcurrency_PRIORITY ChaincodeConcurrency_QueueingPolicy = 3
+)
+
+var ChaincodeConcurrency_QueueingPolicy_name = map[int32]string{
+	0: "DEFAULT",
+	1: "FIFO",
+	2: "REJECT",
+	3: "PRIORITY",
+}
+var ChaincodeConcurrency_QueueingPolicy_value = map[string]int32{
+	"DEFAULT":  0,
+	"FIFO":     1,
+	"REJECT":   2,
+	"PRIORITY": 3,
+}
+
+func (x ChaincodeConcurrency_QueueingPolicy) String() string {
+	return proto.EnumName(ChaincodeConcurrency_QueueingPolicy_name, int32(x))
+}
+
 type ChaincodeMessage_Type int32
 
 const (
@@ -86,6 +117,25 @@ const (
 	ChaincodeMessage_RANGE_QUERY_STATE       ChaincodeMessage_Type = 17
 	ChaincodeMessage_RANGE_QUERY_STATE_NEXT  ChaincodeMessage_Type = 18
 	ChaincodeMessage_RANGE_QUERY_STATE_CLOSE ChaincodeMessage_Type = 19
+	ChaincodeMessage_UPGRADE                 ChaincodeMessage_Type = 20
+	ChaincodeMessage_RESPONSE_CHUNK          ChaincodeMessage_Type = 21
+	ChaincodeMessage_CREDIT                  ChaincodeMessage_Type = 22
+	ChaincodeMessage_GET_HISTORY_FOR_KEY     ChaincodeMessage_Type = 23
+	// Sent by the peer before closing the stream of a chaincode whose
+	// REGISTER it refused, payload is a ChaincodeRegisterFailure
+	ChaincodeMessage_REGISTER_FAILED ChaincodeMessage_Type = 24
+	// Sent by the peer to a chaincode it has not heard from for a while,
+	// the chaincode answers with a KEEPALIVE of its own
+	ChaincodeMessage_KEEPALIVE ChaincodeMessage_Type = 25
+	// Deletes the keys with a prefix, payload is a DelStateRange, the
+	// RESPONSE payload a DelStateRangeResponse
+	ChaincodeMessage_DEL_STATE_RANGE ChaincodeMessage_Type = 26
+	// Sent by the peer instead of a RESPONSE whose payload is larger than
+	// chaincode.responseSpill.maxSize, payload is a ChaincodeResultRef
+	ChaincodeMessage_RESULT_SPILLED ChaincodeMessage_Type = 27
+	// Reads a piece of a spilled RESPONSE payload, payload is a
+	// FetchResult, the RESPONSE payload the piece
+	ChaincodeMessage_FETCH_RESULT ChaincodeMessage_Type = 28
 )
 
 var ChaincodeMessage_Type_name = map[int32]string{
@@ -109,6 +159,15 @@ var ChaincodeMessage_Type_name = map[int32]string{
 	17: "RANGE_QUERY_STATE",
 	18: "RANGE_QUERY_STATE_NEXT",
 	19: "RANGE_QUERY_STATE_CLOSE",
+	20: "UPGRADE",
+	21: "RESPONSE_CHUNK",
+	22: "CREDIT",
+	23: "GET_HISTORY_FOR_KEY",
+	24: "REGISTER_FAILED",
+	25: "KEEPALIVE",
+	26: "DEL_STATE_RANGE",
+	27: "RESULT_SPILLED",
+	28: "FETCH_RESULT",
 }
 var ChaincodeMessage_Type_value = map[string]int32{
 	"UNDEFINED":               0,
@@ -131,12 +190,108 @@ var ChaincodeMessage_Type_value = map[string]int32{
 	"RANGE_QUERY_STATE":       17,
 	"RANGE_QUERY_STATE_NEXT":  18,
 	"RANGE_QUERY_STATE_CLOSE": 19,
+	"UPGRADE":                 20,
+	"RESPONSE_CHUNK":          21,
+	"CREDIT":                  22,
+	"GET_HISTORY_FOR_KEY":     23,
+	"REGISTER_FAILED":         24,
+	"KEEPALIVE":               25,
+	"DEL_STATE_RANGE":         26,
+	"RESULT_SPILLED":          27,
+	"FETCH_RESULT":            28,
 }
 
 func (x ChaincodeMessage_Type) String() string {
 	return proto.EnumName(ChaincodeMessage_Type_name, int32(x))
 }
 
+type ChaincodeRegisterFailure_Reason int32
+
+const (
+	ChaincodeRegisterFailure_UNKNOWN ChaincodeRegisterFailure_Reason = 0
+	// the REGISTER payload could not be decoded
+	ChaincodeRegisterFailure_INVALID_REGISTRATION ChaincodeRegisterFailure_Reason = 1
+	// another instance of the chaincode is registered and
+	// chaincode.duplicateRegistration of the peer is reject
+	ChaincodeRegisterFailure_DUPLICATE ChaincodeRegisterFailure_Reason = 2
+	// no chaincode protocol version is supported by both sides
+	ChaincodeRegisterFailure_PROTOCOL_VERSION ChaincodeRegisterFailure_Reason = 3
+	// the fingerprint does not match the code package deployed
+	ChaincodeRegisterFailure_FINGERPRINT ChaincodeRegisterFailure_Reason = 4
+)
+
+var ChaincodeRegisterFailure_Reason_name = map[int32]string{
+	0: "UNKNOWN",
+	1: "INVALID_REGISTRATION",
+	2: "DUPLICATE",
+	3: "PROTOCOL_VERSION",
+	4: "FINGERPRINT",
+}
+var ChaincodeRegisterFailure_Reason_value = map[string]int32{
+	"UNKNOWN":              0,
+	"INVALID_REGISTRATION": 1,
+	"DUPLICATE":            2,
+	"PROTOCOL_VERSION":     3,
+	"FINGERPRINT":          4,
+}
+
+func (x ChaincodeRegisterFailure_Reason) String() string {
+	return proto.EnumName(ChaincodeRegisterFailure_Reason_name, int32(x))
+}
+
+type ChaincodeError_Code int32
+
+const (
+	ChaincodeError_UNKNOWN ChaincodeError_Code = 0
+	// the ledger failed to serve the state request
+	ChaincodeError_LEDGER ChaincodeError_Code = 1
+	// the transaction or query did not complete by its deadline
+	ChaincodeError_TIMEOUT ChaincodeError_Code = 2
+	// the request is not allowed, e.g. a write while querying
+	ChaincodeError_ACCESS_DENIED ChaincodeError_Code = 3
+	// the request payload could not be decoded
+	ChaincodeError_MALFORMED ChaincodeError_Code = 4
+	// the peer is saturated, the request should be sent again later
+	ChaincodeError_RETRY_LATER ChaincodeError_Code = 5
+	// the invoked or queried chaincode could not be run
+	ChaincodeError_CHAINCODE ChaincodeError_Code = 6
+	// the transaction or query was aborted by an operator
+	ChaincodeError_ABORTED ChaincodeError_Code = 7
+	// a state key or value breaks the limits configured on the peer
+	ChaincodeError_INVALID_ARGUMENT ChaincodeError_Code = 8
+	// another request of the same uuid is pending on the peer
+	ChaincodeError_DUPLICATE_REQUEST ChaincodeError_Code = 9
+)
+
+var ChaincodeError_Code_name = map[int32]string{
+	0: "UNKNOWN",
+	1: "LEDGER",
+	2: "TIMEOUT",
+	3: "ACCESS_DENIED",
+	4: "MALFORMED",
+	5: "RETRY_LATER",
+	6: "CHAINCODE",
+	7: "ABORTED",
+	8: "INVALID_ARGUMENT",
+	9: "DUPLICATE_REQUEST",
+}
+var ChaincodeError_Code_value = map[string]int32{
+	"UNKNOWN":           0,
+	"LEDGER":            1,
+	"TIMEOUT":           2,
+	"ACCESS_DENIED":     3,
+	"MALFORMED":         4,
+	"RETRY_LATER":       5,
+	"CHAINCODE":         6,
+	"ABORTED":           7,
+	"INVALID_ARGUMENT":  8,
+	"DUPLICATE_REQUEST": 9,
+}
+
+func (x ChaincodeError_Code) String() string {
+	return proto.EnumName(ChaincodeError_Code_name, int32(x))
+}
+
 // ChaincodeID contains the path as specified by the deploy transaction
 // that created it as well as the hashCode that is generated by the
 // system for the path. From the user level (ie, CLI, REST API and so on)
@@ -160,12 +315,22 @@ func (*ChaincodeID) ProtoMessage()    {}
 type ChaincodeInput struct {
 	Function string   `protobuf:"bytes,1,opt,name=function" json:"function,omitempty"`
 	Args     []string `protobuf:"bytes,2,rep,name=args" json:"args,omitempty"`
+	// Transient data of the transaction delivered to the chaincode, never
+	// written to the ledger
+	Transient map[string][]byte `protobuf:"bytes,3,rep,name=transient" json:"transient,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value,proto3"`
 }
 
 func (m *ChaincodeInput) Reset()         { *m = ChaincodeInput{} }
 func (m *ChaincodeInput) String() string { return proto.CompactTextString(m) }
 func (*ChaincodeInput) ProtoMessage()    {}
 
+func (m *ChaincodeInput) GetTransient() map[string][]byte {
+	if m != nil {
+		return m.Transient
+	}
+	return nil
+}
+
 // Carries the chaincode specification. This is the actual metadata required for
 // defining a chaincode.
 type ChaincodeSpec struct {
@@ -176,6 +341,10 @@ type ChaincodeSpec struct {
 	SecureContext        string               `protobuf:"bytes,5,opt,name=secureContext" json:"secureContext,omitempty"`
 	ConfidentialityLevel ConfidentialityLevel `protobuf:"varint,6,opt,name=confidentialityLevel,enum=protos.ConfidentialityLevel" json:"confidentialityLevel,omitempty"`
 	Metadata             []byte               `protobuf:"bytes,7,opt,name=metadata,proto3" json:"metadata,omitempty"`
+	// Limits of the container running the chaincode, set at deploy time
+	Resources *ChaincodeResources `protobuf:"bytes,8,opt,name=resources" json:"resources,omitempty"`
+	// Admission of the transactions of the chaincode, set at deploy time
+	Concurrency *ChaincodeConcurrency `protobuf:"bytes,9,opt,name=concurrency" json:"concurrency,omitempty"`
 }
 
 func (m *ChaincodeSpec) Reset()         { *m = ChaincodeSpec{} }
@@ -196,6 +365,51 @@ func (m *ChaincodeSpec) GetCtorMsg() *ChaincodeInput {
 	return nil
 }
 
+func (m *ChaincodeSpec) GetResources() *ChaincodeResources {
+	if m != nil {
+		return m.Resources
+	}
+	return nil
+}
+
+func (m *ChaincodeSpec) GetConcurrency() *ChaincodeConcurrency {
+	if m != nil {
+		return m.Concurrency
+	}
+	return nil
+}
+
+// Resources of the container running a chaincode. Unset fields fall back on
+// the limits of the peer, set fields may only tighten them.
+type ChaincodeResources struct {
+	// relative CPU weight, 1024 being the weight of other containers
+	CpuShares int64 `protobuf:"varint,1,opt,name=cpuShares" json:"cpuShares,omitempty"`
+	// memory limit in bytes, the chaincode is killed when exceeding it
+	MemoryLimit int64 `protobuf:"varint,2,opt,name=memoryLimit" json:"memoryLimit,omitempty"`
+	// maximum number of processes and threads
+	PidsLimit int64 `protobuf:"varint,3,opt,name=pidsLimit" json:"pidsLimit,omitempty"`
+	// network of the container: host, bridge or none
+	NetworkMode string `protobuf:"bytes,4,opt,name=networkMode" json:"networkMode,omitempty"`
+}
+
+func (m *ChaincodeResources) Reset()         { *m = ChaincodeResources{} }
+func (m *ChaincodeResources) String() string { return proto.CompactTextString(m) }
+func (*ChaincodeResources) ProtoMessage()    {}
+
+// Transactions and queries a chaincode executes at once. Unset fields fall
+// back on the defaults of the peer.
+type ChaincodeConcurrency struct {
+	// 0 leaves the number of transactions unbounded
+	MaxConcurrentTransactions int32                               `protobuf:"varint,1,opt,name=maxConcurrentTransactions" json:"maxConcurrentTransactions,omitempty"`
+	Policy                    ChaincodeConcurrency_QueueingPolicy `protobuf:"varint,2,opt,name=policy,enum=protos.ChaincodeConcurrency_QueueingPolicy" json:"policy,omitempty"`
+	// number of transactions waiting beyond which they are rejected
+	QueueSize int32 `protobuf:"varint,3,opt,name=queueSize" json:"queueSize,omitempty"`
+}
+
+func (m *ChaincodeConcurrency) Reset()         { *m = ChaincodeConcurrency{} }
+func (m *ChaincodeConcurrency) String() string { return proto.CompactTextString(m) }
+func (*ChaincodeConcurrency) ProtoMessage()    {}
+
 // Specify the deployment of a chaincode.
 // TODO: Define `codePackage`.
 type ChaincodeDeploymentSpec struct {
@@ -203,6 +417,12 @@ type ChaincodeDeploymentSpec struct {
 	// Controls when the chaincode becomes executable.
 	EffectiveDate *google_protobuf.Timestamp `protobuf:"bytes,2,opt,name=effectiveDate" json:"effectiveDate,omitempty"`
 	CodePackage   []byte                     `protobuf:"bytes,3,opt,name=codePackage,proto3" json:"codePackage,omitempty"`
+	// Digest of codePackage computed by the deployer, the peer refuses to
+	// build a package that does not match it
+	CodePackageHash []byte `protobuf:"bytes,4,opt,name=codePackageHash,proto3" json:"codePackageHash,omitempty"`
+	// Name of the chaincode this deployment upgrades, if any. The deployed
+	// chaincode takes over the state and the requests of that chaincode.
+	Upgrades string `protobuf:"bytes,5,opt,name=upgrades" json:"upgrades,omitempty"`
 }
 
 func (m *ChaincodeDeploymentSpec) Reset()         { *m = ChaincodeDeploymentSpec{} }
@@ -226,6 +446,13 @@ func (m *ChaincodeDeploymentSpec) GetEffectiveDate() *google_protobuf.Timestamp
 // Carries the chaincode function and its arguments.
 type ChaincodeInvocationSpec struct {
 	ChaincodeSpec *ChaincodeSpec `protobuf:"bytes,1,opt,name=chaincodeSpec" json:"chaincodeSpec,omitempty"`
+	// Seconds after its creation during which the transaction may be
+	// executed, 0 for no limit
+	ValiditySeconds uint32 `protobuf:"varint,3,opt,name=validitySeconds" json:"validitySeconds,omitempty"`
+	// Data passed to the chaincode with the transaction but never written to
+	// the ledger or included in events, such as secrets. It is moved to the
+	// transaction when it is created and is not covered by its signature.
+	Transient map[string][]byte `protobuf:"bytes,4,rep,name=transient" json:"transient,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value,proto3"`
 }
 
 func (m *ChaincodeInvocationSpec) Reset()         { *m = ChaincodeInvocationSpec{} }
@@ -239,6 +466,13 @@ func (m *ChaincodeInvocationSpec) GetChaincodeSpec() *ChaincodeSpec {
 	return nil
 }
 
+func (m *ChaincodeInvocationSpec) GetTransient() map[string][]byte {
+	if m != nil {
+		return m.Transient
+	}
+	return nil
+}
+
 type ChaincodeSecurityContext struct {
 	CallerCert     []byte `protobuf:"bytes,1,opt,name=callerCert,proto3" json:"callerCert,omitempty"`
 	CallerSign     []byte `protobuf:"bytes,2,opt,name=callerSign,proto3" json:"callerSign,omitempty"`
@@ -258,6 +492,27 @@ type ChaincodeMessage struct {
 	Payload         []byte                     `protobuf:"bytes,3,opt,name=payload,proto3" json:"payload,omitempty"`
 	Uuid            string                     `protobuf:"bytes,4,opt,name=uuid" json:"uuid,omitempty"`
 	SecurityContext *ChaincodeSecurityContext  `protobuf:"bytes,5,opt,name=securityContext" json:"securityContext,omitempty"`
+	// Set by the peer on TRANSACTION and QUERY, the time by which the
+	// chaincode must have completed. An ERROR carrying a deadline aborts the
+	// transaction or query with the same uuid.
+	Deadline *google_protobuf.Timestamp `protobuf:"bytes,6,opt,name=deadline" json:"deadline,omitempty"`
+	// Chain the message belongs to, set by the peer on the messages it sends.
+	// State requests are served from the ledger of the chain of the chaincode
+	// support the chaincode registered with, naming another chain is refused.
+	ChainID string `protobuf:"bytes,7,opt,name=chainID" json:"chainID,omitempty"`
+	// Set by the peer on the TRANSACTION or QUERY of a chaincode invoked or
+	// queried by another one, the uuid of the invoking transaction or query.
+	// The uuid of the nested invocation is derived from it, see
+	// core/chaincode/nested.go.
+	ParentUuid string `protobuf:"bytes,8,opt,name=parentUuid" json:"parentUuid,omitempty"`
+	// Span of the peer the message belongs to, see core/tracing. The shim
+	// copies the context of a TRANSACTION or QUERY onto the requests it
+	// makes while executing it.
+	TraceContext *TraceContext `protobuf:"bytes,9,opt,name=traceContext" json:"traceContext,omitempty"`
+	// Logical chaincode the message belongs to on a stream shared by
+	// several chaincodes, see ChaincodeRegistration.tenants. Empty for the
+	// chaincode that opened the stream.
+	ChaincodeName string `protobuf:"bytes,10,opt,name=chaincodeName" json:"chaincodeName,omitempty"`
 }
 
 func (m *ChaincodeMessage) Reset()         { *m = ChaincodeMessage{} }
@@ -278,6 +533,13 @@ func (m *ChaincodeMessage) GetSecurityContext() *ChaincodeSecurityContext {
 	return nil
 }
 
+func (m *ChaincodeMessage) GetDeadline() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Deadline
+	}
+	return nil
+}
+
 type PutStateInfo struct {
 	Key   string `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
 	Value []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
@@ -338,10 +600,193 @@ func (m *RangeQueryStateResponse) GetKeysAndValues() []*RangeQueryStateKeyValue
 	return nil
 }
 
+// A value taken by a key, in the RangeQueryStateResponse to
+// GET_HISTORY_FOR_KEY, whose payload is the key. The transaction that set or
+// deleted the key and its timestamp come with the value.
+type KeyModification struct {
+	TxID      string                     `protobuf:"bytes,1,opt,name=txID" json:"txID,omitempty"`
+	Timestamp *google_protobuf.Timestamp `protobuf:"bytes,2,opt,name=timestamp" json:"timestamp,omitempty"`
+	Value     []byte                     `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	IsDelete  bool                       `protobuf:"varint,4,opt,name=isDelete" json:"isDelete,omitempty"`
+}
+
+func (m *KeyModification) Reset()         { *m = KeyModification{} }
+func (m *KeyModification) String() string { return proto.CompactTextString(m) }
+func (*KeyModification) ProtoMessage()    {}
+
+func (m *KeyModification) GetTimestamp() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Timestamp
+	}
+	return nil
+}
+
+// Payload of DEL_STATE_RANGE. Nothing is deleted if more keys than maxKeys
+// have the prefix.
+type DelStateRange struct {
+	// not empty
+	Prefix string `protobuf:"bytes,1,opt,name=prefix" json:"prefix,omitempty"`
+	// chaincode.delStateRange.maxKeys if 0 or above it
+	MaxKeys int32 `protobuf:"varint,2,opt,name=maxKeys" json:"maxKeys,omitempty"`
+	// only count the keys that would be deleted
+	DryRun bool `protobuf:"varint,3,opt,name=dryRun" json:"dryRun,omitempty"`
+}
+
+func (m *DelStateRange) Reset()         { *m = DelStateRange{} }
+func (m *DelStateRange) String() string { return proto.CompactTextString(m) }
+func (*DelStateRange) ProtoMessage()    {}
+
+type DelStateRangeResponse struct {
+	// keys deleted, or that would be deleted by a dry run
+	Count int32 `protobuf:"varint,1,opt,name=count" json:"count,omitempty"`
+}
+
+func (m *DelStateRangeResponse) Reset()         { *m = DelStateRangeResponse{} }
+func (m *DelStateRangeResponse) String() string { return proto.CompactTextString(m) }
+func (*DelStateRangeResponse) ProtoMessage()    {}
+
+// Payload of RESULT_SPILLED, the RESPONSE payload the peer kept under handle
+// rather than send it on the stream
+type ChaincodeResultRef struct {
+	Handle string `protobuf:"bytes,1,opt,name=handle" json:"handle,omitempty"`
+	// length of the payload in bytes
+	Size uint64 `protobuf:"varint,2,opt,name=size" json:"size,omitempty"`
+}
+
+func (m *ChaincodeResultRef) Reset()         { *m = ChaincodeResultRef{} }
+func (m *ChaincodeResultRef) String() string { return proto.CompactTextString(m) }
+func (*ChaincodeResultRef) ProtoMessage()    {}
+
+// Payload of FETCH_RESULT. The peer answers with at most
+// chaincode.responseSpill.maxSize bytes of the spilled payload from offset,
+// and forgets it once its end was read.
+type FetchResult struct {
+	Handle string `protobuf:"bytes,1,opt,name=handle" json:"handle,omitempty"`
+	Offset uint64 `protobuf:"varint,2,opt,name=offset" json:"offset,omitempty"`
+}
+
+func (m *FetchResult) Reset()         { *m = FetchResult{} }
+func (m *FetchResult) String() string { return proto.CompactTextString(m) }
+func (*FetchResult) ProtoMessage()    {}
+
+// Payload of REGISTER. The first fields are those of ChaincodeID so that
+// peers and shims that predate protocol negotiation can read each other.
+type ChaincodeRegistration struct {
+	Path string `protobuf:"bytes,1,opt,name=path" json:"path,omitempty"`
+	Name string `protobuf:"bytes,2,opt,name=name" json:"name,omitempty"`
+	// range of chaincode protocol versions supported by the shim, both 0
+	// for shims that predate protocol negotiation and only speak version 1
+	MinProtocolVersion int32 `protobuf:"varint,3,opt,name=minProtocolVersion" json:"minProtocolVersion,omitempty"`
+	MaxProtocolVersion int32 `protobuf:"varint,4,opt,name=maxProtocolVersion" json:"maxProtocolVersion,omitempty"`
+	// number of messages the shim accepts from the peer before it has to
+	// return credits with CREDIT, 0 if the shim does not do flow control
+	Window uint32 `protobuf:"varint,5,opt,name=window" json:"window,omitempty"`
+	// digest of the code package the chaincode image was built from, baked
+	// into the image by the peer that built it
+	Fingerprint []byte `protobuf:"bytes,6,opt,name=fingerprint,proto3" json:"fingerprint,omitempty"`
+	// names of further chaincodes served over the same stream, each of which
+	// registers with its own REGISTER carrying its name in chaincodeName.
+	// Honored from protocol version 10 by peers accepting tenants.
+	Tenants []string `protobuf:"bytes,7,rep,name=tenants" json:"tenants,omitempty"`
+	// schemas of the events the chaincode emits, registered with the event
+	// hub from protocol version 12
+	EventSchemas []*ChaincodeEventSchema `protobuf:"bytes,8,rep,name=eventSchemas" json:"eventSchemas,omitempty"`
+}
+
+func (m *ChaincodeRegistration) Reset()         { *m = ChaincodeRegistration{} }
+func (m *ChaincodeRegistration) String() string { return proto.CompactTextString(m) }
+func (*ChaincodeRegistration) ProtoMessage()    {}
+
+func (m *ChaincodeRegistration) GetEventSchemas() []*ChaincodeEventSchema {
+	if m != nil {
+		return m.EventSchemas
+	}
+	return nil
+}
+
+// Schema of the payload of the generic events of eventType: a JSON object
+// holding at least the requiredFields
+type ChaincodeEventSchema struct {
+	EventType      string   `protobuf:"bytes,1,opt,name=eventType" json:"eventType,omitempty"`
+	RequiredFields []string `protobuf:"bytes,2,rep,name=requiredFields" json:"requiredFields,omitempty"`
+}
+
+func (m *ChaincodeEventSchema) Reset()         { *m = ChaincodeEventSchema{} }
+func (m *ChaincodeEventSchema) String() string { return proto.CompactTextString(m) }
+func (*ChaincodeEventSchema) ProtoMessage()    {}
+
+// Payload of REGISTERED, the protocol version selected by the peer and the
+// window it honors, 0 if messages to the chaincode are not flow controlled
+type ChaincodeProtocol struct {
+	Version int32  `protobuf:"varint,1,opt,name=version" json:"version,omitempty"`
+	Window  uint32 `protobuf:"varint,2,opt,name=window" json:"window,omitempty"`
+}
+
+func (m *ChaincodeProtocol) Reset()         { *m = ChaincodeProtocol{} }
+func (m *ChaincodeProtocol) String() string { return proto.CompactTextString(m) }
+func (*ChaincodeProtocol) ProtoMessage()    {}
+
+// Payload of REGISTER_FAILED, why the peer refused the registration
+type ChaincodeRegisterFailure struct {
+	Reason  ChaincodeRegisterFailure_Reason `protobuf:"varint,1,opt,name=reason,enum=protos.ChaincodeRegisterFailure_Reason" json:"reason,omitempty"`
+	Message string                          `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+}
+
+func (m *ChaincodeRegisterFailure) Reset()         { *m = ChaincodeRegisterFailure{} }
+func (m *ChaincodeRegisterFailure) String() string { return proto.CompactTextString(m) }
+func (*ChaincodeRegisterFailure) ProtoMessage()    {}
+
+// Payload of CREDIT, the number of messages consumed by the shim since it last
+// returned credits to the peer
+type ChaincodeCredit struct {
+	Credits uint32 `protobuf:"varint,1,opt,name=credits" json:"credits,omitempty"`
+}
+
+func (m *ChaincodeCredit) Reset()         { *m = ChaincodeCredit{} }
+func (m *ChaincodeCredit) String() string { return proto.CompactTextString(m) }
+func (*ChaincodeCredit) ProtoMessage()    {}
+
+// Payload of RESPONSE_CHUNK. A RESPONSE too large for a single message is
+// sent as a sequence of chunks, numbered from 0, the last one marked as such.
+type ChaincodeResponseChunk struct {
+	Sequence uint32 `protobuf:"varint,1,opt,name=sequence" json:"sequence,omitempty"`
+	Data     []byte `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	Last     bool   `protobuf:"varint,3,opt,name=last" json:"last,omitempty"`
+}
+
+func (m *ChaincodeResponseChunk) Reset()         { *m = ChaincodeResponseChunk{} }
+func (m *ChaincodeResponseChunk) String() string { return proto.CompactTextString(m) }
+func (*ChaincodeResponseChunk) ProtoMessage()    {}
+
+// Payload of the ERROR messages the peer sends to chaincodes speaking
+// protocol version 6 or later, older ones get the message alone
+type ChaincodeError struct {
+	Code    ChaincodeError_Code `protobuf:"varint,1,opt,name=code,enum=protos.ChaincodeError_Code" json:"code,omitempty"`
+	Message string              `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+	// context of the failure, e.g. the key or the chaincode involved
+	Details map[string]string `protobuf:"bytes,3,rep,name=details" json:"details,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// whether sending the same request again may succeed
+	Retryable bool `protobuf:"varint,4,opt,name=retryable" json:"retryable,omitempty"`
+}
+
+func (m *ChaincodeError) Reset()         { *m = ChaincodeError{} }
+func (m *ChaincodeError) String() string { return proto.CompactTextString(m) }
+func (*ChaincodeError) ProtoMessage()    {}
+
+func (m *ChaincodeError) GetDetails() map[string]string {
+	if m != nil {
+		return m.Details
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterEnum("protos.ConfidentialityLevel", ConfidentialityLevel_name, ConfidentialityLevel_value)
 	proto.RegisterEnum("protos.ChaincodeSpec_Type", ChaincodeSpec_Type_name, ChaincodeSpec_Type_value)
+	proto.RegisterEnum("protos.ChaincodeConcurrency_QueueingPolicy", ChaincodeConcurrency_QueueingPolicy_name, ChaincodeConcurrency_QueueingPolicy_value)
 	proto.RegisterEnum("protos.ChaincodeMessage_Type", ChaincodeMessage_Type_name, ChaincodeMessage_Type_value)
+	proto.RegisterEnum("protos.ChaincodeRegisterFailure_Reason", ChaincodeRegisterFailure_Reason_name, ChaincodeRegisterFailure_Reason_value)
+	proto.RegisterEnum("protos.ChaincodeError_Code", ChaincodeError_Code_name, ChaincodeError_Code_value)
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -362,6 +807,23 @@ func NewChaincodeSupportClient(cc *grpc.ClientConn) ChaincodeSupportClient {
 	return &chaincodeSupportClient{cc}
 }
 
+func (m *ChaincodeMessage) GetTraceContext() *TraceContext {
+	if m != nil {
+		return m.TraceContext
+	}
+	return nil
+}
+
+// TraceContext identifies a span of a trace across peers and chaincodes
+type TraceContext struct {
+	TraceID string `protobuf:"bytes,1,opt,name=traceID" json:"traceID,omitempty"`
+	SpanID  string `protobuf:"bytes,2,opt,name=spanID" json:"spanID,omitempty"`
+}
+
+func (m *TraceContext) Reset()         { *m = TraceContext{} }
+func (m *TraceContext) String() string { return proto.CompactTextString(m) }
+func (*TraceContext) ProtoMessage()    {}
+
 func (c *chaincodeSupportClient) Register(ctx context.Context, opts ...grpc.CallOption) (ChaincodeSupport_RegisterClient, error) {
 	stream, err := grpc.NewClientStream(ctx, &_ChaincodeSupport_serviceDesc.Streams[0], c.cc, "/protos.ChaincodeSupport/Register", opts...)
 	if err != nil {
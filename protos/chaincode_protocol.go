@@ -0,0 +1,108 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package protos
+
+import (
+	"github.com/golang/protobuf/proto"
+)
+
+// Versions of the protocol spoken between the peer and chaincode shims over
+// the ChaincodeSupport stream, negotiated at REGISTER
+const (
+	// ChaincodeProtocolV1 is the protocol of shims that predate negotiation
+	ChaincodeProtocolV1 int32 = 1
+	// ChaincodeProtocolV2 shims resend state requests the peer rejected with
+	// an ERROR whose payload starts with ChaincodeRetryLater
+	ChaincodeProtocolV2 int32 = 2
+	// ChaincodeProtocolV3 shims reassemble responses the peer split into
+	// RESPONSE_CHUNK messages
+	ChaincodeProtocolV3 int32 = 3
+	// ChaincodeProtocolV4 shims grant the peer a window of messages at
+	// REGISTER and return credits with CREDIT as they consume them
+	ChaincodeProtocolV4 int32 = 4
+	// ChaincodeProtocolV5 peers serve GET_HISTORY_FOR_KEY
+	ChaincodeProtocolV5 int32 = 5
+	// ChaincodeProtocolV6 peers send the payload of ERROR as a ChaincodeError
+	ChaincodeProtocolV6 int32 = 6
+	// ChaincodeProtocolV7 shims answer KEEPALIVE with KEEPALIVE
+	ChaincodeProtocolV7 int32 = 7
+	// ChaincodeProtocolV8 peers serve DEL_STATE_RANGE
+	ChaincodeProtocolV8 int32 = 8
+	// ChaincodeProtocolV9 peers answer a request whose uuid has another one
+	// pending with an ERROR coded DUPLICATE_REQUEST instead of dropping it
+	ChaincodeProtocolV9 int32 = 9
+	// ChaincodeProtocolV10 peers serve the tenants a chaincode registers on
+	// its stream, see ChaincodeRegistration.Tenants
+	ChaincodeProtocolV10 int32 = 10
+	// ChaincodeProtocolV11 shims read the RESPONSE payloads the peer spilled
+	// with FETCH_RESULT, see ChaincodeResultRef
+	ChaincodeProtocolV11 int32 = 11
+	// ChaincodeProtocolV12 peers register the event schemas a chaincode
+	// declares at REGISTER with the event hub, see
+	// ChaincodeRegistration.EventSchemas
+	ChaincodeProtocolV12 int32 = 12
+
+	// MinChaincodeProtocol is the oldest protocol version still supported
+	MinChaincodeProtocol = ChaincodeProtocolV1
+	// MaxChaincodeProtocol is the newest protocol version supported
+	MaxChaincodeProtocol = ChaincodeProtocolV12
+)
+
+// ChaincodeRetryLater is the payload prefix of the ERROR message sent back to
+// a chaincode whose state request was rejected because the peer is saturated
+const ChaincodeRetryLater = "RETRY_LATER"
+
+// Error returns the message of the ChaincodeError so that it can be handed
+// to chaincodes as an error
+func (m *ChaincodeError) Error() string {
+	return m.Message
+}
+
+// NewChaincodeError returns the ChaincodeError for err, retryable if the
+// code is RETRY_LATER or TIMEOUT
+func NewChaincodeError(code ChaincodeError_Code, err error, details map[string]string) *ChaincodeError {
+	retryable := code == ChaincodeError_RETRY_LATER || code == ChaincodeError_TIMEOUT || code == ChaincodeError_DUPLICATE_REQUEST
+	return &ChaincodeError{Code: code, Message: err.Error(), Details: details, Retryable: retryable}
+}
+
+// ChaincodeErrorPayload returns the payload of an ERROR sent to a chaincode
+// speaking protocol version: the marshaled ChaincodeError from
+// ChaincodeProtocolV6, its message before
+func ChaincodeErrorPayload(version int32, chaincodeErr *ChaincodeError) []byte {
+	if version >= ChaincodeProtocolV6 {
+		if payload, err := proto.Marshal(chaincodeErr); err == nil {
+			return payload
+		}
+	}
+	return []byte(chaincodeErr.Message)
+}
+
+// ChaincodeErrorFromPayload decodes the payload of an ERROR received from a
+// peer speaking protocol version. Payloads that are not a ChaincodeError are
+// returned as the message of an UNKNOWN one.
+func ChaincodeErrorFromPayload(version int32, payload []byte) *ChaincodeError {
+	if version >= ChaincodeProtocolV6 {
+		chaincodeErr := &ChaincodeError{}
+		if err := proto.Unmarshal(payload, chaincodeErr); err == nil && chaincodeErr.Message != "" {
+			return chaincodeErr
+		}
+	}
+	return &ChaincodeError{Code: ChaincodeError_UNKNOWN, Message: string(payload)}
+}
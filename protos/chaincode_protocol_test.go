@@ -0,0 +1,50 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package protos
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestChaincodeErrorPayload(t *testing.T) {
+	chaincodeErr := NewChaincodeError(ChaincodeError_TIMEOUT, errors.New("timed out"), map[string]string{"key": "a"})
+	if !chaincodeErr.Retryable {
+		t.Fatalf("Expected %s to be retryable", chaincodeErr.Code)
+	}
+
+	decoded := ChaincodeErrorFromPayload(ChaincodeProtocolV6, ChaincodeErrorPayload(ChaincodeProtocolV6, chaincodeErr))
+	if decoded.Code != ChaincodeError_TIMEOUT || decoded.Message != "timed out" || decoded.Details["key"] != "a" || !decoded.Retryable {
+		t.Fatalf("Unexpected decoded error: %v", decoded)
+	}
+
+	payload := ChaincodeErrorPayload(ChaincodeProtocolV5, chaincodeErr)
+	if string(payload) != "timed out" {
+		t.Fatalf("Expected a text payload below V6, got %q", payload)
+	}
+	decoded = ChaincodeErrorFromPayload(ChaincodeProtocolV5, payload)
+	if decoded.Code != ChaincodeError_UNKNOWN || decoded.Error() != "timed out" {
+		t.Fatalf("Unexpected decoded error: %v", decoded)
+	}
+
+	if NewChaincodeError(ChaincodeError_LEDGER, errors.New("failed"), nil).Retryable {
+		t.Fatalf("Expected %s not to be retryable", ChaincodeError_LEDGER)
+	}
+}
@@ -69,6 +69,64 @@ func (m *BuildResult) GetDeploymentSpec() *ChaincodeDeploymentSpec {
 	return nil
 }
 
+// StateRead is a key read by a simulated transaction together with the
+// version it read, the hash of the value or empty if the key did not exist.
+type StateRead struct {
+	ChaincodeID string `protobuf:"bytes,1,opt,name=chaincodeID" json:"chaincodeID,omitempty"`
+	Key         string `protobuf:"bytes,2,opt,name=key" json:"key,omitempty"`
+	Version     []byte `protobuf:"bytes,3,opt,name=version,proto3" json:"version,omitempty"`
+}
+
+func (m *StateRead) Reset()         { *m = StateRead{} }
+func (m *StateRead) String() string { return proto.CompactTextString(m) }
+func (*StateRead) ProtoMessage()    {}
+
+// StateWrite is a write a simulated transaction would apply.
+type StateWrite struct {
+	ChaincodeID string `protobuf:"bytes,1,opt,name=chaincodeID" json:"chaincodeID,omitempty"`
+	Key         string `protobuf:"bytes,2,opt,name=key" json:"key,omitempty"`
+	Value       []byte `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	IsDelete    bool   `protobuf:"varint,4,opt,name=isDelete" json:"isDelete,omitempty"`
+}
+
+func (m *StateWrite) Reset()         { *m = StateWrite{} }
+func (m *StateWrite) String() string { return proto.CompactTextString(m) }
+func (*StateWrite) ProtoMessage()    {}
+
+// SimulationResult is the outcome of a simulated invoke. Nothing in it has
+// been committed to the ledger.
+type SimulationResult struct {
+	Response *Response     `protobuf:"bytes,1,opt,name=response" json:"response,omitempty"`
+	Uuid     string        `protobuf:"bytes,2,opt,name=uuid" json:"uuid,omitempty"`
+	Reads    []*StateRead  `protobuf:"bytes,3,rep,name=reads" json:"reads,omitempty"`
+	Writes   []*StateWrite `protobuf:"bytes,4,rep,name=writes" json:"writes,omitempty"`
+}
+
+func (m *SimulationResult) Reset()         { *m = SimulationResult{} }
+func (m *SimulationResult) String() string { return proto.CompactTextString(m) }
+func (*SimulationResult) ProtoMessage()    {}
+
+func (m *SimulationResult) GetResponse() *Response {
+	if m != nil {
+		return m.Response
+	}
+	return nil
+}
+
+func (m *SimulationResult) GetReads() []*StateRead {
+	if m != nil {
+		return m.Reads
+	}
+	return nil
+}
+
+func (m *SimulationResult) GetWrites() []*StateWrite {
+	if m != nil {
+		return m.Writes
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterEnum("protos.BuildResult_StatusCode", BuildResult_StatusCode_name, BuildResult_StatusCode_value)
 }
@@ -91,6 +149,9 @@ type DevopsClient interface {
 	Invoke(ctx context.Context, in *ChaincodeInvocationSpec, opts ...grpc.CallOption) (*Response, error)
 	// Invoke chaincode.
 	Query(ctx context.Context, in *ChaincodeInvocationSpec, opts ...grpc.CallOption) (*Response, error)
+	// Execute an invoke against the committed state without submitting it,
+	// returning the response and the read-write set it would produce.
+	Simulate(ctx context.Context, in *ChaincodeInvocationSpec, opts ...grpc.CallOption) (*SimulationResult, error)
 }
 
 type devopsClient struct {
@@ -146,6 +207,15 @@ func (c *devopsClient) Query(ctx context.Context, in *ChaincodeInvocationSpec, o
 	return out, nil
 }
 
+func (c *devopsClient) Simulate(ctx context.Context, in *ChaincodeInvocationSpec, opts ...grpc.CallOption) (*SimulationResult, error) {
+	out := new(SimulationResult)
+	err := grpc.Invoke(ctx, "/protos.Devops/Simulate", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for Devops service
 
 type DevopsServer interface {
@@ -160,6 +230,9 @@ type DevopsServer interface {
 	Invoke(context.Context, *ChaincodeInvocationSpec) (*Response, error)
 	// Invoke chaincode.
 	Query(context.Context, *ChaincodeInvocationSpec) (*Response, error)
+	// Execute an invoke against the committed state without submitting it,
+	// returning the response and the read-write set it would produce.
+	Simulate(context.Context, *ChaincodeInvocationSpec) (*SimulationResult, error)
 }
 
 func RegisterDevopsServer(s *grpc.Server, srv DevopsServer) {
@@ -226,6 +299,18 @@ func _Devops_Query_Handler(srv interface{}, ctx context.Context, dec func(interf
 	return out, nil
 }
 
+func _Devops_Simulate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(ChaincodeInvocationSpec)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(DevopsServer).Simulate(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 var _Devops_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "protos.Devops",
 	HandlerType: (*DevopsServer)(nil),
@@ -250,6 +335,10 @@ var _Devops_serviceDesc = grpc.ServiceDesc{
 			MethodName: "Query",
 			Handler:    _Devops_Query_Handler,
 		},
+		{
+			MethodName: "Simulate",
+			Handler:    _Devops_Simulate_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{},
 }
@@ -78,12 +78,15 @@ func (x PeerEndpoint_Type) String() string {
 type Message_Type int32
 
 const (
-	Message_UNDEFINED               Message_Type = 0
-	Message_DISC_HELLO              Message_Type = 1
+	Message_UNDEFINED  Message_Type = 0
+	Message_DISC_HELLO Message_Type = 1
+	// Departure of the sender, which is shutting down, payload is empty
 	Message_DISC_DISCONNECT         Message_Type = 2
 	Message_DISC_GET_PEERS          Message_Type = 3
 	Message_DISC_PEERS              Message_Type = 4
 	Message_DISC_NEWMSG             Message_Type = 5
+	Message_DISC_PING               Message_Type = 7
+	Message_DISC_PONG               Message_Type = 8
 	Message_CHAIN_TRANSACTION       Message_Type = 6
 	Message_SYNC_GET_BLOCKS         Message_Type = 11
 	Message_SYNC_BLOCKS             Message_Type = 12
@@ -94,6 +97,30 @@ const (
 	Message_SYNC_STATE_DELTAS       Message_Type = 17
 	Message_RESPONSE                Message_Type = 20
 	Message_CONSENSUS               Message_Type = 21
+	// Reply to a message whose type is not available at the protocol
+	// version negotiated for the stream, payload is an UnsupportedMessage
+	Message_UNSUPPORTED Message_Type = 22
+	// Refusal of a DISC_HELLO by a peer with no connection left, payload
+	// is a PeersMessage of peers to connect to instead
+	Message_DISC_BUSY Message_Type = 23
+	// Subscription of the sender to topics, payload is a TopicsMessage
+	Message_SUB Message_Type = 24
+	// Cancellation of subscriptions of the sender, payload is a
+	// TopicsMessage
+	Message_UNSUB Message_Type = 25
+	// Message published on a topic, payload is a PublishMessage. It is
+	// only sent to the peers subscribed to the topic.
+	Message_PUBLISH Message_Type = 26
+	// Bloom filter of the transactions pooled by the sender, payload is
+	// a TransactionDigest
+	Message_TX_DIGEST Message_Type = 27
+	// Pooled transactions missing from the TX_DIGEST of the receiver,
+	// payload is a TransactionBlock
+	Message_TX_POOL Message_Type = 28
+	// Block committed by the sending validator and the changes it made
+	// to the state, payload is a BlockStateDelta. Sent to non-validating
+	// peers, which apply it rather than execute the transactions.
+	Message_STATE_DELTA Message_Type = 29
 )
 
 var Message_Type_name = map[int32]string{
@@ -103,6 +130,8 @@ var Message_Type_name = map[int32]string{
 	3:  "DISC_GET_PEERS",
 	4:  "DISC_PEERS",
 	5:  "DISC_NEWMSG",
+	7:  "DISC_PING",
+	8:  "DISC_PONG",
 	6:  "CHAIN_TRANSACTION",
 	11: "SYNC_GET_BLOCKS",
 	12: "SYNC_BLOCKS",
@@ -113,6 +142,14 @@ var Message_Type_name = map[int32]string{
 	17: "SYNC_STATE_DELTAS",
 	20: "RESPONSE",
 	21: "CONSENSUS",
+	22: "UNSUPPORTED",
+	23: "DISC_BUSY",
+	24: "SUB",
+	25: "UNSUB",
+	26: "PUBLISH",
+	27: "TX_DIGEST",
+	28: "TX_POOL",
+	29: "STATE_DELTA",
 }
 var Message_Type_value = map[string]int32{
 	"UNDEFINED":               0,
@@ -121,6 +158,8 @@ var Message_Type_value = map[string]int32{
 	"DISC_GET_PEERS":          3,
 	"DISC_PEERS":              4,
 	"DISC_NEWMSG":             5,
+	"DISC_PING":               7,
+	"DISC_PONG":               8,
 	"CHAIN_TRANSACTION":       6,
 	"SYNC_GET_BLOCKS":         11,
 	"SYNC_BLOCKS":             12,
@@ -131,28 +170,92 @@ var Message_Type_value = map[string]int32{
 	"SYNC_STATE_DELTAS":       17,
 	"RESPONSE":                20,
 	"CONSENSUS":               21,
+	"UNSUPPORTED":             22,
+	"DISC_BUSY":               23,
+	"SUB":                     24,
+	"UNSUB":                   25,
+	"PUBLISH":                 26,
+	"TX_DIGEST":               27,
+	"TX_POOL":                 28,
+	"STATE_DELTA":             29,
 }
 
 func (x Message_Type) String() string {
 	return proto.EnumName(Message_Type_name, int32(x))
 }
 
+type Message_Compression int32
+
+const (
+	Message_NONE   Message_Compression = 0
+	Message_GZIP   Message_Compression = 1
+	Message_SNAPPY Message_Compression = 2
+)
+
+var Message_Compression_name = map[int32]string{
+	0: "NONE",
+	1: "GZIP",
+	2: "SNAPPY",
+}
+var Message_Compression_value = map[string]int32{
+	"NONE":   0,
+	"GZIP":   1,
+	"SNAPPY": 2,
+}
+
+func (x Message_Compression) String() string {
+	return proto.EnumName(Message_Compression_name, int32(x))
+}
+
+// Class of a message, derived from its type. When several messages wait
+// for the same stream, those of a higher class are sent first so that
+// bulk state transfer cannot hold up consensus or discovery.
+type Message_Priority int32
+
+const (
+	Message_PRIORITY_SYNC        Message_Priority = 0
+	Message_PRIORITY_DISCOVERY   Message_Priority = 1
+	Message_PRIORITY_TRANSACTION Message_Priority = 2
+	Message_PRIORITY_CONSENSUS   Message_Priority = 3
+)
+
+var Message_Priority_name = map[int32]string{
+	0: "PRIORITY_SYNC",
+	1: "PRIORITY_DISCOVERY",
+	2: "PRIORITY_TRANSACTION",
+	3: "PRIORITY_CONSENSUS",
+}
+var Message_Priority_value = map[string]int32{
+	"PRIORITY_SYNC":        0,
+	"PRIORITY_DISCOVERY":   1,
+	"PRIORITY_TRANSACTION": 2,
+	"PRIORITY_CONSENSUS":   3,
+}
+
+func (x Message_Priority) String() string {
+	return proto.EnumName(Message_Priority_name, int32(x))
+}
+
 type Response_StatusCode int32
 
 const (
 	Response_UNDEFINED Response_StatusCode = 0
 	Response_SUCCESS   Response_StatusCode = 200
-	Response_FAILURE   Response_StatusCode = 500
+	// The validity window of the transaction passed before it was executed
+	Response_EXPIRED Response_StatusCode = 408
+	Response_FAILURE Response_StatusCode = 500
 )
 
 var Response_StatusCode_name = map[int32]string{
 	0:   "UNDEFINED",
 	200: "SUCCESS",
+	408: "EXPIRED",
 	500: "FAILURE",
 }
 var Response_StatusCode_value = map[string]int32{
 	"UNDEFINED": 0,
 	"SUCCESS":   200,
+	"EXPIRED":   408,
 	"FAILURE":   500,
 }
 
@@ -179,6 +282,22 @@ type Transaction struct {
 	ToValidators                   []byte                     `protobuf:"bytes,10,opt,name=toValidators,proto3" json:"toValidators,omitempty"`
 	Cert                           []byte                     `protobuf:"bytes,11,opt,name=cert,proto3" json:"cert,omitempty"`
 	Signature                      []byte                     `protobuf:"bytes,12,opt,name=signature,proto3" json:"signature,omitempty"`
+	// End of the validity window of the transaction, it is dropped rather
+	// than executed once the window has passed. Unset never expires.
+	Expiry *google_protobuf.Timestamp `protobuf:"bytes,13,opt,name=expiry" json:"expiry,omitempty"`
+	// Data delivered to the chaincode in ChaincodeInput.transient. It is
+	// removed before the transaction is stored in a block, so it never
+	// reaches the ledger or events. The signature covers it through
+	// transientHash, and it is encrypted along with the payload.
+	Transient map[string][]byte `protobuf:"bytes,14,rep,name=transient" json:"transient,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// Keys of the state of its chaincode the transaction declares it reads
+	// and writes. A query of a block executes alongside the invokes of its
+	// chaincode whose hints do not conflict with its own, a transaction
+	// without hints waits for the invokes before it.
+	KeyHints *StateKeyHints `protobuf:"bytes,15,opt,name=keyHints" json:"keyHints,omitempty"`
+	// Hash of the transient data salted with the nonce, which stays on the
+	// transaction once the transient data is removed.
+	TransientHash []byte `protobuf:"bytes,16,opt,name=transientHash,proto3" json:"transientHash,omitempty"`
 }
 
 func (m *Transaction) Reset()         { *m = Transaction{} }
@@ -192,6 +311,38 @@ func (m *Transaction) GetTimestamp() *google_protobuf.Timestamp {
 	return nil
 }
 
+func (m *Transaction) GetExpiry() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Expiry
+	}
+	return nil
+}
+
+func (m *Transaction) GetTransient() map[string][]byte {
+	if m != nil {
+		return m.Transient
+	}
+	return nil
+}
+
+func (m *Transaction) GetKeyHints() *StateKeyHints {
+	if m != nil {
+		return m.KeyHints
+	}
+	return nil
+}
+
+// StateKeyHints are the keys a transaction reads and writes, all of which it
+// must declare for its hints to be used.
+type StateKeyHints struct {
+	Reads  []string `protobuf:"bytes,1,rep,name=reads" json:"reads,omitempty"`
+	Writes []string `protobuf:"bytes,2,rep,name=writes" json:"writes,omitempty"`
+}
+
+func (m *StateKeyHints) Reset()         { *m = StateKeyHints{} }
+func (m *StateKeyHints) String() string { return proto.CompactTextString(m) }
+func (*StateKeyHints) ProtoMessage()    {}
+
 // TransactionBlock carries a batch of transactions.
 type TransactionBlock struct {
 	Transactions []*Transaction `protobuf:"bytes,1,rep,name=transactions" json:"transactions,omitempty"`
@@ -312,6 +463,39 @@ func (m *NonHashData) GetTransactionResults() []*TransactionResult {
 	return nil
 }
 
+// GenesisConfig is the network configuration every peer loads at first start
+// and commits to the state of block 0, so that all peers of a network begin
+// from the same verifiable configuration. Lists are kept sorted so the same
+// configuration always marshals to the same bytes.
+type GenesisConfig struct {
+	NetworkID        string           `protobuf:"bytes,1,opt,name=networkID" json:"networkID,omitempty"`
+	Validators       []string         `protobuf:"bytes,2,rep,name=validators" json:"validators,omitempty"`
+	Policies         []*GenesisPolicy `protobuf:"bytes,3,rep,name=policies" json:"policies,omitempty"`
+	Capabilities     []string         `protobuf:"bytes,4,rep,name=capabilities" json:"capabilities,omitempty"`
+	SystemChaincodes []string         `protobuf:"bytes,5,rep,name=systemChaincodes" json:"systemChaincodes,omitempty"`
+}
+
+func (m *GenesisConfig) Reset()         { *m = GenesisConfig{} }
+func (m *GenesisConfig) String() string { return proto.CompactTextString(m) }
+func (*GenesisConfig) ProtoMessage()    {}
+
+func (m *GenesisConfig) GetPolicies() []*GenesisPolicy {
+	if m != nil {
+		return m.Policies
+	}
+	return nil
+}
+
+// GenesisPolicy is a named policy rule of the genesis configuration.
+type GenesisPolicy struct {
+	Name string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	Rule string `protobuf:"bytes,2,opt,name=rule" json:"rule,omitempty"`
+}
+
+func (m *GenesisPolicy) Reset()         { *m = GenesisPolicy{} }
+func (m *GenesisPolicy) String() string { return proto.CompactTextString(m) }
+func (*GenesisPolicy) ProtoMessage()    {}
+
 type PeerAddress struct {
 	Host string `protobuf:"bytes,1,opt,name=host" json:"host,omitempty"`
 	Port int32  `protobuf:"varint,2,opt,name=port" json:"port,omitempty"`
@@ -334,6 +518,14 @@ type PeerEndpoint struct {
 	Address string            `protobuf:"bytes,2,opt,name=address" json:"address,omitempty"`
 	Type    PeerEndpoint_Type `protobuf:"varint,3,opt,name=type,enum=protos.PeerEndpoint_Type" json:"type,omitempty"`
 	PkiID   []byte            `protobuf:"bytes,4,opt,name=pkiID,proto3" json:"pkiID,omitempty"`
+	// Bit set of the Message.Compression algorithms this peer accepts,
+	// bit n set means the algorithm with value n is supported
+	Capabilities uint32 `protobuf:"varint,5,opt,name=capabilities" json:"capabilities,omitempty"`
+	// Set when the peer is a read-only follower, which serves queries but
+	// does not accept invokes
+	Follower       bool                `protobuf:"varint,6,opt,name=follower" json:"follower,omitempty"`
+	Metadata       *PeerMetadata       `protobuf:"bytes,7,opt,name=metadata" json:"metadata,omitempty"`
+	SignedMetadata *SignedPeerMetadata `protobuf:"bytes,8,opt,name=signedMetadata" json:"signedMetadata,omitempty"`
 }
 
 func (m *PeerEndpoint) Reset()         { *m = PeerEndpoint{} }
@@ -347,6 +539,73 @@ func (m *PeerEndpoint) GetID() *PeerID {
 	return nil
 }
 
+func (m *PeerEndpoint) GetMetadata() *PeerMetadata {
+	if m != nil {
+		return m.Metadata
+	}
+	return nil
+}
+
+func (m *PeerEndpoint) GetSignedMetadata() *SignedPeerMetadata {
+	if m != nil {
+		return m.SignedMetadata
+	}
+	return nil
+}
+
+// PeerMetadata describes what a peer supports, it is exchanged with the
+// PeerEndpoint during DISC_HELLO and DISC_PEERS so peers can be selected by
+// capability. The type of the peer is the PeerEndpoint type.
+// chaincodeRuntimes - The chaincode runtimes of the peer as TYPE:version,
+// for example GOLANG:1.6.
+// labels - Free form labels of the peer such as its region.
+// role - validator, nonvalidator or follower.
+// timestamp - When the peer created the metadata, the most recent metadata
+// of a peer replaces older copies relayed by other peers.
+type PeerMetadata struct {
+	ProtocolVersion   string                     `protobuf:"bytes,1,opt,name=protocolVersion" json:"protocolVersion,omitempty"`
+	MessageTypes      []Message_Type             `protobuf:"varint,2,rep,packed,name=messageTypes,enum=protos.Message_Type" json:"messageTypes,omitempty"`
+	ChaincodeRuntimes []string                   `protobuf:"bytes,3,rep,name=chaincodeRuntimes" json:"chaincodeRuntimes,omitempty"`
+	Labels            map[string]string          `protobuf:"bytes,4,rep,name=labels" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	SoftwareVersion   string                     `protobuf:"bytes,5,opt,name=softwareVersion" json:"softwareVersion,omitempty"`
+	LedgerHeight      uint64                     `protobuf:"varint,6,opt,name=ledgerHeight" json:"ledgerHeight,omitempty"`
+	Role              string                     `protobuf:"bytes,7,opt,name=role" json:"role,omitempty"`
+	Timestamp         *google_protobuf.Timestamp `protobuf:"bytes,8,opt,name=timestamp" json:"timestamp,omitempty"`
+	// Topics the peer subscribes to when the chat is established, see
+	// Message.SUB
+	Topics []string `protobuf:"bytes,9,rep,name=topics" json:"topics,omitempty"`
+}
+
+func (m *PeerMetadata) Reset()         { *m = PeerMetadata{} }
+func (m *PeerMetadata) String() string { return proto.CompactTextString(m) }
+func (*PeerMetadata) ProtoMessage()    {}
+
+func (m *PeerMetadata) GetLabels() map[string]string {
+	if m != nil {
+		return m.Labels
+	}
+	return nil
+}
+
+func (m *PeerMetadata) GetTimestamp() *google_protobuf.Timestamp {
+	if m != nil {
+		return m.Timestamp
+	}
+	return nil
+}
+
+// SignedPeerMetadata carries the marshalled PeerMetadata of a peer with the
+// signature of that peer, so that the metadata can be verified by any peer it
+// is relayed to. The signature is empty when security is disabled.
+type SignedPeerMetadata struct {
+	Metadata  []byte `protobuf:"bytes,1,opt,name=metadata,proto3" json:"metadata,omitempty"`
+	Signature []byte `protobuf:"bytes,2,opt,name=signature,proto3" json:"signature,omitempty"`
+}
+
+func (m *SignedPeerMetadata) Reset()         { *m = SignedPeerMetadata{} }
+func (m *SignedPeerMetadata) String() string { return proto.CompactTextString(m) }
+func (*SignedPeerMetadata) ProtoMessage()    {}
+
 type PeersMessage struct {
 	Peers []*PeerEndpoint `protobuf:"bytes,1,rep,name=peers" json:"peers,omitempty"`
 }
@@ -362,9 +621,19 @@ func (m *PeersMessage) GetPeers() []*PeerEndpoint {
 	return nil
 }
 
+// HelloMessage is the payload of Message.DISC_HELLO. The range of protocol
+// versions the sender speaks is given by minProtocolVersion and
+// protocolVersion, both sides of a stream use the highest version in common.
+// A sender that does not set them speaks version 1 only.
 type HelloMessage struct {
-	PeerEndpoint   *PeerEndpoint   `protobuf:"bytes,1,opt,name=peerEndpoint" json:"peerEndpoint,omitempty"`
-	BlockchainInfo *BlockchainInfo `protobuf:"bytes,2,opt,name=blockchainInfo" json:"blockchainInfo,omitempty"`
+	PeerEndpoint       *PeerEndpoint   `protobuf:"bytes,1,opt,name=peerEndpoint" json:"peerEndpoint,omitempty"`
+	BlockchainInfo     *BlockchainInfo `protobuf:"bytes,2,opt,name=blockchainInfo" json:"blockchainInfo,omitempty"`
+	ProtocolVersion    uint32          `protobuf:"varint,3,opt,name=protocolVersion" json:"protocolVersion,omitempty"`
+	MinProtocolVersion uint32          `protobuf:"varint,4,opt,name=minProtocolVersion" json:"minProtocolVersion,omitempty"`
+	// DER of the enrollment certificate of the peer and of the intermediate CA
+	// certificates it chains to, set when peer identities are verified
+	EnrollmentCert []byte   `protobuf:"bytes,5,opt,name=enrollmentCert,proto3" json:"enrollmentCert,omitempty"`
+	CertChain      [][]byte `protobuf:"bytes,6,rep,name=certChain,proto3" json:"certChain,omitempty"`
 }
 
 func (m *HelloMessage) Reset()         { *m = HelloMessage{} }
@@ -386,10 +655,18 @@ func (m *HelloMessage) GetBlockchainInfo() *BlockchainInfo {
 }
 
 type Message struct {
-	Type      Message_Type               `protobuf:"varint,1,opt,name=type,enum=protos.Message_Type" json:"type,omitempty"`
-	Timestamp *google_protobuf.Timestamp `protobuf:"bytes,2,opt,name=timestamp" json:"timestamp,omitempty"`
-	Payload   []byte                     `protobuf:"bytes,3,opt,name=payload,proto3" json:"payload,omitempty"`
-	Signature []byte                     `protobuf:"bytes,4,opt,name=signature,proto3" json:"signature,omitempty"`
+	Type        Message_Type               `protobuf:"varint,1,opt,name=type,enum=protos.Message_Type" json:"type,omitempty"`
+	Timestamp   *google_protobuf.Timestamp `protobuf:"bytes,2,opt,name=timestamp" json:"timestamp,omitempty"`
+	Payload     []byte                     `protobuf:"bytes,3,opt,name=payload,proto3" json:"payload,omitempty"`
+	Signature   []byte                     `protobuf:"bytes,4,opt,name=signature,proto3" json:"signature,omitempty"`
+	Compression Message_Compression        `protobuf:"varint,5,opt,name=compression,enum=protos.Message_Compression" json:"compression,omitempty"`
+	// set when the payload is an EncryptedPayload
+	Encrypted bool `protobuf:"varint,6,opt,name=encrypted" json:"encrypted,omitempty"`
+	// Span of the sender the message belongs to, see core/tracing
+	TraceContext *TraceContext `protobuf:"bytes,7,opt,name=traceContext" json:"traceContext,omitempty"`
+	// Logical channel of a request sent on an established chat, echoed by
+	// its RESPONSE, 0 for messages outside of a channel
+	Channel uint64 `protobuf:"varint,8,opt,name=channel" json:"channel,omitempty"`
 }
 
 func (m *Message) Reset()         { *m = Message{} }
@@ -403,6 +680,61 @@ func (m *Message) GetTimestamp() *google_protobuf.Timestamp {
 	return nil
 }
 
+// UnsupportedMessage is the payload of Message.UNSUPPORTED, type is the type
+// of the rejected message and protocolVersion the version negotiated for the
+// stream.
+type UnsupportedMessage struct {
+	Type            Message_Type `protobuf:"varint,1,opt,name=type,enum=protos.Message_Type" json:"type,omitempty"`
+	ProtocolVersion uint32       `protobuf:"varint,2,opt,name=protocolVersion" json:"protocolVersion,omitempty"`
+}
+
+func (m *UnsupportedMessage) Reset()         { *m = UnsupportedMessage{} }
+func (m *UnsupportedMessage) String() string { return proto.CompactTextString(m) }
+func (*UnsupportedMessage) ProtoMessage()    {}
+
+// TopicsMessage is the payload of Message.SUB and Message.UNSUB
+type TopicsMessage struct {
+	Topics []string `protobuf:"bytes,1,rep,name=topics" json:"topics,omitempty"`
+}
+
+func (m *TopicsMessage) Reset()         { *m = TopicsMessage{} }
+func (m *TopicsMessage) String() string { return proto.CompactTextString(m) }
+func (*TopicsMessage) ProtoMessage()    {}
+
+// PublishMessage is the payload of Message.PUBLISH
+type PublishMessage struct {
+	Topic   string `protobuf:"bytes,1,opt,name=topic" json:"topic,omitempty"`
+	Payload []byte `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
+}
+
+func (m *PublishMessage) Reset()         { *m = PublishMessage{} }
+func (m *PublishMessage) String() string { return proto.CompactTextString(m) }
+func (*PublishMessage) ProtoMessage()    {}
+
+// TransactionDigest is the payload of Message.TX_DIGEST, filter is a bloom
+// filter of the hashes of the pooled transactions tested with hashes bit
+// positions per hash.
+type TransactionDigest struct {
+	Filter []byte `protobuf:"bytes,1,opt,name=filter,proto3" json:"filter,omitempty"`
+	Hashes uint32 `protobuf:"varint,2,opt,name=hashes" json:"hashes,omitempty"`
+}
+
+func (m *TransactionDigest) Reset()         { *m = TransactionDigest{} }
+func (m *TransactionDigest) String() string { return proto.CompactTextString(m) }
+func (*TransactionDigest) ProtoMessage()    {}
+
+// Payload of discovery messages encrypted with a key derived from the network secret
+type EncryptedPayload struct {
+	// identifies the key used, so that keys can be rotated
+	KeyID      string `protobuf:"bytes,1,opt,name=keyID" json:"keyID,omitempty"`
+	Nonce      []byte `protobuf:"bytes,2,opt,name=nonce,proto3" json:"nonce,omitempty"`
+	Ciphertext []byte `protobuf:"bytes,3,opt,name=ciphertext,proto3" json:"ciphertext,omitempty"`
+}
+
+func (m *EncryptedPayload) Reset()         { *m = EncryptedPayload{} }
+func (m *EncryptedPayload) String() string { return proto.CompactTextString(m) }
+func (*EncryptedPayload) ProtoMessage()    {}
+
 type Response struct {
 	Status Response_StatusCode `protobuf:"varint,1,opt,name=status,enum=protos.Response_StatusCode" json:"status,omitempty"`
 	Msg    []byte              `protobuf:"bytes,2,opt,name=msg,proto3" json:"msg,omitempty"`
@@ -433,6 +765,26 @@ func (m *BlockState) GetBlock() *Block {
 	return nil
 }
 
+// BlockStateDelta is the payload of Message.STATE_DELTA. stateDelta is the
+// marshaled statemgmt.StateDelta of the block, the keys and values each
+// chaincode changed.
+type BlockStateDelta struct {
+	BlockNumber uint64 `protobuf:"varint,1,opt,name=blockNumber" json:"blockNumber,omitempty"`
+	Block       *Block `protobuf:"bytes,2,opt,name=block" json:"block,omitempty"`
+	StateDelta  []byte `protobuf:"bytes,3,opt,name=stateDelta,proto3" json:"stateDelta,omitempty"`
+}
+
+func (m *BlockStateDelta) Reset()         { *m = BlockStateDelta{} }
+func (m *BlockStateDelta) String() string { return proto.CompactTextString(m) }
+func (*BlockStateDelta) ProtoMessage()    {}
+
+func (m *BlockStateDelta) GetBlock() *Block {
+	if m != nil {
+		return m.Block
+	}
+	return nil
+}
+
 // SyncBlockRange is the payload of Message.SYNC_GET_BLOCKS, where
 // start and end indicate the starting and ending blocks inclusively. The order
 // in which blocks are returned is defined by the start and end values. For
@@ -448,7 +800,9 @@ func (m *SyncBlockRange) String() string { return proto.CompactTextString(m) }
 func (*SyncBlockRange) ProtoMessage()    {}
 
 // SyncBlocks is the payload of Message.SYNC_BLOCKS, where the range
-// indicates the blocks responded to the request SYNC_GET_BLOCKS
+// indicates the blocks responded to the request SYNC_GET_BLOCKS. A request
+// is answered in batches of consecutive blocks, a batch without blocks ends
+// the response early when the responder does not have the remaining blocks.
 type SyncBlocks struct {
 	Range  *SyncBlockRange `protobuf:"bytes,1,opt,name=range" json:"range,omitempty"`
 	Blocks []*Block        `protobuf:"bytes,2,rep,name=blocks" json:"blocks,omitempty"`
@@ -544,6 +898,8 @@ func init() {
 	proto.RegisterEnum("protos.Transaction_Type", Transaction_Type_name, Transaction_Type_value)
 	proto.RegisterEnum("protos.PeerEndpoint_Type", PeerEndpoint_Type_name, PeerEndpoint_Type_value)
 	proto.RegisterEnum("protos.Message_Type", Message_Type_name, Message_Type_value)
+	proto.RegisterEnum("protos.Message_Compression", Message_Compression_name, Message_Compression_value)
+	proto.RegisterEnum("protos.Message_Priority", Message_Priority_name, Message_Priority_value)
 	proto.RegisterEnum("protos.Response_StatusCode", Response_StatusCode_name, Response_StatusCode_value)
 }
 
@@ -567,6 +923,13 @@ func NewPeerClient(cc *grpc.ClientConn) PeerClient {
 	return &peerClient{cc}
 }
 
+func (m *Message) GetTraceContext() *TraceContext {
+	if m != nil {
+		return m.TraceContext
+	}
+	return nil
+}
+
 func (c *peerClient) Chat(ctx context.Context, opts ...grpc.CallOption) (Peer_ChatClient, error) {
 	stream, err := grpc.NewClientStream(ctx, &_Peer_serviceDesc.Streams[0], c.cc, "/protos.Peer/Chat", opts...)
 	if err != nil {
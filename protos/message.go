@@ -0,0 +1,37 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding copyright ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package protos
+
+// Priority returns the class of the message, derived from its type
+func (m *Message) Priority() Message_Priority {
+	switch m.Type {
+	case Message_CONSENSUS:
+		return Message_PRIORITY_CONSENSUS
+	case Message_CHAIN_TRANSACTION, Message_RESPONSE, Message_PUBLISH, Message_TX_POOL:
+		return Message_PRIORITY_TRANSACTION
+	case Message_SYNC_GET_BLOCKS, Message_SYNC_BLOCKS, Message_SYNC_BLOCK_ADDED,
+		Message_SYNC_STATE_GET_SNAPSHOT, Message_SYNC_STATE_SNAPSHOT,
+		Message_SYNC_STATE_GET_DELTAS, Message_SYNC_STATE_DELTAS, Message_STATE_DELTA:
+		return Message_PRIORITY_SYNC
+	default:
+		// Discovery, keepalive and the UNSUPPORTED replies to them
+		return Message_PRIORITY_DISCOVERY
+	}
+}
@@ -51,6 +51,107 @@ func (x ServerStatus_StatusCode) String() string {
 	return proto.EnumName(ServerStatus_StatusCode_name, int32(x))
 }
 
+type LeakedResource_Kind int32
+
+const (
+	LeakedResource_UUID     LeakedResource_Kind = 0
+	LeakedResource_NOTIFIER LeakedResource_Kind = 1
+	LeakedResource_ITERATOR LeakedResource_Kind = 2
+)
+
+var LeakedResource_Kind_name = map[int32]string{
+	0: "UUID",
+	1: "NOTIFIER",
+	2: "ITERATOR",
+}
+var LeakedResource_Kind_value = map[string]int32{
+	"UUID":     0,
+	"NOTIFIER": 1,
+	"ITERATOR": 2,
+}
+
+func (x LeakedResource_Kind) String() string {
+	return proto.EnumName(LeakedResource_Kind_name, int32(x))
+}
+
+type MeshDecision_Action int32
+
+const (
+	MeshDecision_DIAL      MeshDecision_Action = 0
+	MeshDecision_SKIP      MeshDecision_Action = 1
+	MeshDecision_EVICT     MeshDecision_Action = 2
+	MeshDecision_TRANSIENT MeshDecision_Action = 3
+	MeshDecision_REFUSE    MeshDecision_Action = 4
+)
+
+var MeshDecision_Action_name = map[int32]string{
+	0: "DIAL",
+	1: "SKIP",
+	2: "EVICT",
+	3: "TRANSIENT",
+	4: "REFUSE",
+}
+var MeshDecision_Action_value = map[string]int32{
+	"DIAL":      0,
+	"SKIP":      1,
+	"EVICT":     2,
+	"TRANSIENT": 3,
+	"REFUSE":    4,
+}
+
+func (x MeshDecision_Action) String() string {
+	return proto.EnumName(MeshDecision_Action_name, int32(x))
+}
+
+type PeerStanding_Level int32
+
+const (
+	PeerStanding_GOOD        PeerStanding_Level = 0
+	PeerStanding_GREYLISTED  PeerStanding_Level = 1
+	PeerStanding_BLACKLISTED PeerStanding_Level = 2
+)
+
+var PeerStanding_Level_name = map[int32]string{
+	0: "GOOD",
+	1: "GREYLISTED",
+	2: "BLACKLISTED",
+}
+var PeerStanding_Level_value = map[string]int32{
+	"GOOD":        0,
+	"GREYLISTED":  1,
+	"BLACKLISTED": 2,
+}
+
+func (x PeerStanding_Level) String() string {
+	return proto.EnumName(PeerStanding_Level_name, int32(x))
+}
+
+type TraceEntry_Kind int32
+
+const (
+	TraceEntry_RECEIVED   TraceEntry_Kind = 0
+	TraceEntry_SENT       TraceEntry_Kind = 1
+	TraceEntry_TRANSITION TraceEntry_Kind = 2
+	TraceEntry_STATE_OP   TraceEntry_Kind = 3
+)
+
+var TraceEntry_Kind_name = map[int32]string{
+	0: "RECEIVED",
+	1: "SENT",
+	2: "TRANSITION",
+	3: "STATE_OP",
+}
+var TraceEntry_Kind_value = map[string]int32{
+	"RECEIVED":   0,
+	"SENT":       1,
+	"TRANSITION": 2,
+	"STATE_OP":   3,
+}
+
+func (x TraceEntry_Kind) String() string {
+	return proto.EnumName(TraceEntry_Kind_name, int32(x))
+}
+
 type ServerStatus struct {
 	Status ServerStatus_StatusCode `protobuf:"varint,1,opt,name=status,enum=protos.ServerStatus_StatusCode" json:"status,omitempty"`
 }
@@ -59,8 +160,524 @@ func (m *ServerStatus) Reset()         { *m = ServerStatus{} }
 func (m *ServerStatus) String() string { return proto.CompactTextString(m) }
 func (*ServerStatus) ProtoMessage()    {}
 
+type LeakAuditRequest struct {
+	// report resources older than this
+	ThresholdSeconds int64 `protobuf:"varint,1,opt,name=thresholdSeconds" json:"thresholdSeconds,omitempty"`
+	// force-expire the reported resources
+	Expire bool `protobuf:"varint,2,opt,name=expire" json:"expire,omitempty"`
+}
+
+func (m *LeakAuditRequest) Reset()         { *m = LeakAuditRequest{} }
+func (m *LeakAuditRequest) String() string { return proto.CompactTextString(m) }
+func (*LeakAuditRequest) ProtoMessage()    {}
+
+type LeakedResource struct {
+	Kind        LeakedResource_Kind `protobuf:"varint,1,opt,name=kind,enum=protos.LeakedResource_Kind" json:"kind,omitempty"`
+	ChaincodeID string              `protobuf:"bytes,2,opt,name=chaincodeID" json:"chaincodeID,omitempty"`
+	Uuid        string              `protobuf:"bytes,3,opt,name=uuid" json:"uuid,omitempty"`
+	IteratorID  string              `protobuf:"bytes,4,opt,name=iteratorID" json:"iteratorID,omitempty"`
+	AgeSeconds  int64               `protobuf:"varint,5,opt,name=ageSeconds" json:"ageSeconds,omitempty"`
+	Expired     bool                `protobuf:"varint,6,opt,name=expired" json:"expired,omitempty"`
+}
+
+func (m *LeakedResource) Reset()         { *m = LeakedResource{} }
+func (m *LeakedResource) String() string { return proto.CompactTextString(m) }
+func (*LeakedResource) ProtoMessage()    {}
+
+type LeakAuditReport struct {
+	Resources []*LeakedResource `protobuf:"bytes,1,rep,name=resources" json:"resources,omitempty"`
+}
+
+func (m *LeakAuditReport) Reset()         { *m = LeakAuditReport{} }
+func (m *LeakAuditReport) String() string { return proto.CompactTextString(m) }
+func (*LeakAuditReport) ProtoMessage()    {}
+
+func (m *LeakAuditReport) GetResources() []*LeakedResource {
+	if m != nil {
+		return m.Resources
+	}
+	return nil
+}
+
+type TransactionTraceRequest struct {
+	Uuid string `protobuf:"bytes,1,opt,name=uuid" json:"uuid,omitempty"`
+	// number of trailing lines of container log to include, 0 for the default
+	LogTailLines int32 `protobuf:"varint,2,opt,name=logTailLines" json:"logTailLines,omitempty"`
+}
+
+func (m *TransactionTraceRequest) Reset()         { *m = TransactionTraceRequest{} }
+func (m *TransactionTraceRequest) String() string { return proto.CompactTextString(m) }
+func (*TransactionTraceRequest) ProtoMessage()    {}
+
+type TraceEntry struct {
+	Kind           TraceEntry_Kind `protobuf:"varint,1,opt,name=kind,enum=protos.TraceEntry_Kind" json:"kind,omitempty"`
+	TimestampNanos int64           `protobuf:"varint,2,opt,name=timestampNanos" json:"timestampNanos,omitempty"`
+	ChaincodeID    string          `protobuf:"bytes,3,opt,name=chaincodeID" json:"chaincodeID,omitempty"`
+	// message type, FSM event or state operation
+	Type string `protobuf:"bytes,4,opt,name=type" json:"type,omitempty"`
+	// FSM states, TRANSITION only
+	SrcState string `protobuf:"bytes,5,opt,name=srcState" json:"srcState,omitempty"`
+	DstState string `protobuf:"bytes,6,opt,name=dstState" json:"dstState,omitempty"`
+	// key of a state operation and its error, if any
+	Detail      string `protobuf:"bytes,7,opt,name=detail" json:"detail,omitempty"`
+	PayloadSize int32  `protobuf:"varint,8,opt,name=payloadSize" json:"payloadSize,omitempty"`
+}
+
+func (m *TraceEntry) Reset()         { *m = TraceEntry{} }
+func (m *TraceEntry) String() string { return proto.CompactTextString(m) }
+func (*TraceEntry) ProtoMessage()    {}
+
+type ContainerLog struct {
+	ChaincodeID string `protobuf:"bytes,1,opt,name=chaincodeID" json:"chaincodeID,omitempty"`
+	Log         []byte `protobuf:"bytes,2,opt,name=log,proto3" json:"log,omitempty"`
+	// set if the log could not be retrieved
+	Error string `protobuf:"bytes,3,opt,name=error" json:"error,omitempty"`
+}
+
+func (m *ContainerLog) Reset()         { *m = ContainerLog{} }
+func (m *ContainerLog) String() string { return proto.CompactTextString(m) }
+func (*ContainerLog) ProtoMessage()    {}
+
+type SupportBundle struct {
+	Uuid string `protobuf:"bytes,1,opt,name=uuid" json:"uuid,omitempty"`
+	// time between the first and the last entry
+	DurationNanos int64           `protobuf:"varint,2,opt,name=durationNanos" json:"durationNanos,omitempty"`
+	Entries       []*TraceEntry   `protobuf:"bytes,3,rep,name=entries" json:"entries,omitempty"`
+	ContainerLogs []*ContainerLog `protobuf:"bytes,4,rep,name=containerLogs" json:"containerLogs,omitempty"`
+}
+
+func (m *SupportBundle) Reset()         { *m = SupportBundle{} }
+func (m *SupportBundle) String() string { return proto.CompactTextString(m) }
+func (*SupportBundle) ProtoMessage()    {}
+
+func (m *SupportBundle) GetEntries() []*TraceEntry {
+	if m != nil {
+		return m.Entries
+	}
+	return nil
+}
+
+func (m *SupportBundle) GetContainerLogs() []*ContainerLog {
+	if m != nil {
+		return m.ContainerLogs
+	}
+	return nil
+}
+
+type PeerSupportBundleRequest struct {
+	// number of most recent log lines to include, 0 for all that are kept
+	LogLines int32 `protobuf:"varint,1,opt,name=logLines" json:"logLines,omitempty"`
+}
+
+func (m *PeerSupportBundleRequest) Reset()         { *m = PeerSupportBundleRequest{} }
+func (m *PeerSupportBundleRequest) String() string { return proto.CompactTextString(m) }
+func (*PeerSupportBundleRequest) ProtoMessage()    {}
+
+type PeerSupportBundle struct {
+	// suggested file name for the archive
+	Name string `protobuf:"bytes,1,opt,name=name" json:"name,omitempty"`
+	// gzipped tar archive with one file per section of the bundle
+	Archive []byte `protobuf:"bytes,2,opt,name=archive,proto3" json:"archive,omitempty"`
+}
+
+func (m *PeerSupportBundle) Reset()         { *m = PeerSupportBundle{} }
+func (m *PeerSupportBundle) String() string { return proto.CompactTextString(m) }
+func (*PeerSupportBundle) ProtoMessage()    {}
+
+type FSMTransitionsRequest struct {
+	// only return transitions of this handler kind, "chaincode" or "peer"
+	Handler string `protobuf:"bytes,1,opt,name=handler" json:"handler,omitempty"`
+	// only return transitions of this chaincode or remote peer
+	Name string `protobuf:"bytes,2,opt,name=name" json:"name,omitempty"`
+	// only return transitions for this transaction
+	Uuid string `protobuf:"bytes,3,opt,name=uuid" json:"uuid,omitempty"`
+}
+
+func (m *FSMTransitionsRequest) Reset()         { *m = FSMTransitionsRequest{} }
+func (m *FSMTransitionsRequest) String() string { return proto.CompactTextString(m) }
+func (*FSMTransitionsRequest) ProtoMessage()    {}
+
+type FSMTransition struct {
+	TimestampNanos int64 `protobuf:"varint,1,opt,name=timestampNanos" json:"timestampNanos,omitempty"`
+	// handler kind, "chaincode" or "peer"
+	Handler string `protobuf:"bytes,2,opt,name=handler" json:"handler,omitempty"`
+	// chaincode name or remote peer ID
+	Name     string `protobuf:"bytes,3,opt,name=name" json:"name,omitempty"`
+	Uuid     string `protobuf:"bytes,4,opt,name=uuid" json:"uuid,omitempty"`
+	Event    string `protobuf:"bytes,5,opt,name=event" json:"event,omitempty"`
+	SrcState string `protobuf:"bytes,6,opt,name=srcState" json:"srcState,omitempty"`
+	DstState string `protobuf:"bytes,7,opt,name=dstState" json:"dstState,omitempty"`
+	// set if the event was refused or failed
+	Error string `protobuf:"bytes,8,opt,name=error" json:"error,omitempty"`
+}
+
+func (m *FSMTransition) Reset()         { *m = FSMTransition{} }
+func (m *FSMTransition) String() string { return proto.CompactTextString(m) }
+func (*FSMTransition) ProtoMessage()    {}
+
+type FSMTransitions struct {
+	// oldest first
+	Transitions []*FSMTransition `protobuf:"bytes,1,rep,name=transitions" json:"transitions,omitempty"`
+}
+
+func (m *FSMTransitions) Reset()         { *m = FSMTransitions{} }
+func (m *FSMTransitions) String() string { return proto.CompactTextString(m) }
+func (*FSMTransitions) ProtoMessage()    {}
+
+func (m *FSMTransitions) GetTransitions() []*FSMTransition {
+	if m != nil {
+		return m.Transitions
+	}
+	return nil
+}
+
+type NetworkInventoryEntry struct {
+	// endpoint of the peer, its metadata is the most recent one verified
+	Endpoint *PeerEndpoint `protobuf:"bytes,1,opt,name=endpoint" json:"endpoint,omitempty"`
+	// set if this peer has a stream to the peer
+	Connected    bool  `protobuf:"varint,2,opt,name=connected" json:"connected,omitempty"`
+	UpdatedNanos int64 `protobuf:"varint,3,opt,name=updatedNanos" json:"updatedNanos,omitempty"`
+}
+
+func (m *NetworkInventoryEntry) Reset()         { *m = NetworkInventoryEntry{} }
+func (m *NetworkInventoryEntry) String() string { return proto.CompactTextString(m) }
+func (*NetworkInventoryEntry) ProtoMessage()    {}
+
+func (m *NetworkInventoryEntry) GetEndpoint() *PeerEndpoint {
+	if m != nil {
+		return m.Endpoint
+	}
+	return nil
+}
+
+type NetworkInventory struct {
+	// ordered by peer ID
+	Entries []*NetworkInventoryEntry `protobuf:"bytes,1,rep,name=entries" json:"entries,omitempty"`
+}
+
+func (m *NetworkInventory) Reset()         { *m = NetworkInventory{} }
+func (m *NetworkInventory) String() string { return proto.CompactTextString(m) }
+func (*NetworkInventory) ProtoMessage()    {}
+
+func (m *NetworkInventory) GetEntries() []*NetworkInventoryEntry {
+	if m != nil {
+		return m.Entries
+	}
+	return nil
+}
+
+type AbortTransactionRequest struct {
+	Uuid string `protobuf:"bytes,1,opt,name=uuid" json:"uuid,omitempty"`
+	// why the operator aborts the transaction, recorded in the audit log
+	Reason string `protobuf:"bytes,2,opt,name=reason" json:"reason,omitempty"`
+	// also stop the chaincode container, it is launched again by the next
+	// transaction for the chaincode
+	RestartChaincode bool `protobuf:"varint,3,opt,name=restartChaincode" json:"restartChaincode,omitempty"`
+}
+
+func (m *AbortTransactionRequest) Reset()         { *m = AbortTransactionRequest{} }
+func (m *AbortTransactionRequest) String() string { return proto.CompactTextString(m) }
+func (*AbortTransactionRequest) ProtoMessage()    {}
+
+type AbortTransactionResponse struct {
+	// name of the chaincode that was executing the transaction
+	ChaincodeID string `protobuf:"bytes,1,opt,name=chaincodeID" json:"chaincodeID,omitempty"`
+	Restarted   bool   `protobuf:"varint,2,opt,name=restarted" json:"restarted,omitempty"`
+}
+
+func (m *AbortTransactionResponse) Reset()         { *m = AbortTransactionResponse{} }
+func (m *AbortTransactionResponse) String() string { return proto.CompactTextString(m) }
+func (*AbortTransactionResponse) ProtoMessage()    {}
+
+type ChaincodeStateResidency struct {
+	State string `protobuf:"bytes,1,opt,name=state" json:"state,omitempty"`
+	// time spent in the state, including the current stay
+	TotalNanos int64 `protobuf:"varint,2,opt,name=totalNanos" json:"totalNanos,omitempty"`
+	// number of times the state was entered
+	Entries uint64 `protobuf:"varint,3,opt,name=entries" json:"entries,omitempty"`
+}
+
+func (m *ChaincodeStateResidency) Reset()         { *m = ChaincodeStateResidency{} }
+func (m *ChaincodeStateResidency) String() string { return proto.CompactTextString(m) }
+func (*ChaincodeStateResidency) ProtoMessage()    {}
+
+type ChaincodeHandlerMetrics struct {
+	ChaincodeID  string `protobuf:"bytes,1,opt,name=chaincodeID" json:"chaincodeID,omitempty"`
+	CurrentState string `protobuf:"bytes,2,opt,name=currentState" json:"currentState,omitempty"`
+	Transitions  uint64 `protobuf:"varint,3,opt,name=transitions" json:"transitions,omitempty"`
+	// ordered by state name
+	States []*ChaincodeStateResidency `protobuf:"bytes,4,rep,name=states" json:"states,omitempty"`
+	// transaction notifiers and state request UUIDs released by the stale
+	// sweeper after their transaction exceeded its deadline
+	StaleSwept uint64 `protobuf:"varint,5,opt,name=staleSwept" json:"staleSwept,omitempty"`
+}
+
+func (m *ChaincodeHandlerMetrics) Reset()         { *m = ChaincodeHandlerMetrics{} }
+func (m *ChaincodeHandlerMetrics) String() string { return proto.CompactTextString(m) }
+func (*ChaincodeHandlerMetrics) ProtoMessage()    {}
+
+func (m *ChaincodeHandlerMetrics) GetStates() []*ChaincodeStateResidency {
+	if m != nil {
+		return m.States
+	}
+	return nil
+}
+
+type ChaincodeMetrics struct {
+	// ordered by chaincode ID
+	Handlers []*ChaincodeHandlerMetrics `protobuf:"bytes,1,rep,name=handlers" json:"handlers,omitempty"`
+}
+
+func (m *ChaincodeMetrics) Reset()         { *m = ChaincodeMetrics{} }
+func (m *ChaincodeMetrics) String() string { return proto.CompactTextString(m) }
+func (*ChaincodeMetrics) ProtoMessage()    {}
+
+func (m *ChaincodeMetrics) GetHandlers() []*ChaincodeHandlerMetrics {
+	if m != nil {
+		return m.Handlers
+	}
+	return nil
+}
+
+type MeshPeerScore struct {
+	PeerID    *PeerID `protobuf:"bytes,1,opt,name=peerID" json:"peerID,omitempty"`
+	Address   string  `protobuf:"bytes,2,opt,name=address" json:"address,omitempty"`
+	Score     int64   `protobuf:"varint,3,opt,name=score" json:"score,omitempty"`
+	Validator bool    `protobuf:"varint,4,opt,name=validator" json:"validator,omitempty"`
+	// root nodes are anchors, they are never evicted
+	Anchor bool `protobuf:"varint,5,opt,name=anchor" json:"anchor,omitempty"`
+	// last keepalive round trip, 0 if not measured
+	LatencyNanos int64 `protobuf:"varint,6,opt,name=latencyNanos" json:"latencyNanos,omitempty"`
+	// opened on demand for state transfer, outside of the budget
+	Transient bool `protobuf:"varint,7,opt,name=transient" json:"transient,omitempty"`
+	// how long the peer has been connected to
+	ConnectedNanos int64 `protobuf:"varint,8,opt,name=connectedNanos" json:"connectedNanos,omitempty"`
+	// messages received from the peer, keepalive and discovery excluded
+	Messages uint64 `protobuf:"varint,9,opt,name=messages" json:"messages,omitempty"`
+}
+
+func (m *MeshPeerScore) Reset()         { *m = MeshPeerScore{} }
+func (m *MeshPeerScore) String() string { return proto.CompactTextString(m) }
+func (*MeshPeerScore) ProtoMessage()    {}
+
+func (m *MeshPeerScore) GetPeerID() *PeerID {
+	if m != nil {
+		return m.PeerID
+	}
+	return nil
+}
+
+type MeshDecision struct {
+	Action MeshDecision_Action `protobuf:"varint,1,opt,name=action,enum=protos.MeshDecision_Action" json:"action,omitempty"`
+	Peer   *MeshPeerScore      `protobuf:"bytes,2,opt,name=peer" json:"peer,omitempty"`
+	// score of the peer it was compared to, if any
+	ComparedScore  int64 `protobuf:"varint,3,opt,name=comparedScore" json:"comparedScore,omitempty"`
+	TimestampNanos int64 `protobuf:"varint,4,opt,name=timestampNanos" json:"timestampNanos,omitempty"`
+}
+
+func (m *MeshDecision) Reset()         { *m = MeshDecision{} }
+func (m *MeshDecision) String() string { return proto.CompactTextString(m) }
+func (*MeshDecision) ProtoMessage()    {}
+
+func (m *MeshDecision) GetPeer() *MeshPeerScore {
+	if m != nil {
+		return m.Peer
+	}
+	return nil
+}
+
+type MeshStatus struct {
+	// 0 is unlimited
+	MaxConnections int32 `protobuf:"varint,1,opt,name=maxConnections" json:"maxConnections,omitempty"`
+	// ordered by score, highest first
+	Connected []*MeshPeerScore `protobuf:"bytes,2,rep,name=connected" json:"connected,omitempty"`
+	// oldest first
+	Decisions []*MeshDecision `protobuf:"bytes,3,rep,name=decisions" json:"decisions,omitempty"`
+}
+
+func (m *MeshStatus) Reset()         { *m = MeshStatus{} }
+func (m *MeshStatus) String() string { return proto.CompactTextString(m) }
+func (*MeshStatus) ProtoMessage()    {}
+
+func (m *MeshStatus) GetConnected() []*MeshPeerScore {
+	if m != nil {
+		return m.Connected
+	}
+	return nil
+}
+
+func (m *MeshStatus) GetDecisions() []*MeshDecision {
+	if m != nil {
+		return m.Decisions
+	}
+	return nil
+}
+
+type PeerStanding struct {
+	PeerID  *PeerID            `protobuf:"bytes,1,opt,name=peerID" json:"peerID,omitempty"`
+	Address string             `protobuf:"bytes,2,opt,name=address" json:"address,omitempty"`
+	Level   PeerStanding_Level `protobuf:"varint,3,opt,name=level,enum=protos.PeerStanding_Level" json:"level,omitempty"`
+	// weighted violations since the peer was last in good standing
+	Score              int64  `protobuf:"varint,4,opt,name=score" json:"score,omitempty"`
+	BadSignatures      uint64 `protobuf:"varint,5,opt,name=badSignatures" json:"badSignatures,omitempty"`
+	MalformedPayloads  uint64 `protobuf:"varint,6,opt,name=malformedPayloads" json:"malformedPayloads,omitempty"`
+	InvalidMessages    uint64 `protobuf:"varint,7,opt,name=invalidMessages" json:"invalidMessages,omitempty"`
+	LastViolation      string `protobuf:"bytes,8,opt,name=lastViolation" json:"lastViolation,omitempty"`
+	LastViolationNanos int64  `protobuf:"varint,9,opt,name=lastViolationNanos" json:"lastViolationNanos,omitempty"`
+	// when the blacklisting ends, 0 if not blacklisted
+	UntilNanos int64 `protobuf:"varint,10,opt,name=untilNanos" json:"untilNanos,omitempty"`
+}
+
+func (m *PeerStanding) Reset()         { *m = PeerStanding{} }
+func (m *PeerStanding) String() string { return proto.CompactTextString(m) }
+func (*PeerStanding) ProtoMessage()    {}
+
+func (m *PeerStanding) GetPeerID() *PeerID {
+	if m != nil {
+		return m.PeerID
+	}
+	return nil
+}
+
+type PeerStandings struct {
+	// ordered by peer ID
+	Peers []*PeerStanding `protobuf:"bytes,1,rep,name=peers" json:"peers,omitempty"`
+}
+
+func (m *PeerStandings) Reset()         { *m = PeerStandings{} }
+func (m *PeerStandings) String() string { return proto.CompactTextString(m) }
+func (*PeerStandings) ProtoMessage()    {}
+
+func (m *PeerStandings) GetPeers() []*PeerStanding {
+	if m != nil {
+		return m.Peers
+	}
+	return nil
+}
+
+type ClearPeerStandingsRequest struct {
+	// all peers if not set
+	PeerID *PeerID `protobuf:"bytes,1,opt,name=peerID" json:"peerID,omitempty"`
+}
+
+func (m *ClearPeerStandingsRequest) Reset()         { *m = ClearPeerStandingsRequest{} }
+func (m *ClearPeerStandingsRequest) String() string { return proto.CompactTextString(m) }
+func (*ClearPeerStandingsRequest) ProtoMessage()    {}
+
+func (m *ClearPeerStandingsRequest) GetPeerID() *PeerID {
+	if m != nil {
+		return m.PeerID
+	}
+	return nil
+}
+
+type ExportStateRequest struct {
+	ChaincodeID string `protobuf:"bytes,1,opt,name=chaincodeID" json:"chaincodeID,omitempty"`
+	// maximum number of key/values per chunk, chaincode.stateExport.chunkSize
+	// if 0
+	ChunkSize int32 `protobuf:"varint,2,opt,name=chunkSize" json:"chunkSize,omitempty"`
+}
+
+func (m *ExportStateRequest) Reset()         { *m = ExportStateRequest{} }
+func (m *ExportStateRequest) String() string { return proto.CompactTextString(m) }
+func (*ExportStateRequest) ProtoMessage()    {}
+
+type StateKeyValue struct {
+	Key   string `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	Value []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+}
+
+func (m *StateKeyValue) Reset()         { *m = StateKeyValue{} }
+func (m *StateKeyValue) String() string { return proto.CompactTextString(m) }
+func (*StateKeyValue) ProtoMessage()    {}
+
+type StateChunk struct {
+	// namespace the key/values belong to, or are imported into
+	ChaincodeID string `protobuf:"bytes,1,opt,name=chaincodeID" json:"chaincodeID,omitempty"`
+	// ordered by key
+	KeyValues []*StateKeyValue `protobuf:"bytes,2,rep,name=keyValues" json:"keyValues,omitempty"`
+	// position of the chunk in the stream, from 0
+	Sequence uint64 `protobuf:"varint,3,opt,name=sequence" json:"sequence,omitempty"`
+}
+
+func (m *StateChunk) Reset()         { *m = StateChunk{} }
+func (m *StateChunk) String() string { return proto.CompactTextString(m) }
+func (*StateChunk) ProtoMessage()    {}
+
+func (m *StateChunk) GetKeyValues() []*StateKeyValue {
+	if m != nil {
+		return m.KeyValues
+	}
+	return nil
+}
+
+type ImportStateResponse struct {
+	Chunks uint64 `protobuf:"varint,1,opt,name=chunks" json:"chunks,omitempty"`
+	Keys   uint64 `protobuf:"varint,2,opt,name=keys" json:"keys,omitempty"`
+}
+
+func (m *ImportStateResponse) Reset()         { *m = ImportStateResponse{} }
+func (m *ImportStateResponse) String() string { return proto.CompactTextString(m) }
+func (*ImportStateResponse) ProtoMessage()    {}
+
+type PeerBandwidth struct {
+	PeerID  *PeerID `protobuf:"bytes,1,opt,name=peerID" json:"peerID,omitempty"`
+	Address string  `protobuf:"bytes,2,opt,name=address" json:"address,omitempty"`
+	// wire size of the messages since the chat started
+	BytesSent     uint64 `protobuf:"varint,3,opt,name=bytesSent" json:"bytesSent,omitempty"`
+	BytesReceived uint64 `protobuf:"varint,4,opt,name=bytesReceived" json:"bytesReceived,omitempty"`
+	// bytes per second, 0 if not capped
+	SendCap    uint64 `protobuf:"varint,5,opt,name=sendCap" json:"sendCap,omitempty"`
+	ReceiveCap uint64 `protobuf:"varint,6,opt,name=receiveCap" json:"receiveCap,omitempty"`
+	// time spent waiting for the caps to allow a message
+	SendThrottledNanos    int64 `protobuf:"varint,7,opt,name=sendThrottledNanos" json:"sendThrottledNanos,omitempty"`
+	ReceiveThrottledNanos int64 `protobuf:"varint,8,opt,name=receiveThrottledNanos" json:"receiveThrottledNanos,omitempty"`
+}
+
+func (m *PeerBandwidth) Reset()         { *m = PeerBandwidth{} }
+func (m *PeerBandwidth) String() string { return proto.CompactTextString(m) }
+func (*PeerBandwidth) ProtoMessage()    {}
+
+func (m *PeerBandwidth) GetPeerID() *PeerID {
+	if m != nil {
+		return m.PeerID
+	}
+	return nil
+}
+
+type PeerBandwidthStatus struct {
+	// ordered by peer ID
+	Peers []*PeerBandwidth `protobuf:"bytes,1,rep,name=peers" json:"peers,omitempty"`
+}
+
+func (m *PeerBandwidthStatus) Reset()         { *m = PeerBandwidthStatus{} }
+func (m *PeerBandwidthStatus) String() string { return proto.CompactTextString(m) }
+func (*PeerBandwidthStatus) ProtoMessage()    {}
+
+func (m *PeerBandwidthStatus) GetPeers() []*PeerBandwidth {
+	if m != nil {
+		return m.Peers
+	}
+	return nil
+}
+
+type LogLevel struct {
+	// empty for the default level of the modules not set
+	Module string `protobuf:"bytes,1,opt,name=module" json:"module,omitempty"`
+	// CRITICAL, ERROR, WARNING, NOTICE, INFO or DEBUG, ignored by GetLogLevel
+	Level string `protobuf:"bytes,2,opt,name=level" json:"level,omitempty"`
+}
+
+func (m *LogLevel) Reset()         { *m = LogLevel{} }
+func (m *LogLevel) String() string { return proto.CompactTextString(m) }
+func (*LogLevel) ProtoMessage()    {}
+
 func init() {
 	proto.RegisterEnum("protos.ServerStatus_StatusCode", ServerStatus_StatusCode_name, ServerStatus_StatusCode_value)
+	proto.RegisterEnum("protos.MeshDecision_Action", MeshDecision_Action_name, MeshDecision_Action_value)
+	proto.RegisterEnum("protos.PeerStanding_Level", PeerStanding_Level_name, PeerStanding_Level_value)
+	proto.RegisterEnum("protos.LeakedResource_Kind", LeakedResource_Kind_name, LeakedResource_Kind_value)
+	proto.RegisterEnum("protos.TraceEntry_Kind", TraceEntry_Kind_name, TraceEntry_Kind_value)
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -74,6 +691,47 @@ type AdminClient interface {
 	GetStatus(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*ServerStatus, error)
 	StartServer(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*ServerStatus, error)
 	StopServer(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*ServerStatus, error)
+	// Report (and optionally expire) chaincode resources that look leaked.
+	AuditLeaks(ctx context.Context, in *LeakAuditRequest, opts ...grpc.CallOption) (*LeakAuditReport, error)
+	// Collect the trace of a transaction and the logs of the chaincode
+	// containers it involved, for support escalation.
+	GetTransactionTrace(ctx context.Context, in *TransactionTraceRequest, opts ...grpc.CallOption) (*SupportBundle, error)
+	// Collect configuration, logs, runtime state and the peer and chaincode
+	// registries into one archive to attach to issue reports.
+	GetPeerSupportBundle(ctx context.Context, in *PeerSupportBundleRequest, opts ...grpc.CallOption) (*PeerSupportBundle, error)
+	// Return the recent FSM transitions of the chaincode and peer handlers.
+	GetFSMTransitions(ctx context.Context, in *FSMTransitionsRequest, opts ...grpc.CallOption) (*FSMTransitions, error)
+	// Return the peers of the network known through discovery with their
+	// latest verified metadata.
+	GetNetworkInventory(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*NetworkInventory, error)
+	// Last resort for a stuck transaction: abort it, failing its waiters
+	// with OPERATOR_ABORTED, and optionally restart its chaincode.
+	AbortTransaction(ctx context.Context, in *AbortTransactionRequest, opts ...grpc.CallOption) (*AbortTransactionResponse, error)
+	// Return the time the chaincode handlers spent in each FSM state.
+	GetChaincodeMetrics(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*ChaincodeMetrics, error)
+	// Return the scores of the connected peers and the recent decisions taken
+	// to keep the connections within peer.discovery.mesh.maxConnections.
+	GetMeshStatus(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*MeshStatus, error)
+	// Return the peers that recently violated the protocol and whether they
+	// are greylisted or blacklisted.
+	GetPeerStandings(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*PeerStandings, error)
+	// Forget the violations of a peer, or of all peers, restoring them to
+	// good standing.
+	ClearPeerStandings(ctx context.Context, in *ClearPeerStandingsRequest, opts ...grpc.CallOption) (*PeerStandings, error)
+	// Stream the committed key/values of a chaincode namespace in chunks,
+	// for backups and to clone or migrate it to another network.
+	ExportState(ctx context.Context, in *ExportStateRequest, opts ...grpc.CallOption) (Admin_ExportStateClient, error)
+	// Write the key/values of the streamed chunks to the state of their
+	// chaincode namespaces, each chunk atomically.
+	ImportState(ctx context.Context, opts ...grpc.CallOption) (Admin_ImportStateClient, error)
+	// Return the bytes exchanged with each connected peer and the time spent
+	// waiting on the peer.bandwidth caps.
+	GetPeerBandwidth(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*PeerBandwidthStatus, error)
+	// Return the level of a log module.
+	GetLogLevel(ctx context.Context, in *LogLevel, opts ...grpc.CallOption) (*LogLevel, error)
+	// Change the level of a log module at runtime, chaincode.<name> for the
+	// lines of the handler of a chaincode.
+	SetLogLevel(ctx context.Context, in *LogLevel, opts ...grpc.CallOption) (*LogLevel, error)
 }
 
 type adminClient struct {
@@ -111,6 +769,189 @@ func (c *adminClient) StopServer(ctx context.Context, in *google_protobuf1.Empty
 	return out, nil
 }
 
+func (c *adminClient) AuditLeaks(ctx context.Context, in *LeakAuditRequest, opts ...grpc.CallOption) (*LeakAuditReport, error) {
+	out := new(LeakAuditReport)
+	err := grpc.Invoke(ctx, "/protos.Admin/AuditLeaks", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetTransactionTrace(ctx context.Context, in *TransactionTraceRequest, opts ...grpc.CallOption) (*SupportBundle, error) {
+	out := new(SupportBundle)
+	err := grpc.Invoke(ctx, "/protos.Admin/GetTransactionTrace", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetPeerSupportBundle(ctx context.Context, in *PeerSupportBundleRequest, opts ...grpc.CallOption) (*PeerSupportBundle, error) {
+	out := new(PeerSupportBundle)
+	err := grpc.Invoke(ctx, "/protos.Admin/GetPeerSupportBundle", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetFSMTransitions(ctx context.Context, in *FSMTransitionsRequest, opts ...grpc.CallOption) (*FSMTransitions, error) {
+	out := new(FSMTransitions)
+	err := grpc.Invoke(ctx, "/protos.Admin/GetFSMTransitions", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetNetworkInventory(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*NetworkInventory, error) {
+	out := new(NetworkInventory)
+	err := grpc.Invoke(ctx, "/protos.Admin/GetNetworkInventory", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) AbortTransaction(ctx context.Context, in *AbortTransactionRequest, opts ...grpc.CallOption) (*AbortTransactionResponse, error) {
+	out := new(AbortTransactionResponse)
+	err := grpc.Invoke(ctx, "/protos.Admin/AbortTransaction", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetChaincodeMetrics(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*ChaincodeMetrics, error) {
+	out := new(ChaincodeMetrics)
+	err := grpc.Invoke(ctx, "/protos.Admin/GetChaincodeMetrics", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetMeshStatus(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*MeshStatus, error) {
+	out := new(MeshStatus)
+	err := grpc.Invoke(ctx, "/protos.Admin/GetMeshStatus", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetPeerStandings(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*PeerStandings, error) {
+	out := new(PeerStandings)
+	err := grpc.Invoke(ctx, "/protos.Admin/GetPeerStandings", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ClearPeerStandings(ctx context.Context, in *ClearPeerStandingsRequest, opts ...grpc.CallOption) (*PeerStandings, error) {
+	out := new(PeerStandings)
+	err := grpc.Invoke(ctx, "/protos.Admin/ClearPeerStandings", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ExportState(ctx context.Context, in *ExportStateRequest, opts ...grpc.CallOption) (Admin_ExportStateClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Admin_serviceDesc.Streams[0], c.cc, "/protos.Admin/ExportState", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &adminExportStateClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Admin_ExportStateClient interface {
+	Recv() (*StateChunk, error)
+	grpc.ClientStream
+}
+
+type adminExportStateClient struct {
+	grpc.ClientStream
+}
+
+func (x *adminExportStateClient) Recv() (*StateChunk, error) {
+	m := new(StateChunk)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *adminClient) ImportState(ctx context.Context, opts ...grpc.CallOption) (Admin_ImportStateClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Admin_serviceDesc.Streams[1], c.cc, "/protos.Admin/ImportState", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &adminImportStateClient{stream}
+	return x, nil
+}
+
+type Admin_ImportStateClient interface {
+	Send(*StateChunk) error
+	CloseAndRecv() (*ImportStateResponse, error)
+	grpc.ClientStream
+}
+
+type adminImportStateClient struct {
+	grpc.ClientStream
+}
+
+func (x *adminImportStateClient) Send(m *StateChunk) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *adminImportStateClient) CloseAndRecv() (*ImportStateResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(ImportStateResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *adminClient) GetPeerBandwidth(ctx context.Context, in *google_protobuf1.Empty, opts ...grpc.CallOption) (*PeerBandwidthStatus, error) {
+	out := new(PeerBandwidthStatus)
+	err := grpc.Invoke(ctx, "/protos.Admin/GetPeerBandwidth", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetLogLevel(ctx context.Context, in *LogLevel, opts ...grpc.CallOption) (*LogLevel, error) {
+	out := new(LogLevel)
+	err := grpc.Invoke(ctx, "/protos.Admin/GetLogLevel", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) SetLogLevel(ctx context.Context, in *LogLevel, opts ...grpc.CallOption) (*LogLevel, error) {
+	out := new(LogLevel)
+	err := grpc.Invoke(ctx, "/protos.Admin/SetLogLevel", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for Admin service
 
 type AdminServer interface {
@@ -118,6 +959,47 @@ type AdminServer interface {
 	GetStatus(context.Context, *google_protobuf1.Empty) (*ServerStatus, error)
 	StartServer(context.Context, *google_protobuf1.Empty) (*ServerStatus, error)
 	StopServer(context.Context, *google_protobuf1.Empty) (*ServerStatus, error)
+	// Report (and optionally expire) chaincode resources that look leaked.
+	AuditLeaks(context.Context, *LeakAuditRequest) (*LeakAuditReport, error)
+	// Collect the trace of a transaction and the logs of the chaincode
+	// containers it involved, for support escalation.
+	GetTransactionTrace(context.Context, *TransactionTraceRequest) (*SupportBundle, error)
+	// Collect configuration, logs, runtime state and the peer and chaincode
+	// registries into one archive to attach to issue reports.
+	GetPeerSupportBundle(context.Context, *PeerSupportBundleRequest) (*PeerSupportBundle, error)
+	// Return the recent FSM transitions of the chaincode and peer handlers.
+	GetFSMTransitions(context.Context, *FSMTransitionsRequest) (*FSMTransitions, error)
+	// Return the peers of the network known through discovery with their
+	// latest verified metadata.
+	GetNetworkInventory(context.Context, *google_protobuf1.Empty) (*NetworkInventory, error)
+	// Last resort for a stuck transaction: abort it, failing its waiters
+	// with OPERATOR_ABORTED, and optionally restart its chaincode.
+	AbortTransaction(context.Context, *AbortTransactionRequest) (*AbortTransactionResponse, error)
+	// Return the time the chaincode handlers spent in each FSM state.
+	GetChaincodeMetrics(context.Context, *google_protobuf1.Empty) (*ChaincodeMetrics, error)
+	// Return the scores of the connected peers and the recent decisions taken
+	// to keep the connections within peer.discovery.mesh.maxConnections.
+	GetMeshStatus(context.Context, *google_protobuf1.Empty) (*MeshStatus, error)
+	// Return the peers that recently violated the protocol and whether they
+	// are greylisted or blacklisted.
+	GetPeerStandings(context.Context, *google_protobuf1.Empty) (*PeerStandings, error)
+	// Forget the violations of a peer, or of all peers, restoring them to
+	// good standing.
+	ClearPeerStandings(context.Context, *ClearPeerStandingsRequest) (*PeerStandings, error)
+	// Stream the committed key/values of a chaincode namespace in chunks,
+	// for backups and to clone or migrate it to another network.
+	ExportState(*ExportStateRequest, Admin_ExportStateServer) error
+	// Write the key/values of the streamed chunks to the state of their
+	// chaincode namespaces, each chunk atomically.
+	ImportState(Admin_ImportStateServer) error
+	// Return the bytes exchanged with each connected peer and the time spent
+	// waiting on the peer.bandwidth caps.
+	GetPeerBandwidth(context.Context, *google_protobuf1.Empty) (*PeerBandwidthStatus, error)
+	// Return the level of a log module.
+	GetLogLevel(context.Context, *LogLevel) (*LogLevel, error)
+	// Change the level of a log module at runtime, chaincode.<name> for the
+	// lines of the handler of a chaincode.
+	SetLogLevel(context.Context, *LogLevel) (*LogLevel, error)
 }
 
 func RegisterAdminServer(s *grpc.Server, srv AdminServer) {
@@ -160,6 +1042,209 @@ func _Admin_StopServer_Handler(srv interface{}, ctx context.Context, dec func(in
 	return out, nil
 }
 
+func _Admin_AuditLeaks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(LeakAuditRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).AuditLeaks(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_GetTransactionTrace_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(TransactionTraceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).GetTransactionTrace(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_GetPeerSupportBundle_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(PeerSupportBundleRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).GetPeerSupportBundle(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_GetFSMTransitions_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(FSMTransitionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).GetFSMTransitions(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_GetNetworkInventory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(google_protobuf1.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).GetNetworkInventory(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_AbortTransaction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(AbortTransactionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).AbortTransaction(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_GetChaincodeMetrics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(google_protobuf1.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).GetChaincodeMetrics(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_GetMeshStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(google_protobuf1.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).GetMeshStatus(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_GetPeerStandings_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(google_protobuf1.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).GetPeerStandings(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_ClearPeerStandings_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(ClearPeerStandingsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).ClearPeerStandings(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_ExportState_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportStateRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AdminServer).ExportState(m, &adminExportStateServer{stream})
+}
+
+type Admin_ExportStateServer interface {
+	Send(*StateChunk) error
+	grpc.ServerStream
+}
+
+type adminExportStateServer struct {
+	grpc.ServerStream
+}
+
+func (x *adminExportStateServer) Send(m *StateChunk) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Admin_ImportState_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AdminServer).ImportState(&adminImportStateServer{stream})
+}
+
+type Admin_ImportStateServer interface {
+	SendAndClose(*ImportStateResponse) error
+	Recv() (*StateChunk, error)
+	grpc.ServerStream
+}
+
+type adminImportStateServer struct {
+	grpc.ServerStream
+}
+
+func (x *adminImportStateServer) SendAndClose(m *ImportStateResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *adminImportStateServer) Recv() (*StateChunk, error) {
+	m := new(StateChunk)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Admin_GetPeerBandwidth_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(google_protobuf1.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).GetPeerBandwidth(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_GetLogLevel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(LogLevel)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).GetLogLevel(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func _Admin_SetLogLevel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+	in := new(LogLevel)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	out, err := srv.(AdminServer).SetLogLevel(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 var _Admin_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "protos.Admin",
 	HandlerType: (*AdminServer)(nil),
@@ -176,6 +1261,69 @@ var _Admin_serviceDesc = grpc.ServiceDesc{
 			MethodName: "StopServer",
 			Handler:    _Admin_StopServer_Handler,
 		},
+		{
+			MethodName: "AuditLeaks",
+			Handler:    _Admin_AuditLeaks_Handler,
+		},
+		{
+			MethodName: "GetTransactionTrace",
+			Handler:    _Admin_GetTransactionTrace_Handler,
+		},
+		{
+			MethodName: "GetPeerSupportBundle",
+			Handler:    _Admin_GetPeerSupportBundle_Handler,
+		},
+		{
+			MethodName: "GetFSMTransitions",
+			Handler:    _Admin_GetFSMTransitions_Handler,
+		},
+		{
+			MethodName: "GetNetworkInventory",
+			Handler:    _Admin_GetNetworkInventory_Handler,
+		},
+		{
+			MethodName: "AbortTransaction",
+			Handler:    _Admin_AbortTransaction_Handler,
+		},
+		{
+			MethodName: "GetChaincodeMetrics",
+			Handler:    _Admin_GetChaincodeMetrics_Handler,
+		},
+		{
+			MethodName: "GetMeshStatus",
+			Handler:    _Admin_GetMeshStatus_Handler,
+		},
+		{
+			MethodName: "GetPeerStandings",
+			Handler:    _Admin_GetPeerStandings_Handler,
+		},
+		{
+			MethodName: "ClearPeerStandings",
+			Handler:    _Admin_ClearPeerStandings_Handler,
+		},
+		{
+			MethodName: "GetPeerBandwidth",
+			Handler:    _Admin_GetPeerBandwidth_Handler,
+		},
+		{
+			MethodName: "GetLogLevel",
+			Handler:    _Admin_GetLogLevel_Handler,
+		},
+		{
+			MethodName: "SetLogLevel",
+			Handler:    _Admin_SetLogLevel_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ExportState",
+			Handler:       _Admin_ExportState_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ImportState",
+			Handler:       _Admin_ImportState_Handler,
+			ClientStreams: true,
+		},
 	},
-	Streams: []grpc.StreamDesc{},
 }
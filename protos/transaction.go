@@ -20,9 +20,15 @@ under the License.
 package protos
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"sort"
+	"time"
+
 	"github.com/golang/protobuf/proto"
 	"github.com/hyperledger/fabric/core/util"
+	google_protobuf "google/protobuf"
 )
 
 // Bytes returns this transaction as an array of bytes.
@@ -106,10 +112,88 @@ func NewChaincodeExecute(chaincodeInvocationSpec *ChaincodeInvocationSpec, uuid
 		}
 		transaction.ChaincodeID = data
 	}
+	// The transient data travels outside of the payload, which is stored
+	transaction.Transient = chaincodeInvocationSpec.Transient
+	chaincodeInvocationSpec.Transient = nil
 	data, err := proto.Marshal(chaincodeInvocationSpec)
+	chaincodeInvocationSpec.Transient = transaction.Transient
 	if err != nil {
 		return nil, fmt.Errorf("Could not marshal payload for chaincode invocation: %s", err)
 	}
 	transaction.Payload = data
+	transaction.SetTransientHash()
+	if chaincodeInvocationSpec.ValiditySeconds > 0 {
+		expiry := time.Now().Add(time.Duration(chaincodeInvocationSpec.ValiditySeconds) * time.Second)
+		transaction.Expiry = &google_protobuf.Timestamp{Seconds: expiry.Unix(), Nanos: int32(expiry.Nanosecond())}
+	}
 	return transaction, nil
 }
+
+// SigningBytes returns the bytes of the transaction covered by its signature,
+// which leaves out the signature itself and the transient data. The transient
+// data is covered through the transient hash, see SetTransientHash.
+func (transaction *Transaction) SigningBytes() ([]byte, error) {
+	signature, transient := transaction.Signature, transaction.Transient
+	transaction.Signature, transaction.Transient = nil, nil
+	defer func() {
+		transaction.Signature, transaction.Transient = signature, transient
+	}()
+	return proto.Marshal(transaction)
+}
+
+// SetTransientHash binds the transient data to the transaction. It is to be
+// called once the nonce is set and before the transaction is encrypted or
+// signed.
+func (transaction *Transaction) SetTransientHash() {
+	transaction.TransientHash = transientHash(transaction.Transient, transaction.Nonce)
+}
+
+// CheckTransient returns an error if the transient data of the transaction is
+// not the one its transient hash was computed from
+func (transaction *Transaction) CheckTransient() error {
+	if !bytes.Equal(transientHash(transaction.Transient, transaction.Nonce), transaction.TransientHash) {
+		return fmt.Errorf("Transient data of transaction %s does not match its hash", transaction.Uuid)
+	}
+	return nil
+}
+
+// transientHash hashes the transient data, salted with the nonce, in the
+// order of its keys. Without transient data there is no hash.
+func transientHash(transient map[string][]byte, nonce []byte) []byte {
+	if len(transient) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(transient))
+	for k := range transient {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	writeLenPrefixed := func(b []byte) {
+		binary.Write(&buf, binary.BigEndian, uint32(len(b)))
+		buf.Write(b)
+	}
+	writeLenPrefixed(nonce)
+	for _, k := range keys {
+		writeLenPrefixed([]byte(k))
+		writeLenPrefixed(transient[k])
+	}
+	return util.ComputeCryptoHash(buf.Bytes())
+}
+
+// StripTransient removes the transient data of the transactions, to be done
+// before they are stored or published
+func StripTransient(transactions []*Transaction) {
+	for _, transaction := range transactions {
+		transaction.Transient = nil
+	}
+}
+
+// IsExpired returns true if the validity window of the transaction has passed
+// at time now. A transaction without expiry never expires.
+func (transaction *Transaction) IsExpired(now time.Time) bool {
+	if transaction.Expiry == nil {
+		return false
+	}
+	return now.After(time.Unix(transaction.Expiry.Seconds, int64(transaction.Expiry.Nanos)))
+}
@@ -20,7 +20,9 @@ under the License.
 package protos
 
 import (
+	"bytes"
 	"testing"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 )
@@ -49,3 +51,82 @@ func Test_Transaction_CreateNew(t *testing.T) {
 	}
 
 }
+
+func Test_Transaction_Expiry(t *testing.T) {
+	spec := &ChaincodeInvocationSpec{ChaincodeSpec: &ChaincodeSpec{ChaincodeID: &ChaincodeID{Name: "mycc"}}}
+	tx, err := NewChaincodeExecute(spec, "uuid", Transaction_CHAINCODE_INVOKE)
+	if err != nil {
+		t.Fatalf("Error creating transaction: %s", err)
+	}
+	if tx.Expiry != nil || tx.IsExpired(time.Now().Add(24*time.Hour)) {
+		t.Fatal("Expected a transaction without validity window never to expire")
+	}
+
+	spec.ValiditySeconds = 60
+	tx, err = NewChaincodeExecute(spec, "uuid", Transaction_CHAINCODE_INVOKE)
+	if err != nil {
+		t.Fatalf("Error creating transaction: %s", err)
+	}
+	if tx.IsExpired(time.Now()) {
+		t.Fatal("Expected the transaction to be valid within its window")
+	}
+	if !tx.IsExpired(time.Now().Add(61 * time.Second)) {
+		t.Fatal("Expected the transaction to expire after its window")
+	}
+}
+
+func Test_Transaction_Transient(t *testing.T) {
+	secret := []byte("secret-value")
+	spec := &ChaincodeInvocationSpec{
+		ChaincodeSpec: &ChaincodeSpec{ChaincodeID: &ChaincodeID{Name: "mycc"}},
+		Transient:     map[string][]byte{"key": secret},
+	}
+	tx, err := NewChaincodeExecute(spec, "uuid", Transaction_CHAINCODE_INVOKE)
+	if err != nil {
+		t.Fatalf("Error creating transaction: %s", err)
+	}
+	if !bytes.Equal(tx.Transient["key"], secret) {
+		t.Fatal("Expected the transient data to be carried by the transaction")
+	}
+	if bytes.Contains(tx.Payload, secret) {
+		t.Fatal("Expected the payload not to contain transient data")
+	}
+	if spec.Transient == nil {
+		t.Fatal("Expected the invocation spec to be left untouched")
+	}
+
+	raw, err := tx.SigningBytes()
+	if err != nil {
+		t.Fatalf("Error getting signing bytes: %s", err)
+	}
+	if bytes.Contains(raw, secret) {
+		t.Fatal("Expected the signing bytes not to contain transient data")
+	}
+	if tx.Transient == nil {
+		t.Fatal("Expected SigningBytes to leave the transaction untouched")
+	}
+	if tx.TransientHash == nil || !bytes.Contains(raw, tx.TransientHash) {
+		t.Fatal("Expected the signing bytes to cover the transient hash")
+	}
+	if err = tx.CheckTransient(); err != nil {
+		t.Fatalf("Expected the transient data to match its hash: %s", err)
+	}
+	tx.Transient["key"] = []byte("tampered")
+	if err = tx.CheckTransient(); err == nil {
+		t.Fatal("Expected tampered transient data to be detected")
+	}
+	tx.Transient["key"] = secret
+	tx.Nonce = []byte("nonce")
+	tx.SetTransientHash()
+	if err = tx.CheckTransient(); err != nil {
+		t.Fatalf("Expected the transient data to match its salted hash: %s", err)
+	}
+
+	StripTransient([]*Transaction{tx})
+	if tx.Transient != nil {
+		t.Fatal("Expected StripTransient to remove the transient data")
+	}
+	if tx.TransientHash == nil {
+		t.Fatal("Expected the transient hash to stay on the transaction")
+	}
+}